@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// shape is how the generated data is distributed and laid out
+type shape struct {
+	zipf       float64 // Zipf exponent (> 1) for countries and products; 0 is uniform
+	wide       int     // Extra attr_NNN columns
+	quotedPct  float64 // Percentage of notes with commas, quotes, and line breaks; 0 leaves out the note column
+	nullPct    float64 // Percentage of fields left empty, except order_id
+	sortedTime bool    // Timestamps increase with order_id
+}
+
+// dataset generates the rows of the e-commerce fixture
+type dataset struct {
+	shape shape
+	rng   *rand.Rand
+	// extra draws the timestamps and everything the shape adds, so the
+	// other columns are the same for a seed whatever the shape
+	extra     *rand.Rand
+	countries *rand.Zipf // nil when uniform
+	products  *rand.Zipf
+	header    []string
+	numeric   []bool // Columns holding numbers, written as such in JSON lines
+}
+
+var (
+	countries = []string{"UK", "US", "DE", "FR", "ES", "IT", "NL", "CA", "AU", "SE"}
+	statuses  = []string{"pending", "processing", "completed", "cancelled", "refunded"}
+	baseTime  = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// plainNotes and trickyNotes fill the note column: tricky ones need
+	// quoting, and some span lines
+	plainNotes  = []string{"", "", "gift", "leave at door", "express"}
+	trickyNotes = []string{
+		"leave at door, ring twice",
+		`customer said "fragile"`,
+		"line one\nline two",
+		"a, \"quoted\" and\r\nCRLF",
+		",,,",
+		`""`,
+		"trailing newline\n",
+	}
+
+	// attrWords fill the text columns of wide rows
+	attrWords = []string{"red", "green", "blue", "small", "large", "north", "south"}
+)
+
+const (
+	productCount = 20_000
+	userCount    = 200_000
+)
+
+func newDataset(s shape, seed int64) *dataset {
+	d := &dataset{shape: s, rng: rand.New(rand.NewSource(seed)), extra: rand.New(rand.NewSource(seed + 1))}
+	if s.zipf > 0 {
+		d.countries = rand.NewZipf(d.rng, s.zipf, 1, uint64(len(countries)-1))
+		d.products = rand.NewZipf(d.rng, s.zipf, 1, productCount-1)
+	}
+	d.header = []string{
+		"order_id",
+		"user_id",
+		"product_id",
+		"quantity",
+		"price_minor", // 4-digit minor units (1000 => £10.00)
+		"discount_minor",
+		"total_minor",
+		"status",
+		"country",
+		"created_at",
+	}
+	d.numeric = []bool{false, false, false, true, true, true, true, false, false, false}
+	if s.quotedPct > 0 {
+		d.header = append(d.header, "note")
+		d.numeric = append(d.numeric, false)
+	}
+	for i := 1; i <= s.wide; i++ {
+		d.header = append(d.header, fmt.Sprintf("attr_%03d", i))
+		d.numeric = append(d.numeric, i%3 != 1) // See row
+	}
+	return d
+}
+
+// row fills buf, which has a field per header column, with row i
+func (d *dataset) row(i int, buf []string) {
+	rng := d.rng
+	userID := rng.Intn(userCount) + 1
+	var product int
+	if d.products != nil {
+		product = int(d.products.Uint64())
+	} else {
+		product = rng.Intn(productCount)
+	}
+	quantity := rng.Intn(5) + 1
+	priceMinor := rng.Intn(9000) + 1000 // 4-digit price (1000 => £10.00)
+	discountMinor := 0
+	if rng.Float64() < 0.15 {
+		discountMinor = rng.Intn(priceMinor/5 + 1)
+	}
+	totalMinor := max(priceMinor*quantity-discountMinor, 0)
+	status := statuses[rng.Intn(len(statuses))]
+	var country string
+	if d.countries != nil {
+		country = countries[d.countries.Uint64()]
+	} else {
+		country = countries[rng.Intn(len(countries))]
+	}
+
+	var createdAt time.Time
+	if d.shape.sortedTime {
+		// Sequential timestamps: ~1 second per row for 1M rows = ~11 days
+		createdAt = baseTime.Add(time.Duration(i) * time.Second)
+	} else {
+		// Random timestamps across full year
+		createdAt = baseTime.Add(time.Duration(d.extra.Intn(365*24)) * time.Hour)
+	}
+
+	buf[0] = fmt.Sprintf("ORD%09d", i+1)
+	buf[1] = fmt.Sprintf("USR%06d", userID)
+	buf[2] = fmt.Sprintf("PRD%05d", product+1)
+	buf[3] = strconv.Itoa(quantity)
+	buf[4] = fmt.Sprintf("%04d", priceMinor)
+	buf[5] = strconv.Itoa(discountMinor)
+	buf[6] = strconv.Itoa(totalMinor)
+	buf[7] = status
+	buf[8] = country
+	buf[9] = createdAt.Format(time.RFC3339)
+	extra := d.extra
+	col := 10
+	if d.shape.quotedPct > 0 {
+		buf[col] = plainNotes[extra.Intn(len(plainNotes))]
+		if extra.Float64()*100 < d.shape.quotedPct {
+			buf[col] = trickyNotes[extra.Intn(len(trickyNotes))]
+		}
+		col++
+	}
+	for a := 1; a <= d.shape.wide; a++ {
+		switch a % 3 {
+		case 0:
+			buf[col] = strconv.Itoa(extra.Intn(100_000))
+		case 1:
+			buf[col] = attrWords[extra.Intn(len(attrWords))]
+		default:
+			buf[col] = strconv.FormatFloat(float64(extra.Intn(1_000_000))/100, 'f', 2, 64)
+		}
+		col++
+	}
+
+	if d.shape.nullPct > 0 {
+		for c := 1; c < len(buf); c++ {
+			if extra.Float64()*100 < d.shape.nullPct {
+				buf[c] = ""
+			}
+		}
+	}
+}
+
+// sortRows orders rows by column: empty values first, then integers by
+// value, then other values as strings, with ties in generation order
+func sortRows(rows [][]string, column int) {
+	type key struct {
+		rank int // 0 empty, 1 integer, 2 other
+		n    int64
+		s    string
+	}
+	keyOf := func(v string) key {
+		if v == "" {
+			return key{}
+		}
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return key{rank: 1, n: n}
+		}
+		return key{rank: 2, s: v}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := keyOf(rows[i][column]), keyOf(rows[j][column])
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.rank == 1 {
+			return a.n < b.n
+		}
+		return a.s < b.s
+	})
+}
@@ -1,133 +1,122 @@
 package main
 
 import (
-	"bufio"
-	"encoding/csv"
 	"flag"
 	"fmt"
-	"math/rand"
 	"os"
-	"path/filepath"
-	"time"
+	"slices"
+	"strings"
 )
 
 var (
 	rows    = flag.Int("rows", 1_000_000, "number of rows to generate")
-	outPath = flag.String("out", "fixtures/ecommerce_1m.csv", "output CSV path")
+	outPath = flag.String("out", "fixtures/ecommerce_1m.csv", "output path, or comma-separated paths all given the same rows: .csv, .tsv, or .jsonl, each optionally .gz")
 	seed    = flag.Int64("seed", 42, "random seed")
 	sorted  = flag.Bool("sorted", false, "generate with sorted timestamps (for testing .sidx)")
+
+	zipf    = flag.Float64("zipf", 0, "Zipf exponent (> 1) skewing countries and products toward a few values; 0 is uniform")
+	wide    = flag.Int("wide", 0, "add N extra attr_NNN columns (text, integers, and decimals) for wide rows")
+	quoted  = flag.Float64("quoted", 0, "add a note column, with this percentage of notes holding commas, quotes, and line breaks")
+	nulls   = flag.Float64("nulls", 0, "percentage of fields left empty (every column but order_id)")
+	sortBy  = flag.String("sort-by", "", "write rows sorted by this column (buffers all rows in memory)")
+	shuffle = flag.Bool("shuffle", false, "write rows in random order, so order_id is unsorted (buffers all rows in memory)")
 )
 
 func main() {
 	flag.Parse()
 
-	if err := os.MkdirAll(filepath.Dir(*outPath), 0o755); err != nil {
-		fmt.Fprintf(os.Stderr, "create output dir: %v\n", err)
-		os.Exit(1)
-	}
-
-	file, err := os.Create(*outPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "create file: %v\n", err)
-		os.Exit(1)
+	s := shape{zipf: *zipf, wide: *wide, quotedPct: *quoted, nullPct: *nulls, sortedTime: *sorted}
+	if err := checkFlags(s); err != nil {
+		fmt.Fprintf(os.Stderr, "gencsv: %v\n", err)
+		os.Exit(2)
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "close file: %v\n", err)
+	data := newDataset(s, *seed)
+	sortColumn := -1
+	if *sortBy != "" {
+		if sortColumn = slices.Index(data.header, *sortBy); sortColumn < 0 {
+			fmt.Fprintf(os.Stderr, "gencsv: -sort-by %q: no such column (columns: %v)\n", *sortBy, data.header)
+			os.Exit(2)
 		}
-	}()
-
-	writer := csv.NewWriter(bufio.NewWriterSize(file, 1<<20))
-	defer func() {
-		writer.Flush()
-	}()
+	}
 
-	header := []string{
-		"order_id",
-		"user_id",
-		"product_id",
-		"quantity",
-		"price_minor", // 4-digit minor units (1000 => £10.00)
-		"discount_minor",
-		"total_minor",
-		"status",
-		"country",
-		"created_at",
+	// Every output gets the same rows, each in the format its name says
+	paths := strings.Split(*outPath, ",")
+	var sinks []*sink
+	for _, path := range paths {
+		out, err := openSink(strings.TrimSpace(path), data.header, data.numeric)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gencsv: %v\n", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, out)
 	}
-	if err := writer.Write(header); err != nil {
-		fmt.Fprintf(os.Stderr, "write header: %v\n", err)
-		os.Exit(1)
+	write := func(row []string) {
+		for _, out := range sinks {
+			if err := out.write(row); err != nil {
+				fmt.Fprintf(os.Stderr, "write row: %v\n", err)
+				os.Exit(1)
+			}
+		}
 	}
 
-	rng := rand.New(rand.NewSource(*seed))
-
-	countries := []string{"UK", "US", "DE", "FR", "ES", "IT", "NL", "CA", "AU", "SE"}
-	statuses := []string{"pending", "processing", "completed", "cancelled", "refunded"}
-
-	baseTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
-
-	buf := make([]string, len(header))
+	// Sorted and shuffled output hold every row until the last is generated
+	var held [][]string
+	buf := make([]string, len(data.header))
 
 	for i := 0; i < *rows; i++ {
-		orderID := fmt.Sprintf("ORD%09d", i+1)
-		userID := fmt.Sprintf("USR%06d", rng.Intn(200_000)+1)
-		productID := fmt.Sprintf("PRD%05d", rng.Intn(20_000)+1)
-		quantity := rng.Intn(5) + 1
-		priceMinor := rng.Intn(9000) + 1000 // 4-digit price (1000 => £10.00)
-		discountMinor := 0
-		if rng.Float64() < 0.15 {
-			discountMinor = rng.Intn(priceMinor/5 + 1)
-		}
-		subtotal := priceMinor * quantity
-		totalMinor := subtotal - discountMinor
-		if totalMinor < 0 {
-			totalMinor = 0
+		data.row(i, buf)
+		if sortColumn >= 0 || *shuffle {
+			held = append(held, slices.Clone(buf))
+			continue
 		}
-		status := statuses[rng.Intn(len(statuses))]
-		country := countries[rng.Intn(len(countries))]
+		write(buf)
 
-		// Generate timestamp
-		var createdAt string
-		if *sorted {
-			// Sequential timestamps: ~1 second per row for 1M rows = ~11 days
-			createdAt = baseTime.Add(time.Duration(i) * time.Second).Format(time.RFC3339)
-		} else {
-			// Random timestamps across full year
-			createdAt = baseTime.Add(time.Duration(rand.Intn(365*24)) * time.Hour).Format(time.RFC3339)
+		if (i+1)%100_000 == 0 {
+			for _, out := range sinks {
+				if err := out.flush(); err != nil {
+					fmt.Fprintf(os.Stderr, "flush rows: %v\n", err)
+					os.Exit(1)
+				}
+			}
 		}
+	}
 
-		buf[0] = orderID
-		buf[1] = userID
-		buf[2] = productID
-		buf[3] = fmt.Sprintf("%d", quantity)
-		buf[4] = fmt.Sprintf("%04d", priceMinor)
-		buf[5] = fmt.Sprintf("%d", discountMinor)
-		buf[6] = fmt.Sprintf("%d", totalMinor)
-		buf[7] = status
-		buf[8] = country
-		buf[9] = createdAt
+	if *shuffle {
+		data.rng.Shuffle(len(held), func(i, j int) { held[i], held[j] = held[j], held[i] })
+	} else if sortColumn >= 0 {
+		sortRows(held, sortColumn)
+	}
+	for _, row := range held {
+		write(row)
+	}
 
-		if err := writer.Write(buf); err != nil {
-			fmt.Fprintf(os.Stderr, "write row %d: %v\n", i, err)
+	for _, out := range sinks {
+		if err := out.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "final flush: %v\n", err)
 			os.Exit(1)
 		}
-
-		if (i+1)%100_000 == 0 {
-			writer.Flush()
-			if err := writer.Error(); err != nil {
-				fmt.Fprintf(os.Stderr, "flush rows: %v\n", err)
-				os.Exit(1)
-			}
-		}
 	}
 
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		fmt.Fprintf(os.Stderr, "final flush: %v\n", err)
-		os.Exit(1)
+	if _, err := fmt.Fprintf(os.Stdout, "wrote %d rows to %s\n", *rows, strings.Join(paths, ", ")); err != nil {
+		fmt.Fprintf(os.Stderr, "write message: %v\n", err)
 	}
+}
 
-	if _, err := fmt.Fprintf(os.Stdout, "wrote %d rows to %s\n", *rows, *outPath); err != nil {
-		fmt.Fprintf(os.Stderr, "write message: %v\n", err)
+// checkFlags rejects shapes the generator can't produce
+func checkFlags(s shape) error {
+	switch {
+	case *rows < 0:
+		return fmt.Errorf("-rows must not be negative")
+	case s.zipf != 0 && s.zipf <= 1:
+		return fmt.Errorf("-zipf %g: the exponent must be greater than 1 (or 0 for uniform)", s.zipf)
+	case s.wide < 0:
+		return fmt.Errorf("-wide must not be negative")
+	case s.quotedPct < 0 || s.quotedPct > 100:
+		return fmt.Errorf("-quoted %g: want a percentage from 0 to 100", s.quotedPct)
+	case s.nullPct < 0 || s.nullPct > 100:
+		return fmt.Errorf("-nulls %g: want a percentage from 0 to 100", s.nullPct)
+	case *sortBy != "" && *shuffle:
+		return fmt.Errorf("-sort-by cannot be combined with -shuffle")
 	}
+	return nil
 }
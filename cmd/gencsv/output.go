@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sink writes generated rows to one output file in the format its name
+// ends with: .csv, .tsv, or .jsonl, gzip-compressed when followed by .gz.
+// Every format holds the same values: TSV quotes fields as CSV does, and
+// JSON lines write numeric columns as numbers and empty fields as null.
+type sink struct {
+	file    *os.File
+	gz      *gzip.Writer // nil unless compressing
+	buf     *bufio.Writer
+	csv     *csv.Writer // nil for JSON lines
+	header  []string
+	numeric []bool
+	line    []byte
+}
+
+// outputFormat returns the field separator of path's format, or 0 for
+// JSON lines
+func outputFormat(path string) (rune, error) {
+	name := strings.ToLower(filepath.Base(path))
+	name = strings.TrimSuffix(name, ".gz")
+	switch filepath.Ext(name) {
+	case ".csv":
+		return ',', nil
+	case ".tsv":
+		return '\t', nil
+	case ".jsonl":
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%s: want a .csv, .tsv, or .jsonl name, optionally ending in .gz", path)
+}
+
+func openSink(path string, header []string, numeric []bool) (*sink, error) {
+	comma, err := outputFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create output dir: %w", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create file: %w", err)
+	}
+	s := &sink{file: f, header: header, numeric: numeric}
+	var w io.Writer = f
+	if strings.EqualFold(filepath.Ext(path), ".gz") {
+		s.gz = gzip.NewWriter(f)
+		w = s.gz
+	}
+	s.buf = bufio.NewWriterSize(w, 1<<20)
+	if comma != 0 {
+		s.csv = csv.NewWriter(s.buf)
+		s.csv.Comma = comma
+		if err := s.csv.Write(header); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("write header: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *sink) write(row []string) error {
+	if s.csv != nil {
+		return s.csv.Write(row)
+	}
+	s.line = append(s.line[:0], '{')
+	for i, value := range row {
+		if i > 0 {
+			s.line = append(s.line, ',')
+		}
+		key, _ := json.Marshal(s.header[i])
+		s.line = append(append(s.line, key...), ':')
+		switch {
+		case value == "":
+			s.line = append(s.line, "null"...)
+		case s.numeric[i]:
+			s.line = append(s.line, value...)
+		default:
+			quoted, _ := json.Marshal(value)
+			s.line = append(s.line, quoted...)
+		}
+	}
+	s.line = append(s.line, '}', '\n')
+	_, err := s.buf.Write(s.line)
+	return err
+}
+
+// flush writes buffered rows through to the file
+func (s *sink) flush() error {
+	if s.csv != nil {
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return s.buf.Flush()
+}
+
+// close flushes the rows and finishes the file
+func (s *sink) close() error {
+	err := s.flush()
+	if s.gz != nil {
+		if cerr := s.gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/melihbirim/sieswi/internal/engine"
+	"github.com/melihbirim/sieswi/internal/resultcache"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// executeCached runs a query through the --cache-dir result cache when it
+// has one and the query is cacheable: a stored result is copied to out, and
+// otherwise the result is written to out and stored as it's produced.
+func executeCached(query sqlparser.Query, out io.Writer, stats *engine.Stats, opts queryOptions) error {
+	key, ok := cacheKey(opts.cache, query)
+	if !ok {
+		return engine.ExecuteWithStats(query, out, stats)
+	}
+	if result, ok := opts.cache.Get(key); ok {
+		defer result.Close()
+		if _, err := io.Copy(out, result); err != nil {
+			return fmt.Errorf("read cached result: %w", err)
+		}
+		return nil
+	}
+
+	entry, err := opts.cache.Put(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache: %v\n", err)
+		return engine.ExecuteWithStats(query, out, stats)
+	}
+	if err := engine.ExecuteWithStats(query, io.MultiWriter(out, entry), stats); err != nil {
+		entry.Abort()
+		return err
+	}
+	if err := entry.Commit(); err != nil {
+		fmt.Fprintf(os.Stderr, "cache: %v\n", err)
+	}
+	return nil
+}
+
+// cacheKey returns the cache key of a query, or false when its result
+// can't be reused: it reads a stream, draws an unseeded sample, or reports
+// dropped rows, which a cached copy wouldn't
+func cacheKey(cache *resultcache.Cache, query sqlparser.Query) (string, bool) {
+	if cache == nil || query.CreateTable || query.RejectPath != "" {
+		return "", false
+	}
+	if query.OnError != "" && query.OnError != sqlparser.OnErrorStrict {
+		return "", false
+	}
+	if query.Sample != nil && !query.Sample.Repeatable {
+		return "", false
+	}
+
+	queries := []*sqlparser.Query{&query}
+	queries = append(queries, sqlparser.Subqueries(query.Where)...)
+	for _, filter := range query.Filters {
+		queries = append(queries, sqlparser.Subqueries(filter)...)
+	}
+	var files []string
+	for _, q := range queries {
+		if info, err := os.Stat(q.FilePath); err != nil || !info.Mode().IsRegular() {
+			return "", false // Stdin and pipes can't be keyed by content
+		}
+		files = append(files, q.FilePath)
+	}
+
+	// The parsed query, with every option already applied to it, is the
+	// normalized statement: spelling and whitespace don't matter, and the
+	// file it's written to doesn't change the result
+	query.OutputPath = ""
+	statement, err := json.Marshal(query)
+	if err != nil {
+		return "", false
+	}
+	key, err := cache.Key(buildVersion()+"\n"+string(statement), files)
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
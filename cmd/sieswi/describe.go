@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/melihbirim/sieswi/internal/sidx"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+const describeUsage = "usage: sieswi describe <csvfile>"
+
+// maxDescribeValue caps how much of a min/max value is printed
+const maxDescribeValue = 32
+
+// runDescribeCommand implements `sieswi describe` and returns the process exit code.
+func runDescribeCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, describeUsage)
+		return 1
+	}
+	if err := describe(sqlparser.ExpandPath(args[0]), os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "describe error:", err)
+		return 1
+	}
+	return 0
+}
+
+// describe prints the schema and column statistics of a CSV file. A valid
+// .sidx is read as is, so the output shows exactly what queries prune with;
+// otherwise the same statistics are gathered with an in-memory build.
+func describe(csvPath string, out io.Writer) error {
+	index, release, err := sidx.DefaultCache.Acquire(csvPath)
+	defer release()
+	source := csvPath + ".sidx"
+	if err != nil || index == nil {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ignoring index: %v\n", err)
+		}
+		index, err = sidx.NewParallelBuilder(sidx.BlockSize, 0).BuildFromFile(csvPath)
+		if err != nil {
+			return fmt.Errorf("scan %s: %w", csvPath, err)
+		}
+		source = "full scan (no index)"
+	}
+
+	h := &index.Header
+	fmt.Fprintf(out, "File:    %s\n", csvPath)
+	fmt.Fprintf(out, "Source:  %s\n", source)
+	if !h.Dialect.IsDefault() {
+		fmt.Fprintf(out, "Dialect: %s\n", h.Dialect)
+	}
+	fmt.Fprintf(out, "Rows:    %d\n", h.TotalRows)
+	fmt.Fprintf(out, "Columns: %d\n\n", len(h.Columns))
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tCOLUMN\tTYPE\tNULL%\tINVALID\tMIN\tMAX\tDISTINCT")
+	for i, col := range h.Columns {
+		sum := index.Summarize(i)
+		nullPct := 0.0
+		if h.TotalRows > 0 {
+			nullPct = 100 * float64(sum.Empty) / float64(h.TotalRows)
+		}
+		colType := col.Type.String()
+		if col.Mixed {
+			colType += " (mixed)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%.1f\t%d\t%s\t%s\t~%d\n",
+			col.Ordinal, col.Name, colType, nullPct, sum.Invalid,
+			truncateValue(sum.Min), truncateValue(sum.Max), col.Distinct)
+	}
+	return tw.Flush()
+}
+
+// truncateValue shortens s to maxDescribeValue runes for table output
+func truncateValue(s string) string {
+	if utf8.RuneCountInString(s) <= maxDescribeValue {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:maxDescribeValue-1]) + "…"
+}
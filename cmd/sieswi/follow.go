@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/melihbirim/sieswi/internal/engine"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// followQuery streams the rows appended to the query's file until
+// interrupted. Each batch of matches is flushed as it's found, so the
+// output can feed another command; an interrupt ends the query normally,
+// closing the output and printing stats.
+func followQuery(query sqlparser.Query, writer *output, stats *engine.Stats, fromStart bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return engine.Follow(query, writer, stats, engine.FollowOptions{
+		FromStart: fromStart,
+		Stop:      ctx.Done(),
+		Flush:     writer.Flush,
+	})
+}
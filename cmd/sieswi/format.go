@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// formatFlags holds --precision, --output-thousands, --minor-units, and
+// --null-token as given
+type formatFlags struct {
+	precision  string
+	thousands  string
+	minorUnits string
+	null       string
+}
+
+// apply checks the flags and returns how aggregate results are rendered
+func (f formatFlags) apply() (sqlparser.OutputFormat, error) {
+	o := sqlparser.OutputFormat{Null: f.null}
+	if f.precision != "" {
+		n, err := strconv.Atoi(f.precision)
+		if err != nil || n < 0 || n > 18 {
+			return o, fmt.Errorf("--precision %q: want a number of digits from 0 to 18", f.precision)
+		}
+		o.Precision, o.HasPrecision = n, true
+	}
+	if f.thousands != "" {
+		if len(f.thousands) != 1 || f.thousands[0] >= '0' && f.thousands[0] <= '9' || f.thousands[0] == '-' {
+			return o, fmt.Errorf("--output-thousands %q: want a single separator such as , . or '", f.thousands)
+		}
+		o.Thousands = f.thousands[0]
+	}
+	if f.minorUnits != "" {
+		units, err := sqlparser.ParseMinorUnits(f.minorUnits)
+		if err != nil {
+			return o, fmt.Errorf("--minor-units: %w", err)
+		}
+		o.MinorUnits = units
+	}
+	return o, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/golden from the runs without an index")
+
+// goldenCase is one run of the binary, whose output must match
+// testdata/golden/<name>.out byte for byte whichever way the input is read.
+// In args, $ORDERS is 'orders.csv', a copy of testdata/orders.csv, or '-'
+// when the copy is piped to stdin; customers.csv is always read as a file.
+type goldenCase struct {
+	name string
+	args []string
+}
+
+var goldenCases = []goldenCase{
+	{name: "select_star_limit", args: []string{"SELECT * FROM $ORDERS LIMIT 12"}},
+	{name: "limit_zero", args: []string{"SELECT id FROM $ORDERS LIMIT 0"}},
+	{name: "projection_alias", args: []string{"SELECT id AS order_id, country, total FROM $ORDERS WHERE country = 'UK' LIMIT 20"}},
+	{name: "range_pruned", args: []string{"SELECT id, order_date, total FROM $ORDERS WHERE id >= 200 AND id < 215"}},
+	{name: "date_range", args: []string{"SELECT id, order_date FROM $ORDERS WHERE order_date >= '2024-04-01' AND order_date < '2024-04-04'"}},
+	{name: "boolean_logic", args: []string{"SELECT id, country, status, qty FROM $ORDERS WHERE (country = 'DE' OR country = 'FR') AND NOT status = 'refunded' AND qty > 6"}},
+	{name: "in_list", args: []string{"SELECT id, country FROM $ORDERS WHERE country IN ('JP', 'US') AND id > 350"}},
+	{name: "not_in_list", args: []string{"SELECT id, country FROM $ORDERS WHERE country NOT IN ('UK', 'US', 'DE', 'FR') AND id < 60"}},
+	{name: "ilike", args: []string{"SELECT id, note FROM $ORDERS WHERE note ILIKE 'RUSH%' AND id < 100"}},
+	{name: "empty_values", args: []string{"SELECT id, country, price FROM $ORDERS WHERE price = '' OR country = ''"}},
+	{name: "column_comparison", args: []string{"SELECT id, qty, customer_id FROM $ORDERS WHERE qty > customer_id"}},
+	{name: "computed_columns", args: []string{"SELECT id, qty * price AS gross, ROUND(price / 3, 1) AS third FROM $ORDERS WHERE qty * price > 2000"}},
+	{name: "cast_decimal", args: []string{"SELECT id, total FROM $ORDERS WHERE CAST(total AS DECIMAL) >= 2000.5"}},
+	{name: "quoted_fields", args: []string{"SELECT id, note FROM $ORDERS WHERE note != '' AND id <= 40"}},
+	{name: "line_numbers", args: []string{"SELECT _line, id, note FROM $ORDERS WHERE id BETWEEN 95 AND 110"}},
+	{name: "case_insensitive", args: []string{"--case-insensitive", "SELECT id, country FROM $ORDERS WHERE country = 'jp' LIMIT 8"}},
+	{name: "count_only", args: []string{"--count-only", "SELECT * FROM $ORDERS WHERE status = 'paid'"}},
+	{name: "group_by", args: []string{"SELECT country, COUNT(*), SUM(qty), SUM(total), AVG(price), MIN(price), MAX(total) FROM $ORDERS GROUP BY country"}},
+	{name: "group_by_two", args: []string{"SELECT country, status, COUNT(*) AS n FROM $ORDERS WHERE qty >= 5 GROUP BY country, status"}},
+	{name: "having_order_limit", args: []string{"SELECT customer_id, COUNT(*) AS orders, SUM(total) AS spent FROM $ORDERS GROUP BY customer_id HAVING COUNT(*) >= 10 ORDER BY spent DESC LIMIT 5"}},
+	{name: "ungrouped", args: []string{"SELECT COUNT(*), COUNT(price), SUM(qty), MIN(order_date), MAX(id) FROM $ORDERS WHERE status = 'refunded'"}},
+	{name: "whole_file_aggregates", args: []string{"SELECT COUNT(*), COUNT(note), SUM(qty), MIN(id), MAX(total) FROM $ORDERS"}},
+	{name: "count_only_all", args: []string{"--count-only", "SELECT * FROM $ORDERS"}},
+	{name: "aggregate_filter", args: []string{"SELECT country, COUNT(*) FILTER (WHERE status = 'refunded') AS refunds, SUM(total) FILTER (WHERE status = 'paid') AS paid FROM $ORDERS GROUP BY country"}},
+	{name: "null_token_format", args: []string{"--null-token", "NULL", "--precision", "1", "--output-thousands", ",", "SELECT country, SUM(total), AVG(qty) FROM $ORDERS GROUP BY country"}},
+	{name: "qualify", args: []string{"SELECT country, id, total FROM $ORDERS WHERE country != '' QUALIFY ROW_NUMBER() OVER (PARTITION BY country ORDER BY total DESC) <= 2"}},
+	{name: "sample_repeatable", args: []string{"SELECT id, country FROM $ORDERS TABLESAMPLE BERNOULLI (5) REPEATABLE (42)"}},
+	{name: "subquery", args: []string{"SELECT id, customer_id, total FROM $ORDERS WHERE customer_id IN (SELECT customer_id FROM 'customers.csv' WHERE tier = 'gold') AND id > 370"}},
+	{name: "offsets", args: []string{"SELECT _offset, id FROM $ORDERS WHERE id IN (1, 2, 200, 400)"}},
+	{name: "unknown_column", args: []string{"SELECT id FROM $ORDERS WHERE colour = 'red'"}},
+	{name: "syntax_error", args: []string{"SELECT id FORM orders"}},
+}
+
+// TestGolden runs the binary on every golden case three ways: reading
+// orders.csv without an index, with a fresh .sidx (--require-index), and
+// from stdin. Each run must write the golden bytes, so execution paths
+// can't drift apart. Rewrite the files with
+//
+//	go test ./cmd/sieswi -run TestGolden -update
+func TestGolden(t *testing.T) {
+	if testing.Short() {
+		t.Skip("golden tests build the binary; skipped with -short")
+	}
+	bin := buildBinary(t)
+
+	// Each mode runs in its own copy of the fixtures, so the index one
+	// doesn't leave a .sidx for the others
+	plain, indexed := fixtureDir(t), fixtureDir(t)
+	if out, err := exec.Command(bin, "index", "--rows-per-block", "16",
+		filepath.Join(indexed, "orders.csv"), filepath.Join(indexed, "customers.csv")).CombinedOutput(); err != nil {
+		t.Fatalf("index: %v\n%s", err, out)
+	}
+
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			golden := filepath.Join("testdata", "golden", tc.name+".out")
+			got := runGolden(t, bin, plain, tc.args, "'orders.csv'", false)
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			checkGolden(t, "no index", got, want)
+			checkGolden(t, "index", runGolden(t, bin, indexed, append([]string{"--require-index"}, tc.args...), "'orders.csv'", false), want)
+			checkGolden(t, "stdin", runGolden(t, bin, plain, tc.args, "'-'", true), want)
+		})
+	}
+}
+
+// buildBinary builds sieswi into a temporary directory
+func buildBinary(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "sieswi")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("build: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// fixtureDir copies the fixture CSVs to a new directory
+func fixtureDir(t *testing.T) string {
+	dir := t.TempDir()
+	for _, name := range []string{"orders.csv", "customers.csv"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runGolden runs the binary in dir with $ORDERS replaced by orders, piping
+// orders.csv to stdin if asked. It returns stdout, then stderr and the exit
+// status when the run fails.
+func runGolden(t *testing.T, bin, dir string, args []string, orders string, stdin bool) []byte {
+	t.Helper()
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = strings.ReplaceAll(arg, "$ORDERS", orders)
+	}
+	cmd := exec.Command(bin, expanded...)
+	cmd.Dir = dir
+	if stdin {
+		f, err := os.Open(filepath.Join(dir, "orders.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		cmd.Stdin = f
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		fmt.Fprintf(&stdout, "--- exit %d\n%s", exit.ExitCode(), stderr.Bytes())
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.Bytes()
+}
+
+// checkGolden reports the first line where got and want differ
+func checkGolden(t *testing.T, mode string, got, want []byte) {
+	t.Helper()
+	if bytes.Equal(got, want) {
+		return
+	}
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			t.Errorf("%s: line %d is %q, want %q", mode, i+1, g, w)
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// holdStdin spools stdin to a temporary file so every statement of a
+// script can query it, with an index over it when withIndex is set. The
+// file and its index are removed when the process ends through exit,
+// including on a broken pipe or a signal, since they copy all of the input.
+func holdStdin(opts queryOptions, withIndex bool) (string, error) {
+	dir, err := os.MkdirTemp("", "sieswi-hold-")
+	if err != nil {
+		return "", fmt.Errorf("--hold: %w", err)
+	}
+	onExit(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "stdin.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("--hold: %w", err)
+	}
+	_, err = io.Copy(file, os.Stdin)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("--hold: spool stdin: %w", err)
+	}
+
+	// The index only speeds statements up, so failing to build one isn't fatal
+	if withIndex {
+		res := buildIndex(path, indexOptions{
+			parallel:    true,
+			noHeader:    opts.noHeader,
+			headerNames: opts.headerNames,
+			header:      opts.header,
+			dialect:     opts.dialect,
+			numbers:     opts.numbers,
+			encoding:    opts.encoding,
+		})
+		if res.err != nil {
+			fmt.Fprintf(os.Stderr, "--hold: querying stdin without an index: %v\n", res.err)
+		}
+	}
+	return path, nil
+}
+
+// resolveInput points a query at the file it reads: a named table's, or
+// spooled stdin under --hold
+func resolveInput(query *sqlparser.Query, opts queryOptions) error {
+	if err := useTable(query, opts.tables); err != nil {
+		return err
+	}
+	if opts.heldStdin != "" && (query.FilePath == "-" || query.FilePath == "stdin") {
+		query.FilePath = opts.heldStdin
+	}
+	return nil
+}
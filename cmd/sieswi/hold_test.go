@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHoldRemovesSpool checks that the copy of stdin --hold spools is
+// removed when the process ends early, not only after the last statement
+func TestHoldRemovesSpool(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the binary; skipped with -short")
+	}
+	bin := buildBinary(t)
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.sql")
+	if err := os.WriteFile(script, []byte("SELECT * FROM '-';\nSELECT * FROM '-';\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "input.csv")
+	var data strings.Builder
+	data.WriteString("id,name\n")
+	for i := 0; i < 200_000; i++ {
+		data.WriteString("1234567,abcdefghijklmnop\n")
+	}
+	if err := os.WriteFile(input, []byte(data.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("broken pipe", func(t *testing.T) {
+		tmp := t.TempDir()
+		cmd := exec.Command(bin, "--hold", "-f", script)
+		cmd.Env = append(os.Environ(), "TMPDIR="+tmp)
+		in, err := os.Open(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+		cmd.Stdin = in
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		// Read a line, as head -1 would, and go away
+		if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+		stdout.Close()
+		checkExit(t, cmd.Wait(), brokenPipeStatus)
+		checkNoSpool(t, tmp)
+	})
+
+	t.Run("SIGTERM", func(t *testing.T) {
+		tmp := t.TempDir()
+		cmd := exec.Command(bin, "--hold", "-f", script)
+		cmd.Env = append(os.Environ(), "TMPDIR="+tmp)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd.Stdout = io.Discard
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer stdin.Close()
+		if _, err := io.WriteString(stdin, "id,name\n1,a\n"); err != nil {
+			t.Fatal(err)
+		}
+		// Stdin stays open, so sieswi is still spooling when signalled
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			spools, _ := filepath.Glob(filepath.Join(tmp, "sieswi-hold-*", "stdin.csv"))
+			if len(spools) > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("no spool file appeared")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+		checkExit(t, cmd.Wait(), 128+int(syscall.SIGTERM))
+		checkNoSpool(t, tmp)
+	})
+}
+
+func checkExit(t *testing.T, err error, want int) {
+	t.Helper()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) {
+		t.Fatalf("exit: %v, want status %d", err, want)
+	}
+	if got := exit.ExitCode(); got != want {
+		t.Errorf("exit status %d, want %d", got, want)
+	}
+}
+
+func checkNoSpool(t *testing.T, tmp string) {
+	t.Helper()
+	left, err := filepath.Glob(filepath.Join(tmp, "sieswi-hold-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) > 0 {
+		t.Errorf("spool left behind: %v", left)
+	}
+}
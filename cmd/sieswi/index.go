@@ -0,0 +1,741 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
+	"github.com/melihbirim/sieswi/internal/sidx"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+const indexUsage = "usage: sieswi index [--upgrade] [--checkpoint] [--skip-type-inference] [--block-size KB | --rows-per-block N] [--parallel|--sequential] [--workers N] [--jobs N] [--columns a,b,c] [--sums a,b] [--keys a,b] [--pairs a+b,...] [--compress] [--bound-length N] [--no-header [--names a,b,c] | --header] [--delimiter C] [--quote C] [--thousands SEP] [--decimal-comma] [--encoding latin1] [--on-error strict] <csvfile>..."
+
+// indexOptions configures a single index build
+type indexOptions struct {
+	skipTypeInference bool
+	blockSize         uint32 // Rows per block; zero sizes blocks by blockBytes
+	blockBytes        int64  // Target CSV bytes per block, zero for sidx.DefaultBlockBytes
+	compress          bool   // DEFLATE the block section
+	boundLength       uint16 // Cut string bounds to this many bytes, zero for exact
+	parallel          bool
+	workers           int
+	columns           []string
+	sums              []string          // Numeric columns whose blocks store sums
+	keys              []string          // Columns to build key maps of, in the .skey sidecar
+	pairs             []sidx.ColumnPair // Correlated columns whose key maps keep joint bounds
+	noHeader          bool
+	headerNames       []string      // Column names for --no-header files
+	header            bool          // The first row is a header; sniffed without either
+	dialect           csvio.Dialect // --delimiter and --quote; zero fields are sniffed
+	numbers           datatype.NumberFormat
+	strict            bool   // Fail on rows whose field count differs from the header's
+	encoding          string // Input encoding from --encoding
+	progress          sidx.ProgressFunc
+	upgrade           bool // Rebuild with the options recorded in the existing index
+	checkpoint        bool // Build sequentially, saving progress to a .sidx.partial
+	cancel            <-chan struct{}
+}
+
+// indexResult summarizes one build for the summary table
+type indexResult struct {
+	path     string
+	size     int64
+	rows     uint64
+	blocks   uint32
+	status   string // Outcome other than "ok", e.g. for --upgrade
+	skipped  bool   // Nothing was written: the index was already up to date
+	duration time.Duration
+	err      error
+}
+
+// runIndexCommand implements `sieswi index` and returns the process exit code.
+func runIndexCommand(args []string) int {
+	indexFlags := flag.NewFlagSet("index", flag.ExitOnError)
+	upgrade := indexFlags.Bool("upgrade", false, "Rebuild indexes that are outdated or stale, with the options they were built with")
+	checkpoint := indexFlags.Bool("checkpoint", false, "Save progress to FILE.sidx.partial and resume an interrupted build from it (builds sequentially)")
+	skipTypeInference := indexFlags.Bool("skip-type-inference", false, "Skip type inference, assume all columns are strings (faster)")
+	blockSizeKB := indexFlags.Int("block-size", 0, fmt.Sprintf("Target CSV size per block in KB; rows per block follow from the file's row width (default: %d)", sidx.DefaultBlockBytes/1024))
+	rowsPerBlock := indexFlags.Int("rows-per-block", 0, "Rows per block, instead of sizing blocks by bytes")
+	parallel := indexFlags.Bool("parallel", true, "Use parallel index building; produces the same index as --sequential (default: true)")
+	sequential := indexFlags.Bool("sequential", false, "Force sequential processing (disable parallel)")
+	workers := indexFlags.Int("workers", 0, "Number of parallel workers (default: CPU count)")
+	jobs := indexFlags.Int("jobs", 0, "Number of files indexed concurrently (default: CPU count)")
+	columnsFlag := indexFlags.String("columns", "", "Comma-separated columns to index (default: all columns)")
+	sumsFlag := indexFlags.String("sums", "", "Comma-separated numeric columns to store per-block sums of, for SUM and AVG")
+	keysFlag := indexFlags.String("keys", "", "Comma-separated columns to map each value of to its blocks, in a .skey sidecar")
+	pairsFlag := indexFlags.String("pairs", "", "Comma-separated lead+column pairs, e.g. country+created_at: the key map of lead keeps the bounds of column per value and block")
+	compress := indexFlags.Bool("compress", false, "Compress the block stats of the .sidx")
+	boundLength := indexFlags.Int("bound-length", 0, "Cut string min/max bounds to at most N bytes (default: exact)")
+	noHeader := indexFlags.Bool("no-header", false, "First row is data, not column names")
+	namesFlag := indexFlags.String("names", "", "Comma-separated column names for --no-header files (default: c1,c2,...)")
+	header := indexFlags.Bool("header", false, "First row is column names (default: sniffed)")
+	delimiter := indexFlags.String("delimiter", "", "Field separator, e.g. ';' or tab (default: sniffed)")
+	quote := indexFlags.String("quote", "", "Quote character: \" or ' (default: sniffed)")
+	thousands := indexFlags.String("thousands", "", "Thousands separator to accept in numbers, e.g. ','")
+	decimalComma := indexFlags.Bool("decimal-comma", false, "Numbers use ',' as the decimal point")
+	encoding := indexFlags.String("encoding", "utf8", "Input encoding: utf8 or latin1")
+	onError := indexFlags.String("on-error", "", "'strict' fails on rows whose field count differs from the header's")
+	if err := indexFlags.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "parse flags: %v\n", err)
+		return 1
+	}
+
+	if indexFlags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, indexUsage)
+		return 1
+	}
+
+	paths, err := expandIndexArgs(indexFlags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "index error:", err)
+		return 1
+	}
+
+	if *upgrade {
+		// Each index keeps its own options; only how to build may be chosen
+		var conflicts []string
+		indexFlags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "upgrade", "checkpoint", "parallel", "sequential", "workers", "jobs":
+			default:
+				conflicts = append(conflicts, "--"+f.Name)
+			}
+		})
+		if len(conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "--upgrade reuses the options each index was built with; it can't be combined with %s\n", strings.Join(conflicts, ", "))
+			return 1
+		}
+		opts := indexOptions{parallel: *parallel && !*sequential, workers: *workers, upgrade: true, checkpoint: *checkpoint}
+		return runIndexes(paths, opts, *jobs)
+	}
+
+	if *blockSizeKB < 0 || *rowsPerBlock < 0 {
+		fmt.Fprintln(os.Stderr, "index error: block sizes must be positive")
+		return 1
+	}
+	if *blockSizeKB > 0 && *rowsPerBlock > 0 {
+		fmt.Fprintln(os.Stderr, "--rows-per-block cannot be combined with --block-size")
+		return 1
+	}
+	if *boundLength < 0 || *boundLength > math.MaxUint16 {
+		fmt.Fprintf(os.Stderr, "index error: --bound-length must be between 0 and %d\n", math.MaxUint16)
+		return 1
+	}
+
+	opts := indexOptions{
+		skipTypeInference: *skipTypeInference,
+		blockSize:         uint32(min(*rowsPerBlock, math.MaxUint32)),
+		blockBytes:        int64(*blockSizeKB) * 1024,
+		compress:          *compress,
+		boundLength:       uint16(*boundLength),
+		// If --sequential is set, disable parallel
+		parallel:    *parallel && !*sequential,
+		workers:     *workers,
+		columns:     splitList(*columnsFlag),
+		sums:        splitList(*sumsFlag),
+		keys:        splitList(*keysFlag),
+		noHeader:    *noHeader,
+		headerNames: splitList(*namesFlag),
+		header:      *header,
+		checkpoint:  *checkpoint,
+	}
+	for _, spec := range splitList(*pairsFlag) {
+		pair, err := sidx.ParseColumnPair(spec)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "index error:", err)
+			return 1
+		}
+		opts.pairs = append(opts.pairs, pair)
+	}
+	if len(opts.headerNames) > 0 && !opts.noHeader {
+		fmt.Fprintln(os.Stderr, "--names requires --no-header")
+		return 1
+	}
+	if opts.header && opts.noHeader {
+		fmt.Fprintln(os.Stderr, "--header cannot be combined with --no-header")
+		return 1
+	}
+	if *delimiter != "" {
+		if opts.dialect.Delimiter, err = csvio.ParseDelimiter(*delimiter); err != nil {
+			fmt.Fprintln(os.Stderr, "index error:", err)
+			return 1
+		}
+	}
+	if *quote != "" {
+		if opts.dialect.Quote, err = csvio.ParseQuote(*quote); err != nil {
+			fmt.Fprintln(os.Stderr, "index error:", err)
+			return 1
+		}
+	}
+	if opts.numbers, err = numberFormat(*thousands, *decimalComma); err != nil {
+		fmt.Fprintln(os.Stderr, "index error:", err)
+		return 1
+	}
+	if opts.encoding, err = csvio.ParseEncoding(*encoding); err != nil {
+		fmt.Fprintln(os.Stderr, "index error:", err)
+		return 1
+	}
+	switch *onError {
+	case "":
+	case sqlparser.OnErrorStrict:
+		opts.strict = true
+	default:
+		// Queries read every row, so the index must count every row too
+		fmt.Fprintf(os.Stderr, "index error: --on-error %q: only strict is supported when indexing\n", *onError)
+		return 1
+	}
+
+	return runIndexes(paths, opts, *jobs)
+}
+
+// runIndexes builds the indexes of paths until done or interrupted. The
+// first Ctrl-C stops the builds between blocks and writes nothing; a
+// second one exits at once.
+func runIndexes(paths []string, opts indexOptions, jobs int) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	opts.cancel = ctx.Done()
+
+	if len(paths) == 1 {
+		return runSingleIndex(paths[0], opts)
+	}
+	return runMultiIndex(paths, opts, jobs)
+}
+
+// newIndexProgress reports build progress on stderr: a bar on a terminal,
+// otherwise a line every so often for logs
+func newIndexProgress(total int64, tasks int) *progressBar {
+	if isTerminal(os.Stderr) {
+		return newProgressBar(os.Stderr, "rows", total, tasks)
+	}
+	return newProgressLog(os.Stderr, "rows", total, tasks)
+}
+
+// interrupted reports whether done has been closed
+func interrupted(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkpointPath is where --checkpoint saves the progress of csvPath's build
+func checkpointPath(csvPath string) string {
+	return csvPath + ".sidx.partial"
+}
+
+// numberFormat builds the number format from --thousands and --decimal-comma
+func numberFormat(thousands string, decimalComma bool) (datatype.NumberFormat, error) {
+	var f datatype.NumberFormat
+	if len(thousands) > 1 {
+		return f, fmt.Errorf("--thousands takes a single character, not %q", thousands)
+	}
+	if thousands != "" {
+		f.Thousands = thousands[0]
+	}
+	if decimalComma {
+		f.Decimal = ','
+	}
+	return f, f.Validate()
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// expandIndexArgs expands ~ and environment variables, resolves glob
+// patterns (for shells that don't expand them, e.g. Windows cmd or quoted
+// arguments), and drops index sidecars (.sidx, .skey, .sidx.partial) from the list.
+func expandIndexArgs(args []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		arg = sqlparser.ExpandPath(arg)
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+		}
+		for _, m := range matches {
+			if strings.HasSuffix(m, ".sidx") || strings.HasSuffix(m, ".skey") || strings.HasSuffix(m, ".sidx.partial") || seen[m] {
+				continue
+			}
+			seen[m] = true
+			paths = append(paths, m)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no CSV files to index")
+	}
+	return paths, nil
+}
+
+func runSingleIndex(csvPath string, opts indexOptions) int {
+	mode := ""
+	if opts.parallel && !opts.checkpoint {
+		mode = ", parallel mode"
+	}
+	size := fmt.Sprintf("%d rows per block", opts.blockSize)
+	if opts.blockSize == 0 {
+		size = fmt.Sprintf("~%d KB blocks", cmp.Or(opts.blockBytes, sidx.DefaultBlockBytes)/1024)
+	}
+	if opts.upgrade {
+		fmt.Fprintf(os.Stderr, "Upgrading index for %s...\n", csvPath)
+	} else {
+		fmt.Fprintf(os.Stderr, "Building index for %s (%s%s)...\n", csvPath, size, mode)
+	}
+
+	var bar *progressBar
+	if stat, err := os.Stat(csvPath); err == nil {
+		bar = newIndexProgress(stat.Size(), 1)
+		opts.progress = func(bytesDone int64, rowsDone uint64) {
+			bar.update(0, bytesDone, rowsDone)
+		}
+	}
+
+	res := buildIndex(csvPath, opts)
+	if bar != nil {
+		bar.close()
+	}
+	if errors.Is(res.err, sidx.ErrCanceled) {
+		fmt.Fprintf(os.Stderr, "Index build canceled; %s was not written\n", csvPath+".sidx")
+		if opts.checkpoint {
+			fmt.Fprintf(os.Stderr, "Progress is saved in %s; run with --checkpoint again to resume\n", checkpointPath(csvPath))
+		}
+		return 130
+	}
+	if res.err != nil {
+		fmt.Fprintln(os.Stderr, "index error:", res.err)
+		return 1
+	}
+
+	if res.skipped {
+		fmt.Fprintf(os.Stderr, "%s is %s\n", csvPath+".sidx", res.status)
+		return 0
+	}
+	note := ""
+	if res.status != "" {
+		note = ", " + res.status
+	}
+	perBlock := uint64(0)
+	if res.blocks > 0 {
+		perBlock = res.rows / uint64(res.blocks)
+	}
+	fmt.Fprintf(os.Stderr, "Index written to %s (%d rows in %d blocks, %d per block on average%s)\n", csvPath+".sidx", res.rows, res.blocks, perBlock, note)
+	return 0
+}
+
+func runMultiIndex(paths []string, opts indexOptions, jobs int) int {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	if jobs > len(paths) {
+		jobs = len(paths)
+	}
+	// Share the CPU budget between concurrent builds
+	if opts.workers <= 0 {
+		opts.workers = runtime.NumCPU() / jobs
+		if opts.workers < 1 {
+			opts.workers = 1
+		}
+	}
+
+	var totalBytes int64
+	for _, p := range paths {
+		if stat, err := os.Stat(p); err == nil {
+			totalBytes += stat.Size()
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Building indexes for %d files (%d concurrent)...\n", len(paths), jobs)
+
+	bar := newIndexProgress(totalBytes, len(paths))
+
+	results := make([]indexResult, len(paths))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				fileOpts := opts
+				task := i
+				fileOpts.progress = func(bytesDone int64, rowsDone uint64) {
+					bar.update(task, bytesDone, rowsDone)
+				}
+				results[i] = buildIndex(paths[i], fileOpts)
+				bar.finishTask()
+			}
+		}()
+	}
+	for i := range paths {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	bar.close()
+
+	failed := printIndexSummary(os.Stderr, results)
+	switch {
+	case interrupted(opts.cancel):
+		return 130
+	case failed > 0:
+		return 1
+	}
+	return 0
+}
+
+// printIndexSummary writes a table of build results and returns the number of failures.
+func printIndexSummary(out io.Writer, results []indexResult) int {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tSIZE\tROWS\tBLOCKS\tTIME\tROWS/S\tSTATUS")
+
+	failed := 0
+	var totalRows uint64
+	var totalSize int64
+	var totalTime time.Duration
+	for _, r := range results {
+		status := cmp.Or(r.status, "ok")
+		if errors.Is(r.err, sidx.ErrCanceled) {
+			status = "canceled"
+			failed++
+		} else if r.err != nil {
+			status = "error: " + r.err.Error()
+			failed++
+		}
+		rate := uint64(0)
+		if r.duration > 0 {
+			rate = uint64(float64(r.rows) / r.duration.Seconds())
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			r.path, formatBytes(r.size), r.rows, r.blocks, r.duration.Round(time.Millisecond), formatCount(rate), status)
+		totalRows += r.rows
+		totalSize += r.size
+		if r.duration > totalTime {
+			totalTime = r.duration
+		}
+	}
+	fmt.Fprintf(tw, "TOTAL (%d files, %d failed)\t%s\t%d\t\t%s\t\t\n",
+		len(results), failed, formatBytes(totalSize), totalRows, totalTime.Round(time.Millisecond))
+
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "write summary: %v\n", err)
+	}
+	return failed
+}
+
+// buildIndex builds and writes the .sidx sidecar for csvPath.
+func buildIndex(csvPath string, opts indexOptions) indexResult {
+	start := time.Now()
+	res := indexResult{path: csvPath}
+
+	if opts.upgrade {
+		upgraded, from, current, err := upgradeOptions(csvPath, opts)
+		if err != nil {
+			res.err = err
+			return res
+		}
+		if current {
+			res.status, res.skipped = fmt.Sprintf("up to date (v%d)", from), true
+			return res
+		}
+		res.status = fmt.Sprintf("upgraded from v%d", from)
+		opts = upgraded
+	}
+
+	// Blocks sized by bytes hold as many rows as fit at the file's row width
+	blockRows := opts.blockSize
+	if blockRows == 0 {
+		var err error
+		blockRows, err = sidx.RowsPerBlock(csvPath, cmp.Or(opts.blockBytes, sidx.DefaultBlockBytes), opts.noHeader)
+		if err != nil {
+			res.err = fmt.Errorf("size blocks: %w", err)
+			return res
+		}
+	}
+
+	var index *sidx.Index
+	var err error
+
+	if opts.parallel && !opts.checkpoint {
+		builder := sidx.NewParallelBuilder(blockRows, opts.workers)
+		builder.SetSkipTypeInference(opts.skipTypeInference)
+		builder.SetColumns(opts.columns)
+		builder.SetSumColumns(opts.sums)
+		builder.SetProgress(opts.progress)
+		builder.SetCancel(opts.cancel)
+		if opts.noHeader {
+			builder.SetNoHeader(opts.headerNames)
+		}
+		if opts.header {
+			builder.SetHeader()
+		}
+		builder.SetDialect(opts.dialect)
+		builder.SetNumberFormat(opts.numbers)
+		builder.SetStrict(opts.strict)
+		builder.SetEncoding(opts.encoding)
+		index, err = builder.BuildFromFile(csvPath)
+	} else {
+		builder := sidx.NewBuilder(blockRows)
+		builder.SetSkipTypeInference(opts.skipTypeInference)
+		builder.SetColumns(opts.columns)
+		builder.SetSumColumns(opts.sums)
+		builder.SetProgress(opts.progress)
+		builder.SetCancel(opts.cancel)
+		if opts.checkpoint {
+			builder.SetCheckpoint(checkpointPath(csvPath))
+		}
+		if opts.noHeader {
+			builder.SetNoHeader(opts.headerNames)
+		}
+		if opts.header {
+			builder.SetHeader()
+		}
+		builder.SetDialect(opts.dialect)
+		builder.SetNumberFormat(opts.numbers)
+		builder.SetStrict(opts.strict)
+		builder.SetEncoding(opts.encoding)
+		index, err = builder.BuildFromFile(csvPath)
+		if rows := builder.ResumedRows(); rows > 0 {
+			res.status = strings.TrimPrefix(res.status+fmt.Sprintf(", resumed at row %d", rows), ", ")
+		}
+	}
+
+	if errors.Is(err, sidx.ErrCanceled) {
+		res.err = err
+		return res
+	}
+	if err != nil {
+		res.err = fmt.Errorf("build index: %w", err)
+		return res
+	}
+
+	index.Header.BuildVersion = buildVersion()
+	index.Header.Compressed = opts.compress
+	index.Header.BoundLength = opts.boundLength
+	res.size = index.Header.FileSize
+	res.blocks = index.Header.NumBlocks
+	if n := len(index.Blocks); n > 0 {
+		res.rows = index.Blocks[n-1].EndRow
+	}
+
+	var maps []*sidx.KeyMap
+	if len(opts.keys) > 0 || len(opts.pairs) > 0 {
+		if maps, err = sidx.BuildKeyMaps(csvPath, index, opts.keys, opts.pairs, opts.encoding); err != nil {
+			res.err = fmt.Errorf("build key maps: %w", err)
+			return res
+		}
+	}
+
+	// Past this point both files get written, so an interrupt can't leave
+	// a .skey behind that doesn't match the .sidx
+	if interrupted(opts.cancel) {
+		res.err = sidx.ErrCanceled
+		return res
+	}
+	if maps != nil {
+		if err := writeKeyFile(csvPath+".skey", index, maps); err != nil {
+			res.err = err
+			return res
+		}
+	}
+	res.err = writeIndexFile(csvPath+".sidx", index)
+	if res.err == nil && opts.checkpoint {
+		os.Remove(checkpointPath(csvPath))
+	}
+	res.duration = time.Since(start)
+	return res
+}
+
+func writeIndexFile(indexPath string, index *sidx.Index) error {
+	return writeAtomic(indexPath, "index", func(w io.Writer) error {
+		if err := sidx.WriteIndex(w, index); err != nil {
+			return fmt.Errorf("write index: %w", err)
+		}
+		return nil
+	})
+}
+
+func writeKeyFile(keyPath string, index *sidx.Index, maps []*sidx.KeyMap) error {
+	return writeAtomic(keyPath, "key", func(w io.Writer) error {
+		if err := sidx.WriteKeyMaps(w, index, maps); err != nil {
+			return fmt.Errorf("write key maps: %w", err)
+		}
+		return nil
+	})
+}
+
+// writeAtomic writes path through a temporary file in the same directory,
+// renamed over path once complete. Queries reading path meanwhile see the
+// old file or the new one, never a partial one, and a failed or interrupted
+// build leaves the old file in place.
+func writeAtomic(path, kind string, write func(w io.Writer) error) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create %s file: %w", kind, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write %s file: %w", kind, err)
+	}
+	// CreateTemp makes the file private; use what os.Create gives under the usual umask
+	if err := tmp.Chmod(0644); err != nil {
+		return fmt.Errorf("write %s file: %w", kind, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s file: %w", kind, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace %s file: %w", kind, err)
+	}
+	return nil
+}
+
+// buildVersion identifies this binary in index headers.
+func buildVersion() string {
+	return fmt.Sprintf("sieswi %s (commit: %s)", version, commit)
+}
+
+// inspectIndex prints the header of an index file along with its validity
+// against the source CSV. path may name either the CSV or the .sidx file.
+func inspectIndex(path string, out io.Writer) error {
+	indexPath := path
+	csvPath := strings.TrimSuffix(path, ".sidx")
+	if !strings.HasSuffix(path, ".sidx") {
+		indexPath = path + ".sidx"
+	}
+
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return fmt.Errorf("open index: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "close index file: %v\n", err)
+		}
+	}()
+
+	index, err := sidx.ReadIndexLazy(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("read index: %w", err)
+	}
+
+	h := &index.Header
+	builtBy := h.BuildVersion
+	if builtBy == "" {
+		builtBy = "unknown (index predates build info)"
+	}
+	status := "valid"
+	if err := sidx.ValidateIndex(index, csvPath); err != nil {
+		status = "stale: " + err.Error()
+	} else if h.Version < sidx.MinUsableVersion {
+		status = fmt.Sprintf("outdated: queries ignore indexes older than v%d, rebuild it with 'sieswi index --upgrade'", sidx.MinUsableVersion)
+	} else if h.Version < sidx.Version {
+		status = fmt.Sprintf("valid; 'sieswi index --upgrade' rewrites it as v%d", sidx.Version)
+	}
+
+	fmt.Fprintf(out, "Index:        %s\n", indexPath)
+	fmt.Fprintf(out, "Format:       v%d (this binary writes v%d)\n", h.Version, sidx.Version)
+	fmt.Fprintf(out, "Built by:     %s\n", builtBy)
+	fmt.Fprintf(out, "Build flags:  %s\n", h.BuildFlags)
+	if !h.Numbers.IsDefault() {
+		fmt.Fprintf(out, "Numbers:      %s\n", h.Numbers)
+	}
+	if !h.Dialect.IsDefault() {
+		fmt.Fprintf(out, "Dialect:      %s\n", h.Dialect)
+	}
+	fmt.Fprintf(out, "Block size:   %d rows\n", h.BlockSize)
+	fmt.Fprintf(out, "Blocks:       %d\n", h.NumBlocks)
+	if h.Compressed || h.BoundLength > 0 {
+		var storage []string
+		if h.Compressed {
+			storage = append(storage, "compressed")
+		}
+		if h.BoundLength > 0 {
+			storage = append(storage, fmt.Sprintf("string bounds cut to %d bytes", h.BoundLength))
+		}
+		fmt.Fprintf(out, "Storage:      %s\n", strings.Join(storage, ", "))
+	}
+	if h.Version >= 7 {
+		fmt.Fprintf(out, "Rows:         %d\n", h.TotalRows)
+	}
+	fmt.Fprintf(out, "Source size:  %d bytes\n", h.FileSize)
+	fmt.Fprintf(out, "Source mtime: %s\n", time.Unix(0, h.FileMtime).UTC().Format(time.RFC3339Nano))
+	if h.Version >= 6 {
+		fmt.Fprintf(out, "Checksum:     %016x\n", h.FileChecksum)
+	}
+	fmt.Fprintf(out, "Status:       %s\n", status)
+	keys, err := sidx.LoadKeyMaps(csvPath, index)
+	if err != nil {
+		fmt.Fprintf(out, "Key maps:     unusable: %v\n", err)
+	}
+	index.Keys = keys
+	fmt.Fprintf(out, "Columns:      %d\n", len(h.Columns))
+	for _, col := range h.Columns {
+		if h.Version >= 7 {
+			notes := fmt.Sprintf("~%d distinct", col.Distinct)
+			if col.Mixed {
+				notes += ", mixed"
+			}
+			if col.Sums {
+				notes += ", sums"
+			}
+			if col.Sorted {
+				notes += ", sorted"
+			}
+			if m := index.KeyMap(col.Name); m != nil {
+				notes += fmt.Sprintf(", key map of %d values", len(m.Keys))
+				for _, p := range m.Paired {
+					notes += ", bounds of " + p.Name
+				}
+			}
+			fmt.Fprintf(out, "  %4d  %-8s %-24s %s\n", col.Ordinal, col.Type, col.Name, notes)
+		} else {
+			fmt.Fprintf(out, "  %4d  %-8s %s\n", col.Ordinal, col.Type, col.Name)
+		}
+	}
+	return nil
+}
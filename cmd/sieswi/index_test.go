@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestIndexReportsRows checks that the summary counts the rows indexed, not
+// the rows a block could hold
+func TestIndexReportsRows(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the binary; skipped with -short")
+	}
+	bin := buildBinary(t)
+	path := filepath.Join(t.TempDir(), "four.csv")
+	if err := os.WriteFile(path, []byte("a,b\n1,2\n3,4\n5,6\n7,8\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"index", path}, "4 rows in 1 blocks, 4 per block"},
+		{[]string{"index", "--rows-per-block", "3", path}, "4 rows in 2 blocks, 2 per block"},
+	}
+	for _, tt := range tests {
+		out, err := exec.Command(bin, tt.args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", tt.args, err, out)
+		}
+		if !strings.Contains(string(out), tt.want) {
+			t.Errorf("%v reported\n%s\nwant %q", tt.args, out, tt.want)
+		}
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
-	"bufio"
 	"errors"
-	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/melihbirim/sieswi/internal/catalog"
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
 	"github.com/melihbirim/sieswi/internal/engine"
-	"github.com/melihbirim/sieswi/internal/sidx"
+	"github.com/melihbirim/sieswi/internal/resultcache"
 	"github.com/melihbirim/sieswi/internal/sqlparser"
 )
 
@@ -27,102 +31,629 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Check for index command
-	if len(os.Args) >= 2 && os.Args[1] == "index" {
-		// Parse flags for index command
-		indexFlags := flag.NewFlagSet("index", flag.ExitOnError)
-		skipTypeInference := indexFlags.Bool("skip-type-inference", false, "Skip type inference, assume all columns are strings (faster)")
-		blockSizeKB := indexFlags.Int("block-size", 32, "Block size in KB (default: 32)")
-		parallel := indexFlags.Bool("parallel", true, "Use parallel index building (default: true)")
-		sequential := indexFlags.Bool("sequential", false, "Force sequential processing (disable parallel)")
-		workers := indexFlags.Int("workers", 0, "Number of parallel workers (default: CPU count)")
-		if err := indexFlags.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "parse flags: %v\n", err)
+	// Check for index inspect command
+	if len(os.Args) >= 3 && os.Args[1] == "index" && os.Args[2] == "inspect" {
+		if len(os.Args) != 4 {
+			fmt.Fprintln(os.Stderr, "usage: sieswi index inspect <csvfile|indexfile>")
 			os.Exit(1)
 		}
-
-		if indexFlags.NArg() < 1 {
-			fmt.Fprintln(os.Stderr, "usage: sieswi index [--skip-type-inference] [--block-size KB] [--sequential] [--workers N] <csvfile>")
+		if err := inspectIndex(sqlparser.ExpandPath(os.Args[3]), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "inspect error:", err)
 			os.Exit(1)
 		}
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "describe" {
+		os.Exit(runDescribeCommand(os.Args[2:]))
+	}
+
+	// Check for index command
+	if len(os.Args) >= 2 && os.Args[1] == "index" {
+		os.Exit(runIndexCommand(os.Args[2:]))
+	}
 
-		csvPath := indexFlags.Arg(0)
-		blockSize := uint32(*blockSizeKB * 1024)
+	args := os.Args[1:]
+	var opts queryOptions
+	scriptPath := ""
+	continueOnError := false
+	configPath := ""
+	schemaSpec := ""
+	thousands := ""
+	decimalComma := false
+	encoding := ""
+	delimiter, quote := "", ""
+	var watchInterval time.Duration // Set by --watch
+	hold, holdIndex := false, false // Set by --hold and --hold=index
+	cacheDir := ""
+	noIndex, requireIndex := false, false
+	var resources resourceFlags
+	var format formatFlags
+flags:
+	for len(args) > 0 {
+		switch arg := args[0]; {
+		case arg == "--case-insensitive" || arg == "-i":
+			opts.caseInsensitive = true
+		case arg == "--progress":
+			opts.showProgress = true
+		case arg == "--stats":
+			opts.showStats = true
+		case arg == "--out" && len(args) > 1:
+			opts.outPath = sqlparser.ExpandPath(args[1])
+			args = args[1:]
+		case strings.HasPrefix(arg, "--out="):
+			opts.outPath = sqlparser.ExpandPath(strings.TrimPrefix(arg, "--out="))
+		case arg == "-f" && len(args) > 1:
+			scriptPath = sqlparser.ExpandPath(args[1])
+			args = args[1:]
+		case arg == "--no-header":
+			opts.noHeader = true
+		case arg == "--header":
+			opts.header = true
+		case arg == "--delimiter" && len(args) > 1:
+			delimiter = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--delimiter="):
+			delimiter = strings.TrimPrefix(arg, "--delimiter=")
+		case arg == "--quote" && len(args) > 1:
+			quote = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--quote="):
+			quote = strings.TrimPrefix(arg, "--quote=")
+		case arg == "--columns" && len(args) > 1:
+			opts.headerNames = splitList(args[1])
+			args = args[1:]
+		case strings.HasPrefix(arg, "--columns="):
+			opts.headerNames = splitList(strings.TrimPrefix(arg, "--columns="))
+		case arg == "--schema" && len(args) > 1:
+			schemaSpec = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--schema="):
+			schemaSpec = strings.TrimPrefix(arg, "--schema=")
+		case arg == "--thousands" && len(args) > 1:
+			thousands = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--thousands="):
+			thousands = strings.TrimPrefix(arg, "--thousands=")
+		case arg == "--decimal-comma":
+			decimalComma = true
+		case arg == "--encoding" && len(args) > 1:
+			encoding = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--encoding="):
+			encoding = strings.TrimPrefix(arg, "--encoding=")
+		case arg == "--on-error" && len(args) > 1:
+			opts.onError = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--on-error="):
+			opts.onError = strings.TrimPrefix(arg, "--on-error=")
+		case arg == "--reject-file" && len(args) > 1:
+			opts.rejectPath = sqlparser.ExpandPath(args[1])
+			args = args[1:]
+		case strings.HasPrefix(arg, "--reject-file="):
+			opts.rejectPath = sqlparser.ExpandPath(strings.TrimPrefix(arg, "--reject-file="))
+		case arg == "--threads" && len(args) > 1:
+			resources.threads = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--threads="):
+			resources.threads = strings.TrimPrefix(arg, "--threads=")
+		case arg == "--max-memory" && len(args) > 1:
+			resources.maxMemory = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--max-memory="):
+			resources.maxMemory = strings.TrimPrefix(arg, "--max-memory=")
+		case arg == "--io-buffer-size" && len(args) > 1:
+			resources.ioBufferSize = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--io-buffer-size="):
+			resources.ioBufferSize = strings.TrimPrefix(arg, "--io-buffer-size=")
+		case arg == "--precision" && len(args) > 1:
+			format.precision = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--precision="):
+			format.precision = strings.TrimPrefix(arg, "--precision=")
+		case arg == "--output-thousands" && len(args) > 1:
+			format.thousands = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--output-thousands="):
+			format.thousands = strings.TrimPrefix(arg, "--output-thousands=")
+		case arg == "--minor-units" && len(args) > 1:
+			format.minorUnits = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--minor-units="):
+			format.minorUnits = strings.TrimPrefix(arg, "--minor-units=")
+		case arg == "--null-token" && len(args) > 1:
+			format.null = args[1]
+			args = args[1:]
+		case strings.HasPrefix(arg, "--null-token="):
+			format.null = strings.TrimPrefix(arg, "--null-token=")
+		case arg == "--stable-order":
+			opts.stableOrder = true
+		case arg == "--unordered":
+			opts.unordered = true
+		case arg == "--count-only":
+			opts.countOnly = true
+		case arg == "--exit-match":
+			opts.exitMatch = true
+		case arg == "--no-index":
+			noIndex = true
+		case arg == "--require-index":
+			requireIndex = true
+		case arg == "--watch":
+			watchInterval = defaultWatchInterval
+		case strings.HasPrefix(arg, "--watch="):
+			interval, err := parseWatchInterval(strings.TrimPrefix(arg, "--watch="))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			watchInterval = interval
+		case arg == "--follow":
+			opts.follow = true
+		case arg == "--from-start":
+			opts.fromStart = true
+		case arg == "--hold":
+			hold = true
+		case arg == "--hold=index":
+			hold, holdIndex = true, true
+		case arg == "--cache-dir" && len(args) > 1:
+			cacheDir = sqlparser.ExpandPath(args[1])
+			args = args[1:]
+		case strings.HasPrefix(arg, "--cache-dir="):
+			cacheDir = sqlparser.ExpandPath(strings.TrimPrefix(arg, "--cache-dir="))
+		case arg == "--continue-on-error":
+			continueOnError = true
+		case arg == "--config" && len(args) > 1:
+			configPath = sqlparser.ExpandPath(args[1])
+			args = args[1:]
+		case strings.HasPrefix(arg, "--config="):
+			configPath = sqlparser.ExpandPath(strings.TrimPrefix(arg, "--config="))
+		default:
+			break flags
+		}
+		args = args[1:]
+	}
 
-		// If --sequential is set, disable parallel
-		useParallel := *parallel && !*sequential
+	if len(opts.headerNames) > 0 && !opts.noHeader {
+		fmt.Fprintln(os.Stderr, "--columns requires --no-header")
+		os.Exit(1)
+	}
+	if opts.header && opts.noHeader {
+		fmt.Fprintln(os.Stderr, "--header cannot be combined with --no-header")
+		os.Exit(1)
+	}
 
-		if err := buildIndex(csvPath, *skipTypeInference, blockSize, useParallel, *workers); err != nil {
-			fmt.Fprintln(os.Stderr, "index error:", err)
+	switch opts.onError {
+	case "", sqlparser.OnErrorStrict, sqlparser.OnErrorSkip, sqlparser.OnErrorLog:
+	default:
+		fmt.Fprintf(os.Stderr, "--on-error %q: want strict, skip, or log\n", opts.onError)
+		os.Exit(1)
+	}
+	if opts.rejectPath != "" && opts.onError != sqlparser.OnErrorSkip && opts.onError != sqlparser.OnErrorLog {
+		fmt.Fprintln(os.Stderr, "--reject-file requires --on-error skip or log")
+		os.Exit(1)
+	}
+
+	if opts.countOnly && opts.follow {
+		fmt.Fprintln(os.Stderr, "--count-only cannot be combined with --follow")
+		os.Exit(1)
+	}
+	if opts.exitMatch && (scriptPath != "" || watchInterval > 0 || opts.follow) {
+		fmt.Fprintln(os.Stderr, "--exit-match needs a single query; it cannot be combined with -f, --watch, or --follow")
+		os.Exit(1)
+	}
+
+	if opts.stableOrder && opts.unordered {
+		fmt.Fprintln(os.Stderr, "--stable-order cannot be combined with --unordered")
+		os.Exit(1)
+	}
+	switch {
+	case noIndex && requireIndex:
+		fmt.Fprintln(os.Stderr, "--no-index cannot be combined with --require-index")
+		os.Exit(1)
+	case noIndex:
+		opts.indexMode = sqlparser.IndexModeOff
+	case requireIndex:
+		opts.indexMode = sqlparser.IndexModeRequire
+	}
+
+	if schemaSpec != "" {
+		schema, err := sqlparser.ParseSchema(schemaSpec)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "--schema:", err)
 			os.Exit(1)
 		}
-		return
+		opts.schema = schema
 	}
 
-	queryText, err := getQueryFromArgsOrStdin(os.Args[1:], os.Stdin)
+	numbers, err := numberFormat(thousands, decimalComma)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	opts.numbers = numbers
 
-	query, err := sqlparser.Parse(queryText)
+	if opts.resources, err = resources.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if opts.output, err = format.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if opts.encoding, err = csvio.ParseEncoding(encoding); err != nil {
+		fmt.Fprintln(os.Stderr, "--encoding:", err)
+		os.Exit(1)
+	}
+	if delimiter != "" {
+		if opts.dialect.Delimiter, err = csvio.ParseDelimiter(delimiter); err != nil {
+			fmt.Fprintln(os.Stderr, "--delimiter:", err)
+			os.Exit(1)
+		}
+	}
+	if quote != "" {
+		if opts.dialect.Quote, err = csvio.ParseQuote(quote); err != nil {
+			fmt.Fprintln(os.Stderr, "--quote:", err)
+			os.Exit(1)
+		}
+	}
+
+	tables, err := loadCatalog(configPath)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "parse error:", err)
+		fmt.Fprintln(os.Stderr, "config error:", err)
 		os.Exit(1)
 	}
+	opts.tables = tables
 
-	writer := bufio.NewWriter(os.Stdout)
-	defer func() {
-		if err := writer.Flush(); err != nil {
-			fmt.Fprintf(os.Stderr, "flush output: %v\n", err)
+	if cacheDir != "" {
+		if opts.cache, err = resultcache.Open(cacheDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	}()
+	}
 
-	if err := engine.Execute(query, writer); err != nil {
-		fmt.Fprintln(os.Stderr, "execution error:", err)
+	if opts.fromStart && !opts.follow {
+		fmt.Fprintln(os.Stderr, "--from-start requires --follow")
 		os.Exit(1)
 	}
+	if opts.follow && watchInterval > 0 {
+		fmt.Fprintln(os.Stderr, "--follow cannot be combined with --watch")
+		os.Exit(1)
+	}
+
+	if scriptPath != "" {
+		if opts.follow {
+			fmt.Fprintln(os.Stderr, "--follow cannot be combined with -f")
+			os.Exit(1)
+		}
+		if watchInterval > 0 {
+			fmt.Fprintln(os.Stderr, "--watch cannot be combined with -f")
+			os.Exit(1)
+		}
+		if hold {
+			if opts.heldStdin, err = holdStdin(opts, holdIndex); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
+			}
+		}
+		exit(runScript(scriptPath, opts, continueOnError))
+	}
+	if hold {
+		fmt.Fprintln(os.Stderr, "--hold requires -f; a single query reads stdin once anyway")
+		os.Exit(1)
+	}
+
+	queryText, err := getQueryFromArgsOrStdin(args, os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if watchInterval > 0 {
+		if err := watchQuery(queryText, opts, watchInterval); err != nil {
+			exitOnBrokenPipe(err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := runQuery(queryText, opts); err != nil {
+		exitOnBrokenPipe(err)
+		if errors.Is(err, errNoMatch) {
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		// Like grep, --exit-match keeps 1 for "no rows" and fails with 2
+		if opts.exitMatch {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// queryOptions carries the command-line flags that apply to every query.
+type queryOptions struct {
+	caseInsensitive bool
+	showProgress    bool
+	showStats       bool
+	outPath         string
+	noHeader        bool                   // Input has no header row
+	headerNames     []string               // Column names for --no-header input
+	header          bool                   // Input has a header row (--header); sniffed without either
+	dialect         csvio.Dialect          // --delimiter and --quote; zero fields are sniffed
+	schema          map[string]string      // Declared column types from --schema
+	numbers         datatype.NumberFormat  // --thousands and --decimal-comma
+	encoding        string                 // Input encoding from --encoding
+	onError         string                 // Malformed row policy from --on-error
+	rejectPath      string                 // --reject-file for dropped malformed rows
+	indexMode       string                 // --no-index or --require-index
+	resources       sqlparser.Resources    // --threads, --max-memory, --io-buffer-size
+	output          sqlparser.OutputFormat // --precision, --output-thousands, --minor-units, --null-token
+	stableOrder     bool                   // Rows in input order on every path (--stable-order)
+	unordered       bool                   // Parallel batches written as they finish (--unordered)
+	countOnly       bool                   // Write the number of result rows instead (--count-only)
+	exitMatch       bool                   // Exit 1 when no row is returned (--exit-match)
+	tables          *catalog.Catalog       // Named tables from the config file
+	follow          bool                   // Stream appended rows (--follow)
+	fromStart       bool                   // --from-start: follow from the first row
+	heldStdin       string                 // Spooled stdin under --hold
+	cache           *resultcache.Cache     // Result cache from --cache-dir
 }
 
-func buildIndex(csvPath string, skipTypeInference bool, blockSize uint32, parallel bool, workers int) error {
-	var index *sidx.Index
-	var err error
+// loadCatalog reads the table registry from path, or from ~/.sieswi.yaml
+// when path is empty. A missing default file just means no named tables.
+func loadCatalog(path string) (*catalog.Catalog, error) {
+	if path != "" {
+		return catalog.Load(path)
+	}
+	path = catalog.DefaultPath()
+	if path == "" {
+		return nil, nil
+	}
+	tables, err := catalog.Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return tables, err
+}
 
-	if parallel {
-		fmt.Fprintf(os.Stderr, "Building index for %s (block size: %d KB, parallel mode)...\n", csvPath, blockSize/1024)
-		builder := sidx.NewParallelBuilder(blockSize, workers)
-		builder.SetSkipTypeInference(skipTypeInference)
-		index, err = builder.BuildFromFile(csvPath)
-	} else {
-		fmt.Fprintf(os.Stderr, "Building index for %s (block size: %d KB)...\n", csvPath, blockSize/1024)
-		builder := sidx.NewBuilder(blockSize)
-		builder.SetSkipTypeInference(skipTypeInference)
-		index, err = builder.BuildFromFile(csvPath)
+// useTable points a query whose FROM names a table in the catalog at the
+// table's file
+func useTable(query *sqlparser.Query, tables *catalog.Catalog) error {
+	table, ok := tables.Lookup(query.FilePath)
+	if !ok {
+		return nil
 	}
+	query.FilePath = table.Path
+	if query.Dialect.Delimiter == 0 {
+		query.Dialect.Delimiter = table.Delimiter
+	}
+	if !table.Header && !query.NoHeader {
+		query.NoHeader = true
+		query.HeaderNames = table.Columns
+	}
+	return nil
+}
 
+// runQuery parses and executes one statement, writing its result to stdout
+// or the requested output file.
+func runQuery(queryText string, opts queryOptions) error {
+	query, err := sqlparser.Parse(queryText)
 	if err != nil {
-		return fmt.Errorf("build index: %w", err)
+		// Show where a syntax error is under the offending line
+		var serr *sqlparser.SyntaxError
+		if errors.As(err, &serr) {
+			return fmt.Errorf("parse error: %w\n%s", err, serr.Context(queryText))
+		}
+		return fmt.Errorf("parse error: %w", err)
+	}
+	query.Numbers = opts.numbers
+	// Aggregate FILTER conditions read rows the same way WHERE does
+	conditions := []*sqlparser.Expression{&query.Where}
+	for i := range query.Filters {
+		conditions = append(conditions, &query.Filters[i])
+	}
+	// So do IN (SELECT ...) subqueries, which read their own files
+	var subqueries []*sqlparser.Query
+	for _, cond := range conditions {
+		subqueries = append(subqueries, sqlparser.Subqueries(*cond)...)
+	}
+	for _, sub := range subqueries {
+		sub.Numbers = opts.numbers
+		sub.Encoding = opts.encoding
+		sub.Dialect = opts.dialect
+		sub.IndexMode = opts.indexMode
+		sub.Resources = opts.resources
+		if err := resolveInput(sub, opts); err != nil {
+			return err
+		}
+		conditions = append(conditions, &sub.Where)
+	}
+	for _, cond := range conditions {
+		if *cond == nil {
+			continue
+		}
+		if opts.caseInsensitive {
+			*cond = sqlparser.FoldCase(*cond)
+		}
+		if !opts.numbers.IsDefault() {
+			if *cond, err = sqlparser.WithNumberFormat(*cond, opts.numbers); err != nil {
+				return fmt.Errorf("parse error: %w", err)
+			}
+		}
+		if len(opts.schema) > 0 {
+			if *cond, err = sqlparser.WithSchema(*cond, opts.schema); err != nil {
+				return fmt.Errorf("parse error: %w", err)
+			}
+		}
+	}
+	// HAVING compares aggregate results, which are plain numbers
+	if opts.caseInsensitive && query.Having != nil {
+		query.Having = sqlparser.FoldCase(query.Having)
+	}
+	query.NoHeader = opts.noHeader
+	query.HeaderNames = opts.headerNames
+	query.HasHeader = opts.header
+	query.Dialect = opts.dialect
+	query.Encoding = opts.encoding
+	query.OnError = opts.onError
+	query.RejectPath = opts.rejectPath
+	query.IndexMode = opts.indexMode
+	query.Resources = opts.resources
+	query.Output = opts.output
+	query.StableOrder = opts.stableOrder
+	query.Unordered = opts.unordered
+	if err := resolveInput(&query, opts); err != nil {
+		return err
+	}
+	if opts.countOnly && query.CreateTable {
+		return errors.New("--count-only cannot be combined with CREATE TABLE")
 	}
 
-	indexPath := csvPath + ".sidx"
-	f, err := os.Create(indexPath)
+	outPath := opts.outPath
+	if query.CreateTable {
+		if opts.follow {
+			return errors.New("--follow cannot be combined with CREATE TABLE")
+		}
+		if outPath != "" {
+			return errors.New("--out cannot be combined with CREATE TABLE")
+		}
+		// The index is built over the raw bytes, so the table must stay plain CSV
+		if strings.HasSuffix(query.OutputPath, ".gz") {
+			return fmt.Errorf("CREATE TABLE cannot write a compressed file: %s", query.OutputPath)
+		}
+	}
+
+	// --out takes precedence over SELECT ... INTO
+	if outPath == "" {
+		outPath = query.OutputPath
+	}
+	if outPath != "" && filepath.Clean(outPath) == filepath.Clean(query.FilePath) {
+		return fmt.Errorf("output file is the input file: %s", outPath)
+	}
+	if query.RejectPath != "" && filepath.Clean(query.RejectPath) == filepath.Clean(query.FilePath) {
+		return fmt.Errorf("reject file is the input file: %s", query.RejectPath)
+	}
+	writer, err := openOutput(outPath, opts.follow)
 	if err != nil {
-		return fmt.Errorf("create index file: %w", err)
+		return fmt.Errorf("open output: %w", err)
+	}
+
+	// Dropped rows are counted in stats and reported after the query, and
+	// so are the result rows --count-only and --exit-match look at
+	counting := opts.countOnly || opts.exitMatch
+	var stats *engine.Stats
+	if counting || opts.showProgress || opts.showStats || opts.onError == sqlparser.OnErrorSkip || opts.onError == sqlparser.OnErrorLog {
+		stats = &engine.Stats{}
+	}
+	var out io.Writer = writer
+	if opts.countOnly {
+		out = io.Discard
+	}
+	stopProgress := func() {}
+	if opts.showProgress {
+		stopProgress = startQueryProgress(os.Stderr, query.FilePath, stats)
 	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "close index file: %v\n", err)
+
+	start := time.Now()
+	switch {
+	case opts.follow:
+		err = followQuery(query, writer, stats, opts.fromStart)
+	case counting:
+		// A cached result doesn't say how many rows it holds
+		err = engine.ExecuteWithStats(query, out, stats)
+	default:
+		err = executeCached(query, out, stats, opts)
+	}
+	stopProgress()
+	if err != nil {
+		writer.Abort()
+		return fmt.Errorf("execution error: %w", err)
+	}
+	if opts.countOnly {
+		fmt.Fprintln(writer, stats.RowsReturned.Load())
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("flush output: %w", err)
+	}
+
+	if n := rejectedRows(stats); n > 0 {
+		if query.RejectPath != "" {
+			fmt.Fprintf(os.Stderr, "skipped %d malformed rows (written to %s)\n", n, query.RejectPath)
+		} else {
+			fmt.Fprintf(os.Stderr, "skipped %d malformed rows\n", n)
 		}
-	}()
+	}
 
-	if err := sidx.WriteIndex(f, index); err != nil {
-		return fmt.Errorf("write index: %w", err)
+	// CREATE TABLE indexes the new file right away so the next query over it
+	// is pruned like any other indexed CSV
+	if query.CreateTable {
+		res := buildIndex(outPath, indexOptions{parallel: true, numbers: query.Numbers})
+		if res.err != nil {
+			return fmt.Errorf("index %s: %w", outPath, res.err)
+		}
 	}
 
-	fmt.Fprintf(os.Stderr, "Index written to %s (%d blocks)\n", indexPath, index.Header.NumBlocks)
+	// Stats go to stderr so they never mix with the CSV on stdout
+	if opts.showStats {
+		if err := writeStats(os.Stderr, stats, time.Since(start), engine.SourceOrder(query)); err != nil {
+			fmt.Fprintf(os.Stderr, "write stats: %v\n", err)
+		}
+	}
+	if opts.exitMatch && stats.RowsReturned.Load() == 0 {
+		return errNoMatch
+	}
 	return nil
 }
 
+// errNoMatch is returned under --exit-match by a query that returned no rows
+var errNoMatch = errors.New("no rows matched")
+
+// rejectedRows returns the malformed rows a query dropped
+func rejectedRows(stats *engine.Stats) int64 {
+	if stats == nil {
+		return 0
+	}
+	return stats.RowsRejected.Load()
+}
+
+// runScript executes the semicolon-separated statements in a file in order.
+// Result sets written to stdout are separated by a blank line. The first
+// failing statement stops the script unless continueOnError is set; the exit
+// code is 1 if any statement failed.
+func runScript(path string, opts queryOptions, continueOnError bool) int {
+	if opts.outPath != "" {
+		fmt.Fprintln(os.Stderr, "--out cannot be combined with -f; use INTO or CREATE TABLE per statement")
+		return 1
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read script: %v\n", err)
+		return 1
+	}
+
+	status := 0
+	printed := false
+	for i, stmt := range sqlparser.SplitStatements(string(data)) {
+		// Statements with INTO or CREATE TABLE write elsewhere and need no separator
+		if query, err := sqlparser.Parse(stmt); err == nil && query.OutputPath == "" {
+			if printed {
+				fmt.Fprintln(os.Stdout)
+			}
+			printed = true
+		}
+		if err := runQuery(stmt, opts); err != nil {
+			exitOnBrokenPipe(err)
+			fmt.Fprintf(os.Stderr, "statement %d: %v\n", i+1, err)
+			status = 1
+			if !continueOnError {
+				break
+			}
+		}
+	}
+	return status
+}
+
 func getQueryFromArgsOrStdin(args []string, stdin io.Reader) (string, error) {
 	if len(args) > 0 {
 		return strings.TrimSpace(strings.Join(args, " ")), nil
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputFileBufferSize buffers results written to a file; stdout keeps a
+// small buffer so rows still appear promptly when watched interactively
+const outputFileBufferSize = 1 << 20
+
+// output is where query results go: stdout, or a file named by --out or
+// SELECT ... INTO, gzip-compressed when the name ends in .gz
+type output struct {
+	*bufio.Writer
+	file *os.File     // nil for stdout
+	gz   *gzip.Writer // nil unless compressing
+	path string       // Renamed to from file by Close; empty when writing in place
+	mode os.FileMode  // Permissions path gets
+}
+
+// openOutput opens path for results, or stdout when path is empty. A file
+// is written under a temporary name in its directory and renamed over path
+// by Close, so a failed query leaves an existing file (and the .sidx of a
+// table) as it was. With inPlace, as --follow needs for rows to show up
+// when flushed, path is truncated and written directly, and so is a path
+// that isn't a regular file (/dev/stdout, a named pipe, a symlink).
+func openOutput(path string, inPlace bool) (*output, error) {
+	if path == "" {
+		useStdout()
+		return &output{Writer: bufio.NewWriter(os.Stdout)}, nil
+	}
+
+	// CreateTemp makes the file private; keep the mode of the file being
+	// replaced, or use what os.Create gives under the usual umask
+	o := &output{mode: 0644}
+	if info, err := os.Lstat(path); err == nil {
+		inPlace = inPlace || !info.Mode().IsRegular()
+		o.mode = info.Mode().Perm()
+	}
+	if inPlace {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		o.file = f
+	} else {
+		f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+		if err != nil {
+			return nil, err
+		}
+		o.file, o.path = f, path
+		// Early exits (a closed pipe, a signal) skip Abort
+		tmp := f.Name()
+		onExit(func() { os.Remove(tmp) })
+	}
+	var w io.Writer = o.file
+	if strings.EqualFold(filepath.Ext(path), ".gz") {
+		o.gz = gzip.NewWriter(o.file)
+		w = o.gz
+	}
+	o.Writer = bufio.NewWriterSize(w, outputFileBufferSize)
+	return o, nil
+}
+
+// Close flushes buffered results and finishes the file, replacing path
+// with it. A file that can't be finished is removed.
+func (o *output) Close() error {
+	if o.file == nil {
+		// Released first: a reader leaving once it has every result must
+		// not end the process before a script's next statement runs. One
+		// leaving mid-flush still fails it with EPIPE.
+		releaseStdout()
+		return o.Flush()
+	}
+	err := o.Flush()
+	if o.gz != nil {
+		if cerr := o.gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if o.path != "" && err == nil {
+		err = o.file.Chmod(o.mode)
+	}
+	if cerr := o.file.Close(); err == nil {
+		err = cerr
+	}
+	if o.path != "" {
+		if err == nil {
+			err = os.Rename(o.file.Name(), o.path)
+		}
+		if err != nil {
+			os.Remove(o.file.Name())
+		}
+	}
+	return err
+}
+
+// Abort discards a partially written output file after a failed query,
+// leaving any earlier file at path alone.
+func (o *output) Abort() {
+	if o.file == nil {
+		releaseStdout()
+		return
+	}
+	o.file.Close()
+	os.Remove(o.file.Name())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestFailedQueryKeepsOutput checks that a query failing after its output
+// was opened leaves an existing output file as it was
+func TestFailedQueryKeepsOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the binary; skipped with -short")
+	}
+	bin := buildBinary(t)
+
+	// Each case first writes its output with a query that succeeds
+	tests := []struct {
+		name    string
+		earlier []string
+		args    []string
+	}{
+		{
+			name:    "create table",
+			earlier: []string{"CREATE TABLE t.csv AS SELECT * FROM data.csv WHERE id = 1"},
+			args:    []string{"CREATE TABLE t.csv AS SELECT missing FROM data.csv"},
+		},
+		{
+			name:    "out",
+			earlier: []string{"--out", "out.csv", "SELECT * FROM data.csv"},
+			args:    []string{"--out", "out.csv", "SELECT missing FROM data.csv"},
+		},
+		{
+			name:    "out gzip",
+			earlier: []string{"--out", "out.csv.gz", "SELECT * FROM data.csv"},
+			args:    []string{"--out", "out.csv.gz", "SELECT * FROM data.csv WHERE missing = 1"},
+		},
+		{
+			name:    "into",
+			earlier: []string{"SELECT name INTO 'out.csv' FROM data.csv"},
+			// Fails on the ragged last row, after writing the others
+			args: []string{"--on-error", "strict", "SELECT name INTO 'out.csv' FROM data.csv"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "data.csv"), []byte("id,name\n1,a\n2,b\n3,c,ragged\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			earlier := exec.Command(bin, tt.earlier...)
+			earlier.Dir = dir
+			if out, err := earlier.CombinedOutput(); err != nil {
+				t.Fatalf("earlier run: %v\n%s", err, out)
+			}
+			before := readDir(t, dir)
+
+			cmd := exec.Command(bin, tt.args...)
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err == nil {
+				t.Fatalf("query succeeded:\n%s", out)
+			}
+			after := readDir(t, dir)
+			for name, data := range before {
+				if after[name] != data {
+					t.Errorf("%s changed from %q to %q", name, data, after[name])
+				}
+			}
+			for name := range after {
+				if _, ok := before[name]; !ok {
+					t.Errorf("%s left behind", name)
+				}
+			}
+		})
+	}
+}
+
+// readDir returns the content of each file in dir by name
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
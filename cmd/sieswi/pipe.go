@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// brokenPipeStatus is the status a shell reports for a process killed by
+// SIGPIPE (128+13), as grep is when head stops reading
+const brokenPipeStatus = 141
+
+// exitOnBrokenPipe ends the process quietly when err came from writing to
+// a pipe nobody reads any more: the reader left on purpose, so it isn't a
+// failure worth a message
+func exitOnBrokenPipe(err error) {
+	if errors.Is(err, syscall.EPIPE) {
+		exit(brokenPipeStatus)
+	}
+}
+
+var (
+	stdoutWatch sync.Once
+	stdoutMu    sync.Mutex
+	stdoutUsers int  // Outputs open on stdout
+	stdoutGone  bool // The reader of a piped stdout went away
+)
+
+// useStdout marks stdout as in use by an output until releaseStdout, and
+// watches it so the process ends as soon as the reader of a piped stdout
+// goes away, rather than scanning on until the next flush finds out. Writes
+// to a closed stdout would end the process anyway; this only makes it
+// prompt, including while a selective filter writes nothing for a while.
+// Only a statement writing to stdout is ended: a later statement of a
+// script writing a file runs on, and one writing to stdout ends at once.
+func useStdout() {
+	stdoutWatch.Do(func() {
+		go func() {
+			if waitStdoutClosed() {
+				stdoutMu.Lock()
+				defer stdoutMu.Unlock()
+				stdoutGone = true
+				if stdoutUsers > 0 {
+					exit(brokenPipeStatus)
+				}
+			}
+		}()
+	})
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	if stdoutGone {
+		exit(brokenPipeStatus)
+	}
+	stdoutUsers++
+}
+
+// releaseStdout ends a use of stdout begun by useStdout
+func releaseStdout() {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	stdoutUsers--
+}
+
+var (
+	exitMu    sync.Mutex
+	exitHooks []func()
+	signals   sync.Once
+)
+
+// onExit registers fn to run when the process ends through exit, which
+// the early exits on a broken pipe and on SIGINT, SIGTERM, or SIGPIPE also
+// go through. Use it for files that must not outlive the process.
+func onExit(fn func()) {
+	signals.Do(func() {
+		// Catching SIGPIPE too keeps Go from killing the process on a
+		// write to a closed stdout; the write fails with EPIPE instead
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM, syscall.SIGPIPE)
+		go func() {
+			sig := <-ch
+			exit(128 + int(sig.(syscall.Signal)))
+		}()
+	})
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exitHooks = append(exitHooks, fn)
+}
+
+// exit runs the onExit hooks, once, and ends the process with status
+func exit(status int) {
+	exitMu.Lock()
+	hooks := exitHooks
+	exitHooks = nil
+	for _, fn := range hooks {
+		fn()
+	}
+	// Still holding exitMu: a second caller waits here until the first
+	// has cleaned up and exited
+	os.Exit(status)
+}
@@ -0,0 +1,41 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// waitStdoutClosed blocks until the read end of stdout is closed. It
+// returns false right away when stdout isn't a pipe or can't be watched.
+func waitStdoutClosed() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+		return false
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(epfd)
+
+	// A pipe reports EPOLLERR to its writer once no reader is left
+	event := syscall.EpollEvent{Events: syscall.EPOLLERR, Fd: int32(syscall.Stdout)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, syscall.Stdout, &event); err != nil {
+		return false
+	}
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		n, err := syscall.EpollWait(epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return false
+		}
+		if n > 0 && events[0].Events&syscall.EPOLLERR != 0 {
+			return true
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// waitStdoutClosed can't watch stdout on this platform; a closed pipe is
+// noticed on the next write instead
+func waitStdoutClosed() bool {
+	return false
+}
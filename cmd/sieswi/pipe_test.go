@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestClosedStdoutSparesFileStatements checks that a script whose stdout
+// reader leaves after one statement still finishes a later statement
+// writing a file, rather than ending halfway through it
+func TestClosedStdoutSparesFileStatements(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the binary; skipped with -short")
+	}
+	bin := buildBinary(t)
+	dir := t.TempDir()
+	var data strings.Builder
+	data.WriteString("id,name\n")
+	const rows = 300_000
+	for i := 1; i <= rows; i++ {
+		fmt.Fprintf(&data, "%d,name%d\n", i, i)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data.csv"), []byte(data.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := "SELECT name FROM data.csv WHERE id = 1;\n" +
+		"CREATE TABLE big.csv AS SELECT * FROM data.csv;\n" +
+		"SELECT name FROM data.csv WHERE id = 2;\n"
+	if err := os.WriteFile(filepath.Join(dir, "script.sql"), []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(bin, "-f", "script.sql")
+	cmd.Dir = dir
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Read the first result, as head -2 would, and go away
+	reader := bufio.NewReader(stdout)
+	for i := 0; i < 2; i++ {
+		if _, err := reader.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stdout.Close()
+
+	// The last statement finds stdout closed, unless it was quicker
+	var exit *exec.ExitError
+	if err := cmd.Wait(); err != nil && !(errors.As(err, &exit) && exit.ExitCode() == brokenPipeStatus) {
+		t.Fatalf("exit: %v", err)
+	}
+	table, err := os.ReadFile(filepath.Join(dir, "big.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(table), "\n"); got != rows+1 {
+		t.Errorf("big.csv has %d lines, want %d", got, rows+1)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.csv.sidx")); err != nil {
+		t.Errorf("table not indexed: %v", err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, ".*.tmp-*")); len(left) > 0 {
+		t.Errorf("temporary files left behind: %v", left)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/melihbirim/sieswi/internal/engine"
+)
+
+// progressRedrawInterval throttles progress bar updates
+const progressRedrawInterval = 100 * time.Millisecond
+
+// progressLogInterval spaces the lines of progress logged to a non-terminal
+const progressLogInterval = 10 * time.Second
+
+// isTerminal reports whether f is attached to an interactive terminal.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar renders a single-line, periodically refreshed status bar to a terminal.
+// Work is tracked as a number of units (e.g. bytes) split across tasks so
+// concurrent jobs can report independently. All methods are safe for concurrent use.
+type progressBar struct {
+	mu       sync.Mutex
+	out      io.Writer
+	label    string // Unit for the counter, e.g. "rows"
+	total    int64  // Total units across all tasks (0 if unknown)
+	done     []int64
+	counts   []uint64
+	finished int
+	start    time.Time
+	lastDraw time.Time
+	width    int // Width of the last line drawn, for clearing
+	interval time.Duration
+	plain    bool // Log a line per update instead of redrawing one, for non-terminals
+}
+
+func newProgressBar(out io.Writer, label string, total int64, tasks int) *progressBar {
+	return &progressBar{
+		out:      out,
+		label:    label,
+		total:    total,
+		done:     make([]int64, tasks),
+		counts:   make([]uint64, tasks),
+		start:    time.Now(),
+		interval: progressRedrawInterval,
+	}
+}
+
+// newProgressLog is a progressBar for logs and pipes: every
+// progressLogInterval it writes the status as a line of its own.
+func newProgressLog(out io.Writer, label string, total int64, tasks int) *progressBar {
+	p := newProgressBar(out, label, total, tasks)
+	p.interval, p.plain = progressLogInterval, true
+	p.lastDraw = p.start // Quick jobs log nothing
+	return p
+}
+
+// update records the units done and items counted so far for one task.
+func (p *progressBar) update(task int, done int64, count uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[task] = done
+	p.counts[task] = count
+	if time.Since(p.lastDraw) >= p.interval {
+		p.draw()
+	}
+}
+
+// finishTask marks one task complete.
+func (p *progressBar) finishTask() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished++
+	if !p.plain {
+		p.draw()
+	}
+}
+
+// close draws the final state and moves to a fresh line. A log has
+// nothing to clear, and the caller reports the outcome.
+func (p *progressBar) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.plain {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(p.out)
+}
+
+// draw renders the bar; callers must hold p.mu.
+func (p *progressBar) draw() {
+	p.lastDraw = time.Now()
+
+	var done int64
+	var count uint64
+	for i := range p.done {
+		done += p.done[i]
+		count += p.counts[i]
+	}
+
+	elapsed := time.Since(p.start)
+	fraction := 0.0
+	if p.total > 0 {
+		fraction = float64(done) / float64(p.total)
+		if fraction > 1 {
+			fraction = 1
+		}
+	}
+
+	const barWidth = 30
+	filled := int(fraction * barWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(count) / elapsed.Seconds()
+	}
+
+	eta := "--"
+	if fraction > 0 && fraction < 1 {
+		remaining := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+		eta = remaining.Round(time.Second).String()
+	} else if fraction >= 1 {
+		eta = "0s"
+	}
+
+	line := fmt.Sprintf("[%s] %5.1f%% | %s %s | %s %s/s | ETA %s",
+		bar, fraction*100, formatCount(count), p.label, formatCount(uint64(rate)), p.label, eta)
+	if len(p.done) > 1 {
+		line += fmt.Sprintf(" | %d/%d files", p.finished, len(p.done))
+	}
+
+	if p.plain {
+		fmt.Fprintln(p.out, line)
+		return
+	}
+	pad := ""
+	if p.width > len(line) {
+		pad = strings.Repeat(" ", p.width-len(line))
+	}
+	p.width = len(line)
+	fmt.Fprintf(p.out, "\r%s%s", line, pad)
+}
+
+// startQueryProgress draws a progress bar for a running query from its stats:
+// input bytes read or skipped against the file size, and rows matched. The
+// returned func draws the final state and stops the bar.
+func startQueryProgress(out io.Writer, path string, stats *engine.Stats) func() {
+	var total int64
+	if stat, err := os.Stat(path); err == nil && stat.Mode().IsRegular() {
+		total = stat.Size() // Unknown for stdin
+	}
+	bar := newProgressBar(out, "matched", total, 1)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressRedrawInterval)
+		defer ticker.Stop()
+		for {
+			bar.update(0, stats.BytesDone(), uint64(stats.RowsMatched.Load()))
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		bar.update(0, stats.BytesDone(), uint64(stats.RowsMatched.Load()))
+		bar.close()
+	}
+}
+
+// formatCount renders large counts compactly (e.g. 1.2M).
+func formatCount(n uint64) string {
+	switch {
+	case n >= 1_000_000_000:
+		return fmt.Sprintf("%.1fG", float64(n)/1e9)
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 10_000:
+		return fmt.Sprintf("%.1fK", float64(n)/1e3)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
+// formatBytes renders a byte count with a binary unit suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
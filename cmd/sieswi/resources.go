@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// resourceFlags holds --threads, --max-memory, and --io-buffer-size as given
+type resourceFlags struct {
+	threads      string
+	maxMemory    string
+	ioBufferSize string
+}
+
+// apply checks the flags, caps the process at the threads and memory they
+// allow, and returns the limits each query passes to the engine
+func (f resourceFlags) apply() (sqlparser.Resources, error) {
+	var r sqlparser.Resources
+	if f.threads != "" {
+		n, err := strconv.Atoi(f.threads)
+		if err != nil || n < 1 {
+			return r, fmt.Errorf("--threads %q: want a positive number", f.threads)
+		}
+		r.Threads = n
+		runtime.GOMAXPROCS(n)
+	}
+	if f.maxMemory != "" {
+		n, err := parseByteSize(f.maxMemory)
+		if err != nil {
+			return r, fmt.Errorf("--max-memory: %w", err)
+		}
+		// A soft limit: the garbage collector works harder as the heap
+		// nears it, rather than the query failing
+		r.MaxMemory = n
+		debug.SetMemoryLimit(n)
+	}
+	if f.ioBufferSize != "" {
+		n, err := parseByteSize(f.ioBufferSize)
+		if err != nil || n > 1<<30 {
+			return r, fmt.Errorf("--io-buffer-size %q: want a size up to 1GB", f.ioBufferSize)
+		}
+		r.IOBufferSize = int(n)
+	}
+	return r, nil
+}
+
+// parseByteSize reads a size such as 512MB, 64K, or 1048576, with binary
+// units (1K = 1024 bytes) as formatBytes prints them
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	shift := 0
+	if i := len(s) - 1; i >= 0 {
+		if u := strings.IndexByte("KMGT", s[i]); u >= 0 {
+			shift, s = 10*(u+1), s[:i]
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 || n > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 512MB or 64KB)", value)
+	}
+	return n << shift, nil
+}
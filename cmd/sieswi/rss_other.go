@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// peakRSSBytes is unavailable on this platform
+func peakRSSBytes() (int64, bool) {
+	return 0, false
+}
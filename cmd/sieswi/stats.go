@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/melihbirim/sieswi/internal/engine"
+)
+
+// queryStats is the --stats JSON summary of a query run. Durations are in
+// milliseconds; peak_rss_bytes is omitted where the platform can't report it.
+type queryStats struct {
+	RowsScanned  int64   `json:"rows_scanned"`
+	RowsMatched  int64   `json:"rows_matched"`
+	RowsReturned int64   `json:"rows_returned"`
+	RowsRejected int64   `json:"rows_rejected"`
+	BytesRead    int64   `json:"bytes_read"`
+	BytesSkipped int64   `json:"bytes_skipped"`
+	BlocksTotal  int64   `json:"blocks_total"`
+	BlocksPruned int64   `json:"blocks_pruned"`
+	ParseMs      float64 `json:"parse_ms"`
+	FilterMs     float64 `json:"filter_ms"`
+	OutputMs     float64 `json:"output_ms"`
+	WallMs       float64 `json:"wall_ms"`
+	PeakRSSBytes int64   `json:"peak_rss_bytes,omitempty"`
+	SourceOrder  bool    `json:"source_order"` // Rows came out in input order
+}
+
+// writeStats writes the stats of a finished query as one line of JSON
+func writeStats(out io.Writer, stats *engine.Stats, wall time.Duration, sourceOrder bool) error {
+	summary := queryStats{
+		RowsScanned:  stats.RowsScanned.Load(),
+		RowsMatched:  stats.RowsMatched.Load(),
+		RowsReturned: stats.RowsReturned.Load(),
+		RowsRejected: stats.RowsRejected.Load(),
+		BytesRead:    stats.BytesRead.Load(),
+		BytesSkipped: stats.BytesSkipped.Load(),
+		BlocksTotal:  stats.BlocksTotal.Load(),
+		BlocksPruned: stats.BlocksPruned.Load(),
+		ParseMs:      millis(time.Duration(stats.ParseNanos.Load())),
+		FilterMs:     millis(time.Duration(stats.FilterNanos.Load())),
+		OutputMs:     millis(time.Duration(stats.OutputNanos.Load())),
+		WallMs:       millis(wall),
+		SourceOrder:  sourceOrder,
+	}
+	if rss, ok := peakRSSBytes(); ok {
+		summary.PeakRSSBytes = rss
+	}
+	return json.NewEncoder(out).Encode(summary)
+}
+
+// millis converts d to milliseconds rounded to microseconds
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/sidx"
+)
+
+// upgradeOptions returns the options `sieswi index --upgrade` rebuilds
+// csvPath's index with: the ones recorded in the index and its key maps,
+// plus the parallelism of base. current is true when the index is already
+// in this binary's format and valid for the CSV, so it is left alone.
+func upgradeOptions(csvPath string, base indexOptions) (opts indexOptions, from uint32, current bool, err error) {
+	f, err := os.Open(csvPath + ".sidx")
+	if os.IsNotExist(err) {
+		return opts, 0, false, fmt.Errorf("no index to upgrade; build one with 'sieswi index'")
+	}
+	if err != nil {
+		return opts, 0, false, fmt.Errorf("open index: %w", err)
+	}
+	defer f.Close()
+	index, err := sidx.ReadIndexLazy(bufio.NewReader(f))
+	if err != nil {
+		// Without a readable header there are no options to reuse
+		return opts, 0, false, fmt.Errorf("read index: %w", err)
+	}
+	h := &index.Header
+	if h.Version == sidx.Version && sidx.ValidateIndex(index, csvPath) == nil {
+		return opts, h.Version, true, nil
+	}
+
+	// The header mode and dialect are kept, not sniffed again; indexes
+	// before v16 read plain CSV, recorded as the zero dialect
+	opts = indexOptions{
+		parallel:          base.parallel,
+		workers:           base.workers,
+		progress:          base.progress,
+		blockSize:         h.BlockSize,
+		skipTypeInference: h.BuildFlags&sidx.BuildFlagSkipTypeInference != 0,
+		noHeader:          h.BuildFlags&sidx.BuildFlagNoHeader != 0,
+		header:            h.BuildFlags&sidx.BuildFlagNoHeader == 0,
+		dialect:           csvio.Dialect{Delimiter: h.Dialect.Comma(), Quote: h.Dialect.QuoteChar()},
+		numbers:           h.Numbers,
+		compress:          h.Compressed,
+		boundLength:       h.BoundLength,
+	}
+	if h.BuildFlags&sidx.BuildFlagLatin1 != 0 {
+		opts.encoding = csvio.EncodingLatin1
+	}
+
+	// Before v5 there were no build flags; a subset shows in the ordinals
+	partial := h.BuildFlags&sidx.BuildFlagPartialColumns != 0
+	var names []string
+	for i, col := range h.Columns {
+		partial = partial || h.Version < 5 && col.Ordinal != uint32(i)
+		if col.Sums {
+			opts.sums = append(opts.sums, col.Name)
+		}
+		for len(names) <= int(col.Ordinal) {
+			names = append(names, fmt.Sprintf("c%d", len(names)+1))
+		}
+		names[col.Ordinal] = col.Name
+	}
+	if partial {
+		for _, col := range h.Columns {
+			opts.columns = append(opts.columns, col.Name)
+		}
+	}
+	if opts.noHeader {
+		opts.headerNames = names
+	}
+
+	if opts.keys, opts.pairs, err = sidx.KeyMapColumns(csvPath); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s.skey can't be read, so its key maps aren't rebuilt: %v\n", csvPath, err)
+	}
+	return opts, h.Version, false, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// defaultWatchInterval is how often --watch checks its files for changes
+const defaultWatchInterval = time.Second
+
+// fileState is what --watch compares to notice a file changed
+type fileState struct {
+	size    int64
+	modTime time.Time
+	missing bool
+}
+
+func (s fileState) same(other fileState) bool {
+	return s.size == other.size && s.modTime.Equal(other.modTime) && s.missing == other.missing
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{missing: true}
+	}
+	return fileState{size: info.Size(), modTime: info.ModTime()}
+}
+
+// watchQuery runs a query, then polls the files it reads (FROM and any
+// subqueries) and runs it again whenever one changes, until interrupted.
+// Result sets on stdout are separated by a blank line. Execution errors,
+// such as a file caught mid-rewrite, are reported and the watch goes on.
+func watchQuery(queryText string, opts queryOptions, interval time.Duration) error {
+	query, err := sqlparser.Parse(queryText)
+	if err != nil {
+		return fmt.Errorf("parse error: %w", err)
+	}
+	if query.CreateTable {
+		return fmt.Errorf("--watch cannot be combined with CREATE TABLE")
+	}
+	queries := []*sqlparser.Query{&query}
+	queries = append(queries, sqlparser.Subqueries(query.Where)...)
+	for _, filter := range query.Filters {
+		queries = append(queries, sqlparser.Subqueries(filter)...)
+	}
+	var paths []string
+	for _, q := range queries {
+		if err := useTable(q, opts.tables); err != nil {
+			return err
+		}
+		if q.FilePath == "-" || q.FilePath == "stdin" {
+			return fmt.Errorf("--watch needs files; stdin can't be read twice")
+		}
+		if info, err := os.Stat(q.FilePath); err == nil && !info.Mode().IsRegular() {
+			return fmt.Errorf("--watch needs regular files; %s can't be read twice", q.FilePath)
+		}
+		paths = append(paths, q.FilePath)
+	}
+
+	states := make([]fileState, len(paths))
+	for run := 0; ; run++ {
+		for i, path := range paths {
+			states[i] = statFile(path)
+		}
+		if run > 0 && opts.outPath == "" && query.OutputPath == "" {
+			fmt.Fprintln(os.Stdout)
+		}
+		if err := runQuery(queryText, opts); err != nil {
+			exitOnBrokenPipe(err)
+			fmt.Fprintf(os.Stderr, "%s: %v\n", time.Now().Format(time.TimeOnly), err)
+		}
+
+		for changed := false; !changed; {
+			time.Sleep(interval)
+			for i, path := range paths {
+				changed = changed || !statFile(path).same(states[i])
+			}
+		}
+	}
+}
+
+// parseWatchInterval reads the DURATION of --watch=DURATION
+func parseWatchInterval(value string) (time.Duration, error) {
+	interval, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("--watch: invalid interval %q (want e.g. 500ms or 2s)", value)
+	}
+	return interval, nil
+}
@@ -0,0 +1,212 @@
+// Package catalog maps table names to CSV files so queries can say
+// FROM orders instead of a quoted path.
+//
+// The registry is read from a small YAML subset:
+//
+//	tables:
+//	  orders: /data/orders.csv       # shorthand: path only
+//	  users:
+//	    path: exports/users.csv      # relative to the config file
+//	    delimiter: ","
+//	    header: false
+//	    columns: id, name, email     # names for a file without a header row
+package catalog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultFile is looked up in the home directory when no --config is given.
+const DefaultFile = ".sieswi.yaml"
+
+// Table describes one registered CSV file.
+type Table struct {
+	Name      string
+	Path      string
+	Delimiter byte     // Sniffed from the file (0) unless configured
+	Header    bool     // First row holds column names (default true)
+	Columns   []string // Column names when Header is false
+}
+
+// Catalog is a set of named tables. A nil Catalog has no tables.
+type Catalog struct {
+	tables map[string]Table
+}
+
+// DefaultPath returns ~/.sieswi.yaml, or "" if the home directory is unknown.
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, DefaultFile)
+}
+
+// Load reads a registry file. Relative table paths are resolved against the
+// directory holding the file.
+func Load(path string) (*Catalog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := Parse(f, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse reads a registry from r, resolving relative paths against baseDir.
+func Parse(r io.Reader, baseDir string) (*Catalog, error) {
+	c := &Catalog{tables: make(map[string]Table)}
+	scanner := bufio.NewScanner(r)
+
+	inTables := false
+	var current *Table
+	tableIndent := -1
+	lineNum := 0
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if current.Path == "" {
+			return fmt.Errorf("table %q has no path", current.Name)
+		}
+		if !filepath.IsAbs(current.Path) {
+			current.Path = filepath.Join(baseDir, current.Path)
+		}
+		c.tables[current.Name] = *current
+		current = nil
+		return nil
+	}
+
+	for scanner.Scan() {
+		lineNum++
+		line := stripComment(scanner.Text())
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", lineNum)
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNum)
+		}
+		key = strings.TrimSpace(key)
+		value = unquote(strings.TrimSpace(value))
+
+		switch {
+		case indent == 0:
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			inTables = key == "tables"
+			if !inTables {
+				return nil, fmt.Errorf("line %d: unknown section %q", lineNum, key)
+			}
+			tableIndent = -1
+		case !inTables:
+			return nil, fmt.Errorf("line %d: unexpected indentation", lineNum)
+		case tableIndent < 0 || indent == tableIndent:
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			tableIndent = indent
+			current = &Table{Name: key, Path: value, Header: true}
+			if value != "" {
+				if err := finish(); err != nil {
+					return nil, err
+				}
+			}
+		case indent > tableIndent && current != nil:
+			if err := current.set(key, value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unexpected indentation", lineNum)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (t *Table) set(key, value string) error {
+	switch key {
+	case "path":
+		t.Path = value
+	case "delimiter":
+		if value == `\t` {
+			value = "\t"
+		}
+		if len(value) != 1 {
+			return fmt.Errorf("table %q: delimiter must be a single character", t.Name)
+		}
+		t.Delimiter = value[0]
+	case "header":
+		header, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("table %q: header must be true or false", t.Name)
+		}
+		t.Header = header
+	case "columns":
+		t.Columns = nil
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				t.Columns = append(t.Columns, name)
+			}
+		}
+	default:
+		return fmt.Errorf("table %q: unknown option %q", t.Name, key)
+	}
+	return nil
+}
+
+// Lookup returns the table registered under name.
+func (c *Catalog) Lookup(name string) (Table, bool) {
+	if c == nil {
+		return Table{}, false
+	}
+	t, ok := c.tables[name]
+	return t, ok
+}
+
+// stripComment drops a trailing # comment that is outside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
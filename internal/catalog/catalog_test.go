@@ -0,0 +1,86 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	config := `
+# nightly report sources
+tables:
+  orders: /data/orders.csv   # shorthand
+  users:
+    path: "exports/users #1.csv"
+    delimiter: '\t'
+    header: false
+    columns: id, name
+  events:
+    path: /var/log/events.csv
+`
+	c, err := Parse(strings.NewReader(config), "/home/me")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+
+	tests := []Table{
+		{Name: "orders", Path: "/data/orders.csv", Header: true},
+		{Name: "users", Path: "/home/me/exports/users #1.csv", Delimiter: '\t', Header: false, Columns: []string{"id", "name"}},
+		{Name: "events", Path: "/var/log/events.csv", Header: true},
+	}
+	for _, want := range tests {
+		got, ok := c.Lookup(want.Name)
+		if !ok {
+			t.Fatalf("Lookup(%q) not found", want.Name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Lookup(%q) = %+v, want %+v", want.Name, got, want)
+		}
+	}
+	if _, ok := c.Lookup("missing"); ok {
+		t.Error("Lookup(missing) should fail")
+	}
+	if _, ok := (*Catalog)(nil).Lookup("orders"); ok {
+		t.Error("nil catalog should have no tables")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"unknown section", "views:\n  a: b.csv\n"},
+		{"missing path", "tables:\n  orders:\n    header: true\n"},
+		{"unknown option", "tables:\n  orders:\n    path: a.csv\n    quote: x\n"},
+		{"bad header", "tables:\n  orders:\n    path: a.csv\n    header: maybe\n"},
+		{"bad delimiter", "tables:\n  orders:\n    path: a.csv\n    delimiter: ';;'\n"},
+		{"no colon", "tables:\n  orders\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.config), "/"); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestLoadResolvesRelativeToConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, DefaultFile)
+	if err := os.WriteFile(path, []byte("tables:\n  orders: orders.csv\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	table, _ := c.Lookup("orders")
+	if want := filepath.Join(dir, "orders.csv"); table.Path != want {
+		t.Errorf("path = %q, want %q", table.Path, want)
+	}
+}
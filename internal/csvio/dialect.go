@@ -0,0 +1,342 @@
+package csvio
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Dialect is how a CSV file separates and quotes its fields. Zero fields
+// stand for RFC 4180's comma and double quote, so the zero Dialect reads
+// plain CSV; Sniff fills in the ones a caller leaves open.
+type Dialect struct {
+	Delimiter byte // Field separator
+	Quote     byte // Quote character: '"' or '\''
+}
+
+// Comma returns the field separator
+func (d Dialect) Comma() byte {
+	if d.Delimiter == 0 {
+		return ','
+	}
+	return d.Delimiter
+}
+
+// QuoteChar returns the quote character
+func (d Dialect) QuoteChar() byte {
+	if d.Quote == 0 {
+		return '"'
+	}
+	return d.Quote
+}
+
+// Same reports whether d and o read files alike, whether or not they
+// spell out the defaults
+func (d Dialect) Same(o Dialect) bool {
+	return d.Comma() == o.Comma() && d.QuoteChar() == o.QuoteChar()
+}
+
+// IsDefault reports whether d reads plain CSV
+func (d Dialect) IsDefault() bool {
+	return d.Same(Dialect{})
+}
+
+func (d Dialect) String() string {
+	name := string(d.Comma())
+	if d.Comma() == '\t' {
+		name = "tab"
+	}
+	return fmt.Sprintf("delimiter %q, quote %q", name, d.QuoteChar())
+}
+
+// ParseDelimiter reads a --delimiter value: a single ASCII character other
+// than a quote or line break, or "tab" (also written \t)
+func ParseDelimiter(s string) (byte, error) {
+	switch strings.ToLower(s) {
+	case "tab", `\t`, "\t":
+		return '\t', nil
+	}
+	if len(s) != 1 || s[0] >= 0x80 || s[0] == '"' || s[0] == '\'' || s[0] == '\n' || s[0] == '\r' {
+		return 0, fmt.Errorf("delimiter %q: want a single character such as , ; | or tab", s)
+	}
+	return s[0], nil
+}
+
+// ParseQuote reads a --quote value: " or '
+func ParseQuote(s string) (byte, error) {
+	switch strings.ToLower(s) {
+	case `"`, "double":
+		return '"', nil
+	case "'", "single":
+		return '\'', nil
+	}
+	return 0, fmt.Errorf("quote %q: want \" or '", s)
+}
+
+// SniffSize is how much of the start of a file is enough to sniff
+const SniffSize = 16 * 1024
+
+// sniffDelimiters are the separators Sniff considers, in order of
+// preference when several split the sample equally well
+const sniffDelimiters = ",;\t|"
+
+// sniffRecords bounds the records of the sample Sniff looks at
+const sniffRecords = 100
+
+// Sniff guesses the dialect of CSV input from sample, its first bytes,
+// and whether its first record is a header, much like Python's
+// csv.Sniffer. A sample of SniffSize bytes or more is taken to end part way
+// through a record. Fields given sets are kept, not guessed.
+//
+// Single quotes are the quote character when more fields open and close
+// with them than with double quotes. The delimiter is the candidate that
+// splits the most records into the same number of fields, at least two;
+// more fields break ties. The first record is a header unless columns whose
+// other values are all numbers hold a number there too: without evidence
+// either way, it is one.
+func Sniff(sample []byte, given Dialect) (Dialect, bool) {
+	complete := len(sample) < SniffSize
+	sample = TrimBOM(sample)
+
+	d := given
+	if d.Quote == 0 {
+		d.Quote = '"'
+		if quotedFields(sample, '\'') > quotedFields(sample, '"') {
+			d.Quote = '\''
+		}
+	}
+	records := sampleRecords(sample, d.Quote, complete)
+	if d.Delimiter == 0 {
+		d.Delimiter = ','
+		bestAgree, bestFields := 0, 1
+		for i := 0; i < len(sniffDelimiters); i++ {
+			candidate := Dialect{Delimiter: sniffDelimiters[i], Quote: d.Quote}
+			agree, fields := fieldAgreement(records, candidate)
+			if fields >= 2 && (agree > bestAgree || agree == bestAgree && fields > bestFields) {
+				d.Delimiter, bestAgree, bestFields = candidate.Delimiter, agree, fields
+			}
+		}
+	}
+	return d, sniffHeader(records, d)
+}
+
+// SniffFile sniffs the start of r, which is in the given encoding
+func SniffFile(r io.Reader, encoding string, given Dialect) (Dialect, bool, error) {
+	sample := make([]byte, SniffSize)
+	n, err := io.ReadFull(NewDecoder(r, encoding), sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return given, true, err
+	}
+	d, header := Sniff(sample[:n], given)
+	return d, header, nil
+}
+
+// quotedFields counts the fields in sample that quote opens and closes:
+// it follows a line break or a candidate delimiter, and its match (past
+// doubled quotes) comes before one. A stray apostrophe, as in O'Brien or
+// Excel's '0123, opens no field.
+func quotedFields(sample []byte, quote byte) int {
+	n := 0
+	for i := 0; i < len(sample); i++ {
+		if sample[i] != quote || i > 0 && !isFieldEdge(sample[i-1]) {
+			continue
+		}
+		j := i + 1
+		for {
+			k := bytes.IndexByte(sample[j:], quote)
+			if k < 0 {
+				return n
+			}
+			j += k
+			if j+1 < len(sample) && sample[j+1] == quote {
+				j += 2
+				continue
+			}
+			break
+		}
+		if j == len(sample)-1 || isFieldEdge(sample[j+1]) {
+			n++
+		}
+		i = j
+	}
+	return n
+}
+
+func isFieldEdge(c byte) bool {
+	return c == '\n' || c == '\r' || strings.IndexByte(sniffDelimiters, c) >= 0
+}
+
+// sampleRecords splits sample into records by the line rules with quote
+// as the quote character, leaving out blank lines, and the last record
+// unless the sample is complete
+func sampleRecords(sample []byte, quote byte, complete bool) [][]byte {
+	split := recordSplitter{quote: []byte{quote}}
+	var records [][]byte
+	for len(sample) > 0 && len(records) < sniffRecords {
+		size, n := split.split(sample, complete)
+		if size == 0 {
+			break
+		}
+		if n > 0 {
+			records = append(records, sample[:n])
+		}
+		sample = sample[size:]
+	}
+	return records
+}
+
+// fieldAgreement returns how many records split into the most common
+// number of fields under d, and that number
+func fieldAgreement(records [][]byte, d Dialect) (agree, fields int) {
+	counts := make(map[int]int)
+	for _, record := range records {
+		n := len(splitFields(record, d))
+		counts[n]++
+		if c := counts[n]; c > agree || c == agree && n > fields {
+			agree, fields = c, n
+		}
+	}
+	return agree, fields
+}
+
+// splitFields splits a record into fields, quotes removed. It's lenient
+// where encoding/csv isn't, which suits guessing.
+func splitFields(record []byte, d Dialect) []string {
+	comma, quote := d.Comma(), d.QuoteChar()
+	var fields []string
+	var field []byte
+	inQuote := false
+	for i := 0; i < len(record); i++ {
+		switch c := record[i]; {
+		case c == quote && inQuote && i+1 < len(record) && record[i+1] == quote:
+			field = append(field, quote)
+			i++
+		case c == quote:
+			inQuote = !inQuote
+		case c == comma && !inQuote:
+			fields = append(fields, string(field))
+			field = field[:0]
+		default:
+			field = append(field, c)
+		}
+	}
+	return append(fields, string(field))
+}
+
+// sniffHeader reports whether the first of records is a header: each
+// column whose other values are all numbers votes against it when it holds
+// a number there too, and for it otherwise
+func sniffHeader(records [][]byte, d Dialect) bool {
+	if len(records) < 2 {
+		return true
+	}
+	first := splitFields(records[0], d)
+	rows := make([][]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		rows = append(rows, splitFields(record, d))
+	}
+
+	votes := 0
+	for c, name := range first {
+		numeric, seen := true, false
+		for _, row := range rows {
+			if c >= len(row) || strings.TrimSpace(row[c]) == "" {
+				continue
+			}
+			seen = true
+			if !isSniffNumber(row[c]) {
+				numeric = false
+				break
+			}
+		}
+		if !numeric || !seen {
+			continue
+		}
+		if isSniffNumber(name) {
+			votes--
+		} else {
+			votes++
+		}
+	}
+	return votes >= 0
+}
+
+// isSniffNumber reports whether s reads as a number, with a decimal point
+// or a decimal comma
+func isSniffNumber(s string) bool {
+	s = strings.TrimSpace(s)
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	if strings.Count(s, ",") == 1 && !strings.Contains(s, ".") {
+		_, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+		return err == nil
+	}
+	return false
+}
+
+// RecordParser splits records, as a Scanner or RecordReader finds them and
+// without their line terminator, into fields with encoding/csv, reusing
+// one parser for every record
+type RecordParser struct {
+	buf     *bytes.Reader
+	parser  *csv.Reader
+	swap    bool   // Single-quoted input, parsed with the quotes exchanged
+	swapped []byte // The record being parsed, quotes exchanged
+}
+
+// NewRecordParser returns a RecordParser for records in dialect d
+func NewRecordParser(d Dialect) *RecordParser {
+	p := &RecordParser{buf: bytes.NewReader(nil), swap: d.QuoteChar() == '\''}
+	p.parser = csv.NewReader(p.buf)
+	p.parser.Comma = rune(d.Comma())
+	p.parser.FieldsPerRecord = -1
+	return p
+}
+
+// Parse returns the fields of record
+func (p *RecordParser) Parse(record []byte) ([]string, error) {
+	if p.swap {
+		p.swapped = append(p.swapped[:0], record...)
+		swapQuotes(p.swapped)
+		record = p.swapped
+	}
+	p.buf.Reset(record)
+	fields, err := p.parser.Read()
+	if err == nil && p.swap {
+		swapFieldQuotes(fields)
+	}
+	return fields, err
+}
+
+// swapQuotes exchanges single and double quotes in b, in place. encoding/csv
+// only knows double quotes, so input quoted with single quotes is parsed
+// with the two exchanged, and the fields exchanged back.
+func swapQuotes(b []byte) {
+	for i := bytes.IndexAny(b, `"'`); i >= 0; {
+		if b[i] == '"' {
+			b[i] = '\''
+		} else {
+			b[i] = '"'
+		}
+		next := bytes.IndexAny(b[i+1:], `"'`)
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+}
+
+// swapFieldQuotes undoes swapQuotes on the fields of a parsed record
+func swapFieldQuotes(record []string) {
+	for i, field := range record {
+		if strings.ContainsAny(field, `"'`) {
+			b := []byte(field)
+			swapQuotes(b)
+			record[i] = string(b)
+		}
+	}
+}
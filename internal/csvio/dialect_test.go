@@ -0,0 +1,101 @@
+package csvio
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample string
+		given  Dialect
+		want   Dialect
+		header bool
+	}{
+		{"comma", "id,name,amount\n1,a,10\n2,b,20\n", Dialect{}, Dialect{',', '"'}, true},
+		{"semicolon with decimal commas", "id;name;amount\n1;a;3,50\n2;b;4,25\n", Dialect{}, Dialect{';', '"'}, true},
+		{"tab", "id\tname\n1\tx, y\n2\tz\n", Dialect{}, Dialect{'\t', '"'}, true},
+		{"pipe", "a|b|c\nx|y|z\n", Dialect{}, Dialect{'|', '"'}, true},
+		{"quoted delimiters", "name,note\n\"a;b\",\"c;d\"\n\"e;f\",g\n", Dialect{}, Dialect{',', '"'}, true},
+		{"single quotes", "id,name\n1,'O''Brien, J'\n2,'Smith'\n", Dialect{}, Dialect{',', '\''}, true},
+		{"stray apostrophes", "id,name\n1,O'Brien\n2,D'Arcy\n", Dialect{}, Dialect{',', '"'}, true},
+		{"headerless numbers", "1,2,3\n4,5,6\n7,8,9\n", Dialect{}, Dialect{',', '"'}, false},
+		{"numeric names over text", "2023,2024\nfoo,bar\n", Dialect{}, Dialect{',', '"'}, true},
+		{"single column", "name\nalpha\nbeta\n", Dialect{}, Dialect{',', '"'}, true},
+		{"given delimiter", "a;b,c\n1;2,3\n", Dialect{Delimiter: ';'}, Dialect{';', '"'}, true},
+		{"empty", "", Dialect{}, Dialect{',', '"'}, true},
+	}
+	for _, tt := range tests {
+		d, header := Sniff([]byte(tt.sample), tt.given)
+		if d != tt.want || header != tt.header {
+			t.Errorf("%s: Sniff = %v, header %v; want %v, header %v", tt.name, d, header, tt.want, tt.header)
+		}
+	}
+
+	// A full sample ends part way through a record, which is left out
+	var sb strings.Builder
+	sb.WriteString("id;name\n")
+	for sb.Len() < SniffSize {
+		sb.WriteString("1;a,b,c,d,e,f\n")
+	}
+	sample := sb.String()[:SniffSize]
+	if d, _ := Sniff([]byte(sample), Dialect{}); d.Delimiter != ';' {
+		t.Errorf("truncated sample: delimiter %q, want ';'", d.Delimiter)
+	}
+}
+
+func TestParseDelimiterAndQuote(t *testing.T) {
+	for s, want := range map[string]byte{",": ',', ";": ';', "|": '|', "tab": '\t', `\t`: '\t', "\t": '\t'} {
+		if got, err := ParseDelimiter(s); err != nil || got != want {
+			t.Errorf("ParseDelimiter(%q) = %q, %v; want %q", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", ";;", `"`, "'", "\n", "é"} {
+		if _, err := ParseDelimiter(s); err == nil {
+			t.Errorf("ParseDelimiter(%q): expected an error", s)
+		}
+	}
+	if q, err := ParseQuote("'"); err != nil || q != '\'' {
+		t.Errorf("ParseQuote(') = %q, %v", q, err)
+	}
+	if _, err := ParseQuote("`"); err == nil {
+		t.Error("ParseQuote(`): expected an error")
+	}
+}
+
+func TestReadDialect(t *testing.T) {
+	input := "id;name;note\n1;'O''Brien; J';\"hi\"\n2;'two\nlines';x\n"
+	d := Dialect{Delimiter: ';', Quote: '\''}
+	want := [][]string{{"id", "name", "note"}, {"1", "O'Brien; J", `"hi"`}, {"2", "two\nlines", "x"}}
+
+	r := NewReader(strings.NewReader(input))
+	r.SetDialect(d)
+	var got [][]string
+	for {
+		record, err := r.Read()
+		if err != nil {
+			break
+		}
+		got = append(got, record)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reader: got %q, want %q", got, want)
+	}
+
+	// Records split by the dialect's quote parse the same way alone
+	rr := NewRecordReader(strings.NewReader(input))
+	rr.SetDialect(d)
+	parser := NewRecordParser(d)
+	for i := range want {
+		raw, err := rr.Next()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		fields, err := parser.Parse(TrimLineEnd(raw))
+		if err != nil || !reflect.DeepEqual(fields, want[i]) {
+			t.Errorf("RecordParser record %d = %q, %v; want %q", i, fields, err, want[i])
+		}
+	}
+}
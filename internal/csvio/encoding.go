@@ -0,0 +1,169 @@
+package csvio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Input encodings accepted by --encoding. Everything past the reader works
+// on UTF-8, so other encodings are decoded as the file is read.
+const (
+	EncodingUTF8   = ""
+	EncodingLatin1 = "latin1"
+	EncodingUTF16  = "utf16"
+)
+
+// ParseEncoding normalizes an --encoding name
+func ParseEncoding(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "utf8", "utf-8":
+		return EncodingUTF8, nil
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		return EncodingLatin1, nil
+	case "utf16", "utf-16", "utf-16le":
+		return EncodingUTF16, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q (want utf8, latin1, or utf16)", name)
+}
+
+// SameOffsets reports whether bytes of the encoding sit at the same offsets
+// as in the raw file, record for record: latin1 decodes byte by byte and
+// shares ASCII's commas, quotes, and newlines, so raw records can be split
+// and seeked to before decoding. UTF-16 files can only be read start to end.
+func SameOffsets(encoding string) bool {
+	return encoding != EncodingUTF16
+}
+
+// utf8BOM is the byte order mark some editors (notably Excel) write at the
+// start of UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// TrimBOM removes a leading UTF-8 byte order mark
+func TrimBOM(b []byte) []byte {
+	return bytes.TrimPrefix(b, utf8BOM)
+}
+
+// TrimFieldBOM removes a UTF-8 byte order mark from the start of a field,
+// for records read from the start of a file
+func TrimFieldBOM(field string) string {
+	return strings.TrimPrefix(field, string(utf8BOM))
+}
+
+// Decode returns raw, a piece of input in the given encoding, as UTF-8. For
+// UTF-8 input raw itself is returned; otherwise the result is appended to
+// dst[:0]. UTF-16 can't be decoded in pieces; use NewDecoder.
+func Decode(dst, raw []byte, encoding string) []byte {
+	if encoding != EncodingLatin1 {
+		return raw
+	}
+	dst = dst[:0]
+	for _, c := range raw {
+		if c < utf8.RuneSelf {
+			dst = append(dst, c)
+		} else {
+			dst = utf8.AppendRune(dst, rune(c))
+		}
+	}
+	return dst
+}
+
+// NewDecoder returns a reader of r's input, in the given encoding, as UTF-8.
+// UTF-16 input is little-endian unless it starts with a big-endian byte order
+// mark; the mark itself is dropped.
+func NewDecoder(r io.Reader, encoding string) io.Reader {
+	switch encoding {
+	case EncodingLatin1:
+		return &latin1Reader{r: r}
+	case EncodingUTF16:
+		return &utf16Reader{r: bufio.NewReader(r)}
+	}
+	return r
+}
+
+type latin1Reader struct {
+	r       io.Reader
+	raw     []byte
+	pending []byte // Decoded bytes not yet returned
+}
+
+func (d *latin1Reader) Read(p []byte) (int, error) {
+	if len(d.pending) == 0 {
+		// Each byte decodes to at most two, so read half as many
+		if cap(d.raw) < len(p)/2+1 {
+			d.raw = make([]byte, len(p)/2+1)
+		}
+		n, err := d.r.Read(d.raw[:len(p)/2+1])
+		if n == 0 {
+			return 0, err
+		}
+		d.pending = Decode(d.pending, d.raw[:n], EncodingLatin1)
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+type utf16Reader struct {
+	r         *bufio.Reader
+	bigEndian bool
+	started   bool
+	pending   []byte // Rest of a character that didn't fit the last read
+}
+
+func (d *utf16Reader) Read(p []byte) (int, error) {
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	for n < len(p) {
+		unit, err := d.unit()
+		if err != nil {
+			if err == io.EOF && n > 0 {
+				err = nil
+			}
+			return n, err
+		}
+		if !d.started {
+			d.started = true
+			switch unit {
+			case 0xFEFF:
+				continue // Byte order mark as read
+			case 0xFFFE:
+				d.bigEndian = true // Byte order mark read in the wrong order
+				continue
+			}
+		}
+		r := rune(unit)
+		if utf16.IsSurrogate(r) {
+			low, err := d.unit()
+			if err != nil && err != io.EOF {
+				return n, err
+			}
+			r = utf16.DecodeRune(r, rune(low))
+		}
+		var enc [utf8.UTFMax]byte
+		size := utf8.EncodeRune(enc[:], r)
+		copied := copy(p[n:], enc[:size])
+		n += copied
+		d.pending = append(d.pending[:0], enc[copied:size]...)
+	}
+	return n, nil
+}
+
+// unit reads the next 16-bit code unit
+func (d *utf16Reader) unit() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("UTF-16 input ends mid-character")
+		}
+		return 0, err
+	}
+	if d.bigEndian {
+		return uint16(b[0])<<8 | uint16(b[1]), nil
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), nil
+}
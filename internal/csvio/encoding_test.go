@@ -0,0 +1,89 @@
+package csvio
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+func TestParseEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":           EncodingUTF8,
+		"UTF-8":      EncodingUTF8,
+		"latin1":     EncodingLatin1,
+		"ISO-8859-1": EncodingLatin1,
+		"utf-16":     EncodingUTF16,
+	}
+	for name, want := range tests {
+		if got, err := ParseEncoding(name); err != nil || got != want {
+			t.Errorf("ParseEncoding(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseEncoding("ebcdic"); err == nil {
+		t.Error("expected error for unknown encoding")
+	}
+}
+
+func TestDecodeLatin1(t *testing.T) {
+	raw := []byte("id,city\n1,K\xf6ln \xa3\xff\n")
+	want := "id,city\n1,Köln £ÿ\n"
+	if got := Decode(nil, raw, EncodingLatin1); string(got) != want {
+		t.Errorf("Decode = %q, want %q", got, want)
+	}
+	if got := Decode(nil, raw, EncodingUTF8); !bytes.Equal(got, raw) {
+		t.Errorf("UTF-8 input should be returned as is, got %q", got)
+	}
+
+	// Reads smaller than a decoded character still get every byte
+	decoded, err := io.ReadAll(iotest.OneByteReader(NewDecoder(bytes.NewReader(raw), EncodingLatin1)))
+	if err != nil || string(decoded) != want {
+		t.Errorf("NewDecoder = %q, %v; want %q", decoded, err, want)
+	}
+}
+
+func TestDecodeUTF16(t *testing.T) {
+	text := "id,name\n1,Köln 😀\n"
+	units := utf16.Encode([]rune(text))
+	withBOM := append([]uint16{0xFEFF}, units...)
+	encode := func(units []uint16, bigEndian bool) []byte {
+		var buf []byte
+		for _, u := range units {
+			if bigEndian {
+				buf = append(buf, byte(u>>8), byte(u))
+			} else {
+				buf = append(buf, byte(u), byte(u>>8))
+			}
+		}
+		return buf
+	}
+
+	for name, raw := range map[string][]byte{
+		"little-endian":     encode(units, false),
+		"little-endian BOM": encode(withBOM, false),
+		"big-endian BOM":    encode(withBOM, true),
+	} {
+		got, err := io.ReadAll(iotest.OneByteReader(NewDecoder(bytes.NewReader(raw), EncodingUTF16)))
+		if err != nil || string(got) != text {
+			t.Errorf("%s: got %q, %v; want %q", name, got, err, text)
+		}
+	}
+
+	if _, err := io.ReadAll(NewDecoder(strings.NewReader("a\x00b"), EncodingUTF16)); err == nil {
+		t.Error("expected error for a truncated code unit")
+	}
+}
+
+func TestTrimBOM(t *testing.T) {
+	if got := TrimBOM([]byte("\xef\xbb\xbfid,name")); string(got) != "id,name" {
+		t.Errorf("TrimBOM = %q", got)
+	}
+	if got := TrimFieldBOM("\xef\xbb\xbfid"); got != "id" {
+		t.Errorf("TrimFieldBOM = %q", got)
+	}
+	if got := TrimFieldBOM("id"); got != "id" {
+		t.Errorf("TrimFieldBOM without a mark = %q", got)
+	}
+}
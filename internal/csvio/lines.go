@@ -0,0 +1,418 @@
+package csvio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+)
+
+// Line handling shared by every reader of CSV input. The query engine, the
+// index builders, and the parallel chunkers must agree on where records
+// start, or index offsets and _line/_offset values drift apart. The rules:
+//
+//   - A record ends at the first line terminator outside quotes: "\n",
+//     "\r\n", or a lone "\r" (classic Mac files). Mixed endings are fine.
+//   - The last record may end without a terminator.
+//   - Blank lines are not records (as with encoding/csv), but their bytes
+//     and lines still count towards the offsets and line numbers of the
+//     records after them.
+//   - Quote state is tracked by parity: RFC 4180 escapes a quote by
+//     doubling it, which leaves the parity unchanged. The quote character
+//     is the dialect's (see Dialect), '"' unless set.
+
+// maxRecordSize bounds a single record, quoted line breaks included
+const maxRecordSize = 64 * 1024 * 1024
+
+// DefaultBufferSize is the read buffer of a Scanner; a 1MB buffer covers
+// long lines without growing
+const DefaultBufferSize = 1024 * 1024
+
+// recordSplitter finds where records end. A caller that needs more data
+// calls again with the same record start, so the scan position and quote
+// state carry over and each byte is examined once even for records spanning
+// many reads.
+type recordSplitter struct {
+	scanned int    // Bytes of the current record already examined
+	inQuote bool   // Quote state at scanned
+	cr      int    // Bytes known to hold no '\r', unless data[cr] is one
+	lines   int64  // Line terminators passed so far, in records or not
+	quote   []byte // Quote character; nil for '"'
+}
+
+// split returns the size of the first record in data including its
+// terminator, and the size of its content without it. A size of 0 asks
+// for more data.
+func (s *recordSplitter) split(data []byte, atEOF bool) (size, n int) {
+	for s.scanned < len(data) {
+		// Jump line to line; a line with an odd number of quotes flips the
+		// quote state, and a record ends at the first terminator outside quotes
+		rest := data[s.scanned:]
+		nl := bytes.IndexByte(rest, '\n')
+		line := rest
+		if nl >= 0 {
+			line = rest[:nl]
+		}
+
+		// A carriage return ends the line itself unless it starts a "\r\n"
+		if cr := s.nextCR(data) - s.scanned; cr < len(line) && (nl < 0 || cr < len(line)-1) {
+			s.countQuotes(line[:cr])
+			if cr == len(rest)-1 && !atEOF {
+				s.scanned += cr // Can't tell "\r" from a split "\r\n" yet
+				break
+			}
+			s.scanned += cr + 1
+			s.lines++
+			if !s.inQuote {
+				return s.end(1)
+			}
+			continue
+		}
+
+		s.countQuotes(line)
+		if nl < 0 {
+			s.scanned = len(data)
+			break
+		}
+		s.scanned += nl + 1
+		s.lines++
+		if !s.inQuote {
+			if nl > 0 && line[nl-1] == '\r' {
+				return s.end(2)
+			}
+			return s.end(1)
+		}
+	}
+
+	if atEOF && len(data) > 0 {
+		// Final record without a terminator (or with an unterminated quote)
+		s.scanned, s.inQuote, s.cr = 0, false, 0
+		return len(data), len(data)
+	}
+	return 0, 0
+}
+
+// nextCR returns the position of the first '\r' in data at or after
+// scanned, or len(data). Files without carriage returns are searched once
+// per buffer, not once per line.
+func (s *recordSplitter) nextCR(data []byte) int {
+	s.cr = max(s.cr, s.scanned)
+	if s.cr < len(data) && data[s.cr] != '\r' {
+		if i := bytes.IndexByte(data[s.cr:], '\r'); i >= 0 {
+			s.cr += i
+		} else {
+			s.cr = len(data)
+		}
+	}
+	return s.cr
+}
+
+func (s *recordSplitter) countQuotes(line []byte) {
+	quote := s.quote
+	if quote == nil {
+		quote = quoteChar
+	}
+	if bytes.Count(line, quote)%2 == 1 {
+		s.inQuote = !s.inQuote
+	}
+}
+
+// end finishes the current record, whose terminator is term bytes long
+func (s *recordSplitter) end(term int) (int, int) {
+	size := s.scanned
+	s.scanned = 0
+	s.cr -= size
+	return size, size - term
+}
+
+// CompleteRecords returns the size of the terminated records at the start
+// of data, which must begin at a record start. What follows is a record
+// still being written: no terminator yet, an open quote, or a final "\r"
+// that may be the first half of "\r\n".
+func CompleteRecords(data []byte, d Dialect) int {
+	split := recordSplitter{quote: quoteOf(d)}
+	n := 0
+	for {
+		size, _ := split.split(data[n:], false)
+		if size == 0 {
+			return n
+		}
+		n += size
+	}
+}
+
+// TrimLineEnd removes a record's line terminator, if any
+func TrimLineEnd(record []byte) []byte {
+	if bytes.HasSuffix(record, crlf) {
+		return record[:len(record)-2]
+	}
+	if n := len(record); n > 0 && (record[n-1] == '\n' || record[n-1] == '\r') {
+		return record[:n-1]
+	}
+	return record
+}
+
+var (
+	crlf      = []byte("\r\n")
+	quoteChar = []byte{'"'}
+)
+
+// quoteOf returns the quote character of d for a recordSplitter
+func quoteOf(d Dialect) []byte {
+	if d.QuoteChar() == '"' {
+		return nil
+	}
+	return []byte{d.QuoteChar()}
+}
+
+// Scanner reads the records of CSV input and tracks where each one starts.
+// Blank lines are skipped, and a byte order mark at the start of the input
+// is dropped.
+type Scanner struct {
+	scanner *bufio.Scanner
+	split   recordSplitter
+	pos     int64 // Input offset the scanner has consumed up to
+	start   int64 // Input offset of the current record
+	lines   int64 // Line terminators before the next record
+	line    int64 // Line on which the current record starts
+}
+
+// NewScanner returns a Scanner reading from r, which must be positioned at
+// a record start.
+func NewScanner(r io.Reader) *Scanner {
+	return NewScannerSize(r, DefaultBufferSize)
+}
+
+// NewScannerSize is NewScanner with a read buffer of the given size, which
+// still grows for a longer record; size <= 0 uses DefaultBufferSize
+func NewScannerSize(r io.Reader, size int) *Scanner {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	s := &Scanner{scanner: bufio.NewScanner(r)}
+	s.scanner.Buffer(make([]byte, size), max(size, maxRecordSize))
+	s.scanner.Split(s.splitRecords)
+	return s
+}
+
+func (s *Scanner) splitRecords(data []byte, atEOF bool) (int, []byte, error) {
+	for skipped := 0; ; {
+		size, n := s.split.split(data[skipped:], atEOF)
+		if size == 0 {
+			s.pos += int64(skipped)
+			return skipped, nil, nil // Request more data
+		}
+		record := data[skipped : skipped+n]
+		if s.pos+int64(skipped) == 0 {
+			record = TrimBOM(record)
+		}
+		line := s.lines + 1
+		s.lines = s.split.lines
+		if len(record) > 0 {
+			s.start = s.pos + int64(skipped)
+			s.line = line
+			s.pos += int64(skipped + size)
+			return skipped + size, record, nil
+		}
+		skipped += size
+	}
+}
+
+// SetDialect reads records quoted as d says. Call it before the first Scan.
+func (s *Scanner) SetDialect(d Dialect) {
+	s.split.quote = quoteOf(d)
+}
+
+// Scan advances to the next record, reporting false at the end of input or
+// on a read error
+func (s *Scanner) Scan() bool {
+	return s.scanner.Scan()
+}
+
+// Record returns the current record without its line terminator. The slice
+// is only valid until the next call to Scan.
+func (s *Scanner) Record() []byte {
+	return s.scanner.Bytes()
+}
+
+// Offset returns the input offset where the current record starts
+func (s *Scanner) Offset() int64 {
+	return s.start
+}
+
+// Line returns the 1-based input line on which the current record starts
+func (s *Scanner) Line() int64 {
+	return s.line
+}
+
+// Err returns the first read error, if any
+func (s *Scanner) Err() error {
+	return s.scanner.Err()
+}
+
+// RecordReader reads raw CSV records, blank ones included, so callers can
+// account for every byte of input.
+type RecordReader struct {
+	scanner *bufio.Scanner
+	split   recordSplitter
+}
+
+// NewRecordReader reads records from r, which must be positioned at a
+// record start.
+func NewRecordReader(r io.Reader) *RecordReader {
+	return NewRecordReaderSize(r, 64*1024)
+}
+
+// NewRecordReaderSize is NewRecordReader with a read buffer of the given size
+func NewRecordReaderSize(r io.Reader, size int) *RecordReader {
+	rr := &RecordReader{scanner: bufio.NewScanner(r)}
+	rr.scanner.Buffer(make([]byte, size), max(size, maxRecordSize))
+	rr.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		n, _ := rr.split.split(data, atEOF)
+		if n == 0 {
+			return 0, nil, nil // Request more data
+		}
+		return n, data[:n], nil
+	})
+	return rr
+}
+
+// SetDialect reads records quoted as d says. Call it before the first Next.
+func (rr *RecordReader) SetDialect(d Dialect) {
+	rr.split.quote = quoteOf(d)
+}
+
+// Next returns the next record including its line terminator (see
+// TrimLineEnd), or io.EOF at the end of input. The slice is only valid
+// until the next call.
+func (rr *RecordReader) Next() ([]byte, error) {
+	if !rr.scanner.Scan() {
+		if err := rr.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	return rr.scanner.Bytes(), nil
+}
+
+// Reader parses records with encoding/csv as Scanner finds them, so its
+// offsets and line numbers match those of every other reader. Records may
+// have any number of fields.
+type Reader struct {
+	// ReuseRecord lets Read return a slice backed by the previous call's,
+	// as with csv.Reader
+	ReuseRecord bool
+
+	feed   *recordFeed
+	parser *csv.Reader
+	pos    recordPos // Where the record last returned starts
+	swap   bool      // Single-quoted input, parsed with the quotes exchanged
+}
+
+// NewReader returns a Reader reading from r, which must be positioned at a
+// record start.
+func NewReader(r io.Reader) *Reader {
+	return NewReaderSize(r, DefaultBufferSize)
+}
+
+// NewReaderSize is NewReader with a read buffer of the given size; size <= 0
+// uses DefaultBufferSize
+func NewReaderSize(r io.Reader, size int) *Reader {
+	feed := &recordFeed{records: NewScannerSize(r, size)}
+	parser := csv.NewReader(feed)
+	parser.FieldsPerRecord = -1
+	return &Reader{feed: feed, parser: parser}
+}
+
+// SetDialect parses fields separated and quoted as d says. Call it before
+// the first Read.
+func (r *Reader) SetDialect(d Dialect) {
+	r.feed.records.SetDialect(d)
+	r.parser.Comma = rune(d.Comma())
+	r.swap = d.QuoteChar() == '\''
+	r.feed.swap = r.swap
+}
+
+// Read returns the next record, or io.EOF at the end of input
+func (r *Reader) Read() ([]string, error) {
+	r.parser.ReuseRecord = r.ReuseRecord
+	record, err := r.parser.Read()
+	if r.swap {
+		swapFieldQuotes(record)
+	}
+	if r.feed.next < len(r.feed.pending) {
+		r.pos = r.feed.pop()
+	}
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		// The parser only sees records, so renumber its lines from the
+		// start of the input
+		shift := int(r.pos.line) - perr.StartLine
+		perr.StartLine += shift
+		perr.Line += shift
+	}
+	return record, err
+}
+
+// Offset returns the input offset where the record last returned by Read
+// starts
+func (r *Reader) Offset() int64 {
+	return r.pos.offset
+}
+
+type recordPos struct {
+	offset, line int64
+}
+
+// recordFeed streams Scanner's records to encoding/csv, each ended by "\n",
+// and queues their positions until the parser has returned them
+type recordFeed struct {
+	records *Scanner
+	rest    []byte // Part of the current record not yet read
+	newline bool   // Whether the current record's "\n" is still to come
+	pending []recordPos
+	next    int  // Index in pending of the oldest position
+	swap    bool // Exchange single and double quotes (see swapQuotes)
+}
+
+func (f *recordFeed) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(f.rest) == 0 && !f.newline {
+			if !f.records.Scan() {
+				if n > 0 {
+					return n, nil
+				}
+				if err := f.records.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.EOF
+			}
+			f.rest, f.newline = f.records.Record(), true
+			f.pending = append(f.pending, recordPos{f.records.Offset(), f.records.Line()})
+		}
+		copied := copy(p[n:], f.rest)
+		if f.swap {
+			swapQuotes(p[n : n+copied])
+		}
+		f.rest = f.rest[copied:]
+		n += copied
+		if len(f.rest) == 0 && f.newline && n < len(p) {
+			p[n] = '\n'
+			n++
+			f.newline = false
+		}
+	}
+	return n, nil
+}
+
+// pop removes and returns the oldest queued position
+func (f *recordFeed) pop() recordPos {
+	pos := f.pending[f.next]
+	f.next++
+	if f.next == len(f.pending) {
+		f.pending, f.next = f.pending[:0], 0
+	}
+	return pos
+}
@@ -0,0 +1,220 @@
+package csvio
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// lineEndingCases holds the same records (including a quoted line break and
+// a blank line) written with different line endings
+var lineEndingCases = []struct {
+	name    string
+	input   string
+	records []string
+	offsets []int64
+	lines   []int64
+}{
+	{
+		name:    "LF",
+		input:   "a,b\n1,\"x\ny\"\n\n22,z\n",
+		records: []string{"a,b", "1,\"x\ny\"", "22,z"},
+		offsets: []int64{0, 4, 13},
+		lines:   []int64{1, 2, 5},
+	},
+	{
+		name:    "CRLF",
+		input:   "a,b\r\n1,\"x\r\ny\"\r\n\r\n22,z\r\n",
+		records: []string{"a,b", "1,\"x\r\ny\"", "22,z"},
+		offsets: []int64{0, 5, 17},
+		lines:   []int64{1, 2, 5},
+	},
+	{
+		name:    "CR only",
+		input:   "a,b\r1,\"x\ry\"\r\r22,z\r",
+		records: []string{"a,b", "1,\"x\ry\"", "22,z"},
+		offsets: []int64{0, 4, 13},
+		lines:   []int64{1, 2, 5},
+	},
+	{
+		name:    "mixed",
+		input:   "a,b\r\n1,\"x\ry\"\n\r22,z\r",
+		records: []string{"a,b", "1,\"x\ry\"", "22,z"},
+		offsets: []int64{0, 5, 14},
+		lines:   []int64{1, 2, 5},
+	},
+	{
+		name:    "no trailing newline",
+		input:   "a,b\n1,\"x\ny\"\n\n22,z",
+		records: []string{"a,b", "1,\"x\ny\"", "22,z"},
+		offsets: []int64{0, 4, 13},
+		lines:   []int64{1, 2, 5},
+	},
+	{
+		name:    "trailing CR without newline",
+		input:   "a,b\r\n22,z\r",
+		records: []string{"a,b", "22,z"},
+		offsets: []int64{0, 5},
+		lines:   []int64{1, 2},
+	},
+}
+
+func TestScannerLineEndings(t *testing.T) {
+	for _, tt := range lineEndingCases {
+		// One byte at a time, so a "\r\n" is split between reads
+		s := NewScanner(iotest.OneByteReader(strings.NewReader(tt.input)))
+		for i, want := range tt.records {
+			if !s.Scan() {
+				t.Fatalf("%s: record %d: %v", tt.name, i, s.Err())
+			}
+			if got := string(s.Record()); got != want {
+				t.Errorf("%s: record %d = %q, want %q", tt.name, i, got, want)
+			}
+			if s.Offset() != tt.offsets[i] || s.Line() != tt.lines[i] {
+				t.Errorf("%s: record %d at offset %d, line %d; want %d, %d", tt.name, i, s.Offset(), s.Line(), tt.offsets[i], tt.lines[i])
+			}
+		}
+		if s.Scan() {
+			t.Errorf("%s: extra record %q", tt.name, s.Record())
+		}
+	}
+}
+
+func TestRecordReaderLineEndings(t *testing.T) {
+	for _, tt := range lineEndingCases {
+		// Raw records cover every byte, and their starts are the Scanner's offsets
+		rr := NewRecordReader(iotest.OneByteReader(strings.NewReader(tt.input)))
+		var offset int64
+		var records []string
+		var offsets []int64
+		for {
+			raw, err := rr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if record := TrimLineEnd(raw); len(record) > 0 {
+				records = append(records, string(record))
+				offsets = append(offsets, offset)
+			}
+			offset += int64(len(raw))
+		}
+		if offset != int64(len(tt.input)) {
+			t.Errorf("%s: records cover %d of %d bytes", tt.name, offset, len(tt.input))
+		}
+		if strings.Join(records, "|") != strings.Join(tt.records, "|") {
+			t.Errorf("%s: records = %q, want %q", tt.name, records, tt.records)
+		}
+		for i := range offsets {
+			if offsets[i] != tt.offsets[i] {
+				t.Errorf("%s: record %d at offset %d, want %d", tt.name, i, offsets[i], tt.offsets[i])
+			}
+		}
+	}
+}
+
+func TestAlignToLineStartLineEndings(t *testing.T) {
+	for _, tt := range lineEndingCases {
+		// Every position aligns to the next record start (or a blank line
+		// before it, or the end)
+		r := strings.NewReader(tt.input)
+		for pos := int64(1); pos < int64(len(tt.input)); pos++ {
+			got, err := AlignToLineStart(r, pos, int64(len(tt.input)))
+			if err != nil {
+				t.Fatalf("%s: AlignToLineStart(%d): %v", tt.name, pos, err)
+			}
+			if got < pos {
+				t.Fatalf("%s: AlignToLineStart(%d) = %d moved back", tt.name, pos, got)
+			}
+			if got == int64(len(tt.input)) {
+				continue
+			}
+			if prev := tt.input[got-1]; prev != '\n' && prev != '\r' || prev == '\r' && tt.input[got] == '\n' {
+				t.Errorf("%s: AlignToLineStart(%d) = %d is not a line start", tt.name, pos, got)
+			}
+		}
+
+		starts, err := ChunkStarts(r, 0, int64(len(tt.input)), len(tt.input), 2, Dialect{})
+		if err != nil {
+			t.Fatalf("%s: ChunkStarts: %v", tt.name, err)
+		}
+		for _, start := range starts[1:] {
+			s := NewScanner(strings.NewReader(tt.input[start:]))
+			if !s.Scan() {
+				continue // Only blank lines left
+			}
+			found := false
+			for _, offset := range tt.offsets {
+				found = found || start+s.Offset() == offset
+			}
+			if !found {
+				t.Errorf("%s: chunk start %d doesn't lead to a record start", tt.name, start)
+			}
+		}
+	}
+}
+
+func TestTrimLineEnd(t *testing.T) {
+	for input, want := range map[string]string{
+		"a,b\n": "a,b", "a,b\r\n": "a,b", "a,b\r": "a,b", "a,b": "a,b", "\n": "", "": "",
+	} {
+		if got := string(TrimLineEnd([]byte(input))); got != want {
+			t.Errorf("TrimLineEnd(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestCompleteRecords(t *testing.T) {
+	for input, want := range map[string]int{
+		"a,b\n":          4,
+		"a,b\nc,d":       4,
+		"a,b\r\nc,d\r\n": 10,
+		"a,\"x\ny\"\nc":  8,
+		"a,\"x\ny":       0,
+		"a,b\r":          0, // May be followed by "\n"
+		"a,b\rc,d\n":     8,
+		"":               0,
+	} {
+		if got := CompleteRecords([]byte(input), Dialect{}); got != want {
+			t.Errorf("CompleteRecords(%q) = %d, want %d", input, got, want)
+		}
+	}
+}
+
+func TestReaderLineEndings(t *testing.T) {
+	r := NewReader(strings.NewReader("\xef\xbb\xbfid,note\r1,\"x\ry\"\r\r2,z"))
+	want := [][]string{{"id", "note"}, {"1", "x\ry"}, {"2", "z"}}
+	wantOffsets := []int64{0, 11, 20}
+	for i, w := range want {
+		record, err := r.Read()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if strings.Join(record, "|") != strings.Join(w, "|") || r.Offset() != wantOffsets[i] {
+			t.Errorf("record %d = %q at %d, want %q at %d", i, record, r.Offset(), w, wantOffsets[i])
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	// Parse errors count lines from the start of the input
+	r = NewReader(strings.NewReader("id,note\n\n1,\"a\nb\"\n2,x\"y\n"))
+	var perr *csv.ParseError
+	for {
+		if _, err := r.Read(); err != nil {
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected a parse error, got %v", err)
+			}
+			break
+		}
+	}
+	if perr.StartLine != 5 || perr.Line != 5 {
+		t.Errorf("parse error at lines %d-%d, want 5: %v", perr.StartLine, perr.Line, perr)
+	}
+}
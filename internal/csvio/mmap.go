@@ -0,0 +1,62 @@
+package csvio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrMmapUnsupported is returned by Map on platforms without mmap; callers
+// fall back to buffered reads.
+var ErrMmapUnsupported = errors.New("mmap not supported on this platform")
+
+// MappedFile is a read-only memory mapping of a whole file. Reads and seeks
+// become slice operations served from the OS page cache, with no syscalls.
+type MappedFile struct {
+	data []byte
+}
+
+// Map memory-maps the file at path. Empty files and non-regular files
+// (pipes, devices) are rejected so callers use the streaming path for them.
+func Map(path string) (*MappedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("mmap %s: not a regular file", path)
+	}
+	if info.Size() == 0 {
+		return nil, fmt.Errorf("mmap %s: empty file", path)
+	}
+	if int64(int(info.Size())) != info.Size() {
+		return nil, fmt.Errorf("mmap %s: file too large to map", path)
+	}
+
+	data, err := mmapFile(file, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("mmap %s: %w", path, err)
+	}
+	return &MappedFile{data: data}, nil
+}
+
+// Bytes returns the mapped contents. The slice is invalid after Close.
+func (m *MappedFile) Bytes() []byte {
+	return m.data
+}
+
+// Close unmaps the file.
+func (m *MappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := munmapFile(m.data)
+	m.data = nil
+	return err
+}
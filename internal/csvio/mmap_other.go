@@ -0,0 +1,13 @@
+//go:build !unix
+
+package csvio
+
+import "os"
+
+func mmapFile(*os.File, int) ([]byte, error) {
+	return nil, ErrMmapUnsupported
+}
+
+func munmapFile([]byte) error {
+	return nil
+}
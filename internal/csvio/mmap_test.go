@@ -0,0 +1,55 @@
+package csvio
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMapReadsFileContents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	content := []byte("id,name\n1,alice\n2,bob\n")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	m, err := Map(path)
+	if errors.Is(err, ErrMmapUnsupported) {
+		t.Skip("mmap not supported on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Map: %v", err)
+	}
+	if !bytes.Equal(m.Bytes(), content) {
+		t.Errorf("mapped bytes = %q, want %q", m.Bytes(), content)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if m.Bytes() != nil {
+		t.Errorf("expected no data after Close")
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestMapRejectsEmptyAndMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.csv")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if _, err := Map(empty); err == nil {
+		t.Error("expected error mapping an empty file")
+	}
+	if _, err := Map(filepath.Join(dir, "missing.csv")); err == nil {
+		t.Error("expected error mapping a missing file")
+	}
+	if _, err := Map(dir); err == nil {
+		t.Error("expected error mapping a directory")
+	}
+}
@@ -0,0 +1,171 @@
+package csvio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ChunkStarts splits the byte range [start, end) of a CSV file into at most
+// n ranges for parallel processing and returns their start offsets, the
+// first being start. Every offset is a record start: split points move
+// forward to the next line start, and a count of d's quote character per
+// range (run on up to parallelism goroutines) gives the quote state at each
+// one, so a line start inside a quoted field moves on to the next line end
+// outside quotes.
+func ChunkStarts(f io.ReaderAt, start, end int64, n, parallelism int, d Dialect) ([]int64, error) {
+	if n < 1 {
+		n = 1
+	}
+	chunkSize := (end - start + int64(n) - 1) / int64(n)
+
+	starts := []int64{start}
+	for i := int64(1); i < int64(n); i++ {
+		pos, err := AlignToLineStart(f, start+i*chunkSize, end)
+		if err != nil {
+			return nil, err
+		}
+		if pos > starts[len(starts)-1] && pos < end {
+			starts = append(starts, pos)
+		}
+	}
+	if len(starts) == 1 {
+		return starts, nil
+	}
+
+	counts, err := countQuotesPerRange(f, starts, end, parallelism, d.QuoteChar())
+	if err != nil {
+		return nil, fmt.Errorf("count quotes: %w", err)
+	}
+
+	aligned := []int64{start}
+	inQuote := false
+	for i := 1; i < len(starts); i++ {
+		if counts[i-1]%2 == 1 {
+			inQuote = !inQuote
+		}
+		pos := starts[i]
+		if inQuote {
+			if pos, err = skipQuotedField(f, pos, end, d.QuoteChar()); err != nil {
+				return nil, err
+			}
+		}
+		// Skipping can run into (or past) the following starts
+		if pos > aligned[len(aligned)-1] && pos < end {
+			aligned = append(aligned, pos)
+		}
+	}
+	return aligned, nil
+}
+
+// AlignToLineStart returns the first line start at or after pos (or limit)
+func AlignToLineStart(f io.ReaderAt, pos, limit int64) (int64, error) {
+	// Start one byte early: if that byte ends a line, pos itself begins one
+	return nextLineStart(f, pos-1, limit, 0)
+}
+
+// countQuotesPerRange counts quote characters in each range [starts[i], starts[i+1])
+func countQuotesPerRange(f io.ReaderAt, starts []int64, end int64, parallelism int, quote byte) ([]int64, error) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	counts := make([]int64, len(starts))
+	errs := make([]error, len(starts))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range starts {
+		rangeEnd := end
+		if i+1 < len(starts) {
+			rangeEnd = starts[i+1]
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, from, to int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			counts[i], errs[i] = countQuotes(io.NewSectionReader(f, from, to-from), quote)
+		}(i, starts[i], rangeEnd)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// countQuotes returns the number of quote characters in r
+func countQuotes(r io.Reader, quote byte) (int64, error) {
+	buf := make([]byte, 256*1024)
+	var n int64
+	for {
+		read, err := r.Read(buf)
+		n += int64(bytes.Count(buf[:read], []byte{quote}))
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// skipQuotedField returns the first record start after pos, which lies
+// inside a field quoted with quote (or limit if the field never closes)
+func skipQuotedField(f io.ReaderAt, pos, limit int64, quote byte) (int64, error) {
+	return nextLineStart(f, pos, limit, quote)
+}
+
+// nextLineStart returns the offset just past the first line terminator at or
+// after p (or limit). With quote set, p lies inside a field quoted with it
+// and terminators count only once the field closes.
+func nextLineStart(f io.ReaderAt, p, limit int64, quote byte) (int64, error) {
+	buf := make([]byte, 64*1024)
+	quoted := quote != 0
+	inQuote := quoted
+	for p < limit {
+		n, err := f.ReadAt(buf, p)
+		for i, c := range buf[:n] {
+			switch {
+			case c == quote && quoted:
+				inQuote = !inQuote
+			case inQuote:
+			case c == '\n':
+				return p + int64(i) + 1, nil
+			case c == '\r':
+				// A "\r\n" ends at its '\n'; a lone '\r' ends the line itself
+				var next [1]byte
+				if i+1 < n {
+					next[0] = buf[i+1]
+				} else if _, err := f.ReadAt(next[:], p+int64(i)+1); err != nil && err != io.EOF {
+					return 0, err
+				}
+				if next[0] != '\n' {
+					return p + int64(i) + 1, nil
+				}
+			}
+		}
+		p += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return limit, nil
+}
+
+// ColumnNames names the columns of a file without a header row: the given
+// names first, then c<N> (1-based) for any of the width columns left over.
+func ColumnNames(names []string, width int) []string {
+	out := make([]string, max(len(names), width))
+	copy(out, names)
+	for i := len(names); i < len(out); i++ {
+		out[i] = fmt.Sprintf("c%d", i+1)
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package csvio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestAlignToLineStart checks chunk boundaries always land on line starts
+func TestAlignToLineStart(t *testing.T) {
+	data := "h\nabc\nde\n\nf"
+	r := strings.NewReader(data)
+	tests := []struct {
+		pos  int64
+		want int64
+	}{
+		{pos: 2, want: 2},   // Already a line start
+		{pos: 3, want: 6},   // Mid-line moves to next line
+		{pos: 6, want: 6},   // Line start
+		{pos: 9, want: 9},   // Empty line start
+		{pos: 10, want: 10}, // Last line without newline
+		{pos: 11, want: 11}, // Past the final newline: limit
+	}
+	for _, tt := range tests {
+		got, err := AlignToLineStart(r, tt.pos, int64(len(data)))
+		if err != nil {
+			t.Fatalf("AlignToLineStart(%d): %v", tt.pos, err)
+		}
+		if got != tt.want {
+			t.Errorf("AlignToLineStart(%d) = %d, want %d", tt.pos, got, tt.want)
+		}
+	}
+}
+
+// TestChunkStartsSkipQuotedNewlines checks chunks never start inside a quoted field
+func TestChunkStartsSkipQuotedNewlines(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,note\n")
+	for i := 0; i < 200; i++ {
+		sb.WriteString("1,\"line one\nline \"\"two\"\"\n\nline three\"\n2,plain\n")
+	}
+	data := sb.String()
+	r := strings.NewReader(data)
+
+	// Every record start, found by reading records sequentially
+	recordStarts := map[int64]bool{}
+	rr := NewRecordReader(bufio.NewReader(strings.NewReader(data)))
+	var pos int64
+	for {
+		record, err := rr.Next()
+		if len(record) > 0 {
+			recordStarts[pos] = true
+		}
+		pos += int64(len(record))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read record: %v", err)
+		}
+	}
+
+	for _, n := range []int{1, 3, 16, 97} {
+		starts, err := ChunkStarts(r, 8, int64(len(data)), n, 4, Dialect{})
+		if err != nil {
+			t.Fatalf("ChunkStarts(%d): %v", n, err)
+		}
+		if starts[0] != 8 {
+			t.Errorf("ChunkStarts(%d) first start = %d, want 8", n, starts[0])
+		}
+		for i, start := range starts {
+			if !recordStarts[start] {
+				t.Errorf("ChunkStarts(%d)[%d] = %d is not a record start", n, i, start)
+			}
+			if i > 0 && start <= starts[i-1] {
+				t.Errorf("ChunkStarts(%d) not increasing at %d", n, i)
+			}
+		}
+	}
+}
+
+func TestColumnNames(t *testing.T) {
+	tests := []struct {
+		names []string
+		width int
+		want  string
+	}{
+		{nil, 3, "[c1 c2 c3]"},
+		{[]string{"id", "name"}, 3, "[id name c3]"},
+		{[]string{"id", "name", "extra"}, 2, "[id name extra]"},
+		{nil, 0, "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(ColumnNames(tt.names, tt.width)); got != tt.want {
+			t.Errorf("ColumnNames(%q, %d) = %s, want %s", tt.names, tt.width, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Package csvio holds low-level file I/O helpers shared by the query engine
+// and the sidx index builder.
+package csvio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+)
+
+const (
+	// MaxReadAttempts bounds how often a single read is retried on transient errors.
+	MaxReadAttempts = 5
+	// initialBackoff is doubled after each failed attempt (10ms, 20ms, 40ms, ...).
+	initialBackoff = 10 * time.Millisecond
+)
+
+// ReadError reports a read that kept failing after all retries.
+type ReadError struct {
+	Path     string
+	Offset   int64
+	Attempts int
+	Err      error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("read %s at offset %d failed after %d attempt(s): %v", e.Path, e.Offset, e.Attempts, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// RetryReader wraps a file and retries reads that fail with transient errors
+// (EINTR, EAGAIN, EIO, ETIMEDOUT), which network filesystems such as NFS and
+// SMB return occasionally under load. Permanent errors fail immediately.
+type RetryReader struct {
+	r       io.Reader
+	path    string
+	offset  int64
+	sleep   func(time.Duration)
+	retries int // Total retries performed, for diagnostics
+}
+
+// NewRetryReader wraps r, which must be positioned at offset within path.
+// The path and offset are only used for error messages.
+func NewRetryReader(r io.Reader, path string, offset int64) *RetryReader {
+	return &RetryReader{
+		r:      r,
+		path:   path,
+		offset: offset,
+		sleep:  time.Sleep,
+	}
+}
+
+// Read implements io.Reader.
+func (r *RetryReader) Read(p []byte) (int, error) {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		n, err := r.r.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if !IsTransient(err) {
+			return n, &ReadError{Path: r.path, Offset: r.offset, Attempts: attempt, Err: err}
+		}
+		if n > 0 {
+			// Hand back what we got; the next Read retries from the new offset.
+			return n, nil
+		}
+		if attempt >= MaxReadAttempts {
+			return 0, &ReadError{Path: r.path, Offset: r.offset, Attempts: attempt, Err: err}
+		}
+		r.retries++
+		r.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Offset returns the absolute file offset of the next byte to be read.
+func (r *RetryReader) Offset() int64 {
+	return r.offset
+}
+
+// Retries returns how many reads had to be retried.
+func (r *RetryReader) Retries() int {
+	return r.retries
+}
+
+// IsTransient reports whether err is worth retrying.
+func IsTransient(err error) bool {
+	return errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EIO) ||
+		errors.Is(err, syscall.ETIMEDOUT)
+}
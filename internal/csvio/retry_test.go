@@ -0,0 +1,75 @@
+package csvio
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyReader fails with err for the first failures reads, then delegates.
+type flakyReader struct {
+	r        io.Reader
+	err      error
+	failures int
+}
+
+func (f *flakyReader) Read(p []byte) (int, error) {
+	if f.failures > 0 {
+		f.failures--
+		return 0, f.err
+	}
+	return f.r.Read(p)
+}
+
+func newTestReader(r io.Reader) *RetryReader {
+	rr := NewRetryReader(r, "data.csv", 100)
+	rr.sleep = func(time.Duration) {}
+	return rr
+}
+
+func TestRetryReaderRecoversFromTransientErrors(t *testing.T) {
+	rr := newTestReader(&flakyReader{r: strings.NewReader("a,b\n1,2\n"), err: syscall.EINTR, failures: 3})
+
+	data, err := io.ReadAll(rr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "a,b\n1,2\n" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+	if rr.Retries() != 3 {
+		t.Errorf("expected 3 retries, got %d", rr.Retries())
+	}
+	if rr.Offset() != 108 {
+		t.Errorf("expected offset 108, got %d", rr.Offset())
+	}
+}
+
+func TestRetryReaderGivesUpWithDiagnostics(t *testing.T) {
+	rr := newTestReader(&flakyReader{r: strings.NewReader("x"), err: syscall.EIO, failures: MaxReadAttempts})
+
+	_, err := rr.Read(make([]byte, 8))
+	var readErr *ReadError
+	if !errors.As(err, &readErr) {
+		t.Fatalf("expected *ReadError, got %T: %v", err, err)
+	}
+	if readErr.Path != "data.csv" || readErr.Offset != 100 || readErr.Attempts != MaxReadAttempts {
+		t.Errorf("unexpected diagnostics: %+v", readErr)
+	}
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("expected error to wrap EIO: %v", err)
+	}
+}
+
+func TestRetryReaderDoesNotRetryPermanentErrors(t *testing.T) {
+	rr := newTestReader(&flakyReader{r: strings.NewReader("x"), err: syscall.EBADF, failures: 1})
+
+	_, err := rr.Read(make([]byte, 8))
+	var readErr *ReadError
+	if !errors.As(err, &readErr) || readErr.Attempts != 1 {
+		t.Fatalf("expected single-attempt ReadError, got %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+package datatype
+
+import "strings"
+
+// ParseBool reads true/false, yes/no, and 1/0, ignoring case
+func ParseBool(s string) (bool, bool) {
+	switch {
+	case s == "1" || strings.EqualFold(s, "true") || strings.EqualFold(s, "yes"):
+		return true, true
+	case s == "0" || strings.EqualFold(s, "false") || strings.EqualFold(s, "no"):
+		return false, true
+	}
+	return false, false
+}
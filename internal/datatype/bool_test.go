@@ -0,0 +1,29 @@
+package datatype
+
+import "testing"
+
+func TestParseBool(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   bool
+		wantOK bool
+	}{
+		{"true", true, true},
+		{"TRUE", true, true},
+		{"Yes", true, true},
+		{"1", true, true},
+		{"false", false, true},
+		{"no", false, true},
+		{"0", false, true},
+		{"", false, false},
+		{"t", false, false},
+		{"1.0", false, false},
+		{"yes please", false, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseBool(tt.input)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("ParseBool(%q) = %v, %v; want %v, %v", tt.input, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
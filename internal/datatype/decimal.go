@@ -0,0 +1,183 @@
+package datatype
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MaxDecimalScale is the most digits after the decimal point a Decimal keeps
+const MaxDecimalScale = 18
+
+// Decimal is an exact fixed-point number, Units / 10^Scale. Money stored as
+// "19.99" or minor units sums and compares without float rounding.
+type Decimal struct {
+	Units int64
+	Scale int
+}
+
+var pow10 = [MaxDecimalScale + 1]int64{1, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9,
+	1e10, 1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18}
+
+// ParseDecimal parses a plain decimal ([+-]digits[.digits]). Exponents,
+// NaN, and values whose digits don't fit in an int64 are rejected, so callers
+// can fall back to strconv.ParseFloat.
+func ParseDecimal(s string) (Decimal, bool) {
+	i := 0
+	neg := false
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		neg = s[i] == '-'
+		i++
+	}
+
+	var units uint64
+	digits, scale := 0, 0
+	sawDot := false
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isDigit(c):
+			d := uint64(c - '0')
+			if units > (math.MaxInt64-d)/10 {
+				return Decimal{}, false
+			}
+			units = units*10 + d
+			digits++
+			if sawDot {
+				scale++
+			}
+		case c == '.' && !sawDot:
+			sawDot = true
+		default:
+			return Decimal{}, false
+		}
+	}
+	if digits == 0 || scale > MaxDecimalScale {
+		return Decimal{}, false
+	}
+
+	v := int64(units)
+	if neg {
+		v = -v
+	}
+	return Decimal{Units: v, Scale: scale}, true
+}
+
+// rescale returns d's units at a scale at least d.Scale, reporting false on
+// overflow
+func (d Decimal) rescale(scale int) (int64, bool) {
+	p := pow10[scale-d.Scale]
+	limit := math.MaxInt64 / p
+	if d.Units > limit || d.Units < -limit {
+		return 0, false
+	}
+	return d.Units * p, true
+}
+
+// Add returns d + o, reporting false if the exact sum doesn't fit
+func (d Decimal) Add(o Decimal) (Decimal, bool) {
+	scale := max(d.Scale, o.Scale)
+	a, ok := d.rescale(scale)
+	if !ok {
+		return Decimal{}, false
+	}
+	b, ok := o.rescale(scale)
+	if !ok {
+		return Decimal{}, false
+	}
+	sum := a + b
+	if (a > 0 && b > 0 && sum < 0) || (a < 0 && b < 0 && sum >= 0) || sum == math.MinInt64 {
+		return Decimal{}, false
+	}
+	return Decimal{Units: sum, Scale: scale}, true
+}
+
+// Cmp orders d against o exactly: -1, 0, or 1
+func (d Decimal) Cmp(o Decimal) int {
+	scale := max(d.Scale, o.Scale)
+	a, okA := d.rescale(scale)
+	b, okB := o.rescale(scale)
+	if okA && okB {
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	}
+	// Aligning scales overflowed int64
+	return d.big(scale).Cmp(o.big(scale))
+}
+
+func (d Decimal) big(scale int) *big.Int {
+	n := big.NewInt(d.Units)
+	return n.Mul(n, big.NewInt(pow10[scale-d.Scale]))
+}
+
+// Format renders d with at least minScale digits after the decimal point
+func (d Decimal) Format(minScale int) string {
+	units := d.Units
+	neg := units < 0
+	if neg {
+		units = -units
+	}
+	digits := strconv.FormatInt(units, 10)
+	if len(digits) <= d.Scale {
+		digits = strings.Repeat("0", d.Scale-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-d.Scale], digits[len(digits)-d.Scale:]
+	if len(frac) < minScale {
+		frac += strings.Repeat("0", minScale-len(frac))
+	}
+
+	s := whole
+	if frac != "" {
+		s += "." + frac
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// Round returns d with at most scale digits after the decimal point,
+// rounding half away from zero
+func (d Decimal) Round(scale int) Decimal {
+	if scale >= d.Scale {
+		return d
+	}
+	if scale < 0 {
+		scale = 0
+	}
+	p := pow10[d.Scale-scale]
+	units, rest := d.Units/p, d.Units%p
+	switch {
+	case rest >= p-rest:
+		units++
+	case -rest >= p+rest:
+		units--
+	}
+	return Decimal{Units: units, Scale: scale}
+}
+
+// Shift returns d / 10^digits, exactly, reporting false when the scale
+// would exceed MaxDecimalScale
+func (d Decimal) Shift(digits int) (Decimal, bool) {
+	if d.Scale+digits > MaxDecimalScale {
+		return Decimal{}, false
+	}
+	return Decimal{Units: d.Units, Scale: d.Scale + digits}, true
+}
+
+// String renders d at its own scale
+func (d Decimal) String() string {
+	return d.Format(0)
+}
+
+// Float64 returns the float64 nearest to d
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
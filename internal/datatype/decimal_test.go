@@ -0,0 +1,133 @@
+package datatype
+
+import "testing"
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Decimal
+		ok    bool
+	}{
+		{"0", Decimal{0, 0}, true},
+		{"19.99", Decimal{1999, 2}, true},
+		{"-0.10", Decimal{-10, 2}, true},
+		{"+7", Decimal{7, 0}, true},
+		{".5", Decimal{5, 1}, true},
+		{"5.", Decimal{5, 0}, true},
+		{"007", Decimal{7, 0}, true},
+		{"9223372036854775807", Decimal{9223372036854775807, 0}, true},
+		{"9223372036854775808", Decimal{}, false},
+		{"0.1234567890123456789", Decimal{}, false},
+		{"1e3", Decimal{}, false},
+		{"NaN", Decimal{}, false},
+		{"1,000", Decimal{}, false},
+		{"", Decimal{}, false},
+		{"-", Decimal{}, false},
+		{".", Decimal{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseDecimal(tt.input)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseDecimal(%q) = %v, %v; want %v, %v", tt.input, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDecimalSumIsExact(t *testing.T) {
+	var sum Decimal
+	tenth, _ := ParseDecimal("0.1")
+	for i := 0; i < 1000; i++ {
+		var ok bool
+		if sum, ok = sum.Add(tenth); !ok {
+			t.Fatalf("unexpected overflow")
+		}
+	}
+	if got := sum.Format(2); got != "100.00" {
+		t.Errorf("sum of 1000 x 0.1 = %s, want 100.00", got)
+	}
+
+	// Beyond float64's exact integers, minor units still add up
+	big, _ := ParseDecimal("9007199254740993")
+	one, _ := ParseDecimal("1")
+	if got, _ := big.Add(one); got.String() != "9007199254740994" {
+		t.Errorf("got %s, want 9007199254740994", got)
+	}
+
+	max, _ := ParseDecimal("9223372036854775807")
+	if _, ok := max.Add(one); ok {
+		t.Errorf("expected overflow")
+	}
+	fine, _ := ParseDecimal("0.000000000000000001")
+	if _, ok := max.Add(fine); ok {
+		t.Errorf("expected overflow when aligning scales")
+	}
+}
+
+func TestDecimalCmpAndFormat(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.50", "1.5", 0},
+		{"-0.01", "0", -1},
+		{"9007199254740993", "9007199254740992", 1},
+		{"9223372036854775807", "0.000000000000000001", 1},
+		{"-9223372036854775807", "0.1", -1},
+	}
+	for _, tt := range tests {
+		a, _ := ParseDecimal(tt.a)
+		b, _ := ParseDecimal(tt.b)
+		if got := a.Cmp(b); got != tt.want {
+			t.Errorf("Cmp(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	formats := []struct {
+		input    string
+		minScale int
+		want     string
+	}{
+		{"-0.05", 2, "-0.05"},
+		{"12", 2, "12.00"},
+		{"1.234", 2, "1.234"},
+		{".5", 0, "0.5"},
+		{"-0", 2, "0.00"},
+	}
+	for _, tt := range formats {
+		d, _ := ParseDecimal(tt.input)
+		if got := d.Format(tt.minScale); got != tt.want {
+			t.Errorf("Format(%s, %d) = %q, want %q", tt.input, tt.minScale, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalRoundAndShift(t *testing.T) {
+	tests := []struct {
+		input string
+		scale int
+		want  string
+	}{
+		{"1.005", 2, "1.01"},
+		{"1.004", 2, "1.00"},
+		{"-1.005", 2, "-1.01"},
+		{"-1.004", 2, "-1.00"},
+		{"2.5", 0, "3"},
+		{"12", 2, "12.00"},
+		{"0.999", 1, "1.0"},
+	}
+	for _, tt := range tests {
+		d, _ := ParseDecimal(tt.input)
+		if got := d.Round(tt.scale).Format(tt.scale); got != tt.want {
+			t.Errorf("Round(%s, %d) = %q, want %q", tt.input, tt.scale, got, tt.want)
+		}
+	}
+
+	d, _ := ParseDecimal("123456")
+	if got, ok := d.Shift(2); !ok || got.Format(2) != "1234.56" {
+		t.Errorf("Shift(123456, 2) = %v, %v", got, ok)
+	}
+	fine, _ := ParseDecimal("0.000000000000000001")
+	if _, ok := fine.Shift(1); ok {
+		t.Error("expected Shift past MaxDecimalScale to fail")
+	}
+}
@@ -0,0 +1,105 @@
+package datatype
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// NumberFormat describes how a file writes numbers: an optional thousands
+// separator ("1,234.56") and the decimal point, '.' when zero ("1.234,56"
+// uses '.' and ','). The zero value reads numbers as strconv.ParseFloat does.
+type NumberFormat struct {
+	Thousands byte
+	Decimal   byte
+}
+
+// IsDefault reports whether f reads numbers exactly as strconv.ParseFloat
+func (f NumberFormat) IsDefault() bool {
+	return f.Thousands == 0 && (f.Decimal == 0 || f.Decimal == '.')
+}
+
+// Validate rejects separators that would make numbers ambiguous
+func (f NumberFormat) Validate() error {
+	dec := f.decimal()
+	if dec != '.' && dec != ',' {
+		return fmt.Errorf("decimal point must be '.' or ',', not %q", dec)
+	}
+	if f.Thousands == 0 {
+		return nil
+	}
+	if f.Thousands == dec {
+		return fmt.Errorf("thousands separator %q is also the decimal point", f.Thousands)
+	}
+	if isDigit(f.Thousands) || f.Thousands == '-' || f.Thousands == '+' || f.Thousands == 'e' || f.Thousands == 'E' {
+		return fmt.Errorf("%q can't be a thousands separator", f.Thousands)
+	}
+	return nil
+}
+
+// String shows the format by example: "1,234.56", "1.234,56", or "1234.56"
+func (f NumberFormat) String() string {
+	s := "1"
+	if f.Thousands != 0 {
+		s += string(f.Thousands)
+	}
+	return s + "234" + string(f.decimal()) + "56"
+}
+
+func (f NumberFormat) decimal() byte {
+	if f.Decimal == 0 {
+		return '.'
+	}
+	return f.Decimal
+}
+
+// Normalize rewrites s in strconv.ParseFloat syntax: thousands separators are
+// dropped and the decimal point becomes '.'. Separators must sit between
+// digit groups of three ("1,234,567" but not "12,34"). It reports false when
+// s misuses them; s is returned unchanged for the default format.
+func (f NumberFormat) Normalize(s string) (string, bool) {
+	if f.IsDefault() {
+		return s, true
+	}
+
+	dec := f.decimal()
+	buf := make([]byte, 0, len(s))
+	sawDecimal := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == f.Thousands && f.Thousands != 0:
+			// Grouping only before the decimal point, after a digit, and
+			// followed by exactly three digits
+			if sawDecimal || i == 0 || !isDigit(s[i-1]) || !threeDigits(s[i+1:]) {
+				return s, false
+			}
+		case c == dec:
+			sawDecimal = true
+			buf = append(buf, '.')
+		case c == '.' || c == ',':
+			// The other separator character, which this format doesn't use
+			return s, false
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return string(buf), true
+}
+
+// threeDigits reports whether s starts with exactly three digits
+func threeDigits(s string) bool {
+	if len(s) < 3 || !isDigit(s[0]) || !isDigit(s[1]) || !isDigit(s[2]) {
+		return false
+	}
+	return len(s) == 3 || !isDigit(s[3])
+}
+
+// ParseFloat parses s in format f, like strconv.ParseFloat for the default
+func (f NumberFormat) ParseFloat(s string) (float64, bool) {
+	n, ok := f.Normalize(s)
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(n, 64)
+	return v, err == nil
+}
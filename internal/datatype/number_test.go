@@ -0,0 +1,59 @@
+package datatype
+
+import "testing"
+
+func TestNumberFormatParseFloat(t *testing.T) {
+	us := NumberFormat{Thousands: ','}
+	eu := NumberFormat{Thousands: '.', Decimal: ','}
+	swiss := NumberFormat{Thousands: '\'', Decimal: '.'}
+
+	tests := []struct {
+		format NumberFormat
+		input  string
+		want   float64
+		ok     bool
+	}{
+		{NumberFormat{}, "1.2e3", 1200, true},
+		{NumberFormat{}, "1,234.56", 0, false},
+		{us, "1,234.56", 1234.56, true},
+		{us, "-1,234,567", -1234567, true},
+		{us, "1234.5", 1234.5, true},
+		{us, "1.2e3", 1200, true},
+		{us, "12,34", 0, false},
+		{us, "1,2345", 0, false},
+		{us, ",123", 0, false},
+		{us, "1.234,567", 0, false},
+		{eu, "1.234,56", 1234.56, true},
+		{eu, "0,5", 0.5, true},
+		{eu, "1,5e2", 150, true},
+		{eu, "1.5", 0, false},
+		{swiss, "1'000'000.25", 1000000.25, true},
+		{NumberFormat{Decimal: ','}, "3,75", 3.75, true},
+		{NumberFormat{Decimal: ','}, "3.75", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.format.ParseFloat(tt.input)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%+v.ParseFloat(%q) = %v, %v; want %v, %v", tt.format, tt.input, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNumberFormatValidate(t *testing.T) {
+	valid := []NumberFormat{{}, {Thousands: ','}, {Thousands: '.', Decimal: ','}, {Thousands: ' ', Decimal: ','}}
+	for _, f := range valid {
+		if err := f.Validate(); err != nil {
+			t.Errorf("%+v: unexpected error: %v", f, err)
+		}
+	}
+	if got := (NumberFormat{Thousands: '.', Decimal: ','}).String(); got != "1.234,56" {
+		t.Errorf("String() = %q, want 1.234,56", got)
+	}
+
+	invalid := []NumberFormat{{Thousands: '.'}, {Thousands: ',', Decimal: ','}, {Thousands: '1'}, {Decimal: ';'}}
+	for _, f := range invalid {
+		if err := f.Validate(); err == nil {
+			t.Errorf("%+v: expected error", f)
+		}
+	}
+}
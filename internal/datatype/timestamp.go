@@ -0,0 +1,46 @@
+// Package datatype holds value parsing shared by the SQL evaluator and the
+// index builder, so both agree on which strings are numbers and timestamps.
+package datatype
+
+import "time"
+
+// timestampLayouts are tried in order. Fractional seconds are accepted after
+// the seconds field even though the layouts don't spell them out.
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+}
+
+// ParseTimestamp parses ISO 8601 style dates and date-times ("2024-01-31",
+// "2024-01-31 10:00:00", "2024-01-31T10:00:00.5Z", ...) and returns Unix
+// nanoseconds. Values without a zone are taken as UTC.
+func ParseTimestamp(s string) (int64, bool) {
+	// Cheap rejection: every accepted layout starts with YYYY-MM-DD
+	if len(s) < 10 || s[4] != '-' || s[7] != '-' || !isDigit(s[0]) || !isDigit(s[9]) {
+		return 0, false
+	}
+
+	if len(s) == 10 {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return 0, false
+		}
+		return t.UnixNano(), true
+	}
+	if s[10] != 'T' && s[10] != ' ' {
+		return 0, false
+	}
+
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UnixNano(), true
+		}
+	}
+	return 0, false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,38 @@
+package datatype
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Time
+		ok    bool
+	}{
+		{"2024-01-31", time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), true},
+		{"2024-01-31 10:20:30", time.Date(2024, 1, 31, 10, 20, 30, 0, time.UTC), true},
+		{"2024-01-31T10:20:30", time.Date(2024, 1, 31, 10, 20, 30, 0, time.UTC), true},
+		{"2024-01-31T10:20:30Z", time.Date(2024, 1, 31, 10, 20, 30, 0, time.UTC), true},
+		{"2024-01-31T10:20:30.25Z", time.Date(2024, 1, 31, 10, 20, 30, 250000000, time.UTC), true},
+		{"2024-01-31T12:20:30+02:00", time.Date(2024, 1, 31, 10, 20, 30, 0, time.UTC), true},
+		{"2024-01-31 10:20:30.5", time.Date(2024, 1, 31, 10, 20, 30, 500000000, time.UTC), true},
+		{"2024-13-01", time.Time{}, false},
+		{"2024-01-31X10:20:30", time.Time{}, false},
+		{"20240131", time.Time{}, false},
+		{"hello world", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseTimestamp(tt.input)
+		if ok != tt.ok {
+			t.Errorf("ParseTimestamp(%q) ok = %v, want %v", tt.input, ok, tt.ok)
+			continue
+		}
+		if ok && got != tt.want.UnixNano() {
+			t.Errorf("ParseTimestamp(%q) = %v, want %v", tt.input, time.Unix(0, got).UTC(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package difftest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/engine"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// avgTolerance covers AVG, which sieswi rounds to 2 digits
+const avgTolerance = 0.005 + 1e-9
+
+// RunSieswi runs sql through the engine and returns its rows, without the
+// header
+func RunSieswi(sql string) ([][]string, error) {
+	query, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := engine.Execute(query, &out); err != nil {
+		return nil, err
+	}
+	return readRows(&out, true)
+}
+
+// Reference runs queries through the sqlite3 shell, over a table "t"
+// loaded from a CSV file
+type Reference struct {
+	Shell string // Path of the sqlite3 shell
+	Table Table
+	Path  string // The CSV file of Table
+}
+
+// Run runs sql and returns its rows
+func (r Reference) Run(sql string) ([][]string, error) {
+	defs := make([]string, len(r.Table.Columns))
+	for i, col := range r.Table.Columns {
+		defs[i] = col.Name + " " + col.Type
+	}
+	script := fmt.Sprintf("CREATE TABLE t(%s);\n.import --csv --skip 1 '%s' t\n%s;\n",
+		strings.Join(defs, ", "), r.Path, sql)
+
+	cmd := exec.Command(r.Shell, "-csv", "-bail", ":memory:")
+	cmd.Stdin = strings.NewReader(script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return readRows(bytes.NewReader(out), false)
+}
+
+// readRows parses CSV output, dropping the header if there is one
+func readRows(r io.Reader, header bool) ([][]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if header && len(rows) > 0 {
+		rows = rows[1:]
+	}
+	return rows, nil
+}
+
+// Compare returns an error describing the first difference between got and
+// want, comparing rows in order or, unless ordered, as sets. Numbers match
+// within AVG's rounding.
+func Compare(got, want [][]string, ordered bool) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("%d rows, want %d", len(got), len(want))
+	}
+	if !ordered {
+		got, want = sorted(got), sorted(want)
+	}
+	for i := range got {
+		if !rowsEqual(got[i], want[i]) {
+			return fmt.Errorf("row %d is %q, want %q", i+1, got[i], want[i])
+		}
+	}
+	return nil
+}
+
+func sorted(rows [][]string) [][]string {
+	rows = append([][]string(nil), rows...)
+	sort.Slice(rows, func(i, j int) bool {
+		return strings.Join(rows[i], "\x00") < strings.Join(rows[j], "\x00")
+	})
+	return rows
+}
+
+func rowsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		x, errX := strconv.ParseFloat(a[i], 64)
+		y, errY := strconv.ParseFloat(b[i], 64)
+		if errX != nil || errY != nil || math.Abs(x-y) > avgTolerance {
+			return false
+		}
+	}
+	return true
+}
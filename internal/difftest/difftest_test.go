@@ -0,0 +1,74 @@
+package difftest
+
+import (
+	"flag"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	seed    = flag.Int64("difftest.seed", 1, "seed for generated tables and queries; 0 picks one from the clock")
+	queries = flag.Int("difftest.queries", 200, "number of generated queries")
+)
+
+// TestAgainstSQLite compares sieswi with the sqlite3 shell on generated
+// queries. It is skipped without sqlite3 on the PATH and with -short. A
+// failure logs its seed; rerun it with -difftest.seed.
+func TestAgainstSQLite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("differential test skipped with -short")
+	}
+	shell, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found on PATH")
+	}
+
+	s := *seed
+	if s == 0 {
+		s = time.Now().UnixNano()
+	}
+	t.Logf("seed %d", s)
+	rng := rand.New(rand.NewSource(s))
+
+	path := filepath.Join(t.TempDir(), "t.csv")
+	table := GenerateTable(rng, 200+rng.Intn(800))
+	if err := table.WriteCSV(path); err != nil {
+		t.Fatal(err)
+	}
+	ref := Reference{Shell: shell, Table: table, Path: path}
+
+	failures := 0
+	for i := 0; i < *queries && failures < 5; i++ {
+		c := GenerateCase(rng, table, path)
+		got, err := RunSieswi(c.Sieswi)
+		if err != nil {
+			t.Errorf("seed %d: %s: %v", s, c.Sieswi, err)
+			failures++
+			continue
+		}
+		want, err := ref.Run(c.Reference)
+		if err != nil {
+			t.Fatalf("seed %d: reference %s: %v", s, c.Reference, err)
+		}
+		if err := Compare(got, want, c.Ordered); err != nil {
+			t.Errorf("seed %d: %s\n  reference: %s\n  %v", s, c.Sieswi, c.Reference, err)
+			failures++
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	want := [][]string{{"UK", "3", "512.333333333333"}, {"US", "1", "7.0"}}
+	if err := Compare([][]string{{"US", "1", "7"}, {"UK", "3", "512.33"}}, want, false); err != nil {
+		t.Errorf("unordered: %v", err)
+	}
+	if err := Compare([][]string{{"US", "1", "7"}, {"UK", "3", "512.33"}}, want, true); err == nil {
+		t.Error("ordered: rows out of order matched")
+	}
+	if err := Compare([][]string{{"UK", "3", "512.34"}, {"US", "1", "7"}}, want, true); err == nil {
+		t.Error("512.34 matched 512.333333333333")
+	}
+}
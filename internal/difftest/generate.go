@@ -0,0 +1,205 @@
+// Package difftest runs generated queries through sieswi and through a
+// reference SQL engine, the sqlite3 shell, over the same CSV file, and
+// reports the queries whose results differ.
+//
+// Tables and queries stay inside the SQL both engines read the same way:
+// integer and text columns without empty values, comparisons of integers
+// with integers and text with text, and aggregates whose results print
+// alike (AVG is compared to the 2 digits sieswi writes).
+package difftest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Column is a generated column and its SQL type, INTEGER or TEXT
+type Column struct {
+	Name string
+	Type string
+	// Values are the values of a TEXT column; INTEGER columns hold
+	// numbers from Min to Max
+	Values   []string
+	Min, Max int
+}
+
+// Table is a generated CSV table
+type Table struct {
+	Columns []Column
+	Rows    [][]string
+}
+
+// columns is the schema of every generated table
+var columns = []Column{
+	{Name: "id", Type: "INTEGER", Min: 1, Max: 1 << 20},
+	{Name: "qty", Type: "INTEGER", Min: -50, Max: 50},
+	{Name: "price", Type: "INTEGER", Min: 0, Max: 1000},
+	{Name: "country", Type: "TEXT", Values: []string{"UK", "US", "DE", "FR", "JP"}},
+	{Name: "status", Type: "TEXT", Values: []string{"paid", "refunded", "pending", "Paid"}},
+}
+
+// GenerateTable returns a table of n random rows
+func GenerateTable(rng *rand.Rand, n int) Table {
+	t := Table{Columns: columns}
+	for i := 0; i < n; i++ {
+		row := make([]string, len(columns))
+		for j, col := range columns {
+			switch {
+			case col.Name == "id":
+				row[j] = strconv.Itoa(i + 1)
+			case col.Type == "INTEGER":
+				row[j] = strconv.Itoa(col.Min + rng.Intn(col.Max-col.Min+1))
+			default:
+				row[j] = col.Values[rng.Intn(len(col.Values))]
+			}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+// WriteCSV writes the table, with a header, to path
+func (t Table) WriteCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	header := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		header[i] = col.Name
+	}
+	w.Write(header)
+	w.WriteAll(t.Rows)
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Case is one generated query, as sieswi and the reference spell it
+type Case struct {
+	Sieswi    string
+	Reference string
+	// Ordered is set when both results come in a defined order; otherwise
+	// their rows are compared as sets
+	Ordered bool
+}
+
+// GenerateCase returns a random query over t, read by sieswi from path and
+// by the reference from table "t"
+func GenerateCase(rng *rand.Rand, t Table, path string) Case {
+	g := generator{rng: rng, table: t}
+	var c Case
+	switch rng.Intn(3) {
+	case 0:
+		c = g.projection()
+	case 1:
+		c = g.grouped()
+	default:
+		c = g.count()
+	}
+	from := "'" + path + "'"
+	c.Reference = strings.ReplaceAll(c.Sieswi, "FROM $t", "FROM t")
+	c.Sieswi = strings.ReplaceAll(c.Sieswi, "FROM $t", "FROM "+from)
+	return c
+}
+
+// generator builds the SQL of one case, reading FROM $t
+type generator struct {
+	rng   *rand.Rand
+	table Table
+}
+
+// projection selects some columns of the rows matching a condition, in file
+// order (rowid order for the reference)
+func (g generator) projection() Case {
+	var cols []string
+	for _, i := range g.rng.Perm(len(g.table.Columns))[:1+g.rng.Intn(len(g.table.Columns))] {
+		cols = append(cols, g.table.Columns[i].Name)
+	}
+	sql := "SELECT " + strings.Join(cols, ", ") + " FROM $t WHERE " + g.condition(3)
+	ref := " ORDER BY rowid"
+	limit := ""
+	if g.rng.Intn(3) == 0 {
+		limit = fmt.Sprintf(" LIMIT %d", g.rng.Intn(20))
+	}
+	return Case{Sieswi: sql + limit, Reference: sql + ref + limit, Ordered: true}
+}
+
+// grouped aggregates the rows matching a condition by one or two text
+// columns, with an optional HAVING, and ORDER BY the groups when limited
+func (g generator) grouped() Case {
+	groupBy := []string{"country"}
+	if g.rng.Intn(2) == 0 {
+		groupBy = append(groupBy, "status")
+	}
+	aggregates := []string{"COUNT(*)", "SUM(qty)", "MIN(price)", "MAX(price)", "AVG(price)", "SUM(price)", "MIN(qty)"}
+	g.rng.Shuffle(len(aggregates), func(i, j int) { aggregates[i], aggregates[j] = aggregates[j], aggregates[i] })
+	selected := append(append([]string(nil), groupBy...), aggregates[:1+g.rng.Intn(3)]...)
+
+	sql := "SELECT " + strings.Join(selected, ", ") + " FROM $t"
+	if g.rng.Intn(4) != 0 {
+		sql += " WHERE " + g.condition(2)
+	}
+	sql += " GROUP BY " + strings.Join(groupBy, ", ")
+	if g.rng.Intn(3) == 0 {
+		sql += fmt.Sprintf(" HAVING COUNT(*) > %d", g.rng.Intn(30))
+	}
+	if g.rng.Intn(2) == 0 {
+		order := []string{"1"}
+		if len(groupBy) == 2 {
+			order = append(order, "2")
+		}
+		sql += " ORDER BY " + strings.Join(order, ", ") + fmt.Sprintf(" LIMIT %d", 1+g.rng.Intn(8))
+		return Case{Sieswi: sql, Ordered: true}
+	}
+	return Case{Sieswi: sql}
+}
+
+// count counts the rows matching a condition
+func (g generator) count() Case {
+	return Case{Sieswi: "SELECT COUNT(*) FROM $t WHERE " + g.condition(4), Ordered: true}
+}
+
+// condition returns a WHERE condition of at most depth levels of AND, OR,
+// and NOT
+func (g generator) condition(depth int) string {
+	if depth > 0 {
+		switch g.rng.Intn(5) {
+		case 0:
+			return "(" + g.condition(depth-1) + " AND " + g.condition(depth-1) + ")"
+		case 1:
+			return "(" + g.condition(depth-1) + " OR " + g.condition(depth-1) + ")"
+		case 2:
+			return "NOT (" + g.condition(depth-1) + ")"
+		}
+	}
+	col := g.table.Columns[g.rng.Intn(len(g.table.Columns))]
+	ops := []string{"=", "!=", "<", "<=", ">", ">="}
+	if col.Type == "TEXT" {
+		if g.rng.Intn(4) == 0 {
+			values := make([]string, 1+g.rng.Intn(3))
+			for i := range values {
+				values[i] = "'" + col.Values[g.rng.Intn(len(col.Values))] + "'"
+			}
+			return col.Name + " IN (" + strings.Join(values, ", ") + ")"
+		}
+		return col.Name + " " + ops[g.rng.Intn(len(ops))] + " '" + col.Values[g.rng.Intn(len(col.Values))] + "'"
+	}
+	hi := col.Max
+	if col.Name == "id" {
+		hi = len(g.table.Rows) + 1
+	}
+	value := col.Min + g.rng.Intn(hi-col.Min+1)
+	if g.rng.Intn(5) == 0 {
+		other := g.table.Columns[1+g.rng.Intn(2)] // qty or price
+		return col.Name + " " + ops[g.rng.Intn(len(ops))] + " " + other.Name
+	}
+	return col.Name + " " + ops[g.rng.Intn(len(ops))] + " " + strconv.Itoa(value)
+}
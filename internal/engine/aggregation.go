@@ -1,8 +1,6 @@
 package engine
 
 import (
-	"bufio"
-	"encoding/csv"
 	"fmt"
 	"io"
 	"os"
@@ -10,6 +8,9 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
+	"github.com/melihbirim/sieswi/internal/sidx"
 	"github.com/melihbirim/sieswi/internal/sqlparser"
 )
 
@@ -18,6 +19,11 @@ type AggregateFunc struct {
 	FuncName string // COUNT, SUM, AVG, MIN, MAX
 	Column   string // Column name, or "*" for COUNT(*)
 	Alias    string // Original expression (e.g., "COUNT(*)")
+	// Expr is the argument of an aggregate over an expression, such as
+	// SUM(price * qty), whose text is in Column
+	Expr sqlparser.Scalar
+	// Filter limits the rows the aggregate sees, from FILTER (WHERE ...)
+	Filter sqlparser.Expression
 }
 
 // Aggregator accumulates values for aggregation
@@ -29,6 +35,13 @@ type Aggregator struct {
 	Maxs     map[int]float64 // MAX per aggregate index
 	HasMin   map[int]bool    // Track if MIN has been set
 	HasMax   map[int]bool    // Track if MAX has been set
+	// Decimals holds the exact SUM/AVG per aggregate index while every value
+	// is a plain decimal; Inexact marks the ones that fell back to Sums
+	Decimals map[int]datatype.Decimal
+	Inexact  map[int]bool
+	// Fractional marks the MIN/MAX aggregates that saw a value that isn't a
+	// plain whole number, so their result keeps its decimals
+	Fractional map[int]bool
 }
 
 func newAggregator() *Aggregator {
@@ -39,10 +52,136 @@ func newAggregator() *Aggregator {
 		Maxs:   make(map[int]float64),
 		HasMin: make(map[int]bool),
 		HasMax: make(map[int]bool),
+
+		Decimals:   make(map[int]datatype.Decimal),
+		Inexact:    make(map[int]bool),
+		Fractional: make(map[int]bool),
 	}
 }
 
-var aggregateFuncRe = regexp.MustCompile(`(?i)^(COUNT|SUM|AVG|MIN|MAX)\s*\(\s*([*a-zA-Z0-9_]+)\s*\)$`)
+// addDecimal adds field to the exact sum for aggregate i. A value that isn't
+// a plain decimal, or a sum that overflows, leaves the float sum in charge.
+func (a *Aggregator) addDecimal(i int, field string) {
+	if a.Inexact[i] {
+		return
+	}
+	d, ok := datatype.ParseDecimal(field)
+	if ok {
+		d, ok = a.Decimals[i].Add(d)
+	}
+	if !ok {
+		a.Inexact[i] = true
+		return
+	}
+	a.Decimals[i] = d
+}
+
+// add folds the numeric value of aggregate i, whose function is fn, into
+// the accumulator; field is its text for exact sums and whole results
+func (a *Aggregator) add(i int, fn string, val float64, field string) {
+	switch fn {
+	case "SUM", "AVG":
+		a.Sums[i] += val
+		a.Counts[i]++
+		a.addDecimal(i, field)
+	case "MIN", "MAX":
+		if d, ok := datatype.ParseDecimal(field); !ok || d.Scale > 0 {
+			a.Fractional[i] = true
+		}
+		a.addExtreme(i, fn, val)
+	}
+}
+
+// addExtreme folds val into the MIN or MAX of aggregate i
+func (a *Aggregator) addExtreme(i int, fn string, val float64) {
+	switch fn {
+	case "MIN":
+		if !a.HasMin[i] || val < a.Mins[i] {
+			a.Mins[i] = val
+			a.HasMin[i] = true
+		}
+	case "MAX":
+		if !a.HasMax[i] || val > a.Maxs[i] {
+			a.Maxs[i] = val
+			a.HasMax[i] = true
+		}
+	}
+}
+
+// result formats the value of aggregate i for output
+func (a *Aggregator) result(i int, aggFunc *AggregateFunc, format aggregateFormat) string {
+	shift := format.shift(aggFunc)
+	switch aggFunc.FuncName {
+	case "COUNT":
+		count := a.RowCount
+		if aggFunc.Filter != nil || aggFunc.Column != "*" {
+			count = a.Counts[i]
+		}
+		return format.count(count)
+	case "SUM":
+		if a.Inexact[i] {
+			return format.float(a.Sums[i], shift)
+		}
+		return format.decimal(a.Decimals[i], shift)
+	case "AVG":
+		if a.Counts[i] == 0 {
+			return "0"
+		}
+		sum := a.Sums[i]
+		if !a.Inexact[i] {
+			sum = a.Decimals[i].Float64()
+		}
+		return format.float(sum/float64(a.Counts[i]), shift)
+	case "MIN":
+		if a.HasMin[i] {
+			return format.extreme(a.Mins[i], shift, !a.Fractional[i])
+		}
+	case "MAX":
+		if a.HasMax[i] {
+			return format.extreme(a.Maxs[i], shift, !a.Fractional[i])
+		}
+	}
+	return format.Null
+}
+
+// addBlock folds a block's stats for the column of aggregate i into the
+// accumulator. The block has rows rows, none holding an invalid value.
+func (a *Aggregator) addBlock(i int, fn string, col *sidx.ColumnStats, rows int64) {
+	values := rows - int64(col.EmptyCount)
+	if values == 0 {
+		return
+	}
+	switch fn {
+	case "COUNT":
+		a.Counts[i] += values
+	case "SUM", "AVG":
+		a.Sums[i] += col.Sum
+		a.Counts[i] += values
+		if a.Inexact[i] {
+			return
+		}
+		d, ok := a.Decimals[i].Add(col.SumDecimal)
+		if col.SumInexact || !ok {
+			a.Inexact[i] = true
+			return
+		}
+		a.Decimals[i] = d
+	case "MIN", "MAX":
+		// A block's exact sum has as many decimals as its longest value
+		if col.SumDecimal.Scale > 0 || col.SumInexact || !isWhole(col.MinNum) || !isWhole(col.MaxNum) {
+			a.Fractional[i] = true
+		}
+		if fn == "MIN" {
+			a.addExtreme(i, fn, col.MinNum)
+		} else {
+			a.addExtreme(i, fn, col.MaxNum)
+		}
+	}
+}
+
+// aggregateFuncRe matches an aggregate over * or a column, whose name (with
+// quotes already removed by the parser) may hold spaces, dots, or parentheses
+var aggregateFuncRe = regexp.MustCompile(`(?i)^(COUNT|SUM|AVG|MIN|MAX)\s*\(\s*(.+?)\s*\)$`)
 
 // parseAggregateFunc checks if a column expression is an aggregate function
 func parseAggregateFunc(expr string) (*AggregateFunc, bool) {
@@ -59,8 +198,9 @@ func parseAggregateFunc(expr string) (*AggregateFunc, bool) {
 	}, true
 }
 
-// executeGroupBy handles GROUP BY queries with aggregations
-func executeGroupBy(query sqlparser.Query, reader *csv.Reader, header []string, out io.Writer) error {
+// executeGroupBy handles GROUP BY queries with aggregations. A first record
+// held back by streamHeader is aggregated before the reader's.
+func executeGroupBy(query sqlparser.Query, reader *csvio.Reader, header, first []string, out io.Writer, stats *Stats) error {
 	// Parse SELECT columns to identify group columns and aggregate functions
 	var groupCols []string
 	var aggregates []*AggregateFunc
@@ -69,11 +209,34 @@ func executeGroupBy(query sqlparser.Query, reader *csv.Reader, header []string,
 		return fmt.Errorf("SELECT * not supported with GROUP BY, please specify columns")
 	}
 
-	for _, col := range query.Columns {
+	// Output names: an aggregate's alias replaces its text, a group column's
+	// alias its GROUP BY name
+	groupNames := make(map[string]string)
+	for i, col := range query.Columns {
+		var alias string
+		if i < len(query.Aliases) {
+			alias = query.Aliases[i]
+		}
+		var expr sqlparser.Scalar
+		if i < len(query.Exprs) {
+			expr = query.Exprs[i]
+		}
 		if agg, isAgg := parseAggregateFunc(col); isAgg {
+			if alias != "" {
+				agg.Alias = alias
+			}
+			agg.Expr = expr
+			if i < len(query.Filters) {
+				agg.Filter = query.Filters[i]
+			}
 			aggregates = append(aggregates, agg)
+		} else if expr != nil {
+			return fmt.Errorf("computed column %s not supported with GROUP BY or aggregates", col)
 		} else {
 			groupCols = append(groupCols, strings.TrimSpace(col))
+			if alias != "" {
+				groupNames[strings.ToLower(strings.TrimSpace(col))] = alias
+			}
 		}
 	}
 
@@ -82,11 +245,26 @@ func executeGroupBy(query sqlparser.Query, reader *csv.Reader, header []string,
 		return fmt.Errorf("all non-aggregate columns in SELECT must appear in GROUP BY")
 	}
 
+	// Aggregates only HAVING or ORDER BY name are computed after the
+	// selected ones, and not written
+	selected := len(aggregates)
+	hidden, err := hiddenAggregates(query, groupNames, aggregates)
+	if err != nil {
+		return err
+	}
+	aggregates = append(aggregates, hidden...)
+	selector, err := newGroupSelector(query, groupNames, aggregates)
+	if err != nil {
+		return err
+	}
+
 	// Normalize headers for case-insensitive matching
 	normalizedHeaders := make(map[string]int)
 	for i, h := range header {
 		normalizedHeaders[strings.ToLower(strings.TrimSpace(h))] = i
 	}
+	pseudo := resolvePseudoColumns(query, normalizedHeaders, len(header))
+	lines := headerLines(query.NoHeader)
 
 	// Find indices for GROUP BY columns
 	groupByIndices := make([]int, len(query.GroupBy))
@@ -98,13 +276,30 @@ func executeGroupBy(query sqlparser.Query, reader *csv.Reader, header []string,
 		groupByIndices[i] = idx
 	}
 
-	// Find indices for aggregate columns
+	// Find indices for aggregate columns; an aggregate over an expression
+	// evaluates it instead
 	aggregateIndices := make([]int, len(aggregates))
+	aggregateExprs := make([]boundScalar, len(aggregates))
+	aggregateFilters := make([]vectorFilter, len(aggregates))
 	for i, agg := range aggregates {
+		filter, err := compileVectorFilter(agg.Filter, normalizedHeaders)
+		if err != nil {
+			return err
+		}
+		aggregateFilters[i] = filter
 		if agg.FuncName == "COUNT" && agg.Column == "*" {
 			aggregateIndices[i] = -1 // Special case for COUNT(*)
 			continue
 		}
+		if agg.Expr != nil {
+			aggregateIndices[i] = -1
+			expr, err := bindScalar(agg.Expr, normalizedHeaders)
+			if err != nil {
+				return err
+			}
+			aggregateExprs[i] = expr
+			continue
+		}
 		idx, ok := normalizedHeaders[strings.ToLower(agg.Column)]
 		if !ok {
 			return fmt.Errorf("aggregate column not found: %s", agg.Column)
@@ -112,169 +307,615 @@ func executeGroupBy(query sqlparser.Query, reader *csv.Reader, header []string,
 		aggregateIndices[i] = idx
 	}
 
-	// Accumulate groups in memory
-	groups := make(map[string]*Aggregator)
-	groupKeys := []string{} // Preserve insertion order
+	// Resolve WHERE columns once; rows are then tested in place
+	filter, err := compileVectorFilter(query.Where, normalizedHeaders)
+	if err != nil {
+		return err
+	}
+
+	guard, err := newRowGuard(query, len(header), stats)
+	if err != nil {
+		return err
+	}
+	defer guard.close()
+
+	// Accumulate groups in memory, presized from index statistics when available
+	groupHint := 0
+	if query.IndexMode != sqlparser.IndexModeOff {
+		groupHint = estimateGroupCount(query.FilePath, query.GroupBy)
+	}
+	table := &groupTable{
+		groupBy:    groupByIndices,
+		aggregates: aggregates,
+		indices:    aggregateIndices,
+		exprs:      aggregateExprs,
+		filters:    aggregateFilters,
+		numbers:    query.Numbers,
+		groups:     make(map[string]*Aggregator, groupHint),
+		keys:       make([]string, 0, groupHint), // Preserve insertion order
+	}
 
+	// GROUP BY, then HAVING, then ORDER BY, then LIMIT. HAVING and ORDER BY
+	// read exact rows, which are rendered once selected.
 	reader.ReuseRecord = true
-	reader.FieldsPerRecord = -1
+	timer := &rowTimer{stats: stats}
+	var rows operator = &scanOp{reader: reader, first: first, guard: guard, pseudo: pseudo, lines: lines, stats: stats, timer: timer}
+	rows = &filterOp{child: rows, filter: filter, stats: stats, timer: timer}
+	if sample := newSampler(query.Sample); sample != nil {
+		rows = &sampleOp{child: rows, sample: sample}
+	}
+	output := aggregateFormat{OutputFormat: query.Output}
+	if selector != nil {
+		rows = &aggregateOp{child: rows, table: table, format: aggregateFormat{exact: true}, width: len(aggregates)}
+		rows = selector.plan(rows)
+		rows = &limitOp{child: rows, n: query.Limit}
+		rows = &renderOp{child: rows, table: table, format: output, width: selected}
+	} else {
+		rows = &aggregateOp{child: rows, table: table, format: output, width: selected}
+		rows = &limitOp{child: rows, n: query.Limit}
+	}
 
-	rowCount := 0
-	for {
-		row, err := reader.Read()
-		if err == io.EOF {
-			break
+	// Write output header
+	writer := NewFastCSVWriterSize(out, query.Resources.IOBufferSize)
+	outputHeader := make([]string, 0, len(groupCols)+len(aggregates))
+	for _, col := range query.GroupBy {
+		if alias, ok := groupNames[strings.ToLower(strings.TrimSpace(col))]; ok {
+			col = alias
 		}
-		if err != nil {
-			return fmt.Errorf("read row %d: %w", rowCount+1, err)
+		outputHeader = append(outputHeader, col)
+	}
+	for _, agg := range aggregates[:selected] {
+		outputHeader = append(outputHeader, agg.Alias)
+	}
+	if err := writer.Write(outputHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if err := writeRows(writer, rows, stats, nil); err != nil {
+		return err
+	}
+	return guard.close()
+}
+
+// groupTable accumulates the groups of an aggregate query, in order of
+// first appearance
+type groupTable struct {
+	groupBy    []int
+	aggregates []*AggregateFunc
+	indices    []int // Column each aggregate reads; -1 for COUNT(*) and expressions
+	exprs      []boundScalar
+	filters    []vectorFilter
+	numbers    datatype.NumberFormat
+	groups     map[string]*Aggregator
+	keys       []string
+}
+
+// input reads aggregate i's value from row, with the text of a plain
+// decimal for exact sums. Values that aren't numbers are skipped.
+func (t *groupTable) input(i int, row []string) (float64, string, bool) {
+	if expr := t.exprs[i]; expr != nil {
+		val, ok := expr.eval(row, t.numbers).Float(t.numbers)
+		return val, strconv.FormatFloat(val, 'f', -1, 64), ok
+	}
+	idx := t.indices[i]
+	if idx < 0 || idx >= len(row) {
+		return 0, "", false
+	}
+	field, ok := t.numbers.Normalize(row[idx])
+	val, err := strconv.ParseFloat(field, 64)
+	return val, field, ok && err == nil
+}
+
+// present reports whether the value COUNT(column) or COUNT(expression)
+// counts isn't NULL: empty, or past the end of a short row
+func (t *groupTable) present(i int, row []string) bool {
+	if expr := t.exprs[i]; expr != nil {
+		v := expr.eval(row, t.numbers)
+		return !v.Null && v.String() != ""
+	}
+	idx := t.indices[i]
+	return idx >= 0 && idx < len(row) && row[idx] != ""
+}
+
+// add folds row into its group
+func (t *groupTable) add(row []string) {
+	// Build group key from GROUP BY columns
+	keyParts := make([]string, len(t.groupBy))
+	for i, idx := range t.groupBy {
+		if idx < len(row) {
+			keyParts[i] = row[idx]
 		}
-		rowCount++
+	}
+	groupKey := strings.Join(keyParts, "\x00") // Use null byte as separator
+
+	// Get or create aggregator for this group
+	agg, exists := t.groups[groupKey]
+	if !exists {
+		agg = newAggregator()
+		t.groups[groupKey] = agg
+		t.keys = append(t.keys, groupKey)
+	}
 
-		// Apply WHERE filter if present
-		if query.Where != nil {
-			rowMap := make(map[string]string)
-			for idx, val := range row {
-				if idx < len(header) {
-					rowMap[strings.ToLower(header[idx])] = val
-				}
-			}
-			if !sqlparser.EvaluateNormalized(query.Where, rowMap) {
-				continue
+	// Increment row count for this group (for COUNT(*))
+	agg.RowCount++
+
+	// Update aggregates
+	for i, aggFunc := range t.aggregates {
+		if filter := t.filters[i]; filter != nil && !filter.match(row) {
+			continue
+		}
+		if aggFunc.FuncName == "COUNT" {
+			// COUNT(*) is RowCount unless filtered; COUNT(column) skips
+			// NULLs
+			if aggFunc.Column == "*" || t.present(i, row) {
+				agg.Counts[i]++
 			}
+			continue
+		}
+		if val, field, ok := t.input(i, row); ok {
+			agg.add(i, aggFunc.FuncName, val, field)
 		}
+	}
+}
 
-		// Build group key from GROUP BY columns
-		keyParts := make([]string, len(groupByIndices))
-		for i, idx := range groupByIndices {
-			if idx >= len(row) {
-				keyParts[i] = ""
-			} else {
-				keyParts[i] = row[idx]
+// row renders a group as its key columns and first width aggregates. Empty
+// keys are written as the NULL token, unless the row is exact.
+func (t *groupTable) row(groupKey string, f aggregateFormat, width int) []string {
+	row := make([]string, 0, len(t.groupBy)+width)
+	if len(t.groupBy) > 0 {
+		for _, key := range strings.Split(groupKey, "\x00") {
+			if key == "" && !f.exact {
+				key = f.Null // Empty and missing keys are one NULL group
 			}
+			row = append(row, key)
 		}
-		groupKey := strings.Join(keyParts, "\x00") // Use null byte as separator
-
-		// Get or create aggregator for this group
-		agg, exists := groups[groupKey]
-		if !exists {
-			agg = newAggregator()
-			groups[groupKey] = agg
-			groupKeys = append(groupKeys, groupKey)
-		}
-
-		// Increment row count for this group (for COUNT(*))
-		agg.RowCount++
-
-		// Update aggregates
-		for i, aggFunc := range aggregates {
-			switch aggFunc.FuncName {
-			case "COUNT":
-				// COUNT(*) already handled by RowCount
-				// COUNT(column) would be the same in our case
-			case "SUM", "AVG":
-				if aggregateIndices[i] >= 0 && aggregateIndices[i] < len(row) {
-					if val, err := strconv.ParseFloat(row[aggregateIndices[i]], 64); err == nil {
-						agg.Sums[i] += val
-						agg.Counts[i]++
-					}
-				}
-			case "MIN":
-				if aggregateIndices[i] >= 0 && aggregateIndices[i] < len(row) {
-					if val, err := strconv.ParseFloat(row[aggregateIndices[i]], 64); err == nil {
-						if !agg.HasMin[i] || val < agg.Mins[i] {
-							agg.Mins[i] = val
-							agg.HasMin[i] = true
-						}
-					}
-				}
-			case "MAX":
-				if aggregateIndices[i] >= 0 && aggregateIndices[i] < len(row) {
-					if val, err := strconv.ParseFloat(row[aggregateIndices[i]], 64); err == nil {
-						if !agg.HasMax[i] || val > agg.Maxs[i] {
-							agg.Maxs[i] = val
-							agg.HasMax[i] = true
-						}
-					}
-				}
-			}
+	}
+	for i, aggFunc := range t.aggregates[:width] {
+		row = append(row, t.groups[groupKey].result(i, aggFunc, f))
+	}
+	return row
+}
+
+// aggregateOp reads all its child's rows into a groupTable, then returns
+// one row per group in order of first appearance
+type aggregateOp struct {
+	child  operator
+	table  *groupTable
+	format aggregateFormat
+	width  int // Aggregates per row
+	pos    int // Groups returned
+	done   bool
+}
+
+func (a *aggregateOp) next() ([]string, error) {
+	t := a.table
+	if !a.done {
+		if err := drain(a.child, t.add); err != nil {
+			return nil, err
+		}
+		// Aggregates without GROUP BY always produce one row, even for no input
+		if len(t.groupBy) == 0 && len(t.keys) == 0 {
+			t.groups[""] = newAggregator()
+			t.keys = append(t.keys, "")
 		}
+		a.done = true
+	}
+	if a.pos >= len(t.keys) {
+		return nil, io.EOF
 	}
+	a.pos++
+	return t.row(t.keys[a.pos-1], a.format, a.width), nil
+}
 
-	// Write output header
-	writer := csv.NewWriter(out)
-	outputHeader := make([]string, 0, len(groupCols)+len(aggregates))
-	outputHeader = append(outputHeader, query.GroupBy...)
-	for _, agg := range aggregates {
-		outputHeader = append(outputHeader, agg.Alias)
+// renderOp renders the exact rows of an aggregateOp, dropping the
+// aggregates only HAVING and ORDER BY read
+type renderOp struct {
+	child  operator
+	table  *groupTable
+	format aggregateFormat
+	width  int
+}
+
+func (r *renderOp) next() ([]string, error) {
+	row, err := r.child.next()
+	if err != nil {
+		return nil, err
 	}
-	if err := writer.Write(outputHeader); err != nil {
-		return fmt.Errorf("write header: %w", err)
+	return r.table.row(strings.Join(row[:len(r.table.groupBy)], "\x00"), r.format, r.width), nil
+}
+
+// maxGroupHint caps presizing so a poor estimate cannot over-allocate
+const maxGroupHint = 1 << 20
+
+// estimateGroupCount bounds the number of groups by the product of the GROUP BY
+// columns' distinct estimates from the .sidx index. Returns 0 when no valid
+// index with distinct estimates covers every group column.
+func estimateGroupCount(filePath string, groupBy []string) int {
+	if filePath == "" || filePath == "-" {
+		return 0
+	}
+	index, release, err := sidx.DefaultCache.Acquire(filePath)
+	defer release()
+	if err != nil || index == nil {
+		if err != nil && os.Getenv("SIDX_DEBUG") == "1" {
+			fmt.Fprintf(os.Stderr, "[sidx] Not using index for GROUP BY estimate: %v\n", err)
+		}
+		return 0
 	}
 
-	// Write aggregated results (in order of first appearance)
-	outputCount := 0
-	for _, groupKey := range groupKeys {
-		if query.Limit >= 0 && outputCount >= query.Limit {
-			break
+	limit := index.Header.TotalRows
+	if limit > maxGroupHint {
+		limit = maxGroupHint
+	}
+	est := uint64(1)
+	for _, col := range groupBy {
+		n, ok := index.DistinctCount(col)
+		if !ok {
+			return 0
+		}
+		if n == 0 {
+			n = 1 // All-empty column still forms one group
 		}
+		if est > limit/n {
+			return int(limit)
+		}
+		est *= n
+	}
+	if est > limit {
+		est = limit
+	}
+	return int(est)
+}
 
-		agg := groups[groupKey]
-		keyParts := strings.Split(groupKey, "\x00")
+// isAggregateQuery reports whether query aggregates rows: it has a GROUP BY
+// or selects aggregates over the whole file (SELECT COUNT(*) FROM ...)
+func isAggregateQuery(query sqlparser.Query) bool {
+	if len(query.GroupBy) > 0 {
+		return true
+	}
+	for _, col := range query.Columns {
+		if _, ok := parseAggregateFunc(col); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// countFromIndex answers an ungrouped SELECT COUNT(*) from a valid .sidx
+// index. Without WHERE the recorded row count is the answer; with WHERE,
+// blocks the filter prunes count zero, blocks whose stats show every row
+// matching count whole, and only the remaining blocks are read. It reports
+// false when the query isn't such a count (or samples, filters a count or
+// its result, or checks rows for malformed ones) or there is no usable
+// index.
+func countFromIndex(query sqlparser.Query, out io.Writer, stats *Stats) (bool, error) {
+	if len(query.GroupBy) > 0 || query.AllColumns || len(query.Columns) == 0 || query.Sample != nil || query.OnError != "" || query.Filters != nil || query.Having != nil || query.OrderBy != nil {
+		return false, nil
+	}
+	for _, col := range query.Columns {
+		agg, ok := parseAggregateFunc(col)
+		if !ok || agg.FuncName != "COUNT" || agg.Column != "*" {
+			return false, nil
+		}
+	}
+
+	index, release, err := acquireIndex(query)
+	defer release()
+	if err != nil || index == nil {
+		return false, nil
+	}
 
-		outputRow := make([]string, 0, len(groupCols)+len(aggregates))
-		outputRow = append(outputRow, keyParts...)
+	count := index.Header.TotalRows
+	if query.Where != nil {
+		if count, err = countMatchingRows(query, index, stats); err != nil {
+			return true, err
+		}
+	} else {
+		stats.addSkipped(index.Header.FileSize)
+		stats.addMatched(int64(count))
+		stats.addBlocks(len(index.Blocks), len(index.Blocks))
+	}
+	if os.Getenv("SIDX_DEBUG") == "1" {
+		fmt.Fprintf(os.Stderr, "[sidx] COUNT(*) answered from index: %d rows\n", count)
+	}
 
-		for i, aggFunc := range aggregates {
-			var value string
-			switch aggFunc.FuncName {
-			case "COUNT":
-				value = fmt.Sprintf("%d", agg.RowCount)
-			case "SUM":
-				value = fmt.Sprintf("%.2f", agg.Sums[i])
-			case "AVG":
-				if agg.Counts[i] > 0 {
-					value = fmt.Sprintf("%.2f", agg.Sums[i]/float64(agg.Counts[i]))
-				} else {
-					value = "0"
+	writer := NewFastCSVWriterSize(out, query.Resources.IOBufferSize)
+	header := make([]string, len(query.Columns))
+	row := make([]string, len(query.Columns))
+	for i, col := range query.Columns {
+		header[i] = strings.TrimSpace(col)
+		if i < len(query.Aliases) && query.Aliases[i] != "" {
+			header[i] = query.Aliases[i]
+		}
+		row[i] = aggregateFormat{OutputFormat: query.Output}.count(int64(count))
+	}
+	if err := writer.Write(header); err != nil {
+		return true, fmt.Errorf("write header: %w", err)
+	}
+	if query.Limit != 0 {
+		if err := writer.Write(row); err != nil {
+			return true, fmt.Errorf("write row: %w", err)
+		}
+		stats.addReturned(1)
+	}
+	writer.Flush()
+	return true, writer.Error()
+}
+
+// aggregateFromIndex answers an ungrouped query of COUNT, SUM, AVG, MIN, and
+// MAX over columns from a valid .sidx index. Blocks whose every row matches
+// contribute the partials their stats hold: row counts, numeric bounds, and
+// the sums of columns indexed with --sums. Blocks the filter prunes are
+// skipped, so only the blocks straddling its boundary are read. It reports
+// false when the query isn't such an aggregate or the index lacks a column
+// or sum it needs.
+func aggregateFromIndex(query sqlparser.Query, out io.Writer, stats *Stats) (bool, error) {
+	if len(query.GroupBy) > 0 || query.AllColumns || len(query.Columns) == 0 || query.Sample != nil || query.OnError != "" || query.Filters != nil || query.Having != nil || query.OrderBy != nil {
+		return false, nil
+	}
+	aggregates := make([]*AggregateFunc, len(query.Columns))
+	for i, col := range query.Columns {
+		agg, ok := parseAggregateFunc(col)
+		if !ok || (i < len(query.Exprs) && query.Exprs[i] != nil) {
+			return false, nil
+		}
+		if i < len(query.Aliases) && query.Aliases[i] != "" {
+			agg.Alias = query.Aliases[i]
+		}
+		aggregates[i] = agg
+	}
+
+	index, release, err := acquireIndex(query)
+	defer release()
+	if err != nil || index == nil {
+		return false, nil
+	}
+
+	// Each SUM, AVG, MIN, or MAX needs its column's dictionary position, and
+	// its position among the values read from scanned blocks
+	columns := make([]int, len(aggregates))
+	reads := make([]int, len(aggregates))
+	var names []string
+	for i, agg := range aggregates {
+		columns[i], reads[i] = -1, -1
+		if agg.Column == "*" {
+			continue
+		}
+		col, ok := index.Column(agg.Column)
+		if !ok {
+			return false, nil
+		}
+		if agg.FuncName == "COUNT" {
+			// Counts the values that aren't empty, of any type
+			columns[i], reads[i] = col, len(names)
+			names = append(names, agg.Column)
+			continue
+		}
+		info := &index.Header.Columns[col]
+		if info.Type != sidx.ColumnTypeNumeric || (!info.Sums && (agg.FuncName == "SUM" || agg.FuncName == "AVG")) {
+			return false, nil
+		}
+		columns[i], reads[i] = col, len(names)
+		names = append(names, agg.Column)
+	}
+
+	result := newAggregator()
+	blockStats := make([]*sidx.ColumnStats, len(columns))
+	scan, err := scanIndexBlocks(query, index, stats, names,
+		func(block *sidx.BlockMeta) bool {
+			// Values that don't parse are skipped by a scan but unknown to
+			// the stats, so such blocks are read
+			for i, col := range columns {
+				if col < 0 {
+					continue
 				}
-			case "MIN":
-				if agg.HasMin[i] {
-					value = fmt.Sprintf("%.2f", agg.Mins[i])
-				} else {
-					value = ""
+				blockStats[i] = index.Stats(block, col)
+				if blockStats[i] == nil || blockStats[i].InvalidCount > 0 && aggregates[i].FuncName != "COUNT" {
+					return false
 				}
-			case "MAX":
-				if agg.HasMax[i] {
-					value = fmt.Sprintf("%.2f", agg.Maxs[i])
-				} else {
-					value = ""
+			}
+			rows := int64(block.EndRow - block.StartRow)
+			result.RowCount += rows
+			for i, col := range columns {
+				if col >= 0 {
+					result.addBlock(i, aggregates[i].FuncName, blockStats[i], rows)
 				}
 			}
-			outputRow = append(outputRow, value)
+			return true
+		},
+		func(values [][]byte) {
+			result.RowCount++
+			for i, r := range reads {
+				if r < 0 || values[r] == nil {
+					continue
+				}
+				if aggregates[i].FuncName == "COUNT" {
+					if len(values[r]) > 0 {
+						result.Counts[i]++
+					}
+					continue
+				}
+				field, ok := query.Numbers.Normalize(string(values[r]))
+				if val, err := strconv.ParseFloat(field, 64); ok && err == nil {
+					result.add(i, aggregates[i].FuncName, val, field)
+				}
+			}
+		})
+	if err != nil {
+		return true, err
+	}
+	if os.Getenv("SIDX_DEBUG") == "1" {
+		fmt.Fprintf(os.Stderr, "[sidx] Aggregate blocks: %d pruned, %d taken from stats, %d scanned\n",
+			scan.pruned, scan.whole, scan.scanned)
+	}
+
+	writer := NewFastCSVWriterSize(out, query.Resources.IOBufferSize)
+	header := make([]string, len(aggregates))
+	row := make([]string, len(aggregates))
+	for i, agg := range aggregates {
+		header[i] = agg.Alias
+		row[i] = result.result(i, agg, aggregateFormat{OutputFormat: query.Output})
+	}
+	if err := writer.Write(header); err != nil {
+		return true, fmt.Errorf("write header: %w", err)
+	}
+	if query.Limit != 0 {
+		if err := writer.Write(row); err != nil {
+			return true, fmt.Errorf("write row: %w", err)
+		}
+		stats.addReturned(1)
+	}
+	writer.Flush()
+	return true, writer.Error()
+}
+
+// countMatchingRows counts the rows matching query.Where block by block,
+// only reading the blocks whose stats can't decide the answer
+func countMatchingRows(query sqlparser.Query, index *sidx.Index, stats *Stats) (uint64, error) {
+	var count uint64
+	scan, err := scanIndexBlocks(query, index, stats, nil,
+		func(block *sidx.BlockMeta) bool {
+			count += block.EndRow - block.StartRow
+			return true
+		},
+		func([][]byte) { count++ })
+	if err != nil {
+		return 0, err
+	}
+	if os.Getenv("SIDX_DEBUG") == "1" {
+		fmt.Fprintf(os.Stderr, "[sidx] COUNT(*) blocks: %d pruned, %d counted from stats, %d scanned\n",
+			scan.pruned, scan.whole, scan.scanned)
+	}
+	return count, nil
+}
+
+// indexScan tallies how scanIndexBlocks settled the blocks of an index
+type indexScan struct {
+	pruned, whole, scanned int
+}
+
+// scanIndexBlocks visits the rows matching query.Where block by block, so
+// that only blocks whose stats can't stand in for their rows are read.
+// Blocks the filter prunes are skipped. A block whose every row matches is
+// offered to whole, which takes it from its stats by returning true. The
+// rest are read, and each matching row's values of columns go to row (nil
+// for a column past the end of a short row).
+func scanIndexBlocks(query sqlparser.Query, index *sidx.Index, stats *Stats, columns []string, whole func(block *sidx.BlockMeta) bool, row func(values [][]byte)) (indexScan, error) {
+	var scan indexScan
+	file, err := os.Open(query.FilePath)
+	if err != nil {
+		return scan, fmt.Errorf("open CSV: %w", err)
+	}
+	defer file.Close()
+
+	header, _, err := inputHeader(file, query)
+	if err != nil {
+		return scan, err
+	}
+	normalisedIndex := make(map[string]int, len(header))
+	for idx, name := range header {
+		normalisedIndex[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	pseudo := resolvePseudoColumns(query, normalisedIndex, len(header))
+	lines := headerLines(query.NoHeader)
+	filter, err := compileVectorFilter(query.Where, normalisedIndex)
+	if err != nil {
+		return scan, err
+	}
+	columnIndices := make([]int, len(columns))
+	for i, col := range columns {
+		idx, ok := normalisedIndex[strings.ToLower(strings.TrimSpace(col))]
+		if !ok {
+			return scan, fmt.Errorf("column not found: %s", col)
+		}
+		columnIndices[i] = idx
+	}
+	fieldLimit := fieldsNeeded(query.Where, normalisedIndex, columnIndices)
+	values := make([][]byte, len(columns))
+
+	lo, hi := 0, len(index.Blocks)
+	if query.Where != nil {
+		lo, hi = sortedBlockRange(index, query.Where)
+	}
+	for i := range index.Blocks {
+		block := &index.Blocks[i]
+		if i < lo || i >= hi || query.Where != nil && canPruneBlockExpr(index, block, query.Where) {
+			scan.pruned++
+			stats.addSkipped(blockBytes(block))
+			continue
+		}
+		// With no row failing the filter, every row matches
+		if (query.Where == nil || canPruneBlockNotExpr(index, block, query.Where)) && whole(block) {
+			scan.whole++
+			stats.addSkipped(blockBytes(block))
+			stats.addMatched(int64(block.EndRow - block.StartRow))
+			continue
 		}
 
-		if err := writer.Write(outputRow); err != nil {
-			return fmt.Errorf("write row: %w", err)
+		scan.scanned++
+		start, end := int64(block.StartOffset), int64(block.EndOffset)
+		reader := newFastReader(csvio.NewDecoder(stats.countReads(csvio.NewRetryReader(io.NewSectionReader(file, start, end-start), query.FilePath, start)), query.Encoding), query)
+		reader.SetFieldLimit(fieldLimit)
+		timer := rowTimer{stats: stats}
+		line := int64(block.StartRow) + lines
+		for {
+			timer.startRow()
+			fields, err := reader.ReadRaw()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return scan, fmt.Errorf("read row: %w", err)
+			}
+			stats.addScanned(1)
+			line++
+			if pseudo != nil {
+				fields = pseudo.addRaw(fields, line, start+reader.Offset())
+			}
+			timer.mark(phaseParse)
+			matched := filter == nil || filter.matchRaw(fields)
+			timer.mark(phaseFilter)
+			if !matched {
+				continue
+			}
+			stats.addMatched(1)
+			for c, idx := range columnIndices {
+				values[c] = nil
+				if idx < len(fields) {
+					values[c] = fields[idx]
+				}
+			}
+			row(values)
 		}
-		outputCount++
 	}
 
-	writer.Flush()
-	return writer.Error()
+	stats.addBlocks(len(index.Blocks), scan.pruned+scan.whole)
+	return scan, nil
 }
 
 // executeGroupByFromFile handles GROUP BY queries by opening the file and calling executeGroupBy
-func executeGroupByFromFile(query sqlparser.Query, out io.Writer) error {
+func executeGroupByFromFile(query sqlparser.Query, out io.Writer, stats *Stats) error {
+	if handled, err := countFromIndex(query, out, stats); handled {
+		return err
+	}
+	if handled, err := aggregateFromIndex(query, out, stats); handled {
+		return err
+	}
+
 	file, err := os.Open(query.FilePath)
 	if err != nil {
 		return fmt.Errorf("open CSV: %w", err)
 	}
 	defer file.Close()
 
-	buffered := bufio.NewReaderSize(file, ioBufferSize)
-	reader := csv.NewReader(buffered)
-	reader.ReuseRecord = true
-	reader.FieldsPerRecord = -1
+	reader := newCSVReader(csvio.NewDecoder(stats.countReads(csvio.NewRetryReader(file, query.FilePath, 0)), query.Encoding), query)
+
+	if query.NoHeader {
+		header, _, err := inputHeader(file, query)
+		if err != nil {
+			return err
+		}
+		return executeGroupBy(query, reader, header, nil, out, stats)
+	}
 
 	header, err := reader.Read()
 	if err != nil {
@@ -285,5 +926,5 @@ func executeGroupByFromFile(query sqlparser.Query, out io.Writer) error {
 	headerCopy := make([]string, len(header))
 	copy(headerCopy, header)
 
-	return executeGroupBy(query, reader, headerCopy, out)
+	return executeGroupBy(query, reader, headerCopy, nil, out, stats)
 }
@@ -3,11 +3,15 @@ package engine
 import (
 	"bytes"
 	"encoding/csv"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/melihbirim/sieswi/internal/sidx"
 	"github.com/melihbirim/sieswi/internal/sqlparser"
 )
 
@@ -106,11 +110,11 @@ UK,250`
 		sums[rows[i][0]] = rows[i][1]
 	}
 
-	if sums["US"] != "300.00" {
-		t.Errorf("expected US sum=300.00, got %s", sums["US"])
+	if sums["US"] != "300" {
+		t.Errorf("expected US sum=300, got %s", sums["US"])
 	}
-	if sums["UK"] != "400.00" {
-		t.Errorf("expected UK sum=400.00, got %s", sums["UK"])
+	if sums["UK"] != "400" {
+		t.Errorf("expected UK sum=400, got %s", sums["UK"])
 	}
 }
 
@@ -173,13 +177,13 @@ UK,250`
 	}
 
 	// US: min=100, max=300
-	if results["US"][0] != "100.00" || results["US"][1] != "300.00" {
-		t.Errorf("expected US min=100.00, max=300.00, got min=%s, max=%s", results["US"][0], results["US"][1])
+	if results["US"][0] != "100" || results["US"][1] != "300" {
+		t.Errorf("expected US min=100, max=300, got min=%s, max=%s", results["US"][0], results["US"][1])
 	}
 
 	// UK: min=150, max=250
-	if results["UK"][0] != "150.00" || results["UK"][1] != "250.00" {
-		t.Errorf("expected UK min=150.00, max=250.00, got min=%s, max=%s", results["UK"][0], results["UK"][1])
+	if results["UK"][0] != "150" || results["UK"][1] != "250" {
+		t.Errorf("expected UK min=150, max=250, got min=%s, max=%s", results["UK"][0], results["UK"][1])
 	}
 }
 
@@ -259,6 +263,31 @@ US,completed,50`
 	}
 }
 
+// TestGroupByWhereColumnCase checks that the compiled filter finds a WHERE
+// column written in another case than its header, as the row-by-row one did
+func TestGroupByWhereColumnCase(t *testing.T) {
+	tmpFile := createTestCSV(t, "country,amount\nUS,200\nUS,50\nUK,150")
+
+	query, err := sqlparser.Parse("SELECT country, COUNT(*) FROM '" + tmpFile + "' WHERE Amount > 100 GROUP BY Country")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Execute(query, &buf); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+
+	rows := parseCSVOutput(t, buf.String())
+	counts := make(map[string]string)
+	for i := 1; i < len(rows); i++ {
+		counts[rows[i][0]] = rows[i][1]
+	}
+	if counts["US"] != "1" || counts["UK"] != "1" {
+		t.Errorf("expected US=1 and UK=1, got %v", counts)
+	}
+}
+
 func TestGroupByWithLimit(t *testing.T) {
 	csvContent := `country,amount
 US,100
@@ -326,17 +355,45 @@ UK,150`
 	if usRow[1] != "2" {
 		t.Errorf("expected count=2, got %s", usRow[1])
 	}
-	if usRow[2] != "300.00" {
-		t.Errorf("expected sum=300.00, got %s", usRow[2])
+	if usRow[2] != "300" {
+		t.Errorf("expected sum=300, got %s", usRow[2])
 	}
 	if usRow[3] != "150.00" {
 		t.Errorf("expected avg=150.00, got %s", usRow[3])
 	}
-	if usRow[4] != "100.00" {
-		t.Errorf("expected min=100.00, got %s", usRow[4])
+	if usRow[4] != "100" {
+		t.Errorf("expected min=100, got %s", usRow[4])
 	}
-	if usRow[5] != "200.00" {
-		t.Errorf("expected max=200.00, got %s", usRow[5])
+	if usRow[5] != "200" {
+		t.Errorf("expected max=200, got %s", usRow[5])
+	}
+}
+
+func TestGroupByQuotedIdentifiers(t *testing.T) {
+	csvContent := `Order Date,user.id,Net Amount (EUR)
+2024-01-02,a,10
+2024-01-05,b,5
+2024-01-05,a,7`
+
+	tmpFile := createTestCSV(t, csvContent)
+
+	query, err := sqlparser.Parse("SELECT `user.id`, COUNT(*), SUM(\"Net Amount (EUR)\") FROM '" + tmpFile + "' WHERE [Order Date] >= '2024-01-02' GROUP BY \"user.id\"")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Execute(query, &buf); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+
+	want := [][]string{
+		{"user.id", "COUNT(*)", "SUM(Net Amount (EUR))"},
+		{"a", "2", "17"},
+		{"b", "1", "5"},
+	}
+	if rows := parseCSVOutput(t, buf.String()); !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %q, want %q", rows, want)
 	}
 }
 
@@ -384,6 +441,27 @@ US,100`
 	}
 }
 
+func TestGroupByInvalidWhereColumn(t *testing.T) {
+	csvContent := `country,amount
+US,100`
+
+	tmpFile := createTestCSV(t, csvContent)
+
+	query, err := sqlparser.Parse("SELECT country, COUNT(*) FROM '" + tmpFile + "' WHERE nonexistent > 1 GROUP BY country")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = Execute(query, &buf)
+	if err == nil {
+		t.Fatal("expected error for nonexistent WHERE column")
+	}
+	if !strings.Contains(err.Error(), "not found in CSV header") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
 func TestGroupBySelectStarError(t *testing.T) {
 	csvContent := `country,amount
 US,100`
@@ -404,3 +482,569 @@ US,100`
 		t.Errorf("unexpected error message: %v", err)
 	}
 }
+
+func TestEstimateGroupCountFromIndex(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("country,status,amount\n")
+	for i := 0; i < 500; i++ {
+		sb.WriteString([]string{"US", "UK", "DE", "FR"}[i%4] + "," + []string{"completed", "pending"}[i%2] + ",1\n")
+	}
+	csvPath := createTestCSV(t, sb.String())
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	if got := estimateGroupCount(csvPath, []string{"country"}); got != 0 {
+		t.Errorf("expected no estimate without an index, got %d", got)
+	}
+
+	writeTestIndex(t, csvPath, 100)
+	if got := estimateGroupCount(csvPath, []string{"country"}); got != 4 {
+		t.Errorf("estimate(country) = %d, want 4", got)
+	}
+	if got := estimateGroupCount(csvPath, []string{"country", "status"}); got != 8 {
+		t.Errorf("estimate(country, status) = %d, want 8", got)
+	}
+	if got := estimateGroupCount(csvPath, []string{"missing"}); got != 0 {
+		t.Errorf("expected no estimate for unindexed column, got %d", got)
+	}
+
+	query, err := sqlparser.Parse("SELECT country, COUNT(*) FROM '" + csvPath + "' GROUP BY country")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	var out bytes.Buffer
+	if err := Execute(query, &out); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	if rows := parseCSVOutput(t, out.String()); len(rows) != 5 {
+		t.Errorf("expected header + 4 groups, got %v", rows)
+	}
+}
+
+func TestAggregatesWithoutGroupBy(t *testing.T) {
+	csvContent := `country,amount
+US,100
+UK,200
+US,300`
+
+	tmpFile := createTestCSV(t, csvContent)
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{
+			query: "SELECT COUNT(*), SUM(amount) FROM '%s'",
+			want:  [][]string{{"COUNT(*)", "SUM(amount)"}, {"3", "600"}},
+		},
+		{
+			query: "SELECT COUNT(*), MAX(amount) FROM '%s' WHERE country = 'US'",
+			want:  [][]string{{"COUNT(*)", "MAX(amount)"}, {"2", "300"}},
+		},
+		{
+			// No matching rows still produces one row
+			query: "SELECT COUNT(*), MIN(amount) FROM '%s' WHERE country = 'FR'",
+			want:  [][]string{{"COUNT(*)", "MIN(amount)"}, {"0", ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		query, err := sqlparser.Parse(strings.ReplaceAll(tt.query, "%s", tmpFile))
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := Execute(query, &buf); err != nil {
+			t.Fatalf("%s: execute error: %v", tt.query, err)
+		}
+		if rows := parseCSVOutput(t, buf.String()); !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.query, rows, tt.want)
+		}
+	}
+}
+
+func TestDecimalSums(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("kind,amount\n")
+	for i := 0; i < 1000; i++ {
+		sb.WriteString("tenth,0.1\n")
+	}
+	// Minor units beyond float64's exact integers
+	sb.WriteString("big,9007199254740993\nbig,1\nbig,1\n")
+	sb.WriteString("fine,1.005\nfine,2.0001\n")
+	// One exponent value falls back to the float sum
+	sb.WriteString("mixed,0.25\nmixed,1e1\n")
+	tmpFile := createTestCSV(t, sb.String())
+
+	query, err := sqlparser.Parse("SELECT kind, SUM(amount), AVG(amount) FROM '" + tmpFile + "' GROUP BY kind")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := Execute(query, &buf); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	want := [][]string{
+		{"kind", "SUM(amount)", "AVG(amount)"},
+		{"tenth", "100.00", "0.10"},
+		{"big", "9007199254740995", "3002399751580332.00"},
+		{"fine", "3.0051", "1.50"},
+		{"mixed", "10.25", "5.12"},
+	}
+	if rows := parseCSVOutput(t, buf.String()); !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+}
+
+func TestWholeAggregates(t *testing.T) {
+	// Whole results keep decimals when any value had them, as "2.0" does
+	tmpFile := createTestCSV(t, "kind,amount\nint,-3\nint,7\nmixed,1.5\nmixed,2\npoint,2.0\npoint,4\n")
+
+	tests := []struct {
+		output sqlparser.OutputFormat
+		want   [][]string
+	}{
+		{sqlparser.OutputFormat{}, [][]string{
+			{"kind", "SUM(amount)", "MIN(amount)", "MAX(amount)", "AVG(amount)"},
+			{"int", "4", "-3", "7", "2.00"},
+			{"mixed", "3.50", "1.50", "2.00", "1.75"},
+			{"point", "6.00", "2.00", "4.00", "3.00"},
+		}},
+		{sqlparser.OutputFormat{Precision: 1, HasPrecision: true}, [][]string{
+			{"kind", "SUM(amount)", "MIN(amount)", "MAX(amount)", "AVG(amount)"},
+			{"int", "4.0", "-3.0", "7.0", "2.0"},
+			{"mixed", "3.5", "1.5", "2.0", "1.8"},
+			{"point", "6.0", "2.0", "4.0", "3.0"},
+		}},
+	}
+	for _, tt := range tests {
+		query, err := sqlparser.Parse("SELECT kind, SUM(amount), MIN(amount), MAX(amount), AVG(amount) FROM '" + tmpFile + "' GROUP BY kind")
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		query.Output = tt.output
+		var buf bytes.Buffer
+		if err := Execute(query, &buf); err != nil {
+			t.Fatalf("execute error: %v", err)
+		}
+		if rows := parseCSVOutput(t, buf.String()); !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("%+v: got %v, want %v", tt.output, rows, tt.want)
+		}
+	}
+}
+
+func TestNullAggregates(t *testing.T) {
+	// Empty values are NULL: an empty or missing key is one group, COUNT(*)
+	// counts rows, and COUNT(amount) and the others skip empty amounts
+	tmpFile := createTestCSV(t, "country,amount\nUS,10\nUS,\n,5\nUK,\n,7\n\"\",\nUS,abc\n")
+
+	tests := []struct {
+		query string
+		null  string
+		want  [][]string
+	}{
+		{
+			"SELECT country, COUNT(*), COUNT(amount), SUM(amount), AVG(amount), MIN(amount) FROM '%s' GROUP BY country",
+			"NULL",
+			[][]string{
+				{"country", "COUNT(*)", "COUNT(amount)", "SUM(amount)", "AVG(amount)", "MIN(amount)"},
+				{"US", "3", "2", "10", "10.00", "10"},
+				{"NULL", "3", "2", "12", "6.00", "5"},
+				{"UK", "1", "0", "0", "0", "NULL"},
+			},
+		},
+		{
+			"SELECT country, COUNT(amount * 2) FILTER (WHERE amount > 6) FROM '%s' GROUP BY country",
+			"",
+			[][]string{{"country", "COUNT(amount * 2) FILTER (WHERE amount > 6)"}, {"US", "1"}, {"", "1"}, {"UK", "0"}},
+		},
+		{
+			"SELECT COUNT(*), COUNT(country), COUNT(amount) FROM '%s'",
+			"",
+			[][]string{{"COUNT(*)", "COUNT(country)", "COUNT(amount)"}, {"7", "4", "4"}},
+		},
+	}
+	for _, tt := range tests {
+		query, err := sqlparser.Parse(fmt.Sprintf(tt.query, tmpFile))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		query.Output.Null = tt.null
+		var buf bytes.Buffer
+		if err := Execute(query, &buf); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if rows := parseCSVOutput(t, buf.String()); !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("%s:\ngot  %v\nwant %v", tt.query, rows, tt.want)
+		}
+	}
+}
+
+func TestHavingOrderByLimit(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("country,status,refund_minor\n")
+	refunds := map[string]int{"UK": 1200000, "US": 3400000, "DE": 900000, "FR": 3400000, "ES": 1500000, "IT": 1100000}
+	for _, country := range []string{"UK", "US", "DE", "FR", "ES", "IT"} {
+		fmt.Fprintf(&sb, "%s,refunded,%d\n%s,refunded,%d\n%s,paid,\n", country, refunds[country]/2, country, refunds[country]/2, country)
+	}
+	sb.WriteString(",refunded,5000000\n")
+	csvPath := createTestCSV(t, sb.String())
+
+	tests := []struct {
+		query  string
+		output sqlparser.OutputFormat
+		want   [][]string
+	}{
+		{
+			// Top 3 countries by refunds over 10k; US and FR tie in file order
+			"SELECT country, SUM(refund_minor) AS refunded FROM '%s' WHERE status = 'refunded' GROUP BY country HAVING refunded > 1000000 ORDER BY refunded DESC LIMIT 3",
+			sqlparser.OutputFormat{Thousands: ',', MinorUnits: map[string]int{"refund_minor": 2}},
+			[][]string{{"country", "refunded"}, {"", "50,000.00"}, {"US", "34,000.00"}, {"FR", "34,000.00"}},
+		},
+		{
+			// Aggregates HAVING and ORDER BY name needn't be selected
+			"SELECT country FROM '%s' GROUP BY country HAVING COUNT(refund_minor) = 2 AND MIN(refund_minor) < 1000000 ORDER BY SUM(refund_minor) / COUNT(*), country DESC",
+			sqlparser.OutputFormat{},
+			[][]string{{"country"}, {"DE"}, {"IT"}, {"UK"}, {"ES"}},
+		},
+		{
+			// Positions count output columns, group columns first
+			"SELECT COUNT(*), country FROM '%s' GROUP BY country ORDER BY 1 DESC, 2 LIMIT 2",
+			sqlparser.OutputFormat{Null: "-"},
+			[][]string{{"country", "COUNT(*)"}, {"US", "3"}, {"UK", "3"}},
+		},
+		{
+			"SELECT COUNT(*) FROM '%s' HAVING COUNT(*) > 100",
+			sqlparser.OutputFormat{},
+			[][]string{{"COUNT(*)"}},
+		},
+	}
+	for _, tt := range tests {
+		query, err := sqlparser.Parse(fmt.Sprintf(tt.query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		query.Output = tt.output
+		var buf bytes.Buffer
+		if err := Execute(query, &buf); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if rows := parseCSVOutput(t, buf.String()); !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("%s:\ngot  %v\nwant %v", tt.query, rows, tt.want)
+		}
+	}
+
+	for query, want := range map[string]string{
+		"SELECT * FROM '%s' ORDER BY country":                                        "only supported with GROUP BY or aggregates",
+		"SELECT country, COUNT(*) FROM '%s' GROUP BY country HAVING status = 'paid'": "neither a GROUP BY column",
+		"SELECT country FROM '%s' GROUP BY country ORDER BY SUM(refund_minor * 2)":   "must also be selected",
+	} {
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		if err := Execute(q, io.Discard); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", query, err, want)
+		}
+	}
+}
+
+func TestAggregateOutputFormat(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("country,total_cents,qty\n")
+	for i := 0; i < 1500; i++ {
+		fmt.Fprintf(&sb, "%s,%d,%d\n", []string{"DE", "FR"}[i%2], 123456+i, i%7)
+	}
+	csvPath := createTestCSV(t, sb.String())
+
+	tests := []struct {
+		query  string
+		output sqlparser.OutputFormat
+		want   [][]string
+	}{
+		{
+			"SELECT country, SUM(total_cents), MIN(total_cents), AVG(qty), COUNT(*) FROM '%s' WHERE qty > 2 GROUP BY country",
+			sqlparser.OutputFormat{},
+			[][]string{{"country", "SUM(total_cents)", "MIN(total_cents)", "AVG(qty)", "COUNT(*)"}, {"FR", "53160168", "123459", "4.50", "428"}, {"DE", "53160168", "123460", "4.50", "428"}},
+		},
+		{
+			"SELECT country, SUM(total_cents), MIN(total_cents), AVG(qty), COUNT(*) FROM '%s' WHERE qty > 2 GROUP BY country",
+			sqlparser.OutputFormat{Thousands: ',', MinorUnits: map[string]int{"total_cents": 2}},
+			[][]string{{"country", "SUM(total_cents)", "MIN(total_cents)", "AVG(qty)", "COUNT(*)"}, {"FR", "531,601.68", "1,234.59", "4.50", "428"}, {"DE", "531,601.68", "1,234.60", "4.50", "428"}},
+		},
+		{
+			"SELECT SUM(total_cents), AVG(total_cents), COUNT(*) FROM '%s'",
+			sqlparser.OutputFormat{Precision: 1, HasPrecision: true, Thousands: '.', MinorUnits: map[string]int{"total_cents": 3}},
+			[][]string{{"SUM(total_cents)", "AVG(total_cents)", "COUNT(*)"}, {"186.308,3", "124,2", "1.500"}},
+		},
+		{
+			// Expressions aren't in the minor units of their columns
+			"SELECT SUM(total_cents / 100), MAX(total_cents) FROM '%s'",
+			sqlparser.OutputFormat{Precision: 1, HasPrecision: true, MinorUnits: map[string]int{"total_cents": 2}},
+			[][]string{{"SUM(total_cents / 100)", "MAX(total_cents)"}, {"1863082.5", "1249.6"}},
+		},
+	}
+	for _, tt := range tests {
+		query, err := sqlparser.Parse(fmt.Sprintf(tt.query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		query.Output = tt.output
+		var buf bytes.Buffer
+		if err := Execute(query, &buf); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if rows := parseCSVOutput(t, buf.String()); !reflect.DeepEqual(rows, tt.want) {
+			t.Errorf("%s %+v:\ngot  %v\nwant %v", tt.query, tt.output, rows, tt.want)
+		}
+	}
+}
+
+func TestCountFromIndex(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,note\n")
+	for i := 0; i < 250; i++ {
+		sb.WriteString("1,\"two\nlines\"\n")
+	}
+	csvPath := createTestCSV(t, sb.String())
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	query, err := sqlparser.Parse("SELECT COUNT(*) FROM '" + csvPath + "'")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if handled, _ := countFromIndex(query, io.Discard, nil); handled {
+		t.Fatal("expected a scan without an index")
+	}
+	var scanned bytes.Buffer
+	if err := Execute(query, &scanned); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+
+	writeTestIndex(t, csvPath, 100)
+	var fromIndex bytes.Buffer
+	handled, err := countFromIndex(query, &fromIndex, nil)
+	if !handled || err != nil {
+		t.Fatalf("expected COUNT(*) from the index, got handled=%v err=%v", handled, err)
+	}
+	if fromIndex.String() != scanned.String() || scanned.String() != "COUNT(*)\n250\n" {
+		t.Errorf("index count %q, scan count %q, want 250", fromIndex.String(), scanned.String())
+	}
+}
+
+func TestCountWithWhereFromIndex(t *testing.T) {
+	// Runs of equal countries give blocks that are entirely one value
+	var sb strings.Builder
+	sb.WriteString("id,country,amount\n")
+	for i := 0; i < 1000; i++ {
+		country := []string{"UK", "US", "DE", "FR"}[i/250]
+		amount := fmt.Sprint(i % 97)
+		if i%13 == 0 {
+			amount = ""
+		}
+		fmt.Fprintf(&sb, "%d,%s,%s\n", i, country, amount)
+	}
+	csvPath := createTestCSV(t, sb.String())
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	wheres := []string{
+		"country = 'UK'",
+		"country != 'US'",
+		"country = 'UK' OR id >= 900",
+		"NOT country = 'DE' AND amount > 50",
+		"id < 333",
+		"amount >= 0",
+		"country =~ 'uk'",
+		"country = 'XX'",
+	}
+	run := func(where string) string {
+		query, err := sqlparser.Parse("SELECT COUNT(*) FROM '" + csvPath + "' WHERE " + where)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		var out bytes.Buffer
+		if err := Execute(query, &out); err != nil {
+			t.Fatalf("%s: execute error: %v", where, err)
+		}
+		return out.String()
+	}
+
+	want := make([]string, len(wheres))
+	for i, where := range wheres {
+		want[i] = run(where)
+	}
+
+	writeTestIndex(t, csvPath, 50)
+	query, _ := sqlparser.Parse("SELECT COUNT(*) FROM '" + csvPath + "' WHERE country = 'UK'")
+	if handled, err := countFromIndex(query, io.Discard, nil); !handled || err != nil {
+		t.Fatalf("expected COUNT(*) from the index, got handled=%v err=%v", handled, err)
+	}
+	for i, where := range wheres {
+		if got := run(where); got != want[i] {
+			t.Errorf("%s: index count %q, scan count %q", where, got, want[i])
+		}
+	}
+
+	query, _ = sqlparser.Parse("SELECT COUNT(*) FROM '" + csvPath + "' WHERE missing = 1")
+	if err := Execute(query, io.Discard); err == nil {
+		t.Error("expected an error for an unknown WHERE column")
+	}
+}
+
+func TestAggregateFromIndex(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,country,amount\n")
+	for i := 0; i < 1000; i++ {
+		country := []string{"UK", "US", "DE", "FR"}[i/250]
+		amount := fmt.Sprintf("%d.%02d", i%97, i%100)
+		switch {
+		case i%13 == 0:
+			amount = ""
+		case i == 777:
+			amount = "n/a" // Its block can't be taken from stats
+		}
+		fmt.Fprintf(&sb, "%d,%s,%s\n", i, country, amount)
+	}
+	csvPath := createTestCSV(t, sb.String())
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	selects := []string{
+		"SUM(amount), AVG(amount), COUNT(*)",
+		"MIN(amount) AS lo, MAX(amount) AS hi, COUNT(amount)",
+		"SUM(amount), MIN(id), MAX(id)",
+	}
+	wheres := []string{
+		"",
+		" WHERE country = 'UK'",
+		" WHERE country != 'US' AND id > 100",
+		" WHERE amount > 50",
+		" WHERE country = 'XX'",
+	}
+	parse := func(sel, where string) sqlparser.Query {
+		query, err := sqlparser.Parse("SELECT " + sel + " FROM '" + csvPath + "'" + where)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		return query
+	}
+	run := func(sel, where string) string {
+		var out bytes.Buffer
+		if err := Execute(parse(sel, where), &out); err != nil {
+			t.Fatalf("%s%s: execute error: %v", sel, where, err)
+		}
+		return out.String()
+	}
+
+	want := make(map[string]string)
+	for _, sel := range selects {
+		for _, where := range wheres {
+			want[sel+where] = run(sel, where)
+		}
+	}
+
+	builder := sidx.NewBuilder(50)
+	builder.SetSumColumns([]string{"amount"})
+	index, err := builder.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+	saveTestIndex(t, csvPath, index)
+
+	if handled, err := aggregateFromIndex(parse(selects[0], wheres[1]), io.Discard, nil); !handled || err != nil {
+		t.Fatalf("expected SUM from the index, got handled=%v err=%v", handled, err)
+	}
+	// id has no sums, so its SUM must be scanned
+	if handled, _ := aggregateFromIndex(parse("SUM(id)", ""), io.Discard, nil); handled {
+		t.Error("SUM of a column without sums was taken from the index")
+	}
+	for _, sel := range selects {
+		for _, where := range wheres {
+			if got := run(sel, where); got != want[sel+where] {
+				t.Errorf("%s%s: index gave %q, scan %q", sel, where, got, want[sel+where])
+			}
+		}
+	}
+}
+
+func TestAggregatesOverExpressions(t *testing.T) {
+	tmpFile := createTestCSV(t, `country,price_minor,quantity,total_minor
+US,250,4,1000
+US,199,1,199
+UK,1000,2,2000
+UK,n/a,3,
+`)
+
+	query, err := sqlparser.Parse("SELECT country, SUM(price_minor * quantity) AS revenue, AVG(total_minor / 100.0), MAX(ROUND(price_minor / 100)), COUNT(*) FROM '" + tmpFile + "' GROUP BY country")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := Execute(query, &buf); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	// Rows whose expression isn't a number are left out, as with columns
+	want := [][]string{
+		{"country", "revenue", "AVG(total_minor / 100.0)", "MAX(ROUND(price_minor / 100))", "COUNT(*)"},
+		{"US", "1199", "6.00", "3", "2"},
+		{"UK", "2000", "20.00", "10", "2"},
+	}
+	if rows := parseCSVOutput(t, buf.String()); !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+
+	query, err = sqlparser.Parse("SELECT SUM(missing * 2) FROM '" + tmpFile + "'")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Execute(query, &buf); err == nil || !strings.Contains(err.Error(), `"missing" not found`) {
+		t.Errorf("expected missing column error, got %v", err)
+	}
+}
+
+func TestAggregateFilter(t *testing.T) {
+	tmpFile := createTestCSV(t, `country,status,amount
+US,paid,10
+US,refunded,5
+UK,paid,7
+UK,paid,3
+US,refunded,1
+`)
+
+	query, err := sqlparser.Parse("SELECT country, COUNT(*), COUNT(*) FILTER (WHERE status = 'refunded') AS refunds, SUM(amount) FILTER (WHERE status = 'paid' AND amount > 5), AVG(amount) FILTER (WHERE status = 'none') FROM '" + tmpFile + "' GROUP BY country")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := Execute(query, &buf); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	want := [][]string{
+		{"country", "COUNT(*)", "refunds", "SUM(amount) FILTER (WHERE status = 'paid' AND amount > 5)", "AVG(amount) FILTER (WHERE status = 'none')"},
+		{"US", "3", "2", "10", "0"},
+		{"UK", "2", "0", "7", "0"},
+	}
+	if rows := parseCSVOutput(t, buf.String()); !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+
+	// A filtered count isn't answered from the index's row count
+	writeTestIndex(t, tmpFile, 2)
+	query, err = sqlparser.Parse("SELECT COUNT(*), COUNT(*) FILTER (WHERE country = 'UK') FROM '" + tmpFile + "'")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	buf.Reset()
+	if err := Execute(query, &buf); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	if got := buf.String(); got != "COUNT(*),COUNT(*) FILTER (WHERE country = 'UK')\n5,2\n" {
+		t.Errorf("ungrouped: got %q", got)
+	}
+
+	query, err = sqlparser.Parse("SELECT COUNT(*) FILTER (WHERE missing = 1) FROM '" + tmpFile + "'")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := Execute(query, &buf); err == nil || !strings.Contains(err.Error(), `"missing" not found`) {
+		t.Errorf("expected missing column error, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// sniffInput settles what the query leaves open about its input file: the
+// delimiter and quote character, and unless NoHeader or HasHeader is set,
+// whether the first row is a header (see csvio.Sniff). Streams can't be
+// reread, so openStream sniffs them as they're opened.
+func sniffInput(query *sqlparser.Query) error {
+	if !needsSniff(*query) || isStream(query.FilePath) {
+		return nil
+	}
+	file, err := os.Open(query.FilePath)
+	if err != nil {
+		return nil // Reported when the file is opened to be read
+	}
+	defer file.Close()
+	d, header, err := csvio.SniffFile(csvio.NewRetryReader(file, query.FilePath, 0), query.Encoding, query.Dialect)
+	if err != nil {
+		return fmt.Errorf("sniff dialect: %w", err)
+	}
+	settleInput(query, d, header)
+	return nil
+}
+
+// needsSniff reports whether the query leaves any of its input's dialect
+// or header mode open
+func needsSniff(query sqlparser.Query) bool {
+	return query.Dialect.Delimiter == 0 || query.Dialect.Quote == 0 || !query.NoHeader && !query.HasHeader
+}
+
+// settleInput records a sniffed dialect and header mode in the query
+func settleInput(query *sqlparser.Query, d csvio.Dialect, header bool) {
+	query.Dialect = d
+	if !query.NoHeader && !query.HasHeader {
+		query.NoHeader, query.HasHeader = !header, header
+	}
+}
+
+// sniffStream sniffs what has arrived of a stream, decoded, without waiting
+// for more: a slow writer must not hold back the first rows. The sample is
+// cut after its last line break, short of a record still being written.
+func sniffStream(query *sqlparser.Query, src *bufio.Reader) {
+	if !needsSniff(*query) {
+		return
+	}
+	src.Peek(1) // Waits for the first read only
+	sample, err := src.Peek(min(src.Buffered(), csvio.SniffSize))
+	if err == nil && len(sample) < csvio.SniffSize {
+		if i := bytes.LastIndexByte(sample, '\n'); i >= 0 {
+			sample = sample[:i+1]
+		}
+	}
+	d, header := csvio.Sniff(sample, query.Dialect)
+	settleInput(query, d, header)
+}
+
+// newCSVReader returns a csvio.Reader over r, which yields decoded text,
+// for the query's dialect
+func newCSVReader(r io.Reader, query sqlparser.Query) *csvio.Reader {
+	reader := csvio.NewReaderSize(r, query.Resources.IOBufferSize)
+	reader.SetDialect(query.Dialect)
+	return reader
+}
+
+// newFastReader is newCSVReader for a FastCSVReader
+func newFastReader(r io.Reader, query sqlparser.Query) *FastCSVReader {
+	reader := NewFastCSVReaderSize(r, query.Resources.IOBufferSize)
+	reader.SetDialect(query.Dialect)
+	return reader
+}
@@ -1,27 +1,29 @@
 package engine
 
 import (
-	"bufio"
-	"encoding/csv"
+	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
 	"github.com/melihbirim/sieswi/internal/sidx"
 	"github.com/melihbirim/sieswi/internal/sqlparser"
 )
 
 const (
-	ioBufferSize       = 256 * 1024 // 256KB keeps syscalls low without huge RSS.
-	defaultFlushEveryN = 8192       // Flush every N rows - higher for bulk throughput.
+	defaultFlushEveryN = 8192 // Flush every N rows - higher for bulk throughput.
 )
 
 // tryParallelExecute attempts parallel execution and returns (handled, error).
 // If handled=false, caller should fall back to sequential.
 // If handled=true, the error indicates success (nil) or failure.
-func tryParallelExecute(query sqlparser.Query, out io.Writer) (bool, error) {
-	err := ParallelExecute(query, out)
+func tryParallelExecute(query sqlparser.Query, out io.Writer, stats *Stats) (bool, error) {
+	err := parallelExecute(query, out, stats)
 	if err == errSkipParallel {
 		// Parallel processing was skipped, use sequential
 		return false, nil
@@ -30,32 +32,73 @@ func tryParallelExecute(query sqlparser.Query, out io.Writer) (bool, error) {
 	return true, err
 }
 
+// SourceOrder reports whether query is sure to write its rows in the order
+// they appear in the input. Every execution path keeps that order for plain
+// scans, parallel or not, indexed or not, with or without SAMPLE, unless
+// Unordered is set. Grouped results come in the order of each group's first
+// row, and QUALIFY ranks the rows of each partition unless StableOrder is set.
+func SourceOrder(query sqlparser.Query) bool {
+	if query.StableOrder {
+		return true
+	}
+	return query.Top == nil && !isAggregateQuery(query) && (!query.Unordered || query.Sample != nil)
+}
+
 // Execute streams query results to the provided writer.
 func Execute(query sqlparser.Query, out io.Writer) error {
-	// NOTE: Index support temporarily disabled due to bugs
-	// The parallel processing is fast enough without index
-	// Index will be re-enabled after fixing row count bugs
-	var index *sidx.Index = nil
-	_ = sidx.Index{} // Keep import to avoid unused import error
+	return ExecuteWithStats(query, out, nil)
+}
+
+// ExecuteWithStats is Execute that also updates stats as the query runs.
+func ExecuteWithStats(query sqlparser.Query, out io.Writer, stats *Stats) error {
+	// Check if reading from stdin or a pipe
+	streamed := isStream(query.FilePath)
+
+	normalizeQuery(&query)
+	if err := checkQuery(query); err != nil {
+		return err
+	}
+	if err := sniffInput(&query); err != nil {
+		return err
+	}
+	if err := requireIndex(query); err != nil {
+		return err
+	}
+
+	// Subqueries run to completion first; the scan then probes their values
+	if err := resolveSubqueries(&query); err != nil {
+		return err
+	}
 
-	// Check if reading from stdin
-	isStdin := query.FilePath == "-" || query.FilePath == "stdin"
+	// QUALIFY ranks every matching row before any is written
+	if query.Top != nil {
+		return executeTopNFromFile(query, out, stats)
+	}
 
-	if isStdin {
-		// Stdin: cannot use parallel, index, or seeking - direct sequential stream
-		return executeFromStdin(query, out)
+	if streamed {
+		// Stdin or a pipe: cannot use parallel, index, or seeking - direct sequential stream
+		return executeFromStream(query, out, stats)
 	}
 
-	// GROUP BY requires sequential processing (cannot parallelize aggregation easily)
-	if len(query.GroupBy) > 0 {
-		return executeGroupByFromFile(query, out)
+	// GROUP BY and whole-file aggregates require sequential processing
+	// (cannot parallelize aggregation easily)
+	if isAggregateQuery(query) {
+		return executeGroupByFromFile(query, out, stats)
+	}
+
+	// Use the sidecar index only when it lets us skip blocks; otherwise the
+	// parallel and fast paths are quicker than seeking with encoding/csv
+	index, pruneBlocks, releaseIndex := loadPrunableIndex(query)
+	defer releaseIndex()
+	if index != nil {
+		stats.addBlocks(len(index.Blocks), len(pruneBlocks))
 	}
 
 	// Try parallel execution for large files without index
 	// ParallelExecute returns nil if it should be skipped (file too small, small LIMIT, etc.)
 	// It returns a real error only if parallel processing failed
 	if index == nil && os.Getenv("SIDX_NO_PARALLEL") != "1" {
-		parallelHandled, err := tryParallelExecute(query, out)
+		parallelHandled, err := tryParallelExecute(query, out, stats)
 		if parallelHandled {
 			return err // Parallel execution was attempted, return its result
 		}
@@ -73,25 +116,29 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 	}()
 
 	// Note: We need file handle for seeking, can't use buffered reader until after seeks
-	var reader *csv.Reader
+	var reader *csvio.Reader
 	var fastReader *FastCSVReader
-	var bufferedFile *bufio.Reader
 	useFastPath := (index == nil) // Use fast parser when no index (no seeking needed)
 
 	if index != nil {
 		// Use unbuffered for seeking, will add buffer after seeks
-		reader = csv.NewReader(file)
+		reader = newCSVReader(csvio.NewDecoder(stats.countReads(file), query.Encoding), query)
 		reader.ReuseRecord = true
-		reader.FieldsPerRecord = -1
 	} else {
 		// No index, use fast CSV parser (3-5x faster than encoding/csv)
-		fastReader = NewFastCSVReader(file)
+		fastReader = newFastReader(csvio.NewDecoder(stats.countReads(csvio.NewRetryReader(file, query.FilePath, 0)), query.Encoding), query)
 	}
 
 	var headerRecord []string
-	if useFastPath {
+	switch {
+	case query.NoHeader:
+		// The first row is data: name the columns without consuming it
+		if headerRecord, _, err = inputHeader(file, query); err != nil {
+			return err
+		}
+	case useFastPath:
 		headerRecord, err = fastReader.Read()
-	} else {
+	default:
 		headerRecord, err = reader.Read()
 	}
 	if err != nil {
@@ -101,19 +148,24 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 	header := make([]string, len(headerRecord))
 	copy(header, headerRecord)
 
-	// Pre-normalize headers once for fast WHERE evaluation
-	normalizedHeaders := make([]string, len(header))
+	// Pre-normalize headers once for column resolution
 	normalisedIndex := make(map[string]int, len(header))
 	for idx, name := range header {
-		normalized := strings.ToLower(strings.TrimSpace(name))
-		normalizedHeaders[idx] = normalized
-		normalisedIndex[normalized] = idx
+		normalisedIndex[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	pseudo := resolvePseudoColumns(query, normalisedIndex, len(header))
+	lines := headerLines(query.NoHeader)
+
+	proj, outputHeader, err := resolveProjection(query, header, normalisedIndex)
+	if err != nil {
+		return err
 	}
 
-	selectedIdxs, outputHeader, err := resolveProjection(query, header, normalisedIndex)
+	guard, err := newRowGuard(query, len(header), stats)
 	if err != nil {
 		return err
 	}
+	defer guard.close()
 
 	// Validate all columns referenced in WHERE clause exist
 	if query.Where != nil {
@@ -122,34 +174,58 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 		}
 	}
 
-	// Determine which blocks can be pruned and seek to first non-pruned block
-	var pruneBlocks map[int]bool
-	if index != nil && query.Where != nil {
-		pruneBlocks = make(map[int]bool)
-		prunedCount := 0
-		for i := range index.Blocks {
-			block := &index.Blocks[i]
-			if canPruneBlockExpr(index, block, query.Where) {
-				pruneBlocks[i] = true
-				prunedCount++
-			}
+	// The fast path only splits the fields the query reads; on wide files
+	// the rest of each line is skipped without slicing it into fields
+	if useFastPath {
+		fastReader.SetFieldLimit(guard.fieldLimit(fieldsNeeded(query.Where, normalisedIndex, proj.reads)))
+	}
+
+	// With SIDX_MMAP=1, block seeks are served from a memory mapping of the
+	// file instead of a seek and fresh read syscalls per jump
+	var mapped *csvio.MappedFile
+	if index != nil && os.Getenv("SIDX_MMAP") == "1" {
+		if m, err := csvio.Map(query.FilePath); err == nil {
+			mapped = m
+			defer mapped.Close()
+		} else if os.Getenv("SIDX_DEBUG") == "1" {
+			fmt.Fprintf(os.Stderr, "[sidx] %v; using buffered reads\n", err)
 		}
-		if os.Getenv("SIDX_DEBUG") == "1" {
-			fmt.Fprintf(os.Stderr, "[sidx] Loaded index with %d blocks, pruned %d (%.1f%%)\n",
-				len(index.Blocks), prunedCount, 100.0*float64(prunedCount)/float64(len(index.Blocks)))
+	}
+
+	// seekTo repositions the CSV reader at a block's byte offset; readerBase
+	// is where the current reader started
+	var readerBase int64
+	seekTo := func(offset uint64) bool {
+		var src io.Reader
+		if mapped != nil {
+			data := mapped.Bytes()
+			if offset > uint64(len(data)) {
+				return false
+			}
+			src = bytes.NewReader(data[offset:])
+		} else {
+			if _, err := file.Seek(int64(offset), io.SeekStart); err != nil {
+				return false
+			}
+			src = csvio.NewRetryReader(file, query.FilePath, int64(offset))
 		}
+		reader = newCSVReader(csvio.NewDecoder(stats.countReads(src), query.Encoding), query)
+		reader.ReuseRecord = true
+		readerBase = int64(offset)
+		useFastPath = false // Disable fast path after seeking
+		return true
+	}
 
-		// Seek to first non-pruned block if possible
+	// Seek to first non-pruned block
+	if index != nil {
+		var skipped int64
 		for i := range index.Blocks {
-			if !pruneBlocks[i] {
+			if pruneBlocks[i] {
+				skipped += blockBytes(&index.Blocks[i])
+			} else {
+				stats.addSkipped(skipped)
 				block := &index.Blocks[i]
-				if _, err := file.Seek(int64(block.StartOffset), io.SeekStart); err == nil {
-					// Successfully seeked, now add buffering
-					bufferedFile = bufio.NewReaderSize(file, ioBufferSize)
-					reader = csv.NewReader(bufferedFile)
-					reader.ReuseRecord = true
-					reader.FieldsPerRecord = -1
-					useFastPath = false // Disable fast path after seeking
+				if seekTo(block.StartOffset) {
 					if os.Getenv("SIDX_DEBUG") == "1" {
 						fmt.Fprintf(os.Stderr, "[sidx] Seeked to block %d offset %d\n", i, block.StartOffset)
 					}
@@ -159,7 +235,7 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 		}
 	}
 
-	writer := csv.NewWriter(out)
+	writer := NewFastCSVWriterSize(out, query.Resources.IOBufferSize)
 	if err := writer.Write(outputHeader); err != nil {
 		return fmt.Errorf("write header: %w", err)
 	}
@@ -171,6 +247,9 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 	written := 0
 	rowsSinceFlush := 0
 	currentRow := uint64(0)
+	var scanned int64
+	defer func() { stats.addScanned(scanned) }()
+	timer := rowTimer{stats: stats}
 	currentBlockIdx := 0
 
 	// Find which block we started in (if we seeked)
@@ -184,10 +263,70 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 		}
 	}
 
-	// Pre-allocate rowMap for WHERE evaluation to avoid repeated allocations
-	var rowMap map[string]string
-	if query.Where != nil {
-		rowMap = make(map[string]string, len(header))
+	// WHERE is evaluated a batch at a time with the vectorized filter
+	filter, err := compileVectorFilter(query.Where, normalisedIndex)
+	if err != nil {
+		return err
+	}
+	batch := newColumnBatch()
+	pending := make([][]string, 0, vectorBatchSize)
+	var selection []int32
+
+	// emit writes a result row, reporting true once LIMIT is reached
+	emit := func(row []string) (bool, error) {
+		if query.Limit >= 0 && written >= query.Limit {
+			return true, nil // LIMIT 0
+		}
+		if err := writer.Write(row); err != nil {
+			return false, fmt.Errorf("write row: %w", err)
+		}
+
+		written++
+		stats.addReturned(1)
+		rowsSinceFlush++
+		if rowsSinceFlush >= defaultFlushEveryN {
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				return false, fmt.Errorf("flush rows: %w", err)
+			}
+			rowsSinceFlush = 0
+		}
+
+		return query.Limit >= 0 && written >= query.Limit, nil
+	}
+
+	// writeRow passes a matching row through the SAMPLE, if any, to emit
+	sample := newSampler(query.Sample)
+	writeRow := func(row []string) (bool, error) {
+		if query.Limit == 0 {
+			return true, nil
+		}
+		stats.addMatched(1)
+		if sample != nil && !sample.offer(row) {
+			return false, nil
+		}
+		return emit(row)
+	}
+
+	// flushPending filters the buffered rows and writes the matches
+	flushPending := func() (bool, error) {
+		if len(pending) == 0 {
+			return false, nil
+		}
+		start := time.Now()
+		batch.reset(pending)
+		selection = filterAll(filter, batch, selection)
+		stats.addTime(phaseFilter, start)
+
+		start = time.Now()
+		defer stats.addTime(phaseOutput, start)
+		for _, i := range selection {
+			if done, err := writeRow(proj.project(pending[i])); done || err != nil {
+				return done, err
+			}
+		}
+		pending = pending[:0]
+		return false, nil
 	}
 
 	for {
@@ -206,8 +345,10 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 			// If we're in a pruned block, seek to next unpruned block
 			if currentBlockIdx < len(index.Blocks) && pruneBlocks[currentBlockIdx] {
 				// Find next unpruned block
+				stats.addSkipped(blockBytes(&index.Blocks[currentBlockIdx]))
 				nextBlockIdx := currentBlockIdx + 1
 				for nextBlockIdx < len(index.Blocks) && pruneBlocks[nextBlockIdx] {
+					stats.addSkipped(blockBytes(&index.Blocks[nextBlockIdx]))
 					nextBlockIdx++
 				}
 
@@ -216,13 +357,7 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 				}
 
 				nextBlock := &index.Blocks[nextBlockIdx]
-				if _, err := file.Seek(int64(nextBlock.StartOffset), io.SeekStart); err == nil {
-					// Successfully seeked, recreate buffered reader
-					bufferedFile = bufio.NewReaderSize(file, ioBufferSize)
-					reader = csv.NewReader(bufferedFile)
-					reader.ReuseRecord = true
-					reader.FieldsPerRecord = -1
-					useFastPath = false // Disable fast path after seeking
+				if seekTo(nextBlock.StartOffset) {
 					currentBlockIdx = nextBlockIdx
 					currentRow = nextBlock.StartRow
 
@@ -234,54 +369,107 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 			}
 		}
 
-		var record []string
 		if useFastPath {
-			record, err = fastReader.Read()
-		} else {
-			record, err = reader.Read()
+			// No seeking: test raw fields in place and only build strings
+			// for the projected columns of matching rows
+			timer.startRow()
+			fields, err := fastReader.ReadRaw()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("read row: %w", err)
+			}
+			currentRow++
+			scanned++
+			if keep, err := guard.checkRaw(fields, int64(currentRow)+lines); !keep {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if pseudo != nil {
+				fields = pseudo.addRaw(fields, int64(currentRow)+lines, fastReader.Offset())
+			}
+			timer.mark(phaseParse)
+
+			matched := filter == nil || filter.matchRaw(fields)
+			timer.mark(phaseFilter)
+			if !matched {
+				continue
+			}
+			done, err := writeRow(proj.projectRaw(fields))
+			timer.mark(phaseOutput)
+			if done || err != nil {
+				if err != nil {
+					return err
+				}
+				break
+			}
+			continue
 		}
+
+		timer.startRow()
+		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			return fmt.Errorf("read row: %w", err)
 		}
+		recordStart := readerBase + reader.Offset()
 
 		currentRow++
-
-		// Evaluate WHERE clause if present
-		if query.Where != nil {
-			// Populate rowMap with pre-normalized headers (reuses map allocation)
-			for k := range rowMap {
-				delete(rowMap, k) // Clear previous row's data
+		scanned++
+		if keep, err := guard.check(record, int64(currentRow)+lines); !keep {
+			if err != nil {
+				return err
 			}
-			for i := range normalizedHeaders {
-				if i < len(record) {
-					rowMap[normalizedHeaders[i]] = record[i]
+			continue
+		}
+		if pseudo != nil {
+			record = pseudo.add(record, int64(currentRow)+lines, recordStart)
+		}
+		timer.mark(phaseParse)
+
+		if filter == nil {
+			// No WHERE: stream rows straight through
+			done, err := writeRow(proj.project(record))
+			timer.mark(phaseOutput)
+			if done || err != nil {
+				if err != nil {
+					return err
 				}
+				break
 			}
-			if !sqlparser.EvaluateNormalized(query.Where, rowMap) {
-				continue
-			}
+			continue
 		}
 
-		row := project(record, selectedIdxs)
-		if err := writer.Write(row); err != nil {
-			return fmt.Errorf("write row: %w", err)
+		// Readers reuse the record slice, so keep a copy until the batch is filtered
+		pending = append(pending, append([]string(nil), record...))
+		if len(pending) < vectorBatchSize {
+			continue
 		}
-
-		written++
-		rowsSinceFlush++
-		if rowsSinceFlush >= defaultFlushEveryN {
-			writer.Flush()
-			if err := writer.Error(); err != nil {
-				return fmt.Errorf("flush rows: %w", err)
+		if done, err := flushPending(); done || err != nil {
+			if err != nil {
+				return err
 			}
-			rowsSinceFlush = 0
+			break
 		}
+	}
 
-		if query.Limit >= 0 && written >= query.Limit {
-			break
+	if _, err := flushPending(); err != nil {
+		return err
+	}
+
+	if sample != nil && sample.reservoir {
+		for _, row := range sample.rows() {
+			if done, err := emit(row); done || err != nil {
+				if err != nil {
+					return err
+				}
+				break
+			}
 		}
 	}
 
@@ -290,174 +478,563 @@ func Execute(query sqlparser.Query, out io.Writer) error {
 		return fmt.Errorf("flush rows: %w", err)
 	}
 
-	return nil
+	return guard.close()
+}
+
+// projection maps scanned records to output rows: columns holds each
+// output column's record position, or -1 where exprs computes it
+type projection struct {
+	columns []int
+	exprs   []boundScalar // nil unless the query selects expressions
+	reads   []int         // Record positions the output is made from
+	width   int           // One past the highest of reads
+	numbers datatype.NumberFormat
 }
 
-func resolveProjection(query sqlparser.Query, header []string, index map[string]int) ([]int, []string, error) {
+func resolveProjection(query sqlparser.Query, header []string, index map[string]int) (*projection, []string, error) {
 	if query.AllColumns {
 		idxs := make([]int, len(header))
 		for i := range header {
 			idxs[i] = i
 		}
-		return idxs, header, nil
+		return &projection{columns: idxs, reads: idxs, width: len(header)}, header, nil
 	}
 
-	idxs := make([]int, len(query.Columns))
+	p := &projection{columns: make([]int, len(query.Columns)), numbers: query.Numbers}
 	names := make([]string, len(query.Columns))
 
 	for i, col := range query.Columns {
+		if i < len(query.Aliases) && query.Aliases[i] != "" {
+			names[i] = query.Aliases[i]
+		}
+		if i < len(query.Exprs) && query.Exprs[i] != nil {
+			expr, err := bindScalar(query.Exprs[i], index)
+			if err != nil {
+				return nil, nil, err
+			}
+			if p.exprs == nil {
+				p.exprs = make([]boundScalar, len(query.Columns))
+			}
+			p.exprs[i] = expr
+			p.columns[i] = -1
+			reads, width := scalarFields(query.Exprs[i], index)
+			p.reads = append(p.reads, reads...)
+			p.width = max(p.width, width)
+			if names[i] == "" {
+				names[i] = col
+			}
+			continue
+		}
+
 		normalized := strings.ToLower(col)
 		idx, ok := index[normalized]
 		if !ok {
 			return nil, nil, fmt.Errorf("column %q not found in CSV header", col)
 		}
-		idxs[i] = idx
-		names[i] = header[idx]
+		p.columns[i] = idx
+		p.reads = append(p.reads, idx)
+		p.width = max(p.width, idx+1)
+		switch {
+		case names[i] != "":
+		case idx < len(header):
+			names[i] = header[idx]
+		default:
+			names[i] = col // A pseudo-column such as _line
+		}
 	}
 
-	return idxs, names, nil
+	return p, names, nil
 }
 
-func project(record []string, columns []int) []string {
-	projected := make([]string, len(columns))
-	for i, idx := range columns {
-		if idx < len(record) {
+func (p *projection) project(record []string) []string {
+	projected := make([]string, len(p.columns))
+	for i, idx := range p.columns {
+		switch {
+		case idx < 0:
+			projected[i] = p.exprs[i].eval(record, p.numbers).String()
+		case idx < len(record):
 			projected[i] = record[idx]
 		}
 	}
 	return projected
 }
 
-// validateWhereColumns checks that all columns in expression exist
-func validateWhereColumns(expr sqlparser.Expression, index map[string]int) error {
-	switch e := expr.(type) {
-	case sqlparser.BinaryExpr:
-		if err := validateWhereColumns(e.Left, index); err != nil {
-			return err
+// projectRaw is project for raw fields, materializing only the fields the
+// output is made from
+func (p *projection) projectRaw(fields [][]byte) []string {
+	if p.exprs != nil {
+		return p.project(rawRow(nil, fields, p.reads, p.width))
+	}
+	projected := make([]string, len(p.columns))
+	for i, idx := range p.columns {
+		if idx < len(fields) {
+			projected[i] = string(fields[idx])
 		}
-		return validateWhereColumns(e.Right, index)
-	case sqlparser.UnaryExpr:
-		return validateWhereColumns(e.Expr, index)
-	case sqlparser.Comparison:
-		_, ok := index[strings.ToLower(e.Column)]
-		if !ok {
-			return fmt.Errorf("column %q not found in CSV header", e.Column)
+	}
+	return projected
+}
+
+// readHeaderRecord reads the header record at the start of file and returns
+// its fields and byte length, which is where the data section starts. For
+// encodings without raw offsets the length is of the decoded text.
+func readHeaderRecord(file io.ReaderAt, path, encoding string, dialect csvio.Dialect) ([]string, int64, error) {
+	var src io.Reader = csvio.NewRetryReader(io.NewSectionReader(file, 0, math.MaxInt64), path, 0)
+	if !csvio.SameOffsets(encoding) {
+		src = csvio.NewDecoder(src, encoding)
+	}
+	reader := csvio.NewRecordReader(src)
+	reader.SetDialect(dialect)
+	record, err := reader.Next()
+	if err != nil && err != io.EOF {
+		return nil, 0, fmt.Errorf("read header: %w", err)
+	}
+	text := csvio.Decode(nil, csvio.TrimBOM(record), encoding)
+	parser := NewFastCSVReader(bytes.NewReader(text))
+	parser.SetDialect(dialect)
+	header, err := parser.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("read header: %w", err)
+	}
+	return header, int64(len(record)), nil
+}
+
+// inputHeader returns the column names of the query's input file and the
+// byte offset where its data rows start. A headerless file starts at 0 and
+// its names come from the query, padded to the first record's width.
+func inputHeader(file io.ReaderAt, query sqlparser.Query) ([]string, int64, error) {
+	header, dataStart, err := readHeaderRecord(file, query.FilePath, query.Encoding, query.Dialect)
+	if !query.NoHeader {
+		return header, dataStart, err
+	}
+	if err != nil && len(query.HeaderNames) == 0 {
+		return nil, 0, err
+	}
+	return csvio.ColumnNames(query.HeaderNames, len(header)), 0, nil
+}
+
+// acquireIndex returns the cached index for the query's file. An index built
+// for the other header mode has different row numbers and offsets, and one
+// built for another number format or dialect different bounds, so all are
+// rejected. The release func must always be called.
+func acquireIndex(query sqlparser.Query) (*sidx.Index, func(), error) {
+	if query.IndexMode == sqlparser.IndexModeOff {
+		return nil, func() {}, nil
+	}
+	index, release, err := sidx.DefaultCache.Acquire(query.FilePath)
+	if err != nil || index == nil {
+		return index, release, err
+	}
+	if noHeader := index.Header.BuildFlags&sidx.BuildFlagNoHeader != 0; noHeader != query.NoHeader {
+		release()
+		if noHeader {
+			return nil, func() {}, fmt.Errorf("index was built with --no-header")
 		}
+		return nil, func() {}, fmt.Errorf("index was built for a file with a header row")
+	}
+	if index.Header.Numbers != query.Numbers {
+		release()
+		return nil, func() {}, fmt.Errorf("index was built with a different number format")
+	}
+	if !index.Header.Dialect.Same(query.Dialect) {
+		release()
+		return nil, func() {}, fmt.Errorf("index was built for CSV with %s", index.Header.Dialect)
+	}
+	if latin1 := index.Header.BuildFlags&sidx.BuildFlagLatin1 != 0; latin1 != (query.Encoding == csvio.EncodingLatin1) {
+		release()
+		if latin1 {
+			return nil, func() {}, fmt.Errorf("index was built with --encoding latin1")
+		}
+		return nil, func() {}, fmt.Errorf("index was built for UTF-8 input")
+	}
+	return index, release, nil
+}
+
+// requireIndex fails a query run with IndexModeRequire when its file has no
+// index acquireIndex would return. The index only makes the query use it
+// where it helps: a WHERE that prunes too little still scans the file.
+func requireIndex(query sqlparser.Query) error {
+	if query.IndexMode != sqlparser.IndexModeRequire {
 		return nil
 	}
+	if isStream(query.FilePath) {
+		return fmt.Errorf("index required: %s is a stream, which can't be indexed", query.FilePath)
+	}
+	index, release, err := acquireIndex(query)
+	release()
+	if err != nil {
+		return fmt.Errorf("index required for %s: %w", query.FilePath, err)
+	}
+	if _, err := os.Stat(query.FilePath); index == nil && err == nil {
+		return fmt.Errorf("index required: %s has no .sidx (run sieswi index %s)", query.FilePath, query.FilePath)
+	}
 	return nil
 }
 
+// blockBytes is the size of an index block in the CSV file
+func blockBytes(block *sidx.BlockMeta) int64 {
+	return int64(block.EndOffset - block.StartOffset)
+}
+
+// fieldsNeeded returns how many leading fields a scan must split to evaluate
+// expr and project columns: one past the highest column index either uses
+func fieldsNeeded(expr sqlparser.Expression, index map[string]int, columns []int) int {
+	n := 0
+	for _, idx := range columns {
+		n = max(n, idx+1)
+	}
+	if expr != nil {
+		n = max(n, sqlparser.VisitExpression[int](expr, fieldCounter{index: index}))
+	}
+	return n
+}
+
+// fieldCounter is one past the highest column index an expression reads
+type fieldCounter struct {
+	index map[string]int
+}
+
+func (f fieldCounter) columns(names ...string) int {
+	n := 0
+	for _, name := range names {
+		if idx, ok := f.index[strings.ToLower(strings.TrimSpace(name))]; ok {
+			n = max(n, idx+1)
+		}
+	}
+	return n
+}
+
+func (f fieldCounter) Binary(e sqlparser.BinaryExpr) int {
+	return max(sqlparser.VisitExpression[int](e.Left, f), sqlparser.VisitExpression[int](e.Right, f))
+}
+
+func (f fieldCounter) Unary(e sqlparser.UnaryExpr) int {
+	return sqlparser.VisitExpression[int](e.Expr, f)
+}
+
+func (f fieldCounter) Comparison(e sqlparser.Comparison) int { return f.columns(e.Column) }
+
+func (f fieldCounter) ColumnComparison(e sqlparser.ColumnComparison) int {
+	return f.columns(e.Left, e.Right)
+}
+
+func (f fieldCounter) ExprComparison(e sqlparser.ExprComparison) int {
+	_, left := scalarFields(e.Left, f.index)
+	_, right := scalarFields(e.Right, f.index)
+	return max(left, right)
+}
+
+func (f fieldCounter) In(e sqlparser.InExpr) int { return f.columns(e.Column) }
+
+// minPrunedFraction is the share of rows an index must let us skip before a
+// pruned scan is preferred over a full scan
+const minPrunedFraction = 0.25
+
+// loadPrunableIndex acquires the cached index for the query's file and marks
+// the blocks its WHERE clause can skip. It returns a nil index when there is no
+// usable index or too little can be pruned. The release func must always be called.
+func loadPrunableIndex(query sqlparser.Query) (*sidx.Index, map[int]bool, func()) {
+	noop := func() {}
+	if query.Where == nil {
+		return nil, nil, noop
+	}
+
+	index, release, err := acquireIndex(query)
+	if err != nil {
+		if os.Getenv("SIDX_DEBUG") == "1" {
+			fmt.Fprintf(os.Stderr, "[sidx] Ignoring index: %v\n", err)
+		}
+		return nil, nil, noop
+	}
+	if index == nil || len(index.Blocks) == 0 {
+		release()
+		return nil, nil, noop
+	}
+
+	pruneBlocks := make(map[int]bool)
+	lo, hi := sortedBlockRange(index, query.Where)
+	for i := range index.Blocks {
+		if i < lo || i >= hi || canPruneBlockExpr(index, &index.Blocks[i], query.Where) {
+			pruneBlocks[i] = true
+		}
+	}
+	if os.Getenv("SIDX_DEBUG") == "1" {
+		if hi-lo < len(index.Blocks) {
+			fmt.Fprintf(os.Stderr, "[sidx] Sorted columns leave %d of %d blocks to check\n", hi-lo, len(index.Blocks))
+		}
+		fmt.Fprintf(os.Stderr, "[sidx] Loaded index with %d blocks, pruned %d (%.1f%%)\n",
+			len(index.Blocks), len(pruneBlocks), 100.0*float64(len(pruneBlocks))/float64(len(index.Blocks)))
+	}
+
+	// Seeking through encoding/csv only pays off when enough rows are skipped;
+	// otherwise the parallel and fast full-scan paths win
+	var prunedRows uint64
+	for i := range pruneBlocks {
+		prunedRows += index.Blocks[i].EndRow - index.Blocks[i].StartRow
+	}
+	if prunedRows == 0 || float64(prunedRows) < minPrunedFraction*float64(index.Header.TotalRows) {
+		if os.Getenv("SIDX_DEBUG") == "1" && prunedRows > 0 {
+			fmt.Fprintf(os.Stderr, "[sidx] Pruning skips only %d of %d rows, using full scan\n",
+				prunedRows, index.Header.TotalRows)
+		}
+		release()
+		return nil, nil, noop
+	}
+	return index, pruneBlocks, release
+}
+
+// validateWhereColumns checks that all columns in expression exist
+func validateWhereColumns(expr sqlparser.Expression, index map[string]int) error {
+	return sqlparser.VisitExpression[error](expr, columnValidator{index: index})
+}
+
+// columnValidator reports the first column of an expression missing from
+// the header
+type columnValidator struct {
+	index map[string]int
+}
+
+func (v columnValidator) columns(names ...string) error {
+	for _, name := range names {
+		if _, ok := v.index[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("column %q not found in CSV header", name)
+		}
+	}
+	return nil
+}
+
+func (v columnValidator) Binary(e sqlparser.BinaryExpr) error {
+	if err := sqlparser.VisitExpression[error](e.Left, v); err != nil {
+		return err
+	}
+	return sqlparser.VisitExpression[error](e.Right, v)
+}
+
+func (v columnValidator) Unary(e sqlparser.UnaryExpr) error {
+	return sqlparser.VisitExpression[error](e.Expr, v)
+}
+
+func (v columnValidator) Comparison(e sqlparser.Comparison) error { return v.columns(e.Column) }
+
+func (v columnValidator) ColumnComparison(e sqlparser.ColumnComparison) error {
+	return v.columns(e.Left, e.Right)
+}
+
+func (v columnValidator) ExprComparison(e sqlparser.ExprComparison) error {
+	return v.columns(append(sqlparser.ScalarColumns(e.Left), sqlparser.ScalarColumns(e.Right)...)...)
+}
+
+func (v columnValidator) In(e sqlparser.InExpr) error { return v.columns(e.Column) }
+
 // canPruneBlockExpr determines if a block can be pruned based on expression
 func canPruneBlockExpr(index *sidx.Index, block *sidx.BlockMeta, expr sqlparser.Expression) bool {
-	switch e := expr.(type) {
-	case sqlparser.BinaryExpr:
-		switch e.Operator {
-		case "AND":
-			// Can prune if either side allows pruning
-			return canPruneBlockExpr(index, block, e.Left) || canPruneBlockExpr(index, block, e.Right)
-		case "OR":
-			// Can only prune if BOTH sides allow pruning
-			return canPruneBlockExpr(index, block, e.Left) && canPruneBlockExpr(index, block, e.Right)
+	return sqlparser.VisitExpression[bool](expr, blockPruner{index: index, block: block})
+}
+
+// canPruneBlockNotExpr determines if a block can be pruned for NOT expr,
+// pushing the negation down with De Morgan's laws
+func canPruneBlockNotExpr(index *sidx.Index, block *sidx.BlockMeta, expr sqlparser.Expression) bool {
+	return sqlparser.VisitExpression[bool](expr, blockPruner{index: index, block: block, not: true})
+}
+
+// blockPruner reports whether no row of a block can match an expression,
+// or with not set, whether every row of it matches (so NOT expr matches
+// none). Comparisons between columns or expressions and IN lists never
+// prune.
+type blockPruner struct {
+	index *sidx.Index
+	block *sidx.BlockMeta
+	not   bool
+}
+
+func (p blockPruner) prune(expr sqlparser.Expression) bool {
+	return sqlparser.VisitExpression[bool](expr, p)
+}
+
+func (p blockPruner) Binary(e sqlparser.BinaryExpr) bool {
+	// Under NOT, De Morgan swaps the operators: NOT (A AND B) = NOT A OR
+	// NOT B, and NOT (A OR B) = NOT A AND NOT B
+	if e.Operator != "AND" && e.Operator != "OR" {
+		return false
+	}
+	if (e.Operator == "AND") != p.not {
+		// Can prune if either side allows pruning, or, for a plain AND, two
+		// of the chain's terms together do
+		return p.prune(e.Left) || p.prune(e.Right) || (!p.not && canPrunePairs(p.index, p.block, e))
+	}
+	// Can only prune if BOTH sides allow pruning
+	return p.prune(e.Left) && p.prune(e.Right)
+}
+
+func (p blockPruner) Unary(e sqlparser.UnaryExpr) bool {
+	if e.Operator != "NOT" {
+		return false
+	}
+	p.not = !p.not
+	return p.prune(e.Expr)
+}
+
+func (p blockPruner) Comparison(e sqlparser.Comparison) bool {
+	if e.IsBool {
+		if p.not {
+			return sidx.CanPruneBlockBoolNot(p.index, p.block, e.Column, boolWanted(e))
 		}
+		return sidx.CanPruneBlockBool(p.index, p.block, e.Column, boolWanted(e))
+	}
+	if prune, ok := canPruneLines(p.index, p.block, e, p.not); ok {
+		return prune
+	}
+	// The index picks bounds by how the literal reads, which a declared
+	// type may override
+	if foldsStrings(e) || e.Retyped() {
 		return false
-	case sqlparser.UnaryExpr:
-		// NOT: conservative, don't prune
+	}
+	if p.not {
+		return sidx.CanPruneBlockNot(p.index, p.block, e.Column, e.Operator, e.Value)
+	}
+	return sidx.CanPruneBlock(p.index, p.block, e.Column, e.Operator, e.Value)
+}
+
+func (blockPruner) ColumnComparison(sqlparser.ColumnComparison) bool { return false }
+func (blockPruner) ExprComparison(sqlparser.ExprComparison) bool     { return false }
+func (blockPruner) In(sqlparser.InExpr) bool                         { return false }
+
+// canPrunePairs determines if a block can be pruned by an AND chain holding
+// an equality on a key-mapped column and a comparison on a column paired
+// with it, which the key map keeps joint bounds for
+func canPrunePairs(index *sidx.Index, block *sidx.BlockMeta, and sqlparser.BinaryExpr) bool {
+	if len(index.Keys) == 0 {
 		return false
-	case sqlparser.Comparison:
-		return sidx.CanPruneBlock(index, block, e.Column, e.Operator, e.Value)
+	}
+	terms := comparisonTerms(and, nil)
+	for i, lead := range terms {
+		if lead.Operator != "=" {
+			continue
+		}
+		for j, other := range terms {
+			if i != j && sidx.CanPruneBlockPair(index, block, lead.Column, lead.Value, other.Column, other.Operator, other.Value) {
+				return true
+			}
+		}
 	}
 	return false
 }
 
-// executeFromStdin handles queries reading from stdin (piped data)
-func executeFromStdin(query sqlparser.Query, out io.Writer) error {
-	reader := csv.NewReader(bufio.NewReader(os.Stdin))
-	reader.ReuseRecord = true
-	reader.FieldsPerRecord = -1
+// sortedBlockRange narrows the blocks a WHERE clause can match to [lo, hi)
+// by binary search on the terms of its AND chain over columns sorted across
+// blocks. Blocks outside the range are pruned without checking each one.
+func sortedBlockRange(index *sidx.Index, where sqlparser.Expression) (lo, hi int) {
+	lo, hi = 0, len(index.Blocks)
+	for _, term := range comparisonTerms(where, nil) {
+		if l, h, ok := index.BlockRange(term.Column, term.Operator, term.Value); ok {
+			lo, hi = max(lo, l), min(hi, h)
+		}
+	}
+	return lo, max(lo, hi)
+}
+
+// comparisonTerms appends the comparisons of an AND chain that block bounds
+// can answer to terms
+func comparisonTerms(expr sqlparser.Expression, terms []sqlparser.Comparison) []sqlparser.Comparison {
+	return append(terms, sqlparser.VisitExpression[[]sqlparser.Comparison](expr, termCollector{})...)
+}
+
+// termCollector lists the comparisons of an AND chain that block bounds can
+// answer; any other node ends the chain
+type termCollector struct{}
 
-	// Read header
-	header, err := reader.Read()
+func (termCollector) Binary(e sqlparser.BinaryExpr) []sqlparser.Comparison {
+	if e.Operator != "AND" {
+		return nil
+	}
+	return comparisonTerms(e.Right, comparisonTerms(e.Left, nil))
+}
+
+func (termCollector) Comparison(e sqlparser.Comparison) []sqlparser.Comparison {
+	if e.IsBool || foldsStrings(e) || e.Retyped() {
+		return nil
+	}
+	return []sqlparser.Comparison{e}
+}
+
+func (termCollector) Unary(sqlparser.UnaryExpr) []sqlparser.Comparison                   { return nil }
+func (termCollector) ColumnComparison(sqlparser.ColumnComparison) []sqlparser.Comparison { return nil }
+func (termCollector) ExprComparison(sqlparser.ExprComparison) []sqlparser.Comparison     { return nil }
+func (termCollector) In(sqlparser.InExpr) []sqlparser.Comparison                         { return nil }
+
+// foldsStrings reports whether a comparison matches strings case-insensitively.
+// Block bounds are ordered case-sensitively, so they can't rule such rows out.
+func foldsStrings(c sqlparser.Comparison) bool {
+	return c.CaseInsensitive && !c.IsNumeric && !c.IsTimestamp
+}
+
+// boolWanted is the value a boolean comparison matches: active != true
+// matches false
+func boolWanted(c sqlparser.Comparison) bool {
+	return c.BoolValue != (c.Operator == "!=")
+}
+
+// executeFromStream handles queries reading stdin or another stream (see
+// isStream) front to back, with no seeking
+func executeFromStream(query sqlparser.Query, out io.Writer, stats *Stats) error {
+	reader, closeStream, err := openStream(&query, stats)
 	if err != nil {
-		return fmt.Errorf("read header: %w", err)
+		return err
+	}
+	defer closeStream()
+	header, first, err := streamHeader(query, reader)
+	if err != nil {
+		return err
+	}
+	if isAggregateQuery(query) {
+		return executeGroupBy(query, reader, header, first, out, stats)
 	}
+	reader.ReuseRecord = true
 
 	// Build column map
 	colMap := make(map[string]int, len(header))
 	for i, col := range header {
-		colMap[strings.ToLower(col)] = i
+		colMap[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	pseudo := resolvePseudoColumns(query, colMap, len(header))
+	guard, err := newRowGuard(query, len(header), stats)
+	if err != nil {
+		return err
+	}
+	defer guard.close()
+
+	// Resolve WHERE columns once; rows are then tested in place
+	filter, err := compileVectorFilter(query.Where, colMap)
+	if err != nil {
+		return err
 	}
 
 	// Determine output columns
-	outCols := header
-	outIndices := make([]int, len(header))
-	for i := range outIndices {
-		outIndices[i] = i
-	}
-
-	if !query.AllColumns {
-		outCols = query.Columns
-		outIndices = make([]int, len(query.Columns))
-		for i, col := range query.Columns {
-			idx, ok := colMap[strings.ToLower(col)]
-			if !ok {
-				return fmt.Errorf("column not found: %s", col)
-			}
-			outIndices[i] = idx
-		}
+	proj, outCols, err := resolveProjection(query, header, colMap)
+	if err != nil {
+		return err
 	}
 
 	// Write output header
-	writer := csv.NewWriter(out)
+	writer := NewFastCSVWriterSize(out, query.Resources.IOBufferSize)
 	defer writer.Flush()
 
 	if err := writer.Write(outCols); err != nil {
 		return fmt.Errorf("write header: %w", err)
 	}
 
-	// Stream rows
-	rowCount := 0
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("read row: %w", err)
-		}
-
-		// Apply WHERE filter
-		if query.Where != nil {
-			// Build row map for evaluation
-			rowMap := make(map[string]string, len(record))
-			for col, idx := range colMap {
-				if idx < len(record) {
-					rowMap[col] = record[idx]
-				}
-			}
-
-			if !sqlparser.Evaluate(query.Where, rowMap) {
-				continue
-			}
-		}
-
-		// Build output row
-		outRow := make([]string, len(outIndices))
-		for i, idx := range outIndices {
-			if idx < len(record) {
-				outRow[i] = record[idx]
-			}
-		}
-
-		if err := writer.Write(outRow); err != nil {
-			return fmt.Errorf("write row: %w", err)
-		}
-
-		rowCount++
-		if query.Limit > 0 && rowCount >= query.Limit {
-			break
-		}
+	// Stream rows: scan, filter, project, sample, limit. The limit stops
+	// the scan once reached.
+	timer := &rowTimer{stats: stats}
+	var rows operator = &scanOp{reader: reader, first: first, guard: guard, pseudo: pseudo, lines: headerLines(query.NoHeader), stats: stats, timer: timer}
+	rows = &filterOp{child: rows, filter: filter, stats: stats, timer: timer}
+	rows = &projectOp{child: rows, proj: proj}
+	if sample := newSampler(query.Sample); sample != nil {
+		rows = &sampleOp{child: rows, sample: sample}
+	}
+	rows = &limitOp{child: rows, n: query.Limit}
+	if err := writeRows(writer, rows, stats, timer); err != nil {
+		return err
 	}
 
-	return nil
+	return guard.close()
 }
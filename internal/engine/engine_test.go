@@ -2,10 +2,20 @@ package engine
 
 import (
 	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
+	"github.com/melihbirim/sieswi/internal/sidx"
 	"github.com/melihbirim/sieswi/internal/sqlparser"
 )
 
@@ -20,6 +30,31 @@ func writeTempCSV(t *testing.T, content string) string {
 	return path
 }
 
+// writeTestIndex builds a .sidx sidecar for csvPath with the given rows per block.
+func writeTestIndex(t *testing.T, csvPath string, blockSize uint32) {
+	t.Helper()
+
+	index, err := sidx.NewBuilder(blockSize).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+	saveTestIndex(t, csvPath, index)
+}
+
+// saveTestIndex writes index next to csvPath
+func saveTestIndex(t *testing.T, csvPath string, index *sidx.Index) {
+	t.Helper()
+
+	f, err := os.Create(csvPath + ".sidx")
+	if err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	defer f.Close()
+	if err := sidx.WriteIndex(f, index); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+}
+
 func TestExecuteStreamsProjectedRows(t *testing.T) {
 	csvPath := writeTempCSV(t, "id,name,amount\n1,alpha,10\n2,beta,20\n3,gamma,30\n")
 
@@ -258,3 +293,1337 @@ func contains(s, substr string) bool {
 		(s[:len(substr)] == substr || s[len(s)-len(substr):] == substr ||
 			contains(s[1:], substr)))
 }
+
+func TestCanPruneBlockExprNot(t *testing.T) {
+	index := &sidx.Index{
+		Header: sidx.Header{
+			Columns: []sidx.ColumnInfo{
+				{Name: "country", Type: sidx.ColumnTypeString},
+				{Name: "status", Type: sidx.ColumnTypeString},
+			},
+		},
+	}
+	block := &sidx.BlockMeta{StartRow: 0, EndRow: 100, Columns: []sidx.ColumnStats{
+		{Min: "UK", Max: "UK"},
+		{Min: "active", Max: "pending"},
+	}}
+
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{"NOT country = 'UK'", true},
+		{"NOT country = 'US'", false},
+		{"NOT (country = 'UK' AND status >= 'active')", true},
+		{"NOT (country = 'UK' AND status = 'active')", false},
+		{"NOT (country = 'US' OR status < 'zzz')", true},
+		{"NOT (country = 'US' OR status = 'active')", false},
+		{"NOT NOT country = 'US'", true},
+		{"NOT NOT country = 'UK'", false},
+		{"status = 'done' AND NOT country = 'UK'", true},
+	}
+
+	for _, tt := range tests {
+		query, err := sqlparser.Parse("SELECT * FROM data.csv WHERE " + tt.where)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.where, err)
+		}
+		if got := canPruneBlockExpr(index, block, query.Where); got != tt.want {
+			t.Errorf("canPruneBlockExpr(%s) = %v, want %v", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteWithIndexMatchesFullScan(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,country,amount,created_at\n")
+	countries := []string{"DE", "FR", "UK", "US"}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 1000; i++ {
+		// Countries and timestamps are clustered so whole blocks can be pruned
+		created := start.Add(time.Duration(i) * time.Hour).Format(time.RFC3339)
+		if i%10 == 0 {
+			created = start.Add(time.Duration(i) * time.Hour).Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(&sb, "%d,%s,%d,%s\n", i, countries[(i-1)/250], (i*37)%500, created)
+	}
+	csvPath := writeTempCSV(t, sb.String())
+
+	queries := []string{
+		"SELECT * FROM '%s' WHERE id > 900",
+		"SELECT id FROM '%s' WHERE id <= 30 OR id >= 990",
+		"SELECT id, amount FROM '%s' WHERE country = 'UK' AND amount < 100",
+		"SELECT * FROM '%s' WHERE country = 'FR' LIMIT 7",
+		"SELECT * FROM '%s' WHERE country = 'XX'",
+		"SELECT * FROM '%s' WHERE amount > 10",
+		"SELECT id FROM '%s' WHERE amount >= 490 OR amount = 7",
+		"SELECT id FROM '%s' WHERE created_at >= '2024-02-10' AND created_at < '2024-02-11T06:00:00Z'",
+		"SELECT id FROM '%s' WHERE NOT country = 'DE' AND NOT country = 'FR' AND id < 600",
+		"SELECT id FROM '%s' WHERE NOT (id > 50 AND id < 950)",
+		"SELECT id FROM '%s' WHERE NOT amount >= 0",
+		"SELECT id FROM '%s' WHERE country =~ 'uk' AND id < 700",
+		"SELECT id FROM '%s' WHERE NOT country ILIKE 'd%%' AND id < 300",
+		"SELECT id FROM '%s' WHERE id >= 400 AND id < 420 AND amount > 100",
+		"SELECT COUNT(*), SUM(amount) FROM '%s' WHERE created_at >= '2024-01-20' AND created_at <= '2024-01-25'",
+	}
+
+	run := func(query string) string {
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil {
+			t.Fatalf("execute %q: %v", query, err)
+		}
+		return out.String()
+	}
+
+	want := make([]string, len(queries))
+	for i, query := range queries {
+		want[i] = run(query)
+	}
+
+	writeTestIndex(t, csvPath, 40)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	// The index must actually be used for the comparison to mean anything
+	q, _ := sqlparser.Parse(fmt.Sprintf(queries[0], csvPath))
+	index, pruned, release := loadPrunableIndex(q)
+	release()
+	if index == nil || len(pruned) == 0 {
+		t.Fatal("expected the index to prune blocks for id > 900")
+	}
+
+	for _, mmap := range []string{"0", "1"} {
+		t.Setenv("SIDX_MMAP", mmap)
+		for i, query := range queries {
+			if got := run(query); got != want[i] {
+				t.Errorf("%s (SIDX_MMAP=%s): indexed result differs from full scan\nwant:\n%s\ngot:\n%s", query, mmap, want[i], got)
+			}
+		}
+	}
+}
+
+func TestExecuteQuotedMultilineFields(t *testing.T) {
+	csvPath := writeTempCSV(t, "id,note,amount\n"+
+		"1,\"first line\nsecond, line\",10\n"+
+		"2,plain,20\n"+
+		"3,\"quoted \"\"word\"\"\",30\n")
+
+	q, err := sqlparser.Parse(fmt.Sprintf("SELECT id, note FROM '%s' WHERE amount != 20", csvPath))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var out bytes.Buffer
+	if err := Execute(q, &out); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	want := "id,note\n1,\"first line\nsecond, line\"\n3,\"quoted \"\"word\"\"\"\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestFieldsNeeded(t *testing.T) {
+	index := map[string]int{"id": 0, "name": 1, "country": 2, "amount": 3}
+	tests := []struct {
+		where   string
+		columns []int
+		want    int
+	}{
+		{"", []int{0, 1, 2, 3}, 4},
+		{"", []int{1}, 2},
+		{"country = 'UK'", []int{0}, 3},
+		{"id = 1 OR NOT amount > 5", []int{1}, 4},
+	}
+	for _, tt := range tests {
+		var where sqlparser.Expression
+		if tt.where != "" {
+			q, err := sqlparser.Parse("SELECT * FROM data.csv WHERE " + tt.where)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.where, err)
+			}
+			where = q.Where
+		}
+		if got := fieldsNeeded(where, index, tt.columns); got != tt.want {
+			t.Errorf("fieldsNeeded(%q, %v) = %d, want %d", tt.where, tt.columns, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteWithStatsCountsProgress(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,country\n")
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&sb, "%d,%s\n", i, []string{"DE", "FR", "UK", "US"}[i%4])
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	size := int64(sb.Len())
+
+	run := func(query string) (*Stats, int) {
+		t.Helper()
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		stats := &Stats{}
+		var out bytes.Buffer
+		if err := ExecuteWithStats(q, &out, stats); err != nil {
+			t.Fatalf("execute %q: %v", query, err)
+		}
+		return stats, strings.Count(out.String(), "\n") - 1
+	}
+
+	t.Setenv("SIDX_NO_PARALLEL", "1")
+	stats, rows := run("SELECT id FROM '%s' WHERE country = 'UK'")
+	if stats.BytesRead.Load() != size || stats.RowsMatched.Load() != int64(rows) || rows != 500 {
+		t.Errorf("sequential: read %d of %d bytes, matched %d, wrote %d rows",
+			stats.BytesRead.Load(), size, stats.RowsMatched.Load(), rows)
+	}
+
+	t.Setenv("SIDX_NO_PARALLEL", "0")
+	forceParallel(t, 100)
+	stats, rows = run("SELECT id FROM '%s' WHERE country = 'UK'")
+	if stats.BytesRead.Load() != size || stats.RowsMatched.Load() != int64(rows) || rows != 500 {
+		t.Errorf("parallel: read %d of %d bytes, matched %d, wrote %d rows",
+			stats.BytesRead.Load(), size, stats.RowsMatched.Load(), rows)
+	}
+
+	writeTestIndex(t, csvPath, 100)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+	stats, rows = run("SELECT id FROM '%s' WHERE id >= 1500")
+	if stats.BytesSkipped.Load() == 0 || stats.RowsMatched.Load() != int64(rows) || rows != 500 {
+		t.Errorf("indexed: skipped %d bytes, matched %d, wrote %d rows",
+			stats.BytesSkipped.Load(), stats.RowsMatched.Load(), rows)
+	}
+}
+
+func TestExecuteIndexMode(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,country\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&sb, "%d,%s\n", i, []string{"DE", "FR", "UK", "US"}[i/250])
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	run := func(sql, mode string) (*Stats, error) {
+		q, err := sqlparser.Parse(fmt.Sprintf(sql, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", sql, err)
+		}
+		q.IndexMode = mode
+		stats := &Stats{}
+		return stats, ExecuteWithStats(q, io.Discard, stats)
+	}
+	const query = "SELECT id FROM '%s' WHERE id >= 900"
+
+	if _, err := run(query, sqlparser.IndexModeRequire); err == nil || !strings.Contains(err.Error(), "has no .sidx") {
+		t.Errorf("required index missing: err = %v", err)
+	}
+	writeTestIndex(t, csvPath, 100)
+	if stats, err := run(query, sqlparser.IndexModeRequire); err != nil || stats.BlocksPruned.Load() != 9 {
+		t.Errorf("required index: err = %v, %d blocks pruned", err, stats.BlocksPruned.Load())
+	}
+
+	// Neither the scan nor COUNT(*) reads an index that's turned off
+	for _, sql := range []string{query, "SELECT COUNT(*) FROM '%s' WHERE id >= 900"} {
+		stats, err := run(sql, sqlparser.IndexModeOff)
+		if err != nil {
+			t.Fatalf("execute %q: %v", sql, err)
+		}
+		if stats.BlocksTotal.Load() != 0 || stats.RowsScanned.Load() != 1000 {
+			t.Errorf("%s without the index: %d blocks, %d rows scanned", sql, stats.BlocksTotal.Load(), stats.RowsScanned.Load())
+		}
+	}
+
+	// A stale index is an error, not a silent full scan
+	if err := os.WriteFile(csvPath, []byte(sb.String()+"1000,US\n"), 0o600); err != nil {
+		t.Fatalf("append row: %v", err)
+	}
+	if _, err := run(query, sqlparser.IndexModeRequire); err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Errorf("required index stale: err = %v", err)
+	}
+	if _, err := run(query, ""); err != nil {
+		t.Errorf("stale index without --require-index: %v", err)
+	}
+}
+
+func TestExecuteWithStatsCountsRowsAndBlocks(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,country\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&sb, "%d,%s\n", i, []string{"DE", "FR", "UK", "US"}[i/250])
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	writeTestIndex(t, csvPath, 100)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	tests := []struct {
+		query                            string
+		scanned, matched, returned       int64
+		blocksTotal, blocksPrunedAtLeast int64
+	}{
+		{"SELECT id FROM '%s' WHERE id >= 900", 100, 100, 100, 10, 9},
+		{"SELECT id FROM '%s' LIMIT 10", 10, 10, 10, 0, 0},
+		{"SELECT country, COUNT(*) FROM '%s' GROUP BY country", 1000, 1000, 4, 0, 0},
+		{"SELECT COUNT(*) FROM '%s' WHERE country = 'FR'", 100, 250, 1, 10, 9}, // Block 200-299 straddles DE and FR,
+	}
+	for _, tt := range tests {
+		q, err := sqlparser.Parse(fmt.Sprintf(tt.query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		stats := &Stats{}
+		if err := ExecuteWithStats(q, io.Discard, stats); err != nil {
+			t.Fatalf("execute %q: %v", tt.query, err)
+		}
+		if stats.RowsScanned.Load() != tt.scanned || stats.RowsMatched.Load() != tt.matched || stats.RowsReturned.Load() != tt.returned {
+			t.Errorf("%s: scanned/matched/returned = %d/%d/%d, want %d/%d/%d", tt.query,
+				stats.RowsScanned.Load(), stats.RowsMatched.Load(), stats.RowsReturned.Load(),
+				tt.scanned, tt.matched, tt.returned)
+		}
+		if stats.BlocksTotal.Load() != tt.blocksTotal || stats.BlocksPruned.Load() < tt.blocksPrunedAtLeast {
+			t.Errorf("%s: blocks pruned %d of %d, want at least %d of %d", tt.query,
+				stats.BlocksPruned.Load(), stats.BlocksTotal.Load(), tt.blocksPrunedAtLeast, tt.blocksTotal)
+		}
+	}
+}
+
+func TestExecuteNoHeader(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&sb, "%d,%s,%d\n", i, []string{"DE", "FR", "UK"}[i%3], i%50)
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	// A header-mode index over the same file must not be used for a headerless query
+	b := sidx.NewBuilder(16)
+	b.SetHeader()
+	index, err := b.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+	saveTestIndex(t, csvPath, index)
+
+	tests := []struct {
+		query string
+		names []string
+		want  string
+	}{
+		{"SELECT * FROM '%s' LIMIT 2", nil, "c1,c2,c3\n0,DE,0\n1,FR,1\n"},
+		{"SELECT id, c3 FROM '%s' WHERE id < 2 OR id > 298", []string{"id"}, "id,c3\n0,0\n1,1\n299,49\n"},
+		{"SELECT COUNT(*) FROM '%s'", nil, "COUNT(*)\n300\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE c1 < 20", nil, "COUNT(*)\n20\n"},
+		{"SELECT country, COUNT(*) FROM '%s' WHERE id < 4 GROUP BY country", []string{"id", "country"}, "country,COUNT(*)\nDE,2\nFR,1\nUK,1\n"},
+	}
+	for _, tt := range tests {
+		q, err := sqlparser.Parse(fmt.Sprintf(tt.query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		q.NoHeader = true
+		q.HeaderNames = tt.names
+
+		t.Setenv("SIDX_NO_PARALLEL", "1")
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.query, out.String(), tt.want)
+		}
+
+		if isAggregateQuery(q) {
+			continue
+		}
+		forceParallel(t, 7)
+		var par bytes.Buffer
+		if err := ParallelExecute(q, &par); err != nil {
+			t.Fatalf("parallel %s: %v", tt.query, err)
+		}
+		if par.String() != tt.want {
+			t.Errorf("parallel %s:\ngot  %q\nwant %q", tt.query, par.String(), tt.want)
+		}
+	}
+}
+
+func TestExecuteDeclaredTypes(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("user_id,amount\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&sb, "%03d,%d\n", i, i)
+	}
+	sb.WriteString("7,7\n")
+	csvPath := writeTempCSV(t, sb.String())
+	// The index bounds are numeric, but a string-typed comparison must still
+	// see every block
+	writeTestIndex(t, csvPath, 16)
+
+	tests := []struct {
+		query  string
+		schema map[string]string
+		want   string
+	}{
+		{"SELECT COUNT(*) FROM '%s' WHERE user_id = 007", nil, "COUNT(*)\n2\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE user_id = 007", map[string]string{"user_id": sqlparser.TypeString}, "COUNT(*)\n1\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE CAST(user_id AS STRING) = '7'", nil, "COUNT(*)\n1\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE user_id < '1'", map[string]string{"user_id": sqlparser.TypeString}, "COUNT(*)\n100\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE CAST(user_id AS INT) < 3", map[string]string{"user_id": sqlparser.TypeString}, "COUNT(*)\n3\n"},
+	}
+	for _, tt := range tests {
+		q, err := sqlparser.Parse(fmt.Sprintf(tt.query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		if q.Where, err = sqlparser.WithSchema(q.Where, tt.schema); err != nil {
+			t.Fatalf("schema %q: %v", tt.query, err)
+		}
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s %v:\ngot  %q\nwant %q", tt.query, tt.schema, out.String(), tt.want)
+		}
+	}
+}
+
+func TestExecuteNullSafeComparison(t *testing.T) {
+	pairs := [][2]string{{"", ""}, {"", "5"}, {"5", "5"}, {"5", "6"}}
+	var sb strings.Builder
+	sb.WriteString("id,a,b\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&sb, "%d,%s,%s\n", i, pairs[i%4][0], pairs[i%4][1])
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	writeTestIndex(t, csvPath, 16)
+
+	ints := map[string]string{"a": sqlparser.TypeInt, "b": sqlparser.TypeInt}
+	tests := []struct {
+		query  string
+		schema map[string]string
+		want   string
+	}{
+		{"SELECT COUNT(*) FROM '%s' WHERE a <=> b", nil, "COUNT(*)\n150\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE a <=> b", ints, "COUNT(*)\n150\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE NOT a <=> b", ints, "COUNT(*)\n150\n"},
+		// Declared types make = skip empty values, which aren't numbers
+		{"SELECT COUNT(*) FROM '%s' WHERE a = b", ints, "COUNT(*)\n75\n"},
+		{"SELECT id FROM '%s' WHERE a <=> b AND id < 4", ints, "id\n0\n2\n"},
+		{"SELECT id FROM '%s' WHERE a <=> b AND id > 295", nil, "id\n296\n298\n"},
+	}
+	for _, tt := range tests {
+		q, err := sqlparser.Parse(fmt.Sprintf(tt.query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		if q.Where, err = sqlparser.WithSchema(q.Where, tt.schema); err != nil {
+			t.Fatalf("schema %q: %v", tt.query, err)
+		}
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s %v:\ngot  %q\nwant %q", tt.query, tt.schema, out.String(), tt.want)
+		}
+	}
+}
+
+func TestExecuteNumberFormat(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,amount\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&sb, "%d,\"%d,%03d.50\"\n", i, i/100+1, i%100*10)
+	}
+	sb.WriteString("300,2.5e3\n")
+	csvPath := writeTempCSV(t, sb.String())
+	us := datatype.NumberFormat{Thousands: ','}
+
+	b := sidx.NewBuilder(16)
+	b.SetNumberFormat(us)
+	index, err := b.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+	saveTestIndex(t, csvPath, index)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	tests := []struct {
+		query   string
+		numbers datatype.NumberFormat
+		want    string
+	}{
+		{"SELECT COUNT(*) FROM '%s' WHERE amount >= '3,000'", us, "COUNT(*)\n100\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE amount > 1990", us, "COUNT(*)\n202\n"},
+		{"SELECT id FROM '%s' WHERE amount = 1010.5", us, "id\n1\n"},
+		{"SELECT COUNT(*), SUM(amount) FROM '%s' WHERE id < 3", us, "COUNT(*),SUM(amount)\n3,3031.50\n"},
+		// The default format reads "1,010.50" as text and ignores the index
+		{"SELECT COUNT(*) FROM '%s' WHERE amount > 1990", datatype.NumberFormat{}, "COUNT(*)\n1\n"},
+	}
+	for _, tt := range tests {
+		q, err := sqlparser.Parse(fmt.Sprintf(tt.query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		q.Numbers = tt.numbers
+		if q.Where, err = sqlparser.WithNumberFormat(q.Where, tt.numbers); err != nil {
+			t.Fatalf("number format %q: %v", tt.query, err)
+		}
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s %v:\ngot  %q\nwant %q", tt.query, tt.numbers, out.String(), tt.want)
+		}
+	}
+}
+
+func TestExecuteBooleanPredicates(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,active\n")
+	for i := 0; i < 400; i++ {
+		// Rows 0-99 true, 100-199 false, 200-299 yes/no, 300-399 true or empty
+		active := []string{"true", "false", []string{"yes", "no"}[i%2], []string{"TRUE", ""}[i%2]}[i/100]
+		fmt.Fprintf(&sb, "%d,%s\n", i, active)
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	writeTestIndex(t, csvPath, 100)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	tests := []struct {
+		query   string
+		matched int64
+		pruned  int64
+	}{
+		{"SELECT id FROM '%s' WHERE active", 200, 1},
+		{"SELECT id FROM '%s' WHERE NOT active", 200, 1},
+		{"SELECT id FROM '%s' WHERE active = false", 150, 2},
+		{"SELECT id FROM '%s' WHERE active != 'no'", 200, 1},
+		{"SELECT id FROM '%s' WHERE active AND id < 100", 100, 3},
+	}
+	for _, tt := range tests {
+		q, err := sqlparser.Parse(fmt.Sprintf(tt.query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		if q.Where, err = sqlparser.WithSchema(q.Where, map[string]string{"active": sqlparser.TypeBoolean}); err != nil {
+			t.Fatalf("schema %q: %v", tt.query, err)
+		}
+		stats := &Stats{}
+		if err := ExecuteWithStats(q, io.Discard, stats); err != nil {
+			t.Fatalf("execute %q: %v", tt.query, err)
+		}
+		if stats.RowsMatched.Load() != tt.matched || stats.BlocksPruned.Load() != tt.pruned {
+			t.Errorf("%s: matched %d, pruned %d blocks; want %d, %d", tt.query,
+				stats.RowsMatched.Load(), stats.BlocksPruned.Load(), tt.matched, tt.pruned)
+		}
+	}
+}
+
+func TestExecuteSample(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,country\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&sb, "%d,%s\n", i, []string{"DE", "FR"}[i%2])
+	}
+	csvPath := writeTempCSV(t, sb.String())
+
+	run := func(query string) []string {
+		t.Helper()
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil {
+			t.Fatalf("execute %q: %v", query, err)
+		}
+		return strings.Split(strings.TrimSpace(out.String()), "\n")[1:]
+	}
+
+	// A reservoir holds exactly n matching rows, returned in file order
+	rows := run("SELECT id FROM '%s' SAMPLE 100 ROWS WHERE country = 'FR'")
+	if len(rows) != 100 {
+		t.Fatalf("reservoir returned %d rows, want 100", len(rows))
+	}
+	prev := -1
+	for _, row := range rows {
+		id, _ := strconv.Atoi(row)
+		if id%2 != 1 || id <= prev {
+			t.Fatalf("reservoir rows not matching or out of order: %v", rows)
+		}
+		prev = id
+	}
+	if got := run("SELECT id FROM '%s' SAMPLE 100 ROWS LIMIT 10"); len(got) != 10 {
+		t.Errorf("LIMIT after reservoir returned %d rows, want 10", len(got))
+	}
+	if got := run("SELECT id FROM '%s' SAMPLE 10000 ROWS"); len(got) != 5000 {
+		t.Errorf("reservoir larger than the input returned %d rows, want 5000", len(got))
+	}
+
+	// Bernoulli keeps about the requested share; REPEATABLE fixes the draw
+	bernoulli := run("SELECT id FROM '%s' SAMPLE 10%% REPEATABLE (3)")
+	if len(bernoulli) < 400 || len(bernoulli) > 600 {
+		t.Errorf("10%% sample returned %d of 5000 rows", len(bernoulli))
+	}
+	if again := run("SELECT id FROM '%s' SAMPLE 10%% REPEATABLE (3)"); strings.Join(again, ",") != strings.Join(bernoulli, ",") {
+		t.Errorf("REPEATABLE sample changed between runs")
+	}
+	if got := run("SELECT id FROM '%s' SAMPLE 0%%"); len(got) != 0 {
+		t.Errorf("0%% sample returned %d rows", len(got))
+	}
+
+	// Aggregates run over the sample
+	if got := run("SELECT COUNT(*) FROM '%s' SAMPLE 250 ROWS"); len(got) != 1 || got[0] != "250" {
+		t.Errorf("COUNT(*) over a reservoir = %v, want 250", got)
+	}
+	groups := run("SELECT country, COUNT(*) FROM '%s' SAMPLE 20 ROWS REPEATABLE (9) GROUP BY country")
+	total := 0
+	for _, g := range groups {
+		n, _ := strconv.Atoi(strings.Split(g, ",")[1])
+		total += n
+	}
+	if total != 20 {
+		t.Errorf("grouped reservoir counts sum to %d, want 20: %v", total, groups)
+	}
+}
+
+func TestExecuteLineColumn(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,note\n")
+	for i := 0; i < 1000; i++ {
+		if i == 3 {
+			sb.WriteString("3,\"two\nlines\"\n") // Still one record, one line number
+			continue
+		}
+		fmt.Fprintf(&sb, "%d,n%d\n", i, i)
+	}
+	csvPath := writeTempCSV(t, sb.String())
+
+	run := func(query string) (string, *Stats) {
+		t.Helper()
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		stats := &Stats{}
+		var out bytes.Buffer
+		if err := ExecuteWithStats(q, &out, stats); err != nil {
+			t.Fatalf("execute %q: %v", query, err)
+		}
+		return out.String(), stats
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT _line, id FROM '%s' WHERE _line BETWEEN 4 AND 6", "_line,id\n4,2\n5,3\n6,4\n"},
+		{"SELECT id FROM '%s' WHERE _line = 1001", "id\n999\n"},
+		{"SELECT id FROM '%s' WHERE NOT _line > 2 OR id = 7", "id\n0\n7\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE _line >= 902", "COUNT(*)\n100\n"},
+		{"SELECT MAX(_line) FROM '%s' WHERE id < 10", "MAX(_line)\n11\n"},
+	}
+	for _, tt := range tests {
+		if got, _ := run(tt.query); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+
+	// With an index, a range of lines is a seek to the blocks holding it
+	writeTestIndex(t, csvPath, 100)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+	for _, tt := range tests[:4] {
+		if got, _ := run(tt.query); got != tt.want {
+			t.Errorf("indexed %s:\ngot  %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+	_, stats := run("SELECT id FROM '%s' WHERE _line BETWEEN 503 AND 520")
+	if stats.BlocksPruned.Load() != 9 || stats.RowsScanned.Load() != 100 {
+		t.Errorf("line range pruned %d blocks and scanned %d rows, want 9 and 100",
+			stats.BlocksPruned.Load(), stats.RowsScanned.Load())
+	}
+
+	// A real column named _line wins over the pseudo-column
+	csvPath = writeTempCSV(t, "_line,id\nx,1\ny,2\n")
+	if got, _ := run("SELECT id FROM '%s' WHERE _line = 'y'"); got != "id\n2\n" {
+		t.Errorf("real _line column: got %q", got)
+	}
+}
+
+func TestExecuteFileAndOffsetColumns(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,note\n")
+	for i := 0; i < 1000; i++ {
+		if i == 3 {
+			sb.WriteString("3,\"two\nlines\"\n")
+			continue
+		}
+		fmt.Fprintf(&sb, "%d,n%d\n", i, i)
+	}
+	data := sb.String()
+	csvPath := writeTempCSV(t, data)
+
+	// Every _offset points at the start of its row's record
+	check := func(label, query string) {
+		t.Helper()
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil {
+			t.Fatalf("execute %q: %v", query, err)
+		}
+		rows := strings.Split(strings.TrimSpace(out.String()), "\n")[1:]
+		if len(rows) == 0 {
+			t.Fatalf("%s: no rows", label)
+		}
+		for _, row := range rows {
+			fields := strings.Split(row, ",")
+			offset, _ := strconv.Atoi(fields[2])
+			if fields[1] != csvPath || !strings.HasPrefix(data[offset:], fields[0]+",") {
+				t.Errorf("%s: row %q doesn't point at its record", label, row)
+			}
+		}
+	}
+	check("scan", "SELECT id, _file, _offset FROM '%s' WHERE id >= 2 AND id <= 5")
+	check("offset filter", "SELECT id, _file, _offset FROM '%s' WHERE _offset > 7000")
+
+	q, err := sqlparser.Parse(fmt.Sprintf("SELECT _file, COUNT(*) FROM '%s' WHERE _offset < 30 GROUP BY _file", csvPath))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var out bytes.Buffer
+	if err := Execute(q, &out); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	// Records 0-3 start at offsets 8, 13, 18, and 23; record 4 follows the quoted one at 38
+	if want := "_file,COUNT(*)\n" + csvPath + ",4\n"; out.String() != want {
+		t.Errorf("grouped by _file: got %q, want %q", out.String(), want)
+	}
+
+	writeTestIndex(t, csvPath, 100)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+	check("indexed", "SELECT id, _file, _offset FROM '%s' WHERE id >= 950")
+	check("indexed by line", "SELECT id, _file, _offset FROM '%s' WHERE _line BETWEEN 500 AND 510")
+}
+
+func TestExecuteLineEndings(t *testing.T) {
+	endings := map[string][]string{
+		"LF":                  {"\n"},
+		"CRLF":                {"\r\n"},
+		"CR":                  {"\r"},
+		"mixed":               {"\n", "\r\n", "\r"},
+		"no trailing newline": {"\n"},
+	}
+	for name, eol := range endings {
+		var sb strings.Builder
+		sb.WriteString("id,note" + eol[0])
+		for i := 0; i < 500; i++ {
+			end := eol[i%len(eol)]
+			switch {
+			case i == 3:
+				fmt.Fprintf(&sb, "3,\"two%slines\"%s", end, end)
+			case i == 10:
+				fmt.Fprintf(&sb, "10,n10%s%s", end, end) // Followed by a blank line
+			case i == 499 && name == "no trailing newline":
+				sb.WriteString("499,n499")
+			default:
+				fmt.Fprintf(&sb, "%d,n%d%s", i, i, end)
+			}
+		}
+		data := sb.String()
+		csvPath := writeTempCSV(t, data)
+
+		run := func(label, query string) string {
+			t.Helper()
+			q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+			if err != nil {
+				t.Fatalf("%s: parse %q: %v", name, query, err)
+			}
+			var out bytes.Buffer
+			if err := Execute(q, &out); err != nil {
+				t.Fatalf("%s %s: %v", name, label, err)
+			}
+			return out.String()
+		}
+		// Every path must agree on line numbers and offsets
+		checkRows := func(label string) {
+			t.Helper()
+			out := run(label, "SELECT id, note, _line, _offset FROM '%s' WHERE id >= 495 OR id = 3")
+			rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+			if err != nil || len(rows) != 7 {
+				t.Fatalf("%s %s: got %q (%v)", name, label, out, err)
+			}
+			for _, row := range rows[1:] {
+				id, _ := strconv.Atoi(row[0])
+				offset, _ := strconv.Atoi(row[3])
+				note := fmt.Sprintf("n%d", id)
+				if id == 3 {
+					// Quoted line breaks read as \n, except a lone \r
+					note = "two" + strings.ReplaceAll(eol[3%len(eol)], "\r\n", "\n") + "lines"
+				}
+				if row[1] != note {
+					t.Errorf("%s %s: row %q has a stray line ending", name, label, row)
+				}
+				if row[2] != strconv.Itoa(id+2) || !strings.HasPrefix(data[offset:], row[0]+",") {
+					t.Errorf("%s %s: row %q has the wrong line or offset", name, label, row)
+				}
+			}
+		}
+
+		t.Setenv("SIDX_NO_PARALLEL", "1")
+		checkRows("scan")
+		if got := run("count", "SELECT COUNT(*) FROM '%s' WHERE id >= 250"); got != "COUNT(*)\n250\n" {
+			t.Errorf("%s count: got %q", name, got)
+		}
+
+		writeTestIndex(t, csvPath, 64)
+		checkRows("indexed")
+		if got := run("indexed count", "SELECT COUNT(*) FROM '%s'"); got != "COUNT(*)\n500\n" {
+			t.Errorf("%s indexed count: got %q", name, got)
+		}
+		sidx.DefaultCache.Invalidate(csvPath)
+
+		q, err := sqlparser.Parse(fmt.Sprintf("SELECT id, note FROM '%s' WHERE id >= 495", csvPath))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		forceParallel(t, 7)
+		var par bytes.Buffer
+		if err := ParallelExecute(q, &par); err != nil {
+			t.Fatalf("%s parallel: %v", name, err)
+		}
+		if want := "id,note\n495,n495\n496,n496\n497,n497\n498,n498\n499,n499\n"; par.String() != want {
+			t.Errorf("%s parallel: got %q, want %q", name, par.String(), want)
+		}
+	}
+}
+
+func TestExecuteOnError(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,name,amount\n")
+	for i := 1; i <= 1000; i++ {
+		switch i {
+		case 300:
+			sb.WriteString("300,short\n")
+		case 700:
+			sb.WriteString("700,\"wide, quoted\",7,extra\n")
+		default:
+			fmt.Fprintf(&sb, "%d,n%d,%d\n", i, i, i%10)
+		}
+	}
+	csvPath := writeTempCSV(t, sb.String())
+
+	run := func(query, onError, rejectPath string) (string, *Stats, error) {
+		t.Helper()
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		q.OnError, q.RejectPath = onError, rejectPath
+		var out bytes.Buffer
+		stats := &Stats{}
+		err = ExecuteWithStats(q, &out, stats)
+		return out.String(), stats, err
+	}
+
+	// Lenient by default: the short row is padded, the wide one read as is
+	if out, _, err := run("SELECT id FROM '%s' WHERE id = 300 OR id = 700", "", ""); err != nil || out != "id\n300\n700\n" {
+		t.Errorf("default: got %q, %v", out, err)
+	}
+
+	for _, query := range []string{
+		"SELECT id FROM '%s' WHERE amount >= 0",
+		"SELECT COUNT(*) FROM '%s'",
+	} {
+		_, _, err := run(query, sqlparser.OnErrorStrict, "")
+		if err == nil || err.Error() != "line 301: expected 3 fields, got 2" {
+			t.Errorf("strict %q: got error %v", query, err)
+		}
+	}
+
+	var logged bytes.Buffer
+	malformedLog = &logged
+	defer func() { malformedLog = os.Stderr }()
+	rejectPath := filepath.Join(t.TempDir(), "rejects.csv")
+	out, stats, err := run("SELECT COUNT(*) FROM '%s' WHERE amount >= 0", sqlparser.OnErrorLog, rejectPath)
+	if err != nil {
+		t.Fatalf("log: %v", err)
+	}
+	if out != "COUNT(*)\n998\n" || stats.RowsRejected.Load() != 2 {
+		t.Errorf("log: got %q with %d rejected", out, stats.RowsRejected.Load())
+	}
+	wantLog := "line 301: expected 3 fields, got 2; skipped\nline 701: expected 3 fields, got 4; skipped\n"
+	if logged.String() != wantLog {
+		t.Errorf("log output = %q, want %q", logged.String(), wantLog)
+	}
+	if rejects, _ := os.ReadFile(rejectPath); string(rejects) != "300,short\n700,\"wide, quoted\",7,extra\n" {
+		t.Errorf("reject file = %q", rejects)
+	}
+
+	// With an index the scan seeks with encoding/csv; _line still counts skipped rows
+	writeTestIndex(t, csvPath, 100)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+	logged.Reset()
+	out, stats, err = run("SELECT id, _line FROM '%s' WHERE id >= 699 AND id <= 701", sqlparser.OnErrorSkip, "")
+	if err != nil || out != "id,_line\n699,700\n701,702\n" || stats.RowsRejected.Load() != 1 || logged.Len() != 0 {
+		t.Errorf("indexed skip: got %q, %v, %d rejected, log %q", out, err, stats.RowsRejected.Load(), logged.String())
+	}
+}
+
+func TestExecuteBOMAndEncoding(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("\xef\xbb\xbforder_id,city\n")
+	for i := 1; i <= 1000; i++ {
+		city := "Paris"
+		if i%100 == 0 {
+			city = "K\xf6ln"
+		}
+		fmt.Fprintf(&sb, "%d,%s\n", i, city)
+	}
+	latin1 := strings.Replace(sb.String(), "\xef\xbb\xbf", "", 1)
+	bomPath := writeTempCSV(t, strings.ReplaceAll(sb.String(), "\xf6", "\xc3\xb6"))
+	latin1Path := writeTempCSV(t, latin1)
+
+	run := func(query, path, encoding string) string {
+		t.Helper()
+		q, err := sqlparser.Parse(fmt.Sprintf(query, path))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		q.Encoding = encoding
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil {
+			t.Fatalf("execute %q: %v", query, err)
+		}
+		return out.String()
+	}
+
+	// The byte order mark isn't part of the first column's name
+	want := "order_id,city\n500,Köln\n"
+	if got := run("SELECT order_id, city FROM '%s' WHERE order_id = 500", bomPath, ""); got != want {
+		t.Errorf("BOM: got %q, want %q", got, want)
+	}
+	if got := run("SELECT order_id, city FROM '%s' WHERE order_id = 500", latin1Path, csvio.EncodingLatin1); got != want {
+		t.Errorf("latin1: got %q, want %q", got, want)
+	}
+	wantCount := "COUNT(*)\n10\n"
+	if got := run("SELECT COUNT(*) FROM '%s' WHERE city = 'Köln'", latin1Path, csvio.EncodingLatin1); got != wantCount {
+		t.Errorf("latin1 count: got %q, want %q", got, wantCount)
+	}
+
+	// Indexes record the encoding, and seeks decode from the block start
+	for _, path := range []string{bomPath, latin1Path} {
+		encoding := ""
+		if path == latin1Path {
+			encoding = csvio.EncodingLatin1
+		}
+		builder := sidx.NewBuilder(100)
+		builder.SetEncoding(encoding)
+		index, err := builder.BuildFromFile(path)
+		if err != nil {
+			t.Fatalf("build index: %v", err)
+		}
+		saveTestIndex(t, path, index)
+		if got := run("SELECT order_id, city FROM '%s' WHERE order_id = 500", path, encoding); got != want {
+			t.Errorf("indexed %s: got %q, want %q", path, got, want)
+		}
+		if got := run("SELECT COUNT(*) FROM '%s' WHERE city = 'Köln'", path, encoding); got != wantCount {
+			t.Errorf("indexed count %s: got %q, want %q", path, got, wantCount)
+		}
+		sidx.DefaultCache.Invalidate(path)
+	}
+
+	// Headerless: the first row's value has no mark either
+	q, _ := sqlparser.Parse(fmt.Sprintf("SELECT c1 FROM '%s' LIMIT 1", bomPath))
+	q.NoHeader = true
+	var out bytes.Buffer
+	if err := Execute(q, &out); err != nil || out.String() != "c1\norder_id\n" {
+		t.Errorf("no header: got %q, %v", out.String(), err)
+	}
+}
+
+func TestExecuteSniffedDialect(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id;city;amount\n")
+	for i := 1; i <= 1000; i++ {
+		city := "Paris"
+		if i%100 == 0 {
+			city = "\"Bad; Ems\""
+		}
+		fmt.Fprintf(&sb, "%d;%s;%d,50\n", i, city, i)
+	}
+	csvPath := writeTempCSV(t, sb.String())
+
+	run := func(query string, prepare func(*sqlparser.Query)) (string, error) {
+		t.Helper()
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		if prepare != nil {
+			prepare(&q)
+		}
+		var out bytes.Buffer
+		err = Execute(q, &out)
+		return out.String(), err
+	}
+	queries := []struct {
+		query, want string
+	}{
+		{"SELECT id, city FROM '%s' WHERE id = 500", "id,city\n500,Bad; Ems\n"},
+		{"SELECT COUNT(*) FROM '%s' WHERE city = 'Bad; Ems'", "COUNT(*)\n10\n"},
+		{"SELECT city, COUNT(*) FROM '%s' WHERE id > 990 GROUP BY city", "city,COUNT(*)\nParis,9\nBad; Ems,1\n"},
+	}
+	check := func(label string) {
+		t.Helper()
+		for _, tt := range queries {
+			if got, err := run(tt.query, nil); err != nil || got != tt.want {
+				t.Errorf("%s %s: got %q, %v; want %q", label, tt.query, got, err, tt.want)
+			}
+		}
+	}
+	check("sniffed")
+	forceParallel(t, 7)
+	check("parallel")
+
+	// The index is built for the sniffed dialect and serves the same queries
+	writeTestIndex(t, csvPath, 64)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+	check("indexed")
+	if _, err := run("SELECT id FROM '%s' WHERE id = 5", func(q *sqlparser.Query) {
+		q.Dialect.Delimiter = ','
+		q.IndexMode = sqlparser.IndexModeRequire
+	}); err == nil || !strings.Contains(err.Error(), "dialect") && !strings.Contains(err.Error(), "delimiter") {
+		t.Errorf("index for another delimiter: err = %v", err)
+	}
+
+	// A given delimiter isn't second-guessed
+	if got, err := run("SELECT * FROM '%s' LIMIT 1", func(q *sqlparser.Query) { q.Dialect.Delimiter = '|' }); err != nil || got != "id;city;amount\n\"1;Paris;1,50\"\n" {
+		t.Errorf("given delimiter: got %q, %v", got, err)
+	}
+
+	// Headerless numbers are sniffed as data, unless HasHeader says otherwise
+	numbers := writeTempCSV(t, "1;2\n3;4\n5;6\n")
+	for _, hasHeader := range []bool{false, true} {
+		q, _ := sqlparser.Parse(fmt.Sprintf("SELECT * FROM '%s'", numbers))
+		q.HasHeader = hasHeader
+		want := "c1,c2\n1,2\n3,4\n5,6\n"
+		if hasHeader {
+			want = "1,2\n3,4\n5,6\n"
+		}
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil || out.String() != want {
+			t.Errorf("header %v: got %q, %v; want %q", hasHeader, out.String(), err, want)
+		}
+	}
+}
+
+func TestExecuteScalarExpressions(t *testing.T) {
+	path := writeTempCSV(t, "id,total_minor,qty,region\n1,12345,2,EU\n2,999,3,US\n3,50,0,EU\n4,n/a,1,US\n")
+
+	run := func(query string) (string, error) {
+		t.Helper()
+		q, err := sqlparser.Parse(fmt.Sprintf(query, path))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		var out bytes.Buffer
+		err = Execute(q, &out)
+		return out.String(), err
+	}
+
+	for _, tt := range []struct {
+		query, want string
+	}{
+		// Arithmetic on a value that isn't a number, or division by zero,
+		// outputs an empty field
+		{"SELECT id, ROUND(total_minor/100.0, 2) AS total_gbp, total_minor * qty FROM '%s'",
+			"id,total_gbp,total_minor * qty\n1,123.45,24690\n2,9.99,2997\n3,0.5,0\n4,,\n"},
+		{"SELECT id, total_minor / qty AS unit FROM '%s' WHERE qty = 0", "id,unit\n3,\n"},
+		{"SELECT id AS order_id, ABS(-qty), CEIL(qty / 2), FLOOR(qty / 2) FROM '%s' LIMIT 2",
+			"order_id,ABS(-qty),CEIL(qty / 2),FLOOR(qty / 2)\n1,2,1,1\n2,3,2,1\n"},
+		{"SELECT id FROM '%s' WHERE total_minor / qty > 300", "id\n1\n2\n"},
+		{"SELECT id FROM '%s' WHERE ROUND(total_minor, -2) = 1000 OR 2 * qty = id - 1", "id\n2\n"},
+		{"SELECT region AS area, COUNT(*) AS orders FROM '%s' GROUP BY region", "area,orders\nEU,2\nUS,2\n"},
+		{"SELECT COUNT(*) AS n FROM '%s' WHERE qty * 10 >= 20", "n\n2\n"},
+	} {
+		got, err := run(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+
+	if _, err := run("SELECT id, missing * 2 FROM '%s'"); err == nil || !strings.Contains(err.Error(), `"missing" not found`) {
+		t.Errorf("missing column: got %v", err)
+	}
+	if _, err := run("SELECT region, qty * 2 FROM '%s' GROUP BY region"); err == nil || !strings.Contains(err.Error(), "not supported with GROUP BY") {
+		t.Errorf("computed column with GROUP BY: got %v", err)
+	}
+}
+
+func TestExecuteQualifyTopN(t *testing.T) {
+	path := writeTempCSV(t, "order_id,country,total\n1,UK,50\n2,US,70\n3,UK,90\n4,UK,20\n5,US,10\n6,DE,5\n7,UK,90\n8,US,\n9,US,abc\n")
+
+	run := func(query string) (string, error) {
+		t.Helper()
+		q, err := sqlparser.Parse(fmt.Sprintf(query, path))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		var out bytes.Buffer
+		err = Execute(q, &out)
+		return out.String(), err
+	}
+
+	for _, tt := range []struct {
+		query, want string
+	}{
+		// Partitions come out in order of first appearance; ties keep input order
+		{"SELECT order_id, country, total FROM '%s' QUALIFY ROW_NUMBER() OVER (PARTITION BY country ORDER BY total DESC) <= 2",
+			"order_id,country,total\n3,UK,90\n7,UK,90\n9,US,abc\n2,US,70\n6,DE,5\n"},
+		// Empty values rank last in either direction
+		{"SELECT order_id FROM '%s' WHERE country = 'US' QUALIFY ROW_NUMBER() OVER (ORDER BY total) <= 10",
+			"order_id\n5\n2\n9\n8\n"},
+		{"SELECT order_id, total * 2 AS doubled FROM '%s' WHERE total > 10 QUALIFY ROW_NUMBER() OVER (ORDER BY -total) < 3",
+			"order_id,doubled\n3,180\n7,180\n"},
+		{"SELECT * FROM '%s' QUALIFY ROW_NUMBER() OVER (PARTITION BY country ORDER BY total) = 1 LIMIT 2",
+			"order_id,country,total\n4,UK,20\n5,US,10\n"},
+	} {
+		got, err := run(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+
+	// StableOrder keeps the same rows in input order
+	q, err := sqlparser.Parse(fmt.Sprintf("SELECT order_id, country, total FROM '%s' QUALIFY ROW_NUMBER() OVER (PARTITION BY country ORDER BY total DESC) <= 2 LIMIT 4", path))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if SourceOrder(q) {
+		t.Error("ranked QUALIFY reported in source order")
+	}
+	q.StableOrder = true
+	var out bytes.Buffer
+	if err := Execute(q, &out); err != nil {
+		t.Fatalf("stable order: %v", err)
+	}
+	if want := "order_id,country,total\n2,US,70\n3,UK,90\n6,DE,5\n7,UK,90\n"; out.String() != want || !SourceOrder(q) {
+		t.Errorf("stable order:\ngot  %q\nwant %q", out.String(), want)
+	}
+
+	if _, err := run("SELECT * FROM '%s' QUALIFY ROW_NUMBER() OVER (PARTITION BY region ORDER BY total) <= 1"); err == nil || !strings.Contains(err.Error(), `"region" not found`) {
+		t.Errorf("missing partition column: got %v", err)
+	}
+	if _, err := run("SELECT COUNT(*) FROM '%s' QUALIFY ROW_NUMBER() OVER (ORDER BY total) <= 1"); err == nil {
+		t.Error("expected an error for QUALIFY with aggregates")
+	}
+}
+
+func TestExecuteInSubquery(t *testing.T) {
+	orders := writeTempCSV(t, "user_id,amount\n1,10\n2,20\n3,30\n4.0,40\n,50\n")
+	vip := writeTempCSV(t, "user_id,tier\n1,gold\n4,gold\n5,silver\n")
+
+	for _, tt := range []struct {
+		query, want string
+	}{
+		// 4.0 matches 4: values that read as numbers match numerically
+		{"SELECT * FROM '%[1]s' WHERE user_id IN (SELECT user_id FROM '%[2]s' WHERE tier = 'gold')",
+			"user_id,amount\n1,10\n4.0,40\n"},
+		{"SELECT amount FROM '%[1]s' WHERE user_id NOT IN (SELECT user_id FROM '%[2]s')",
+			"amount\n20\n30\n50\n"},
+		{"SELECT COUNT(*) FROM '%[1]s' WHERE user_id IN (SELECT user_id FROM '%[2]s' WHERE tier = 'silver')",
+			"COUNT(*)\n0\n"},
+		{"SELECT amount FROM '%[1]s' WHERE user_id IN (2, '3') OR amount IN (50)",
+			"amount\n20\n30\n50\n"},
+	} {
+		q, err := sqlparser.Parse(fmt.Sprintf(tt.query, orders, vip))
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.query, err)
+		}
+		var out bytes.Buffer
+		if err := Execute(q, &out); err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.query, out.String(), tt.want)
+		}
+	}
+
+	q, err := sqlparser.Parse(fmt.Sprintf("SELECT * FROM '%s' WHERE user_id IN (SELECT region FROM '%s')", orders, vip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Execute(q, io.Discard); err == nil || !strings.Contains(err.Error(), `"region" not found`) {
+		t.Errorf("missing subquery column: got %v", err)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	jan := writeTempCSV(t, "id,country,total\n1,UK,50\n2,US,70\n3,UK,90\n")
+	feb := writeTempCSV(t, "id,country,total\n4,UK,20\n5,DE,10\n")
+	vip := writeTempCSV(t, "id\n1\n4\n5\n")
+
+	prepared, err := Prepare(fmt.Sprintf("SELECT id, total FROM '%s' WHERE country = 'UK' OR id IN (SELECT id FROM '%s')", jan, vip))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{
+		jan: "id,total\n1,50\n3,90\n",
+		feb: "id,total\n4,20\n5,10\n",
+	}
+
+	// Executions are independent and may run at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		path := []string{jan, feb}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out bytes.Buffer
+			if err := prepared.ExecuteFile(path, &out, nil); err != nil {
+				errs <- err
+				return
+			}
+			if out.String() != want[path] {
+				errs <- fmt.Errorf("%s: got %q, want %q", path, out.String(), want[path])
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var out bytes.Buffer
+	if err := prepared.Execute(&out); err != nil || out.String() != want[jan] {
+		t.Errorf("Execute: got %q, %v", out.String(), err)
+	}
+
+	for sql, msg := range map[string]string{
+		"SELECT COUNT(*) FROM t.csv QUALIFY ROW_NUMBER() OVER (ORDER BY a) <= 1": "QUALIFY can't be combined with aggregates",
+		"CREATE TABLE 'x.csv' AS SELECT * FROM t.csv":                            "can't be prepared",
+		"SELECT * FROM": "missing file path",
+	} {
+		if _, err := Prepare(sql); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: got %v, want %q", sql, err, msg)
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to read while a goroutine writes it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFollow(t *testing.T) {
+	path := writeTempCSV(t, "id,level\n1,error\n2,info\n3,err")
+	appendRows := func(rows string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	follow := func(sql string, opts FollowOptions) (*lockedBuffer, chan error) {
+		query, err := sqlparser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		opts.Interval = time.Millisecond
+		out := &lockedBuffer{}
+		done := make(chan error, 1)
+		go func() { done <- Follow(query, out, nil, opts) }()
+		return out, done
+	}
+	waitFor := func(out *lockedBuffer, want string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); out.String() != want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %q, want %q", out.String(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Rows already there are skipped, and the one being written is read
+	// once its line ends
+	stop := make(chan struct{})
+	out, done := follow(fmt.Sprintf("SELECT id, _line FROM '%s' WHERE level = 'error'", path), FollowOptions{Stop: stop})
+	waitFor(out, "id,_line\n")
+	appendRows("or\n4,info\n5,error\n")
+	waitFor(out, "id,_line\n3,4\n5,6\n")
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+
+	// From the start, stopping at LIMIT
+	out, done = follow(fmt.Sprintf("SELECT id FROM '%s' WHERE level = 'error' LIMIT 4", path), FollowOptions{FromStart: true})
+	waitFor(out, "id\n1\n3\n5\n")
+	appendRows("6,error\n7,error\n")
+	if err := <-done; err != nil || out.String() != "id\n1\n3\n5\n6\n" {
+		t.Fatalf("got %q, %v", out.String(), err)
+	}
+
+	// A truncated file is read again from its start
+	stop = make(chan struct{})
+	out, done = follow(fmt.Sprintf("SELECT id FROM '%s' WHERE level = 'error'", path), FollowOptions{Stop: stop})
+	waitFor(out, "id\n")
+	if err := os.WriteFile(path, []byte("id,level\n8,error\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(out, "id\n8\n")
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+
+	for sql, msg := range map[string]string{
+		"SELECT COUNT(*) FROM '" + path + "'":     "aggregates",
+		"SELECT * FROM '" + path + "' SAMPLE 10%": "SAMPLE",
+		"SELECT * FROM stdin":                     "stdin",
+	} {
+		query, err := sqlparser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %s: %v", sql, err)
+		}
+		if err := Follow(query, io.Discard, nil, FollowOptions{}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: got %v, want %q", sql, err, msg)
+		}
+	}
+}
@@ -4,78 +4,213 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
 )
 
 // FastCSVReader is a zero-allocation CSV parser optimized for simple CSV files.
 // It's ~3-5x faster than encoding/csv for well-formed CSVs with no quoted fields.
+// Records are split by csvio.Scanner, so quoted fields may contain commas
+// and line breaks (RFC 4180) and any line ending works.
 type FastCSVReader struct {
-	scanner *bufio.Scanner
+	records *csvio.Scanner
 	fields  []string
+	raw     [][]byte
 	line    []byte
+	limit   int  // Fields to split per record; 0 splits them all
+	comma   byte // Field separator
+	quote   byte // Quote character
 }
 
-// FastCSVWriter is a simple CSV writer that skips full RFC 4180 escaping.
-// For known-simple data (no commas/quotes in fields), this is ~5x faster.
+// FastCSVWriter writes CSV records with the same output as encoding/csv
+// (LF line endings). Fields are appended to a reusable line buffer; only
+// fields containing a comma, quote, or line break (or starting with a space)
+// take the slower quoting path. Like csv.Writer, Flush reports failures through Error.
 type FastCSVWriter struct {
 	w   *bufio.Writer
 	buf []byte // Reusable buffer for building lines
+	err error
 }
 
+// defaultWriteBufferSize is the output buffer of a FastCSVWriter
+const defaultWriteBufferSize = 256 * 1024
+
 // NewFastCSVWriter creates a fast CSV writer.
 func NewFastCSVWriter(w io.Writer) *FastCSVWriter {
+	return NewFastCSVWriterSize(w, defaultWriteBufferSize)
+}
+
+// NewFastCSVWriterSize is NewFastCSVWriter with an output buffer of the given
+// size; size <= 0 uses the default of 256KB
+func NewFastCSVWriterSize(w io.Writer, size int) *FastCSVWriter {
+	if size <= 0 {
+		size = defaultWriteBufferSize
+	}
 	return &FastCSVWriter{
-		w:   bufio.NewWriterSize(w, 256*1024), // 256KB buffer
-		buf: make([]byte, 0, 512),             // Pre-allocate for typical line length
+		w:   bufio.NewWriterSize(w, size),
+		buf: make([]byte, 0, 512), // Pre-allocate for typical line length
 	}
 }
 
-// Write writes a CSV record. Assumes fields don't contain commas or quotes.
+// Write writes a CSV record.
 func (w *FastCSVWriter) Write(record []string) error {
+	if w.err != nil {
+		return w.err
+	}
 	w.buf = w.buf[:0] // Reset buffer
 
 	for i, field := range record {
 		if i > 0 {
 			w.buf = append(w.buf, ',')
 		}
+		if !fieldNeedsQuotes(field) {
+			w.buf = append(w.buf, field...)
+			continue
+		}
+		// Quote the field and double embedded quotes
+		w.buf = append(w.buf, '"')
+		for {
+			j := strings.IndexByte(field, '"')
+			if j < 0 {
+				break
+			}
+			w.buf = append(w.buf, field[:j+1]...)
+			w.buf = append(w.buf, '"')
+			field = field[j+1:]
+		}
 		w.buf = append(w.buf, field...)
+		w.buf = append(w.buf, '"')
 	}
 	w.buf = append(w.buf, '\n')
 
-	_, err := w.w.Write(w.buf)
-	return err
+	_, w.err = w.w.Write(w.buf)
+	return w.err
+}
+
+// fieldNeedsQuotes reports whether encoding/csv would quote the field
+func fieldNeedsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+	if field == `\.` {
+		return true
+	}
+	for i := 0; i < len(field); i++ {
+		switch field[i] {
+		case ',', '"', '\r', '\n':
+			return true
+		}
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
+
+// Flush writes any buffered data to the underlying writer.
+// Use Error to check for failures.
+func (w *FastCSVWriter) Flush() {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
 }
 
-// Flush flushes the buffer.
-func (w *FastCSVWriter) Flush() error {
-	return w.w.Flush()
+// Error reports any error from a previous Write or Flush.
+func (w *FastCSVWriter) Error() error {
+	return w.err
 }
 
 // NewFastCSVReader creates a fast CSV reader with large buffer.
 func NewFastCSVReader(r io.Reader) *FastCSVReader {
-	scanner := bufio.NewScanner(r)
-	// Use 1MB buffer for long CSV lines
-	buf := make([]byte, 1024*1024)
-	scanner.Buffer(buf, 1024*1024)
+	return NewFastCSVReaderSize(r, csvio.DefaultBufferSize)
+}
 
+// NewFastCSVReaderSize is NewFastCSVReader with a read buffer of the given
+// size; size <= 0 uses csvio.DefaultBufferSize
+func NewFastCSVReaderSize(r io.Reader, size int) *FastCSVReader {
 	return &FastCSVReader{
-		scanner: scanner,
+		records: csvio.NewScannerSize(r, size),
 		fields:  make([]string, 0, 16), // Pre-allocate for typical column count
+		raw:     make([][]byte, 0, 16),
+		comma:   ',',
+		quote:   '"',
+	}
+}
+
+// SetDialect splits fields separated and quoted as d says. Call it before
+// the first Read.
+func (r *FastCSVReader) SetDialect(d csvio.Dialect) {
+	r.records.SetDialect(d)
+	r.comma, r.quote = d.Comma(), d.QuoteChar()
+}
+
+// SetFieldLimit makes ReadRaw (and Read) split only the first n fields of
+// each record and skip the rest of the line, for scans that only need the
+// leading columns. Records with fewer fields are returned whole. n <= 0
+// removes the limit.
+func (r *FastCSVReader) SetFieldLimit(n int) {
+	if n < 0 {
+		n = 0
 	}
+	r.limit = n
 }
 
 // Read returns the next CSV record. Returns io.EOF when done.
 // The returned slice is reused on next call (like ReuseRecord=true).
 func (r *FastCSVReader) Read() ([]string, error) {
-	if !r.scanner.Scan() {
-		if err := r.scanner.Err(); err != nil {
+	raw, err := r.ReadRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	r.fields = r.fields[:0] // Reset but keep capacity
+	for _, field := range raw {
+		r.fields = append(r.fields, string(field))
+	}
+	return r.fields, nil
+}
+
+// Offset returns the byte offset, from the start of the input, where the
+// record last returned by Read or ReadRaw starts
+func (r *FastCSVReader) Offset() int64 {
+	return r.records.Offset()
+}
+
+// ReadRaw returns the next record as byte slices without allocating strings.
+// Unquoted fields alias the reader's line buffer, so the slices are only valid
+// until the next call. Returns io.EOF when done.
+func (r *FastCSVReader) ReadRaw() ([][]byte, error) {
+	if !r.records.Scan() {
+		if err := r.records.Err(); err != nil {
 			return nil, err
 		}
 		return nil, io.EOF
 	}
 
-	r.line = r.scanner.Bytes()
-	r.fields = r.fields[:0] // Reset but keep capacity
+	r.line = r.records.Record()
+	r.raw = r.raw[:0]
+
+	// Most lines have no quotes: find commas with bytes.IndexByte, which the
+	// runtime implements with SIMD instructions, instead of a byte loop
+	if bytes.IndexByte(r.line, r.quote) < 0 {
+		line := r.line
+		for {
+			i := bytes.IndexByte(line, r.comma)
+			if i < 0 {
+				break
+			}
+			r.raw = append(r.raw, bytes.TrimSpace(line[:i]))
+			if len(r.raw) == r.limit {
+				return r.raw, nil // Skip the unneeded trailing fields
+			}
+			line = line[i+1:]
+		}
+		r.raw = append(r.raw, bytes.TrimSpace(line))
+		return r.raw, nil
+	}
 
+	// Quoted fields: track quote state byte by byte
 	start := 0
 	inQuote := false
 	hasQuote := false
@@ -83,42 +218,43 @@ func (r *FastCSVReader) Read() ([]string, error) {
 	for i := 0; i < len(r.line); i++ {
 		c := r.line[i]
 
-		if c == '"' {
+		if c == r.quote {
 			inQuote = !inQuote
 			hasQuote = true
-		} else if c == ',' && !inQuote {
+		} else if c == r.comma && !inQuote {
 			// Field boundary - extract and clean
-			field := r.line[start:i]
-
-			// Fast path: no quotes, just trim spaces
-			if !hasQuote {
-				r.fields = append(r.fields, string(bytes.TrimSpace(field)))
-			} else {
-				// Slow path: remove quotes and unescape
-				cleaned := bytes.TrimSpace(field)
-				if len(cleaned) > 0 && cleaned[0] == '"' && cleaned[len(cleaned)-1] == '"' {
-					cleaned = cleaned[1 : len(cleaned)-1]
-				}
-				// Unescape doubled quotes: "" -> "
-				r.fields = append(r.fields, string(bytes.ReplaceAll(cleaned, []byte(`""`), []byte(`"`))))
+			r.raw = append(r.raw, cleanField(r.line[start:i], hasQuote, r.quote))
+			if len(r.raw) == r.limit {
+				return r.raw, nil
 			}
-
 			start = i + 1
 			hasQuote = false
 		}
 	}
 
 	// Last field
-	field := r.line[start:]
+	r.raw = append(r.raw, cleanField(r.line[start:], hasQuote, r.quote))
+
+	return r.raw, nil
+}
+
+// cleanField trims a field and, if it was quoted, strips the quotes and
+// unescapes doubled quotes into a new slice
+func cleanField(field []byte, hasQuote bool, quote byte) []byte {
+	cleaned := bytes.TrimSpace(field)
+	// Fast path: no quotes, just trim spaces
 	if !hasQuote {
-		r.fields = append(r.fields, string(bytes.TrimSpace(field)))
-	} else {
-		cleaned := bytes.TrimSpace(field)
-		if len(cleaned) > 0 && cleaned[0] == '"' && cleaned[len(cleaned)-1] == '"' {
-			cleaned = cleaned[1 : len(cleaned)-1]
-		}
-		r.fields = append(r.fields, string(bytes.ReplaceAll(cleaned, []byte(`""`), []byte(`"`))))
+		return cleaned
 	}
-
-	return r.fields, nil
+	// Slow path: remove quotes and unescape
+	if len(cleaned) > 0 && cleaned[0] == quote && cleaned[len(cleaned)-1] == quote {
+		cleaned = cleaned[1 : len(cleaned)-1]
+	}
+	// Unescape doubled quotes: "" -> "
+	cleaned = bytes.ReplaceAll(cleaned, []byte{quote, quote}, []byte{quote})
+	// Quoted line breaks read as \n, as with encoding/csv
+	if bytes.IndexByte(cleaned, '\r') >= 0 {
+		cleaned = bytes.ReplaceAll(cleaned, []byte("\r\n"), []byte("\n"))
+	}
+	return cleaned
 }
@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func readAllFast(t *testing.T, input string) [][]string {
+	t.Helper()
+	reader := NewFastCSVReader(strings.NewReader(input))
+	var records [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		records = append(records, append([]string(nil), record...))
+	}
+}
+
+func TestFastCSVReaderSplitsFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  [][]string
+	}{
+		{"plain", "a,b,c\n1,2,3\n", [][]string{{"a", "b", "c"}, {"1", "2", "3"}}},
+		{"empty fields", ",x,\n,,\n", [][]string{{"", "x", ""}, {"", "", ""}}},
+		{"trims spaces", " a , b\n", [][]string{{"a", "b"}}},
+		{"no trailing newline", "a,b\n1,2", [][]string{{"a", "b"}, {"1", "2"}}},
+		{"quoted comma", `id,"Smith, John",x` + "\n", [][]string{{"id", "Smith, John", "x"}}},
+		{"escaped quote", `"say ""hi""",2` + "\n", [][]string{{`say "hi"`, "2"}}},
+		{"mixed lines", "1,2\n\"a,b\",3\n4,5\n", [][]string{{"1", "2"}, {"a,b", "3"}, {"4", "5"}}},
+		{"quoted newline", "id,note\n1,\"line one\nline two\"\n2,x\n", [][]string{{"id", "note"}, {"1", "line one\nline two"}, {"2", "x"}}},
+		{"quoted newline and comma", "\"a\n,b\n\",c\n", [][]string{{"a\n,b\n", "c"}}},
+		{"crlf", "a,b\r\n1,2\r\n", [][]string{{"a", "b"}, {"1", "2"}}},
+		{"quoted crlf", "\"x\r\ny\",z\r\n", [][]string{{"x\ny", "z"}}},
+		{"escaped quote before newline", "\"he said \"\"hi\"\"\nbye\",1\n", [][]string{{"he said \"hi\"\nbye", "1"}}},
+		{"unterminated quote", "1,\"open\n2,3", [][]string{{"1", "\"open\n2,3"}}}, // Rest of input, kept verbatim
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readAllFast(t, tt.input)
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFastCSVReaderMatchesEncodingCSV(t *testing.T) {
+	input := "id,name,comment\n" +
+		"1,alice,\"likes, commas\"\n" +
+		"2,bob,\"multi\nline\ncomment\"\n" +
+		"3,carol,\"quote \"\"inside\"\" and\nnewline\"\n" +
+		"4,\"dave\",\n" +
+		"5,eve,\"\"\n"
+
+	want, err := csv.NewReader(strings.NewReader(input)).ReadAll()
+	if err != nil {
+		t.Fatalf("encoding/csv: %v", err)
+	}
+
+	// One byte per read forces records to span many buffer fills
+	for name, r := range map[string]io.Reader{
+		"whole":    strings.NewReader(input),
+		"one byte": iotest.OneByteReader(strings.NewReader(input)),
+	} {
+		reader := NewFastCSVReader(r)
+		var got [][]string
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: read: %v", name, err)
+			}
+			got = append(got, append([]string(nil), record...))
+		}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFastCSVWriterMatchesEncodingCSV(t *testing.T) {
+	records := [][]string{
+		{"id", "name", "note"},
+		{"1", "plain", ""},
+		{"2", "Smith, John", `say "hi"`},
+		{"3", "multi\nline", "crlf\r\nend"},
+		{"4", " leading space", "\tleading tab"},
+		{"5", `\.`, `"`},
+		{"6", "ünïcode", "trailing space "},
+		{},
+	}
+
+	var want bytes.Buffer
+	cw := csv.NewWriter(&want)
+	var got bytes.Buffer
+	fw := NewFastCSVWriter(&got)
+	for _, record := range records {
+		if err := cw.Write(record); err != nil {
+			t.Fatalf("encoding/csv: %v", err)
+		}
+		if err := fw.Write(record); err != nil {
+			t.Fatalf("fast writer: %v", err)
+		}
+	}
+	cw.Flush()
+	fw.Flush()
+	if err := fw.Error(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if got.String() != want.String() {
+		t.Errorf("output differs:\ngot  %q\nwant %q", got.String(), want.String())
+	}
+}
+
+func TestFastCSVWriterReportsErrors(t *testing.T) {
+	w := NewFastCSVWriter(errWriter{})
+	if err := w.Write([]string{"a"}); err != nil {
+		t.Fatalf("buffered write failed early: %v", err)
+	}
+	w.Flush()
+	if w.Error() == nil {
+		t.Fatal("expected flush error")
+	}
+	if err := w.Write([]string{"b"}); err == nil {
+		t.Error("expected writes after a failure to keep failing")
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func BenchmarkFastCSVWriter(b *testing.B) {
+	record := []string{"12345", "customer_12345", "UK", "199.50", "2024-01-15", "completed", "some free text here"}
+
+	b.Run("encoding_csv", func(b *testing.B) {
+		w := csv.NewWriter(io.Discard)
+		for i := 0; i < b.N; i++ {
+			if err := w.Write(record); err != nil {
+				b.Fatal(err)
+			}
+		}
+		w.Flush()
+	})
+	b.Run("fast", func(b *testing.B) {
+		w := NewFastCSVWriter(io.Discard)
+		for i := 0; i < b.N; i++ {
+			if err := w.Write(record); err != nil {
+				b.Fatal(err)
+			}
+		}
+		w.Flush()
+	})
+}
+
+func BenchmarkFastCSVReaderReadRaw(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&sb, "%d,customer_%d,UK,%d.50,2024-01-%02d,completed,some free text here\n", i, i, i%1000, i%28+1)
+	}
+	input := sb.String()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		reader := NewFastCSVReader(strings.NewReader(input))
+		for {
+			if _, err := reader.ReadRaw(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func TestFastCSVReaderFieldLimit(t *testing.T) {
+	input := "a,b,c,d\n1,2,3,4\n\"x,y\",\"q\"\"\",z,\"w\nv\"\nshort\n5,6\n"
+	want := [][]string{
+		{"a", "b"},
+		{"1", "2"},
+		{"x,y", `q"`},
+		{"short"},
+		{"5", "6"},
+	}
+
+	reader := NewFastCSVReader(strings.NewReader(input))
+	reader.SetFieldLimit(2)
+	for i, w := range want {
+		got, err := reader.Read()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("record %d = %q, want %q", i, got, w)
+		}
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestFastCSVReaderOffset(t *testing.T) {
+	input := "a,b\n1,\"x\ny\"\n\n22,z\n333,w"
+	want := []int64{0, 4, 13, 18} // The blank line is skipped
+
+	reader := NewFastCSVReader(iotest.OneByteReader(strings.NewReader(input)))
+	for i, w := range want {
+		if _, err := reader.Read(); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if got := reader.Offset(); got != w {
+			t.Errorf("record %d offset = %d, want %d", i, got, w)
+		}
+	}
+}
+
+func BenchmarkFastCSVReaderWide(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 5000; i++ {
+		for c := 0; c < 50; c++ {
+			if c > 0 {
+				sb.WriteByte(',')
+			}
+			fmt.Fprintf(&sb, "v%d_%d", i, c)
+		}
+		sb.WriteByte('\n')
+	}
+	input := sb.String()
+
+	for _, limit := range []int{0, 3} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				reader := NewFastCSVReader(strings.NewReader(input))
+				reader.SetFieldLimit(limit)
+				for {
+					if _, err := reader.ReadRaw(); err != nil {
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// defaultFollowInterval is how often Follow checks the file for new rows
+const defaultFollowInterval = 250 * time.Millisecond
+
+// followChunkBytes is how much new data Follow reads at once; it doubles
+// when a single record doesn't fit
+const followChunkBytes = 4 << 20
+
+// followTailBytes is how far back from the end Follow looks for the end of
+// the last complete record when it starts at the end of the file
+const followTailBytes = 64 << 10
+
+// FollowOptions controls Follow
+type FollowOptions struct {
+	FromStart bool            // Emit the rows already in the file first
+	Interval  time.Duration   // Polling interval; 0 for the default
+	Stop      <-chan struct{} // Closing it ends Follow
+	Flush     func() error    // Called after each batch of new rows, if set
+}
+
+// Follow streams the rows appended to the query's file that match it, like
+// tail -f piped to a filter, until opts.Stop is closed or LIMIT rows have
+// been written. It starts at the end of the last complete record unless
+// opts.FromStart is set, and only reads whole records, so a row being written
+// is picked up once its line ends. A file that shrinks or is replaced (log
+// rotation) is followed from its start.
+func Follow(query sqlparser.Query, out io.Writer, stats *Stats, opts FollowOptions) error {
+	normalizeQuery(&query)
+	switch {
+	case isStream(query.FilePath):
+		return fmt.Errorf("follow needs a regular file; stdin and pipes are already read as they're written")
+	case isAggregateQuery(query) || query.Top != nil:
+		return fmt.Errorf("follow can't be combined with aggregates or QUALIFY")
+	case query.Sample != nil:
+		return fmt.Errorf("follow can't be combined with SAMPLE")
+	case !csvio.SameOffsets(query.Encoding):
+		return fmt.Errorf("follow can't read %s files", query.Encoding)
+	}
+	if err := checkQuery(query); err != nil {
+		return err
+	}
+	if err := sniffInput(&query); err != nil {
+		return err
+	}
+	if err := resolveSubqueries(&query); err != nil {
+		return err
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultFollowInterval
+	}
+
+	f := &follower{query: query, opts: opts, stats: stats, writer: NewFastCSVWriterSize(out, query.Resources.IOBufferSize), chunk: followChunkBytes}
+	defer f.close()
+	if err := f.open(opts.FromStart); err != nil {
+		return err
+	}
+	var err error
+	if f.guard, err = newRowGuard(query, len(f.header), stats); err != nil {
+		return err
+	}
+	if err := f.writer.Write(f.outputHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
+
+	for {
+		progressed, done, err := f.poll()
+		if err != nil || done {
+			if ferr := f.flush(); err == nil {
+				err = ferr
+			}
+			if gerr := f.guard.close(); err == nil {
+				err = gerr
+			}
+			return err
+		}
+		if progressed {
+			if err := f.flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case <-opts.Stop:
+			if err := f.flush(); err != nil {
+				return err
+			}
+			return f.guard.close()
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// follower is the state of a Follow: the open file, what has been read of
+// it, and the query compiled against its header
+type follower struct {
+	query  sqlparser.Query
+	opts   FollowOptions
+	stats  *Stats
+	writer *FastCSVWriter
+	guard  *rowGuard
+
+	file         *os.File
+	header       []string
+	outputHeader []string
+	filter       vectorFilter
+	proj         *projection
+	pseudo       *pseudoColumns
+
+	pos     int64 // Offset just past the last record read
+	line    int64 // Line of the last record read
+	written int
+	chunk   int64
+}
+
+// open opens the file and compiles the query against its header, starting
+// at its first record or after its last complete one
+func (f *follower) open(fromStart bool) error {
+	f.close()
+	file, err := os.Open(f.query.FilePath)
+	if err != nil {
+		return fmt.Errorf("open CSV: %w", err)
+	}
+	f.file = file
+	header, dataStart, err := inputHeader(file, f.query)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	f.header = header
+	f.pseudo = resolvePseudoColumns(f.query, index, len(header))
+	if f.filter, err = compileVectorFilter(f.query.Where, index); err != nil {
+		return err
+	}
+	if f.proj, f.outputHeader, err = resolveProjection(f.query, header, index); err != nil {
+		return err
+	}
+
+	f.pos, f.line = dataStart, headerLines(f.query.NoHeader)
+	if fromStart {
+		return nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if f.pos, err = lastRecordEnd(file, dataStart, info.Size()); err != nil {
+		return err
+	}
+	// Line numbers are only worth a pass over the file when they're reported
+	if usesColumn(f.query, lineColumnName) || f.query.OnError != "" {
+		scanner := csvio.NewScanner(io.NewSectionReader(file, dataStart, f.pos-dataStart))
+		scanner.SetDialect(f.query.Dialect)
+		for scanner.Scan() {
+			f.line++
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("count lines: %w", err)
+		}
+	}
+	return nil
+}
+
+// lastRecordEnd returns where the last complete record of the data between
+// start and size ends, looking back at most followTailBytes
+func lastRecordEnd(file io.ReaderAt, start, size int64) (int64, error) {
+	from := max(start, size-followTailBytes)
+	tail := make([]byte, size-from)
+	if _, err := file.ReadAt(tail, from); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("read CSV: %w", err)
+	}
+	if len(tail) == 0 || tail[len(tail)-1] == '\n' || tail[len(tail)-1] == '\r' {
+		return size, nil
+	}
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		return from + int64(i) + 1, nil
+	}
+	if from == start {
+		return start, nil // Only a partial first record so far
+	}
+	return size, nil
+}
+
+func (f *follower) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+func (f *follower) flush() error {
+	f.writer.Flush()
+	if err := f.writer.Error(); err != nil {
+		return fmt.Errorf("write row: %w", err)
+	}
+	if f.opts.Flush != nil {
+		return f.opts.Flush()
+	}
+	return nil
+}
+
+// poll reads the complete records appended since the last poll. It reports
+// whether any were read, and whether LIMIT has been reached.
+func (f *follower) poll() (progressed, done bool, err error) {
+	info, err := os.Stat(f.query.FilePath)
+	if err != nil {
+		return false, false, nil // Between rotation steps
+	}
+	current, err := f.file.Stat()
+	if err != nil {
+		return false, false, err
+	}
+	if !os.SameFile(info, current) || info.Size() < f.pos {
+		if !headerWritten(f.query.FilePath, f.query.Dialect) {
+			return false, false, nil // The new file is still being started
+		}
+		outputHeader := f.outputHeader
+		if err := f.open(true); err != nil {
+			return false, false, err
+		}
+		if !slices.Equal(outputHeader, f.outputHeader) {
+			return false, false, fmt.Errorf("%s was replaced by a file with other columns", f.query.FilePath)
+		}
+		return true, false, nil
+	}
+	if info.Size() == f.pos {
+		return false, false, nil
+	}
+
+	data := make([]byte, min(info.Size()-f.pos, f.chunk))
+	n, err := f.file.ReadAt(data, f.pos)
+	if err != nil && err != io.EOF {
+		return false, false, fmt.Errorf("read CSV: %w", err)
+	}
+	data = data[:n]
+	complete := csvio.CompleteRecords(data, f.query.Dialect)
+	if complete == 0 {
+		if int64(n) == f.chunk {
+			f.chunk *= 2
+			return true, false, nil
+		}
+		return false, false, nil
+	}
+	done, err = f.process(data[:complete])
+	f.pos += int64(complete)
+	f.stats.addRead(int64(complete))
+	return true, done, err
+}
+
+// headerWritten reports whether the file at path has a complete first
+// record, so a file replaced by rotation isn't read before its header is
+func headerWritten(path string, d csvio.Dialect) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	data := make([]byte, followTailBytes)
+	n, _ := io.ReadFull(file, data)
+	return csvio.CompleteRecords(data[:n], d) > 0
+}
+
+// process filters and writes the records in data, which starts at f.pos
+func (f *follower) process(data []byte) (bool, error) {
+	reader := csvio.NewReader(csvio.NewDecoder(bytes.NewReader(data), f.query.Encoding))
+	reader.SetDialect(f.query.Dialect)
+	reader.ReuseRecord = true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("read row at offset %d: %w", f.pos+reader.Offset(), err)
+		}
+		f.line++
+		f.stats.addScanned(1)
+		if keep, err := f.guard.check(record, f.line); !keep {
+			if err != nil {
+				return false, err
+			}
+			continue
+		}
+		if f.pseudo != nil {
+			record = f.pseudo.add(record, f.line, f.pos+reader.Offset())
+		}
+		if f.filter != nil && !f.filter.match(record) {
+			continue
+		}
+		f.stats.addMatched(1)
+		if err := f.writer.Write(f.proj.project(record)); err != nil {
+			return false, fmt.Errorf("write row: %w", err)
+		}
+		f.stats.addReturned(1)
+		if f.written++; f.query.Limit >= 0 && f.written >= f.query.Limit {
+			return true, nil
+		}
+	}
+}
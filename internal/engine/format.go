@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// aggregateFormat renders aggregate results as the query's OutputFormat
+// says. An exact one, for HAVING and ORDER BY, writes every digit.
+type aggregateFormat struct {
+	sqlparser.OutputFormat
+	exact bool
+}
+
+// shift returns the minor-unit digits of the column an aggregate reads;
+// aggregates over expressions aren't shifted
+func (f aggregateFormat) shift(agg *AggregateFunc) int {
+	if agg.Expr != nil || len(f.MinorUnits) == 0 {
+		return 0
+	}
+	return f.MinorUnits[strings.ToLower(strings.TrimSpace(agg.Column))]
+}
+
+func (f aggregateFormat) precision() int {
+	if f.exact {
+		return -1
+	}
+	if f.HasPrecision {
+		return f.Precision
+	}
+	return sqlparser.DefaultPrecision
+}
+
+// count renders a row count
+func (f aggregateFormat) count(n int64) string {
+	return f.group(strconv.FormatInt(n, 10))
+}
+
+// float renders x, in minor units of shift digits. Minor units are shifted
+// in x's shortest decimal form, not divided, so 124955 cents is 1249.55 and
+// rounds up like an exact sum rather than as the float below it.
+func (f aggregateFormat) float(x float64, shift int) string {
+	if shift > 0 {
+		d, ok := datatype.ParseDecimal(strconv.FormatFloat(x, 'f', -1, 64))
+		if shifted, shiftOK := d.Shift(shift); ok && shiftOK {
+			return f.group(shifted.Round(f.precision()).Format(f.precision()))
+		}
+		x /= math.Pow10(shift)
+	}
+	return f.group(strconv.FormatFloat(x, 'f', f.precision(), 64))
+}
+
+// decimal renders an exact sum, in minor units of shift digits. Without a
+// set precision, digits past the default are kept rather than rounded, and
+// a sum of whole numbers is written without a point.
+func (f aggregateFormat) decimal(d datatype.Decimal, shift int) string {
+	shifted, ok := d.Shift(shift)
+	if !ok {
+		return f.float(d.Float64()/math.Pow10(shift), 0)
+	}
+	if f.HasPrecision {
+		shifted = shifted.Round(f.Precision)
+	} else if shifted.Scale == 0 {
+		return f.group(shifted.Format(0))
+	}
+	return f.group(shifted.Format(f.precision()))
+}
+
+// extreme renders a MIN or MAX as float does, but without a point when it
+// came from whole numbers only and no precision is set
+func (f aggregateFormat) extreme(x float64, shift int, whole bool) string {
+	if whole && shift == 0 && !f.HasPrecision && isWhole(x) {
+		return f.group(strconv.FormatFloat(x+0, 'f', 0, 64)) // +0 turns -0 into 0
+	}
+	return f.float(x, shift)
+}
+
+// isWhole reports whether x is a whole number a float64 holds exactly
+func isWhole(x float64) bool {
+	return x == math.Trunc(x) && math.Abs(x) <= 1<<53
+}
+
+// group inserts the thousands separator between the whole digits of a
+// rendered number, and makes the point a comma when the separator is '.'
+func (f aggregateFormat) group(s string) string {
+	if f.Thousands == 0 {
+		return s
+	}
+	sign, whole, frac := "", s, ""
+	if strings.HasPrefix(whole, "-") {
+		sign, whole = "-", whole[1:]
+	}
+	if i := strings.IndexByte(whole, '.'); i >= 0 {
+		whole, frac = whole[:i], whole[i+1:]
+	}
+	var sb strings.Builder
+	sb.WriteString(sign)
+	for i := 0; i < len(whole); i++ {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			sb.WriteByte(f.Thousands)
+		}
+		sb.WriteByte(whole[i])
+	}
+	if frac != "" {
+		point := byte('.')
+		if f.Thousands == '.' {
+			point = ','
+		}
+		sb.WriteByte(point)
+		sb.WriteString(frac)
+	}
+	return sb.String()
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// FuzzFilterMatchesEvaluate compiles a fuzzed WHERE clause and checks that
+// the compiled filter, row by row, on raw fields, and a batch at a time,
+// agrees with sqlparser.EvaluateNormalized on fuzzed rows. Run it with
+//
+//	go test ./internal/engine -run '^$' -fuzz FuzzFilterMatchesEvaluate
+func FuzzFilterMatchesEvaluate(f *testing.F) {
+	for _, seed := range []struct{ where, id, country, amount string }{
+		{"amount > 10", "1", "UK", "12"},
+		{"amount <= 99.5 AND country = 'UK'", "2", "UK", "99.5"},
+		{"NOT (country = 'DE' OR country = 'US') AND id < 150", "3", "", "abc"},
+		{"country =~ 'fr' OR (amount < 0 AND NOT id = 5)", "5", "FR", "-3"},
+		{"country ILIKE 'u%'", "6", "us", ""},
+		{"CAST(amount AS DECIMAL) = 10.0", "7", "UK", "10.00"},
+		{"amount > id OR country IN ('UK', 'DE')", "8", "DE", "1e3"},
+		{"ROUND(amount / 3, 1) >= 3.3 OR ABS(amount) = 3", "9", "US", "NaN"},
+		{"id % 7 = 0 AND amount - 1 != 9", "14", "UK", "10"},
+		{"(country = 'UK' AND amount > 10) OR (COUNTRY = 'UK' AND id < 50)", "4", "UK", "5"},
+	} {
+		f.Add(seed.where, seed.id, seed.country, seed.amount)
+	}
+	header := []string{"id", "country", "amount"}
+	index := map[string]int{"id": 0, "country": 1, "amount": 2}
+	f.Fuzz(func(t *testing.T, where, id, country, amount string) {
+		query, err := sqlparser.Parse("SELECT * FROM data.csv WHERE " + where)
+		if err != nil || query.Where == nil || len(sqlparser.Subqueries(query.Where)) > 0 {
+			return
+		}
+		filter, err := compileVectorFilter(query.Where, index)
+		if err != nil {
+			return // Unknown columns
+		}
+
+		rows := [][]string{{id, country, amount}, {id, country}, {amount, id, country}}
+		var want []int32
+		for i, row := range rows {
+			rowMap := make(map[string]string, len(row))
+			for j, value := range row {
+				rowMap[header[j]] = value
+			}
+			if sqlparser.EvaluateNormalized(query.Where, rowMap) {
+				want = append(want, int32(i))
+			}
+		}
+
+		var matched, matchedRaw []int32
+		for i, row := range rows {
+			if filter.match(row) {
+				matched = append(matched, int32(i))
+			}
+			fields := make([][]byte, len(row))
+			for j, value := range row {
+				fields[j] = []byte(value)
+			}
+			if filter.matchRaw(fields) {
+				matchedRaw = append(matchedRaw, int32(i))
+			}
+		}
+		batch := newColumnBatch()
+		batch.reset(rows)
+		filtered := filterAll(filter, batch, nil)
+
+		for name, got := range map[string][]int32{"match": matched, "matchRaw": matchedRaw, "batch": filtered} {
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("WHERE %s on %q (%s): got %v, want %v", where, rows, name, got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// groupSelector applies HAVING and ORDER BY to the rows of an aggregate
+// query. Both read a group's row as written, group columns then aggregates,
+// but with the aggregates exact: unrounded and unformatted.
+type groupSelector struct {
+	having vectorFilter
+	order  []boundScalar
+	desc   []bool
+}
+
+// aggregateKey is how an aggregate is named in HAVING and ORDER BY, matched
+// against the SELECT list regardless of case and spacing
+func aggregateKey(fn, column string) string {
+	return strings.ToLower(fn + "(" + strings.Join(strings.Fields(column), " ") + ")")
+}
+
+// outputNames maps the lower-case names of an aggregate query's output
+// columns to their positions: group columns by name and alias, aggregates
+// by their text and alias
+func outputNames(query sqlparser.Query, groupNames map[string]string, aggregates []*AggregateFunc) map[string]int {
+	names := make(map[string]int)
+	for i, col := range query.GroupBy {
+		col = strings.ToLower(strings.TrimSpace(col))
+		names[col] = i
+		if alias, ok := groupNames[col]; ok {
+			names[strings.ToLower(alias)] = i
+		}
+	}
+	for i, agg := range aggregates {
+		pos := len(query.GroupBy) + i
+		names[aggregateKey(agg.FuncName, agg.Column)] = pos
+		names[strings.ToLower(strings.TrimSpace(agg.Alias))] = pos
+	}
+	return names
+}
+
+// hiddenAggregates returns the aggregates HAVING and ORDER BY name that
+// SELECT doesn't, to be computed after the selected ones
+func hiddenAggregates(query sqlparser.Query, groupNames map[string]string, aggregates []*AggregateFunc) ([]*AggregateFunc, error) {
+	refs := sqlparser.ExpressionColumns(query.Having)
+	for _, item := range query.OrderBy {
+		if item.Expr != nil {
+			refs = append(refs, sqlparser.ScalarColumns(item.Expr)...)
+		}
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	names := outputNames(query, groupNames, aggregates)
+	var hidden []*AggregateFunc
+	for _, ref := range refs {
+		if _, ok := names[strings.ToLower(strings.TrimSpace(ref))]; ok {
+			continue
+		}
+		agg, ok := parseAggregateFunc(ref)
+		if !ok {
+			return nil, fmt.Errorf("HAVING or ORDER BY column %q is neither a GROUP BY column, an alias, nor an aggregate", ref)
+		}
+		if agg.Column != "*" && strings.ContainsAny(agg.Column, "+-*/%()") {
+			return nil, fmt.Errorf("%s in HAVING or ORDER BY must also be selected", ref)
+		}
+		names[aggregateKey(agg.FuncName, agg.Column)] = len(query.GroupBy) + len(aggregates) + len(hidden)
+		hidden = append(hidden, agg)
+	}
+	return hidden, nil
+}
+
+// newGroupSelector resolves HAVING and ORDER BY against the output columns;
+// aggregates holds the hidden aggregates after the selected ones. It
+// returns nil when the query has neither.
+func newGroupSelector(query sqlparser.Query, groupNames map[string]string, aggregates []*AggregateFunc) (*groupSelector, error) {
+	if query.Having == nil && len(query.OrderBy) == 0 {
+		return nil, nil
+	}
+	names := outputNames(query, groupNames, aggregates)
+	having, err := compileVectorFilter(query.Having, names)
+	if err != nil {
+		return nil, fmt.Errorf("HAVING: %w", err)
+	}
+	s := &groupSelector{having: having}
+	for _, item := range query.OrderBy {
+		var key boundScalar = boundColumn{col: item.Position - 1}
+		if item.Expr != nil {
+			if key, err = bindScalar(item.Expr, names); err != nil {
+				return nil, fmt.Errorf("ORDER BY: %w", err)
+			}
+		}
+		s.order = append(s.order, key)
+		s.desc = append(s.desc, item.Desc)
+	}
+	return s, nil
+}
+
+// plan applies HAVING, then ORDER BY with ties in their given order, to
+// the exact rows of an aggregateOp
+func (s *groupSelector) plan(rows operator) operator {
+	if s.having != nil {
+		rows = &filterOp{child: rows, filter: s.having}
+	}
+	if len(s.order) > 0 {
+		rows = &sortOp{child: rows, keys: s.order, desc: s.desc}
+	}
+	return rows
+}
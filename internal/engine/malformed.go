@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// malformedLog receives the rows reported by --on-error log
+var malformedLog io.Writer = os.Stderr
+
+// rowGuard applies a query's OnError policy to rows whose field count
+// differs from the header's. A nil *rowGuard keeps every row.
+type rowGuard struct {
+	policy string
+	width  int
+	stats  *Stats
+
+	rejectFile *os.File
+	reject     *FastCSVWriter
+
+	fields []string // Scratch for raw records
+}
+
+// newRowGuard returns nil when the query keeps malformed rows. The reject
+// file, if any, is created here and must be closed with close.
+func newRowGuard(query sqlparser.Query, width int, stats *Stats) (*rowGuard, error) {
+	if query.OnError == "" {
+		return nil, nil
+	}
+	g := &rowGuard{policy: query.OnError, width: width, stats: stats}
+	if query.RejectPath != "" {
+		file, err := os.Create(query.RejectPath)
+		if err != nil {
+			return nil, fmt.Errorf("create reject file: %w", err)
+		}
+		g.rejectFile = file
+		g.reject = NewFastCSVWriter(file)
+	}
+	return g, nil
+}
+
+// check reports whether to keep record, found on the given source line. A
+// malformed record under the strict policy is an error.
+func (g *rowGuard) check(record []string, line int64) (bool, error) {
+	if g == nil || len(record) == g.width {
+		return true, nil
+	}
+	return false, g.malformed(record, line)
+}
+
+// checkRaw is check for raw fields
+func (g *rowGuard) checkRaw(fields [][]byte, line int64) (bool, error) {
+	if g == nil || len(fields) == g.width {
+		return true, nil
+	}
+	g.fields = g.fields[:0]
+	for _, f := range fields {
+		g.fields = append(g.fields, string(f))
+	}
+	return false, g.malformed(g.fields, line)
+}
+
+func (g *rowGuard) malformed(record []string, line int64) error {
+	if g.policy == sqlparser.OnErrorStrict {
+		return fmt.Errorf("line %d: expected %d fields, got %d", line, g.width, len(record))
+	}
+	g.stats.addRejected(1)
+	if g.policy == sqlparser.OnErrorLog {
+		fmt.Fprintf(malformedLog, "line %d: expected %d fields, got %d; skipped\n", line, g.width, len(record))
+	}
+	if g.reject != nil {
+		if err := g.reject.Write(record); err != nil {
+			return fmt.Errorf("write reject file: %w", err)
+		}
+	}
+	return nil
+}
+
+// close flushes and closes the reject file. Later calls do nothing, so it
+// may also be deferred.
+func (g *rowGuard) close() error {
+	if g == nil || g.rejectFile == nil {
+		return nil
+	}
+	g.reject.Flush()
+	err := g.reject.Error()
+	if cerr := g.rejectFile.Close(); err == nil {
+		err = cerr
+	}
+	g.rejectFile = nil
+	if err != nil {
+		return fmt.Errorf("write reject file: %w", err)
+	}
+	return nil
+}
+
+// fieldLimit is limit unless the guard needs every field to count them
+func (g *rowGuard) fieldLimit(limit int) int {
+	if g != nil {
+		return 0
+	}
+	return limit
+}
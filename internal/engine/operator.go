@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// An operator is one stage of a query plan: a scan reads rows, and each
+// stage above it filters, samples, projects, aggregates, sorts, or limits
+// the rows of the stage below. The stage on top is drained by writeRows.
+//
+//	scan → filter → [sample] → aggregate → [HAVING → ORDER BY] → limit → output
+//
+// next returns the stage's next row, or io.EOF once there are no more. A
+// row is only valid until the following call: stages that hold rows back
+// copy them.
+//
+// Only the stream, GROUP BY, and QUALIFY paths are built from operators.
+// ExecuteWithStats still picks one of its file scans (fast, parallel, or
+// indexed) for everything else, and those work on raw fields and batches
+// and feed their writer directly.
+type operator interface {
+	next() ([]string, error)
+}
+
+// scanOp reads the data rows of a csvio.Reader, starting with a first
+// record held back by streamHeader, if any. Malformed rows go to the
+// guard, and pseudo-columns are appended.
+type scanOp struct {
+	reader *csvio.Reader
+	first  []string
+	guard  *rowGuard
+	pseudo *pseudoColumns
+	lines  int64 // Lines before the first data row
+	rows   int64 // Data rows read
+	stats  *Stats
+	timer  *rowTimer
+}
+
+func (s *scanOp) next() ([]string, error) {
+	for {
+		s.timer.startRow()
+		record, offset := s.first, int64(0) // A held-back first record starts the input
+		if record != nil {
+			s.first = nil
+		} else {
+			var err error
+			record, err = s.reader.Read()
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read row %d: %w", s.rows+1, err)
+			}
+			offset = s.reader.Offset()
+		}
+		s.rows++
+		s.stats.addScanned(1)
+		line := s.rows + s.lines
+		if keep, err := s.guard.check(record, line); !keep {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if s.pseudo != nil {
+			record = s.pseudo.add(record, line, offset)
+		}
+		s.timer.mark(phaseParse)
+		return record, nil
+	}
+}
+
+// filterOp passes the rows matching filter (all of them when it is nil),
+// counting them as matched
+type filterOp struct {
+	child  operator
+	filter vectorFilter
+	stats  *Stats
+	timer  *rowTimer
+}
+
+func (f *filterOp) next() ([]string, error) {
+	for {
+		row, err := f.child.next()
+		if err != nil {
+			return nil, err
+		}
+		matched := f.filter == nil || f.filter.match(row)
+		f.timer.mark(phaseFilter)
+		if matched {
+			f.stats.addMatched(1)
+			return row, nil
+		}
+	}
+}
+
+// sampleOp draws a SAMPLE from its child's rows. A reservoir sample reads
+// them all before returning the rows it kept, in their order.
+type sampleOp struct {
+	child  operator
+	sample *sampler
+	kept   [][]string
+	done   bool
+}
+
+func (s *sampleOp) next() ([]string, error) {
+	if !s.sample.reservoir {
+		for {
+			row, err := s.child.next()
+			if err != nil || s.sample.offer(row) {
+				return row, err
+			}
+		}
+	}
+	if !s.done {
+		if err := drain(s.child, func(row []string) {
+			s.sample.offer(append([]string(nil), row...))
+		}); err != nil {
+			return nil, err
+		}
+		s.kept, s.done = s.sample.rows(), true
+	}
+	if len(s.kept) == 0 {
+		return nil, io.EOF
+	}
+	row := s.kept[0]
+	s.kept = s.kept[1:]
+	return row, nil
+}
+
+// projectOp maps rows to the SELECT list
+type projectOp struct {
+	child operator
+	proj  *projection
+}
+
+func (p *projectOp) next() ([]string, error) {
+	row, err := p.child.next()
+	if err != nil {
+		return nil, err
+	}
+	return p.proj.project(row), nil
+}
+
+// sortOp reads all its child's rows and returns them ordered by keys,
+// computed from each row, with ties in their given order (see compareRanked)
+type sortOp struct {
+	child operator
+	keys  []boundScalar
+	desc  []bool
+	rows  []rankedRow
+	done  bool
+}
+
+// rankedRow is a row held by sortOp with its sort keys
+type rankedRow struct {
+	row    []string
+	values []sqlparser.Value
+}
+
+func (s *sortOp) next() ([]string, error) {
+	if !s.done {
+		if err := drain(s.child, func(row []string) {
+			values := make([]sqlparser.Value, len(s.keys))
+			for i, key := range s.keys {
+				values[i] = rankValue(key.eval(row, datatype.NumberFormat{}), datatype.NumberFormat{})
+			}
+			s.rows = append(s.rows, rankedRow{append([]string(nil), row...), values})
+		}); err != nil {
+			return nil, err
+		}
+		sort.SliceStable(s.rows, func(i, j int) bool {
+			for k, desc := range s.desc {
+				if cmp := compareRanked(s.rows[i].values[k], s.rows[j].values[k], desc); cmp != 0 {
+					return cmp < 0
+				}
+			}
+			return false
+		})
+		s.done = true
+	}
+	if len(s.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := s.rows[0].row
+	s.rows = s.rows[1:]
+	return row, nil
+}
+
+// limitOp ends after n rows, without reading further; n < 0 is no limit
+type limitOp struct {
+	child operator
+	n     int
+}
+
+func (l *limitOp) next() ([]string, error) {
+	if l.n == 0 {
+		return nil, io.EOF
+	}
+	row, err := l.child.next()
+	if err == nil && l.n > 0 {
+		l.n--
+	}
+	return row, err
+}
+
+// drain passes every row of op to fn
+func drain(op operator, fn func(row []string)) error {
+	for {
+		row, err := op.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(row)
+	}
+}
+
+// writeRows writes the rows of op, and flushes the writer. timer is the
+// scan's when rows stream from it; nil times each row of op alone, for
+// plans whose rows come after their input has been read.
+func writeRows(writer *FastCSVWriter, op operator, stats *Stats, timer *rowTimer) error {
+	own := timer == nil
+	if own {
+		timer = &rowTimer{stats: stats}
+	}
+	for {
+		if own {
+			timer.startRow()
+		}
+		row, err := op.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("write row: %w", err)
+		}
+		stats.addReturned(1)
+		timer.mark(phaseOutput)
+	}
+	writer.Flush()
+	return writer.Error()
+}
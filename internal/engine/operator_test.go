@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// sliceOp returns fixed rows
+type sliceOp struct{ rows [][]string }
+
+func (s *sliceOp) next() ([]string, error) {
+	if len(s.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, nil
+}
+
+func TestOperatorPipeline(t *testing.T) {
+	input := [][]string{{"a", "3"}, {"b", "1"}, {"c", "2"}, {"d", "1"}, {"e", ""}}
+	index := map[string]int{"name": 0, "n": 1}
+	where, err := sqlparser.Parse("SELECT * FROM 't.csv' WHERE n >= 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := compileVectorFilter(where.Where, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		plan func(rows operator) operator
+		want [][]string
+	}{
+		{"no limit", func(rows operator) operator { return &limitOp{child: rows, n: -1} }, input},
+		{"limit 0", func(rows operator) operator { return &limitOp{child: rows, n: 0} }, nil},
+		{"filter then limit", func(rows operator) operator {
+			return &limitOp{child: &filterOp{child: rows, filter: filter}, n: 2}
+		}, [][]string{{"a", "3"}, {"b", "1"}}},
+		{"sort is stable, nulls last", func(rows operator) operator {
+			return &sortOp{child: rows, keys: []boundScalar{boundColumn{col: 1}}, desc: []bool{false}}
+		}, [][]string{{"b", "1"}, {"d", "1"}, {"c", "2"}, {"a", "3"}, {"e", ""}}},
+		{"filter, sort, limit", func(rows operator) operator {
+			rows = &filterOp{child: rows, filter: filter}
+			rows = &sortOp{child: rows, keys: []boundScalar{boundColumn{col: 1}}, desc: []bool{true}}
+			return &limitOp{child: rows, n: 2}
+		}, [][]string{{"a", "3"}, {"c", "2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]string
+			err := drain(tt.plan(&sliceOp{rows: input}), func(row []string) {
+				got = append(got, row)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLimitStopsReading checks that a reached LIMIT doesn't pull more rows
+func TestLimitStopsReading(t *testing.T) {
+	source := &sliceOp{rows: [][]string{{"1"}, {"2"}, {"3"}}}
+	if err := drain(&limitOp{child: source, n: 1}, func([]string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(source.rows) != 2 {
+		t.Errorf("%d rows left unread, want 2", len(source.rows))
+	}
+}
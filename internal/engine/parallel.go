@@ -1,8 +1,6 @@
 package engine
 
 import (
-	"bufio"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
@@ -10,40 +8,80 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/melihbirim/sieswi/internal/csvio"
 	"github.com/melihbirim/sieswi/internal/sqlparser"
 )
 
 var errSkipParallel = errors.New("parallel processing skipped")
 
-// rowBatch represents a batch of CSV rows to process
-type rowBatch struct {
-	id   int
-	rows [][]string // Pre-parsed CSV rows
+// Parallel execution thresholds (variables so tests can exercise the
+// parallel path on small files)
+var (
+	parallelMinFileSize int64 = 10 * 1024 * 1024 // Smaller files are faster sequentially
+	parallelMinLimit          = 10000            // Smaller LIMITs are faster sequentially
+	parallelBatchSize         = 10000            // Rows per batch
+)
+
+// minBatchBytes keeps a tight --max-memory from sending rows one at a time
+const minBatchBytes = 64 * 1024
+
+// parallelWorkers returns how many workers a parallel scan runs: one per
+// CPU the runtime may use, unless the query sets its threads
+func parallelWorkers(r sqlparser.Resources) int {
+	if r.Threads > 0 {
+		return r.Threads
+	}
+	return max(runtime.GOMAXPROCS(0), 1)
 }
 
-// batchResult represents processed rows from a batch
-type batchResult struct {
-	id   int
+// chunkBatch is a run of filtered, projected rows from one chunk, or the
+// error that ended the chunk
+type chunkBatch struct {
 	rows [][]string
 	err  error
 }
 
-// ParallelExecute processes CSV in parallel by having one goroutine read/parse rows
-// and multiple worker goroutines filter and project them. This avoids chunk boundary issues.
+// ParallelExecute splits the data section of the CSV into byte ranges that
+// start on record boundaries and lets each worker parse, filter and project
+// its own ranges. The writer drains the ranges in file order, so the output
+// matches a sequential scan.
 func ParallelExecute(query sqlparser.Query, out io.Writer) error {
+	normalizeQuery(&query)
+	return parallelExecute(query, out, nil)
+}
+
+func parallelExecute(query sqlparser.Query, out io.Writer, stats *Stats) error {
+	if !csvio.SameOffsets(query.Encoding) {
+		return errSkipParallel // Chunks can't be split before decoding
+	}
+
 	// Get file size to decide if parallel processing is worth it
 	fileInfo, err := os.Stat(query.FilePath)
 	if err != nil {
 		return fmt.Errorf("stat file: %w", err)
 	}
 
-	// Only use parallel processing for large files (>10MB)
+	// An unfiltered LIMIT never needs more than the blocks holding its rows
+	scanEnd := fileInfo.Size()
+	if end, ok := limitScanEnd(query); ok && end < scanEnd {
+		scanEnd = end
+	}
+
+	// Only use parallel processing for large files (>10MB unless set)
 	// Skip for small LIMIT queries (< 10000 rows) where sequential is faster
-	if fileInfo.Size() < 10*1024*1024 {
+	minSize := parallelMinFileSize
+	if query.Resources.ParallelMinSize > 0 {
+		minSize = query.Resources.ParallelMinSize
+	}
+	if scanEnd < minSize {
 		return errSkipParallel // File too small, use sequential
 	}
-	if query.Limit >= 0 && query.Limit < 10000 {
+	if query.Resources.Threads == 1 {
+		return errSkipParallel // One thread asked for is a sequential scan
+	}
+	if query.Limit >= 0 && query.Limit < parallelMinLimit {
 		return errSkipParallel // Small LIMIT, sequential is faster
 	}
 
@@ -57,27 +95,24 @@ func ParallelExecute(query sqlparser.Query, out io.Writer) error {
 		}
 	}()
 
-	// Read header first (sequential)
-	reader := csv.NewReader(bufio.NewReaderSize(file, ioBufferSize))
-	reader.ReuseRecord = true
-	reader.FieldsPerRecord = -1
-
-	headerRecord, err := reader.Read()
+	header, dataStart, err := inputHeader(file, query)
 	if err != nil {
-		return fmt.Errorf("read header: %w", err)
+		return err
 	}
-	header := make([]string, len(headerRecord))
-	copy(header, headerRecord)
+	stats.addRead(dataStart)
 
-	normalizedHeaders := make([]string, len(header))
 	normalisedIndex := make(map[string]int, len(header))
 	for idx, name := range header {
-		normalized := strings.ToLower(strings.TrimSpace(name))
-		normalizedHeaders[idx] = normalized
-		normalisedIndex[normalized] = idx
+		normalisedIndex[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	if resolvePseudoColumns(query, normalisedIndex, len(header)) != nil {
+		return errSkipParallel // Workers don't know the line or offset of their rows
+	}
+	if query.OnError != "" {
+		return errSkipParallel // Malformed rows are reported by line, as above
 	}
 
-	selectedIdxs, outputHeader, err := resolveProjection(query, header, normalisedIndex)
+	proj, outputHeader, err := resolveProjection(query, header, normalisedIndex)
 	if err != nil {
 		return err
 	}
@@ -89,7 +124,7 @@ func ParallelExecute(query sqlparser.Query, out io.Writer) error {
 	}
 
 	// Write header
-	writer := csv.NewWriter(out)
+	writer := NewFastCSVWriterSize(out, query.Resources.IOBufferSize)
 	if err := writer.Write(outputHeader); err != nil {
 		return fmt.Errorf("write header: %w", err)
 	}
@@ -98,182 +133,253 @@ func ParallelExecute(query sqlparser.Query, out io.Writer) error {
 		return fmt.Errorf("flush header: %w", err)
 	}
 
-	// Use all available CPU cores as workers
-	workers := runtime.GOMAXPROCS(0)
-	if workers < 1 {
-		workers = 1
+	// Workers only split the fields the query reads
+	fieldLimit := fieldsNeeded(query.Where, normalisedIndex, proj.reads)
+
+	// Several chunks per worker so one slow chunk doesn't idle the rest
+	workers := parallelWorkers(query.Resources)
+	starts, err := csvio.ChunkStarts(file, dataStart, max(scanEnd, dataStart), workers*4, workers, query.Dialect)
+	if err != nil {
+		return fmt.Errorf("split CSV: %w", err)
 	}
 
-	// Create channels
-	const batchSize = 10000 // Rows per batch
-	batches := make(chan rowBatch, workers*2)
-	results := make(chan batchResult, workers*2)
+	// Every chunk ahead of the writer may hold a batch, and each worker
+	// fills one more
+	var batchBytes int64
+	if query.Resources.MaxMemory > 0 {
+		batchBytes = max(query.Resources.MaxMemory/int64(len(starts)+workers), minBatchBytes)
+	}
 
-	// Start worker goroutines to process batches
+	// Workers take chunks in file order. Each chunk has its own output
+	// channel with room for one batch, so a worker that runs ahead of the
+	// writer stalls instead of buffering its whole chunk. Unordered, every
+	// chunk sends to one channel, and batches are written as they come.
+	unordered := query.Unordered && !query.StableOrder && query.Sample == nil
+	var merged chan chunkBatch
+	if unordered {
+		merged = make(chan chunkBatch, workers)
+	}
+	chunkIDs := make(chan int, len(starts))
+	outputs := make([]chan chunkBatch, len(starts))
+	for i := range starts {
+		chunkIDs <- i
+		outputs[i] = merged
+		if !unordered {
+			outputs[i] = make(chan chunkBatch, 1)
+		}
+	}
+	close(chunkIDs)
+
+	// stop is closed when the writer returns (LIMIT reached or an error) so
+	// workers exit instead of blocking on full channels
+	stop := make(chan struct{})
 	var wg sync.WaitGroup
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	// Each worker compiles its own filter: compiled filters own scratch buffers
 	for w := 0; w < workers; w++ {
+		filter, err := compileVectorFilter(query.Where, normalisedIndex)
+		if err != nil {
+			return err
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			processBatches(batches, results, query, normalizedHeaders, selectedIdxs)
-		}()
-	}
-
-	// Start reader goroutine to read CSV and create batches
-	readErr := make(chan error, 1)
-	go func() {
-		batchID := 0
-		batch := make([][]string, 0, batchSize)
-
-		for {
-			record, err := reader.Read()
-			if err == io.EOF {
-				// Send final batch if any
-				if len(batch) > 0 {
-					batches <- rowBatch{id: batchID, rows: batch}
+			for id := range chunkIDs {
+				end := max(scanEnd, dataStart)
+				if id+1 < len(starts) {
+					end = starts[id+1]
+				}
+				section := csvio.NewDecoder(stats.countReads(csvio.NewRetryReader(io.NewSectionReader(file, starts[id], end-starts[id]), query.FilePath, starts[id])), query.Encoding)
+				reader := newFastReader(section, query)
+				reader.SetFieldLimit(fieldLimit)
+				ok := scanChunk(reader, batchBytes, outputs[id], stop, filter, proj, stats)
+				if !unordered {
+					close(outputs[id])
+				}
+				if !ok {
+					return
 				}
-				close(batches)
-				readErr <- nil
-				return
-			}
-			if err != nil {
-				close(batches)
-				readErr <- fmt.Errorf("read row: %w", err)
-				return
-			}
-
-			// Copy record since reader reuses the slice
-			row := make([]string, len(record))
-			copy(row, record)
-			batch = append(batch, row)
-
-			if len(batch) >= batchSize {
-				batches <- rowBatch{id: batchID, rows: batch}
-				batchID++
-				batch = make([][]string, 0, batchSize)
 			}
-		}
-	}()
-
-	// Close results when all workers done
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+		}()
+	}
+	if unordered {
+		go func() {
+			wg.Wait()
+			close(merged)
+		}()
+	}
 
-	// Collect and write results in order
-	resultMap := make(map[int][][]string)
-	nextID := 0
+	// Write results in file order, so a SAMPLE draws the same rows as a
+	// sequential scan with the same seed. Unordered, the first range over
+	// the shared channel takes every batch.
 	rowCount := 0
-	batchesProcessed := 0
-
-	for res := range results {
-		if res.err != nil {
-			return fmt.Errorf("batch %d: %w", res.id, res.err)
+	emit := func(row []string) error {
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("write row: %w", err)
 		}
-
-		resultMap[res.id] = res.rows
-		batchesProcessed++
-
-		// Write results in order
-		for {
-			rows, ok := resultMap[nextID]
-			if !ok {
-				break
+		rowCount++
+		stats.addReturned(1)
+		if rowCount%defaultFlushEveryN == 0 {
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				return fmt.Errorf("flush rows: %w", err)
+			}
+		}
+		return nil
+	}
+	sample := newSampler(query.Sample)
+	for _, output := range outputs {
+		for batch := range output {
+			if batch.err != nil {
+				return batch.err
 			}
+			start := time.Now()
 
-			for _, row := range rows {
+			for _, row := range batch.rows {
 				// Check LIMIT before writing
 				if query.Limit >= 0 && rowCount >= query.Limit {
 					goto done // Exit both loops
 				}
 
-				if err := writer.Write(row); err != nil {
-					return fmt.Errorf("write row: %w", err)
+				stats.addMatched(1)
+				if sample != nil && !sample.offer(row) {
+					continue
 				}
-				rowCount++
-				if rowCount%defaultFlushEveryN == 0 {
-					writer.Flush()
-					if err := writer.Error(); err != nil {
-						return fmt.Errorf("flush rows: %w", err)
-					}
+				if err := emit(row); err != nil {
+					return err
 				}
 			}
-
-			delete(resultMap, nextID)
-			nextID++
+			stats.addTime(phaseOutput, start)
 		}
 	}
 
-done:
-	// Check for read errors
-	if err := <-readErr; err != nil {
-		return err
+	if sample != nil && sample.reservoir {
+		for _, row := range sample.rows() {
+			if query.Limit >= 0 && rowCount >= query.Limit {
+				break
+			}
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
 	}
 
+done:
 	writer.Flush()
 	if err := writer.Error(); err != nil {
 		return fmt.Errorf("final flush: %w", err)
 	}
 
 	if os.Getenv("SIDX_DEBUG") == "1" {
-		fmt.Fprintf(os.Stderr, "[parallel] Processed %d batches with %d workers, wrote %d rows\n",
-			batchesProcessed, workers, rowCount)
+		fmt.Fprintf(os.Stderr, "[parallel] Processed %d chunks with %d workers, wrote %d rows (ordered=%v)\n",
+			len(starts), workers, rowCount, !unordered)
 	}
 
 	return nil
 }
 
-// processBatches processes row batches from the channel
-func processBatches(
-	batches <-chan rowBatch,
-	results chan<- batchResult,
-	query sqlparser.Query,
-	normalizedHeaders []string,
-	selectedIdxs []int,
-) {
-	// Pre-allocate rowMap for WHERE evaluation
-	var rowMap map[string]string
-	if query.Where != nil {
-		rowMap = make(map[string]string, len(normalizedHeaders))
+// limitScanEnd returns the byte offset where the index blocks covering the
+// first LIMIT rows end, so an unfiltered LIMIT scan can stop there. ok is
+// false if the query filters, samples, or has no LIMIT, or there is no usable
+// index.
+func limitScanEnd(query sqlparser.Query) (int64, bool) {
+	if query.Where != nil || query.Limit < 0 || query.Sample != nil {
+		return 0, false
+	}
+	index, release, err := acquireIndex(query)
+	defer release()
+	if err != nil || index == nil {
+		return 0, false
+	}
+	for _, block := range index.Blocks {
+		if block.EndRow >= uint64(query.Limit) {
+			if os.Getenv("SIDX_DEBUG") == "1" {
+				fmt.Fprintf(os.Stderr, "[sidx] LIMIT %d covered by the first %d bytes\n", query.Limit, block.EndOffset)
+			}
+			return int64(block.EndOffset), true
+		}
 	}
+	return 0, false
+}
 
-	for batch := range batches {
-		var filteredRows [][]string
+// scanChunk parses, filters and projects the records of one chunk, and sends
+// them to output in batches of parallelBatchSize rows, or fewer once their
+// fields add up to batchBytes (when set). It returns false if stop was
+// closed first.
+func scanChunk(
+	reader *FastCSVReader,
+	batchBytes int64,
+	output chan<- chunkBatch,
+	stop <-chan struct{},
+	filter vectorFilter,
+	proj *projection,
+	stats *Stats,
+) bool {
+	var scanned int64
+	defer func() { stats.addScanned(scanned) }()
+	timer := rowTimer{stats: stats}
+
+	send := func(batch chunkBatch) bool {
+		select {
+		case output <- batch:
+			return true
+		case <-stop:
+			return false
+		}
+	}
 
-		for _, record := range batch.rows {
-			// Evaluate WHERE clause if present
-			if query.Where != nil {
-				// Clear and populate rowMap
-				for k := range rowMap {
-					delete(rowMap, k)
-				}
-				for i := range normalizedHeaders {
-					if i < len(record) {
-						rowMap[normalizedHeaders[i]] = record[i]
-					} else {
-						rowMap[normalizedHeaders[i]] = ""
-					}
-				}
-				if !sqlparser.EvaluateNormalized(query.Where, rowMap) {
-					continue
-				}
+	rows := make([][]string, 0, parallelBatchSize)
+	var size int64
+	for {
+		timer.startRow()
+		fields, err := reader.ReadRaw()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			send(chunkBatch{err: fmt.Errorf("read row: %w", err)})
+			return false
+		}
+		timer.mark(phaseParse)
+
+		// A selective filter may go a long way between sends
+		scanned++
+		if scanned%int64(parallelBatchSize) == 0 {
+			select {
+			case <-stop:
+				return false
+			default:
 			}
+		}
 
-			// Project columns
-			row := make([]string, len(selectedIdxs))
-			for i, idx := range selectedIdxs {
-				if idx < len(record) {
-					row[i] = record[idx]
-				}
+		matched := filter == nil || filter.matchRaw(fields)
+		timer.mark(phaseFilter)
+		if !matched {
+			continue
+		}
+		row := proj.projectRaw(fields)
+		rows = append(rows, row)
+		if batchBytes > 0 {
+			for _, field := range row {
+				size += int64(len(field))
 			}
-			filteredRows = append(filteredRows, row)
 		}
-
-		results <- batchResult{
-			id:   batch.id,
-			rows: filteredRows,
-			err:  nil,
+		timer.mark(phaseOutput)
+		if len(rows) >= parallelBatchSize || batchBytes > 0 && size >= batchBytes {
+			if !send(chunkBatch{rows: rows}) {
+				return false
+			}
+			rows = make([][]string, 0, len(rows))
+			size = 0
 		}
 	}
+
+	if len(rows) > 0 {
+		return send(chunkBatch{rows: rows})
+	}
+	return true
 }
@@ -0,0 +1,289 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/melihbirim/sieswi/internal/sidx"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// forceParallel lowers the parallel thresholds so small test files take the
+// parallel path with many batches
+func forceParallel(t *testing.T, batchSize int) {
+	t.Helper()
+	oldSize, oldLimit, oldBatch := parallelMinFileSize, parallelMinLimit, parallelBatchSize
+	parallelMinFileSize, parallelMinLimit, parallelBatchSize = 0, 0, batchSize
+	t.Cleanup(func() {
+		parallelMinFileSize, parallelMinLimit, parallelBatchSize = oldSize, oldLimit, oldBatch
+	})
+}
+
+func TestParallelExecuteMatchesSequential(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,country,amount\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&sb, "%d,%s,%d\n", i, []string{"DE", "FR", "UK", "US"}[i%4], (i*37)%1000)
+	}
+	csvPath := writeTempCSV(t, sb.String())
+
+	queries := []string{
+		"SELECT * FROM '%s'",
+		"SELECT id, amount FROM '%s' WHERE amount > 500 AND country != 'UK'",
+		"SELECT id FROM '%s' WHERE country = 'FR' LIMIT 333",
+		"SELECT * FROM '%s' LIMIT 0",
+		"SELECT * FROM '%s' WHERE country = 'XX'",
+		"SELECT id FROM '%s' WHERE id < 100",
+		"SELECT id FROM '%s' SAMPLE 10%% REPEATABLE (7) WHERE country = 'DE'",
+		"SELECT id FROM '%s' SAMPLE 50 ROWS REPEATABLE (7)",
+	}
+	for _, query := range queries {
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+
+		t.Setenv("SIDX_NO_PARALLEL", "1")
+		var want bytes.Buffer
+		if err := Execute(q, &want); err != nil {
+			t.Fatalf("sequential %q: %v", query, err)
+		}
+
+		forceParallel(t, 7)
+		var got bytes.Buffer
+		if err := ParallelExecute(q, &got); err != nil {
+			t.Fatalf("parallel %q: %v", query, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: parallel output differs from sequential", query)
+		}
+	}
+}
+
+func TestParallelExecuteResources(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,name\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&sb, "%d,name-%d\n", i, i)
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	q, err := sqlparser.Parse(fmt.Sprintf("SELECT * FROM '%s' WHERE id >= 100", csvPath))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	t.Setenv("SIDX_NO_PARALLEL", "1")
+	var want bytes.Buffer
+	if err := Execute(q, &want); err != nil {
+		t.Fatalf("sequential: %v", err)
+	}
+	forceParallel(t, 1000)
+
+	// Tiny buffers and batches still give the sequential result
+	q.Resources = sqlparser.Resources{Threads: 3, IOBufferSize: 16, MaxMemory: 1}
+	var got bytes.Buffer
+	if err := ParallelExecute(q, &got); err != nil {
+		t.Fatalf("parallel: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Error("parallel output with small resources differs from sequential")
+	}
+
+	for _, r := range []sqlparser.Resources{{Threads: 1}, {ParallelMinSize: 1 << 30}} {
+		q.Resources = r
+		if err := ParallelExecute(q, &bytes.Buffer{}); err != errSkipParallel {
+			t.Errorf("%+v: err = %v, want a sequential scan", r, err)
+		}
+	}
+
+	// Batches end once their fields reach batchBytes
+	output := make(chan chunkBatch, 100)
+	reader := NewFastCSVReaderSize(strings.NewReader(strings.Repeat("abcd,efgh\n", 100)), 16)
+	proj, _, err := resolveProjection(sqlparser.Query{AllColumns: true}, []string{"a", "b"}, map[string]int{"a": 0, "b": 1})
+	if err != nil {
+		t.Fatalf("projection: %v", err)
+	}
+	if !scanChunk(reader, 40, output, nil, nil, proj, nil) {
+		t.Fatal("scanChunk stopped")
+	}
+	close(output)
+	batches := 0
+	for batch := range output {
+		batches++
+		if len(batch.rows) != 5 {
+			t.Errorf("batch of %d rows, want 5 rows of 8 bytes", len(batch.rows))
+		}
+	}
+	if batches != 20 {
+		t.Errorf("%d batches, want 20", batches)
+	}
+}
+
+func TestParallelExecuteUnordered(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,country\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&sb, "%d,%s\n", i, []string{"DE", "FR", "UK", "US"}[i%4])
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	forceParallel(t, 7)
+
+	sorted := func(out string) []string {
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		sort.Strings(lines[1:])
+		return lines
+	}
+	for _, query := range []string{
+		"SELECT * FROM '%s' WHERE country != 'UK'",
+		"SELECT id FROM '%s' WHERE country = 'XX'",
+	} {
+		q, err := sqlparser.Parse(fmt.Sprintf(query, csvPath))
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		var want, got bytes.Buffer
+		if err := ParallelExecute(q, &want); err != nil {
+			t.Fatalf("ordered %q: %v", query, err)
+		}
+		q.Unordered = true
+		if SourceOrder(q) {
+			t.Errorf("%s: unordered query reported in source order", query)
+		}
+		if err := ParallelExecute(q, &got); err != nil {
+			t.Fatalf("unordered %q: %v", query, err)
+		}
+		// The same rows, in whatever order batches finished
+		if !reflect.DeepEqual(sorted(got.String()), sorted(want.String())) {
+			t.Errorf("%s: unordered output holds other rows", query)
+		}
+	}
+
+	// LIMIT takes the first rows to arrive
+	q, err := sqlparser.Parse(fmt.Sprintf("SELECT id FROM '%s' LIMIT 100", csvPath))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	q.Unordered = true
+	var out bytes.Buffer
+	if err := ParallelExecute(q, &out); err != nil {
+		t.Fatalf("unordered LIMIT: %v", err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 101 {
+		t.Errorf("unordered LIMIT 100 wrote %d lines", lines)
+	}
+
+	// A seeded sample draws by position, so it stays in order
+	q.Sample = &sqlparser.Sample{Percent: 10, Seed: 7, Repeatable: true}
+	if !SourceOrder(q) {
+		t.Error("unordered SAMPLE reported out of source order")
+	}
+}
+
+// TestParallelExecuteStopsAtLimit checks that stopping early releases the
+// reader and workers instead of deadlocking on full channels
+func TestParallelExecuteStopsAtLimit(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id\n")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&sb, "%d\n", i)
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	forceParallel(t, 10)
+
+	q, err := sqlparser.Parse(fmt.Sprintf("SELECT id FROM '%s' LIMIT 25", csvPath))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	done := make(chan error, 1)
+	var out bytes.Buffer
+	go func() { done <- ParallelExecute(q, &out) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("execute: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("ParallelExecute did not return after reaching LIMIT")
+	}
+
+	if lines := strings.Count(out.String(), "\n"); lines != 26 {
+		t.Errorf("got %d lines, want header + 25 rows", lines)
+	}
+}
+
+// TestParallelExecuteQuotedNewlines checks that chunks split on record
+// boundaries when quoted fields contain newlines
+func TestParallelExecuteQuotedNewlines(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,note\n")
+	for i := 0; i < 3000; i++ {
+		if i%3 == 0 {
+			fmt.Fprintf(&sb, "%d,\"multi\nline \"\"%d\"\"\n\"\n", i, i)
+		} else {
+			fmt.Fprintf(&sb, "%d,plain %d\n", i, i)
+		}
+	}
+	csvPath := writeTempCSV(t, sb.String())
+
+	q, err := sqlparser.Parse(fmt.Sprintf("SELECT note, id FROM '%s' WHERE id >= 100", csvPath))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	t.Setenv("SIDX_NO_PARALLEL", "1")
+	var want bytes.Buffer
+	if err := Execute(q, &want); err != nil {
+		t.Fatalf("sequential: %v", err)
+	}
+
+	forceParallel(t, 50)
+	var got bytes.Buffer
+	if err := ParallelExecute(q, &got); err != nil {
+		t.Fatalf("parallel: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Error("parallel output differs from sequential")
+	}
+}
+
+// TestParallelExecuteLimitUsesIndex checks that an unfiltered LIMIT only
+// scans the index blocks holding its rows
+func TestParallelExecuteLimitUsesIndex(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,name\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&sb, "%d,name %d\n", i, i)
+	}
+	csvPath := writeTempCSV(t, sb.String())
+	writeTestIndex(t, csvPath, 100)
+	defer sidx.DefaultCache.Invalidate(csvPath)
+
+	q, err := sqlparser.Parse(fmt.Sprintf("SELECT name FROM '%s' LIMIT 1050", csvPath))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	end, ok := limitScanEnd(q)
+	if !ok || end >= int64(sb.Len()) {
+		t.Fatalf("limitScanEnd = %d, %v; want an offset before the end of the file", end, ok)
+	}
+
+	t.Setenv("SIDX_NO_PARALLEL", "1")
+	var want bytes.Buffer
+	if err := Execute(q, &want); err != nil {
+		t.Fatalf("sequential: %v", err)
+	}
+
+	forceParallel(t, 10)
+	var got bytes.Buffer
+	if err := ParallelExecute(q, &got); err != nil {
+		t.Fatalf("parallel: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Error("parallel output differs from sequential")
+	}
+}
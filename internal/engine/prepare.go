@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// Prepared is a parsed and checked query that can be executed repeatedly,
+// against its own file or others with the same columns. It is immutable and
+// safe for concurrent use.
+//
+// Preparing only saves parsing and the checks that don't need a file. It
+// holds nothing else: each execution reads the header, resolves column
+// positions, compiles the filter, and picks the execution path as
+// ExecuteWithStats does for an unprepared query, and reruns subqueries,
+// since their files may have changed.
+type Prepared struct {
+	query sqlparser.Query
+}
+
+// Prepare parses sql and rejects queries that can't run whatever the file
+func Prepare(sql string) (*Prepared, error) {
+	query, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	return PrepareQuery(query)
+}
+
+// PrepareQuery is Prepare for a query already parsed, and possibly adjusted
+// (number format, case folding, header options)
+func PrepareQuery(query sqlparser.Query) (*Prepared, error) {
+	if query.CreateTable {
+		return nil, fmt.Errorf("CREATE TABLE can't be prepared; prepare its SELECT and write the result")
+	}
+	if err := checkQuery(query); err != nil {
+		return nil, err
+	}
+	return &Prepared{query: query}, nil
+}
+
+// Query returns the prepared query, whose slices must not be modified
+func (p *Prepared) Query() sqlparser.Query {
+	return p.query
+}
+
+// Execute runs the query against the file named in its FROM clause
+func (p *Prepared) Execute(out io.Writer) error {
+	return ExecuteWithStats(p.query, out, nil)
+}
+
+// ExecuteFile runs the query against path ("-" for stdin) instead of the
+// file named in its FROM clause
+func (p *Prepared) ExecuteFile(path string, out io.Writer, stats *Stats) error {
+	query := p.query
+	query.FilePath = path
+	return ExecuteWithStats(query, out, stats)
+}
+
+// normalizeQuery gives the conditions of a query built by hand, which may
+// hold pointers to nodes (&sqlparser.BinaryExpr{...}), the form Parse builds
+func normalizeQuery(query *sqlparser.Query) {
+	query.Where = sqlparser.Normalize(query.Where)
+	query.Having = sqlparser.Normalize(query.Having)
+	if query.Filters != nil {
+		filters := make([]sqlparser.Expression, len(query.Filters))
+		for i, f := range query.Filters {
+			filters[i] = sqlparser.Normalize(f)
+		}
+		query.Filters = filters
+	}
+}
+
+// checkQuery rejects combinations no execution path supports, before any
+// file is opened
+func checkQuery(query sqlparser.Query) error {
+	// Rows are decoded before they're parsed, so their offsets in the file
+	// are lost
+	if query.Encoding != csvio.EncodingUTF8 && usesColumn(query, offsetColumnName) {
+		return fmt.Errorf("%s can't be used with --encoding %s", offsetColumnName, query.Encoding)
+	}
+	if query.Top != nil && isAggregateQuery(query) {
+		return fmt.Errorf("QUALIFY can't be combined with aggregates")
+	}
+	if (query.Having != nil || query.OrderBy != nil) && !isAggregateQuery(query) {
+		return fmt.Errorf("HAVING and ORDER BY are only supported with GROUP BY or aggregates")
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/sidx"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// Pseudo-columns a query can select, filter, group, and aggregate on as if
+// the file had them. A real column of the same name takes precedence.
+const (
+	// lineColumnName is each row's line number: the 1-based position of its
+	// record in the file, counting the header row. A record with quoted line
+	// breaks still counts as one line.
+	lineColumnName = "_line"
+	// fileColumnName is the path of the file the row was read from
+	fileColumnName = "_file"
+	// offsetColumnName is the byte offset where the row's record starts
+	offsetColumnName = "_offset"
+)
+
+// pseudoColumns is where a scan stores the pseudo-columns a query uses, in
+// positions past the file's real columns; -1 marks the ones it doesn't use
+type pseudoColumns struct {
+	width              int
+	n                  int // Pseudo-columns in use
+	line, file, offset int
+	path               string
+	rawPath            []byte
+	lineBuf, offBuf    []byte
+}
+
+// resolvePseudoColumns adds the pseudo-columns the query uses to a
+// normalized header index of width columns. It returns nil when rows don't
+// need any, so scans only pay for them when asked.
+func resolvePseudoColumns(query sqlparser.Query, index map[string]int, width int) *pseudoColumns {
+	p := &pseudoColumns{width: width, line: -1, file: -1, offset: -1, path: query.FilePath}
+	next := width
+	for _, c := range []struct {
+		name string
+		pos  *int
+	}{{lineColumnName, &p.line}, {fileColumnName, &p.file}, {offsetColumnName, &p.offset}} {
+		if _, real := index[c.name]; real || !usesColumn(query, c.name) {
+			continue
+		}
+		index[c.name] = next
+		*c.pos = next
+		next++
+	}
+	if p.n = next - width; p.n == 0 {
+		return nil
+	}
+	p.rawPath = []byte(p.path)
+	return p
+}
+
+// usesColumn reports whether the query selects, filters, groups, or
+// aggregates on the named column
+func usesColumn(query sqlparser.Query, name string) bool {
+	for i, col := range query.Columns {
+		if i < len(query.Exprs) && query.Exprs[i] != nil && scalarUsesColumn(query.Exprs[i], name) {
+			return true
+		}
+		if agg, ok := parseAggregateFunc(col); ok {
+			col = agg.Column
+		}
+		if isColumn(col, name) {
+			return true
+		}
+	}
+	for _, col := range query.GroupBy {
+		if isColumn(col, name) {
+			return true
+		}
+	}
+	if query.Top != nil {
+		for _, col := range query.Top.PartitionBy {
+			if isColumn(col, name) {
+				return true
+			}
+		}
+		if scalarUsesColumn(query.Top.OrderBy, name) {
+			return true
+		}
+	}
+	for _, filter := range query.Filters {
+		if exprUsesColumn(filter, name) {
+			return true
+		}
+	}
+	// HAVING and ORDER BY read input columns through aggregates only
+	refs := sqlparser.ExpressionColumns(query.Having)
+	for _, item := range query.OrderBy {
+		refs = append(refs, sqlparser.ScalarColumns(item.Expr)...)
+	}
+	for _, ref := range refs {
+		if agg, ok := parseAggregateFunc(ref); ok && isColumn(agg.Column, name) {
+			return true
+		}
+	}
+	return exprUsesColumn(query.Where, name)
+}
+
+func exprUsesColumn(expr sqlparser.Expression, name string) bool {
+	return expr != nil && sqlparser.VisitExpression[bool](expr, columnUser{name: name})
+}
+
+// columnUser reports whether an expression reads a column
+type columnUser struct {
+	name string
+}
+
+func (u columnUser) Binary(e sqlparser.BinaryExpr) bool {
+	return exprUsesColumn(e.Left, u.name) || exprUsesColumn(e.Right, u.name)
+}
+
+func (u columnUser) Unary(e sqlparser.UnaryExpr) bool { return exprUsesColumn(e.Expr, u.name) }
+
+func (u columnUser) Comparison(e sqlparser.Comparison) bool { return isColumn(e.Column, u.name) }
+
+func (u columnUser) ColumnComparison(e sqlparser.ColumnComparison) bool {
+	return isColumn(e.Left, u.name) || isColumn(e.Right, u.name)
+}
+
+func (u columnUser) ExprComparison(e sqlparser.ExprComparison) bool {
+	return scalarUsesColumn(e.Left, u.name) || scalarUsesColumn(e.Right, u.name)
+}
+
+func (u columnUser) In(e sqlparser.InExpr) bool { return isColumn(e.Column, u.name) }
+
+func scalarUsesColumn(s sqlparser.Scalar, name string) bool {
+	for _, col := range sqlparser.ScalarColumns(s) {
+		if isColumn(col, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isColumn(col, name string) bool {
+	return strings.EqualFold(strings.TrimSpace(col), name)
+}
+
+// headerLines is the number of lines before the first data row
+func headerLines(noHeader bool) int64 {
+	if noHeader {
+		return 0
+	}
+	return 1
+}
+
+// add returns record with the pseudo-columns of the row on the given line,
+// starting at the given byte offset. Rows shorter than the header are padded
+// with empty fields.
+func (p *pseudoColumns) add(record []string, line, offset int64) []string {
+	for len(record) < p.width {
+		record = append(record, "")
+	}
+	record = record[:p.width]
+	for pos := p.width; pos < p.width+p.n; pos++ {
+		switch pos {
+		case p.line:
+			record = append(record, strconv.FormatInt(line, 10))
+		case p.file:
+			record = append(record, p.path)
+		case p.offset:
+			record = append(record, strconv.FormatInt(offset, 10))
+		}
+	}
+	return record
+}
+
+// addRaw is add for raw fields; the values are only valid until the next call
+func (p *pseudoColumns) addRaw(fields [][]byte, line, offset int64) [][]byte {
+	for len(fields) < p.width {
+		fields = append(fields, nil)
+	}
+	fields = fields[:p.width]
+	for pos := p.width; pos < p.width+p.n; pos++ {
+		switch pos {
+		case p.line:
+			p.lineBuf = strconv.AppendInt(p.lineBuf[:0], line, 10)
+			fields = append(fields, p.lineBuf)
+		case p.file:
+			fields = append(fields, p.rawPath)
+		case p.offset:
+			p.offBuf = strconv.AppendInt(p.offBuf[:0], offset, 10)
+			fields = append(fields, p.offBuf)
+		}
+	}
+	return fields
+}
+
+// canPruneLines decides a comparison on _line from the block's row range,
+// when the index covers every column so the file can't have a real _line
+func canPruneLines(index *sidx.Index, block *sidx.BlockMeta, c sqlparser.Comparison, negated bool) (prune, ok bool) {
+	if !isColumn(c.Column, lineColumnName) || index.Header.BuildFlags&sidx.BuildFlagPartialColumns != 0 {
+		return false, false
+	}
+	for _, col := range index.Header.Columns {
+		if isColumn(col.Name, lineColumnName) {
+			return false, false
+		}
+	}
+	if !c.IsNumeric || c.Retyped() {
+		return false, true
+	}
+	first := uint64(headerLines(index.Header.BuildFlags&sidx.BuildFlagNoHeader != 0)) + 1
+	return sidx.CanPruneBlockRows(block, first, c.Operator, c.NumericValue, negated), true
+}
@@ -0,0 +1,199 @@
+package engine
+
+import "sort"
+
+// rerankRows is how many rows a chain tests between reorderings of its terms
+const rerankRows = 4096
+
+// filterChain is a flattened run of ANDs or ORs. Its terms are evaluated in
+// order of expected work per row decided: a cheap comparison that rejects
+// most rows runs first in an AND, one that accepts most rows first in an OR.
+// Selectivity is measured on the rows the chain sees, so the order adapts to
+// the data; until then it follows a static cost estimate, and ties keep the
+// order the query was written in.
+type filterChain struct {
+	and     bool
+	terms   []chainTerm
+	pending int // Rows tested since the last reordering
+
+	// Double buffers for OR, which reads one selection while building the next
+	matched, rest [2][]int32
+}
+
+// chainTerm is one operand of a chain with the counts that rank it
+type chainTerm struct {
+	f      vectorFilter
+	cost   float64 // Static estimate of the work per row
+	tested int64   // Rows the term was evaluated on
+	passed int64   // Of those, the rows it matched
+}
+
+// newFilterChain joins left and right, absorbing chains of the same kind so
+// a AND b AND c is one chain of three terms
+func newFilterChain(and bool, left, right vectorFilter) *filterChain {
+	c := &filterChain{and: and}
+	for _, f := range []vectorFilter{left, right} {
+		if sub, ok := f.(*filterChain); ok && sub.and == and {
+			c.terms = append(c.terms, sub.terms...)
+		} else {
+			c.terms = append(c.terms, chainTerm{f: f, cost: filterCost(f)})
+		}
+	}
+	c.reorder()
+	return c
+}
+
+// filterCost estimates the relative work of evaluating f on one row
+func filterCost(f vectorFilter) float64 {
+	switch f := f.(type) {
+	case *compareFilter:
+		switch {
+		case f.slow:
+			return 3
+		case f.cmp.CaseInsensitive || f.cmp.Operator == "ILIKE":
+			return 2
+		}
+		return 1
+	case *columnCompareFilter, *inFilter:
+		return 2
+	case *exprCompareFilter:
+		return 4
+	case *notFilter:
+		return filterCost(f.inner)
+	case *cachedFilter:
+		// Often already evaluated for the row by another reference
+		return filterCost(f.results.inner) / 2
+	case *filterChain:
+		cost := 0.0
+		for _, t := range f.terms {
+			cost += t.cost
+		}
+		return cost
+	}
+	return 1
+}
+
+// rank is the expected cost of the term per row it decides: for an AND the
+// rows it rejects, for an OR the rows it accepts. The pass rate is smoothed
+// so an untested term counts as passing half its rows.
+func (t *chainTerm) rank(and bool) float64 {
+	pass := (float64(t.passed) + 1) / (float64(t.tested) + 2)
+	decided := pass
+	if and {
+		decided = 1 - pass
+	}
+	return t.cost / max(decided, 1e-3)
+}
+
+// reorder sorts the terms by rank. Counts are halved once large, so the
+// order follows changes in the data rather than its whole history.
+func (c *filterChain) reorder() {
+	for i := range c.terms {
+		if t := &c.terms[i]; t.tested > 1<<20 {
+			t.tested /= 2
+			t.passed /= 2
+		}
+	}
+	sort.SliceStable(c.terms, func(i, j int) bool {
+		return c.terms[i].rank(c.and) < c.terms[j].rank(c.and)
+	})
+	c.pending = 0
+}
+
+// count adds rows tested towards the next reordering
+func (c *filterChain) count(rows int) {
+	if c.pending += rows; c.pending >= rerankRows {
+		c.reorder()
+	}
+}
+
+func (c *filterChain) filter(b *columnBatch, sel []int32) []int32 {
+	rows := len(sel)
+	defer c.count(rows)
+	if c.and {
+		// Each term only sees the rows the ones before it kept
+		for i := range c.terms {
+			if len(sel) == 0 {
+				break
+			}
+			t := &c.terms[i]
+			t.tested += int64(len(sel))
+			sel = t.f.filter(b, sel)
+			t.passed += int64(len(sel))
+		}
+		return sel
+	}
+
+	// Each term only sees the rows the ones before it rejected
+	var matched []int32
+	rest := sel
+	next := 0 // The buffer pair to write
+	for i := range c.terms {
+		if len(rest) == 0 {
+			break
+		}
+		t := &c.terms[i]
+		t.tested += int64(len(rest))
+		hits := t.f.filter(b, rest)
+		t.passed += int64(len(hits))
+		if len(hits) == 0 {
+			continue
+		}
+		c.matched[next] = merge(c.matched[next][:0], matched, hits)
+		matched = c.matched[next]
+		c.rest[next] = difference(c.rest[next][:0], rest, hits)
+		rest = c.rest[next]
+		next = 1 - next
+	}
+	return matched
+}
+
+func (c *filterChain) match(row []string) bool {
+	defer c.count(1)
+	for i := range c.terms {
+		t := &c.terms[i]
+		t.tested++
+		if t.f.match(row) {
+			t.passed++
+			if !c.and {
+				return true
+			}
+		} else if c.and {
+			return false
+		}
+	}
+	return c.and
+}
+
+func (c *filterChain) matchRaw(fields [][]byte) bool {
+	defer c.count(1)
+	for i := range c.terms {
+		t := &c.terms[i]
+		t.tested++
+		if t.f.matchRaw(fields) {
+			t.passed++
+			if !c.and {
+				return true
+			}
+		} else if c.and {
+			return false
+		}
+	}
+	return c.and
+}
+
+// merge appends to dst the union of two disjoint ascending selections
+func merge(dst, a, b []int32) []int32 {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] < b[j] {
+			dst = append(dst, a[i])
+			i++
+		} else {
+			dst = append(dst, b[j])
+			j++
+		}
+	}
+	dst = append(dst, a[i:]...)
+	return append(dst, b[j:]...)
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// sampler draws a query's SAMPLE from the stream of rows matching WHERE, in
+// one pass: Bernoulli samples decide each row as it arrives, and reservoir
+// samples (Algorithm R) hold at most Rows rows until the scan ends.
+type sampler struct {
+	rng       *rand.Rand
+	percent   float64
+	size      int
+	reservoir bool
+	seen      int64
+	kept      []sampledRow
+}
+
+// sampledRow is a reservoir row with its position among the offered rows,
+// so the sample can be returned in file order
+type sampledRow struct {
+	seq int64
+	row []string
+}
+
+// newSampler returns nil when the query has no SAMPLE clause
+func newSampler(s *sqlparser.Sample) *sampler {
+	if s == nil {
+		return nil
+	}
+	seed := time.Now().UnixNano()
+	if s.Repeatable {
+		seed = s.Seed
+	}
+	return &sampler{
+		rng:       rand.New(rand.NewSource(seed)),
+		percent:   s.Percent,
+		size:      s.Rows,
+		reservoir: s.Reservoir,
+	}
+}
+
+// offer passes the next matching row to the sample. Bernoulli samples report
+// whether to emit it now. Reservoir samples may keep the row (which must not
+// be reused by the caller) and always report false; rows() returns them.
+func (s *sampler) offer(row []string) bool {
+	if !s.reservoir {
+		return s.rng.Float64()*100 < s.percent
+	}
+
+	s.seen++
+	if len(s.kept) < s.size {
+		s.kept = append(s.kept, sampledRow{seq: s.seen, row: row})
+		return false
+	}
+	// Replace a kept row with probability size/seen
+	if j := s.rng.Int63n(s.seen); j < int64(s.size) {
+		s.kept[j] = sampledRow{seq: s.seen, row: row}
+	}
+	return false
+}
+
+// rows returns a reservoir sample in file order
+func (s *sampler) rows() [][]string {
+	sort.Slice(s.kept, func(i, j int) bool { return s.kept[i].seq < s.kept[j].seq })
+	rows := make([][]string, len(s.kept))
+	for i, k := range s.kept {
+		rows[i] = k.row
+	}
+	return rows
+}
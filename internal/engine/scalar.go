@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// boundScalar is a sqlparser.Scalar whose columns are resolved to record
+// positions, so evaluating it on a row does no name lookups
+type boundScalar interface {
+	eval(row []string, f datatype.NumberFormat) sqlparser.Value
+}
+
+type boundColumn struct {
+	col int
+}
+
+type boundLiteral struct {
+	value sqlparser.Value
+}
+
+type boundArith struct {
+	left, right boundScalar
+	op          string
+}
+
+type boundFunc struct {
+	name string
+	args []boundScalar
+}
+
+func (s boundColumn) eval(row []string, _ datatype.NumberFormat) sqlparser.Value {
+	if s.col >= len(row) {
+		return sqlparser.Value{Null: true}
+	}
+	return sqlparser.Value{Text: row[s.col]}
+}
+
+func (s boundLiteral) eval([]string, datatype.NumberFormat) sqlparser.Value {
+	return s.value
+}
+
+func (s boundArith) eval(row []string, f datatype.NumberFormat) sqlparser.Value {
+	return sqlparser.ApplyArithmetic(s.op, s.left.eval(row, f), s.right.eval(row, f), f)
+}
+
+func (s boundFunc) eval(row []string, f datatype.NumberFormat) sqlparser.Value {
+	var buf [2]sqlparser.Value
+	args := buf[:0]
+	for _, arg := range s.args {
+		args = append(args, arg.eval(row, f))
+	}
+	return sqlparser.ApplyFunction(s.name, args, f)
+}
+
+// bindScalar resolves the columns of s against the normalized header index
+func bindScalar(s sqlparser.Scalar, index map[string]int) (boundScalar, error) {
+	switch e := s.(type) {
+	case sqlparser.ColumnRef:
+		col, ok := index[strings.ToLower(strings.TrimSpace(e.Name))]
+		if !ok {
+			return nil, fmt.Errorf("column %q not found in CSV header", e.Name)
+		}
+		return boundColumn{col: col}, nil
+	case sqlparser.Literal:
+		return boundLiteral{value: sqlparser.LiteralValue(e)}, nil
+	case sqlparser.Arithmetic:
+		left, err := bindScalar(e.Left, index)
+		if err != nil {
+			return nil, err
+		}
+		right, err := bindScalar(e.Right, index)
+		if err != nil {
+			return nil, err
+		}
+		return boundArith{left: left, right: right, op: e.Operator}, nil
+	case sqlparser.FuncCall:
+		args := make([]boundScalar, len(e.Args))
+		for i, arg := range e.Args {
+			var err error
+			if args[i], err = bindScalar(arg, index); err != nil {
+				return nil, err
+			}
+		}
+		return boundFunc{name: e.Name, args: args}, nil
+	}
+	return nil, fmt.Errorf("unsupported expression %T", s)
+}
+
+// scalarFields returns the record positions s reads, and one past the
+// highest of them
+func scalarFields(s sqlparser.Scalar, index map[string]int) ([]int, int) {
+	var cols []int
+	n := 0
+	for _, name := range sqlparser.ScalarColumns(s) {
+		if idx, ok := index[strings.ToLower(strings.TrimSpace(name))]; ok {
+			cols = append(cols, idx)
+			n = max(n, idx+1)
+		}
+	}
+	return cols, n
+}
+
+// rawRow converts the fields at cols, which are all below width, to strings
+// in row (reallocated if too small) and returns it cut to the fields
+// present. Other positions hold stale values, so only cols may be read.
+func rawRow(row []string, fields [][]byte, cols []int, width int) []string {
+	if cap(row) < width {
+		row = make([]string, width)
+	}
+	row = row[:width]
+	for _, col := range cols {
+		if col < len(fields) {
+			row[col] = string(fields[col])
+		}
+	}
+	return row[:min(width, len(fields))]
+}
+
+// exprCompareFilter evaluates an ExprComparison on each row
+type exprCompareFilter struct {
+	left, right boundScalar
+	cmp         sqlparser.ExprComparison
+	cols        []int    // Record positions the two sides read
+	width       int      // One past the highest of cols
+	scratch     []string // Fields converted for matchRaw
+	out         []int32
+}
+
+func newExprCompareFilter(e sqlparser.ExprComparison, index map[string]int) (*exprCompareFilter, error) {
+	left, err := bindScalar(e.Left, index)
+	if err != nil {
+		return nil, err
+	}
+	right, err := bindScalar(e.Right, index)
+	if err != nil {
+		return nil, err
+	}
+	f := &exprCompareFilter{left: left, right: right, cmp: e}
+	leftCols, leftWidth := scalarFields(e.Left, index)
+	rightCols, rightWidth := scalarFields(e.Right, index)
+	f.cols = append(leftCols, rightCols...)
+	f.width = max(leftWidth, rightWidth)
+	return f, nil
+}
+
+func (f *exprCompareFilter) filter(b *columnBatch, sel []int32) []int32 {
+	out := f.out[:0]
+	for _, i := range sel {
+		if f.match(b.rows[i]) {
+			out = append(out, i)
+		}
+	}
+	f.out = out
+	return out
+}
+
+func (f *exprCompareFilter) match(row []string) bool {
+	return f.cmp.Compare(f.left.eval(row, f.cmp.Numbers), f.right.eval(row, f.cmp.Numbers))
+}
+
+// matchRaw converts only the fields the comparison reads
+func (f *exprCompareFilter) matchRaw(fields [][]byte) bool {
+	f.scratch = rawRow(f.scratch, fields, f.cols, f.width)
+	return f.match(f.scratch)
+}
@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats collects counters while a query runs. Counters are updated
+// atomically, so another goroutine (such as a progress bar) may read them
+// while the query executes. A nil *Stats collects nothing.
+//
+// Phase times are CPU time summed across parallel workers. Parsing and
+// filtering are timed on every phaseSampleEvery-th row and scaled up, which
+// keeps clock reads off the hot path.
+type Stats struct {
+	BytesRead    atomic.Int64 // Input bytes read
+	BytesSkipped atomic.Int64 // Input bytes skipped by index pruning without reading
+	RowsScanned  atomic.Int64 // Data rows parsed
+	RowsMatched  atomic.Int64 // Rows that passed WHERE
+	RowsReturned atomic.Int64 // Result rows written, excluding the header
+	RowsRejected atomic.Int64 // Malformed rows dropped by --on-error skip or log
+	BlocksTotal  atomic.Int64 // Index blocks considered (0 without an index)
+	BlocksPruned atomic.Int64 // Index blocks answered or skipped without reading
+
+	ParseNanos  atomic.Int64 // Time spent splitting records into fields
+	FilterNanos atomic.Int64 // Time spent evaluating WHERE
+	OutputNanos atomic.Int64 // Time spent projecting and writing results
+}
+
+// BytesDone returns the input bytes read or skipped so far.
+func (s *Stats) BytesDone() int64 {
+	return s.BytesRead.Load() + s.BytesSkipped.Load()
+}
+
+func (s *Stats) addRead(n int64) {
+	if s != nil {
+		s.BytesRead.Add(n)
+	}
+}
+
+func (s *Stats) addSkipped(n int64) {
+	if s != nil {
+		s.BytesSkipped.Add(n)
+	}
+}
+
+func (s *Stats) addMatched(n int64) {
+	if s != nil {
+		s.RowsMatched.Add(n)
+	}
+}
+
+func (s *Stats) addScanned(n int64) {
+	if s != nil {
+		s.RowsScanned.Add(n)
+	}
+}
+
+func (s *Stats) addReturned(n int64) {
+	if s != nil {
+		s.RowsReturned.Add(n)
+	}
+}
+
+func (s *Stats) addRejected(n int64) {
+	if s != nil {
+		s.RowsRejected.Add(n)
+	}
+}
+
+func (s *Stats) addBlocks(total, pruned int) {
+	if s != nil {
+		s.BlocksTotal.Add(int64(total))
+		s.BlocksPruned.Add(int64(pruned))
+	}
+}
+
+// addTime adds the time since start to a phase counter
+func (s *Stats) addTime(p phase, start time.Time) {
+	if s != nil {
+		s.phaseCounter(p).Add(int64(time.Since(start)))
+	}
+}
+
+// phase identifies a timed part of query execution
+type phase int
+
+const (
+	phaseParse phase = iota
+	phaseFilter
+	phaseOutput
+)
+
+func (s *Stats) phaseCounter(p phase) *atomic.Int64 {
+	switch p {
+	case phaseParse:
+		return &s.ParseNanos
+	case phaseFilter:
+		return &s.FilterNanos
+	default:
+		return &s.OutputNanos
+	}
+}
+
+// phaseSampleEvery is how often (in rows) a rowTimer reads the clock
+const phaseSampleEvery = 64
+
+// rowTimer times the phases of row-at-a-time loops on sampled rows. Each
+// goroutine needs its own; a nil rowTimer times nothing.
+type rowTimer struct {
+	stats    *Stats
+	rows     int
+	sampling bool
+	last     time.Time
+}
+
+// startRow begins a row; only sampled rows are timed
+func (t *rowTimer) startRow() {
+	if t == nil || t.stats == nil {
+		return
+	}
+	t.rows++
+	t.sampling = t.rows%phaseSampleEvery == 0
+	if t.sampling {
+		t.last = time.Now()
+	}
+}
+
+// mark attributes the time since the previous mark (or startRow) to p
+func (t *rowTimer) mark(p phase) {
+	if t == nil || !t.sampling {
+		return
+	}
+	now := time.Now()
+	// Each interval includes one clock read, which can be as slow as the
+	// work being timed; leave it out so sampling doesn't inflate the phases
+	if d := now.Sub(t.last) - clockReadCost(); d > 0 {
+		t.stats.phaseCounter(p).Add(int64(d) * phaseSampleEvery)
+	}
+	t.last = now
+}
+
+var (
+	clockCostOnce sync.Once
+	clockCost     time.Duration
+)
+
+// clockReadCost estimates the duration of one time.Now call
+func clockReadCost() time.Duration {
+	clockCostOnce.Do(func() {
+		const reads = 1000
+		start := time.Now()
+		for i := 0; i < reads; i++ {
+			time.Now()
+		}
+		clockCost = time.Since(start) / reads
+	})
+	return clockCost
+}
+
+// countReads wraps r so the bytes read through it count toward BytesRead
+func (s *Stats) countReads(r io.Reader) io.Reader {
+	if s == nil {
+		return r
+	}
+	return &countingReader{r: r, stats: s}
+}
+
+type countingReader struct {
+	r     io.Reader
+	stats *Stats
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.stats.BytesRead.Add(int64(n))
+	return n, err
+}
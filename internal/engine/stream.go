@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// isStream reports whether path can only be read once, front to back:
+// stdin, or a file that isn't regular, such as a named pipe or the
+// /dev/fd/N of a shell's process substitution. Streams are never stat'ed
+// for a size, split for parallel reads, or paired with an index.
+func isStream(path string) bool {
+	if path == "-" || path == "stdin" {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && !info.Mode().IsRegular()
+}
+
+// openStream returns a reader over the query's stream and a func that
+// closes it. What the query leaves open of the input's dialect and header
+// mode is sniffed from the first read and settled in query.
+func openStream(query *sqlparser.Query, stats *Stats) (*csvio.Reader, func(), error) {
+	var src io.Reader
+	closeStream := func() {}
+	if query.FilePath == "-" || query.FilePath == "stdin" {
+		src = csvio.NewRetryReader(os.Stdin, "stdin", 0)
+	} else {
+		// Opening a named pipe waits for its writer, as reading it would anyway
+		file, err := os.Open(query.FilePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open CSV: %w", err)
+		}
+		src, closeStream = csvio.NewRetryReader(file, query.FilePath, 0), func() { file.Close() }
+	}
+	text := bufio.NewReaderSize(csvio.NewDecoder(stats.countReads(src), query.Encoding), csvio.SniffSize)
+	sniffStream(query, text)
+	return newCSVReader(text, *query), closeStream, nil
+}
+
+// streamHeader reads the header of a stream. Without one, the stream can't
+// be reread to name the columns, so the first record is returned to be
+// processed as data.
+func streamHeader(query sqlparser.Query, reader *csvio.Reader) (header, first []string, err error) {
+	record, err := reader.Read()
+	switch {
+	case query.NoHeader && err == io.EOF && len(query.HeaderNames) > 0:
+		return query.HeaderNames, nil, nil
+	case err != nil:
+		return nil, nil, fmt.Errorf("read header: %w", err)
+	case query.NoHeader:
+		first = append([]string(nil), record...)
+		return csvio.ColumnNames(query.HeaderNames, len(first)), first, nil
+	}
+	return append([]string(nil), record...), nil, nil
+}
@@ -0,0 +1,64 @@
+//go:build unix
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// TestExecuteNamedPipe runs queries over a FIFO, which can't be sized,
+// split, or seeked, so every kind of query must read it as a stream
+func TestExecuteNamedPipe(t *testing.T) {
+	var data strings.Builder
+	data.WriteString("id,country,total\n")
+	for i := 1; i <= 3000; i++ {
+		fmt.Fprintf(&data, "%d,%s,%d\n", i, []string{"UK", "US", "DE"}[i%3], i%10)
+	}
+	path := filepath.Join(t.TempDir(), "pipe.csv")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	for _, tt := range []struct {
+		sql      string
+		noHeader bool
+		want     string
+	}{
+		{sql: "SELECT id FROM '%s' WHERE total = 9 AND country = 'UK' LIMIT 2", want: "id\n9\n39\n"},
+		{sql: "SELECT country, COUNT(*), SUM(total) FROM '%s' GROUP BY country", want: "country,COUNT(*),SUM(total)\nUS,1000,4500\nDE,1000,4500\nUK,1000,4500\n"},
+		{sql: "SELECT COUNT(*) FROM '%s'", noHeader: true, want: "COUNT(*)\n3001\n"},
+		{sql: "SELECT c1, c2 FROM '%s' LIMIT 2", noHeader: true, want: "c1,c2\nid,country\n1,US\n"},
+		{sql: "SELECT id FROM '%s' QUALIFY ROW_NUMBER() OVER (PARTITION BY country ORDER BY id DESC) <= 1", want: "id\n2998\n2999\n3000\n"},
+	} {
+		sql := fmt.Sprintf(tt.sql, path)
+		query, err := sqlparser.Parse(sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		query.NoHeader = tt.noHeader
+
+		// The writer blocks until the query opens the pipe, and stops
+		// early if it closes it after LIMIT
+		go func() {
+			if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+				f.WriteString(data.String())
+				f.Close()
+			}
+		}()
+		var out bytes.Buffer
+		if err := Execute(query, &out); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", sql, out.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// filterCompiler compiles a WHERE clause, compiling a subexpression that
+// appears more than once (common in generated queries) only once, so each
+// row evaluates it once
+type filterCompiler struct {
+	index  map[string]int
+	counts map[string]int            // Occurrences of each canonical subexpression
+	shared map[string]*sharedResults // Compiled repeated subexpressions
+	cache  *filterCache              // Set once a subexpression is shared
+}
+
+func newFilterCompiler(expr sqlparser.Expression, index map[string]int) *filterCompiler {
+	c := &filterCompiler{index: index, counts: make(map[string]int), shared: make(map[string]*sharedResults)}
+	subexprKeyer{counts: c.counts}.key(expr)
+	return c
+}
+
+// compile compiles expr, sharing the filter for it with its other
+// occurrences if it repeats
+func (c *filterCompiler) compile(expr sqlparser.Expression) (vectorFilter, error) {
+	key := canonicalKey(expr)
+	if c.counts[key] < 2 {
+		return c.compileNode(expr)
+	}
+	results, ok := c.shared[key]
+	if !ok {
+		inner, err := c.compileNode(expr)
+		if err != nil {
+			return nil, err
+		}
+		if c.cache == nil {
+			c.cache = &filterCache{}
+		}
+		results = &sharedResults{inner: inner, cache: c.cache}
+		c.shared[key] = results
+	}
+	return &cachedFilter{results: results}, nil
+}
+
+// root wraps the compiled clause so each evaluation starts a new cache epoch
+func (c *filterCompiler) root(f vectorFilter) vectorFilter {
+	if c.cache == nil {
+		return f
+	}
+	return &epochFilter{inner: f, cache: c.cache}
+}
+
+// canonicalExpr rewrites expr so equivalent spellings compare equal: column
+// names are normalized like the header index, and a column comparison puts
+// its columns in name order (b < a is a > b)
+func canonicalExpr(expr sqlparser.Expression) sqlparser.Expression {
+	return sqlparser.VisitExpression[sqlparser.Expression](expr, canonicalizer{})
+}
+
+// canonicalizer rewrites one node for canonicalExpr, leaving its operands as
+// they are
+type canonicalizer struct{}
+
+func canonicalName(name string) string { return strings.ToLower(strings.TrimSpace(name)) }
+
+func (canonicalizer) Binary(e sqlparser.BinaryExpr) sqlparser.Expression { return e }
+func (canonicalizer) Unary(e sqlparser.UnaryExpr) sqlparser.Expression   { return e }
+
+func (canonicalizer) Comparison(e sqlparser.Comparison) sqlparser.Expression {
+	e.Column = canonicalName(e.Column)
+	return e
+}
+
+func (canonicalizer) ColumnComparison(e sqlparser.ColumnComparison) sqlparser.Expression {
+	e.Left, e.Right = canonicalName(e.Left), canonicalName(e.Right)
+	if e.Left > e.Right {
+		e.Left, e.Right = e.Right, e.Left
+		e.Operator = flippedOperator(e.Operator)
+	}
+	return e
+}
+
+func (canonicalizer) ExprComparison(e sqlparser.ExprComparison) sqlparser.Expression { return e }
+
+func (canonicalizer) In(e sqlparser.InExpr) sqlparser.Expression {
+	e.Column = canonicalName(e.Column)
+	return e
+}
+
+// flippedOperator is the operator that compares with its sides swapped
+func flippedOperator(op string) string {
+	switch op {
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	}
+	return op
+}
+
+// canonicalKey identifies a subexpression up to canonicalExpr. Operands of
+// AND, OR, and NOT are keyed recursively, so whole repeated groups match.
+func canonicalKey(expr sqlparser.Expression) string {
+	return subexprKeyer{}.key(expr)
+}
+
+// subexprKeyer builds canonical keys, counting every subexpression's key in
+// counts when set
+type subexprKeyer struct {
+	counts map[string]int
+}
+
+func (k subexprKeyer) key(expr sqlparser.Expression) string {
+	key := sqlparser.VisitExpression[string](canonicalExpr(expr), k)
+	if k.counts != nil {
+		k.counts[key]++
+	}
+	return key
+}
+
+func (k subexprKeyer) Binary(e sqlparser.BinaryExpr) string {
+	return "(" + k.key(e.Left) + " " + e.Operator + " " + k.key(e.Right) + ")"
+}
+
+func (k subexprKeyer) Unary(e sqlparser.UnaryExpr) string {
+	return e.Operator + " " + k.key(e.Expr)
+}
+
+func (subexprKeyer) Comparison(e sqlparser.Comparison) string { return fmt.Sprintf("%#v", e) }
+func (subexprKeyer) ColumnComparison(e sqlparser.ColumnComparison) string {
+	return fmt.Sprintf("%#v", e)
+}
+func (subexprKeyer) ExprComparison(e sqlparser.ExprComparison) string { return fmt.Sprintf("%#v", e) }
+func (subexprKeyer) In(e sqlparser.InExpr) string                     { return fmt.Sprintf("%#v", e) }
+
+// filterCache numbers evaluations of a compiled clause: each batch filtered
+// or row matched is an epoch, and shared results hold until the next one
+type filterCache struct {
+	epoch uint64
+}
+
+// epochFilter is the root of a clause with shared subexpressions
+type epochFilter struct {
+	inner vectorFilter
+	cache *filterCache
+}
+
+func (f *epochFilter) filter(b *columnBatch, sel []int32) []int32 {
+	f.cache.epoch++
+	return f.inner.filter(b, sel)
+}
+
+func (f *epochFilter) match(row []string) bool {
+	f.cache.epoch++
+	return f.inner.match(row)
+}
+
+func (f *epochFilter) matchRaw(fields [][]byte) bool {
+	f.cache.epoch++
+	return f.inner.matchRaw(fields)
+}
+
+// sharedResults evaluates a subexpression shared by several places in a
+// clause and remembers its results for the current epoch: one result for a
+// row, or one per batch position, filled in as selections reach it
+type sharedResults struct {
+	inner vectorFilter
+	cache *filterCache
+	epoch uint64 // Epoch the results below belong to
+
+	hit           bool   // Result for the current row
+	known, result []bool // Results by batch position
+	pending       []int32
+}
+
+// cachedFilter is one occurrence of a shared subexpression; each has its own
+// output buffer, since its caller may still hold it when another occurrence
+// is evaluated
+type cachedFilter struct {
+	results *sharedResults
+	out     []int32
+}
+
+func (f *cachedFilter) filter(b *columnBatch, sel []int32) []int32 {
+	r := f.results
+	if r.epoch != r.cache.epoch {
+		r.epoch = r.cache.epoch
+		r.known = resetBools(r.known, len(b.rows))
+		r.result = resetBools(r.result, len(b.rows))
+	}
+	r.pending = r.pending[:0]
+	for _, i := range sel {
+		if !r.known[i] {
+			r.pending = append(r.pending, i)
+		}
+	}
+	if len(r.pending) > 0 {
+		for _, i := range r.inner.filter(b, r.pending) {
+			r.result[i] = true
+		}
+		for _, i := range r.pending {
+			r.known[i] = true
+		}
+	}
+
+	out := f.out[:0]
+	for _, i := range sel {
+		if r.result[i] {
+			out = append(out, i)
+		}
+	}
+	f.out = out
+	return out
+}
+
+func (f *cachedFilter) match(row []string) bool {
+	r := f.results
+	if r.epoch != r.cache.epoch {
+		r.epoch = r.cache.epoch
+		r.hit = r.inner.match(row)
+	}
+	return r.hit
+}
+
+func (f *cachedFilter) matchRaw(fields [][]byte) bool {
+	r := f.results
+	if r.epoch != r.cache.epoch {
+		r.epoch = r.cache.epoch
+		r.hit = r.inner.matchRaw(fields)
+	}
+	return r.hit
+}
+
+// resetBools returns s resized to n, all false, reusing its storage
+func resetBools(s []bool, n int) []bool {
+	if cap(s) < n {
+		return make([]bool, n)
+	}
+	s = s[:n]
+	clear(s)
+	return s
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// resolveSubqueries runs the IN (SELECT ...) subqueries in the query's WHERE
+// and FILTER conditions, replacing each with the values it returned, so the
+// outer scan probes a hash set built once (a semi join, or an anti join under
+// NOT)
+func resolveSubqueries(query *sqlparser.Query) error {
+	var err error
+	if query.Where, err = resolveExprSubqueries(query.Where); err != nil {
+		return err
+	}
+	if query.Filters == nil {
+		return nil
+	}
+	// The caller's slice is left as it was, so a prepared query reruns its
+	// subqueries
+	filters := make([]sqlparser.Expression, len(query.Filters))
+	for i, filter := range query.Filters {
+		if filters[i], err = resolveExprSubqueries(filter); err != nil {
+			return err
+		}
+	}
+	query.Filters = filters
+	return nil
+}
+
+func resolveExprSubqueries(expr sqlparser.Expression) (sqlparser.Expression, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	r := sqlparser.VisitExpression[resolved](expr, subqueryResolver{})
+	return r.expr, r.err
+}
+
+// resolved is an expression with its subqueries run, or the error running
+// one returned
+type resolved struct {
+	expr sqlparser.Expression
+	err  error
+}
+
+// subqueryResolver replaces IN (SELECT ...) lists with the values the
+// subqueries return
+type subqueryResolver struct{}
+
+func (subqueryResolver) Binary(e sqlparser.BinaryExpr) resolved {
+	left, err := resolveExprSubqueries(e.Left)
+	if err != nil {
+		return resolved{err: err}
+	}
+	right, err := resolveExprSubqueries(e.Right)
+	if err != nil {
+		return resolved{err: err}
+	}
+	return resolved{expr: sqlparser.BinaryExpr{Left: left, Operator: e.Operator, Right: right}}
+}
+
+func (subqueryResolver) Unary(e sqlparser.UnaryExpr) resolved {
+	inner, err := resolveExprSubqueries(e.Expr)
+	if err != nil {
+		return resolved{err: err}
+	}
+	return resolved{expr: sqlparser.UnaryExpr{Operator: e.Operator, Expr: inner}}
+}
+
+func (subqueryResolver) Comparison(e sqlparser.Comparison) resolved { return resolved{expr: e} }
+
+func (subqueryResolver) ColumnComparison(e sqlparser.ColumnComparison) resolved {
+	return resolved{expr: e}
+}
+
+func (subqueryResolver) ExprComparison(e sqlparser.ExprComparison) resolved {
+	return resolved{expr: e}
+}
+
+func (subqueryResolver) In(e sqlparser.InExpr) resolved {
+	if e.Subquery == nil {
+		return resolved{expr: e}
+	}
+	values, err := subqueryValues(*e.Subquery)
+	if err != nil {
+		return resolved{err: fmt.Errorf("subquery on %s: %w", e.Subquery.FilePath, err)}
+	}
+	e.Values, e.Subquery = values, nil
+	return resolved{expr: e}
+}
+
+// subqueryValues runs a one-column query and returns its values, read back
+// from its CSV output as it streams
+func subqueryValues(query sqlparser.Query) ([]string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(Execute(query, pw))
+	}()
+	defer pr.Close() // Stops the query if reading fails
+
+	reader := csvio.NewReader(pr)
+	if _, err := reader.Read(); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var values []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, record[0])
+	}
+}
@@ -0,0 +1,267 @@
+package engine
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// topRow is a row kept by a QUALIFY scan, with the value it ranks by and its
+// position among the rows matching WHERE, which breaks ties
+type topRow struct {
+	key sqlparser.Value
+	seq int64
+	row []string // Projected output
+}
+
+// topHeap holds a partition's best rows so far with the worst on top, so a
+// better row replaces it in O(log n) and memory stays at n rows per partition
+type topHeap struct {
+	rows []topRow
+	desc bool
+}
+
+func (h *topHeap) Len() int           { return len(h.rows) }
+func (h *topHeap) Less(i, j int) bool { return h.before(h.rows[j], h.rows[i]) }
+func (h *topHeap) Swap(i, j int)      { h.rows[i], h.rows[j] = h.rows[j], h.rows[i] }
+func (h *topHeap) Push(x any)         { h.rows = append(h.rows, x.(topRow)) }
+func (h *topHeap) Pop() any {
+	last := h.rows[len(h.rows)-1]
+	h.rows = h.rows[:len(h.rows)-1]
+	return last
+}
+
+// before reports whether a ranks ahead of b: by key, then by input order
+func (h *topHeap) before(a, b topRow) bool {
+	if cmp := compareRanked(a.key, b.key, h.desc); cmp != 0 {
+		return cmp < 0
+	}
+	return a.seq < b.seq
+}
+
+// rankValue returns v as it ranks: as a number if it reads as one, and
+// missing if it is empty
+func rankValue(v sqlparser.Value, f datatype.NumberFormat) sqlparser.Value {
+	if n, ok := v.Float(f); ok {
+		return sqlparser.NumberValue(n)
+	}
+	if v.Text == "" {
+		v.Null = true
+	}
+	return v
+}
+
+// compareRanked orders values from rankValue, negative when a ranks ahead
+// of b: numbers numerically, anything else as strings, and missing values
+// last in either direction
+func compareRanked(a, b sqlparser.Value, desc bool) int {
+	switch {
+	case a.Null && b.Null:
+		return 0
+	case a.Null:
+		return 1
+	case b.Null:
+		return -1
+	}
+	var cmp int
+	if a.IsNumber && b.IsNumber {
+		cmp = cmpFloat(a.Number, b.Number)
+	} else {
+		cmp = strings.Compare(a.String(), b.String())
+	}
+	if desc {
+		return -cmp
+	}
+	return cmp
+}
+
+// admits reports whether a row would be kept among the best n
+func (h *topHeap) admits(r topRow, n int) bool {
+	return len(h.rows) < n || n > 0 && h.before(r, h.rows[0])
+}
+
+// add keeps r, dropping the worst row if the heap already holds n
+func (h *topHeap) add(r topRow, n int) {
+	if len(h.rows) < n {
+		heap.Push(h, r)
+		return
+	}
+	h.rows[0] = r
+	heap.Fix(h, 0)
+}
+
+// ranked returns the kept rows, best first
+func (h *topHeap) ranked() []topRow {
+	sort.Slice(h.rows, func(i, j int) bool { return h.before(h.rows[i], h.rows[j]) })
+	return h.rows
+}
+
+// executeTopNFromFile runs a query with QUALIFY, reading the file or a
+// stream
+func executeTopNFromFile(query sqlparser.Query, out io.Writer, stats *Stats) error {
+	if isStream(query.FilePath) {
+		reader, closeStream, err := openStream(&query, stats)
+		if err != nil {
+			return err
+		}
+		defer closeStream()
+		header, first, err := streamHeader(query, reader)
+		if err != nil {
+			return err
+		}
+		return executeTopN(query, reader, header, first, out, stats)
+	}
+
+	file, err := os.Open(query.FilePath)
+	if err != nil {
+		return fmt.Errorf("open CSV: %w", err)
+	}
+	defer file.Close()
+	reader := newCSVReader(csvio.NewDecoder(stats.countReads(csvio.NewRetryReader(file, query.FilePath, 0)), query.Encoding), query)
+
+	var header []string
+	if query.NoHeader {
+		if header, _, err = inputHeader(file, query); err != nil {
+			return err
+		}
+	} else if header, err = reader.Read(); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	return executeTopN(query, reader, header, nil, out, stats)
+}
+
+// executeTopN keeps the first Top.N rows of each partition by Top.OrderBy
+// and writes them partition by partition, in order of each partition's
+// first row, best row first
+func executeTopN(query sqlparser.Query, reader *csvio.Reader, header, first []string, out io.Writer, stats *Stats) error {
+	top := query.Top
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	pseudo := resolvePseudoColumns(query, index, len(header))
+	lines := headerLines(query.NoHeader)
+
+	partitionBy := make([]int, len(top.PartitionBy))
+	for i, col := range top.PartitionBy {
+		idx, ok := index[strings.ToLower(strings.TrimSpace(col))]
+		if !ok {
+			return fmt.Errorf("PARTITION BY column %q not found in CSV header", col)
+		}
+		partitionBy[i] = idx
+	}
+	orderBy, err := bindScalar(top.OrderBy, index)
+	if err != nil {
+		return err
+	}
+	proj, outputHeader, err := resolveProjection(query, header, index)
+	if err != nil {
+		return err
+	}
+	filter, err := compileVectorFilter(query.Where, index)
+	if err != nil {
+		return err
+	}
+	guard, err := newRowGuard(query, len(header), stats)
+	if err != nil {
+		return err
+	}
+	defer guard.close()
+
+	reader.ReuseRecord = true
+	timer := &rowTimer{stats: stats}
+	var rows operator = &scanOp{reader: reader, first: first, guard: guard, pseudo: pseudo, lines: lines, stats: stats, timer: timer}
+	rows = &filterOp{child: rows, filter: filter, stats: stats, timer: timer}
+	if sample := newSampler(query.Sample); sample != nil {
+		rows = &sampleOp{child: rows, sample: sample}
+	}
+	rows = &topNOp{child: rows, top: top, partitionBy: partitionBy, orderBy: orderBy, proj: proj, numbers: query.Numbers, stable: query.StableOrder}
+	rows = &limitOp{child: rows, n: query.Limit}
+
+	writer := NewFastCSVWriterSize(out, query.Resources.IOBufferSize)
+	if err := writer.Write(outputHeader); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if err := writeRows(writer, rows, stats, nil); err != nil {
+		return err
+	}
+	return guard.close()
+}
+
+// topNOp reads all its child's rows, keeping the first Top.N of each
+// partition, then returns them partition by partition, in order of each
+// partition's first row, best row first; or in input order when stable
+type topNOp struct {
+	child       operator
+	top         *sqlparser.TopN
+	partitionBy []int
+	orderBy     boundScalar
+	proj        *projection
+	numbers     datatype.NumberFormat
+	stable      bool
+	rows        []topRow
+	done        bool
+}
+
+func (t *topNOp) next() ([]string, error) {
+	if !t.done {
+		if err := t.rank(); err != nil {
+			return nil, err
+		}
+		t.done = true
+	}
+	if len(t.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := t.rows[0].row
+	t.rows = t.rows[1:]
+	return row, nil
+}
+
+// rank reads the child's rows into per-partition heaps, and orders the rows
+// kept
+func (t *topNOp) rank() error {
+	partitions := make(map[string]*topHeap)
+	var order []*topHeap // In order of first appearance
+	var seq int64
+	keyParts := make([]string, len(t.partitionBy))
+	err := drain(t.child, func(row []string) {
+		seq++
+		for i, idx := range t.partitionBy {
+			keyParts[i] = ""
+			if idx < len(row) {
+				keyParts[i] = row[idx]
+			}
+		}
+		key := strings.Join(keyParts, "\x00")
+		h, ok := partitions[key]
+		if !ok {
+			h = &topHeap{desc: t.top.Desc}
+			partitions[key] = h
+			order = append(order, h)
+		}
+
+		r := topRow{key: rankValue(t.orderBy.eval(row, t.numbers), t.numbers), seq: seq}
+		if h.admits(r, t.top.N) {
+			r.row = t.proj.project(row)
+			h.add(r, t.top.N)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, h := range order {
+		t.rows = append(t.rows, h.ranked()...)
+	}
+	if t.stable {
+		sort.Slice(t.rows, func(i, j int) bool { return t.rows[i].seq < t.rows[j].seq })
+	}
+	return nil
+}
@@ -0,0 +1,561 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+// vectorBatchSize is the number of rows the sequential scan filters at once
+const vectorBatchSize = 4096
+
+// columnBatch is a set of rows filtered together. Typed column vectors are
+// decoded lazily, once per batch, the first time a predicate needs them, so
+// several comparisons on one column share a single parse.
+type columnBatch struct {
+	rows  [][]string
+	nums  map[int]*numericVector
+	times map[int]*timeVector
+}
+
+type numericVector struct {
+	values []float64
+	valid  []bool // false for missing or unparseable values
+}
+
+type timeVector struct {
+	values []int64
+	valid  []bool
+}
+
+func newColumnBatch() *columnBatch {
+	return &columnBatch{
+		nums:  make(map[int]*numericVector),
+		times: make(map[int]*timeVector),
+	}
+}
+
+// reset points the batch at new rows, keeping vector allocations for reuse
+func (b *columnBatch) reset(rows [][]string) {
+	b.rows = rows
+	for _, v := range b.nums {
+		v.values = v.values[:0]
+		v.valid = v.valid[:0]
+	}
+	for _, v := range b.times {
+		v.values = v.values[:0]
+		v.valid = v.valid[:0]
+	}
+}
+
+// numbers returns column col decoded as float64
+func (b *columnBatch) numbers(col int) *numericVector {
+	v, ok := b.nums[col]
+	if !ok {
+		v = &numericVector{}
+		b.nums[col] = v
+	}
+	if len(v.valid) == len(b.rows) {
+		return v
+	}
+	for _, row := range b.rows {
+		var num float64
+		var err error = strconv.ErrSyntax
+		if col < len(row) {
+			num, err = strconv.ParseFloat(row[col], 64)
+		}
+		v.values = append(v.values, num)
+		v.valid = append(v.valid, err == nil)
+	}
+	return v
+}
+
+// timestamps returns column col decoded as Unix nanos
+func (b *columnBatch) timestamps(col int) *timeVector {
+	v, ok := b.times[col]
+	if !ok {
+		v = &timeVector{}
+		b.times[col] = v
+	}
+	if len(v.valid) == len(b.rows) {
+		return v
+	}
+	for _, row := range b.rows {
+		var ts int64
+		parsed := false
+		if col < len(row) {
+			ts, parsed = datatype.ParseTimestamp(row[col])
+		}
+		v.values = append(v.values, ts)
+		v.valid = append(v.valid, parsed)
+	}
+	return v
+}
+
+// vectorFilter is a WHERE clause compiled against a header: columns are
+// resolved to positions once, so evaluation never builds a row map.
+//
+// filter narrows a selection vector (ascending row positions within a batch)
+// to the rows matching the predicate; match tests a single row for streaming
+// paths; matchRaw tests a row of raw fields from FastCSVReader.ReadRaw without
+// materializing strings. Each node owns its output buffer, so a compiled filter must not be
+// shared between goroutines.
+type vectorFilter interface {
+	filter(b *columnBatch, sel []int32) []int32
+	match(row []string) bool
+	matchRaw(fields [][]byte) bool
+}
+
+// compileVectorFilter resolves the WHERE clause against the normalized header
+// index (lowercased, trimmed names). A nil expression compiles to a nil filter.
+func compileVectorFilter(expr sqlparser.Expression, index map[string]int) (vectorFilter, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	expr = sqlparser.Normalize(expr)
+	c := newFilterCompiler(expr, index)
+	f, err := c.compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return c.root(f), nil
+}
+
+// compileNode compiles one node of the WHERE clause; its operands go through
+// c.compile, which shares repeated subexpressions
+func (c *filterCompiler) compileNode(expr sqlparser.Expression) (vectorFilter, error) {
+	return sqlparser.VisitExpression[compiled](expr, nodeCompiler{c}).unpack()
+}
+
+// compiled is a compiled filter or the reason a node can't be compiled, the
+// one value an ExpressionVisitor returns
+type compiled struct {
+	filter vectorFilter
+	err    error
+}
+
+func (c compiled) unpack() (vectorFilter, error) { return c.filter, c.err }
+
+// nodeCompiler compiles the nodes of a WHERE clause for filterCompiler
+type nodeCompiler struct {
+	c *filterCompiler
+}
+
+func (n nodeCompiler) Binary(e sqlparser.BinaryExpr) compiled {
+	left, err := n.c.compile(e.Left)
+	if err != nil {
+		return compiled{err: err}
+	}
+	right, err := n.c.compile(e.Right)
+	if err != nil {
+		return compiled{err: err}
+	}
+	switch e.Operator {
+	case "AND", "OR":
+		return compiled{filter: newFilterChain(e.Operator == "AND", left, right)}
+	}
+	return compiled{err: fmt.Errorf("unsupported boolean operator %q", e.Operator)}
+}
+
+func (n nodeCompiler) Unary(e sqlparser.UnaryExpr) compiled {
+	if e.Operator != "NOT" {
+		return compiled{err: fmt.Errorf("unsupported unary operator %q", e.Operator)}
+	}
+	inner, err := n.c.compile(e.Expr)
+	if err != nil {
+		return compiled{err: err}
+	}
+	return compiled{filter: &notFilter{inner: inner}}
+}
+
+func (n nodeCompiler) Comparison(e sqlparser.Comparison) compiled {
+	col, ok := n.c.index[strings.ToLower(strings.TrimSpace(e.Column))]
+	if !ok {
+		return compiled{err: fmt.Errorf("column %q not found in CSV header", e.Column)}
+	}
+	// Decimal, boolean, and formatted numbers can't use the batch's float64
+	// column or raw bytes; they go through Compare
+	slow := e.IsDecimal || e.IsBool || (e.IsNumeric && !e.Numbers.IsDefault())
+	return compiled{filter: &compareFilter{col: col, cmp: e, value: []byte(e.Value), slow: slow}}
+}
+
+func (n nodeCompiler) ColumnComparison(e sqlparser.ColumnComparison) compiled {
+	left, ok := n.c.index[strings.ToLower(strings.TrimSpace(e.Left))]
+	if !ok {
+		return compiled{err: fmt.Errorf("column %q not found in CSV header", e.Left)}
+	}
+	right, ok := n.c.index[strings.ToLower(strings.TrimSpace(e.Right))]
+	if !ok {
+		return compiled{err: fmt.Errorf("column %q not found in CSV header", e.Right)}
+	}
+	// Inferred and float comparisons of plain numbers can use the batch's
+	// float64 columns
+	numeric := e.Numbers.IsDefault() && (e.Type == "" || e.Type == sqlparser.TypeInt || e.Type == sqlparser.TypeFloat)
+	return compiled{filter: &columnCompareFilter{left: left, right: right, cmp: e, numeric: numeric}}
+}
+
+func (n nodeCompiler) ExprComparison(e sqlparser.ExprComparison) compiled {
+	f, err := newExprCompareFilter(e, n.c.index)
+	return compiled{filter: f, err: err}
+}
+
+func (n nodeCompiler) In(e sqlparser.InExpr) compiled {
+	f, err := newInFilter(e, n.c.index)
+	return compiled{filter: f, err: err}
+}
+
+// filterAll returns the positions of the rows in b matching f, using all as
+// scratch for the initial selection
+func filterAll(f vectorFilter, b *columnBatch, all []int32) []int32 {
+	all = all[:0]
+	for i := range b.rows {
+		all = append(all, int32(i))
+	}
+	return f.filter(b, all)
+}
+
+type notFilter struct {
+	inner vectorFilter
+	out   []int32
+}
+
+func (f *notFilter) filter(b *columnBatch, sel []int32) []int32 {
+	f.out = difference(f.out[:0], sel, f.inner.filter(b, sel))
+	return f.out
+}
+
+func (f *notFilter) match(row []string) bool {
+	return !f.inner.match(row)
+}
+
+func (f *notFilter) matchRaw(fields [][]byte) bool {
+	return !f.inner.matchRaw(fields)
+}
+
+// difference appends to dst the positions in sel that are not in subset,
+// where subset is an ascending subsequence of sel
+func difference(dst, sel, subset []int32) []int32 {
+	j := 0
+	for _, i := range sel {
+		if j < len(subset) && subset[j] == i {
+			j++
+			continue
+		}
+		dst = append(dst, i)
+	}
+	return dst
+}
+
+type compareFilter struct {
+	col   int
+	cmp   sqlparser.Comparison
+	value []byte // cmp.Value, for comparing raw fields
+	slow  bool   // Every value goes through cmp.Compare
+	out   []int32
+}
+
+func (f *compareFilter) filter(b *columnBatch, sel []int32) []int32 {
+	out := f.out[:0]
+	switch {
+	case f.cmp.IsNumeric && !f.slow:
+		v := b.numbers(f.col)
+		out = selectOrdered(out, sel, v.values, v.valid, f.cmp.Operator, f.cmp.NumericValue)
+	case f.cmp.IsTimestamp:
+		v := b.timestamps(f.col)
+		out = selectOrdered(out, sel, v.values, v.valid, f.cmp.Operator, f.cmp.TimeValue)
+	case f.cmp.Operator == "=" && !f.cmp.CaseInsensitive && !f.slow:
+		// Most common string predicate: skip the general comparison
+		for _, i := range sel {
+			row := b.rows[i]
+			if f.col < len(row) && row[f.col] == f.cmp.Value {
+				out = append(out, i)
+			}
+		}
+	default:
+		for _, i := range sel {
+			row := b.rows[i]
+			if f.col < len(row) && f.cmp.Compare(row[f.col]) {
+				out = append(out, i)
+			}
+		}
+	}
+	f.out = out
+	return out
+}
+
+// match reports whether the row satisfies the comparison; a row too short to
+// have the column never does
+func (f *compareFilter) match(row []string) bool {
+	return f.col < len(row) && f.cmp.Compare(row[f.col])
+}
+
+// matchRaw is match on a raw field: numbers and exact string comparisons run
+// on the bytes, other comparisons convert the one field they need
+func (f *compareFilter) matchRaw(fields [][]byte) bool {
+	if f.col >= len(fields) {
+		return false
+	}
+	field := fields[f.col]
+	switch {
+	case f.slow || f.cmp.IsTimestamp || f.cmp.CaseInsensitive:
+		return f.cmp.Compare(string(field))
+	case f.cmp.IsNumeric:
+		num, ok := parseFloatBytes(field)
+		return ok && compareResult(f.cmp.Operator, cmpFloat(num, f.cmp.NumericValue))
+	default:
+		return compareResult(f.cmp.Operator, bytes.Compare(field, f.value))
+	}
+}
+
+type columnCompareFilter struct {
+	left, right int
+	cmp         sqlparser.ColumnComparison
+	numeric     bool // Values that both read as numbers compare as float64s
+	out         []int32
+}
+
+func (f *columnCompareFilter) filter(b *columnBatch, sel []int32) []int32 {
+	out := f.out[:0]
+	if f.numeric {
+		l, r := b.numbers(f.left), b.numbers(f.right)
+		for _, i := range sel {
+			if l.valid[i] && r.valid[i] {
+				if compareResult(f.cmp.Operator, cmpFloat(l.values[i], r.values[i])) {
+					out = append(out, i)
+				}
+			} else if f.match(b.rows[i]) {
+				out = append(out, i)
+			}
+		}
+	} else {
+		for _, i := range sel {
+			if f.match(b.rows[i]) {
+				out = append(out, i)
+			}
+		}
+	}
+	f.out = out
+	return out
+}
+
+// match reports whether the row satisfies the comparison; a row too short to
+// have both columns never does
+func (f *columnCompareFilter) match(row []string) bool {
+	return f.left < len(row) && f.right < len(row) && f.cmp.Compare(row[f.left], row[f.right])
+}
+
+// matchRaw compares plain numbers on the bytes and converts the two fields
+// for anything else
+func (f *columnCompareFilter) matchRaw(fields [][]byte) bool {
+	if f.left >= len(fields) || f.right >= len(fields) {
+		return false
+	}
+	left, right := fields[f.left], fields[f.right]
+	if f.numeric {
+		if l, ok := parseFloatBytes(left); ok {
+			if r, ok := parseFloatBytes(right); ok {
+				return compareResult(f.cmp.Operator, cmpFloat(l, r))
+			}
+		}
+	}
+	return f.cmp.Compare(string(left), string(right))
+}
+
+// inFilter tests a column against the values of an IN list, held in hash
+// sets: numbers by value and strings by their folded form
+type inFilter struct {
+	col     int
+	in      sqlparser.InExpr
+	numbers map[float64]struct{}
+	strs    map[string]struct{}
+	out     []int32
+}
+
+func newInFilter(e sqlparser.InExpr, index map[string]int) (*inFilter, error) {
+	col, ok := index[strings.ToLower(strings.TrimSpace(e.Column))]
+	if !ok {
+		return nil, fmt.Errorf("column %q not found in CSV header", e.Column)
+	}
+	if e.Subquery != nil {
+		return nil, fmt.Errorf("subquery on %s wasn't run", e.Subquery.FilePath)
+	}
+	f := &inFilter{col: col, in: e, numbers: make(map[float64]struct{}), strs: make(map[string]struct{})}
+	for _, value := range e.Values {
+		if n, ok := e.Number(value); ok {
+			f.numbers[n] = struct{}{}
+		} else {
+			f.strs[e.Fold(value)] = struct{}{}
+		}
+	}
+	return f, nil
+}
+
+func (f *inFilter) filter(b *columnBatch, sel []int32) []int32 {
+	out := f.out[:0]
+	for _, i := range sel {
+		if f.match(b.rows[i]) {
+			out = append(out, i)
+		}
+	}
+	f.out = out
+	return out
+}
+
+// match reports whether the row's value is in the list; a row too short to
+// have the column never is
+func (f *inFilter) match(row []string) bool {
+	return f.col < len(row) && f.contains(row[f.col])
+}
+
+func (f *inFilter) matchRaw(fields [][]byte) bool {
+	return f.col < len(fields) && f.contains(string(fields[f.col]))
+}
+
+func (f *inFilter) contains(value string) bool {
+	if n, ok := f.in.Number(value); ok {
+		_, found := f.numbers[n]
+		return found
+	}
+	_, found := f.strs[f.in.Fold(value)]
+	return found
+}
+
+// cmpFloat orders a against b; NaN is unordered and reported as 2, which
+// satisfies only !=, as in sqlparser.Comparison.Compare
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	case a == b:
+		return 0
+	}
+	return 2
+}
+
+// compareResult applies a comparison operator to an ordering result
+func compareResult(op string, cmp int) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp == 1
+	case ">=":
+		return cmp == 1 || cmp == 0
+	case "<":
+		return cmp == -1
+	case "<=":
+		return cmp == -1 || cmp == 0
+	}
+	return false
+}
+
+// maxExactMantissa is the largest integer a float64 holds exactly
+const maxExactMantissa = 1<<53 - 1
+
+// exactPow10 holds the powers of ten a float64 represents exactly
+var exactPow10 = [...]float64{1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10,
+	1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22}
+
+// parseFloatBytes parses b like strconv.ParseFloat(string(b), 64). Plain
+// decimals ([+-]digits[.digits]) whose digits fit in a float64 mantissa are
+// converted without allocating: an exact integer divided by an exact power
+// of ten is correctly rounded. Anything else goes through strconv.
+func parseFloatBytes(b []byte) (float64, bool) {
+	i := 0
+	neg := false
+	if i < len(b) && (b[i] == '+' || b[i] == '-') {
+		neg = b[i] == '-'
+		i++
+	}
+
+	var mantissa uint64
+	digits, fracDigits := 0, 0
+	sawDot := false
+	for ; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c >= '0' && c <= '9':
+			mantissa = mantissa*10 + uint64(c-'0')
+			digits++
+			if sawDot {
+				fracDigits++
+			}
+			if mantissa > maxExactMantissa {
+				return parseFloatSlow(b)
+			}
+		case c == '.' && !sawDot:
+			sawDot = true
+		default:
+			return parseFloatSlow(b)
+		}
+	}
+	if digits == 0 {
+		return parseFloatSlow(b)
+	}
+	if fracDigits >= len(exactPow10) {
+		return parseFloatSlow(b)
+	}
+
+	f := float64(mantissa) / exactPow10[fracDigits]
+	if neg {
+		f = -f
+	}
+	return f, true
+}
+
+func parseFloatSlow(b []byte) (float64, bool) {
+	f, err := strconv.ParseFloat(string(b), 64)
+	return f, err == nil
+}
+
+// selectOrdered appends the selected positions whose decoded value satisfies
+// "value op constant". The operator is switched on once per batch.
+func selectOrdered[T float64 | int64](out, sel []int32, values []T, valid []bool, op string, constant T) []int32 {
+	switch op {
+	case "=":
+		for _, i := range sel {
+			if valid[i] && values[i] == constant {
+				out = append(out, i)
+			}
+		}
+	case "!=":
+		for _, i := range sel {
+			if valid[i] && values[i] != constant {
+				out = append(out, i)
+			}
+		}
+	case ">":
+		for _, i := range sel {
+			if valid[i] && values[i] > constant {
+				out = append(out, i)
+			}
+		}
+	case ">=":
+		for _, i := range sel {
+			if valid[i] && values[i] >= constant {
+				out = append(out, i)
+			}
+		}
+	case "<":
+		for _, i := range sel {
+			if valid[i] && values[i] < constant {
+				out = append(out, i)
+			}
+		}
+	case "<=":
+		for _, i := range sel {
+			if valid[i] && values[i] <= constant {
+				out = append(out, i)
+			}
+		}
+	}
+	return out
+}
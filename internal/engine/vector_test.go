@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/melihbirim/sieswi/internal/sqlparser"
+)
+
+func TestVectorFilterMatchesEvaluate(t *testing.T) {
+	header := []string{"id", "country", "amount", "created_at"}
+	index := map[string]int{"id": 0, "country": 1, "amount": 2, "created_at": 3}
+
+	countries := []string{"DE", "fr", "UK", "", "US"}
+	amounts := []string{"10", "99.5", "abc", "", "NaN", "-3", "1e3"}
+	dates := []string{"2024-01-01", "2024-02-15T10:00:00Z", "not a date", "", "2024-03-01 12:00:00"}
+	var rows [][]string
+	for i := 0; i < 200; i++ {
+		row := []string{
+			fmt.Sprint(i),
+			countries[i%len(countries)],
+			amounts[i%len(amounts)],
+			dates[i%len(dates)],
+		}
+		if i%17 == 0 {
+			row = row[:2] // Short row: amount and created_at are missing
+		}
+		rows = append(rows, row)
+	}
+
+	wheres := []string{
+		"amount > 10",
+		"amount != 10",
+		"amount <= 99.5 AND country = 'UK'",
+		"country = 'UK' OR amount >= 1000",
+		"NOT amount > 10",
+		"NOT (country = 'DE' OR country = 'US') AND id < 150",
+		"created_at >= '2024-02-01'",
+		"NOT created_at < '2024-02-01'",
+		"country =~ 'FR' OR (amount < 0 AND NOT id = 5)",
+		"country ILIKE 'u%'",
+		"country != ''",
+		"(id < 20 OR id > 180) OR (amount = 10 AND created_at = '2024-01-01')",
+		"NOT (NOT country = 'DE')",
+		"CAST(amount AS DECIMAL) = 10.0 OR CAST(amount AS DECIMAL) < -2",
+		"CAST(country AS STRING) >= 'UK' AND CAST(id AS DECIMAL) != 7",
+		"amount > id",
+		"NOT amount <= id OR country = country",
+		"created_at >= created_at AND amount != amount",
+		"country =~ created_at OR id < amount",
+		"CAST(amount AS DECIMAL) < id",
+		"CAST(amount AS STRING) > id",
+		"amount * 2 > id + 10",
+		"ROUND(amount / 3, 1) >= 3.3 OR ABS(amount) = 3",
+		"id % 7 = 0 AND amount - 1 != 9",
+		"FLOOR(SQRT(id)) = 4 OR -amount > 0",
+		"id / (amount - 10) < 1",
+		"2 * id <= 100 AND country =~ 'uk'",
+		// Repeated subexpressions are evaluated once per row
+		"(country = 'UK' AND amount > 10) OR (COUNTRY = 'UK' AND id < 50)",
+		"NOT amount > 10 AND (amount > 10 OR country = 'DE')",
+		"amount > id OR (id < amount AND country = 'US')",
+		"(id < 20 OR country = 'US') AND NOT (id < 20 OR country = 'US')",
+		"(id < 20 OR country = 'US') OR (id < 20 OR country = 'US') AND created_at >= '2024-02-01'",
+	}
+
+	for _, where := range wheres {
+		query, err := sqlparser.Parse("SELECT * FROM data.csv WHERE " + where)
+		if err != nil {
+			t.Fatalf("parse %q: %v", where, err)
+		}
+		filter, err := compileVectorFilter(query.Where, index)
+		if err != nil {
+			t.Fatalf("compile %q: %v", where, err)
+		}
+
+		var want []int32
+		for i, row := range rows {
+			rowMap := make(map[string]string, len(row))
+			for j, value := range row {
+				rowMap[header[j]] = value
+			}
+			if sqlparser.EvaluateNormalized(query.Where, rowMap) {
+				want = append(want, int32(i))
+			}
+		}
+
+		// Run twice over the same batch to exercise buffer reuse
+		batch := newColumnBatch()
+		for round := 0; round < 2; round++ {
+			batch.reset(rows)
+			got := filterAll(filter, batch, nil)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%s (round %d): got %v, want %v", where, round, got, want)
+			}
+		}
+
+		var matched []int32
+		for i, row := range rows {
+			if filter.match(row) {
+				matched = append(matched, int32(i))
+			}
+		}
+		if fmt.Sprint(matched) != fmt.Sprint(want) {
+			t.Errorf("%s (match): got %v, want %v", where, matched, want)
+		}
+
+		var matchedRaw []int32
+		for i, row := range rows {
+			fields := make([][]byte, len(row))
+			for j, value := range row {
+				fields[j] = []byte(value)
+			}
+			if filter.matchRaw(fields) {
+				matchedRaw = append(matchedRaw, int32(i))
+			}
+		}
+		if fmt.Sprint(matchedRaw) != fmt.Sprint(want) {
+			t.Errorf("%s (matchRaw): got %v, want %v", where, matchedRaw, want)
+		}
+	}
+}
+
+func TestParseFloatBytes(t *testing.T) {
+	inputs := []string{
+		"0", "-0", "+7", "42", "99.5", "0.1", ".5", "5.", "-3.25", "007",
+		"123456789012345678", "9007199254740993", "0.30000000000000004",
+		"1.0000000000000000000000001", "1e3", "-2.5E-3", "NaN", "inf", "0x1p-2",
+		"", "-", ".", "1.2.3", "12a", " 1", "1_000",
+	}
+	for _, in := range inputs {
+		want, err := strconv.ParseFloat(in, 64)
+		got, ok := parseFloatBytes([]byte(in))
+		if ok != (err == nil) {
+			t.Errorf("parseFloatBytes(%q) ok = %v, strconv err = %v", in, ok, err)
+			continue
+		}
+		if ok && math.Float64bits(got) != math.Float64bits(want) && !(math.IsNaN(got) && math.IsNaN(want)) {
+			t.Errorf("parseFloatBytes(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestCompileVectorFilterUnknownColumn(t *testing.T) {
+	query, err := sqlparser.Parse("SELECT * FROM data.csv WHERE missing = 1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := compileVectorFilter(query.Where, map[string]int{"id": 0}); err == nil {
+		t.Fatalf("expected error for unknown column")
+	}
+}
+
+func TestFilterChainReorders(t *testing.T) {
+	index := map[string]int{"id": 0, "country": 1}
+	var rows [][]string
+	for i := 0; i < 3*rerankRows; i++ {
+		rows = append(rows, []string{fmt.Sprint(i), []string{"UK", "US"}[i%2]})
+	}
+
+	for _, tt := range []struct {
+		where string
+		first string // Column of the term expected to run first after sampling
+		want  int
+	}{
+		// id < 10 rejects almost every row, so it moves ahead of country
+		{"country = 'UK' AND id < 10", "id", 5},
+		// id >= 10 accepts almost every row, so it moves ahead in an OR
+		{"country = 'UK' OR id >= 10", "id", 3*rerankRows - 5},
+	} {
+		query, err := sqlparser.Parse("SELECT * FROM data.csv WHERE " + tt.where)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.where, err)
+		}
+		filter, err := compileVectorFilter(query.Where, index)
+		if err != nil {
+			t.Fatalf("compile %q: %v", tt.where, err)
+		}
+
+		batch := newColumnBatch()
+		for start := 0; start < len(rows); start += rerankRows {
+			batch.reset(rows[start : start+rerankRows])
+			got := filterAll(filter, batch, nil)
+			matched := 0
+			for _, row := range rows[start : start+rerankRows] {
+				if filter.match(row) {
+					matched++
+				}
+			}
+			if len(got) != matched {
+				t.Errorf("%s: filter kept %d rows, match %d", tt.where, len(got), matched)
+			}
+		}
+		total := 0
+		for _, row := range rows {
+			if filter.match(row) {
+				total++
+			}
+		}
+		if total != tt.want {
+			t.Errorf("%s: matched %d rows, want %d", tt.where, total, tt.want)
+		}
+
+		chain := filter.(*filterChain)
+		if first := chain.terms[0].f.(*compareFilter).cmp.Column; first != tt.first {
+			t.Errorf("%s: first term is on %s, want %s", tt.where, first, tt.first)
+		}
+	}
+}
+
+func TestCompileSharesRepeatedSubexpressions(t *testing.T) {
+	index := map[string]int{"id": 0, "country": 1, "amount": 2}
+	for where, shared := range map[string]bool{
+		"(country = 'UK' AND amount > 10) OR (Country = 'UK' AND id < 50)": true,
+		"amount > id OR id < amount":                                       true,
+		"country = 'UK' AND amount > 10":                                   false,
+		"country = 'UK' OR country = 'uk'":                                 false,
+	} {
+		query, err := sqlparser.Parse("SELECT * FROM data.csv WHERE " + where)
+		if err != nil {
+			t.Fatalf("parse %q: %v", where, err)
+		}
+		filter, err := compileVectorFilter(query.Where, index)
+		if err != nil {
+			t.Fatalf("compile %q: %v", where, err)
+		}
+		if _, ok := filter.(*epochFilter); ok != shared {
+			t.Errorf("%s: shared = %v, want %v", where, ok, shared)
+		}
+	}
+}
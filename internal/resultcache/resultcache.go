@@ -0,0 +1,191 @@
+// Package resultcache stores query results on disk, keyed by the content of
+// the files a query reads and the query itself, so a repeated query over
+// unchanged files is answered by copying its last result.
+//
+// A cache directory holds two kinds of entries:
+//
+//	files/<hash of path>     size, modification time, fingerprint, and content hash of a file
+//	results/<key>.csv        a complete result
+//
+// A file's content is only hashed again when its size, modification time,
+// or fingerprint (the CRC-64 of its first and last 64KB that .sidx indexes
+// are validated by) changes, so keys for unchanged files cost a stat and
+// two small reads each. The fingerprint catches rewrites that keep the
+// size and modification time (cp -p, touch -r, rsync --times), unless they
+// only change bytes more than 64KB from both ends. Entries are
+// written to a temporary file and renamed into place, so readers never see
+// a partial result and concurrent writers of one key are harmless. Nothing
+// is evicted; remove the directory to clear the cache.
+package resultcache
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/sidx"
+)
+
+// formatVersion changes whenever the layout of the cache directory or the
+// derivation of keys does, orphaning older entries
+const formatVersion = "1"
+
+// Cache is a result cache rooted at a directory
+type Cache struct {
+	dir string
+}
+
+// Open returns the cache in dir, creating the directory if needed
+func Open(dir string) (*Cache, error) {
+	for _, sub := range []string{"files", "results"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("open result cache: %w", err)
+		}
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Key identifies the result of query over files. query must describe
+// everything besides the files' content that affects the result (the
+// normalized statement and any options), in a form that's stable across
+// runs.
+func (c *Cache) Key(query string, files []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d:%s\x00", formatVersion, len(query), query)
+	for _, path := range files {
+		sum, err := c.fileHash(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Get opens the stored result for key; ok is false when there is none
+func (c *Cache) Get(key string) (result *os.File, ok bool) {
+	file, err := os.Open(c.resultPath(key))
+	if err != nil {
+		return nil, false
+	}
+	return file, true
+}
+
+// Put starts storing the result for key. The result is only visible once
+// committed.
+func (c *Cache) Put(key string) (*Entry, error) {
+	file, err := os.CreateTemp(filepath.Join(c.dir, "results"), ".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("store result: %w", err)
+	}
+	return &Entry{Writer: bufio.NewWriter(file), file: file, path: c.resultPath(key)}, nil
+}
+
+func (c *Cache) resultPath(key string) string {
+	return filepath.Join(c.dir, "results", key+".csv")
+}
+
+// Entry is a result being stored
+type Entry struct {
+	*bufio.Writer
+	file *os.File
+	path string
+}
+
+// Commit makes the written result the one stored for its key
+func (e *Entry) Commit() error {
+	err := e.Flush()
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(e.file.Name(), e.path)
+	}
+	if err != nil {
+		os.Remove(e.file.Name())
+		return fmt.Errorf("store result: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the written result
+func (e *Entry) Abort() {
+	e.file.Close()
+	os.Remove(e.file.Name())
+}
+
+// fileHash returns the SHA-256 of path's content, from the files entry
+// when its size, modification time, and fingerprint still match
+func (c *Cache) fileHash(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+	name := sha256.Sum256([]byte(abs))
+	entryPath := filepath.Join(c.dir, "files", hex.EncodeToString(name[:]))
+	fingerprint, err := sidx.Fingerprint(abs)
+	if err != nil {
+		return "", err
+	}
+	stamp := strconv.FormatInt(info.Size(), 10) + " " + strconv.FormatInt(info.ModTime().UnixNano(), 10) +
+		" " + strconv.FormatUint(fingerprint, 16)
+
+	if data, err := os.ReadFile(entryPath); err == nil {
+		if recorded, sum, ok := strings.Cut(strings.TrimSpace(string(data)), "\n"); ok && recorded == stamp {
+			return sum, nil
+		}
+	}
+
+	file, err := os.Open(abs)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	// A file written while it was hashed gets a new stamp, so the entry is
+	// only trusted when the stamp held throughout
+	if after, err := file.Stat(); err != nil || after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		return "", fmt.Errorf("%s changed while it was hashed", path)
+	}
+	if err := writeAtomic(entryPath, stamp+"\n"+sum+"\n"); err != nil {
+		return "", err
+	}
+	return sum, nil
+}
+
+// writeAtomic replaces path with data
+func writeAtomic(path, data string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
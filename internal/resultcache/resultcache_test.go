@@ -0,0 +1,101 @@
+package resultcache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(dir, "data.csv")
+	copyPath := filepath.Join(dir, "copy.csv")
+	for _, path := range []string{data, copyPath} {
+		if err := os.WriteFile(path, []byte("id\n1\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := cache.Key("SELECT * FROM data.csv", []string{data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("empty cache had a result")
+	}
+
+	// An aborted result is never seen
+	entry, err := cache.Put(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.WriteString("partial")
+	entry.Abort()
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("aborted result was stored")
+	}
+
+	entry, err = cache.Put(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.WriteString("id\n1\n")
+	if err := entry.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	result, ok := cache.Get(key)
+	if !ok {
+		t.Fatal("committed result not found")
+	}
+	got, _ := io.ReadAll(result)
+	result.Close()
+	if string(got) != "id\n1\n" {
+		t.Errorf("result = %q", got)
+	}
+
+	// Keys follow content, not paths, and differ by query
+	if other, _ := cache.Key("SELECT * FROM data.csv", []string{copyPath}); other != key {
+		t.Error("identical content gave a different key")
+	}
+	if other, _ := cache.Key("SELECT id FROM data.csv", []string{data}); other == key {
+		t.Error("different query gave the same key")
+	}
+
+	// A rewrite with new content changes the key, even at the same size
+	if err := os.WriteFile(data, []byte("id\n2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(data, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := cache.Key("SELECT * FROM data.csv", []string{data}); other == key {
+		t.Error("changed file gave the same key")
+	}
+
+	// So does one that keeps the size and modification time, as cp -p and
+	// touch -r do
+	key, err = cache.Key("SELECT * FROM data.csv", []string{data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(data, []byte("id\n3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(data, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := cache.Key("SELECT * FROM data.csv", []string{data}); other == key {
+		t.Error("file rewritten with its modification time gave the same key")
+	}
+
+	if _, err := cache.Key("SELECT 1", []string{filepath.Join(dir, "missing.csv")}); err == nil {
+		t.Error("missing file gave a key")
+	}
+}
@@ -1,14 +1,16 @@
 package sidx
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/csv"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
 )
 
 // Builder collects statistics while scanning a CSV file
@@ -18,24 +20,46 @@ type Builder struct {
 	blocks     []BlockMeta
 
 	// Current block state
-	blockStartRow     uint64
-	blockStartOffset  uint64
-	lastRowEndOffset  uint64
-	columnMins        []string
-	columnMaxs        []string
-	columnEmptyCounts []uint32
-	columnTypes       []ColumnType
-	headers           []string
-
-	// Type inference state (computed during first block)
-	typeInferenceActive bool
-	skipTypeInference   bool
-	numericCounts       []int
-	nonEmptyCounts      []int
-
-	// Reusable CSV parsing buffer
-	csvReader *csv.Reader
-	csvBuffer *bytes.Reader
+	blockStartRow    uint64
+	blockStartOffset uint64
+	lastRowEndOffset uint64
+	columnStats      []columnAccumulator
+	columnTypes      []ColumnType
+	columnTracks     []uint8
+	headers          []string
+
+	// Column selection: requested names and their resolved header positions
+	columns  []string
+	ordinals []int
+
+	// Headerless input: the first row is data and columns are named here
+	noHeader    bool
+	headerNames []string
+	header      bool // The first row is a header; not sniffed
+
+	// Delimiter and quote character; sniffed where left zero
+	dialect csvio.Dialect
+
+	numbers datatype.NumberFormat
+
+	// Numeric columns whose blocks also store sums
+	sumColumns []string
+
+	progress ProgressFunc
+
+	// Stops the build between blocks once closed
+	cancel <-chan struct{}
+
+	// Where to save progress for a later build to resume from, if anywhere
+	checkpointPath string
+	resumedRows    uint64
+
+	// Distinct value sketches, one per indexed column
+	sketches []hyperLogLog
+
+	skipTypeInference bool
+	strict            bool
+	encoding          string
 }
 
 func NewBuilder(blockSize uint32) *Builder {
@@ -50,39 +74,455 @@ func (b *Builder) SetSkipTypeInference(skip bool) {
 	b.skipTypeInference = skip
 }
 
-// finalizeTypeInference determines column types based on collected statistics
-func (b *Builder) finalizeTypeInference() {
-	for i := range b.columnTypes {
-		// If >80% of non-empty values are numeric, treat as numeric
-		if b.nonEmptyCounts[i] > 0 && b.numericCounts[i]*5 >= b.nonEmptyCounts[i]*4 {
-			b.columnTypes[i] = ColumnTypeNumeric
-		} else {
-			b.columnTypes[i] = ColumnTypeString
+// SetColumns restricts statistics to the named columns.
+// An empty list (the default) indexes every column in the CSV header.
+func (b *Builder) SetColumns(columns []string) {
+	b.columns = columns
+}
+
+// SetNoHeader treats the first row as data. Columns are named by names,
+// then c1, c2, ... by position.
+func (b *Builder) SetNoHeader(names []string) {
+	b.noHeader = true
+	b.headerNames = names
+}
+
+// SetHeader treats the first row as column names without sniffing
+// whether it is one
+func (b *Builder) SetHeader() {
+	b.header = true
+}
+
+// SetDialect reads fields separated and quoted as d says; zero fields are
+// sniffed from the start of the file. The dialect is recorded in the index,
+// which only serves queries reading the file the same way.
+func (b *Builder) SetDialect(d csvio.Dialect) {
+	b.dialect = d
+}
+
+// SetNumberFormat reads numeric values in format f ("1,234.56", "1.234,56").
+// The format is recorded in the index, which only serves queries using it.
+func (b *Builder) SetNumberFormat(f datatype.NumberFormat) {
+	b.numbers = f
+}
+
+// SetStrict fails the build at the first row whose field count differs
+// from the header's. By default such rows are indexed like any other.
+func (b *Builder) SetStrict(strict bool) {
+	b.strict = strict
+}
+
+// SetEncoding decodes the file from encoding (see csvio.ParseEncoding).
+// Only encodings whose records sit at the raw file's offsets can be indexed.
+func (b *Builder) SetEncoding(encoding string) {
+	b.encoding = encoding
+}
+
+// SetSumColumns stores per-block sums of the named numeric columns, which
+// whole-file SUM and AVG take from the index instead of the rows
+func (b *Builder) SetSumColumns(columns []string) {
+	b.sumColumns = columns
+}
+
+// SetProgress registers a callback invoked periodically during BuildFromFile
+func (b *Builder) SetProgress(fn ProgressFunc) {
+	b.progress = fn
+}
+
+// SetCancel stops BuildFromFile with ErrCanceled once done is closed.
+// The build checks it between blocks.
+func (b *Builder) SetCancel(done <-chan struct{}) {
+	b.cancel = done
+}
+
+// SetCheckpoint saves the build's progress to path every so often and when
+// it's canceled. A build finding a checkpoint there from the same file and
+// options resumes after its last block instead of starting over. The caller
+// removes path once the index is safely written.
+func (b *Builder) SetCheckpoint(path string) {
+	b.checkpointPath = path
+}
+
+// ResumedRows returns how many rows BuildFromFile took from a checkpoint
+// rather than scanning them, zero for a build that started over
+func (b *Builder) ResumedRows() uint64 {
+	return b.resumedRows
+}
+
+// recordLine is the line number of data row row (0-based) as queries report
+// it, with the header as line 1 and each record counted as one line
+func recordLine(row uint64, noHeader bool) uint64 {
+	if noHeader {
+		return row + 1
+	}
+	return row + 2
+}
+
+// Sizing blocks by bytes: DefaultBlockBytes is the CSV data a block covers
+// unless asked otherwise, and the row count derived from it is clamped so
+// very wide rows still share a block and very thin ones don't make it huge.
+// Blocks sized by bytes cover DefaultBlockBytes of CSV unless told
+// otherwise, and hold between MinRowsPerBlock and MaxRowsPerBlock rows
+const (
+	DefaultBlockBytes = 1 << 20
+	MinRowsPerBlock   = 64
+	MaxRowsPerBlock   = 1 << 20
+
+	// blockSizeSampleBytes is how much of the file RowsPerBlock measures
+	blockSizeSampleBytes = 1 << 20
+)
+
+// RowsPerBlock returns the rows per block that make blocks of csvPath cover
+// about targetBytes of CSV, from the average record width near the start of
+// the file. Pruning granularity then stays alike across thin and wide files.
+// A file without data rows gets BlockSize.
+func RowsPerBlock(csvPath string, targetBytes int64, noHeader bool) (uint32, error) {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := csvio.NewRecordReader(csvio.NewRetryReader(f, csvPath, 0))
+	if !noHeader {
+		if _, err := reader.Next(); err != nil {
+			if err == io.EOF {
+				return BlockSize, nil
+			}
+			return 0, fmt.Errorf("read header: %w", err)
+		}
+	}
+	var rows, bytes int64
+	for bytes < blockSizeSampleBytes {
+		record, err := reader.Next()
+		if len(csvio.TrimLineEnd(record)) > 0 {
+			rows++
+			bytes += int64(len(record))
+		}
+		if err == io.EOF {
+			break
 		}
+		if err != nil {
+			return 0, fmt.Errorf("measure rows: %w", err)
+		}
+	}
+	if rows == 0 {
+		return BlockSize, nil
 	}
+	n := targetBytes * rows / bytes
+	return uint32(min(max(n, MinRowsPerBlock), MaxRowsPerBlock)), nil
 }
 
-// inferColumnType is a helper for testing type inference logic
-func inferColumnType(values []string) ColumnType {
-	numericCount := 0
-	nonEmptyCount := 0
-	for _, v := range values {
-		if v == "" {
+// resolveColumnOrdinals maps requested column names to their header positions,
+// returned in header order. An empty request selects every column.
+func resolveColumnOrdinals(headers, requested []string) ([]int, error) {
+	if len(requested) == 0 {
+		ordinals := make([]int, len(headers))
+		for i := range ordinals {
+			ordinals[i] = i
+		}
+		return ordinals, nil
+	}
+
+	seen := make(map[int]bool, len(requested))
+	ordinals := make([]int, 0, len(requested))
+	for _, name := range requested {
+		ord := -1
+		for i, h := range headers {
+			if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(name)) {
+				ord = i
+				break
+			}
+		}
+		if ord == -1 {
+			return nil, fmt.Errorf("column %q not found in CSV header", name)
+		}
+		if !seen[ord] {
+			seen[ord] = true
+			ordinals = append(ordinals, ord)
+		}
+	}
+	sort.Ints(ordinals)
+	return ordinals, nil
+}
+
+// Type inference samples typeSampleWindows stretches spread evenly over the
+// data section, so a column that changes type part way through the file is
+// seen. Each stretch reads blockSize/typeSampleWindows rows, but at least
+// minSampleRows so small files are read in full.
+const (
+	typeSampleWindows = 16
+	minSampleRows     = 256
+)
+
+// sampleColumnTypes infers column types from rows sampled across the data
+// section [start, end). Both builders use it, so they agree on every type.
+// Stretches begin at line starts; one that lands inside a quoted field may
+// misparse a few rows, which only shifts the sample, never the results.
+func sampleColumnTypes(f io.ReaderAt, csvPath string, start, end int64, ordinals []int, blockSize uint32, numbers datatype.NumberFormat, encoding string, dialect csvio.Dialect) ([]ColumnType, error) {
+	accs := newAccumulators(len(ordinals), numbers)
+	decoder := newRecordDecoder(encoding, dialect)
+	perWindow := max(blockSize/typeSampleWindows, minSampleRows)
+	step := (end - start) / typeSampleWindows
+
+	prevEnd := start
+	for w := int64(0); w < typeSampleWindows && prevEnd < end; w++ {
+		// The first stretch starts at the data section; later ones at the
+		// next line start, never re-reading rows already sampled
+		pos := start
+		if w > 0 {
+			var err error
+			if pos, err = csvio.AlignToLineStart(f, max(start+w*step, prevEnd), end); err != nil {
+				return nil, fmt.Errorf("sample types: %w", err)
+			}
+		}
+		section := io.NewSectionReader(f, pos, end-pos)
+		reader := recordReader(csvio.NewRetryReader(section, csvPath, pos), 0, dialect)
+		prevEnd = pos
+		for rows := uint32(0); rows < perWindow; {
+			rawLine, err := reader.Next()
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("sample types: %w", err)
+			}
+			rowStart := uint64(prevEnd)
+			prevEnd += int64(len(rawLine))
+			trimmed := csvio.TrimLineEnd(rawLine)
+			if len(trimmed) > 0 {
+				// Rows that don't parse are left out of the sample
+				if record, perr := decoder.parse(trimmed, rowStart); perr == nil {
+					for i, ord := range ordinals {
+						if ord < len(record) {
+							accs[i].add(record[ord], trackNumeric|trackTimestamp|trackBoolean)
+						}
+					}
+				}
+				rows++
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+	}
+
+	types := make([]ColumnType, len(ordinals))
+	for i := range accs {
+		types[i] = accs[i].inferType()
+	}
+	return types, nil
+}
+
+// columnTracks returns what each indexed column's accumulator tracks: its
+// type's representations, and sums for the columns named in sums, which
+// must be indexed and numeric
+func columnTracks(headers []string, ordinals []int, types []ColumnType, sums []string) ([]uint8, error) {
+	tracks := make([]uint8, len(ordinals))
+	for i, t := range types {
+		tracks[i] = trackFor(t)
+	}
+	for _, name := range sums {
+		col := -1
+		for i, ord := range ordinals {
+			if strings.EqualFold(strings.TrimSpace(headers[ord]), strings.TrimSpace(name)) {
+				col = i
+				break
+			}
+		}
+		if col == -1 {
+			return nil, fmt.Errorf("can't store sums of %q: column isn't indexed", name)
+		}
+		if types[col] != ColumnTypeNumeric {
+			return nil, fmt.Errorf("can't store sums of %q: column isn't numeric", name)
+		}
+		tracks[col] |= trackSum
+	}
+	return tracks, nil
+}
+
+// markMixedColumns flags numeric and timestamp columns holding values that
+// don't parse as their type. Only those keep the string bounds every block
+// collected for them.
+func markMixedColumns(columns []ColumnInfo, blocks []BlockMeta) {
+	for i := range columns {
+		if columns[i].Type != ColumnTypeString {
+			for b := range blocks {
+				if blocks[b].Columns[i].InvalidCount > 0 {
+					columns[i].Mixed = true
+					break
+				}
+			}
+		}
+		if !columns[i].Mixed {
+			for b := range blocks {
+				blocks[b].Columns[i].StrMin, blocks[b].Columns[i].StrMax = "", ""
+			}
+		}
+	}
+}
+
+// markSortedColumns flags the columns whose blocks are in order: each
+// block's typed bounds start at or after the end of the previous block's.
+// A block's bounds are its first and last value when the rows are sorted,
+// so this holds for columns sorted in the file, such as timestamps of an
+// append-only log, and a range predicate on them matches a run of blocks.
+// Blocks without values of the column's type are skipped; boolean columns
+// are never marked.
+func markSortedColumns(columns []ColumnInfo, blocks []BlockMeta) {
+	for i := range columns {
+		t := columns[i].Type
+		if t == ColumnTypeBoolean {
 			continue
 		}
-		nonEmptyCount++
-		if _, err := strconv.ParseFloat(v, 64); err == nil {
-			numericCount++
+		sorted := true
+		var prev *ColumnStats
+		for b := range blocks {
+			stats := &blocks[b].Columns[i]
+			if !hasBounds(stats, t) {
+				continue
+			}
+			if prev != nil && compareBlockBounds(prev, stats, t) > 0 {
+				sorted = false
+				break
+			}
+			prev = stats
 		}
+		columns[i].Sorted = sorted
 	}
-	// If >80% of non-empty values are numeric, treat as numeric
-	if nonEmptyCount > 0 && numericCount*5 >= nonEmptyCount*4 {
-		return ColumnTypeNumeric
+}
+
+// hasBounds reports whether a block holds values a range predicate on a
+// column of type t can match: any value of a string column, including
+// empty ones, or values that parse as the type
+func hasBounds(stats *ColumnStats, t ColumnType) bool {
+	return stats.Min != "" || stats.Max != "" || t == ColumnTypeString && stats.EmptyCount > 0
+}
+
+// compareBlockBounds orders the upper bound of block a against the lower
+// bound of block b. Empty strings are values to the evaluator, so they are
+// the lower bound of a string column's block holding any.
+func compareBlockBounds(a, b *ColumnStats, t ColumnType) int {
+	switch t {
+	case ColumnTypeNumeric:
+		return cmpOrdered(a.MaxNum, b.MinNum)
+	case ColumnTypeTimestamp:
+		return cmpOrdered(a.MaxTime, b.MinTime)
+	default:
+		lo := b.Min
+		if b.EmptyCount > 0 {
+			lo = ""
+		}
+		return strings.Compare(a.Max, lo)
 	}
-	return ColumnTypeString
+}
+
+// BlockRange returns the blocks [lo, hi) that may hold rows satisfying
+// "col op value" when col is sorted across blocks, found by binary search
+// over the block bounds instead of checking every block: each block outside
+// the range is one CanPruneBlock would skip. ok is false for a column that
+// isn't sorted, for != and unknown operators, and for a literal that doesn't
+// compare the way the bounds are ordered.
+//
+// Blocks without values of the column's type can't match and may fall on
+// either side of an edge; they take the side of the next block with values.
+func (idx *Index) BlockRange(colName, operator, value string) (lo, hi int, ok bool) {
+	col, colType, found := findColumn(idx, colName)
+	if !found || !idx.Header.Columns[col].Sorted || len(idx.Blocks) == 0 {
+		return 0, 0, false
+	}
+	kind, num, ts := predicateKind(value)
+	if kind != colType || math.IsNaN(num) {
+		return 0, 0, false
+	}
+	// Lazily loaded stats are decoded a column at a time; a column that
+	// doesn't decode has none for any block
+	if idx.Stats(&idx.Blocks[0], col) == nil {
+		return 0, 0, false
+	}
+
+	// cmpBound compares a block's lower or upper bound with the literal
+	cmpBound := func(stats *ColumnStats, upper bool) int {
+		switch {
+		case colType == ColumnTypeNumeric && upper:
+			return cmpOrdered(stats.MaxNum, num)
+		case colType == ColumnTypeNumeric:
+			return cmpOrdered(stats.MinNum, num)
+		case colType == ColumnTypeTimestamp && upper:
+			return cmpOrdered(stats.MaxTime, ts)
+		case colType == ColumnTypeTimestamp:
+			return cmpOrdered(stats.MinTime, ts)
+		case upper:
+			return strings.Compare(stats.Max, value)
+		case stats.EmptyCount > 0:
+			return strings.Compare("", value)
+		default:
+			return strings.Compare(stats.Min, value)
+		}
+	}
+	// search returns the first block whose bound satisfies f; over sorted
+	// blocks f goes from false to true once
+	search := func(f func(stats *ColumnStats) bool) int {
+		return sort.Search(len(idx.Blocks), func(i int) bool {
+			for ; i < len(idx.Blocks); i++ {
+				if stats := idx.Stats(&idx.Blocks[i], col); hasBounds(stats, colType) {
+					return f(stats)
+				}
+			}
+			return true
+		})
+	}
+	// Blocks before lo end below the matches, blocks from hi on start above
+	lo, hi = 0, len(idx.Blocks)
+	switch operator {
+	case "=":
+		lo = search(func(s *ColumnStats) bool { return cmpBound(s, true) >= 0 })
+		hi = search(func(s *ColumnStats) bool { return cmpBound(s, false) > 0 })
+	case ">":
+		lo = search(func(s *ColumnStats) bool { return cmpBound(s, true) > 0 })
+	case ">=":
+		lo = search(func(s *ColumnStats) bool { return cmpBound(s, true) >= 0 })
+	case "<":
+		hi = search(func(s *ColumnStats) bool { return cmpBound(s, false) >= 0 })
+	case "<=":
+		hi = search(func(s *ColumnStats) bool { return cmpBound(s, false) > 0 })
+	default:
+		return 0, 0, false
+	}
+	return lo, max(lo, hi), true
+}
+
+// inferColumnType is a helper for testing type inference logic
+func inferColumnType(values []string) ColumnType {
+	var acc columnAccumulator
+	for _, v := range values {
+		acc.add(v, trackAll)
+	}
+	return acc.inferType()
+}
+
+// distinctEstimate reads a sketch, capped at the row count since small
+// cardinalities can overshoot slightly
+func distinctEstimate(h *hyperLogLog, rows uint64) uint64 {
+	est := h.estimate()
+	if est > rows {
+		est = rows
+	}
+	return est
+}
+
+// isMostlyNumeric applies the type inference threshold:
+// a column is numeric if at least 80% of its non-empty values parse as numbers
+func isMostlyNumeric(numericCount, nonEmptyCount int) bool {
+	return isMostlyType(numericCount, nonEmptyCount)
+}
+
+// isMostlyType reports whether at least 80% of non-empty values parsed as a type
+func isMostlyType(matchCount, nonEmptyCount int) bool {
+	return nonEmptyCount > 0 && matchCount*5 >= nonEmptyCount*4
 }
 
 func (b *Builder) BuildFromFile(csvPath string) (*Index, error) {
+	if err := checkEncoding(b.encoding); err != nil {
+		return nil, err
+	}
 	f, err := os.Open(csvPath)
 	if err != nil {
 		return nil, err
@@ -100,51 +540,97 @@ func (b *Builder) BuildFromFile(csvPath string) (*Index, error) {
 
 	fileSize := stat.Size()
 	fileMtime := stat.ModTime().UnixNano()
+	checksum, err := fingerprintReader(f, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	if b.dialect, b.noHeader, err = sniffInput(f, b.encoding, b.dialect, b.noHeader, b.header); err != nil {
+		return nil, err
+	}
 
-	reader := bufio.NewReaderSize(f, 2*1024*1024) // 2MB buffer for better throughput
+	// 2MB buffer for better throughput; records may span lines inside quotes
+	reader := recordReader(csvio.NewRetryReader(io.NewSectionReader(f, 0, fileSize), csvPath, 0), 2*1024*1024, b.dialect)
 	offset := int64(0)
 
-	// Read header line
-	headerLine, err := reader.ReadBytes('\n')
+	// Read header record
+	headerLine, err := reader.Next()
 	if err != nil && err != io.EOF {
 		return nil, fmt.Errorf("read header: %w", err)
 	}
-	headerRecord, perr := parseCSVLine(headerLine)
+	decoder := newRecordDecoder(b.encoding, b.dialect)
+	headerRecord, perr := decoder.parse(csvio.TrimLineEnd(headerLine), 0)
 	if perr != nil {
 		return nil, fmt.Errorf("parse header: %w", perr)
 	}
 
 	b.headers = make([]string, len(headerRecord))
 	copy(b.headers, headerRecord)
+	if b.noHeader {
+		// Name the columns after the first record's width, then start over
+		// so that record is indexed as data
+		b.headers = csvio.ColumnNames(b.headerNames, len(headerRecord))
+		reader = recordReader(csvio.NewRetryReader(io.NewSectionReader(f, 0, fileSize), csvPath, 0), 2*1024*1024, b.dialect)
+		headerLine = nil
+	}
 
-	numCols := len(b.headers)
-	b.columnMins = make([]string, numCols)
-	b.columnMaxs = make([]string, numCols)
-	b.columnEmptyCounts = make([]uint32, numCols)
+	b.ordinals, err = resolveColumnOrdinals(b.headers, b.columns)
+	if err != nil {
+		return nil, err
+	}
+
+	numCols := len(b.ordinals)
+	b.columnStats = newAccumulators(numCols, b.numbers)
 	b.columnTypes = make([]ColumnType, numCols)
+	b.sketches = make([]hyperLogLog, numCols)
 
-	// Type inference during first block (unless skipped)
+	// Types are settled before the scan so every block tracks only its
+	// column's representation
 	if !b.skipTypeInference {
-		b.typeInferenceActive = true
-		b.numericCounts = make([]int, numCols)
-		b.nonEmptyCounts = make([]int, numCols)
+		b.columnTypes, err = sampleColumnTypes(f, csvPath, int64(len(headerLine)), fileSize, b.ordinals, b.blockSize, b.numbers, b.encoding, b.dialect)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b.columnTracks, err = columnTracks(b.headers, b.ordinals, b.columnTypes, b.sumColumns)
+	if err != nil {
+		return nil, err
 	}
-
-	// Initialize reusable CSV parser
-	b.csvBuffer = bytes.NewReader(nil)
-	b.csvReader = csv.NewReader(b.csvBuffer)
-	b.csvReader.FieldsPerRecord = -1
 
 	offset += int64(len(headerLine))
 	b.blockStartRow = 0
 	b.blockStartOffset = uint64(offset)
 	b.lastRowEndOffset = b.blockStartOffset
 
+	lastCheckpoint := offset
+	if b.checkpointPath != "" {
+		ck, err := readCheckpoint(b.checkpointPath)
+		if err == nil && ck.resumes(&b.index(fileSize, fileMtime, checksum).Header) {
+			// Pick up after the last finished block, as if the scan had
+			// just flushed it
+			last := ck.index.Blocks[len(ck.index.Blocks)-1]
+			b.blocks = ck.index.Blocks
+			b.sketches = ck.sketches
+			b.currentRow, b.resumedRows = last.EndRow, last.EndRow
+			b.blockStartRow = last.EndRow
+			b.blockStartOffset, b.lastRowEndOffset = last.EndOffset, last.EndOffset
+			offset, lastCheckpoint = int64(last.EndOffset), int64(last.EndOffset)
+			section := io.NewSectionReader(f, offset, fileSize-offset)
+			reader = recordReader(csvio.NewRetryReader(section, csvPath, offset), 2*1024*1024, b.dialect)
+		}
+	}
+	saveCheckpoint := func() error {
+		if err := writeCheckpoint(b.checkpointPath, b.index(fileSize, fileMtime, checksum), b.sketches); err != nil {
+			return err
+		}
+		lastCheckpoint = offset
+		return nil
+	}
+
 	rowInBlock := uint32(0)
 
 	for {
 		rowStart := uint64(offset)
-		rawLine, err := reader.ReadBytes('\n')
+		rawLine, err := reader.Next()
 		if err == io.EOF && len(rawLine) == 0 {
 			break
 		}
@@ -152,7 +638,7 @@ func (b *Builder) BuildFromFile(csvPath string) (*Index, error) {
 			return nil, fmt.Errorf("read row %d: %w", b.currentRow, err)
 		}
 
-		trimmed := bytes.TrimRight(rawLine, "\r\n")
+		trimmed := csvio.TrimLineEnd(rawLine)
 		if len(trimmed) == 0 {
 			if err == io.EOF {
 				break
@@ -161,38 +647,28 @@ func (b *Builder) BuildFromFile(csvPath string) (*Index, error) {
 			continue
 		}
 
-		// Reuse CSV reader to avoid allocations
-		b.csvBuffer.Reset(trimmed)
-		record, perr := b.csvReader.Read()
+		record, perr := decoder.parse(trimmed, rowStart)
 		if perr != nil {
 			return nil, fmt.Errorf("parse row %d: %w", b.currentRow, perr)
 		}
+		if b.strict && len(record) != len(b.headers) {
+			return nil, fmt.Errorf("line %d: expected %d fields, got %d", recordLine(b.currentRow, b.noHeader), len(b.headers), len(record))
+		}
 
 		if rowInBlock == 0 {
 			b.blockStartOffset = rowStart
 		}
 
-		for i := 0; i < numCols && i < len(record); i++ {
-			value := record[i]
-			if value == "" {
-				b.columnEmptyCounts[i]++
-				continue
-			}
-
-			if b.columnMins[i] == "" || value < b.columnMins[i] {
-				b.columnMins[i] = value
+		for i, ord := range b.ordinals {
+			// Short rows count as empty so EmptyCount covers every row without a value
+			value := ""
+			if ord < len(record) {
+				value = record[ord]
 			}
-			if b.columnMaxs[i] == "" || value > b.columnMaxs[i] {
-				b.columnMaxs[i] = value
-			}
-
-			// Type inference during first block
-			if b.typeInferenceActive {
-				b.nonEmptyCounts[i]++
-				if _, err := strconv.ParseFloat(value, 64); err == nil {
-					b.numericCounts[i]++
-				}
+			if value != "" {
+				b.sketches[i].add(value)
 			}
+			b.columnStats[i].add(value, b.columnTracks[i])
 		}
 
 		b.currentRow++
@@ -200,14 +676,26 @@ func (b *Builder) BuildFromFile(csvPath string) (*Index, error) {
 		offset += int64(len(rawLine))
 		b.lastRowEndOffset = uint64(offset)
 
+		if b.progress != nil && b.currentRow%progressEveryRows == 0 {
+			b.progress(offset, b.currentRow)
+		}
+
 		if rowInBlock >= b.blockSize {
 			b.flushBlock()
 			rowInBlock = 0
 
-			// Type inference complete after first block
-			if b.typeInferenceActive {
-				b.finalizeTypeInference()
-				b.typeInferenceActive = false
+			if canceled(b.cancel) {
+				if b.checkpointPath != "" {
+					if err := saveCheckpoint(); err != nil {
+						return nil, err
+					}
+				}
+				return nil, ErrCanceled
+			}
+			if b.checkpointPath != "" && offset-lastCheckpoint >= checkpointEveryBytes {
+				if err := saveCheckpoint(); err != nil {
+					return nil, err
+				}
 			}
 		}
 
@@ -220,36 +708,60 @@ func (b *Builder) BuildFromFile(csvPath string) (*Index, error) {
 		b.flushBlock()
 	}
 
-	// Finalize type inference if we never hit a full block
-	if b.typeInferenceActive {
-		b.finalizeTypeInference()
-		b.typeInferenceActive = false
-	} else if b.skipTypeInference {
-		// Set all columns to string type
-		for i := range b.columnTypes {
-			b.columnTypes[i] = ColumnTypeString
-		}
+	if b.progress != nil {
+		b.progress(fileSize, b.currentRow)
 	}
 
-	columns := make([]ColumnInfo, numCols)
+	index := b.index(fileSize, fileMtime, checksum)
+	markMixedColumns(index.Header.Columns, index.Blocks)
+	markSortedColumns(index.Header.Columns, index.Blocks)
+	return index, nil
+}
+
+// index returns the index of the blocks flushed so far, before
+// markMixedColumns drops the string bounds of columns that don't need them
+func (b *Builder) index(fileSize, fileMtime int64, checksum uint64) *Index {
+	columns := make([]ColumnInfo, len(b.ordinals))
 	for i := range columns {
 		columns[i] = ColumnInfo{
-			Name: b.headers[i],
-			Type: b.columnTypes[i],
+			Name:     b.headers[b.ordinals[i]],
+			Type:     b.columnTypes[i],
+			Ordinal:  uint32(b.ordinals[i]),
+			Distinct: distinctEstimate(&b.sketches[i], b.currentRow),
+			Sums:     b.columnTracks[i]&trackSum != 0,
 		}
 	}
 
+	var flags BuildFlags
+	if b.skipTypeInference {
+		flags |= BuildFlagSkipTypeInference
+	}
+	if len(b.ordinals) < len(b.headers) {
+		flags |= BuildFlagPartialColumns
+	}
+	if b.noHeader {
+		flags |= BuildFlagNoHeader
+	}
+	if b.encoding == csvio.EncodingLatin1 {
+		flags |= BuildFlagLatin1
+	}
+
 	return &Index{
 		Header: Header{
-			Version:   Version,
-			BlockSize: b.blockSize,
-			NumBlocks: uint32(len(b.blocks)),
-			FileSize:  fileSize,
-			FileMtime: fileMtime,
-			Columns:   columns,
+			Version:      Version,
+			BlockSize:    b.blockSize,
+			NumBlocks:    uint32(len(b.blocks)),
+			FileSize:     fileSize,
+			FileMtime:    fileMtime,
+			FileChecksum: checksum,
+			TotalRows:    b.currentRow,
+			Columns:      columns,
+			BuildFlags:   flags,
+			Numbers:      b.numbers,
+			Dialect:      b.dialect,
 		},
 		Blocks: b.blocks,
-	}, nil
+	}
 }
 
 func (b *Builder) flushBlock() {
@@ -265,16 +777,13 @@ func (b *Builder) flushBlock() {
 		panic(fmt.Sprintf("invalid block: EndOffset (%d) < StartOffset (%d)", b.lastRowEndOffset, b.blockStartOffset))
 	}
 
-	cols := make([]ColumnStats, len(b.headers))
-	for i := range b.headers {
+	cols := make([]ColumnStats, len(b.ordinals))
+	for i, ord := range b.ordinals {
+		cols[i] = b.columnStats[i].stats(b.columnTypes[i])
+
 		// Validate min <= max when both present
-		if b.columnMins[i] != "" && b.columnMaxs[i] != "" && b.columnMins[i] > b.columnMaxs[i] {
-			panic(fmt.Sprintf("invalid block: column %q has min > max (%q > %q)", b.headers[i], b.columnMins[i], b.columnMaxs[i]))
-		}
-		cols[i] = ColumnStats{
-			Min:        b.columnMins[i],
-			Max:        b.columnMaxs[i],
-			EmptyCount: b.columnEmptyCounts[i],
+		if cols[i].Min != "" && compareBounds(&cols[i], b.columnTypes[i]) > 0 {
+			panic(fmt.Sprintf("invalid block: column %q has min > max (%q > %q)", b.headers[ord], cols[i].Min, cols[i].Max))
 		}
 	}
 
@@ -289,98 +798,268 @@ func (b *Builder) flushBlock() {
 
 	b.blockStartRow = b.currentRow
 	b.blockStartOffset = b.lastRowEndOffset
-	for i := range b.columnMins {
-		b.columnMins[i] = ""
-		b.columnMaxs[i] = ""
-		b.columnEmptyCounts[i] = 0
+	for i := range b.columnStats {
+		b.columnStats[i].reset()
 	}
 }
 
-// CanPruneBlock determines if a block can be skipped based on predicate
-// Requires index with column dictionary for type information
-func CanPruneBlock(index *Index, block *BlockMeta, colName, operator, value string) bool {
-	colName = strings.ToLower(colName)
+// negatedOperators maps each comparison to the one matching exactly the rows it rejects
+var negatedOperators = map[string]string{
+	"=":  "!=",
+	"!=": "=",
+	">":  "<=",
+	">=": "<",
+	"<":  ">=",
+	"<=": ">",
+}
 
-	// Find column index in dictionary
-	colIdx := -1
-	var colType ColumnType
-	for i, col := range index.Header.Columns {
-		if strings.ToLower(col.Name) == colName {
-			colIdx = i
-			colType = col.Type
-			break
-		}
+// predicateKind classifies a literal the way sqlparser.Comparison evaluates it:
+// numbers compare numerically, dates chronologically, anything else as strings.
+func predicateKind(value string) (ColumnType, float64, int64) {
+	if num, err := strconv.ParseFloat(value, 64); err == nil {
+		return ColumnTypeNumeric, num, 0
 	}
-
-	if colIdx == -1 || colIdx >= len(block.Columns) {
-		return false // Column not found, can't prune
+	if ts, ok := datatype.ParseTimestamp(value); ok {
+		return ColumnTypeTimestamp, 0, ts
 	}
+	return ColumnTypeString, 0, 0
+}
 
-	stats := &block.Columns[colIdx]
-	min := stats.Min
-	max := stats.Max
-
-	// If stats are empty but we have non-empty count info, check if block is all-empty
-	if min == "" && max == "" {
-		// If EmptyCount equals block size, all values are empty
-		blockSize := block.EndRow - block.StartRow
-		// Only use EmptyCount if it's meaningful (blockSize > 0 and EmptyCount > 0)
-		if blockSize > 0 && stats.EmptyCount > 0 && stats.EmptyCount == uint32(blockSize) {
-			// All empty: can prune for any operator except != empty
-			if operator == "=" && value != "" {
-				return true // Looking for non-empty value in all-empty column
-			}
+// findColumn returns the dictionary position and type of a column
+func findColumn(index *Index, colName string) (int, ColumnType, bool) {
+	for i, col := range index.Header.Columns {
+		if strings.EqualFold(col.Name, strings.TrimSpace(colName)) {
+			return i, col.Type, true
 		}
-		return false // Can't prune safely otherwise
 	}
+	return -1, 0, false
+}
 
-	// Use type-aware comparison
-	compare := func(a, b string) int {
-		if colType == ColumnTypeNumeric {
-			aNum, aErr := strconv.ParseFloat(a, 64)
-			bNum, bErr := strconv.ParseFloat(b, 64)
-			if aErr == nil && bErr == nil {
-				if aNum < bNum {
-					return -1
-				} else if aNum > bNum {
-					return 1
-				}
-				return 0
-			}
-		}
-		// Fall back to lexicographic
-		if a < b {
-			return -1
-		} else if a > b {
-			return 1
-		}
-		return 0
+// compareBounds orders a block's min against its max using the column type
+func compareBounds(stats *ColumnStats, t ColumnType) int {
+	switch t {
+	case ColumnTypeNumeric, ColumnTypeBoolean:
+		return cmpOrdered(stats.MinNum, stats.MaxNum)
+	case ColumnTypeTimestamp:
+		return cmpOrdered(stats.MinTime, stats.MaxTime)
+	default:
+		return strings.Compare(stats.Min, stats.Max)
 	}
+}
 
+func cmpOrdered[T float64 | int64](a, b T) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// pruneRange decides whether no value in [min, max] can satisfy "x op value",
+// given value compared against min (cmpMin) and max (cmpMax)
+func pruneRange(operator string, cmpMin, cmpMax int, constant bool) bool {
 	switch operator {
 	case "=":
 		// Can prune if value is outside [min, max] range
-		return compare(value, min) < 0 || compare(value, max) > 0
+		return cmpMin < 0 || cmpMax > 0
 	case "!=":
 		// Can only prune if min == max == value (entire block is that value)
-		return compare(min, max) == 0 && compare(min, value) == 0
+		return constant && cmpMin == 0
 	case ">":
 		// Can prune if value >= max (all values are <= max)
-		return compare(value, max) >= 0
+		return cmpMax >= 0
 	case ">=":
 		// Can prune if value > max
-		return compare(value, max) > 0
+		return cmpMax > 0
 	case "<":
 		// Can prune if value <= min
-		return compare(value, min) <= 0
+		return cmpMin <= 0
 	case "<=":
 		// Can prune if value < min
-		return compare(value, min) < 0
+		return cmpMin < 0
 	default:
 		return false
 	}
 }
 
+// CanPruneBlockNot determines if a block can be skipped for NOT (col op value),
+// which holds when every row in the block satisfies the comparison.
+//
+// Every row must have a value of the column's type: empty, missing, and
+// unparseable values evaluate false under the comparison (so true under NOT).
+// With that established, "all rows satisfy op" is "no row satisfies the negation".
+func CanPruneBlockNot(index *Index, block *BlockMeta, colName, operator, value string) bool {
+	negated, ok := negatedOperators[operator]
+	if !ok {
+		return false
+	}
+	colIdx, colType, found := findColumn(index, colName)
+	if !found {
+		return false
+	}
+	stats := index.Stats(block, colIdx)
+	if stats == nil {
+		return false
+	}
+	kind, num, _ := predicateKind(value)
+	if math.IsNaN(num) {
+		return false
+	}
+	if kind != colType {
+		// A mixed column's string bounds cover every non-empty value
+		if kind != ColumnTypeString || !index.Header.Columns[colIdx].Mixed || stats.EmptyCount > 0 || stats.StrMin == "" {
+			return false
+		}
+		return CanPruneBlock(index, block, colName, negated, value)
+	}
+	if stats.EmptyCount > 0 || stats.InvalidCount > 0 || stats.Min == "" {
+		return false
+	}
+
+	return CanPruneBlock(index, block, colName, negated, value)
+}
+
+// CanPruneBlock determines if a block can be skipped based on predicate
+// Requires index with column dictionary for type information
+//
+// Bounds are only used when they order values the same way the evaluator
+// does: numeric predicates against numeric columns, date predicates against
+// timestamp columns, and string predicates against string columns. When the
+// bounds can't rule the block out, the column's key map may.
+func CanPruneBlock(index *Index, block *BlockMeta, colName, operator, value string) bool {
+	if canPruneBounds(index, block, colName, operator, value) {
+		return true
+	}
+	prune, _ := canPruneKeys(index, blockNumber(index, block), colName, operator, value)
+	return prune
+}
+
+// canPruneBounds decides a predicate from a block's bounds
+func canPruneBounds(index *Index, block *BlockMeta, colName, operator, value string) bool {
+	colIdx, colType, found := findColumn(index, colName)
+	if !found {
+		return false // Column not found, can't prune
+	}
+	stats := index.Stats(block, colIdx)
+	if stats == nil {
+		return false
+	}
+	kind, num, ts := predicateKind(value)
+	if math.IsNaN(num) {
+		return false
+	}
+	if kind != colType {
+		// Mixed columns also keep string bounds over all their values
+		if kind == ColumnTypeString && index.Header.Columns[colIdx].Mixed {
+			return canPruneStrings(block, stats.StrMin, stats.StrMax, stats.EmptyCount, operator, value)
+		}
+		return false // Bounds don't order values the way the predicate compares them
+	}
+	if colType == ColumnTypeString {
+		return canPruneStrings(block, stats.Min, stats.Max, stats.EmptyCount, operator, value)
+	}
+
+	// If stats are empty but we have non-empty count info, check if block is all-empty
+	if stats.Min == "" && stats.Max == "" {
+		blockSize := block.EndRow - block.StartRow
+		// No value parses, so no row can satisfy a numeric or date comparison
+		return blockSize > 0 && stats.EmptyCount+stats.InvalidCount == uint32(blockSize)
+	}
+
+	constant := compareBounds(stats, colType) == 0
+	switch colType {
+	case ColumnTypeNumeric:
+		// Unparseable values never match, except that NaN matches !=
+		if operator == "!=" && stats.InvalidCount > 0 {
+			return false
+		}
+		return pruneRange(operator, cmpOrdered(num, stats.MinNum), cmpOrdered(num, stats.MaxNum), constant)
+	default:
+		return pruneRange(operator, cmpOrdered(ts, stats.MinTime), cmpOrdered(ts, stats.MaxTime), constant)
+	}
+}
+
+// CanPruneBlockBool determines if a block can be skipped for a predicate that
+// holds when a boolean column equals want (WHERE active, active = false).
+// Only boolean columns qualify; their bounds are 0 for false and 1 for true.
+func CanPruneBlockBool(index *Index, block *BlockMeta, colName string, want bool) bool {
+	colIdx, colType, found := findColumn(index, colName)
+	if !found || colType != ColumnTypeBoolean {
+		return false
+	}
+	stats := index.Stats(block, colIdx)
+	if stats == nil {
+		return false
+	}
+	if stats.Min == "" && stats.Max == "" {
+		// No value reads as a boolean, so none can match
+		blockSize := block.EndRow - block.StartRow
+		return blockSize > 0 && stats.EmptyCount+stats.InvalidCount == uint32(blockSize)
+	}
+	if want {
+		return stats.MaxNum == 0
+	}
+	return stats.MinNum == 1
+}
+
+// CanPruneBlockBoolNot determines if a block can be skipped for the negation
+// of such a predicate (WHERE NOT active), which needs every row to hold want
+func CanPruneBlockBoolNot(index *Index, block *BlockMeta, colName string, want bool) bool {
+	colIdx, colType, found := findColumn(index, colName)
+	if !found || colType != ColumnTypeBoolean {
+		return false
+	}
+	stats := index.Stats(block, colIdx)
+	if stats == nil {
+		return false
+	}
+	if stats.EmptyCount > 0 || stats.InvalidCount > 0 || stats.Min == "" {
+		return false
+	}
+	return CanPruneBlockBool(index, block, colName, !want)
+}
+
+// CanPruneBlockRows determines if a block can be skipped for a numeric
+// predicate on the row number, where the block's first row is numbered
+// first+StartRow. Every block knows its row range, so no column stats are
+// needed and every row has a number: negated is true for NOT (row op value).
+func CanPruneBlockRows(block *BlockMeta, first uint64, operator string, value float64, negated bool) bool {
+	if negated {
+		op, ok := negatedOperators[operator]
+		if !ok {
+			return false
+		}
+		operator = op
+	}
+	if block.EndRow <= block.StartRow || math.IsNaN(value) {
+		return false
+	}
+	lo, hi := float64(first+block.StartRow), float64(first+block.EndRow-1)
+	return pruneRange(operator, cmpOrdered(value, lo), cmpOrdered(value, hi), lo == hi)
+}
+
+// canPruneStrings decides a string predicate from the lexicographic bounds
+// of a block's non-empty values and its count of empty values
+func canPruneStrings(block *BlockMeta, min, max string, empty uint32, operator, value string) bool {
+	if min == "" && max == "" {
+		// All empty: can prune for any operator except != empty
+		blockSize := block.EndRow - block.StartRow
+		if blockSize == 0 || empty != uint32(blockSize) {
+			return false // Can't prune safely otherwise
+		}
+		return operator == "=" && value != ""
+	}
+
+	// Empty values are real strings to the evaluator and sort before everything
+	constant := min == max
+	if empty > 0 {
+		min = ""
+		constant = false
+	}
+	return pruneRange(operator, strings.Compare(value, min), strings.Compare(value, max), constant)
+}
+
 // ValidateIndex checks if index is still valid for the given CSV file
 func ValidateIndex(index *Index, csvPath string) error {
 	stat, err := os.Stat(csvPath)
@@ -393,12 +1072,24 @@ func ValidateIndex(index *Index, csvPath string) error {
 			index.Header.FileSize, stat.Size())
 	}
 
-	if stat.ModTime().UnixNano() != index.Header.FileMtime {
+	// v6+ indexes compare content fingerprints, so copies that reset mtime stay
+	// valid and edits that preserve mtime are still caught
+	if index.Header.Version >= 6 {
+		checksum, err := Fingerprint(csvPath)
+		if err != nil {
+			return fmt.Errorf("fingerprint CSV: %w", err)
+		}
+		if checksum != index.Header.FileChecksum {
+			return fmt.Errorf("file content changed since index built")
+		}
+	} else if stat.ModTime().UnixNano() != index.Header.FileMtime {
 		return fmt.Errorf("file modified since index built")
 	}
 
-	// Validate that CSV header matches index columns (only if columns are defined)
-	if len(index.Header.Columns) > 0 {
+	// Validate that CSV header matches index columns (only if columns are
+	// defined). Headerless files have no names to compare; the content
+	// fingerprint already covers their first row.
+	if len(index.Header.Columns) > 0 && index.Header.BuildFlags&BuildFlagNoHeader == 0 {
 		f, err := os.Open(csvPath)
 		if err != nil {
 			return fmt.Errorf("open CSV for header check: %w", err)
@@ -409,26 +1100,35 @@ func ValidateIndex(index *Index, csvPath string) error {
 			}
 		}()
 
-		reader := bufio.NewReader(f)
-		headerLine, err := reader.ReadBytes('\n')
+		reader := recordReader(csvio.NewRetryReader(f, csvPath, 0), 0, index.Header.Dialect)
+		headerLine, err := reader.Next()
 		if err != nil && err != io.EOF {
 			return fmt.Errorf("read CSV header: %w", err)
 		}
 
-		headerRecord, err := parseCSVLine(bytes.TrimRight(headerLine, "\r\n"))
+		encoding := csvio.EncodingUTF8
+		if index.Header.BuildFlags&BuildFlagLatin1 != 0 {
+			encoding = csvio.EncodingLatin1
+		}
+		decoder := newRecordDecoder(encoding, index.Header.Dialect)
+		headerRecord, err := decoder.parse(csvio.TrimLineEnd(headerLine), 0)
 		if err != nil {
 			return fmt.Errorf("parse CSV header: %w", err)
 		}
 
-		if len(headerRecord) != len(index.Header.Columns) {
+		if len(headerRecord) < len(index.Header.Columns) {
 			return fmt.Errorf("column count mismatch: CSV has %d columns, index has %d",
 				len(headerRecord), len(index.Header.Columns))
 		}
 
-		for i, csvCol := range headerRecord {
-			if !strings.EqualFold(strings.TrimSpace(csvCol), index.Header.Columns[i].Name) {
+		for _, col := range index.Header.Columns {
+			if int(col.Ordinal) >= len(headerRecord) {
+				return fmt.Errorf("column %q not found: CSV has %d columns", col.Name, len(headerRecord))
+			}
+			csvCol := headerRecord[col.Ordinal]
+			if !strings.EqualFold(strings.TrimSpace(csvCol), col.Name) {
 				return fmt.Errorf("column %d mismatch: CSV has %q, index has %q",
-					i, csvCol, index.Header.Columns[i].Name)
+					col.Ordinal, csvCol, col.Name)
 			}
 		}
 	}
@@ -436,8 +1136,67 @@ func ValidateIndex(index *Index, csvPath string) error {
 	return nil
 }
 
-func parseCSVLine(raw []byte) ([]string, error) {
-	r := csv.NewReader(bytes.NewReader(raw))
-	r.FieldsPerRecord = -1
-	return r.Read()
+// recordDecoder turns raw records into the UTF-8 text that is parsed: it
+// drops the byte order mark from the record at the start of the file and
+// decodes latin1
+type recordDecoder struct {
+	encoding string
+	buf      []byte
+	parser   *csvio.RecordParser
+}
+
+func newRecordDecoder(encoding string, dialect csvio.Dialect) *recordDecoder {
+	return &recordDecoder{encoding: encoding, parser: csvio.NewRecordParser(dialect)}
+}
+
+// parse returns the fields of the raw record starting at offset
+func (d *recordDecoder) parse(raw []byte, offset uint64) ([]string, error) {
+	return d.parser.Parse(d.decode(raw, offset))
+}
+
+// decode returns the text of the raw record starting at offset. The result
+// is only valid until the next call.
+func (d *recordDecoder) decode(raw []byte, offset uint64) []byte {
+	if offset == 0 {
+		raw = csvio.TrimBOM(raw)
+	}
+	if d.encoding != csvio.EncodingLatin1 {
+		return raw
+	}
+	d.buf = csvio.Decode(d.buf, raw, d.encoding)
+	return d.buf
+}
+
+// checkEncoding rejects encodings whose records can't be found at raw file
+// offsets, which is what blocks record
+func checkEncoding(encoding string) error {
+	if !csvio.SameOffsets(encoding) {
+		return fmt.Errorf("%s files can't be indexed; convert them to UTF-8 (e.g. iconv -f UTF-16 -t UTF-8)", encoding)
+	}
+	return nil
+}
+
+// recordReader reads the records of r, quoted as d says, with a read
+// buffer of size bytes (64KB when zero)
+func recordReader(r io.Reader, size int, d csvio.Dialect) *csvio.RecordReader {
+	if size <= 0 {
+		size = 64 * 1024
+	}
+	reader := csvio.NewRecordReaderSize(r, size)
+	reader.SetDialect(d)
+	return reader
+}
+
+// sniffInput fills in what a build leaves open, as the engine does for a
+// query: the dialect's zero fields, and unless noHeader or header is set,
+// whether the first row is a header
+func sniffInput(f io.ReaderAt, encoding string, given csvio.Dialect, noHeader, header bool) (csvio.Dialect, bool, error) {
+	d, hasHeader, err := csvio.SniffFile(io.NewSectionReader(f, 0, csvio.SniffSize), encoding, given)
+	if err != nil {
+		return given, noHeader, fmt.Errorf("sniff dialect: %w", err)
+	}
+	if !noHeader && !header {
+		noHeader = !hasHeader
+	}
+	return d, noHeader, nil
 }
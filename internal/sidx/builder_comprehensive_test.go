@@ -1,10 +1,15 @@
 package sidx
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestCanPruneBlock_RangeQueries tests various range query scenarios
@@ -32,7 +37,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "numeric_equals_outside_range_low",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -45,7 +50,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "numeric_equals_outside_range_high",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -58,7 +63,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "numeric_equals_inside_range",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -73,7 +78,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "numeric_greater_than_max",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -86,7 +91,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "numeric_greater_than_below_max",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -101,7 +106,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "numeric_less_than_min",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -114,7 +119,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "numeric_less_than_above_min",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -129,7 +134,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "string_equals_outside_range",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "alice", Max: "zoe"},
 				},
 			},
@@ -142,7 +147,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "string_equals_inside_range",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "alice", Max: "zoe"},
 				},
 			},
@@ -172,7 +177,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "not_equals_constant_block_match",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "100"}, // All rows have value 100
+					{Min: "100", Max: "100", MinNum: 100, MaxNum: 100}, // All rows have value 100
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -185,7 +190,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "not_equals_constant_block_no_match",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "100"},
+					{Min: "100", Max: "100", MinNum: 100, MaxNum: 100},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -198,7 +203,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "not_equals_range_block",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -213,7 +218,7 @@ func TestCanPruneBlock_RangeQueries(t *testing.T) {
 			name: "column_not_found",
 			block: BlockMeta{
 				Columns: []ColumnStats{
-					{Min: "100", Max: "200"},
+					{Min: "100", Max: "200", MinNum: 100, MaxNum: 200},
 					{Min: "a", Max: "z"},
 				},
 			},
@@ -248,8 +253,8 @@ func TestNumericVsStringComparison(t *testing.T) {
 
 	block := BlockMeta{
 		Columns: []ColumnStats{
-			{Min: "10", Max: "100"}, // Numeric: 10-100
-			{Min: "10", Max: "100"}, // String: lexicographic
+			{Min: "10", Max: "100", MinNum: 10, MaxNum: 100}, // Numeric: 10-100
+			{Min: "10", Max: "100"},                          // String: lexicographic
 		},
 	}
 
@@ -258,9 +263,15 @@ func TestNumericVsStringComparison(t *testing.T) {
 		t.Error("Expected to prune: numeric 9 < 10")
 	}
 
-	// String: "9" > "100" lexicographically, should prune (9 is greater than max)
-	if !CanPruneBlock(idx, &block, "string_col", "=", "9") {
-		t.Error("Expected to prune: string '9' > '100' lexicographically")
+	// String column, numeric literal: the evaluator compares numerically, and
+	// lexicographic bounds can't rule that out ("9.0" = 9 sorts after "100")
+	if CanPruneBlock(idx, &block, "string_col", "=", "9") {
+		t.Error("Expected to keep: numeric literal against lexicographic bounds")
+	}
+
+	// String literal against string column: "9x" > "100" lexicographically, prune
+	if !CanPruneBlock(idx, &block, "string_col", "=", "9x") {
+		t.Error("Expected to prune: string '9x' > '100' lexicographically")
 	}
 
 	// Numeric: 50 in [10, 100], should NOT prune
@@ -268,12 +279,9 @@ func TestNumericVsStringComparison(t *testing.T) {
 		t.Error("Expected to keep: numeric 50 in [10, 100]")
 	}
 
-	// String: "50" < "100" but > "10" lexicographically, should NOT prune
-	// Note: lexicographically "10" < "50" < "9" (not "100")
-	// Actually "100" < "50" < "9" lexicographically
-	// So "50" is NOT in ["10", "100"] - it's outside!
-	if !CanPruneBlock(idx, &block, "string_col", "=", "50") {
-		t.Error("Expected to prune: string '50' > '100' lexicographically")
+	// String literal against a numeric column: numeric bounds don't order strings
+	if CanPruneBlock(idx, &block, "numeric_col", "=", "abc") {
+		t.Error("Expected to keep: string literal against numeric bounds")
 	}
 }
 
@@ -442,3 +450,874 @@ func TestRealOffsetTracking(t *testing.T) {
 		t.Errorf("After seeking to block 1, read byte %v, want '1'", buf[0])
 	}
 }
+
+// TestBuildSelectedColumns verifies that --columns style selection only indexes the requested columns
+func TestBuildSelectedColumns(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "test.csv")
+
+	content := "id,country,note,total\n1,UK,a,10\n2,US,b,20\n3,DE,c,30\n"
+	if err := os.WriteFile(csvPath, []byte(content), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	builder := NewBuilder(2)
+	builder.SetColumns([]string{"total", "Country"})
+	idx, err := builder.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("BuildFromFile: %v", err)
+	}
+
+	if len(idx.Header.Columns) != 2 {
+		t.Fatalf("expected 2 indexed columns, got %d", len(idx.Header.Columns))
+	}
+	if idx.Header.Columns[0].Name != "country" || idx.Header.Columns[0].Ordinal != 1 {
+		t.Errorf("unexpected first column: %+v", idx.Header.Columns[0])
+	}
+	if idx.Header.Columns[1].Name != "total" || idx.Header.Columns[1].Ordinal != 3 {
+		t.Errorf("unexpected second column: %+v", idx.Header.Columns[1])
+	}
+	if got := idx.Blocks[0].Columns[1]; got.Min != "10" || got.Max != "20" {
+		t.Errorf("unexpected total stats in block 0: %+v", got)
+	}
+
+	// Round-trip through the on-disk format keeps ordinals
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, idx); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	loaded, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+	if loaded.Header.Columns[1].Ordinal != 3 {
+		t.Errorf("ordinal lost in round-trip: %+v", loaded.Header.Columns[1])
+	}
+	if err := ValidateIndex(loaded, csvPath); err != nil {
+		t.Errorf("ValidateIndex() failed for partial index: %v", err)
+	}
+
+	if !CanPruneBlock(loaded, &loaded.Blocks[0], "country", "=", "FR") {
+		t.Error("Expected to prune block 0 for country = 'FR'")
+	}
+	if CanPruneBlock(loaded, &loaded.Blocks[0], "note", "=", "zzz") {
+		t.Error("Unindexed column must never prune")
+	}
+
+	// Parallel builder honours the same selection
+	pb := NewParallelBuilder(2, 2)
+	pb.SetColumns([]string{"country"})
+	pidx, err := pb.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("ParallelBuilder.BuildFromFile: %v", err)
+	}
+	if len(pidx.Header.Columns) != 1 || pidx.Header.Columns[0].Ordinal != 1 {
+		t.Errorf("unexpected parallel columns: %+v", pidx.Header.Columns)
+	}
+
+	// Unknown columns are rejected
+	bad := NewBuilder(2)
+	bad.SetColumns([]string{"missing"})
+	if _, err := bad.BuildFromFile(csvPath); err == nil {
+		t.Error("expected error for unknown column")
+	}
+}
+
+// TestBuildInfoRoundTrip verifies build version and flags survive serialization
+func TestBuildInfoRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "test.csv")
+	if err := os.WriteFile(csvPath, []byte("a,b\n1,x\n2,y\n"), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	builder := NewBuilder(10)
+	builder.SetSkipTypeInference(true)
+	idx, err := builder.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("BuildFromFile: %v", err)
+	}
+	if idx.Header.BuildFlags != BuildFlagSkipTypeInference {
+		t.Errorf("unexpected build flags: %s", idx.Header.BuildFlags)
+	}
+	idx.Header.BuildVersion = "sieswi 1.2.3 (commit: abc)"
+
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, idx); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	loaded, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+	if loaded.Header.BuildVersion != idx.Header.BuildVersion {
+		t.Errorf("BuildVersion = %q, want %q", loaded.Header.BuildVersion, idx.Header.BuildVersion)
+	}
+	if loaded.Header.BuildFlags != idx.Header.BuildFlags {
+		t.Errorf("BuildFlags = %s, want %s", loaded.Header.BuildFlags, idx.Header.BuildFlags)
+	}
+	if loaded.Blocks[0].Columns[1].Max != "y" {
+		t.Errorf("block stats misread after build info: %+v", loaded.Blocks[0].Columns)
+	}
+}
+
+// TestIndexValidationByChecksum checks that v6 indexes validate on content, not mtime
+func TestIndexValidationByChecksum(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "test.csv")
+	if err := os.WriteFile(csvPath, []byte("a,b\n1,x\n2,y\n"), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	idx, err := NewBuilder(10).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("BuildFromFile: %v", err)
+	}
+	if idx.Header.FileChecksum == 0 {
+		t.Fatal("expected builder to record a checksum")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, idx); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	loaded, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+	if loaded.Header.FileChecksum != idx.Header.FileChecksum {
+		t.Errorf("FileChecksum = %x, want %x", loaded.Header.FileChecksum, idx.Header.FileChecksum)
+	}
+
+	// A copy that resets mtime keeps the index valid
+	newTime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(csvPath, newTime, newTime); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if err := ValidateIndex(loaded, csvPath); err != nil {
+		t.Errorf("ValidateIndex() failed after mtime-only change: %v", err)
+	}
+
+	// Same size, same mtime, different content is caught
+	if err := os.WriteFile(csvPath, []byte("a,b\n1,x\n3,y\n"), 0644); err != nil {
+		t.Fatalf("rewrite test file: %v", err)
+	}
+	if err := os.Chtimes(csvPath, newTime, newTime); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if err := ValidateIndex(loaded, csvPath); err == nil {
+		t.Error("ValidateIndex() should fail when content changes with mtime preserved")
+	}
+}
+
+func TestFingerprintSamplesHeadAndTail(t *testing.T) {
+	base := bytes.Repeat([]byte("0123456789abcdef"), fingerprintSampleSize/4) // 4 samples long
+	sum := func(data []byte) uint64 {
+		t.Helper()
+		got, err := fingerprintReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("fingerprintReader: %v", err)
+		}
+		return got
+	}
+	orig := sum(base)
+
+	for _, pos := range []int{0, len(base) - 1} {
+		changed := append([]byte(nil), base...)
+		changed[pos] = 'X'
+		if sum(changed) == orig {
+			t.Errorf("change at offset %d not detected", pos)
+		}
+	}
+
+	// The middle is deliberately not hashed
+	middle := append([]byte(nil), base...)
+	middle[len(base)/2] = 'X'
+	if sum(middle) != orig {
+		t.Error("expected middle of large file to be outside the fingerprint")
+	}
+
+	if sum(base[:10]) == sum(base[:11]) {
+		t.Error("expected size to be part of the fingerprint")
+	}
+}
+
+// TestRowAndDistinctStats checks the row count and HyperLogLog estimates stored in the header
+func TestRowAndDistinctStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "test.csv")
+
+	var sb strings.Builder
+	sb.WriteString("id,country,flag\n")
+	const rows = 20000
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&sb, "%d,c%d,\n", i, i%50)
+	}
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	builders := map[string]interface {
+		BuildFromFile(string) (*Index, error)
+	}{
+		"sequential": NewBuilder(1000),
+		"parallel":   NewParallelBuilder(1000, 4),
+	}
+	for name, builder := range builders {
+		idx, err := builder.BuildFromFile(csvPath)
+		if err != nil {
+			t.Fatalf("%s: BuildFromFile: %v", name, err)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteIndex(&buf, idx); err != nil {
+			t.Fatalf("%s: WriteIndex: %v", name, err)
+		}
+		loaded, err := ReadIndex(&buf)
+		if err != nil {
+			t.Fatalf("%s: ReadIndex: %v", name, err)
+		}
+
+		if loaded.Header.TotalRows != rows {
+			t.Errorf("%s: TotalRows = %d, want %d", name, loaded.Header.TotalRows, rows)
+		}
+		checks := []struct {
+			col       string
+			want, tol uint64
+		}{
+			{"id", rows, rows / 20}, // within 5%
+			{"country", 50, 1},
+			{"flag", 0, 0},
+		}
+		for _, c := range checks {
+			got, ok := loaded.DistinctCount(c.col)
+			if !ok {
+				t.Errorf("%s: no distinct estimate for %s", name, c.col)
+				continue
+			}
+			if got+c.tol < c.want || got > c.want+c.tol {
+				t.Errorf("%s: distinct(%s) = %d, want %d±%d", name, c.col, got, c.want, c.tol)
+			}
+		}
+	}
+}
+
+// TestCanPruneBlockNot tests pruning for negated comparisons
+func TestCanPruneBlockNot(t *testing.T) {
+	idx := &Index{
+		Header: Header{
+			Columns: []ColumnInfo{
+				{Name: "id", Type: ColumnTypeNumeric},
+				{Name: "country", Type: ColumnTypeString},
+			},
+		},
+	}
+	uniform := BlockMeta{StartRow: 0, EndRow: 10, Columns: []ColumnStats{
+		{Min: "1", Max: "10", MinNum: 1, MaxNum: 10},
+		{Min: "UK", Max: "UK"},
+	}}
+	ranged := BlockMeta{StartRow: 0, EndRow: 10, Columns: []ColumnStats{
+		{Min: "1", Max: "10", MinNum: 1, MaxNum: 10},
+		{Min: "DE", Max: "FR"},
+	}}
+	withInvalid := BlockMeta{StartRow: 0, EndRow: 10, Columns: []ColumnStats{
+		{Min: "1", Max: "10", MinNum: 1, MaxNum: 10, InvalidCount: 1},
+		{Min: "UK", Max: "UK"},
+	}}
+	withEmpty := BlockMeta{StartRow: 0, EndRow: 10, Columns: []ColumnStats{
+		{Min: "1", Max: "10", MinNum: 1, MaxNum: 10},
+		{Min: "UK", Max: "UK", EmptyCount: 1},
+	}}
+
+	tests := []struct {
+		name     string
+		block    BlockMeta
+		column   string
+		operator string
+		value    string
+		want     bool
+	}{
+		{"not_equals_whole_block", uniform, "country", "=", "UK", true},
+		{"not_equals_other_value", uniform, "country", "=", "US", false},
+		{"not_not_equals_outside", ranged, "country", "!=", "UK", true},
+		{"not_not_equals_inside", ranged, "country", "!=", "ES", false},
+		{"not_greater_all_above", ranged, "country", ">", "CH", true},
+		{"not_greater_some_below", ranged, "country", ">", "ES", false},
+		{"not_greater_equal_min", ranged, "country", ">=", "DE", true},
+		{"not_less_all_below", ranged, "country", "<", "GR", true},
+		{"not_less_equal_max", ranged, "country", "<=", "FR", true},
+		{"not_less_equal_below_max", ranged, "country", "<=", "ES", false},
+		{"empty_values_match_not", withEmpty, "country", "=", "UK", false},
+		{"numeric_all_above", uniform, "id", ">", "0", true},
+		{"numeric_some_below", uniform, "id", ">", "5", false},
+		{"numeric_invalid_values_match_not", withInvalid, "id", ">", "0", false},
+		{"numeric_value_on_string_column", uniform, "country", "!=", "5", false},
+		{"unknown_column", uniform, "missing", "=", "UK", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CanPruneBlockNot(idx, &tt.block, tt.column, tt.operator, tt.value)
+			if got != tt.want {
+				t.Errorf("CanPruneBlockNot(%s %s %s) = %v, want %v",
+					tt.column, tt.operator, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestShortRowsCountAsEmpty checks that missing trailing fields are recorded as empty
+func TestShortRowsCountAsEmpty(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "test.csv")
+	if err := os.WriteFile(csvPath, []byte("a,b\n1,x\n2\n3,\n"), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	idx, err := NewBuilder(10).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("BuildFromFile: %v", err)
+	}
+	if got := idx.Blocks[0].Columns[1].EmptyCount; got != 2 {
+		t.Errorf("EmptyCount for b = %d, want 2", got)
+	}
+}
+
+// TestTypedPruning covers timestamp columns and values that don't parse as the column type
+func TestTypedPruning(t *testing.T) {
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	feb := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	idx := &Index{
+		Header: Header{
+			Columns: []ColumnInfo{
+				{Name: "created_at", Type: ColumnTypeTimestamp},
+				{Name: "amount", Type: ColumnTypeNumeric},
+			},
+		},
+	}
+	block := BlockMeta{StartRow: 0, EndRow: 10, Columns: []ColumnStats{
+		{Min: "2024-01-01", Max: "2024-02-01T00:00:00Z", MinTime: jan, MaxTime: feb},
+		{Min: "-5", Max: "9", MinNum: -5, MaxNum: 9, InvalidCount: 2},
+	}}
+	noValues := BlockMeta{StartRow: 0, EndRow: 10, Columns: []ColumnStats{
+		{EmptyCount: 4, InvalidCount: 6},
+		{EmptyCount: 10},
+	}}
+
+	tests := []struct {
+		name     string
+		block    BlockMeta
+		column   string
+		operator string
+		value    string
+		want     bool
+	}{
+		{"timestamp_after_max", block, "created_at", ">", "2024-02-01", true},
+		{"timestamp_after_max_with_time", block, "created_at", ">=", "2024-02-01T00:00:01Z", true},
+		{"timestamp_inside", block, "created_at", ">=", "2024-01-15 12:00:00", false},
+		{"timestamp_before_min", block, "created_at", "<", "2024-01-01", true},
+		{"timestamp_offset_zone", block, "created_at", "<", "2024-01-01T01:00:00+02:00", true},
+		{"timestamp_string_literal", block, "created_at", "=", "yesterday", false},
+		{"timestamp_numeric_literal", block, "created_at", "<", "20240101", false},
+		{"numeric_negative_bounds", block, "amount", "<", "-5", true},
+		{"numeric_invalid_ne_kept", BlockMeta{StartRow: 0, EndRow: 10, Columns: []ColumnStats{
+			{}, {Min: "3", Max: "3", MinNum: 3, MaxNum: 3, InvalidCount: 1},
+		}}, "amount", "!=", "3", false},
+		{"numeric_constant_ne", BlockMeta{StartRow: 0, EndRow: 10, Columns: []ColumnStats{
+			{}, {Min: "3", Max: "3.0", MinNum: 3, MaxNum: 3},
+		}}, "amount", "!=", "3", true},
+		{"numeric_nan_literal", block, "amount", "!=", "NaN", false},
+		{"timestamp_no_parseable_values", noValues, "created_at", "=", "2024-01-10", true},
+		{"numeric_all_empty", noValues, "amount", ">", "0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CanPruneBlock(idx, &tt.block, tt.column, tt.operator, tt.value)
+			if got != tt.want {
+				t.Errorf("CanPruneBlock(%s %s %s) = %v, want %v",
+					tt.column, tt.operator, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTypedStatsRoundTrip builds typed columns and checks bounds survive serialization
+func TestTypedStatsRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "test.csv")
+	content := "id,amount,created_at,name\n" +
+		"1,9,2024-03-01,zed\n" +
+		"2,10,2024-01-15T08:00:00Z,amy\n" +
+		"3,100,2024-02-01 10:00:00,bob\n" +
+		"4,n/a,,carl\n" +
+		"5,-2.5,2024-01-02,dan\n"
+	if err := os.WriteFile(csvPath, []byte(content), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	idx, err := NewBuilder(100).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("BuildFromFile: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, idx); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	loaded, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+
+	wantTypes := []ColumnType{ColumnTypeNumeric, ColumnTypeNumeric, ColumnTypeTimestamp, ColumnTypeString}
+	for i, want := range wantTypes {
+		if got := loaded.Header.Columns[i].Type; got != want {
+			t.Errorf("column %s type = %s, want %s", loaded.Header.Columns[i].Name, got, want)
+		}
+	}
+
+	amount := loaded.Blocks[0].Columns[1]
+	if amount.MinNum != -2.5 || amount.MaxNum != 100 || amount.Min != "-2.5" || amount.Max != "100" {
+		t.Errorf("amount bounds = %+v, want numeric -2.5..100", amount)
+	}
+	if amount.InvalidCount != 1 {
+		t.Errorf("amount InvalidCount = %d, want 1", amount.InvalidCount)
+	}
+
+	created := loaded.Blocks[0].Columns[2]
+	wantMin := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC).UnixNano()
+	wantMax := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	if created.MinTime != wantMin || created.MaxTime != wantMax || created.EmptyCount != 1 {
+		t.Errorf("created_at bounds = %+v", created)
+	}
+
+	name := loaded.Blocks[0].Columns[3]
+	if name.Min != "amy" || name.Max != "zed" {
+		t.Errorf("name bounds = %+v, want amy..zed", name)
+	}
+}
+
+// TestCompressedTruncatedRoundTrip checks compressed indexes read back the
+// same and cut string bounds still bound every value of their block
+func TestCompressedTruncatedRoundTrip(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "test.csv")
+	var sb strings.Builder
+	sb.WriteString("id,url\n")
+	for i := 0; i < 400; i++ {
+		fmt.Fprintf(&sb, "%d,https://example.com/items/%05d/detail\n", i, i*37%400)
+	}
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+	idx, err := NewBuilder(50).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("BuildFromFile: %v", err)
+	}
+
+	var plain bytes.Buffer
+	if err := WriteIndex(&plain, idx); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	idx.Header.Compressed = true
+	var compressed bytes.Buffer
+	if err := WriteIndex(&compressed, idx); err != nil {
+		t.Fatalf("WriteIndex compressed: %v", err)
+	}
+	if compressed.Len() >= plain.Len() {
+		t.Errorf("compressed index is %d bytes, plain %d", compressed.Len(), plain.Len())
+	}
+	loaded, err := ReadIndex(&compressed)
+	if err != nil {
+		t.Fatalf("ReadIndex compressed: %v", err)
+	}
+	if !loaded.Header.Compressed || !reflect.DeepEqual(loaded.Blocks, idx.Blocks) {
+		t.Fatal("compressed index doesn't read back the blocks written")
+	}
+
+	idx.Header.BoundLength = 12
+	var truncated bytes.Buffer
+	if err := WriteIndex(&truncated, idx); err != nil {
+		t.Fatalf("WriteIndex truncated: %v", err)
+	}
+	if loaded, err = ReadIndex(&truncated); err != nil {
+		t.Fatalf("ReadIndex truncated: %v", err)
+	}
+	if loaded.Header.BoundLength != 12 {
+		t.Errorf("BoundLength = %d, want 12", loaded.Header.BoundLength)
+	}
+	for b, block := range loaded.Blocks {
+		exact := idx.Blocks[b].Columns[1]
+		cut := block.Columns[1]
+		if len(cut.Min) > 12 || len(cut.Max) > 12 {
+			t.Errorf("block %d: url bounds %q..%q longer than 12 bytes", b, cut.Min, cut.Max)
+		}
+		if cut.Min > exact.Min || cut.Max < exact.Max {
+			t.Errorf("block %d: url bounds %q..%q don't cover %q..%q", b, cut.Min, cut.Max, exact.Min, exact.Max)
+		}
+	}
+	// A cut bound prunes less, never wrongly
+	for _, value := range []string{"https://example.com/items/00037/detail", "https://example.com/zzz", "http://"} {
+		for b := range loaded.Blocks {
+			if CanPruneBlock(loaded, &loaded.Blocks[b], "url", "=", value) && !CanPruneBlock(idx, &idx.Blocks[b], "url", "=", value) {
+				t.Errorf("block %d: url = %q pruned only with cut bounds", b, value)
+			}
+		}
+	}
+
+	for _, tc := range []struct{ in, min, max string }{
+		{"abcdef", "abc", "abd"},
+		{"ab", "ab", "ab"},
+		{"ab\xff\xffz", "ab\xff", "ac"},
+		{"\xff\xff\xffz", "\xff\xff\xff", "\xff\xff\xffz"},
+	} {
+		if got := truncateMin(tc.in, 3); got != tc.min {
+			t.Errorf("truncateMin(%q) = %q, want %q", tc.in, got, tc.min)
+		}
+		if got := truncateMax(tc.in, 3); got != tc.max {
+			t.Errorf("truncateMax(%q) = %q, want %q", tc.in, got, tc.max)
+		}
+	}
+}
+
+// TestReadIndexRejectsNewerFormat checks a format this build doesn't know
+// is refused instead of read with the wrong layout
+func TestReadIndexRejectsNewerFormat(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(csvPath, []byte("id,name\n1,a\n2,b\n"), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+	idx, err := NewBuilder(100).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("BuildFromFile: %v", err)
+	}
+	for _, version := range []uint32{0, Version + 1} {
+		idx.Header.Version = version
+		var buf bytes.Buffer
+		if err := WriteIndex(&buf, idx); err != nil {
+			t.Fatalf("WriteIndex: %v", err)
+		}
+		if _, err := ReadIndex(&buf); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("v%d: err = %v, want ErrUnsupportedVersion", version, err)
+		}
+	}
+}
+
+// TestSummarize checks block statistics fold into file-wide bounds by column type
+func TestSummarize(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "test.csv")
+	data := "id,amount,created_at,name\n" +
+		"5,10,2024-02-01,bob\n" +
+		"12,-2.5,,alice\n" +
+		"3,x,2024-01-15,\n" +
+		"40,100,2024-03-01,carol\n" +
+		"7,9,2023-12-31,ann\n"
+	if err := os.WriteFile(csvPath, []byte(data), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+	idx, err := NewBuilder(2).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("BuildFromFile: %v", err)
+	}
+
+	want := []ColumnSummary{
+		{Min: "3", Max: "40"}, // Numeric order, not lexicographic
+		{Min: "-2.5", Max: "100", Invalid: 1},
+		{Min: "2023-12-31", Max: "2024-03-01", Empty: 1},
+		{Min: "alice", Max: "carol", Empty: 1},
+	}
+	for i, w := range want {
+		if got := idx.Summarize(i); got != w {
+			t.Errorf("Summarize(%s) = %+v, want %+v", idx.Header.Columns[i].Name, got, w)
+		}
+	}
+}
+
+// TestTypeInferenceSamplesWholeFile checks types come from rows across the
+// file, not just the first block, and that mixed columns keep string bounds
+func TestTypeInferenceSamplesWholeFile(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "test.csv")
+	var sb strings.Builder
+	sb.WriteString("id,code,amount\n")
+	for i := 0; i < 2000; i++ {
+		code := fmt.Sprint(i)
+		if i >= 100 {
+			code = fmt.Sprintf("K%04d", i) // Numeric only in the first block
+		}
+		amount := fmt.Sprint(i % 100)
+		if i >= 1700 {
+			amount = "n/a" // 15% text: still numeric, but mixed
+		}
+		fmt.Fprintf(&sb, "%d,%s,%s\n", i, code, amount)
+	}
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	pb := NewParallelBuilder(100, 4)
+	pb.minChunkSize = 1
+	builders := map[string]interface {
+		BuildFromFile(string) (*Index, error)
+	}{
+		"sequential": NewBuilder(100),
+		"parallel":   pb,
+	}
+	for name, builder := range builders {
+		built, err := builder.BuildFromFile(csvPath)
+		if err != nil {
+			t.Fatalf("%s: BuildFromFile: %v", name, err)
+		}
+		var buf bytes.Buffer
+		if err := WriteIndex(&buf, built); err != nil {
+			t.Fatalf("%s: WriteIndex: %v", name, err)
+		}
+		idx, err := ReadIndex(&buf)
+		if err != nil {
+			t.Fatalf("%s: ReadIndex: %v", name, err)
+		}
+
+		cols := idx.Header.Columns
+		if cols[1].Type != ColumnTypeString || cols[1].Mixed {
+			t.Errorf("%s: code = %s (mixed %v), want string", name, cols[1].Type, cols[1].Mixed)
+		}
+		if cols[2].Type != ColumnTypeNumeric || !cols[2].Mixed {
+			t.Errorf("%s: amount = %s (mixed %v), want mixed numeric", name, cols[2].Type, cols[2].Mixed)
+		}
+		if cols[0].Mixed || idx.Blocks[0].Columns[0].StrMin != "" {
+			t.Errorf("%s: id should not be mixed or keep string bounds", name)
+		}
+
+		// String predicates prune the mixed column with its string bounds
+		var kept []int
+		for i := range idx.Blocks {
+			if !CanPruneBlock(idx, &idx.Blocks[i], "amount", "=", "n/a") {
+				kept = append(kept, i)
+			}
+		}
+		if fmt.Sprint(kept) != "[17 18 19]" {
+			t.Errorf("%s: amount = 'n/a' keeps blocks %v, want [17 18 19]", name, kept)
+		}
+		if !CanPruneBlockNot(idx, &idx.Blocks[18], "amount", "=", "n/a") {
+			t.Errorf("%s: NOT amount = 'n/a' should prune an all-'n/a' block", name)
+		}
+		if !CanPruneBlock(idx, &idx.Blocks[18], "amount", ">", "5") {
+			t.Errorf("%s: amount > 5 should prune a block with no numbers", name)
+		}
+	}
+}
+
+// TestBooleanColumns checks true/false columns are typed boolean, keep 0/1
+// bounds through serialization, and prune bare boolean predicates
+func TestBooleanColumns(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "test.csv")
+	data := "id,active,flag\n" +
+		"1,true,1\n" +
+		"2,TRUE,0\n" +
+		"3,yes,1\n" +
+		"4,no,0\n" +
+		"5,,1\n" +
+		"6,False,0\n"
+	if err := os.WriteFile(csvPath, []byte(data), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+	built, err := NewBuilder(2).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("BuildFromFile: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, built); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	idx, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+
+	// A column of only 1 and 0 stays numeric
+	if got := idx.Header.Columns[1].Type; got != ColumnTypeBoolean {
+		t.Errorf("active type = %s, want boolean", got)
+	}
+	if got := idx.Header.Columns[2].Type; got != ColumnTypeNumeric {
+		t.Errorf("flag type = %s, want numeric", got)
+	}
+	if got, want := idx.Summarize(1), (ColumnSummary{Min: "no", Max: "true", Empty: 1}); got != want {
+		t.Errorf("Summarize(active) = %+v, want %+v", got, want)
+	}
+
+	// Blocks: {true, TRUE}, {yes, no}, {"", False}
+	tests := []struct {
+		block   int
+		want    bool
+		prune   bool
+		pruneNo bool // For NOT (active = want)
+	}{
+		{0, true, false, true},
+		{0, false, true, false},
+		{1, true, false, false},
+		{1, false, false, false},
+		{2, true, true, false}, // The empty row matches NOT active
+		{2, false, false, false},
+	}
+	for _, tt := range tests {
+		block := &idx.Blocks[tt.block]
+		if got := CanPruneBlockBool(idx, block, "active", tt.want); got != tt.prune {
+			t.Errorf("block %d: CanPruneBlockBool(%v) = %v, want %v", tt.block, tt.want, got, tt.prune)
+		}
+		if got := CanPruneBlockBoolNot(idx, block, "active", tt.want); got != tt.pruneNo {
+			t.Errorf("block %d: CanPruneBlockBoolNot(%v) = %v, want %v", tt.block, tt.want, got, tt.pruneNo)
+		}
+	}
+	if CanPruneBlockBool(idx, &idx.Blocks[0], "flag", true) {
+		t.Errorf("numeric column pruned as boolean")
+	}
+}
+
+// TestCanPruneBlockRows checks row-number predicates against block row ranges
+func TestCanPruneBlockRows(t *testing.T) {
+	block := &BlockMeta{StartRow: 100, EndRow: 200} // Numbered 102..201 from first=2
+	tests := []struct {
+		operator string
+		value    float64
+		negated  bool
+		want     bool
+	}{
+		{"=", 101, false, true},
+		{"=", 102, false, false},
+		{">", 201, false, true},
+		{">=", 201, false, false},
+		{"<", 102, false, true},
+		{"<=", 150, false, false},
+		{">", 101, true, true}, // NOT (n > 101): every row is above 101
+		{">", 150, true, false},
+		{"!=", 150, true, false},
+	}
+	for _, tt := range tests {
+		if got := CanPruneBlockRows(block, 2, tt.operator, tt.value, tt.negated); got != tt.want {
+			t.Errorf("CanPruneBlockRows(%s %v, negated=%v) = %v, want %v", tt.operator, tt.value, tt.negated, got, tt.want)
+		}
+	}
+}
+
+func TestSortedColumns(t *testing.T) {
+	// id and day rise through the file; code rises too but an empty value
+	// late in the file sorts before everything; amount and flag cycle
+	var sb strings.Builder
+	sb.WriteString("id,day,code,amount,flag\n")
+	for i := 0; i < 400; i++ {
+		code := fmt.Sprintf("c%04d", i)
+		if i == 350 {
+			code = ""
+		}
+		fmt.Fprintf(&sb, "%d,2024-%02d-%02d,%s,%d,%t\n", i, 1+i/40, 1+i%28, code, i%7, i%2 == 0)
+	}
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+
+	want := map[string]bool{"id": true, "day": false, "code": false, "amount": false, "flag": false}
+	// Days within a block wander, but blocks of 40 rows each cover one month
+	for _, blockSize := range []uint32{40, 64} {
+		want["day"] = blockSize == 40
+		seq, err := NewBuilder(blockSize).BuildFromFile(csvPath)
+		if err != nil {
+			t.Fatalf("build index: %v", err)
+		}
+		pb := NewParallelBuilder(blockSize, 3)
+		pb.minChunkSize = 1024
+		par, err := pb.BuildFromFile(csvPath)
+		if err != nil {
+			t.Fatalf("parallel build: %v", err)
+		}
+		for _, index := range []*Index{seq, par} {
+			for _, col := range index.Header.Columns {
+				if col.Sorted != want[col.Name] {
+					t.Errorf("block size %d: %s sorted = %v, want %v", blockSize, col.Name, col.Sorted, want[col.Name])
+				}
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := WriteIndex(&buf, seq); err != nil {
+			t.Fatalf("WriteIndex: %v", err)
+		}
+		read, err := ReadIndex(&buf)
+		if err != nil {
+			t.Fatalf("ReadIndex: %v", err)
+		}
+		if !reflect.DeepEqual(read.Header.Columns, seq.Header.Columns) {
+			t.Errorf("columns after round trip = %+v, want %+v", read.Header.Columns, seq.Header.Columns)
+		}
+	}
+}
+
+func TestBlockRangeMatchesPruning(t *testing.T) {
+	// created_at rises a day every three rows, so days straddle blocks, and
+	// one block has no dates at all; code rises with a prefix
+	var sb strings.Builder
+	sb.WriteString("id,created_at,code,amount\n")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 500; i++ {
+		day := start.AddDate(0, 0, i/3).Format("2006-01-02")
+		if i >= 200 && i < 250 {
+			day = ""
+		}
+		fmt.Fprintf(&sb, "%d,%s,k%04d,%d\n", i, day, i, i%9)
+	}
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	index, err := NewBuilder(50).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+
+	preds := []struct {
+		col, op, value string
+	}{
+		{"created_at", ">=", "2024-02-01"},
+		{"created_at", ">", "2024-01-17"},
+		{"created_at", "<", "2024-01-17"},
+		{"created_at", "<=", "2024-04-01"},
+		{"created_at", "=", "2024-03-10"},
+		{"created_at", ">=", "2025-01-01"},
+		{"created_at", "<", "2023-01-01"},
+		{"id", "=", "333"},
+		{"id", ">", "120"},
+		{"code", "<", "k0260"},
+		{"code", "=", "k0499"},
+	}
+	for _, p := range preds {
+		lo, hi, ok := index.BlockRange(p.col, p.op, p.value)
+		if !ok {
+			t.Errorf("%s %s %s: no block range", p.col, p.op, p.value)
+			continue
+		}
+		colIdx, colType, _ := findColumn(index, p.col)
+		for n := range index.Blocks {
+			block := &index.Blocks[n]
+			prune := CanPruneBlock(index, block, p.col, p.op, p.value)
+			if n < lo || n >= hi {
+				if !prune {
+					t.Errorf("%s %s %s: block %d outside [%d, %d) can't be pruned", p.col, p.op, p.value, n, lo, hi)
+				}
+			} else if prune && hasBounds(index.Stats(block, colIdx), colType) {
+				// Sorted blocks with values inside the range all match
+				t.Errorf("%s %s %s: block %d inside [%d, %d) can be pruned", p.col, p.op, p.value, n, lo, hi)
+			}
+		}
+	}
+
+	// February starts at row 93, in the second block
+	if lo, hi, _ := index.BlockRange("created_at", ">=", "2024-02-01"); lo != 1 || hi != len(index.Blocks) {
+		t.Errorf("created_at >= 2024-02-01: range [%d, %d), want [1, %d)", lo, hi, len(index.Blocks))
+	}
+	if lo, hi, _ := index.BlockRange("created_at", ">=", "2025-01-01"); lo != hi {
+		t.Errorf("dates past the file: range [%d, %d), want empty", lo, hi)
+	}
+	for _, p := range [][3]string{{"amount", ">", "3"}, {"id", "!=", "5"}, {"created_at", ">", "abc"}, {"missing", "=", "1"}} {
+		if _, _, ok := index.BlockRange(p[0], p[1], p[2]); ok {
+			t.Errorf("%s %s %s: block range for a predicate bounds don't order", p[0], p[1], p[2])
+		}
+	}
+}
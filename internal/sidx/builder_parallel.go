@@ -1,36 +1,42 @@
 package sidx
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/csv"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
-	"strconv"
 	"sync"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
 )
 
-// ChunkResult represents the result of processing a chunk of the CSV file
-type ChunkResult struct {
-	StartRow       uint64
-	EndRow         uint64
-	StartOffset    uint64
-	EndOffset      uint64
-	ColumnMins     []string
-	ColumnMaxs     []string
-	EmptyCounts    []uint32
-	NumericCounts  []int // For type inference
-	NonEmptyCounts []int // For type inference
-	Err            error
-}
+// minChunkSize keeps chunks large enough that per-chunk overhead stays negligible
+const minChunkSize = 4 * 1024 * 1024
 
-// ParallelBuilder builds indexes using multiple goroutines
+// ParallelBuilder builds indexes using multiple goroutines.
+//
+// The data section is split into byte ranges that start exactly on line
+// boundaries. A first pass counts rows per chunk so each chunk knows its
+// global starting row; the second pass collects statistics and cuts blocks at
+// the same global row multiples as Builder. Blocks straddling a chunk boundary
+// are merged, so the result matches a sequential build block for block.
 type ParallelBuilder struct {
 	blockSize         uint32
 	skipTypeInference bool
 	numWorkers        int
+	columns           []string
+	minChunkSize      int64
+	progress          ProgressFunc
+	cancel            <-chan struct{}
+	noHeader          bool
+	headerNames       []string
+	header            bool
+	dialect           csvio.Dialect
+	numbers           datatype.NumberFormat
+	strict            bool
+	encoding          string
+	sumColumns        []string
 }
 
 // NewParallelBuilder creates a new parallel index builder
@@ -39,8 +45,9 @@ func NewParallelBuilder(blockSize uint32, numWorkers int) *ParallelBuilder {
 		numWorkers = runtime.NumCPU()
 	}
 	return &ParallelBuilder{
-		blockSize:  blockSize,
-		numWorkers: numWorkers,
+		blockSize:    blockSize,
+		numWorkers:   numWorkers,
+		minChunkSize: minChunkSize,
 	}
 }
 
@@ -49,13 +56,93 @@ func (pb *ParallelBuilder) SetSkipTypeInference(skip bool) {
 	pb.skipTypeInference = skip
 }
 
+// SetColumns restricts statistics to the named columns (empty means all)
+func (pb *ParallelBuilder) SetColumns(columns []string) {
+	pb.columns = columns
+}
+
+// SetNoHeader treats the first row as data. Columns are named by names,
+// then c1, c2, ... by position.
+func (pb *ParallelBuilder) SetNoHeader(names []string) {
+	pb.noHeader = true
+	pb.headerNames = names
+}
+
+// SetHeader treats the first row as column names without sniffing
+// whether it is one
+func (pb *ParallelBuilder) SetHeader() {
+	pb.header = true
+}
+
+// SetDialect reads fields separated and quoted as d says; zero fields are
+// sniffed from the start of the file
+func (pb *ParallelBuilder) SetDialect(d csvio.Dialect) {
+	pb.dialect = d
+}
+
+// SetNumberFormat reads numeric values in format f ("1,234.56", "1.234,56").
+// The format is recorded in the index, which only serves queries using it.
+func (pb *ParallelBuilder) SetNumberFormat(f datatype.NumberFormat) {
+	pb.numbers = f
+}
+
+// SetStrict fails the build at the first row whose field count differs
+// from the header's. By default such rows are indexed like any other.
+func (pb *ParallelBuilder) SetStrict(strict bool) {
+	pb.strict = strict
+}
+
+// SetEncoding decodes the file from encoding (see csvio.ParseEncoding).
+// Only encodings whose records sit at the raw file's offsets can be indexed.
+func (pb *ParallelBuilder) SetEncoding(encoding string) {
+	pb.encoding = encoding
+}
+
+// SetSumColumns stores per-block sums of the named numeric columns, which
+// whole-file SUM and AVG take from the index instead of the rows
+func (pb *ParallelBuilder) SetSumColumns(columns []string) {
+	pb.sumColumns = columns
+}
+
+// SetProgress registers a callback invoked periodically while statistics are collected
+func (pb *ParallelBuilder) SetProgress(fn ProgressFunc) {
+	pb.progress = fn
+}
+
+// SetCancel stops BuildFromFile with ErrCanceled once done is closed.
+// Workers check it between blocks.
+func (pb *ParallelBuilder) SetCancel(done <-chan struct{}) {
+	pb.cancel = done
+}
+
+// chunkInfo is a byte range of the data section starting on a line boundary
+type chunkInfo struct {
+	StartOffset uint64
+	EndOffset   uint64 // Exclusive; also a line boundary (or EOF)
+	StartRow    uint64 // Global index of the first row, filled in after pass 1
+	NumRows     uint64 // Rows counted in pass 1
+}
+
+// chunkResult holds the blocks (possibly partial at either edge) of one chunk
+type chunkResult struct {
+	blocks   []BlockMeta
+	sketches []hyperLogLog // Distinct values seen in this chunk, per column
+}
+
 // BuildFromFile builds an index using parallel processing
 func (pb *ParallelBuilder) BuildFromFile(csvPath string) (*Index, error) {
+	if err := checkEncoding(pb.encoding); err != nil {
+		return nil, err
+	}
 	f, err := os.Open(csvPath)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	defer func() {
+		if err := f.Close(); err != nil && os.Getenv("SIDX_DEBUG") == "1" {
+			fmt.Fprintf(os.Stderr, "[sidx] Failed to close CSV file: %v\n", err)
+		}
+	}()
 
 	stat, err := f.Stat()
 	if err != nil {
@@ -64,343 +151,370 @@ func (pb *ParallelBuilder) BuildFromFile(csvPath string) (*Index, error) {
 
 	fileSize := stat.Size()
 	fileMtime := stat.ModTime().UnixNano()
+	checksum, err := fingerprintReader(f, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	if pb.dialect, pb.noHeader, err = sniffInput(f, pb.encoding, pb.dialect, pb.noHeader, pb.header); err != nil {
+		return nil, err
+	}
 
 	// Read header
-	reader := bufio.NewReaderSize(f, 2*1024*1024)
-	headerLine, err := reader.ReadBytes('\n')
+	reader := recordReader(csvio.NewRetryReader(io.NewSectionReader(f, 0, fileSize), csvPath, 0), 0, pb.dialect)
+	headerLine, err := reader.Next()
 	if err != nil && err != io.EOF {
 		return nil, fmt.Errorf("read header: %w", err)
 	}
 
-	headers, err := parseCSVLine(headerLine)
+	decoder := newRecordDecoder(pb.encoding, pb.dialect)
+	headers, err := decoder.parse(csvio.TrimLineEnd(headerLine), 0)
 	if err != nil {
 		return nil, fmt.Errorf("parse header: %w", err)
 	}
+	if pb.noHeader {
+		// Name the columns after the first record's width; the data section
+		// then starts at offset 0 with that record
+		headers = csvio.ColumnNames(pb.headerNames, len(headers))
+		reader = recordReader(csvio.NewRetryReader(io.NewSectionReader(f, 0, fileSize), csvPath, 0), 0, pb.dialect)
+		headerLine = nil
+	}
 
-	numCols := len(headers)
-	headerSize := int64(len(headerLine))
-
-	// Divide file into chunks for parallel processing
-	chunks := pb.divideIntoChunks(fileSize, headerSize)
-
-	// Process chunks in parallel
-	results := make(chan ChunkResult, len(chunks))
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, pb.numWorkers)
+	ordinals, err := resolveColumnOrdinals(headers, pb.columns)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, chunk := range chunks {
-		wg.Add(1)
-		go func(c chunkInfo) {
-			defer wg.Done()
-			semaphore <- struct{}{}        // Acquire
-			defer func() { <-semaphore }() // Release
+	numCols := len(ordinals)
+	headerSize := int64(len(headerLine))
 
-			result := pb.processChunk(csvPath, c, numCols, headerSize)
-			results <- result
-		}(chunk)
+	// Column types come from the first block's rows (same window as Builder).
+	// Knowing them up front lets every chunk track only typed bounds.
+	columnTypes := make([]ColumnType, numCols)
+	if !pb.skipTypeInference {
+		columnTypes, err = sampleColumnTypes(f, csvPath, headerSize, fileSize, ordinals, pb.blockSize, pb.numbers, pb.encoding, pb.dialect)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tracks, err := columnTracks(headers, ordinals, columnTypes, pb.sumColumns)
+	if err != nil {
+		return nil, err
 	}
 
-	// Wait for all workers and close results channel
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+	chunks, err := pb.divideIntoChunks(f, fileSize, headerSize)
+	if err != nil {
+		return nil, fmt.Errorf("divide into chunks: %w", err)
+	}
 
-	// Collect and merge results
-	var allResults []ChunkResult
-	for result := range results {
-		if result.Err != nil {
-			return nil, fmt.Errorf("chunk processing error: %w", result.Err)
-		}
-		allResults = append(allResults, result)
+	// Pass 1: count rows per chunk to learn each chunk's global starting row
+	err = pb.forEachChunk(len(chunks), func(i int) error {
+		c := &chunks[i]
+		section := io.NewSectionReader(f, int64(c.StartOffset), int64(c.EndOffset-c.StartOffset))
+		n, err := countRows(csvio.NewRetryReader(section, csvPath, int64(c.StartOffset)), pb.dialect)
+		c.NumRows = n
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("count rows: %w", err)
+	}
+	var nextRow uint64
+	for i := range chunks {
+		chunks[i].StartRow = nextRow
+		nextRow += chunks[i].NumRows
 	}
 
-	// Sort results by StartRow to maintain order
-	// (Results may arrive out of order)
-	for i := 0; i < len(allResults)-1; i++ {
-		for j := i + 1; j < len(allResults); j++ {
-			if allResults[j].StartRow < allResults[i].StartRow {
-				allResults[i], allResults[j] = allResults[j], allResults[i]
-			}
-		}
+	// Pass 2: collect statistics with exact row numbers and offsets
+	tracker := newProgressTracker(pb.progress, headerSize)
+	results := make([]chunkResult, len(chunks))
+	width := 0 // Field count every row must have, when strict
+	if pb.strict {
+		width = len(headers)
+	}
+	err = pb.forEachChunk(len(chunks), func(i int) error {
+		res, err := pb.processChunk(f, csvPath, chunks[i], ordinals, columnTypes, tracks, width, tracker)
+		results[i] = res
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Merge results into blocks
-	blocks := pb.mergeResultsIntoBlocks(allResults, numCols)
+	blocks := mergeChunkBlocks(results, pb.blockSize, columnTypes)
 
-	// Determine column types
-	columnTypes := make([]ColumnType, numCols)
-	if pb.skipTypeInference {
-		for i := range columnTypes {
-			columnTypes[i] = ColumnTypeString
-		}
-	} else {
-		// Use first result for type inference
-		if len(allResults) > 0 {
-			for i := 0; i < numCols; i++ {
-				if allResults[0].NonEmptyCounts[i] > 0 &&
-					allResults[0].NumericCounts[i]*5 >= allResults[0].NonEmptyCounts[i]*4 {
-					columnTypes[i] = ColumnTypeNumeric
-				} else {
-					columnTypes[i] = ColumnTypeString
-				}
-			}
+	sketches := make([]hyperLogLog, numCols)
+	for _, res := range results {
+		for i := range res.sketches {
+			sketches[i].merge(&res.sketches[i])
 		}
 	}
 
 	columns := make([]ColumnInfo, numCols)
 	for i := range columns {
 		columns[i] = ColumnInfo{
-			Name: headers[i],
-			Type: columnTypes[i],
+			Name:     headers[ordinals[i]],
+			Type:     columnTypes[i],
+			Ordinal:  uint32(ordinals[i]),
+			Distinct: distinctEstimate(&sketches[i], nextRow),
+			Sums:     tracks[i]&trackSum != 0,
 		}
 	}
 
+	markMixedColumns(columns, blocks)
+	markSortedColumns(columns, blocks)
+
+	flags := BuildFlagParallel
+	if pb.skipTypeInference {
+		flags |= BuildFlagSkipTypeInference
+	}
+	if numCols < len(headers) {
+		flags |= BuildFlagPartialColumns
+	}
+	if pb.noHeader {
+		flags |= BuildFlagNoHeader
+	}
+	if pb.encoding == csvio.EncodingLatin1 {
+		flags |= BuildFlagLatin1
+	}
+
 	return &Index{
 		Header: Header{
-			Version:   Version,
-			BlockSize: pb.blockSize,
-			NumBlocks: uint32(len(blocks)),
-			FileSize:  fileSize,
-			FileMtime: fileMtime,
-			Columns:   columns,
+			Version:      Version,
+			BlockSize:    pb.blockSize,
+			NumBlocks:    uint32(len(blocks)),
+			FileSize:     fileSize,
+			FileMtime:    fileMtime,
+			FileChecksum: checksum,
+			TotalRows:    nextRow,
+			Columns:      columns,
+			BuildFlags:   flags,
+			Numbers:      pb.numbers,
+			Dialect:      pb.dialect,
 		},
 		Blocks: blocks,
 	}, nil
 }
 
-type chunkInfo struct {
-	StartOffset uint64
-	EndOffset   uint64
-	StartRow    uint64
+// forEachChunk runs fn for chunk indexes [0, n) on the worker pool and
+// returns the first error encountered.
+func (pb *ParallelBuilder) forEachChunk(n int, fn func(i int) error) error {
+	jobs := make(chan int)
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+
+	workers := pb.numWorkers
+	if workers > n {
+		workers = n
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if canceled(pb.cancel) {
+					errs <- ErrCanceled
+					continue
+				}
+				if err := fn(i); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	return <-errs // nil when the channel is empty
 }
 
-// divideIntoChunks divides the file into roughly equal chunks for parallel processing
-func (pb *ParallelBuilder) divideIntoChunks(fileSize, headerSize int64) []chunkInfo {
+// divideIntoChunks splits the data section into ranges aligned to record starts
+func (pb *ParallelBuilder) divideIntoChunks(f io.ReaderAt, fileSize, headerSize int64) ([]chunkInfo, error) {
 	dataSize := fileSize - headerSize
 	if dataSize <= 0 {
-		return []chunkInfo{}
+		return nil, nil
 	}
 
-	// Aim for chunks that are multiples of block size
-	rowsPerChunk := pb.blockSize * 4 // Process 4 blocks worth at a time
-	avgBytesPerRow := int64(80)      // Estimate ~80 bytes per row
-	chunkSize := int64(rowsPerChunk) * avgBytesPerRow
-
-	numChunks := (dataSize + chunkSize - 1) / chunkSize
-	if numChunks > int64(pb.numWorkers*2) {
-		numChunks = int64(pb.numWorkers * 2) // Cap at 2x workers
-	}
-	if numChunks < 1 {
-		numChunks = 1
+	numChunks := int64(pb.numWorkers * 4)
+	if pb.minChunkSize > 0 && dataSize/numChunks < pb.minChunkSize {
+		numChunks = dataSize / pb.minChunkSize
 	}
 
-	actualChunkSize := (dataSize + numChunks - 1) / numChunks
-	chunks := make([]chunkInfo, 0, numChunks)
+	starts, err := csvio.ChunkStarts(f, headerSize, fileSize, int(numChunks), pb.numWorkers, pb.dialect)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := int64(0); i < numChunks; i++ {
-		startOffset := headerSize + i*actualChunkSize
-		endOffset := startOffset + actualChunkSize
-		if endOffset > fileSize {
-			endOffset = fileSize
+	chunks := make([]chunkInfo, len(starts))
+	for i, start := range starts {
+		end := fileSize
+		if i+1 < len(starts) {
+			end = starts[i+1]
 		}
-
-		chunks = append(chunks, chunkInfo{
-			StartOffset: uint64(startOffset),
-			EndOffset:   uint64(endOffset),
-			StartRow:    0, // Will be computed during processing
-		})
+		chunks[i] = chunkInfo{StartOffset: uint64(start), EndOffset: uint64(end)}
 	}
-
-	return chunks
+	return chunks, nil
 }
 
-// processChunk processes a chunk of the CSV file
-func (pb *ParallelBuilder) processChunk(csvPath string, chunk chunkInfo, numCols int, headerSize int64) ChunkResult {
-	f, err := os.Open(csvPath)
-	if err != nil {
-		return ChunkResult{Err: err}
-	}
-	defer f.Close()
+// countRows counts non-empty records using the same rules as Builder
+func countRows(r io.Reader, d csvio.Dialect) (uint64, error) {
+	reader := recordReader(r, 1024*1024, d)
+	var rows uint64
 
-	// Seek to chunk start
-	if chunk.StartOffset > uint64(headerSize) {
-		// Need to find line boundary - seek back and find newline
-		seekPos := int64(chunk.StartOffset) - 1024 // Look back up to 1KB
-		if seekPos < headerSize {
-			seekPos = headerSize
-		}
-		if _, err := f.Seek(seekPos, 0); err != nil {
-			return ChunkResult{Err: err}
+	for {
+		record, err := reader.Next()
+		if len(csvio.TrimLineEnd(record)) > 0 {
+			rows++
 		}
-
-		reader := bufio.NewReader(f)
-		// Skip to next newline
-		if seekPos > headerSize {
-			_, err := reader.ReadBytes('\n')
-			if err != nil {
-				return ChunkResult{Err: err}
-			}
+		if err == io.EOF {
+			return rows, nil
 		}
-		chunk.StartOffset = uint64(seekPos) + uint64(1024) // Approximate
-	} else {
-		if _, err := f.Seek(int64(chunk.StartOffset), 0); err != nil {
-			return ChunkResult{Err: err}
+		if err != nil {
+			return rows, err
 		}
 	}
+}
 
-	reader := bufio.NewReaderSize(f, 1*1024*1024)
-
-	result := ChunkResult{
-		StartOffset:    chunk.StartOffset,
-		ColumnMins:     make([]string, numCols),
-		ColumnMaxs:     make([]string, numCols),
-		EmptyCounts:    make([]uint32, numCols),
-		NumericCounts:  make([]int, numCols),
-		NonEmptyCounts: make([]int, numCols),
+// processChunk collects statistics for a chunk, cutting blocks at global
+// multiples of blockSize. The first and last blocks may be partial. tracks
+// holds what each column's accumulator tracks, and a nonzero width is the
+// field count every row must have.
+func (pb *ParallelBuilder) processChunk(f io.ReaderAt, csvPath string, chunk chunkInfo, ordinals []int, columnTypes []ColumnType, tracks []uint8, width int, tracker *progressTracker) (chunkResult, error) {
+	numCols := len(ordinals)
+	blockSize := uint64(pb.blockSize)
+
+	section := io.NewSectionReader(f, int64(chunk.StartOffset), int64(chunk.EndOffset-chunk.StartOffset))
+	reader := recordReader(csvio.NewRetryReader(section, csvPath, int64(chunk.StartOffset)), 1024*1024, pb.dialect)
+
+	result := chunkResult{sketches: make([]hyperLogLog, numCols)}
+	var current *BlockMeta
+	accs := newAccumulators(numCols, pb.numbers)
+	closeBlock := func() {
+		for i := range accs {
+			current.Columns[i] = accs[i].stats(columnTypes[i])
+			accs[i].reset()
+		}
+		result.blocks = append(result.blocks, *current)
+		current = nil
 	}
 
-	csvBuffer := bytes.NewReader(nil)
-	csvReader := csv.NewReader(csvBuffer)
-	csvReader.FieldsPerRecord = -1
+	decoder := newRecordDecoder(pb.encoding, pb.dialect)
 
-	rowCount := uint64(0)
+	row := chunk.StartRow
 	offset := chunk.StartOffset
 
-	for offset < chunk.EndOffset {
-		rawLine, err := reader.ReadBytes('\n')
+	// Progress already reported for this chunk
+	reportedRow := row
+	reportedOffset := offset
+
+	for {
+		rowStart := offset
+		rawLine, err := reader.Next()
 		if err == io.EOF && len(rawLine) == 0 {
 			break
 		}
 		if err != nil && err != io.EOF {
-			result.Err = fmt.Errorf("read row: %w", err)
-			return result
+			return result, fmt.Errorf("read row %d: %w", row, err)
 		}
+		offset += uint64(len(rawLine))
 
-		trimmed := bytes.TrimRight(rawLine, "\r\n")
+		trimmed := csvio.TrimLineEnd(rawLine)
 		if len(trimmed) == 0 {
 			if err == io.EOF {
 				break
 			}
-			offset += uint64(len(rawLine))
 			continue
 		}
 
-		csvBuffer.Reset(trimmed)
-		record, perr := csvReader.Read()
+		record, perr := decoder.parse(trimmed, rowStart)
 		if perr != nil {
-			// Skip malformed rows
-			offset += uint64(len(rawLine))
-			continue
+			return result, fmt.Errorf("parse row %d: %w", row, perr)
 		}
-
-		if rowCount == 0 {
-			result.StartRow = chunk.StartRow
+		if width > 0 && len(record) != width {
+			return result, fmt.Errorf("line %d: expected %d fields, got %d", recordLine(row, pb.noHeader), width, len(record))
 		}
 
-		// Update statistics
-		for i := 0; i < numCols && i < len(record); i++ {
-			value := record[i]
-			if value == "" {
-				result.EmptyCounts[i]++
-				continue
+		if current == nil {
+			current = &BlockMeta{
+				StartRow:    row,
+				StartOffset: rowStart,
+				Columns:     make([]ColumnStats, numCols),
 			}
+		}
 
-			if result.ColumnMins[i] == "" || value < result.ColumnMins[i] {
-				result.ColumnMins[i] = value
+		for i, ord := range ordinals {
+			// Short rows count as empty so EmptyCount covers every row without a value
+			value := ""
+			if ord < len(record) {
+				value = record[ord]
 			}
-			if result.ColumnMaxs[i] == "" || value > result.ColumnMaxs[i] {
-				result.ColumnMaxs[i] = value
+			if value != "" {
+				result.sketches[i].add(value)
 			}
+			accs[i].add(value, tracks[i])
+		}
 
-			// Type inference (only for first chunk)
-			if !pb.skipTypeInference && chunk.StartOffset == uint64(headerSize) {
-				result.NonEmptyCounts[i]++
-				if _, err := strconv.ParseFloat(value, 64); err == nil {
-					result.NumericCounts[i]++
-				}
+		row++
+		current.EndRow = row
+		current.EndOffset = offset
+
+		if row%blockSize == 0 {
+			closeBlock()
+			if canceled(pb.cancel) {
+				return result, ErrCanceled
 			}
 		}
 
-		rowCount++
-		offset += uint64(len(rawLine))
+		if row-reportedRow >= progressEveryRows {
+			tracker.add(int64(offset-reportedOffset), row-reportedRow)
+			reportedRow, reportedOffset = row, offset
+		}
 
 		if err == io.EOF {
 			break
 		}
 	}
 
-	result.EndRow = result.StartRow + rowCount - 1
-	result.EndOffset = offset
-
-	return result
-}
-
-// mergeResultsIntoBlocks combines chunk results into index blocks
-func (pb *ParallelBuilder) mergeResultsIntoBlocks(results []ChunkResult, numCols int) []BlockMeta {
-	if len(results) == 0 {
-		return nil
+	if current != nil {
+		closeBlock()
 	}
+	tracker.add(int64(chunk.EndOffset-reportedOffset), row-reportedRow)
 
-	var blocks []BlockMeta
-
-	// For simplicity, treat each result as potential blocks
-	// In a production system, we'd merge adjacent results into blockSize chunks
-	currentBlock := BlockMeta{
-		StartRow:    results[0].StartRow,
-		StartOffset: results[0].StartOffset,
-		Columns:     make([]ColumnStats, numCols),
+	if got := row - chunk.StartRow; got != chunk.NumRows {
+		return result, fmt.Errorf("chunk at offset %d: counted %d rows, parsed %d (file changed during build?)",
+			chunk.StartOffset, chunk.NumRows, got)
 	}
 
-	for i := range currentBlock.Columns {
-		currentBlock.Columns[i].Min = results[0].ColumnMins[i]
-		currentBlock.Columns[i].Max = results[0].ColumnMaxs[i]
-		currentBlock.Columns[i].EmptyCount = results[0].EmptyCounts[i]
-	}
-
-	rowsInBlock := results[0].EndRow - results[0].StartRow + 1
-
-	for _, result := range results[1:] {
-		if rowsInBlock >= uint64(pb.blockSize) {
-			// Finalize current block
-			currentBlock.EndRow = currentBlock.StartRow + rowsInBlock - 1
-			currentBlock.EndOffset = result.StartOffset
-			blocks = append(blocks, currentBlock)
+	return result, nil
+}
 
-			// Start new block
-			currentBlock = BlockMeta{
-				StartRow:    result.StartRow,
-				StartOffset: result.StartOffset,
-				Columns:     make([]ColumnStats, numCols),
-			}
-			rowsInBlock = 0
-		}
+// mergeChunkBlocks concatenates chunk blocks in file order, merging partial
+// blocks that were split across chunk boundaries.
+func mergeChunkBlocks(results []chunkResult, blockSize uint32, columnTypes []ColumnType) []BlockMeta {
+	var blocks []BlockMeta
 
-		// Merge statistics
-		for i := 0; i < numCols; i++ {
-			if result.ColumnMins[i] != "" {
-				if currentBlock.Columns[i].Min == "" || result.ColumnMins[i] < currentBlock.Columns[i].Min {
-					currentBlock.Columns[i].Min = result.ColumnMins[i]
-				}
-			}
-			if result.ColumnMaxs[i] != "" {
-				if currentBlock.Columns[i].Max == "" || result.ColumnMaxs[i] > currentBlock.Columns[i].Max {
-					currentBlock.Columns[i].Max = result.ColumnMaxs[i]
-				}
+	for _, res := range results {
+		for _, block := range res.blocks {
+			n := len(blocks)
+			if n > 0 && block.StartRow%uint64(blockSize) != 0 {
+				mergeBlockInto(&blocks[n-1], &block, columnTypes)
+				continue
 			}
-			currentBlock.Columns[i].EmptyCount += result.EmptyCounts[i]
+			blocks = append(blocks, block)
 		}
-
-		rowsInBlock += result.EndRow - result.StartRow + 1
-	}
-
-	// Add final block
-	if rowsInBlock > 0 {
-		lastResult := results[len(results)-1]
-		currentBlock.EndRow = currentBlock.StartRow + rowsInBlock - 1
-		currentBlock.EndOffset = lastResult.EndOffset
-		blocks = append(blocks, currentBlock)
 	}
 
 	return blocks
 }
+
+// mergeBlockInto extends dst with the rows and statistics of the following block src
+func mergeBlockInto(dst, src *BlockMeta, columnTypes []ColumnType) {
+	dst.EndRow = src.EndRow
+	dst.EndOffset = src.EndOffset
+	for i := range dst.Columns {
+		mergeColumnStats(&dst.Columns[i], &src.Columns[i], columnTypes[i])
+	}
+}
@@ -0,0 +1,629 @@
+package sidx
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+// TestParallelBuilderMatchesSequential verifies that chunked parallel builds
+// produce exactly the same blocks, offsets, and types as the sequential builder
+func TestParallelBuilderMatchesSequential(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,name,amount,created_at\n")
+	for i := 0; i < 500; i++ {
+		switch {
+		case i%97 == 0:
+			sb.WriteString("\n") // Blank lines are skipped by both builders
+		case i%41 == 0:
+			fmt.Fprintf(&sb, "%d,\"smith, john\",%d,2024-01-%02d\r\n", i, i*3, i%28+1)
+		case i%29 == 0:
+			// Quoted newlines (and a quote pair) must not split the record
+			fmt.Fprintf(&sb, "%d,\"multi\nline \"\"%d\"\"\n\",%d,2024-01-05\n", i, i, i)
+		case i%13 == 0:
+			fmt.Fprintf(&sb, "%d,,,n/a\n", i)
+		default:
+			fmt.Fprintf(&sb, "%d,name%03d,%d.5,2024-02-%02dT%02d:00:00Z\n", i, (i*7)%500, i%50, i%28+1, i%24)
+		}
+	}
+	sb.WriteString("999,last,1,2024-03-01") // No trailing newline
+
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	for _, blockSize := range []uint32{1, 3, 64, 1000} {
+		want, err := NewBuilder(blockSize).BuildFromFile(csvPath)
+		if err != nil {
+			t.Fatalf("sequential build: %v", err)
+		}
+
+		for _, workers := range []int{1, 2, 7} {
+			t.Run(fmt.Sprintf("block%d_workers%d", blockSize, workers), func(t *testing.T) {
+				pb := NewParallelBuilder(blockSize, workers)
+				pb.minChunkSize = 1 // Force many small chunks
+				got, err := pb.BuildFromFile(csvPath)
+				if err != nil {
+					t.Fatalf("parallel build: %v", err)
+				}
+
+				if !reflect.DeepEqual(got.Header.Columns, want.Header.Columns) {
+					t.Errorf("columns differ:\ngot  %+v\nwant %+v", got.Header.Columns, want.Header.Columns)
+				}
+				if len(got.Blocks) != len(want.Blocks) {
+					t.Fatalf("got %d blocks, want %d", len(got.Blocks), len(want.Blocks))
+				}
+				for i := range want.Blocks {
+					if !reflect.DeepEqual(got.Blocks[i], want.Blocks[i]) {
+						t.Fatalf("block %d differs:\ngot  %+v\nwant %+v", i, got.Blocks[i], want.Blocks[i])
+					}
+				}
+				if got.Header.BuildFlags&BuildFlagParallel == 0 {
+					t.Error("expected parallel build flag")
+				}
+			})
+		}
+	}
+}
+
+// TestBuildersHandleQuotedNewlines checks row counts and block offsets follow
+// CSV records, not physical lines, so seeking to a block lands on its first row
+func TestBuildersHandleQuotedNewlines(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,\"note\nwith newline\",amount\n")
+	for i := 0; i < 300; i++ {
+		switch i % 4 {
+		case 0:
+			fmt.Fprintf(&sb, "%d,\"line one\nline two, \"\"quoted\"\"\n\n\",%d\n", i, i)
+		case 1:
+			fmt.Fprintf(&sb, "%d,\"\"\"\n\",%d\r\n", i, i) // Field starting with an escaped quote, then a newline
+		default:
+			fmt.Fprintf(&sb, "%d,plain,%d\n", i, i)
+		}
+	}
+	data := sb.String()
+	csvPath := filepath.Join(t.TempDir(), "quoted.csv")
+	if err := os.WriteFile(csvPath, []byte(data), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("encoding/csv: %v", err)
+	}
+	wantRows := uint64(len(records) - 1)
+
+	seq, err := NewBuilder(16).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("sequential build: %v", err)
+	}
+	pb := NewParallelBuilder(16, 4)
+	pb.minChunkSize = 1 // Chunk starts land inside quoted fields
+	par, err := pb.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("parallel build: %v", err)
+	}
+
+	for name, index := range map[string]*Index{"sequential": seq, "parallel": par} {
+		if index.Header.TotalRows != wantRows {
+			t.Errorf("%s: TotalRows = %d, want %d", name, index.Header.TotalRows, wantRows)
+		}
+		if index.Header.Columns[1].Name != "note\nwith newline" {
+			t.Errorf("%s: header column = %q", name, index.Header.Columns[1].Name)
+		}
+		for i, block := range index.Blocks {
+			r := csv.NewReader(strings.NewReader(data[block.StartOffset:block.EndOffset]))
+			got, err := r.ReadAll()
+			if err != nil {
+				t.Fatalf("%s: block %d: parse from offset %d: %v", name, i, block.StartOffset, err)
+			}
+			want := records[1+block.StartRow : 1+block.EndRow]
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: block %d rows differ:\ngot  %q\nwant %q", name, i, got, want)
+			}
+		}
+	}
+	if !reflect.DeepEqual(par.Blocks, seq.Blocks) {
+		t.Error("parallel blocks differ from sequential")
+	}
+}
+
+// TestBuildersLineEndings checks block offsets stay exact with CR-only and
+// mixed line endings and blank lines
+func TestBuildersLineEndings(t *testing.T) {
+	eols := []string{"\r", "\r\n", "\n", "\r", "\r\n\r\n"}
+	var sb strings.Builder
+	sb.WriteString("id,note\r")
+	for i := 0; i < 300; i++ {
+		if i%7 == 0 {
+			fmt.Fprintf(&sb, "%d,\"two\rlines\"%s", i, eols[i%len(eols)])
+			continue
+		}
+		fmt.Fprintf(&sb, "%d,n%d%s", i, i, eols[i%len(eols)])
+	}
+	data := sb.String()
+	csvPath := filepath.Join(t.TempDir(), "endings.csv")
+	if err := os.WriteFile(csvPath, []byte(data), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	seq, err := NewBuilder(16).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("sequential build: %v", err)
+	}
+	pb := NewParallelBuilder(16, 4)
+	pb.minChunkSize = 1
+	par, err := pb.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("parallel build: %v", err)
+	}
+
+	for name, index := range map[string]*Index{"sequential": seq, "parallel": par} {
+		if index.Header.TotalRows != 300 {
+			t.Errorf("%s: TotalRows = %d, want 300", name, index.Header.TotalRows)
+		}
+		for i, block := range index.Blocks {
+			r := csvio.NewReader(strings.NewReader(data[block.StartOffset:block.EndOffset]))
+			for row := block.StartRow; row < block.EndRow; row++ {
+				record, err := r.Read()
+				if err != nil || record[0] != strconv.FormatUint(row, 10) {
+					t.Fatalf("%s: block %d row %d = %q (%v)", name, i, row, record, err)
+				}
+			}
+		}
+	}
+	if !reflect.DeepEqual(par.Blocks, seq.Blocks) {
+		t.Error("parallel blocks differ from sequential")
+	}
+}
+
+// TestBuildersReportProgress checks both builders finish with the full file size and row count
+func TestBuildersReportProgress(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,name\n")
+	for i := 0; i < 200_000; i++ {
+		fmt.Fprintf(&sb, "%d,n%d\n", i, i)
+	}
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	check := func(name string, build func(ProgressFunc) error) {
+		var calls int
+		var lastBytes int64
+		var lastRows uint64
+		err := build(func(bytesDone int64, rowsDone uint64) {
+			calls++
+			if bytesDone < lastBytes || rowsDone < lastRows {
+				t.Errorf("%s: progress went backwards (%d,%d) -> (%d,%d)", name, lastBytes, lastRows, bytesDone, rowsDone)
+			}
+			lastBytes, lastRows = bytesDone, rowsDone
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if calls < 2 {
+			t.Errorf("%s: expected periodic progress, got %d calls", name, calls)
+		}
+		if lastBytes != int64(sb.Len()) || lastRows != 200_000 {
+			t.Errorf("%s: final progress (%d bytes, %d rows), want (%d, 200000)", name, lastBytes, lastRows, sb.Len())
+		}
+	}
+
+	check("sequential", func(fn ProgressFunc) error {
+		b := NewBuilder(BlockSize)
+		b.SetProgress(fn)
+		_, err := b.BuildFromFile(csvPath)
+		return err
+	})
+	check("parallel", func(fn ProgressFunc) error {
+		pb := NewParallelBuilder(BlockSize, 3)
+		pb.minChunkSize = 64 * 1024
+		pb.SetProgress(fn)
+		_, err := pb.BuildFromFile(csvPath)
+		return err
+	})
+}
+
+// TestBuildersNoHeader checks headerless builds index the first row as data
+// and name columns from SetNoHeader, padding with c<N>
+func TestBuildersNoHeader(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&sb, "%d,name%d,%d\n", i, i, i*2)
+	}
+	csvPath := filepath.Join(t.TempDir(), "noheader.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	b := NewBuilder(16)
+	b.SetNoHeader([]string{"id", "name"})
+	seq, err := b.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("sequential build: %v", err)
+	}
+	pb := NewParallelBuilder(16, 3)
+	pb.minChunkSize = 1
+	pb.SetNoHeader([]string{"id", "name"})
+	par, err := pb.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("parallel build: %v", err)
+	}
+
+	for name, index := range map[string]*Index{"sequential": seq, "parallel": par} {
+		if index.Header.TotalRows != 100 {
+			t.Errorf("%s: TotalRows = %d, want 100", name, index.Header.TotalRows)
+		}
+		if index.Header.BuildFlags&BuildFlagNoHeader == 0 {
+			t.Errorf("%s: expected no-header build flag", name)
+		}
+		var names []string
+		for _, col := range index.Header.Columns {
+			names = append(names, col.Name)
+		}
+		if fmt.Sprint(names) != "[id name c3]" {
+			t.Errorf("%s: columns = %v", name, names)
+		}
+		first := index.Blocks[0]
+		if first.StartOffset != 0 || first.Columns[0].MinNum != 0 {
+			t.Errorf("%s: first block starts at %d with min id %v", name, first.StartOffset, first.Columns[0].MinNum)
+		}
+		if err := ValidateIndex(index, csvPath); err != nil {
+			t.Errorf("%s: ValidateIndex() = %v", name, err)
+		}
+	}
+	if !reflect.DeepEqual(par.Blocks, seq.Blocks) {
+		t.Error("parallel blocks differ from sequential")
+	}
+}
+
+func TestBuildersNumberFormat(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,amount\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&sb, "%d,\"%d.%03d,%02d\"\n", i, i, i*7%1000, i%100)
+	}
+	csvPath := filepath.Join(t.TempDir(), "eu.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+	eu := datatype.NumberFormat{Thousands: '.', Decimal: ','}
+
+	b := NewBuilder(16)
+	b.SetNumberFormat(eu)
+	seq, err := b.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("sequential build: %v", err)
+	}
+	pb := NewParallelBuilder(16, 3)
+	pb.minChunkSize = 1
+	pb.SetNumberFormat(eu)
+	par, err := pb.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("parallel build: %v", err)
+	}
+
+	for name, index := range map[string]*Index{"sequential": seq, "parallel": par} {
+		amount := index.Header.Columns[1]
+		if amount.Type != ColumnTypeNumeric || amount.Mixed {
+			t.Errorf("%s: amount is %v (mixed %v), want numeric", name, amount.Type, amount.Mixed)
+		}
+		last := index.Blocks[len(index.Blocks)-1].Columns[1]
+		if last.MaxNum != 99693.99 || last.Max != "99.693,99" {
+			t.Errorf("%s: last block max = %v (%q)", name, last.MaxNum, last.Max)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteIndex(&buf, index); err != nil {
+			t.Fatalf("%s: WriteIndex: %v", name, err)
+		}
+		loaded, err := ReadIndex(&buf)
+		if err != nil {
+			t.Fatalf("%s: ReadIndex: %v", name, err)
+		}
+		if loaded.Header.Numbers != eu {
+			t.Errorf("%s: number format = %+v after reload, want %+v", name, loaded.Header.Numbers, eu)
+		}
+	}
+	if !reflect.DeepEqual(par.Blocks, seq.Blocks) {
+		t.Error("parallel blocks differ from sequential")
+	}
+
+	// Without the format the same values are text
+	plain, err := NewBuilder(16).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("default build: %v", err)
+	}
+	if got := plain.Header.Columns[1].Type; got != ColumnTypeString {
+		t.Errorf("default format: amount is %v, want string", got)
+	}
+}
+
+func TestBuildersStrict(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,name\n")
+	for i := 0; i < 100; i++ {
+		if i == 70 {
+			sb.WriteString("70,x,extra\n")
+			continue
+		}
+		fmt.Fprintf(&sb, "%d,\"n\n%d\"\n", i, i)
+	}
+	csvPath := filepath.Join(t.TempDir(), "bad.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	// Lenient builds index the wide row like any other
+	if _, err := NewBuilder(16).BuildFromFile(csvPath); err != nil {
+		t.Fatalf("lenient build: %v", err)
+	}
+
+	b := NewBuilder(16)
+	b.SetStrict(true)
+	_, seqErr := b.BuildFromFile(csvPath)
+	pb := NewParallelBuilder(16, 3)
+	pb.minChunkSize = 1
+	pb.SetStrict(true)
+	_, parErr := pb.BuildFromFile(csvPath)
+
+	want := "line 72: expected 2 fields, got 3"
+	for name, err := range map[string]error{"sequential": seqErr, "parallel": parErr} {
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: got error %v, want %q", name, err, want)
+		}
+	}
+}
+
+func TestBuildersSums(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,name,amount\n")
+	for i := 0; i < 100; i++ {
+		amount := fmt.Sprintf("%d.%02d", i, i%4*25) // Exact in floating point
+		switch i {
+		case 10:
+			amount = ""
+		case 20:
+			amount = "n/a"
+		case 90:
+			amount = "1e2" // Not a plain decimal
+		}
+		fmt.Fprintf(&sb, "%d,n%d,%s\n", i, i, amount)
+	}
+	csvPath := filepath.Join(t.TempDir(), "sums.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	b := NewBuilder(16)
+	b.SetSumColumns([]string{"AMOUNT"})
+	seq, err := b.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("sequential build: %v", err)
+	}
+	pb := NewParallelBuilder(16, 3)
+	pb.minChunkSize = 1
+	pb.SetSumColumns([]string{"amount"})
+	par, err := pb.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("parallel build: %v", err)
+	}
+	if !reflect.DeepEqual(par.Blocks, seq.Blocks) || !reflect.DeepEqual(par.Header.Columns, seq.Header.Columns) {
+		t.Error("parallel build differs from sequential")
+	}
+
+	if !seq.Header.Columns[2].Sums || seq.Header.Columns[0].Sums {
+		t.Errorf("sums flags = %+v", seq.Header.Columns)
+	}
+	first := seq.Blocks[0].Columns[2] // Rows 0-15, row 10 empty
+	if first.Sum != 115.5 || first.SumInexact || first.SumDecimal.Format(2) != "115.50" {
+		t.Errorf("block 0 sum = %v (%s, inexact %v), want 115.5", first.Sum, first.SumDecimal.Format(2), first.SumInexact)
+	}
+	second := seq.Blocks[1].Columns[2] // Rows 16-31, "n/a" left out
+	if second.Sum != 362 || second.SumInexact {
+		t.Errorf("block 1 sum = %v (inexact %v), want 362", second.Sum, second.SumInexact)
+	}
+	last := seq.Blocks[5].Columns[2] // Rows 80-95 hold 1e2
+	if !last.SumInexact || last.Sum != 1415.5 {
+		t.Errorf("block 5 sum = %v (inexact %v), want inexact 1415.5", last.Sum, last.SumInexact)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, seq); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	loaded, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Blocks, seq.Blocks) || !reflect.DeepEqual(loaded.Header.Columns, seq.Header.Columns) {
+		t.Error("sums changed across a write and read")
+	}
+
+	for _, cols := range [][]string{{"name"}, {"missing"}} {
+		b := NewBuilder(16)
+		b.SetSumColumns(cols)
+		if _, err := b.BuildFromFile(csvPath); err == nil {
+			t.Errorf("sums of %v: expected an error", cols)
+		}
+	}
+}
+
+func TestBuildersBOMAndLatin1(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("\xef\xbb\xbfid,city\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&sb, "%d,K\xf6ln%02d\n", i, i)
+	}
+	dir := t.TempDir()
+	bomPath := filepath.Join(dir, "bom.csv")
+	latin1Path := filepath.Join(dir, "latin1.csv")
+	if err := os.WriteFile(bomPath, []byte(strings.ReplaceAll(sb.String(), "\xf6", "\xc3\xb6")), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+	if err := os.WriteFile(latin1Path, []byte(strings.TrimPrefix(sb.String(), "\xef\xbb\xbf")), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	builds := map[string]func(path, encoding string) (*Index, error){
+		"sequential": func(path, encoding string) (*Index, error) {
+			b := NewBuilder(16)
+			b.SetEncoding(encoding)
+			return b.BuildFromFile(path)
+		},
+		"parallel": func(path, encoding string) (*Index, error) {
+			pb := NewParallelBuilder(16, 3)
+			pb.minChunkSize = 1
+			pb.SetEncoding(encoding)
+			return pb.BuildFromFile(path)
+		},
+	}
+	for name, build := range builds {
+		for path, encoding := range map[string]string{bomPath: "", latin1Path: csvio.EncodingLatin1} {
+			index, err := build(path, encoding)
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, filepath.Base(path), err)
+			}
+			if got := index.Header.Columns[0].Name; got != "id" {
+				t.Errorf("%s %s: first column = %q, want id", name, filepath.Base(path), got)
+			}
+			if got := index.Blocks[0].Columns[1].Min; got != "Köln00" {
+				t.Errorf("%s %s: city min = %q, want Köln00", name, filepath.Base(path), got)
+			}
+			if latin1 := index.Header.BuildFlags&BuildFlagLatin1 != 0; latin1 != (encoding == csvio.EncodingLatin1) {
+				t.Errorf("%s %s: flags = %v", name, filepath.Base(path), index.Header.BuildFlags)
+			}
+		}
+
+		if _, err := build(bomPath, csvio.EncodingUTF16); err == nil {
+			t.Errorf("%s: expected error indexing UTF-16", name)
+		}
+	}
+
+	// A BOM on the first row of a headerless file isn't part of its value
+	b := NewBuilder(16)
+	b.SetNoHeader(nil)
+	b.SetSkipTypeInference(true)
+	index, err := b.BuildFromFile(bomPath)
+	if err != nil {
+		t.Fatalf("no-header build: %v", err)
+	}
+	if got := index.Blocks[0].Columns[0].Max; got != "id" {
+		t.Errorf("no-header: first column max = %q, want id", got)
+	}
+}
+
+func TestBuildersDialect(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id;name;amount\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, "%d;'n;%03d\nx';%d,5\n", i, i, i)
+	}
+	csvPath := filepath.Join(t.TempDir(), "semi.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("create test file: %v", err)
+	}
+
+	seq, err := NewBuilder(16).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("sequential: %v", err)
+	}
+	pb := NewParallelBuilder(16, 3)
+	pb.minChunkSize = 1
+	par, err := pb.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("parallel: %v", err)
+	}
+	want := csvio.Dialect{Delimiter: ';', Quote: '\''}
+	for name, index := range map[string]*Index{"sequential": seq, "parallel": par} {
+		if index.Header.Dialect != want || len(index.Header.Columns) != 3 || index.Header.TotalRows != 200 {
+			t.Fatalf("%s: dialect %v, %d columns, %d rows", name, index.Header.Dialect, len(index.Header.Columns), index.Header.TotalRows)
+		}
+		if got := index.Blocks[1].Columns[1].Min; got != "n;016\nx" {
+			t.Errorf("%s: name min of block 1 = %q", name, got)
+		}
+		if typ := index.Header.Columns[0].Type; typ != ColumnTypeNumeric {
+			t.Errorf("%s: id type = %v", name, typ)
+		}
+	}
+	if !reflect.DeepEqual(seq.Blocks, par.Blocks) {
+		t.Error("parallel blocks differ from sequential")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, seq); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	read, err := ReadIndex(&buf)
+	if err != nil || read.Header.Dialect != want {
+		t.Errorf("read back: dialect %v, %v", read.Header.Dialect, err)
+	}
+
+	// A given dialect is used as is: read as commas, the file has one column
+	b := NewBuilder(16)
+	b.SetDialect(csvio.Dialect{Delimiter: ',', Quote: '"'})
+	b.SetSkipTypeInference(true)
+	index, err := b.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("comma build: %v", err)
+	}
+	if index.Header.Dialect.Delimiter != ',' || index.Header.Columns[0].Name != "id;name;amount" {
+		t.Errorf("comma build: dialect %v, columns %+v", index.Header.Dialect, index.Header.Columns)
+	}
+}
+
+func TestRowsPerBlock(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, rows int, width int) string {
+		var sb strings.Builder
+		sb.WriteString("id,payload\n")
+		for i := 0; i < rows; i++ {
+			fmt.Fprintf(&sb, "%06d,%s\n", i, strings.Repeat("x", width))
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+			t.Fatalf("write csv: %v", err)
+		}
+		return path
+	}
+
+	// Rows of 16 and 256 bytes: the wide file gets 16 times fewer rows
+	thin := write("thin.csv", 10000, 8)
+	wide := write("wide.csv", 10000, 248)
+	for _, tc := range []struct {
+		path   string
+		target int64
+		want   uint32
+	}{
+		{thin, 64 << 10, 4096},
+		{wide, 64 << 10, 256},
+		{wide, 1 << 10, MinRowsPerBlock},
+		{thin, 1 << 40, MaxRowsPerBlock},
+	} {
+		got, err := RowsPerBlock(tc.path, tc.target, false)
+		if err != nil {
+			t.Fatalf("RowsPerBlock(%s): %v", tc.path, err)
+		}
+		if got != tc.want {
+			t.Errorf("RowsPerBlock(%s, %d) = %d, want %d", filepath.Base(tc.path), tc.target, got, tc.want)
+		}
+	}
+
+	// Without data rows there's nothing to measure
+	empty := write("empty.csv", 0, 0)
+	if got, err := RowsPerBlock(empty, DefaultBlockBytes, false); err != nil || got != BlockSize {
+		t.Errorf("header-only file: got %d, %v; want %d", got, err, BlockSize)
+	}
+}
@@ -0,0 +1,219 @@
+package sidx
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCache is the process-wide index cache.
+// Long-running processes issuing many queries against the same files share a
+// single parsed copy of each index instead of re-reading it per query.
+var DefaultCache = NewCache(64)
+
+// Cache shares parsed indexes between concurrent queries. Entries are
+// reference counted and revalidated on every Acquire: if the CSV or its .sidx
+// file changed on disk, including a CSV rewritten with its mtime kept (which
+// the content fingerprint catches), the old entry is dropped once its last
+// user releases it.
+type Cache struct {
+	mu         sync.Mutex
+	entries    map[string]*cacheEntry
+	maxEntries int
+}
+
+type cacheEntry struct {
+	index    *Index
+	csvSize  int64
+	csvMtime time.Time
+	idxSize  int64
+	idxMtime time.Time
+	keyStamp string // Size and mtime of the .skey sidecar, empty without one
+	refs     int
+	stale    bool
+	lastUsed time.Time
+}
+
+// NewCache creates a cache that keeps at most maxEntries unreferenced indexes.
+func NewCache(maxEntries int) *Cache {
+	return &Cache{
+		entries:    make(map[string]*cacheEntry),
+		maxEntries: maxEntries,
+	}
+}
+
+// Acquire returns the validated index for csvPath. The release function must
+// be called once the caller is done with the index; the index must not be
+// modified. When no .sidx file exists, Acquire returns a nil index and nil
+// error. A stale or unreadable index is reported as an error.
+func (c *Cache) Acquire(csvPath string) (*Index, func(), error) {
+	noop := func() {}
+
+	idxStat, err := os.Stat(csvPath + ".sidx")
+	if os.IsNotExist(err) {
+		return nil, noop, nil
+	}
+	if err != nil {
+		return nil, noop, fmt.Errorf("stat index: %w", err)
+	}
+	csvStat, err := os.Stat(csvPath)
+	if err != nil {
+		return nil, noop, fmt.Errorf("stat CSV: %w", err)
+	}
+	keys := keyStamp(csvPath)
+	// Size and mtime survive cp -p and touch -r, so a long-lived process
+	// checks the content fingerprint too, as loading the index does
+	checksum, err := Fingerprint(csvPath)
+	if err != nil {
+		return nil, noop, fmt.Errorf("fingerprint CSV: %w", err)
+	}
+
+	c.mu.Lock()
+	if entry, ok := c.entries[csvPath]; ok {
+		if entry.matches(csvStat, idxStat, keys, checksum) {
+			entry.refs++
+			entry.lastUsed = time.Now()
+			c.mu.Unlock()
+			return entry.index, c.releaser(csvPath, entry), nil
+		}
+		c.invalidateLocked(csvPath, entry)
+	}
+	c.mu.Unlock()
+
+	// Load outside the lock so slow reads don't block other files
+	index, err := loadIndexFile(csvPath)
+	if err != nil {
+		return nil, noop, err
+	}
+
+	entry := &cacheEntry{
+		index:    index,
+		csvSize:  csvStat.Size(),
+		csvMtime: csvStat.ModTime(),
+		idxSize:  idxStat.Size(),
+		idxMtime: idxStat.ModTime(),
+		keyStamp: keys,
+		refs:     1,
+		lastUsed: time.Now(),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.entries[csvPath]; ok {
+		if existing.matches(csvStat, idxStat, keys, checksum) {
+			// Another query loaded the same index concurrently; share theirs
+			existing.refs++
+			existing.lastUsed = time.Now()
+			return existing.index, c.releaser(csvPath, existing), nil
+		}
+		c.invalidateLocked(csvPath, existing)
+	}
+	c.entries[csvPath] = entry
+	c.evictLocked()
+	return index, c.releaser(csvPath, entry), nil
+}
+
+// Invalidate drops the cached index for csvPath (e.g. after a rebuild).
+func (c *Cache) Invalidate(csvPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[csvPath]; ok {
+		c.invalidateLocked(csvPath, entry)
+	}
+}
+
+// Len returns the number of cached indexes.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *Cache) releaser(csvPath string, entry *cacheEntry) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			entry.refs--
+			if entry.stale && entry.refs == 0 && c.entries[csvPath] == entry {
+				delete(c.entries, csvPath)
+			}
+			c.evictLocked()
+		})
+	}
+}
+
+// invalidateLocked marks an entry stale; it is removed once unreferenced
+func (c *Cache) invalidateLocked(csvPath string, entry *cacheEntry) {
+	entry.stale = true
+	if c.entries[csvPath] == entry {
+		delete(c.entries, csvPath)
+	}
+}
+
+// evictLocked drops least recently used unreferenced entries beyond maxEntries
+func (c *Cache) evictLocked() {
+	for len(c.entries) > c.maxEntries {
+		var oldestPath string
+		var oldest *cacheEntry
+		for path, entry := range c.entries {
+			if entry.refs == 0 && (oldest == nil || entry.lastUsed.Before(oldest.lastUsed)) {
+				oldestPath, oldest = path, entry
+			}
+		}
+		if oldest == nil {
+			return // Everything is in use
+		}
+		delete(c.entries, oldestPath)
+	}
+}
+
+func (e *cacheEntry) matches(csvStat, idxStat os.FileInfo, keys string, checksum uint64) bool {
+	return e.csvSize == csvStat.Size() && e.csvMtime.Equal(csvStat.ModTime()) &&
+		e.index.Header.FileChecksum == checksum &&
+		e.idxSize == idxStat.Size() && e.idxMtime.Equal(idxStat.ModTime()) &&
+		e.keyStamp == keys
+}
+
+// keyStamp identifies the current .skey sidecar of csvPath by size and
+// modification time, or is empty when there is none
+func keyStamp(csvPath string) string {
+	stat, err := os.Stat(csvPath + ".skey")
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d %d", stat.Size(), stat.ModTime().UnixNano())
+}
+
+// loadIndexFile reads csvPath's .sidx sidecar and validates it against the CSV
+func loadIndexFile(csvPath string) (*Index, error) {
+	f, err := os.Open(csvPath + ".sidx")
+	if err != nil {
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil && os.Getenv("SIDX_DEBUG") == "1" {
+			fmt.Fprintf(os.Stderr, "[sidx] Failed to close index file: %v\n", err)
+		}
+	}()
+
+	index, err := ReadIndexLazy(bufio.NewReaderSize(f, 256*1024))
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	if index.Header.Version < MinUsableVersion {
+		return nil, fmt.Errorf("index format v%d is outdated (need v%d+); rebuild with 'sieswi index --upgrade'",
+			index.Header.Version, MinUsableVersion)
+	}
+	if err := ValidateIndex(index, csvPath); err != nil {
+		return nil, fmt.Errorf("stale index: %w", err)
+	}
+	// Key maps only narrow pruning, so one that can't be used is ignored
+	if index.Keys, err = LoadKeyMaps(csvPath, index); err != nil && os.Getenv("SIDX_DEBUG") == "1" {
+		fmt.Fprintf(os.Stderr, "[sidx] Not using key maps: %v\n", err)
+	}
+	return index, nil
+}
@@ -0,0 +1,217 @@
+package sidx
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeIndexedCSV(t *testing.T, csvPath, content string) {
+	t.Helper()
+	if err := os.WriteFile(csvPath, []byte(content), 0644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	idx, err := NewBuilder(2).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+	f, err := os.Create(csvPath + ".sidx")
+	if err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	defer f.Close()
+	if err := WriteIndex(f, idx); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+}
+
+func TestCacheSharesIndexAcrossAcquires(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	writeIndexedCSV(t, csvPath, "id,name\n1,a\n2,b\n3,c\n")
+
+	cache := NewCache(4)
+	var wg sync.WaitGroup
+	indexes := make([]*Index, 8)
+	for i := range indexes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			idx, release, err := cache.Acquire(csvPath)
+			if err != nil {
+				t.Errorf("Acquire: %v", err)
+				return
+			}
+			defer release()
+			indexes[i] = idx
+		}(i)
+	}
+	wg.Wait()
+
+	idx, release, err := cache.Acquire(csvPath)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer release()
+	if idx == nil || idx.Header.NumBlocks != 2 {
+		t.Fatalf("unexpected index: %+v", idx)
+	}
+	// After the concurrent warm-up every later query shares one parsed copy
+	for i, got := range indexes {
+		if got == nil {
+			t.Fatalf("acquire %d returned nil index", i)
+		}
+	}
+	again, releaseAgain, _ := cache.Acquire(csvPath)
+	releaseAgain()
+	if again != idx {
+		t.Error("expected repeated Acquire to return the shared index")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("expected 1 cached entry, got %d", cache.Len())
+	}
+}
+
+func TestCacheInvalidatesOnFileChange(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	writeIndexedCSV(t, csvPath, "id,name\n1,a\n2,b\n")
+
+	cache := NewCache(4)
+	first, release, err := cache.Acquire(csvPath)
+	if err != nil || first == nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	// Rewrite CSV and index while the old index is still in use
+	writeIndexedCSV(t, csvPath, "id,name\n1,a\n2,b\n3,c\n4,d\n5,e\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(csvPath+".sidx", later, later); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	second, release2, err := cache.Acquire(csvPath)
+	if err != nil {
+		t.Fatalf("Acquire after change: %v", err)
+	}
+	defer release2()
+	if second == first {
+		t.Fatal("expected a reloaded index after the files changed")
+	}
+	if second.Header.NumBlocks != 3 {
+		t.Errorf("expected 3 blocks in rebuilt index, got %d", second.Header.NumBlocks)
+	}
+
+	// The old holder keeps a usable index until it releases it
+	if first.Header.NumBlocks != 1 {
+		t.Errorf("old index was mutated: %d blocks", first.Header.NumBlocks)
+	}
+	release()
+	if cache.Len() != 1 {
+		t.Errorf("expected only the fresh entry to remain, got %d", cache.Len())
+	}
+}
+
+func TestCacheRejectsRewriteKeepingMtime(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	writeIndexedCSV(t, csvPath, "id,name\n1,a\n2,b\n")
+	stat, err := os.Stat(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache(4)
+	_, release, err := cache.Acquire(csvPath)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	release()
+
+	// Same size and mtime, as cp -p or touch -r leave a rewritten file
+	if err := os.WriteFile(csvPath, []byte("id,name\n7,x\n8,y\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(csvPath, stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if index, release, err := cache.Acquire(csvPath); err == nil {
+		release()
+		t.Fatalf("Acquire after rewrite returned the old index (%d blocks), want a stale index error", index.Header.NumBlocks)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("stale entry still cached: %d entries", cache.Len())
+	}
+}
+
+func TestCacheMissingAndStaleIndexes(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewCache(4)
+
+	plain := filepath.Join(dir, "plain.csv")
+	if err := os.WriteFile(plain, []byte("a\n1\n"), 0644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	idx, release, err := cache.Acquire(plain)
+	release()
+	if idx != nil || err != nil {
+		t.Errorf("expected no index and no error, got %v, %v", idx, err)
+	}
+
+	stale := filepath.Join(dir, "stale.csv")
+	writeIndexedCSV(t, stale, "a\n1\n")
+	if err := os.WriteFile(stale, []byte("a\n1\n2\n"), 0644); err != nil {
+		t.Fatalf("rewrite csv: %v", err)
+	}
+	idx, release, err = cache.Acquire(stale)
+	release()
+	if idx != nil || err == nil {
+		t.Errorf("expected stale index error, got %v, %v", idx, err)
+	}
+}
+
+func TestCacheRejectsTruncatedIndex(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	writeIndexedCSV(t, csvPath, "id,name\n1,a\n2,b\n3,c\n")
+	full, err := os.ReadFile(csvPath + ".sidx")
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+
+	// Every cut, including an empty file, reads as truncated
+	for n := 0; n < len(full); n++ {
+		for _, read := range []func(io.Reader) (*Index, error){ReadIndex, ReadIndexLazy} {
+			if _, err := read(bytes.NewReader(full[:n])); err != ErrTruncated {
+				t.Fatalf("index cut to %d of %d bytes: err = %v, want ErrTruncated", n, len(full), err)
+			}
+		}
+	}
+
+	if err := os.WriteFile(csvPath+".sidx", full[:len(full)/2], 0644); err != nil {
+		t.Fatalf("truncate index: %v", err)
+	}
+	idx, release, err := NewCache(4).Acquire(csvPath)
+	release()
+	if idx != nil || !errors.Is(err, ErrTruncated) {
+		t.Errorf("expected a truncated index error, got %v, %v", idx, err)
+	}
+}
+
+func TestCacheEvictsUnreferencedEntries(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewCache(2)
+	for _, name := range []string{"a.csv", "b.csv", "c.csv"} {
+		path := filepath.Join(dir, name)
+		writeIndexedCSV(t, path, "x\n1\n")
+		_, release, err := cache.Acquire(path)
+		if err != nil {
+			t.Fatalf("Acquire %s: %v", name, err)
+		}
+		release()
+	}
+	if cache.Len() != 2 {
+		t.Errorf("expected cache capped at 2 entries, got %d", cache.Len())
+	}
+}
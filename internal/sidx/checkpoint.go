@@ -0,0 +1,131 @@
+package sidx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrCanceled is returned by builds stopped through SetCancel
+var ErrCanceled = errors.New("index build canceled")
+
+// checkpointMagic starts a checkpoint file, ahead of the partial index
+const checkpointMagic = "SCKP"
+
+// checkpointEveryBytes is how much CSV a build covers between checkpoints
+const checkpointEveryBytes = 64 << 20
+
+// canceled reports whether done has been closed; a nil channel never is
+func canceled(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkpoint is the state of an interrupted build at a block boundary:
+// the blocks finished so far and the distinct value sketches of their rows
+type checkpoint struct {
+	index    *Index
+	sketches []hyperLogLog
+}
+
+// writeCheckpoint replaces the checkpoint at path through a temporary
+// file, so an interrupted write leaves the previous checkpoint in place.
+// Non-string columns are marked mixed to keep the string bounds of every
+// block: the rows still to come decide which columns really are.
+func writeCheckpoint(path string, index *Index, sketches []hyperLogLog) (err error) {
+	partial := *index
+	partial.Header.Columns = append([]ColumnInfo(nil), index.Header.Columns...)
+	for i := range partial.Header.Columns {
+		partial.Header.Columns[i].Mixed = partial.Header.Columns[i].Type != ColumnTypeString
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create checkpoint: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if _, err := w.WriteString(checkpointMagic); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(sketches))); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	for i := range sketches {
+		if _, err := w.Write(sketches[i].registers[:]); err != nil {
+			return fmt.Errorf("write checkpoint: %w", err)
+		}
+	}
+	if err := WriteIndex(w, &partial); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readCheckpoint loads the checkpoint at path
+func readCheckpoint(path string) (*checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != checkpointMagic {
+		return nil, fmt.Errorf("%s is not an index checkpoint", path)
+	}
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, ErrTruncated
+	}
+	ck := &checkpoint{sketches: make([]hyperLogLog, n)}
+	for i := range ck.sketches {
+		if _, err := io.ReadFull(r, ck.sketches[i].registers[:]); err != nil {
+			return nil, ErrTruncated
+		}
+	}
+	if ck.index, err = ReadIndex(r); err != nil {
+		return nil, err
+	}
+	return ck, nil
+}
+
+// resumes reports whether ck was saved by a build of the same file with the
+// same options as the one whose index would start out as want
+func (ck *checkpoint) resumes(want *Header) bool {
+	got := &ck.index.Header
+	if got.Version != want.Version || got.BlockSize != want.BlockSize ||
+		got.FileSize != want.FileSize || got.FileMtime != want.FileMtime || got.FileChecksum != want.FileChecksum ||
+		got.BuildFlags != want.BuildFlags || got.Numbers != want.Numbers || got.Dialect != want.Dialect ||
+		len(got.Columns) != len(want.Columns) || len(ck.sketches) != len(want.Columns) || len(ck.index.Blocks) == 0 {
+		return false
+	}
+	for i, c := range got.Columns {
+		w := want.Columns[i]
+		if c.Name != w.Name || c.Type != w.Type || c.Ordinal != w.Ordinal || c.Sums != w.Sums {
+			return false
+		}
+	}
+	return true
+}
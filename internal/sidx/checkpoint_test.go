@@ -0,0 +1,78 @@
+package sidx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCanceledBuildResumesFromCheckpoint(t *testing.T) {
+	// amount only turns mixed after the checkpoint, so the blocks saved in it
+	// must keep their string bounds
+	var sb strings.Builder
+	sb.WriteString("id,name,amount\n")
+	for i := 0; i < 500; i++ {
+		amount := fmt.Sprintf("%d.5", i%40)
+		if i == 400 {
+			amount = "n/a"
+		}
+		fmt.Fprintf(&sb, "%d,name-%03d,%s\n", i, i%97, amount)
+	}
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	want, err := NewBuilder(64).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+
+	// Canceled from the start, the build stops after its first block
+	ckPath := csvPath + ".sidx.partial"
+	stop := make(chan struct{})
+	close(stop)
+	b := NewBuilder(64)
+	b.SetCancel(stop)
+	b.SetCheckpoint(ckPath)
+	if _, err := b.BuildFromFile(csvPath); !errors.Is(err, ErrCanceled) {
+		t.Fatalf("canceled build: err = %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".*tmp-*")); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+
+	b = NewBuilder(64)
+	b.SetCheckpoint(ckPath)
+	got, err := b.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("resumed build: %v", err)
+	}
+	if b.ResumedRows() != 64 {
+		t.Errorf("ResumedRows = %d, want 64", b.ResumedRows())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("resumed build doesn't match an uninterrupted one")
+	}
+
+	// A checkpoint from other options is ignored
+	b = NewBuilder(50)
+	b.SetCheckpoint(ckPath)
+	got, err = b.BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build with other options: %v", err)
+	}
+	if b.ResumedRows() != 0 || got.Header.NumBlocks != 10 {
+		t.Errorf("checkpoint reused for another block size: resumed %d rows, %d blocks", b.ResumedRows(), got.Header.NumBlocks)
+	}
+
+	pb := NewParallelBuilder(64, 2)
+	pb.SetCancel(stop)
+	if _, err := pb.BuildFromFile(csvPath); !errors.Is(err, ErrCanceled) {
+		t.Errorf("canceled parallel build: err = %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package sidx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+)
+
+// fingerprintSampleSize is how many bytes are hashed from each end of the CSV.
+const fingerprintSampleSize = 64 * 1024
+
+var fingerprintTable = crc64.MakeTable(crc64.ECMA)
+
+// Fingerprint computes a cheap content hash of a CSV file: CRC-64 over the
+// file size and the first and last 64KB. It is stored in the index header so
+// validation survives copies that reset mtime (cp, rsync without -t) and
+// catches edits that preserve it (touch -r, cp -p over a changed file).
+func Fingerprint(csvPath string) (uint64, error) {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat file: %w", err)
+	}
+	return fingerprintReader(f, stat.Size())
+}
+
+func fingerprintReader(r io.ReaderAt, size int64) (uint64, error) {
+	h := crc64.New(fingerprintTable)
+
+	var sizeBuf [8]byte
+	binary.LittleEndian.PutUint64(sizeBuf[:], uint64(size))
+	h.Write(sizeBuf[:])
+
+	head := size
+	if head > fingerprintSampleSize {
+		head = fingerprintSampleSize
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, head)); err != nil {
+		return 0, fmt.Errorf("read head for fingerprint: %w", err)
+	}
+
+	// The tail sample never overlaps the head; small files are hashed whole
+	tailStart := size - fingerprintSampleSize
+	if tailStart < head {
+		tailStart = head
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(r, tailStart, size-tailStart)); err != nil {
+		return 0, fmt.Errorf("read tail for fingerprint: %w", err)
+	}
+
+	return h.Sum64(), nil
+}
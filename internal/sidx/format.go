@@ -1,9 +1,15 @@
 package sidx
 
 import (
+	"compress/flate"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
 )
 
 // File format:
@@ -14,13 +20,33 @@ import (
 //   - NumBlocks: uint32 (4 bytes)
 //   - FileSize: int64 (8 bytes) - source CSV file size
 //   - FileMtime: int64 (8 bytes) - source CSV modification time (Unix nanos)
+//   - FileChecksum: uint64 (8 bytes) - CRC-64 of size + first/last 64KB (version 6+)
+//   - TotalRows: uint64 (8 bytes) - data rows in the CSV (version 7+)
+//   - BuildVersionLen: uint32 (4 bytes) (version 5+)
+//   - BuildVersion: string (BuildVersionLen bytes) - sieswi release that built the index
+//   - BuildFlags: uint32 (4 bytes) - options used for the build (version 5+)
+//   - Thousands, Decimal: uint8 each (2 bytes) - number format of the numeric stats, 0 for default (version 10+)
+//   - Delimiter, Quote: uint8 each (2 bytes) - CSV dialect of the source, 0 for comma and double quote (version 16+)
 //   - NumColumns: uint32 (4 bytes) - column count in dictionary
 //   - For each column in dictionary:
 //     - NameLen: uint32 (4 bytes)
 //     - Name: string (NameLen bytes)
-//     - Type: uint8 (1 byte) - 0=string, 1=numeric
+//     - Type: uint8 (1 byte) - 0=string, 1=numeric, 2=timestamp, 3=boolean (version 11+)
+//     - Ordinal: uint32 (4 bytes) - position of the column in the CSV header (version 4+)
+//     - Distinct: uint64 (8 bytes) - estimated distinct non-empty values (version 7+)
+//     - Mixed: uint8 (1 byte) - 1 if a numeric or timestamp column also holds other values (version 9+)
+//     - Sums: uint8 (1 byte) - 1 if the blocks hold sums of the column (version 12+)
+//     - Sorted: uint8 (1 byte) - 1 if the blocks are in order of the column's bounds (version 15+)
+//   - Compressed: uint8 (1 byte) - 1 if the block section below is a DEFLATE stream (version 13+)
+//   - BoundLength: uint16 (2 bytes) - string bounds cut to this many bytes, 0 if exact (version 13+)
+//
+// Version 14+ stores blocks column by column, in sections that each start
+// with their stored length as a uint64 and are DEFLATE streams of their own
+// when Compressed:
+//   - Block ranges: StartRow, EndRow, StartOffset, EndOffset uint64 of each block
+//   - For each column in dictionary: its stats in each block, encoded as below
 //
-// For each block:
+// Before version 14, for each block:
 //   - StartRow: uint64 (8 bytes)
 //   - EndRow: uint64 (8 bytes)
 //   - StartOffset: uint64 (8 bytes) - actual byte position in CSV
@@ -30,40 +56,149 @@ import (
 //     - Min: string (MinLen bytes)
 //     - MaxLen: uint32 (4 bytes)
 //     - Max: string (MaxLen bytes)
+//     - EmptyCount: uint32 (4 bytes) (version 3+)
+//     - For numeric columns (version 8+): MinNum, MaxNum float64 (16 bytes), InvalidCount uint32
+//     - For timestamp columns (version 8+): MinTime, MaxTime int64 Unix nanos (16 bytes), InvalidCount uint32
+//     - For boolean columns (version 11+): MinNum, MaxNum float64 (0 false, 1 true), InvalidCount uint32
+//     - For mixed columns (version 9+): StrMinLen uint32, StrMin, StrMaxLen uint32, StrMax - lexicographic bounds of all values
+//     - For columns with sums (version 12+): Sum float64, SumInexact uint8, SumUnits int64, SumScale uint8 - sum of the valid values, exact as SumUnits/10^SumScale unless SumInexact is 1
+//
+// With BoundLength set, Min/Max of string columns and StrMin/StrMax of mixed
+// columns longer than it are cut: a lower bound to its prefix, an upper bound
+// to its prefix with the last byte incremented. Both still bound every value
+// of the block, so pruning stays correct, only less tight for long values.
 
 const (
 	Magic      = "SIDX"
-	Version    = 3     // Bumped to add EmptyCount to ColumnStats
+	Version    = 16    // Bumped for the CSV dialect
 	BlockSize  = 32768 // 32K rows per block (optimized based on benchmarks)
 	HeaderSize = 32    // Base size without column dictionary
+
+	// MinUsableVersion is the oldest format the engine prunes with. Earlier
+	// builds stored lexicographic bounds for numeric columns, which can't be
+	// trusted for numeric predicates.
+	MinUsableVersion = 8
 )
 
 type ColumnType uint8
 
 const (
-	ColumnTypeString  ColumnType = 0
-	ColumnTypeNumeric ColumnType = 1
+	ColumnTypeString    ColumnType = 0
+	ColumnTypeNumeric   ColumnType = 1
+	ColumnTypeTimestamp ColumnType = 2 // ISO 8601 dates and date-times
+	ColumnTypeBoolean   ColumnType = 3 // true/false, yes/no, 1/0 (v11+)
+)
+
+func (t ColumnType) String() string {
+	switch t {
+	case ColumnTypeString:
+		return "string"
+	case ColumnTypeNumeric:
+		return "numeric"
+	case ColumnTypeTimestamp:
+		return "timestamp"
+	case ColumnTypeBoolean:
+		return "boolean"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// BuildFlags records the options an index was built with, so indexes from
+// incompatible or buggy builds can be detected and rebuilt.
+type BuildFlags uint32
+
+const (
+	BuildFlagParallel          BuildFlags = 1 << iota // Built by ParallelBuilder
+	BuildFlagSkipTypeInference                        // All columns stored as strings
+	BuildFlagPartialColumns                           // Only a subset of columns indexed
+	BuildFlagNoHeader                                 // First row is data; column names were supplied
+	BuildFlagLatin1                                   // Values were decoded from latin1
 )
 
+func (f BuildFlags) String() string {
+	var names []string
+	if f&BuildFlagParallel != 0 {
+		names = append(names, "parallel")
+	}
+	if f&BuildFlagSkipTypeInference != 0 {
+		names = append(names, "skip-type-inference")
+	}
+	if f&BuildFlagPartialColumns != 0 {
+		names = append(names, "partial-columns")
+	}
+	if f&BuildFlagNoHeader != 0 {
+		names = append(names, "no-header")
+	}
+	if f&BuildFlagLatin1 != 0 {
+		names = append(names, "latin1")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
 type ColumnInfo struct {
-	Name string
-	Type ColumnType
+	Name     string
+	Type     ColumnType
+	Ordinal  uint32 // Position in the CSV header (indexes may cover a subset of columns)
+	Distinct uint64 // HyperLogLog estimate of distinct non-empty values (zero before v7)
+	// Mixed marks a numeric or timestamp column with values that don't parse
+	// as its type; its blocks also carry string bounds (v9+)
+	Mixed bool
+	// Sums marks a numeric column whose blocks carry the sum of their values,
+	// requested at build time (v12+)
+	Sums bool
+	// Sorted marks a column whose blocks are in order: each block's bounds
+	// start at or after the end of the previous block's (v15+). String
+	// columns cut by BoundLength are never marked.
+	Sorted bool
 }
 
 type Header struct {
-	Magic     [4]byte
-	Version   uint32
-	BlockSize uint32
-	NumBlocks uint32
-	FileSize  int64        // Source CSV size for validation
-	FileMtime int64        // Source CSV mtime (Unix nanos) for validation
-	Columns   []ColumnInfo // Column dictionary
+	Magic        [4]byte
+	Version      uint32
+	BlockSize    uint32
+	NumBlocks    uint32
+	FileSize     int64        // Source CSV size for validation
+	FileMtime    int64        // Source CSV mtime (Unix nanos), used to validate pre-v6 indexes
+	FileChecksum uint64       // Content fingerprint of the source CSV (zero before v6)
+	TotalRows    uint64       // Data rows in the source CSV (zero before v7)
+	Columns      []ColumnInfo // Column dictionary
+
+	BuildVersion string     // sieswi version that built the index (empty before v5)
+	BuildFlags   BuildFlags // Build options (zero before v5)
+
+	Numbers datatype.NumberFormat // How numeric values were read (default before v10)
+	Dialect csvio.Dialect         // How fields were separated and quoted (default before v16)
+
+	Compressed  bool   // Block section is DEFLATE-compressed (v13+)
+	BoundLength uint16 // String bounds are cut to this many bytes; zero keeps them exact (v13+)
 }
 
 type ColumnStats struct {
-	Min        string // String representation, compared per column type
-	Max        string
-	EmptyCount uint32 // Number of empty/null values in this column for this block
+	Min string // Lexicographic bounds for string columns; source text of the typed bounds otherwise
+	Max string
+
+	MinNum  float64 // Numeric column bounds (v8+)
+	MaxNum  float64
+	MinTime int64 // Timestamp column bounds in Unix nanos (v8+)
+	MaxTime int64
+
+	EmptyCount   uint32 // Number of empty/null values in this column for this block
+	InvalidCount uint32 // Non-empty values that don't parse as the column type (v8+)
+
+	// Lexicographic bounds of every non-empty value, kept for mixed columns
+	// so string predicates can prune them too (v9+)
+	StrMin, StrMax string
+
+	// Sum of the values that parse as numbers, for columns with Sums (v12+).
+	// SumDecimal is the exact sum unless SumInexact is set: a value wasn't a
+	// plain decimal or the total didn't fit.
+	Sum        float64
+	SumDecimal datatype.Decimal
+	SumInexact bool
 }
 
 type BlockMeta struct {
@@ -77,6 +212,63 @@ type BlockMeta struct {
 type Index struct {
 	Header Header
 	Blocks []BlockMeta
+
+	// Keys holds the key maps of the .skey sidecar, when it has one built
+	// for this index (see BuildKeyMaps)
+	Keys []*KeyMap
+
+	// lazy holds the encoded column sections of an index read by
+	// ReadIndexLazy, whose blocks have no Columns
+	lazy *lazyStats
+}
+
+// DistinctCount returns the estimated number of distinct non-empty values in
+// the named column. ok is false if the column is not indexed or the index
+// predates distinct estimates.
+func (idx *Index) DistinctCount(colName string) (n uint64, ok bool) {
+	if idx.Header.Version < 7 {
+		return 0, false
+	}
+	for _, col := range idx.Header.Columns {
+		if strings.EqualFold(col.Name, strings.TrimSpace(colName)) {
+			return col.Distinct, true
+		}
+	}
+	return 0, false
+}
+
+// Column returns the dictionary position of the named column; ok is false
+// if it isn't indexed
+func (idx *Index) Column(name string) (i int, ok bool) {
+	i, _, ok = findColumn(idx, name)
+	return i, ok
+}
+
+// ColumnSummary is the file-wide view of one column's block statistics
+type ColumnSummary struct {
+	Min, Max string // Source text of the smallest and largest values ("" if all empty)
+	Empty    uint64 // Rows with an empty value
+	Invalid  uint64 // Non-empty values that don't parse as the column type
+}
+
+// Summarize folds the block statistics of dictionary column i into
+// file-wide bounds and counts.
+func (idx *Index) Summarize(i int) ColumnSummary {
+	t := idx.Header.Columns[i].Type
+	var merged ColumnStats
+	var sum ColumnSummary
+	for b := range idx.Blocks {
+		col := idx.Stats(&idx.Blocks[b], i)
+		if col == nil {
+			continue
+		}
+		// Counts are summed here since the per-block fields are uint32
+		sum.Empty += uint64(col.EmptyCount)
+		sum.Invalid += uint64(col.InvalidCount)
+		mergeColumnStats(&merged, col, t)
+	}
+	sum.Min, sum.Max = merged.Min, merged.Max
+	return sum
 }
 
 func WriteIndex(w io.Writer, idx *Index) error {
@@ -99,6 +291,35 @@ func WriteIndex(w io.Writer, idx *Index) error {
 	if err := binary.Write(w, binary.LittleEndian, idx.Header.FileMtime); err != nil {
 		return err
 	}
+	if err := binary.Write(w, binary.LittleEndian, idx.Header.FileChecksum); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, idx.Header.TotalRows); err != nil {
+		return err
+	}
+
+	// Write build info
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(idx.Header.BuildVersion))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(idx.Header.BuildVersion)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(idx.Header.BuildFlags)); err != nil {
+		return err
+	}
+	if idx.Header.Version >= 10 {
+		numbers := [2]byte{idx.Header.Numbers.Thousands, idx.Header.Numbers.Decimal}
+		if _, err := w.Write(numbers[:]); err != nil {
+			return err
+		}
+	}
+	if idx.Header.Version >= 16 {
+		dialect := [2]byte{idx.Header.Dialect.Delimiter, idx.Header.Dialect.Quote}
+		if _, err := w.Write(dialect[:]); err != nil {
+			return err
+		}
+	}
 
 	// Write column dictionary
 	if err := binary.Write(w, binary.LittleEndian, uint32(len(idx.Header.Columns))); err != nil {
@@ -114,9 +335,59 @@ func WriteIndex(w io.Writer, idx *Index) error {
 		if err := binary.Write(w, binary.LittleEndian, uint8(col.Type)); err != nil {
 			return err
 		}
+		if err := binary.Write(w, binary.LittleEndian, col.Ordinal); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, col.Distinct); err != nil {
+			return err
+		}
+		if idx.Header.Version >= 9 {
+			if err := binary.Write(w, binary.LittleEndian, boolByte(col.Mixed)); err != nil {
+				return err
+			}
+		}
+		if idx.Header.Version >= 12 {
+			if err := binary.Write(w, binary.LittleEndian, boolByte(col.Sums)); err != nil {
+				return err
+			}
+		}
+		if idx.Header.Version >= 15 {
+			// Cut string bounds may overlap where the exact ones didn't
+			sorted := col.Sorted && (col.Type != ColumnTypeString || idx.Header.BoundLength == 0)
+			if err := binary.Write(w, binary.LittleEndian, boolByte(sorted)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if idx.Header.Version < 13 {
+		return writeBlocks(w, idx)
+	}
+	if _, err := w.Write([]byte{boolByte(idx.Header.Compressed)}); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, idx.Header.BoundLength); err != nil {
+		return err
+	}
+	if idx.Header.Version >= 14 {
+		return writeSections(w, idx)
 	}
+	if !idx.Header.Compressed {
+		return writeBlocks(w, idx)
+	}
+	fw, err := flate.NewWriter(w, flate.BestCompression)
+	if err != nil {
+		return err
+	}
+	if err := writeBlocks(fw, idx); err != nil {
+		return err
+	}
+	return fw.Close()
+}
 
-	// Write blocks (no column names, just stats)
+// writeBlocks writes the stats of every block (no column names, order
+// matches the dictionary)
+func writeBlocks(w io.Writer, idx *Index) error {
 	for _, block := range idx.Blocks {
 		if err := binary.Write(w, binary.LittleEndian, block.StartRow); err != nil {
 			return err
@@ -132,34 +403,130 @@ func WriteIndex(w io.Writer, idx *Index) error {
 		}
 
 		// Write stats for each column (order matches dictionary)
-		for _, col := range block.Columns {
-			// Min value
-			if err := binary.Write(w, binary.LittleEndian, uint32(len(col.Min))); err != nil {
-				return err
-			}
-			if _, err := w.Write([]byte(col.Min)); err != nil {
+		for j := range block.Columns {
+			if err := writeColumnStats(w, idx, j, &block.Columns[j]); err != nil {
 				return err
 			}
+		}
+	}
+
+	return nil
+}
+
+// writeColumnStats writes the stats of dictionary column j in one block
+func writeColumnStats(w io.Writer, idx *Index, j int, col *ColumnStats) error {
+	n := int(idx.Header.BoundLength)
 
-			// Max value
-			if err := binary.Write(w, binary.LittleEndian, uint32(len(col.Max))); err != nil {
+	// Min and max value; typed columns keep the source text whole
+	min, max := col.Min, col.Max
+	if idx.Header.Columns[j].Type == ColumnTypeString {
+		min, max = truncateMin(min, n), truncateMax(max, n)
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(min))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(min)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(max))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(max)); err != nil {
+		return err
+	}
+
+	// Empty count
+	if err := binary.Write(w, binary.LittleEndian, col.EmptyCount); err != nil {
+		return err
+	}
+
+	if err := writeTypedStats(w, col, idx.Header.Columns[j].Type); err != nil {
+		return err
+	}
+	if idx.Header.Version >= 9 && idx.Header.Columns[j].Mixed {
+		for _, s := range []string{truncateMin(col.StrMin, n), truncateMax(col.StrMax, n)} {
+			if err := binary.Write(w, binary.LittleEndian, uint32(len(s))); err != nil {
 				return err
 			}
-			if _, err := w.Write([]byte(col.Max)); err != nil {
+			if _, err := w.Write([]byte(s)); err != nil {
 				return err
 			}
-
-			// Empty count
-			if err := binary.Write(w, binary.LittleEndian, col.EmptyCount); err != nil {
+		}
+	}
+	if idx.Header.Version >= 12 && idx.Header.Columns[j].Sums {
+		sum := []any{col.Sum, boolByte(col.SumInexact), col.SumDecimal.Units, uint8(col.SumDecimal.Scale)}
+		for _, v := range sum {
+			if err := binary.Write(w, binary.LittleEndian, v); err != nil {
 				return err
 			}
 		}
 	}
-
 	return nil
 }
 
+// truncateMin cuts a lower bound to at most n bytes; a prefix never sorts
+// after the string it came from. n of zero keeps it whole.
+func truncateMin(s string, n int) string {
+	if n == 0 || len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// truncateMax cuts an upper bound to at most n bytes and increments the last
+// byte that can be, so the result sorts after every string sharing the cut
+// prefix. A prefix of only 0xff bytes has no such successor and stays whole.
+func truncateMax(s string, n int) string {
+	if n == 0 || len(s) <= n {
+		return s
+	}
+	b := []byte(s[:n])
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return s
+}
+
+func boolByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ErrTruncated is returned for an index file that ends before its last
+// block, such as one an older release was still writing in place
+var ErrTruncated = errors.New("index file is truncated; rebuild it with 'sieswi index'")
+
+// ErrUnsupportedVersion is returned for an index written in a format this
+// build doesn't know, by a newer release
+var ErrUnsupportedVersion = errors.New("unsupported index format")
+
+// ReadIndex reads an index with the stats of every block decoded
 func ReadIndex(r io.Reader) (*Index, error) {
+	return readIndex(r, false)
+}
+
+// ReadIndexLazy reads an index like ReadIndex, but a v14+ index keeps each
+// column's block stats encoded until Stats first asks for them. Opening an
+// index of millions of blocks then costs one read, and a query only decodes
+// the columns it prunes on. Older formats are decoded whole.
+func ReadIndexLazy(r io.Reader) (*Index, error) {
+	return readIndex(r, true)
+}
+
+func readIndex(r io.Reader, lazy bool) (*Index, error) {
+	idx, err := decodeIndex(r, lazy)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ErrTruncated
+	}
+	return idx, err
+}
+
+func decodeIndex(r io.Reader, lazy bool) (*Index, error) {
 	idx := &Index{}
 
 	// Read header
@@ -175,6 +542,10 @@ func ReadIndex(r io.Reader) (*Index, error) {
 	if err := binary.Read(r, binary.LittleEndian, &idx.Header.Version); err != nil {
 		return nil, err
 	}
+	// Fields of a newer format can't be told apart, so it isn't guessed at
+	if idx.Header.Version == 0 || idx.Header.Version > Version {
+		return nil, fmt.Errorf("%w: v%d, this sieswi reads v1 to v%d", ErrUnsupportedVersion, idx.Header.Version, Version)
+	}
 	if err := binary.Read(r, binary.LittleEndian, &idx.Header.BlockSize); err != nil {
 		return nil, err
 	}
@@ -188,6 +559,57 @@ func ReadIndex(r io.Reader) (*Index, error) {
 		return nil, err
 	}
 
+	// Read content checksum (version 6+)
+	if idx.Header.Version >= 6 {
+		if err := binary.Read(r, binary.LittleEndian, &idx.Header.FileChecksum); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read row count (version 7+)
+	if idx.Header.Version >= 7 {
+		if err := binary.Read(r, binary.LittleEndian, &idx.Header.TotalRows); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read build info (version 5+)
+	if idx.Header.Version >= 5 {
+		var versionLen uint32
+		if err := binary.Read(r, binary.LittleEndian, &versionLen); err != nil {
+			return nil, err
+		}
+		versionBuf := make([]byte, versionLen)
+		if _, err := io.ReadFull(r, versionBuf); err != nil {
+			return nil, err
+		}
+		idx.Header.BuildVersion = string(versionBuf)
+
+		var flags uint32
+		if err := binary.Read(r, binary.LittleEndian, &flags); err != nil {
+			return nil, err
+		}
+		idx.Header.BuildFlags = BuildFlags(flags)
+	}
+
+	// Read number format (version 10+)
+	if idx.Header.Version >= 10 {
+		var numbers [2]byte
+		if _, err := io.ReadFull(r, numbers[:]); err != nil {
+			return nil, err
+		}
+		idx.Header.Numbers = datatype.NumberFormat{Thousands: numbers[0], Decimal: numbers[1]}
+	}
+
+	// Read CSV dialect (version 16+)
+	if idx.Header.Version >= 16 {
+		var dialect [2]byte
+		if _, err := io.ReadFull(r, dialect[:]); err != nil {
+			return nil, err
+		}
+		idx.Header.Dialect = csvio.Dialect{Delimiter: dialect[0], Quote: dialect[1]}
+	}
+
 	// Read column dictionary
 	var numColumns uint32
 	if err := binary.Read(r, binary.LittleEndian, &numColumns); err != nil {
@@ -210,61 +632,213 @@ func ReadIndex(r io.Reader) (*Index, error) {
 			return nil, err
 		}
 		idx.Header.Columns[i].Type = ColumnType(colType)
+
+		// Read ordinal (version 4+); older indexes always cover every column in order
+		idx.Header.Columns[i].Ordinal = i
+		if idx.Header.Version >= 4 {
+			if err := binary.Read(r, binary.LittleEndian, &idx.Header.Columns[i].Ordinal); err != nil {
+				return nil, err
+			}
+		}
+
+		// Read distinct estimate (version 7+)
+		if idx.Header.Version >= 7 {
+			if err := binary.Read(r, binary.LittleEndian, &idx.Header.Columns[i].Distinct); err != nil {
+				return nil, err
+			}
+		}
+
+		// Read mixed flag (version 9+)
+		if idx.Header.Version >= 9 {
+			var mixed uint8
+			if err := binary.Read(r, binary.LittleEndian, &mixed); err != nil {
+				return nil, err
+			}
+			idx.Header.Columns[i].Mixed = mixed != 0
+		}
+
+		// Read sums flag (version 12+)
+		if idx.Header.Version >= 12 {
+			var sums uint8
+			if err := binary.Read(r, binary.LittleEndian, &sums); err != nil {
+				return nil, err
+			}
+			idx.Header.Columns[i].Sums = sums != 0
+		}
+
+		// Read sorted flag (version 15+)
+		if idx.Header.Version >= 15 {
+			var sorted uint8
+			if err := binary.Read(r, binary.LittleEndian, &sorted); err != nil {
+				return nil, err
+			}
+			idx.Header.Columns[i].Sorted = sorted != 0
+		}
 	}
 
-	// Read blocks (stats only, no column names)
+	// Read compression and bound length (version 13+)
+	if idx.Header.Version >= 13 {
+		var compressed uint8
+		if err := binary.Read(r, binary.LittleEndian, &compressed); err != nil {
+			return nil, err
+		}
+		idx.Header.Compressed = compressed != 0
+		if err := binary.Read(r, binary.LittleEndian, &idx.Header.BoundLength); err != nil {
+			return nil, err
+		}
+	}
+	if idx.Header.Version >= 14 {
+		if err := readSections(r, idx, lazy); err != nil {
+			return nil, err
+		}
+		return idx, nil
+	}
+	if idx.Header.Compressed {
+		fr := flate.NewReader(r)
+		defer fr.Close()
+		r = fr
+	}
+
+	if err := readBlocks(r, idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// readBlocks reads the stats of every block (no column names, order matches
+// the dictionary)
+func readBlocks(r io.Reader, idx *Index) error {
+	numColumns := uint32(len(idx.Header.Columns))
 	idx.Blocks = make([]BlockMeta, idx.Header.NumBlocks)
 	for i := uint32(0); i < idx.Header.NumBlocks; i++ {
 		block := &idx.Blocks[i]
 
 		if err := binary.Read(r, binary.LittleEndian, &block.StartRow); err != nil {
-			return nil, err
+			return err
 		}
 		if err := binary.Read(r, binary.LittleEndian, &block.EndRow); err != nil {
-			return nil, err
+			return err
 		}
 		if err := binary.Read(r, binary.LittleEndian, &block.StartOffset); err != nil {
-			return nil, err
+			return err
 		}
 		if err := binary.Read(r, binary.LittleEndian, &block.EndOffset); err != nil {
-			return nil, err
+			return err
 		}
 
 		// Read stats for each column (order matches dictionary)
 		block.Columns = make([]ColumnStats, numColumns)
-		for j := uint32(0); j < numColumns; j++ {
-			col := &block.Columns[j]
-
-			// Read min value
-			var minLen uint32
-			if err := binary.Read(r, binary.LittleEndian, &minLen); err != nil {
-				return nil, err
-			}
-			minBuf := make([]byte, minLen)
-			if _, err := io.ReadFull(r, minBuf); err != nil {
-				return nil, err
+		for j := range block.Columns {
+			if err := readColumnStats(r, idx, j, &block.Columns[j]); err != nil {
+				return err
 			}
-			col.Min = string(minBuf)
+		}
+	}
 
-			// Read max value
-			var maxLen uint32
-			if err := binary.Read(r, binary.LittleEndian, &maxLen); err != nil {
-				return nil, err
+	return nil
+}
+
+// readColumnStats reads the stats of dictionary column j in one block
+func readColumnStats(r io.Reader, idx *Index, j int, col *ColumnStats) error {
+	// Read min value
+	var minLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &minLen); err != nil {
+		return err
+	}
+	minBuf := make([]byte, minLen)
+	if _, err := io.ReadFull(r, minBuf); err != nil {
+		return err
+	}
+	col.Min = string(minBuf)
+
+	// Read max value
+	var maxLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &maxLen); err != nil {
+		return err
+	}
+	maxBuf := make([]byte, maxLen)
+	if _, err := io.ReadFull(r, maxBuf); err != nil {
+		return err
+	}
+	col.Max = string(maxBuf)
+
+	// Read empty count (version 3+)
+	if idx.Header.Version >= 3 {
+		if err := binary.Read(r, binary.LittleEndian, &col.EmptyCount); err != nil {
+			return err
+		}
+	}
+
+	// Read typed bounds (version 8+)
+	if idx.Header.Version >= 8 {
+		if err := readTypedStats(r, col, idx.Header.Columns[j].Type); err != nil {
+			return err
+		}
+	}
+
+	// Read string bounds of mixed columns (version 9+)
+	if idx.Header.Version >= 9 && idx.Header.Columns[j].Mixed {
+		for _, s := range []*string{&col.StrMin, &col.StrMax} {
+			var n uint32
+			if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+				return err
 			}
-			maxBuf := make([]byte, maxLen)
-			if _, err := io.ReadFull(r, maxBuf); err != nil {
-				return nil, err
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return err
 			}
-			col.Max = string(maxBuf)
+			*s = string(buf)
+		}
+	}
 
-			// Read empty count (version 3+)
-			if idx.Header.Version >= 3 {
-				if err := binary.Read(r, binary.LittleEndian, &col.EmptyCount); err != nil {
-					return nil, err
-				}
+	// Read sums (version 12+)
+	if idx.Header.Version >= 12 && idx.Header.Columns[j].Sums {
+		var inexact, scale uint8
+		for _, v := range []any{&col.Sum, &inexact, &col.SumDecimal.Units, &scale} {
+			if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+				return err
 			}
 		}
+		col.SumInexact = inexact != 0
+		col.SumDecimal.Scale = int(scale)
 	}
+	return nil
+}
 
-	return idx, nil
+// writeTypedStats writes the binary bounds and invalid count of numeric,
+// timestamp, and boolean columns; string columns have none.
+func writeTypedStats(w io.Writer, col *ColumnStats, t ColumnType) error {
+	var bounds []any
+	switch t {
+	case ColumnTypeNumeric, ColumnTypeBoolean:
+		bounds = []any{col.MinNum, col.MaxNum}
+	case ColumnTypeTimestamp:
+		bounds = []any{col.MinTime, col.MaxTime}
+	default:
+		return nil
+	}
+	for _, v := range append(bounds, col.InvalidCount) {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readTypedStats(r io.Reader, col *ColumnStats, t ColumnType) error {
+	var fields []any
+	switch t {
+	case ColumnTypeNumeric, ColumnTypeBoolean:
+		fields = []any{&col.MinNum, &col.MaxNum}
+	case ColumnTypeTimestamp:
+		fields = []any{&col.MinTime, &col.MaxTime}
+	default:
+		return nil
+	}
+	for _, v := range append(fields, &col.InvalidCount) {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
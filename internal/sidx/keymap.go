@@ -0,0 +1,639 @@
+package sidx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+// Key maps are secondary indexes kept in a .skey sidecar next to the .sidx.
+// For each chosen column the sidecar lists the column's distinct values in
+// sorted order, each with the blocks holding it, like the leaves of a coarse
+// B-tree. Block bounds only skip blocks whose range misses a predicate,
+// which on an unsorted column is rare; a key map names the blocks holding
+// the matching values exactly. A key map may also keep, for each key and
+// block, the bounds of paired columns over the rows holding that key (see
+// pairs.go).
+//
+// File format:
+//   - Magic: "SKEY" (4 bytes)
+//   - Version: uint32 (4 bytes)
+//   - FileSize: int64, FileChecksum: uint64, TotalRows: uint64 - of the CSV, as in the .sidx
+//   - BlockSize: uint32, NumBlocks: uint32 - of the .sidx the block numbers refer to
+//   - BuildFlags: uint32, Thousands, Decimal: uint8 - of the .sidx
+//   - NumMaps: uint32 (4 bytes)
+//   - For each map:
+//     - NameLen: uint32, Name - the column
+//     - Type: uint8 - column type, which orders the keys
+//     - NumPaired: uvarint, then each paired column's name (uvarint length
+//       and bytes) and type (uint8) (version 2+)
+//     - NumKeys: uvarint
+//     - Keys, ascending: the value (numeric: float64 bits; timestamp: int64
+//       Unix nanos; string: uvarint length and bytes), then NumBlocks uvarint
+//       and the block numbers as uvarint deltas from the previous one, then
+//       for each of those blocks and each paired column a uint8 that is 1
+//       when the rows had values, followed by their min and max encoded like
+//       keys of the paired column's type (version 2+)
+//
+// A key map is only used with the .sidx it was built from; the copied
+// header fields identify it.
+
+const (
+	KeyMapMagic   = "SKEY"
+	KeyMapVersion = 2
+)
+
+// KeyMap is the secondary index of one column
+type KeyMap struct {
+	Column string
+	Type   ColumnType
+	Keys   []Key // Ascending in the column type's order
+	Paired []PairedColumn
+
+	// Candidate blocks of recent predicates, which every block of a scan asks about
+	mu      sync.Mutex
+	matches map[string][]bool
+}
+
+// Key is a distinct value of a key map's column and the blocks holding it
+type Key struct {
+	Text   string  // String columns
+	Num    float64 // Numeric columns
+	Time   int64   // Timestamp columns, Unix nanos
+	Blocks []uint32
+
+	// Bounds of the paired columns over the key's rows in each of its
+	// blocks: those of Blocks[i] start at Bounds[i*len(Paired)]
+	Bounds []PairBound
+}
+
+// maxKeyMatches bounds the predicates a key map remembers candidates for
+const maxKeyMatches = 64
+
+// keyFileHeader ties a .skey file to the .sidx it was built from
+type keyFileHeader struct {
+	FileSize     int64
+	FileChecksum uint64
+	TotalRows    uint64
+	BlockSize    uint32
+	NumBlocks    uint32
+	BuildFlags   BuildFlags
+	Thousands    uint8
+	Decimal      uint8
+}
+
+func newKeyFileHeader(index *Index) keyFileHeader {
+	h := &index.Header
+	return keyFileHeader{
+		FileSize:     h.FileSize,
+		FileChecksum: h.FileChecksum,
+		TotalRows:    h.TotalRows,
+		BlockSize:    h.BlockSize,
+		NumBlocks:    h.NumBlocks,
+		// Parallel and sequential builds cut the same blocks
+		BuildFlags: h.BuildFlags &^ BuildFlagParallel,
+		Thousands:  h.Numbers.Thousands,
+		Decimal:    h.Numbers.Decimal,
+	}
+}
+
+// KeyMap returns the key map of the named column, or nil if it has none
+func (idx *Index) KeyMap(colName string) *KeyMap {
+	for _, m := range idx.Keys {
+		if strings.EqualFold(m.Column, strings.TrimSpace(colName)) {
+			return m
+		}
+	}
+	return nil
+}
+
+// BuildKeyMaps reads the values of the named columns from csvPath block by
+// block and maps each to the blocks of index that hold it. The lead column of
+// each pair gets a key map too, which also keeps the bounds of the pair's
+// other column per key and block. The columns must be in index with a
+// string, numeric, or timestamp type; encoding is the one index was built
+// with.
+func BuildKeyMaps(csvPath string, index *Index, columns []string, pairs []ColumnPair, encoding string) ([]*KeyMap, error) {
+	if err := checkEncoding(encoding); err != nil {
+		return nil, err
+	}
+	// keyValue is a Key's value, comparable for use as a map key
+	type keyValue struct {
+		text string
+		num  float64
+		time int64
+	}
+	type entry struct {
+		blocks []uint32
+		bounds []PairBound
+	}
+	type build struct {
+		ordinal int
+		paired  []int // Ordinals of the paired columns
+		entries map[keyValue]*entry
+	}
+	var maps []*KeyMap
+	var builds []build
+	mapOf := func(name string) (int, error) {
+		col, colType, ok := findColumn(index, name)
+		if !ok {
+			return 0, fmt.Errorf("can't map keys of %q: column isn't indexed", name)
+		}
+		if colType == ColumnTypeBoolean {
+			return 0, fmt.Errorf("can't map keys of %q: boolean columns are pruned by their bounds", name)
+		}
+		info := &index.Header.Columns[col]
+		for i, m := range maps {
+			if m.Column == info.Name {
+				return i, nil
+			}
+		}
+		maps = append(maps, &KeyMap{Column: info.Name, Type: colType})
+		builds = append(builds, build{ordinal: int(info.Ordinal), entries: make(map[keyValue]*entry)})
+		return len(maps) - 1, nil
+	}
+	for _, name := range columns {
+		if _, err := mapOf(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, pair := range pairs {
+		i, err := mapOf(pair.Lead)
+		if err != nil {
+			return nil, err
+		}
+		col, colType, ok := findColumn(index, pair.Column)
+		if !ok || colType == ColumnTypeBoolean {
+			return nil, fmt.Errorf("can't pair %q with %q: %q isn't an indexed string, numeric, or timestamp column", pair.Lead, pair.Column, pair.Column)
+		}
+		info := &index.Header.Columns[col]
+		if maps[i].pairedColumn(info.Name) >= 0 || strings.EqualFold(info.Name, maps[i].Column) {
+			continue
+		}
+		maps[i].Paired = append(maps[i].Paired, PairedColumn{Name: info.Name, Type: colType})
+		builds[i].paired = append(builds[i].paired, int(info.Ordinal))
+	}
+
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := newRecordDecoder(encoding, index.Header.Dialect)
+	numbers := index.Header.Numbers
+	for n := range index.Blocks {
+		block := &index.Blocks[n]
+		start, end := int64(block.StartOffset), int64(block.EndOffset)
+		reader := recordReader(csvio.NewRetryReader(io.NewSectionReader(f, start, end-start), csvPath, start), 0, index.Header.Dialect)
+		offset := uint64(start)
+		for {
+			rawLine, err := reader.Next()
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("map keys: %w", err)
+			}
+			rowStart := offset
+			offset += uint64(len(rawLine))
+			if trimmed := csvio.TrimLineEnd(rawLine); len(trimmed) > 0 {
+				record, perr := decoder.parse(trimmed, rowStart)
+				if perr != nil {
+					return nil, fmt.Errorf("map keys: parse row at offset %d: %w", rowStart, perr)
+				}
+				// Short rows hold an empty value, as in the block stats
+				field := func(ordinal int) string {
+					if ordinal < len(record) {
+						return record[ordinal]
+					}
+					return ""
+				}
+				for i, m := range maps {
+					b := &builds[i]
+					// Values that don't parse as the column's type are left
+					// to the block's InvalidCount
+					var kv keyValue
+					var ok bool
+					kv.text, kv.num, kv.time, ok = parseKey(field(b.ordinal), m.Type, numbers)
+					if !ok {
+						continue
+					}
+					e := b.entries[kv]
+					if e == nil {
+						e = &entry{}
+						b.entries[kv] = e
+					}
+					// Each value is added once per block; appending block
+					// numbers in order keeps every list sorted
+					if last := len(e.blocks) - 1; last < 0 || e.blocks[last] != uint32(n) {
+						e.blocks = append(e.blocks, uint32(n))
+						e.bounds = append(e.bounds, make([]PairBound, len(m.Paired))...)
+					}
+					bounds := e.bounds[len(e.bounds)-len(m.Paired):]
+					for p, paired := range m.Paired {
+						bounds[p].add(field(b.paired[p]), paired.Type, numbers)
+					}
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+	}
+
+	for i, m := range maps {
+		for kv, e := range builds[i].entries {
+			m.Keys = append(m.Keys, Key{Text: kv.text, Num: kv.num, Time: kv.time, Blocks: e.blocks, Bounds: e.bounds})
+		}
+		sort.Slice(m.Keys, func(a, c int) bool { return m.compare(&m.Keys[a], &m.Keys[c]) < 0 })
+	}
+	return maps, nil
+}
+
+// parseKey reads value as a key of a map of type t. Numbers and timestamps
+// that don't parse aren't keys.
+func parseKey(value string, t ColumnType, numbers datatype.NumberFormat) (text string, num float64, ts int64, ok bool) {
+	switch t {
+	case ColumnTypeNumeric:
+		num, ok = numbers.ParseFloat(value)
+		return "", num, 0, ok && !math.IsNaN(num)
+	case ColumnTypeTimestamp:
+		ts, ok = datatype.ParseTimestamp(value)
+		return "", 0, ts, ok
+	default:
+		return value, 0, 0, true
+	}
+}
+
+// compare orders two keys of the map
+func (m *KeyMap) compare(a, b *Key) int {
+	switch m.Type {
+	case ColumnTypeNumeric:
+		return cmpOrdered(a.Num, b.Num)
+	case ColumnTypeTimestamp:
+		return cmpOrdered(a.Time, b.Time)
+	default:
+		return strings.Compare(a.Text, b.Text)
+	}
+}
+
+// canPruneKeys reports whether the key map of a column shows that no row of
+// block n can satisfy "x op value". It only answers (ok) when the column has
+// a key map and the predicate compares values the way its keys are ordered.
+func canPruneKeys(index *Index, n int, colName, operator, value string) (prune, ok bool) {
+	m := index.KeyMap(colName)
+	col, _, found := findColumn(index, colName)
+	if m == nil || !found || n < 0 || n >= len(index.Blocks) {
+		return false, false
+	}
+	kind, num, ts := predicateKind(value)
+	if kind != m.Type || math.IsNaN(num) {
+		return false, false
+	}
+	// Values that don't parse aren't keyed; they never match, except that
+	// NaN matches !=
+	if stats := index.Stats(&index.Blocks[n], col); stats == nil || operator == "!=" && stats.InvalidCount > 0 {
+		return false, false
+	}
+	probe := Key{Text: value, Num: num, Time: ts}
+	candidates := m.candidates(operator, &probe, len(index.Blocks))
+	if candidates == nil {
+		return false, false
+	}
+	return !candidates[n], true
+}
+
+// candidates returns which blocks hold a key satisfying "key op probe", or
+// nil for an operator the map can't answer
+func (m *KeyMap) candidates(operator string, probe *Key, numBlocks int) []bool {
+	memo := operator + "\x00" + probe.Text + "\x00" + strconv.FormatFloat(probe.Num, 'g', -1, 64) + "\x00" + strconv.FormatInt(probe.Time, 10)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.matches[memo]; ok {
+		return c
+	}
+
+	// Keys before lo are less than probe, keys from hi on greater
+	lo := sort.Search(len(m.Keys), func(i int) bool { return m.compare(&m.Keys[i], probe) >= 0 })
+	hi := lo
+	for hi < len(m.Keys) && m.compare(&m.Keys[hi], probe) == 0 {
+		hi++
+	}
+	var ranges [][2]int
+	switch operator {
+	case "=":
+		ranges = [][2]int{{lo, hi}}
+	case "!=":
+		ranges = [][2]int{{0, lo}, {hi, len(m.Keys)}}
+	case "<":
+		ranges = [][2]int{{0, lo}}
+	case "<=":
+		ranges = [][2]int{{0, hi}}
+	case ">":
+		ranges = [][2]int{{hi, len(m.Keys)}}
+	case ">=":
+		ranges = [][2]int{{lo, len(m.Keys)}}
+	default:
+		return nil
+	}
+
+	c := make([]bool, numBlocks)
+	for _, r := range ranges {
+		for _, key := range m.Keys[r[0]:r[1]] {
+			for _, b := range key.Blocks {
+				c[b] = true
+			}
+		}
+	}
+	if m.matches == nil || len(m.matches) >= maxKeyMatches {
+		m.matches = make(map[string][]bool)
+	}
+	m.matches[memo] = c
+	return c
+}
+
+// blockNumber returns the position of block in index.Blocks. Both builders
+// cut blocks at multiples of the block size, so it normally follows from
+// StartRow; otherwise the blocks are searched by StartRow.
+func blockNumber(index *Index, block *BlockMeta) int {
+	if size := uint64(index.Header.BlockSize); size > 0 {
+		n := block.StartRow / size
+		if n < uint64(len(index.Blocks)) && index.Blocks[n].StartRow == block.StartRow {
+			return int(n)
+		}
+	}
+	n := sort.Search(len(index.Blocks), func(i int) bool { return index.Blocks[i].StartRow >= block.StartRow })
+	if n == len(index.Blocks) || index.Blocks[n].StartRow != block.StartRow {
+		return -1
+	}
+	return n
+}
+
+// WriteKeyMaps writes the key maps of index to w
+func WriteKeyMaps(w io.Writer, index *Index, maps []*KeyMap) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(KeyMapMagic); err != nil {
+		return err
+	}
+	header := newKeyFileHeader(index)
+	for _, v := range []any{uint32(KeyMapVersion), header, uint32(len(maps))} {
+		if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	var buf []byte
+	for _, m := range maps {
+		buf = binary.LittleEndian.AppendUint32(buf[:0], uint32(len(m.Column)))
+		buf = append(buf, m.Column...)
+		buf = append(buf, byte(m.Type))
+		buf = binary.AppendUvarint(buf, uint64(len(m.Paired)))
+		for _, paired := range m.Paired {
+			buf = binary.AppendUvarint(buf, uint64(len(paired.Name)))
+			buf = append(buf, paired.Name...)
+			buf = append(buf, byte(paired.Type))
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(m.Keys)))
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		for i := range m.Keys {
+			key := &m.Keys[i]
+			buf = appendKeyValue(buf[:0], m.Type, key.Text, key.Num, key.Time)
+			buf = binary.AppendUvarint(buf, uint64(len(key.Blocks)))
+			prev := uint32(0)
+			for _, b := range key.Blocks {
+				buf = binary.AppendUvarint(buf, uint64(b-prev))
+				prev = b
+			}
+			for j := range key.Bounds {
+				bound := &key.Bounds[j]
+				t := m.Paired[j%len(m.Paired)].Type
+				buf = append(buf, boolByte(bound.Valid))
+				if bound.Valid {
+					buf = appendKeyValue(buf, t, bound.Min, bound.MinNum, bound.MinTime)
+					buf = appendKeyValue(buf, t, bound.Max, bound.MaxNum, bound.MaxTime)
+				}
+			}
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// appendKeyValue encodes a value of type t the way keys of that type are
+func appendKeyValue(buf []byte, t ColumnType, text string, num float64, ts int64) []byte {
+	switch t {
+	case ColumnTypeNumeric:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(num))
+	case ColumnTypeTimestamp:
+		return binary.LittleEndian.AppendUint64(buf, uint64(ts))
+	default:
+		buf = binary.AppendUvarint(buf, uint64(len(text)))
+		return append(buf, text...)
+	}
+}
+
+// readKeyValue decodes a value appendKeyValue encoded
+func readKeyValue(br *bufio.Reader, t ColumnType) (text string, num float64, ts int64, err error) {
+	switch t {
+	case ColumnTypeNumeric, ColumnTypeTimestamp:
+		var bits uint64
+		if err := binary.Read(br, binary.LittleEndian, &bits); err != nil {
+			return "", 0, 0, err
+		}
+		if t == ColumnTypeNumeric {
+			return "", math.Float64frombits(bits), 0, nil
+		}
+		return "", 0, int64(bits), nil
+	default:
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", 0, 0, err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return "", 0, 0, err
+		}
+		return string(b), 0, 0, nil
+	}
+}
+
+// errKeyMapMismatch reports a .skey built from another .sidx
+var errKeyMapMismatch = errors.New("key maps were built for a different index")
+
+// ReadKeyMaps reads the key maps written for index from r. A nil index
+// reads them whatever index they were built for.
+func ReadKeyMaps(r io.Reader, index *Index) ([]*KeyMap, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != KeyMapMagic {
+		return nil, fmt.Errorf("invalid magic: %s", magic)
+	}
+	var version uint32
+	if err := binary.Read(br, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version == 0 || version > KeyMapVersion {
+		return nil, fmt.Errorf("unsupported key map version %d", version)
+	}
+	var header keyFileHeader
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if index != nil && header != newKeyFileHeader(index) {
+		return nil, errKeyMapMismatch
+	}
+	var numMaps uint32
+	if err := binary.Read(br, binary.LittleEndian, &numMaps); err != nil {
+		return nil, err
+	}
+
+	readUvarint := func() (uint64, error) {
+		v, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return v, err
+	}
+	maps := make([]*KeyMap, 0, numMaps)
+	for range numMaps {
+		var nameLen uint32
+		if err := binary.Read(br, binary.LittleEndian, &nameLen); err != nil {
+			return nil, err
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, err
+		}
+		colType, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		m := &KeyMap{Column: string(name), Type: ColumnType(colType)}
+		if version >= 2 {
+			numPaired, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			for range numPaired {
+				name, _, _, err := readKeyValue(br, ColumnTypeString)
+				if err != nil {
+					return nil, err
+				}
+				t, err := br.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				m.Paired = append(m.Paired, PairedColumn{Name: name, Type: ColumnType(t)})
+			}
+		}
+		numKeys, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		m.Keys = make([]Key, 0, min(numKeys, 1<<20))
+		for range numKeys {
+			var key Key
+			if key.Text, key.Num, key.Time, err = readKeyValue(br, m.Type); err != nil {
+				return nil, err
+			}
+			numBlocks, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			key.Blocks = make([]uint32, 0, min(numBlocks, uint64(header.NumBlocks)))
+			prev := uint64(0)
+			for range numBlocks {
+				delta, err := readUvarint()
+				if err != nil {
+					return nil, err
+				}
+				prev += delta
+				if prev >= uint64(header.NumBlocks) {
+					return nil, fmt.Errorf("key map %q: block %d out of range", m.Column, prev)
+				}
+				key.Blocks = append(key.Blocks, uint32(prev))
+			}
+			if len(m.Paired) > 0 {
+				key.Bounds = make([]PairBound, len(key.Blocks)*len(m.Paired))
+			}
+			for j := range key.Bounds {
+				bound := &key.Bounds[j]
+				t := m.Paired[j%len(m.Paired)].Type
+				valid, err := br.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				if bound.Valid = valid != 0; bound.Valid {
+					if bound.Min, bound.MinNum, bound.MinTime, err = readKeyValue(br, t); err != nil {
+						return nil, err
+					}
+					if bound.Max, bound.MaxNum, bound.MaxTime, err = readKeyValue(br, t); err != nil {
+						return nil, err
+					}
+				}
+			}
+			m.Keys = append(m.Keys, key)
+		}
+		if !slices.IsSortedFunc(m.Keys, func(a, b Key) int { return m.compare(&a, &b) }) {
+			return nil, fmt.Errorf("key map %q: keys out of order", m.Column)
+		}
+		maps = append(maps, m)
+	}
+	return maps, nil
+}
+
+// KeyMapColumns returns the columns csvPath's .skey sidecar maps and the
+// pairs it keeps bounds for, whatever index it was built for, so a rebuild
+// can map the same ones
+func KeyMapColumns(csvPath string) (columns []string, pairs []ColumnPair, err error) {
+	f, err := os.Open(csvPath + ".skey")
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	maps, err := ReadKeyMaps(f, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	index := &Index{Keys: maps}
+	for _, m := range maps {
+		columns = append(columns, m.Column)
+	}
+	return columns, index.Pairs(), nil
+}
+
+// LoadKeyMaps reads csvPath's .skey sidecar, if it has one built for index
+func LoadKeyMaps(csvPath string, index *Index) ([]*KeyMap, error) {
+	f, err := os.Open(csvPath + ".skey")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadKeyMaps(f, index)
+}
@@ -0,0 +1,273 @@
+package sidx
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestKeyMapsPruneUnsortedColumns(t *testing.T) {
+	// Every block spans the whole range of both columns, so bounds prune
+	// nothing; only the key maps know where each value is
+	skus := []string{"apple", "kiwi", "mango", "zebra"}
+	var sb strings.Builder
+	sb.WriteString("id,sku,qty,when\n")
+	type row struct {
+		sku string
+		qty float64
+	}
+	var rows []row
+	for i := 0; i < 400; i++ {
+		sku := skus[i%len(skus)]
+		qty := float64(i % 10 * 10)
+		if i == 150 || i == 151 {
+			sku, qty = "melon", 55
+		}
+		fmt.Fprintf(&sb, "%d,%s,%g,2024-01-%02d\n", i, sku, qty, i%28+1)
+		rows = append(rows, row{sku, qty})
+	}
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	index, err := NewBuilder(50).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+
+	maps, err := BuildKeyMaps(csvPath, index, []string{"SKU", "qty", "when"}, nil, "")
+	if err != nil {
+		t.Fatalf("build key maps: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteKeyMaps(&buf, index, maps); err != nil {
+		t.Fatalf("write key maps: %v", err)
+	}
+	if index.Keys, err = ReadKeyMaps(bytes.NewReader(buf.Bytes()), index); err != nil {
+		t.Fatalf("read key maps: %v", err)
+	}
+	if m := index.KeyMap("sku"); m == nil || len(m.Keys) != 5 || m.Keys[3].Text != "melon" || len(m.Keys[3].Blocks) != 1 {
+		t.Fatalf("sku key map = %+v", m)
+	}
+
+	preds := []struct {
+		col, op, value string
+	}{
+		{"sku", "=", "melon"},
+		{"sku", "=", "pear"},
+		{"sku", "!=", "apple"},
+		{"sku", ">", "mango"},
+		{"sku", "<=", "kiwi"},
+		{"qty", "=", "55"},
+		{"qty", ">=", "90"},
+		{"qty", "<", "0"},
+	}
+	for _, p := range preds {
+		pruned := 0
+		for n := range index.Blocks {
+			block := &index.Blocks[n]
+			matches := false
+			for r := block.StartRow; r < block.EndRow; r++ {
+				var cmp int
+				if p.col == "sku" {
+					cmp = strings.Compare(rows[r].sku, p.value)
+				} else {
+					v, _ := strconv.ParseFloat(p.value, 64)
+					cmp = cmpOrdered(rows[r].qty, v)
+				}
+				matches = matches || map[string]bool{
+					"=": cmp == 0, "!=": cmp != 0, "<": cmp < 0, "<=": cmp <= 0, ">": cmp > 0, ">=": cmp >= 0,
+				}[p.op]
+			}
+			// Key maps are exact: a block is pruned exactly when no row matches
+			if prune := CanPruneBlock(index, block, p.col, p.op, p.value); prune == matches {
+				t.Errorf("%s %s %s: block %d pruned=%v, has matches=%v", p.col, p.op, p.value, n, prune, matches)
+			} else if prune {
+				pruned++
+			}
+		}
+		if p.op == "=" && p.value == "melon" && pruned != len(index.Blocks)-1 {
+			t.Errorf("sku = 'melon' pruned %d of %d blocks", pruned, len(index.Blocks))
+		}
+	}
+
+	// String predicates on a numeric key map are left to the bounds
+	if _, ok := canPruneKeys(index, 0, "qty", "=", "abc"); ok {
+		t.Error("string predicate answered from a numeric key map")
+	}
+
+	// A key map from another build of the file is ignored
+	other, err := NewBuilder(64).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+	if _, err := ReadKeyMaps(bytes.NewReader(buf.Bytes()), other); err != errKeyMapMismatch {
+		t.Errorf("key maps for another index: err = %v", err)
+	}
+
+	if _, err := BuildKeyMaps(csvPath, index, []string{"missing"}, nil, ""); err == nil {
+		t.Error("expected an error for an unindexed column")
+	}
+}
+
+func TestCacheLoadsKeyMaps(t *testing.T) {
+	cache := NewCache(4)
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	writeIndexedCSV(t, csvPath, "id,name\n1,a\n2,b\n3,c\n")
+
+	index, release, err := cache.Acquire(csvPath)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	release()
+	if index.Keys != nil {
+		t.Fatal("index without a .skey has key maps")
+	}
+
+	maps, err := BuildKeyMaps(csvPath, index, []string{"name"}, nil, "")
+	if err != nil {
+		t.Fatalf("build key maps: %v", err)
+	}
+	f, err := os.Create(csvPath + ".skey")
+	if err != nil {
+		t.Fatalf("create key file: %v", err)
+	}
+	if err := WriteKeyMaps(f, index, maps); err != nil {
+		t.Fatalf("write key maps: %v", err)
+	}
+	f.Close()
+
+	if columns, pairs, err := KeyMapColumns(csvPath); err != nil || len(columns) != 1 || columns[0] != "name" || pairs != nil {
+		t.Errorf("KeyMapColumns = %v, %v, %v; want [name] and no pairs", columns, pairs, err)
+	}
+
+	// A new .skey is picked up without touching the .sidx
+	index, release, err = cache.Acquire(csvPath)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+	if index.KeyMap("name") == nil {
+		t.Error("key map not loaded with the index")
+	}
+}
+
+func TestPairBoundsPruneCorrelatedColumns(t *testing.T) {
+	// Every block holds every country and spans the year, but US rows are
+	// from January, DE rows from June, and FR rows move through the year;
+	// US amounts stay below 20
+	countries := []string{"US", "DE", "FR"}
+	var sb strings.Builder
+	sb.WriteString("id,country,created_at,amount\n")
+	type row struct {
+		country string
+		month   int
+		amount  float64
+	}
+	var rows []row
+	for i := 0; i < 600; i++ {
+		r := row{country: countries[i%3], amount: float64(i % 50)}
+		switch r.country {
+		case "US":
+			r.month, r.amount = 1, float64(i%20)
+		case "DE":
+			r.month = 6
+		default:
+			r.month = 1 + i/50
+		}
+		fmt.Fprintf(&sb, "%d,%s,2024-%02d-%02d,%g\n", i, r.country, r.month, i%28+1, r.amount)
+		rows = append(rows, r)
+	}
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	index, err := NewBuilder(50).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+
+	pairs := []ColumnPair{{"country", "created_at"}, {"Country", "amount"}}
+	maps, err := BuildKeyMaps(csvPath, index, nil, pairs, "")
+	if err != nil {
+		t.Fatalf("build key maps: %v", err)
+	}
+	f, err := os.Create(csvPath + ".skey")
+	if err != nil {
+		t.Fatalf("create key file: %v", err)
+	}
+	if err := WriteKeyMaps(f, index, maps); err != nil {
+		t.Fatalf("write key maps: %v", err)
+	}
+	f.Close()
+	if index.Keys, err = LoadKeyMaps(csvPath, index); err != nil {
+		t.Fatalf("load key maps: %v", err)
+	}
+	if m := index.KeyMap("country"); m == nil || len(m.Paired) != 2 || len(m.Keys) != 3 {
+		t.Fatalf("country key map = %+v", m)
+	}
+	if columns, got, err := KeyMapColumns(csvPath); err != nil || len(columns) != 1 || !reflect.DeepEqual(got, []ColumnPair{{"country", "created_at"}, {"country", "amount"}}) {
+		t.Errorf("KeyMapColumns = %v, %v, %v", columns, got, err)
+	}
+
+	preds := []struct {
+		country, col, op, value string
+	}{
+		{"US", "created_at", ">=", "2024-06-01"},
+		{"DE", "created_at", "<", "2024-06-01"},
+		{"FR", "created_at", ">", "2024-10-15"},
+		{"FR", "created_at", "=", "2024-03-05"},
+		{"US", "amount", ">", "20"},
+		{"UK", "amount", ">", "0"},
+	}
+	for _, p := range preds {
+		pruned := 0
+		for n := range index.Blocks {
+			block := &index.Blocks[n]
+			matches := false
+			for r := block.StartRow; r < block.EndRow; r++ {
+				row := rows[r]
+				if row.country != p.country {
+					continue
+				}
+				var cmp int
+				if p.col == "amount" {
+					v, _ := strconv.ParseFloat(p.value, 64)
+					cmp = cmpOrdered(row.amount, v)
+				} else {
+					// Rows fall on the id's day of the month
+					day := fmt.Sprintf("2024-%02d-%02d", row.month, r%28+1)
+					cmp = strings.Compare(day, p.value)
+				}
+				matches = matches || map[string]bool{"=": cmp == 0, "<": cmp < 0, ">": cmp > 0, ">=": cmp >= 0}[p.op]
+			}
+			prune := CanPruneBlockPair(index, block, "country", p.country, p.col, p.op, p.value)
+			if prune && matches {
+				t.Errorf("country = %s AND %s %s %s: block %d pruned with matches", p.country, p.col, p.op, p.value, n)
+			}
+			if prune {
+				pruned++
+			}
+		}
+		if p.country != "FR" && pruned != len(index.Blocks) {
+			t.Errorf("country = %s AND %s %s %s: pruned %d of %d blocks", p.country, p.col, p.op, p.value, pruned, len(index.Blocks))
+		}
+	}
+
+	// Neither column's own bounds rule a block out
+	block := &index.Blocks[3]
+	if CanPruneBlock(index, block, "country", "=", "US") || CanPruneBlock(index, block, "created_at", ">=", "2024-06-01") {
+		t.Error("per-column bounds pruned a block holding both values")
+	}
+	// Columns that aren't paired, and literals of another type, get no answer
+	if CanPruneBlockPair(index, block, "country", "US", "id", ">", "1000") ||
+		CanPruneBlockPair(index, block, "country", "US", "amount", ">", "abc") ||
+		CanPruneBlockPair(index, block, "country", "US", "amount", "!=", "3") {
+		t.Error("pair bounds answered a predicate they don't cover")
+	}
+}
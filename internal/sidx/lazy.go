@@ -0,0 +1,205 @@
+package sidx
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// lazyStats holds the column sections of a v14+ index as read from disk,
+// each decoded into per-block stats the first time it is needed
+type lazyStats struct {
+	compressed bool
+	once       []sync.Once
+	raw        [][]byte
+	stats      [][]ColumnStats
+}
+
+// Stats returns the stats of dictionary column col in block, which must be
+// one of idx.Blocks. An index read by ReadIndexLazy decodes the column on
+// first use; Stats is nil if its section can't be decoded, and callers then
+// treat the block as if the column weren't indexed.
+func (idx *Index) Stats(block *BlockMeta, col int) *ColumnStats {
+	if idx.lazy == nil || block.Columns != nil {
+		if col >= len(block.Columns) {
+			return nil
+		}
+		return &block.Columns[col]
+	}
+	stats := idx.lazy.column(idx, col)
+	n := blockNumber(idx, block)
+	if stats == nil || n < 0 {
+		return nil
+	}
+	return &stats[n]
+}
+
+// column returns the decoded stats of dictionary column col, one per block
+func (l *lazyStats) column(idx *Index, col int) []ColumnStats {
+	l.once[col].Do(func() {
+		stats := make([]ColumnStats, len(idx.Blocks))
+		r, err := l.open(l.raw[col])
+		for b := range stats {
+			if err != nil {
+				break
+			}
+			err = readColumnStats(r, idx, col, &stats[b])
+		}
+		if err != nil {
+			if os.Getenv("SIDX_DEBUG") == "1" {
+				fmt.Fprintf(os.Stderr, "[sidx] Can't decode stats of column %q: %v\n", idx.Header.Columns[col].Name, err)
+			}
+			stats = nil
+		}
+		l.stats[col], l.raw[col] = stats, nil
+	})
+	return l.stats[col]
+}
+
+// open returns a reader of the content of a section
+func (l *lazyStats) open(section []byte) (io.Reader, error) {
+	if !l.compressed {
+		return bytes.NewReader(section), nil
+	}
+	// Inflating the whole section up front keeps the many small reads of
+	// the decoder off the flate reader
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(section)))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// writeSections writes the block ranges and then the stats of each column,
+// every one as a length-prefixed section (v14+)
+func writeSections(w io.Writer, idx *Index) error {
+	var buf bytes.Buffer
+	section := func(write func(w io.Writer) error) error {
+		buf.Reset()
+		var fw *flate.Writer
+		var sw io.Writer = &buf
+		if idx.Header.Compressed {
+			var err error
+			if fw, err = flate.NewWriter(&buf, flate.BestCompression); err != nil {
+				return err
+			}
+			sw = fw
+		}
+		if err := write(sw); err != nil {
+			return err
+		}
+		if fw != nil {
+			if err := fw.Close(); err != nil {
+				return err
+			}
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint64(buf.Len())); err != nil {
+			return err
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	err := section(func(w io.Writer) error {
+		for _, block := range idx.Blocks {
+			ranges := [4]uint64{block.StartRow, block.EndRow, block.StartOffset, block.EndOffset}
+			if err := binary.Write(w, binary.LittleEndian, ranges); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for j := range idx.Header.Columns {
+		err := section(func(w io.Writer) error {
+			for b := range idx.Blocks {
+				col := idx.Stats(&idx.Blocks[b], j)
+				if col == nil {
+					return fmt.Errorf("stats of column %q can't be decoded", idx.Header.Columns[j].Name)
+				}
+				if err := writeColumnStats(w, idx, j, col); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSections reads the sections written by writeSections. The block
+// ranges are always decoded; the column sections are kept encoded when lazy.
+func readSections(r io.Reader, idx *Index, lazy bool) error {
+	l := &lazyStats{
+		compressed: idx.Header.Compressed,
+		once:       make([]sync.Once, len(idx.Header.Columns)),
+		raw:        make([][]byte, len(idx.Header.Columns)),
+		stats:      make([][]ColumnStats, len(idx.Header.Columns)),
+	}
+	readSection := func() ([]byte, error) {
+		var n uint64
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		// Read in steps so a corrupt length fails at EOF instead of
+		// allocating whatever it claims
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+			return nil, fmt.Errorf("read section: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	section, err := readSection()
+	if err != nil {
+		return err
+	}
+	ranges, err := l.open(section)
+	if err != nil {
+		return err
+	}
+	idx.Blocks = make([]BlockMeta, idx.Header.NumBlocks)
+	for i := range idx.Blocks {
+		var v [4]uint64
+		if err := binary.Read(ranges, binary.LittleEndian, &v); err != nil {
+			return err
+		}
+		block := &idx.Blocks[i]
+		block.StartRow, block.EndRow, block.StartOffset, block.EndOffset = v[0], v[1], v[2], v[3]
+		if !lazy {
+			block.Columns = make([]ColumnStats, len(idx.Header.Columns))
+		}
+	}
+
+	for j := range idx.Header.Columns {
+		if l.raw[j], err = readSection(); err != nil {
+			return err
+		}
+	}
+	if lazy {
+		idx.lazy = l
+		return nil
+	}
+
+	for j := range idx.Header.Columns {
+		r, err := l.open(l.raw[j])
+		if err != nil {
+			return err
+		}
+		for b := range idx.Blocks {
+			if err := readColumnStats(r, idx, j, &idx.Blocks[b].Columns[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package sidx
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReadIndexLazy(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id,name,amount,day\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&sb, "%d,name-%03d,%d.5,2024-01-%02d\n", i, i%97, i%40, i%28+1)
+	}
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	built, err := NewBuilder(64).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+
+	for _, compressed := range []bool{false, true} {
+		built.Header.Compressed = compressed
+		var buf bytes.Buffer
+		if err := WriteIndex(&buf, built); err != nil {
+			t.Fatalf("WriteIndex: %v", err)
+		}
+		eager, err := ReadIndex(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("ReadIndex: %v", err)
+		}
+		if !reflect.DeepEqual(eager.Blocks, built.Blocks) {
+			t.Fatalf("compressed=%v: eager read doesn't match the built index", compressed)
+		}
+		lazy, err := ReadIndexLazy(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("ReadIndexLazy: %v", err)
+		}
+
+		// Pruning on one column decodes that column only
+		for b := range lazy.Blocks {
+			if lazy.Blocks[b].Columns != nil {
+				t.Fatal("lazy index decoded block stats up front")
+			}
+			got := CanPruneBlock(lazy, &lazy.Blocks[b], "amount", ">", "30")
+			if want := CanPruneBlock(eager, &eager.Blocks[b], "amount", ">", "30"); got != want {
+				t.Errorf("compressed=%v block %d: lazy prune %v, eager %v", compressed, b, got, want)
+			}
+		}
+		for j, stats := range lazy.lazy.stats {
+			if decoded := stats != nil; decoded != (lazy.Header.Columns[j].Name == "amount") {
+				t.Errorf("compressed=%v: column %s decoded=%v", compressed, lazy.Header.Columns[j].Name, decoded)
+			}
+		}
+
+		// Columns decode once, even when asked from many goroutines
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for b := range lazy.Blocks {
+					for j := range lazy.Header.Columns {
+						if !reflect.DeepEqual(lazy.Stats(&lazy.Blocks[b], j), &eager.Blocks[b].Columns[j]) {
+							t.Errorf("compressed=%v: block %d column %d differs", compressed, b, j)
+							return
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		// A lazily read index writes out the same bytes
+		var again bytes.Buffer
+		if err := WriteIndex(&again, lazy); err != nil {
+			t.Fatalf("WriteIndex lazy: %v", err)
+		}
+		if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+			t.Errorf("compressed=%v: rewriting a lazy index changed it", compressed)
+		}
+	}
+}
+
+func TestReadIndexLazyCorruptColumn(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(csvPath, []byte("id,name\n1,a\n2,b\n3,c\n"), 0644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	built, err := NewBuilder(2).BuildFromFile(csvPath)
+	if err != nil {
+		t.Fatalf("build index: %v", err)
+	}
+	built.Header.Compressed = true
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, built); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	lazy, err := ReadIndexLazy(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndexLazy: %v", err)
+	}
+
+	// A column that doesn't decode has no stats, so nothing is pruned on it
+	name, _ := lazy.Column("name")
+	lazy.lazy.raw[name] = []byte("not deflate")
+	for b := range lazy.Blocks {
+		if lazy.Stats(&lazy.Blocks[b], name) != nil {
+			t.Fatalf("block %d: stats from a corrupt section", b)
+		}
+		if CanPruneBlock(lazy, &lazy.Blocks[b], "name", "=", "zzz") {
+			t.Errorf("block %d pruned without stats", b)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package sidx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+// Pair bounds are composite statistics for correlated columns. Block bounds
+// of country and created_at are independent: a block holding US rows from
+// January and DE rows from June can't be skipped for
+// "country = 'US' AND created_at >= June", though no row matches. The key
+// map of country can keep, for each country and block, the bounds of
+// created_at over that country's rows, and those rule the block out.
+
+// ColumnPair declares a pair of correlated columns: Lead is compared for
+// equality, Column by range, in the same WHERE
+type ColumnPair struct {
+	Lead   string
+	Column string
+}
+
+// ParseColumnPair reads a pair written "lead+column"
+func ParseColumnPair(s string) (ColumnPair, error) {
+	lead, column, ok := strings.Cut(s, "+")
+	lead, column = strings.TrimSpace(lead), strings.TrimSpace(column)
+	if !ok || lead == "" || column == "" {
+		return ColumnPair{}, fmt.Errorf("column pair %q: want lead+column, e.g. country+created_at", s)
+	}
+	return ColumnPair{Lead: lead, Column: column}, nil
+}
+
+func (p ColumnPair) String() string {
+	return p.Lead + "+" + p.Column
+}
+
+// PairedColumn is a column whose bounds a key map keeps per key and block
+type PairedColumn struct {
+	Name string
+	Type ColumnType
+}
+
+// PairBound holds the bounds of a paired column over the rows of one block
+// holding one key. Values that don't parse as the column's type match no
+// comparison the bounds answer, so they're left out.
+type PairBound struct {
+	Valid            bool   // Some row had a value of the column's type
+	Min, Max         string // String columns
+	MinNum, MaxNum   float64
+	MinTime, MaxTime int64
+}
+
+// add widens the bounds to include value of a column of type t
+func (b *PairBound) add(value string, t ColumnType, numbers datatype.NumberFormat) {
+	text, num, ts, ok := parseKey(value, t, numbers)
+	if !ok {
+		return
+	}
+	if !b.Valid {
+		*b = PairBound{Valid: true, Min: text, Max: text, MinNum: num, MaxNum: num, MinTime: ts, MaxTime: ts}
+		return
+	}
+	switch t {
+	case ColumnTypeNumeric:
+		b.MinNum, b.MaxNum = min(b.MinNum, num), max(b.MaxNum, num)
+	case ColumnTypeTimestamp:
+		b.MinTime, b.MaxTime = min(b.MinTime, ts), max(b.MaxTime, ts)
+	default:
+		b.Min, b.Max = min(b.Min, text), max(b.Max, text)
+	}
+}
+
+// pairedColumn returns the position of a paired column in m.Paired, or -1
+func (m *KeyMap) pairedColumn(name string) int {
+	for i, p := range m.Paired {
+		if strings.EqualFold(p.Name, strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Pairs returns the column pairs the key maps of idx keep bounds for
+func (idx *Index) Pairs() []ColumnPair {
+	var pairs []ColumnPair
+	for _, m := range idx.Keys {
+		for _, p := range m.Paired {
+			pairs = append(pairs, ColumnPair{Lead: m.Column, Column: p.Name})
+		}
+	}
+	return pairs
+}
+
+// CanPruneBlockPair reports whether no row of block satisfies both
+// "lead = leadValue" and "column op value", from the bounds the key map of
+// lead keeps of column. It answers false whenever the pair has no bounds
+// or the literals don't compare the way they're ordered.
+func CanPruneBlockPair(index *Index, block *BlockMeta, lead, leadValue, column, operator, value string) bool {
+	m := index.KeyMap(lead)
+	if m == nil || operator == "!=" {
+		return false
+	}
+	p := m.pairedColumn(column)
+	if p < 0 {
+		return false
+	}
+	kind, num, ts := predicateKind(leadValue)
+	if kind != m.Type || math.IsNaN(num) {
+		return false
+	}
+	valueKind, valueNum, valueTime := predicateKind(value)
+	if valueKind != m.Paired[p].Type || math.IsNaN(valueNum) {
+		return false
+	}
+	n := blockNumber(index, block)
+	if n < 0 {
+		return false
+	}
+
+	probe := Key{Text: leadValue, Num: num, Time: ts}
+	k := sort.Search(len(m.Keys), func(i int) bool { return m.compare(&m.Keys[i], &probe) >= 0 })
+	if k == len(m.Keys) || m.compare(&m.Keys[k], &probe) != 0 {
+		return true // No row holds the lead value
+	}
+	key := &m.Keys[k]
+	i := sort.Search(len(key.Blocks), func(i int) bool { return key.Blocks[i] >= uint32(n) })
+	if i == len(key.Blocks) || key.Blocks[i] != uint32(n) {
+		return true
+	}
+	bound := &key.Bounds[i*len(m.Paired)+p]
+	if !bound.Valid {
+		return true
+	}
+
+	var cmpMin, cmpMax int
+	switch valueKind {
+	case ColumnTypeNumeric:
+		cmpMin, cmpMax = cmpOrdered(valueNum, bound.MinNum), cmpOrdered(valueNum, bound.MaxNum)
+	case ColumnTypeTimestamp:
+		cmpMin, cmpMax = cmpOrdered(valueTime, bound.MinTime), cmpOrdered(valueTime, bound.MaxTime)
+	default:
+		cmpMin, cmpMax = strings.Compare(value, bound.Min), strings.Compare(value, bound.Max)
+	}
+	return pruneRange(operator, cmpMin, cmpMax, false)
+}
@@ -0,0 +1,34 @@
+package sidx
+
+import "sync"
+
+// progressEveryRows controls how often builders report progress
+const progressEveryRows = 64 * 1024
+
+// ProgressFunc receives the CSV bytes and rows processed so far.
+// Builders serialize calls, so implementations need no locking of their own.
+type ProgressFunc func(bytesDone int64, rowsDone uint64)
+
+// progressTracker aggregates progress reported by concurrent workers
+type progressTracker struct {
+	mu    sync.Mutex
+	fn    ProgressFunc
+	bytes int64
+	rows  uint64
+}
+
+func newProgressTracker(fn ProgressFunc, startBytes int64) *progressTracker {
+	return &progressTracker{fn: fn, bytes: startBytes}
+}
+
+// add records additional bytes and rows and reports the new totals
+func (t *progressTracker) add(bytes int64, rows uint64) {
+	if t == nil || t.fn == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bytes += bytes
+	t.rows += rows
+	t.fn(t.bytes, t.rows)
+}
@@ -0,0 +1,262 @@
+package sidx
+
+import (
+	"math"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+// Which representations a columnAccumulator tracks. While types are still
+// being inferred every representation is tracked; afterwards only the
+// column's own type is, so later blocks don't pay for extra parsing.
+const (
+	trackString uint8 = 1 << iota
+	trackNumeric
+	trackTimestamp
+	trackBoolean
+	trackSum // Numeric values are also added up, for columns built with sums
+	trackAll = trackString | trackNumeric | trackTimestamp | trackBoolean
+)
+
+// String bounds are always tracked: a typed column that turns out to be mixed
+// needs them, and keeping them costs two comparisons per value.
+func trackFor(t ColumnType) uint8 {
+	switch t {
+	case ColumnTypeNumeric:
+		return trackNumeric | trackString
+	case ColumnTypeTimestamp:
+		return trackTimestamp | trackString
+	case ColumnTypeBoolean:
+		return trackBoolean | trackString
+	default:
+		return trackString
+	}
+}
+
+// columnAccumulator collects one column's statistics for the block being built
+type columnAccumulator struct {
+	numbers datatype.NumberFormat // How numeric values are written
+
+	min, max string // Lexicographic bounds of non-empty values
+
+	numMin, numMax         float64
+	numMinText, numMaxText string
+
+	timeMin, timeMax         int64
+	timeMinText, timeMaxText string
+
+	boolMin, boolMax         float64 // false is 0, true is 1
+	boolMinText, boolMaxText string
+
+	sum        float64
+	sumDecimal datatype.Decimal // Exact sum while sumInexact is unset
+	sumInexact bool
+
+	empty      uint32 // Empty or missing values
+	nonEmpty   uint32
+	numeric    uint32 // Non-empty values that parsed as numbers (NaN excluded)
+	timestamps uint32 // Non-empty values that parsed as timestamps
+	booleans   uint32 // Non-empty values that parsed as booleans
+}
+
+func (a *columnAccumulator) add(value string, track uint8) {
+	if value == "" {
+		a.empty++
+		return
+	}
+	a.nonEmpty++
+
+	if track&trackString != 0 {
+		if a.min == "" || value < a.min {
+			a.min = value
+		}
+		if a.max == "" || value > a.max {
+			a.max = value
+		}
+	}
+
+	if track&trackNumeric != 0 {
+		// NaN has no place in an ordering; it is counted as invalid
+		if f, ok := a.numbers.ParseFloat(value); ok && !math.IsNaN(f) {
+			if a.numeric == 0 || f < a.numMin {
+				a.numMin, a.numMinText = f, value
+			}
+			if a.numeric == 0 || f > a.numMax {
+				a.numMax, a.numMaxText = f, value
+			}
+			a.numeric++
+			if track&trackSum != 0 {
+				a.addSum(f, value)
+			}
+		}
+	}
+
+	if track&trackTimestamp != 0 {
+		if ts, ok := datatype.ParseTimestamp(value); ok {
+			if a.timestamps == 0 || ts < a.timeMin {
+				a.timeMin, a.timeMinText = ts, value
+			}
+			if a.timestamps == 0 || ts > a.timeMax {
+				a.timeMax, a.timeMaxText = ts, value
+			}
+			a.timestamps++
+		}
+	}
+
+	if track&trackBoolean != 0 {
+		if b, ok := datatype.ParseBool(value); ok {
+			f := 0.0
+			if b {
+				f = 1
+			}
+			if a.booleans == 0 || f < a.boolMin {
+				a.boolMin, a.boolMinText = f, value
+			}
+			if a.booleans == 0 || f > a.boolMax {
+				a.boolMax, a.boolMaxText = f, value
+			}
+			a.booleans++
+		}
+	}
+}
+
+// addSum adds a numeric value to the sums, keeping the exact one while every
+// value is a plain decimal and the total fits
+func (a *columnAccumulator) addSum(f float64, value string) {
+	a.sum += f
+	if a.sumInexact {
+		return
+	}
+	n, _ := a.numbers.Normalize(value)
+	d, ok := datatype.ParseDecimal(n)
+	if ok {
+		d, ok = a.sumDecimal.Add(d)
+	}
+	if !ok {
+		a.sumInexact, a.sumDecimal = true, datatype.Decimal{}
+		return
+	}
+	a.sumDecimal = d
+}
+
+// inferType picks the column type from the values seen so far. Numbers come
+// first, so a column of only 0 and 1 stays numeric; true/false and yes/no
+// make a column boolean.
+func (a *columnAccumulator) inferType() ColumnType {
+	switch {
+	case isMostlyNumeric(int(a.numeric), int(a.nonEmpty)):
+		return ColumnTypeNumeric
+	case isMostlyType(int(a.timestamps), int(a.nonEmpty)):
+		return ColumnTypeTimestamp
+	case isMostlyType(int(a.booleans), int(a.nonEmpty)):
+		return ColumnTypeBoolean
+	default:
+		return ColumnTypeString
+	}
+}
+
+// stats returns the block statistics for a column of type t
+func (a *columnAccumulator) stats(t ColumnType) ColumnStats {
+	cs := ColumnStats{EmptyCount: a.empty}
+	if t != ColumnTypeString {
+		cs.StrMin, cs.StrMax = a.min, a.max
+	}
+	switch t {
+	case ColumnTypeNumeric:
+		cs.InvalidCount = a.nonEmpty - a.numeric
+		cs.Sum, cs.SumDecimal, cs.SumInexact = a.sum, a.sumDecimal, a.sumInexact
+		if a.numeric > 0 {
+			cs.Min, cs.Max = a.numMinText, a.numMaxText
+			cs.MinNum, cs.MaxNum = a.numMin, a.numMax
+		}
+	case ColumnTypeTimestamp:
+		cs.InvalidCount = a.nonEmpty - a.timestamps
+		if a.timestamps > 0 {
+			cs.Min, cs.Max = a.timeMinText, a.timeMaxText
+			cs.MinTime, cs.MaxTime = a.timeMin, a.timeMax
+		}
+	case ColumnTypeBoolean:
+		cs.InvalidCount = a.nonEmpty - a.booleans
+		if a.booleans > 0 {
+			cs.Min, cs.Max = a.boolMinText, a.boolMaxText
+			cs.MinNum, cs.MaxNum = a.boolMin, a.boolMax
+		}
+	default:
+		cs.Min, cs.Max = a.min, a.max
+	}
+	return cs
+}
+
+func (a *columnAccumulator) reset() {
+	*a = columnAccumulator{numbers: a.numbers}
+}
+
+// newAccumulators returns n column accumulators reading numbers in format f
+func newAccumulators(n int, f datatype.NumberFormat) []columnAccumulator {
+	accs := make([]columnAccumulator, n)
+	for i := range accs {
+		accs[i].numbers = f
+	}
+	return accs
+}
+
+// mergeColumnStats folds the statistics of a later partial block src into dst.
+// Ties keep dst's value so the result matches a single sequential pass.
+func mergeColumnStats(dst, src *ColumnStats, t ColumnType) {
+	dst.EmptyCount += src.EmptyCount
+	dst.InvalidCount += src.InvalidCount
+	if src.StrMin != "" && (dst.StrMin == "" || src.StrMin < dst.StrMin) {
+		dst.StrMin = src.StrMin
+	}
+	if src.StrMax > dst.StrMax {
+		dst.StrMax = src.StrMax
+	}
+	mergeSums(dst, src)
+	if src.Min == "" {
+		return
+	}
+	if dst.Min == "" {
+		dst.Min, dst.Max = src.Min, src.Max
+		dst.MinNum, dst.MaxNum = src.MinNum, src.MaxNum
+		dst.MinTime, dst.MaxTime = src.MinTime, src.MaxTime
+		return
+	}
+
+	switch t {
+	case ColumnTypeNumeric, ColumnTypeBoolean:
+		if src.MinNum < dst.MinNum {
+			dst.Min, dst.MinNum = src.Min, src.MinNum
+		}
+		if src.MaxNum > dst.MaxNum {
+			dst.Max, dst.MaxNum = src.Max, src.MaxNum
+		}
+	case ColumnTypeTimestamp:
+		if src.MinTime < dst.MinTime {
+			dst.Min, dst.MinTime = src.Min, src.MinTime
+		}
+		if src.MaxTime > dst.MaxTime {
+			dst.Max, dst.MaxTime = src.Max, src.MaxTime
+		}
+	default:
+		if src.Min < dst.Min {
+			dst.Min = src.Min
+		}
+		if src.Max > dst.Max {
+			dst.Max = src.Max
+		}
+	}
+}
+
+// mergeSums adds the sums of src to dst's
+func mergeSums(dst, src *ColumnStats) {
+	dst.Sum += src.Sum
+	if dst.SumInexact {
+		return
+	}
+	d, ok := dst.SumDecimal.Add(src.SumDecimal)
+	if src.SumInexact || !ok {
+		dst.SumInexact, dst.SumDecimal = true, datatype.Decimal{}
+		return
+	}
+	dst.SumDecimal = d
+}
@@ -0,0 +1,139 @@
+package sqlparser
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError is a parse error at a known place in the query
+type SyntaxError struct {
+	Msg    string
+	Pos    int    // Byte offset in the query
+	Line   int    // 1-based line of Pos
+	Column int    // 1-based character (not byte) column of Pos
+	Token  string // The unexpected token as written; empty at the end of the query
+	Hint   string // A likely fix, e.g. "did you mean WHERE?"; may be empty
+}
+
+func (e *SyntaxError) Error() string {
+	where := fmt.Sprintf("column %d", e.Column)
+	if e.Line > 1 {
+		where = fmt.Sprintf("line %d, column %d", e.Line, e.Column)
+	}
+	msg := fmt.Sprintf("%s at %s", e.Msg, where)
+	if e.Hint != "" {
+		msg += "; " + e.Hint
+	}
+	return msg
+}
+
+// newSyntaxError locates msg at tok in input
+func newSyntaxError(input string, tok token, msg string) *SyntaxError {
+	before := input[:tok.pos]
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	return &SyntaxError{
+		Msg:    msg,
+		Pos:    tok.pos,
+		Line:   strings.Count(before, "\n") + 1,
+		Column: utf8.RuneCountInString(before[lineStart:]) + 1,
+		Token:  input[tok.pos:tok.end],
+	}
+}
+
+// contextWidth is how many characters Context shows on each side of the error
+const contextWidth = 40
+
+// Context returns the line of query holding the error, shortened around it
+// if long, with a caret under the error's column:
+//
+//	SELECT * FROM data.csv WHRE x = 1
+//	                       ^
+func (e *SyntaxError) Context(query string) string {
+	lines := strings.Split(query, "\n")
+	if e.Line < 1 || e.Line > len(lines) {
+		return ""
+	}
+	line := []rune(strings.TrimRight(lines[e.Line-1], "\r"))
+	col := e.Column - 1
+
+	start, end := max(col-contextWidth, 0), min(col+contextWidth, len(line))
+	prefix, suffix := "", ""
+	if start > 0 {
+		prefix = "..."
+	}
+	if end < len(line) {
+		suffix = "..."
+	}
+
+	// Tabs stay tabs under the caret so it lines up in a terminal
+	var pad strings.Builder
+	pad.WriteString(strings.Repeat(" ", len(prefix)))
+	for _, r := range line[start:min(col, len(line))] {
+		if r == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	return prefix + string(line[start:end]) + suffix + "\n" + pad.String() + "^"
+}
+
+// keywords are suggested for misspelled words in error hints
+var keywords = []string{
+	"SELECT", "INTO", "FROM", "WHERE", "GROUP", "BY", "LIMIT", "CREATE", "TABLE", "AS",
+	"SAMPLE", "TABLESAMPLE", "BERNOULLI", "REPEATABLE", "PERCENT", "ROWS",
+	"AND", "OR", "NOT", "BETWEEN", "IN", "ILIKE", "CAST", "FILTER",
+	"QUALIFY", "OVER", "PARTITION", "ORDER", "ASC", "DESC", "HAVING",
+}
+
+// suggestKeyword returns the keyword word is most likely a misspelling of,
+// or "" if there is none close enough (or word is itself a keyword). LIKE
+// suggests ILIKE, the only pattern operator.
+func suggestKeyword(word string) string {
+	upper := strings.ToUpper(word)
+	if len(upper) < 3 {
+		return ""
+	}
+	best, bestDist := "", 3
+	for _, kw := range keywords {
+		if kw == upper {
+			return ""
+		}
+		limit := 1
+		if len(kw) > 5 {
+			limit = 2
+		}
+		if d := editDistance(upper, kw); d <= limit && d < bestDist {
+			best, bestDist = kw, d
+		}
+	}
+	return best
+}
+
+// editDistance counts the single-character insertions, deletions,
+// substitutions, and adjacent swaps that turn a into b
+func editDistance(a, b string) int {
+	// Three rows of the optimal string alignment table
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,97 @@
+package sqlparser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSyntaxErrorHints(t *testing.T) {
+	tests := []struct {
+		query string
+		token string
+		hint  string
+	}{
+		{"SELECT * FROM data.csv WHRE x = 1", "WHRE", "did you mean WHERE?"},
+		{"SELECT * FORM data.csv", "FORM", "did you mean FROM?"},
+		{"SELECT a, b FRM data.csv WHERE x = 1", "FRM", "did you mean FROM?"},
+		{"SELECT * FROM data.csv WHERE x = 1 LIMT 5", "LIMT", "did you mean LIMIT?"},
+		{"SELECT * FROM data.csv WHERE name LIKE 'a%'", "LIKE", "did you mean ILIKE?"},
+		{"SELECT * FROM data.csv GRUOP BY a", "GRUOP", "did you mean GROUP?"},
+		{"SELECT * FROM data.csv WHERE x = 1 ORDER x", "x", ""},
+		{"SELECT a, COUNT(*) FROM data.csv GROUP BY a HAVNG COUNT(*) > 1", "HAVNG", "did you mean HAVING?"},
+		{"SELECT * FROM data.csv WHERE x 1", "1", ""},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("%q: expected a SyntaxError, got %v", tt.query, err)
+			continue
+		}
+		if serr.Token != tt.token || serr.Hint != tt.hint {
+			t.Errorf("%q: token %q hint %q, want %q %q", tt.query, serr.Token, serr.Hint, tt.token, tt.hint)
+		}
+		if tt.hint != "" && !strings.HasSuffix(serr.Error(), "; "+tt.hint) {
+			t.Errorf("%q: hint missing from %q", tt.query, serr.Error())
+		}
+	}
+}
+
+func TestSyntaxErrorLocation(t *testing.T) {
+	query := "SELECT *\nFROM 'données.csv' WHERE prix > 1\n\tAND x ?"
+	_, err := Parse(query)
+	var serr *SyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected a SyntaxError, got %v", err)
+	}
+	if serr.Line != 3 || serr.Column != 8 || serr.Pos != strings.Index(query, "?") {
+		t.Errorf("error at line %d, column %d, offset %d", serr.Line, serr.Column, serr.Pos)
+	}
+	if want := "\tAND x ?\n\t      ^"; serr.Context(query) != want {
+		t.Errorf("context:\n%s\nwant:\n%s", serr.Context(query), want)
+	}
+
+	// Columns count characters, not bytes
+	_, err = Parse("SELECT * FROM 'données.csv' WHERE é")
+	if !errors.As(err, &serr) || serr.Column != 35 || serr.Pos != 35 {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSyntaxErrorContextLongLine(t *testing.T) {
+	query := "SELECT * FROM data.csv WHERE " + strings.Repeat("a = 1 OR ", 20) + "b ="
+	_, err := Parse(query)
+	var serr *SyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected a SyntaxError, got %v", err)
+	}
+	lines := strings.Split(serr.Context(query), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "...") || len(lines[0]) > 2*contextWidth+6 {
+		t.Fatalf("unexpected context: %q", lines)
+	}
+	if caret := strings.Index(lines[1], "^"); caret != len(lines[0]) {
+		t.Errorf("caret at %d doesn't point past the end of %q", caret, lines[0])
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"WHERE", "WHERE", 0},
+		{"WHRE", "WHERE", 1},
+		{"FORM", "FROM", 1},
+		{"LIMT", "LIMIT", 1},
+		{"GRUOP", "GROUP", 1},
+		{"SELCET", "SELECT", 1},
+		{"", "AND", 3},
+		{"ORDER", "OR", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package sqlparser
+
+import (
+	"errors"
+	"testing"
+)
+
+// FuzzParse checks that Parse never panics, that syntax errors point into
+// the query, and that parsed conditions evaluate. Run it with
+//
+//	go test ./internal/sqlparser -run '^$' -fuzz FuzzParse
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"SELECT * FROM 'data.csv'",
+		"SELECT a, b AS bee FROM data.csv WHERE a > 10 AND NOT (b = 'x' OR c IN (1, 2)) LIMIT 5",
+		"SELECT country, COUNT(*), SUM(total) FROM 'o.csv' GROUP BY country HAVING COUNT(*) > 1 ORDER BY 3 DESC LIMIT 2",
+		"SELECT * FROM o.csv WHERE CAST(amount AS DECIMAL) >= 1.5 AND name ILIKE 'a%'",
+		"SELECT price * qty AS total FROM o.csv WHERE ROUND(price / 3, 1) = 3.3",
+		"SELECT * FROM o.csv WHERE user_id IN (SELECT user_id FROM 'vip.csv' WHERE tier = 'gold')",
+		"SELECT * FROM o.csv QUALIFY ROW_NUMBER() OVER (PARTITION BY a ORDER BY b DESC) <= 2",
+		"SELECT * FROM o.csv WHERE name = 'O''Brien' OR path = 'C:\\data'",
+		"SELECT COUNT(*) FILTER (WHERE a > 1) FROM o.csv",
+		"SELECT * FROM '-' TABLESAMPLE BERNOULLI (10) REPEATABLE (7)",
+		"SELCT * FORM x WHERE",
+		"SELECT * FROM o.csv WHERE a = 'unterminated",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, sql string) {
+		q, err := Parse(sql)
+		if err != nil {
+			var syntax *SyntaxError
+			if errors.As(err, &syntax) && (syntax.Pos < 0 || syntax.Pos > len(sql)) {
+				t.Errorf("Parse(%q): error at %d, outside the query: %v", sql, syntax.Pos, err)
+			}
+			return
+		}
+		for _, expr := range []Expression{q.Where, q.Having} {
+			Evaluate(expr, map[string]string{})
+			EvaluateNormalized(expr, map[string]string{"a": "1", "b": "x"})
+		}
+	})
+}
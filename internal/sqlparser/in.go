@@ -0,0 +1,88 @@
+package sqlparser
+
+import (
+	"math"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+// InExpr tests a column against a set of values, as in WHERE country IN
+// ('UK', 'US') or WHERE user_id IN (SELECT user_id FROM vip.csv). NOT IN is
+// a UnaryExpr around it. Values that read as numbers match numerically (7
+// matches 7.0); others match as strings.
+type InExpr struct {
+	Column string
+	Values []string
+	// Subquery produces Values when the query runs; the engine fills them
+	// in and clears it
+	Subquery *Query
+	// Numbers reads numbers in the data and in Values; see WithNumberFormat
+	Numbers datatype.NumberFormat
+	// CaseInsensitive matches string values regardless of case (set by
+	// FoldCase)
+	CaseInsensitive bool
+}
+
+func (InExpr) isExpression() {}
+
+// Number reads value as a number in e's format; NaN isn't one, since it
+// would never match
+func (e InExpr) Number(value string) (float64, bool) {
+	n, ok := e.Numbers.ParseFloat(value)
+	return n, ok && !math.IsNaN(n)
+}
+
+// Fold returns the form of a string value that matching compares
+func (e InExpr) Fold(value string) string {
+	if e.CaseInsensitive {
+		return strings.ToLower(value)
+	}
+	return value
+}
+
+// Contains reports whether candidate matches one of e.Values. It scans the
+// list; the engine builds a hash set instead.
+func (e InExpr) Contains(candidate string) bool {
+	n, isNumber := e.Number(candidate)
+	folded := e.Fold(candidate)
+	for _, value := range e.Values {
+		if m, ok := e.Number(value); ok {
+			if isNumber && m == n {
+				return true
+			}
+		} else if !isNumber && e.Fold(value) == folded {
+			return true
+		}
+	}
+	return false
+}
+
+// Subqueries returns the queries of the IN (SELECT ...) conditions in expr,
+// including those nested in their WHERE clauses, outermost first
+func Subqueries(expr Expression) []*Query {
+	if expr == nil {
+		return nil
+	}
+	return VisitExpression[[]*Query](expr, subqueryLister{})
+}
+
+// subqueryLister lists the subqueries of an expression
+type subqueryLister struct{}
+
+func (subqueryLister) Binary(e BinaryExpr) []*Query {
+	return append(Subqueries(e.Left), Subqueries(e.Right)...)
+}
+
+func (subqueryLister) Unary(e UnaryExpr) []*Query { return Subqueries(e.Expr) }
+
+func (subqueryLister) Comparison(Comparison) []*Query             { return nil }
+func (subqueryLister) ColumnComparison(ColumnComparison) []*Query { return nil }
+func (subqueryLister) ExprComparison(ExprComparison) []*Query     { return nil }
+
+func (subqueryLister) In(e InExpr) []*Query {
+	if e.Subquery == nil {
+		return nil
+	}
+	return append([]*Query{e.Subquery}, Subqueries(e.Subquery.Where)...)
+}
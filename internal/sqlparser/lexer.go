@@ -0,0 +1,160 @@
+package sqlparser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// tokenKind classifies the tokens of a query
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokWord             // Keyword, column name, or number
+	tokString           // Quoted literal; text holds the contents
+	tokIdent            // `Quoted` or [bracketed] identifier; text holds the name
+	tokSymbol           // Operator or punctuation
+	tokError            // Malformed input; text holds the message
+)
+
+// token is one lexical unit of a query. Keywords aren't reserved: the parser
+// decides from context whether a word is one.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // Byte offset of the first character
+	end  int // Byte offset just past the last character
+}
+
+// symbols are the operators and punctuation, longest first so "<=" wins
+// over "<"
+var symbols = []string{"<=>", "=~", "!=", ">=", "<=", "=", ">", "<", "(", ")", ",", "*", "%"}
+
+// lex returns the token starting at or after pos. Whitespace (newlines
+// included) and comments separate tokens.
+func lex(input string, pos int) token {
+	pos, ok := skipSpace(input, pos)
+	if !ok {
+		return token{kind: tokError, text: "unterminated comment", pos: pos, end: len(input)}
+	}
+	if pos == len(input) {
+		return token{kind: tokEOF, pos: pos, end: pos}
+	}
+
+	c := input[pos]
+	switch {
+	case c == ';':
+		// A statement may end with a semicolon, as statements in scripts do.
+		// Anything but comments after one is a second statement, which the
+		// parser rejects at the semicolon.
+		if rest, ok := skipSpace(input, pos+1); ok && rest == len(input) {
+			return token{kind: tokEOF, pos: pos, end: len(input)}
+		}
+	case c == '\'' || c == '"':
+		return lexString(input, pos)
+	case c == '`':
+		return lexIdent(input, pos, '`')
+	case c == '[':
+		return lexIdent(input, pos, ']')
+	case isWordChar(c):
+		// Words starting with a digit are numbers and may have a fraction
+		number := c >= '0' && c <= '9'
+		end := pos + 1
+		for end < len(input) && (isWordChar(input[end]) || number && input[end] == '.') {
+			end++
+		}
+		return token{kind: tokWord, text: input[pos:end], pos: pos, end: end}
+	}
+	for _, s := range symbols {
+		if strings.HasPrefix(input[pos:], s) {
+			return token{kind: tokSymbol, text: s, pos: pos, end: pos + len(s)}
+		}
+	}
+	// Any other character stands alone; the parser reports it if misplaced
+	_, size := utf8.DecodeRuneInString(input[pos:])
+	return token{kind: tokSymbol, text: input[pos : pos+size], pos: pos, end: pos + size}
+}
+
+// lexString reads the quoted literal at pos. Inside it, the closing quote
+// written twice, or a backslash before a quote or backslash, stands for that
+// character; other backslashes are kept as written, so 'C:\data' needs no
+// escaping.
+func lexString(input string, pos int) token {
+	quote := input[pos]
+	var b strings.Builder
+	for i := pos + 1; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == '\\' && i+1 < len(input) && isEscapable(input[i+1]):
+			i++
+			b.WriteByte(input[i])
+		case c == quote && i+1 < len(input) && input[i+1] == quote:
+			i++
+			b.WriteByte(quote)
+		case c == quote:
+			return token{kind: tokString, text: b.String(), pos: pos, end: i + 1}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return token{kind: tokError, text: "unterminated string", pos: pos, end: len(input)}
+}
+
+// lexIdent reads the `quoted` or [bracketed] identifier at pos, which ends
+// at close. A doubled close stands for that character.
+func lexIdent(input string, pos int, close byte) token {
+	var b strings.Builder
+	for i := pos + 1; i < len(input); i++ {
+		switch {
+		case input[i] == close && i+1 < len(input) && input[i+1] == close:
+			i++
+			b.WriteByte(close)
+		case input[i] == close:
+			return token{kind: tokIdent, text: b.String(), pos: pos, end: i + 1}
+		default:
+			b.WriteByte(input[i])
+		}
+	}
+	return token{kind: tokError, text: "unterminated identifier", pos: pos, end: len(input)}
+}
+
+// isEscapable reports whether a backslash before c escapes it in a literal
+func isEscapable(c byte) bool {
+	return c == '\'' || c == '"' || c == '\\'
+}
+
+// skipSpace returns the first position at or after pos that isn't
+// whitespace or inside a comment: -- to the end of the line, or /* ... */
+// (which doesn't nest). For an unterminated /* it returns the comment's
+// start and false.
+func skipSpace(input string, pos int) (int, bool) {
+	for pos < len(input) {
+		switch {
+		case isSpace(input[pos]):
+			pos++
+		case strings.HasPrefix(input[pos:], "--"):
+			end := strings.IndexByte(input[pos:], '\n')
+			if end < 0 {
+				return len(input), true
+			}
+			pos += end + 1
+		case strings.HasPrefix(input[pos:], "/*"):
+			end := strings.Index(input[pos+2:], "*/")
+			if end < 0 {
+				return pos, false
+			}
+			pos += end + 4
+		default:
+			return pos, true
+		}
+	}
+	return pos, true
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
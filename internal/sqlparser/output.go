@@ -0,0 +1,57 @@
+package sqlparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultPrecision is the digits after the point of SUM, AVG, MIN, and MAX
+// results unless OutputFormat sets them. SUM, MIN, and MAX over whole
+// numbers only are written without a point.
+const DefaultPrecision = 2
+
+// OutputFormat is how aggregate results are rendered. The zero value writes
+// SUM, AVG, MIN, and MAX with DefaultPrecision digits and no grouping.
+type OutputFormat struct {
+	Precision    int  // Digits after the point, when HasPrecision
+	HasPrecision bool // Results are rounded to exactly Precision digits
+	// Thousands groups the whole digits of every aggregate, COUNT included;
+	// 0 for none. With '.', the decimal point is written as ','
+	Thousands byte
+	// MinorUnits maps columns holding minor units (cents), by lower-case
+	// name, to the digits their aggregates are shifted by: SUM(total_cents)
+	// with 2 is written in major units
+	MinorUnits map[string]int
+	// Null is written for NULL (empty or missing) GROUP BY keys, which form
+	// one group, and for MIN and MAX over no values; "" leaves them empty
+	Null string
+}
+
+// ParseMinorUnits parses a comma-separated list of columns holding minor
+// units, each optionally followed by :digits (default 2), such as
+// "total_cents,fee_millis:3"
+func ParseMinorUnits(spec string) (map[string]int, error) {
+	units := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		column, digits, hasDigits := strings.Cut(entry, ":")
+		column = strings.TrimSpace(column)
+		shift := 2
+		if hasDigits {
+			n, err := strconv.Atoi(strings.TrimSpace(digits))
+			if err != nil || n < 1 || n > 9 {
+				return nil, fmt.Errorf("minor units %q: digits must be between 1 and 9", entry)
+			}
+			shift = n
+		}
+		if column == "" {
+			return nil, fmt.Errorf("minor units %q: expected column or column:digits", entry)
+		}
+		units[strings.ToLower(column)] = shift
+	}
+	return units, nil
+}
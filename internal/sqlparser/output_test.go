@@ -0,0 +1,22 @@
+package sqlparser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMinorUnits(t *testing.T) {
+	units, err := ParseMinorUnits("Total_Cents, fee_millis:3,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]int{"total_cents": 2, "fee_millis": 3}; !reflect.DeepEqual(units, want) {
+		t.Errorf("got %v, want %v", units, want)
+	}
+
+	for _, spec := range []string{":2", "cents:0", "cents:x", "cents:10"} {
+		if _, err := ParseMinorUnits(spec); err == nil {
+			t.Errorf("ParseMinorUnits(%q): expected error", spec)
+		}
+	}
+}
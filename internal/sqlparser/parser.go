@@ -2,19 +2,139 @@ package sqlparser
 
 import (
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/melihbirim/sieswi/internal/csvio"
+	"github.com/melihbirim/sieswi/internal/datatype"
 )
 
 // Query captures the minimal information required to execute a CSV query.
 type Query struct {
-	Columns    []string
+	Columns []string
+	// Exprs holds the computed SELECT items, parallel to Columns (which then
+	// hold their text), and the argument of an aggregate over an expression
+	// (SUM(price * qty)): nil for plain columns and other aggregates. Aliases
+	// holds the output names given with AS, "" where there is none. Either is
+	// nil when no item needs it
+	Exprs   []Scalar
+	Aliases []string
+	// Filters holds the FILTER (WHERE ...) condition of each aggregate that
+	// has one, parallel to Columns; nil when none does
+	Filters    []Expression
 	AllColumns bool
 	FilePath   string
 	Where      Expression
 	GroupBy    []string // Columns to group by
+	// Having filters the rows of an aggregate query, and OrderBy sorts them,
+	// before LIMIT. Their columns name the output: group columns, aliases,
+	// and aggregates written as in SELECT (SUM(total)), which may be left
+	// out of it
+	Having     Expression
+	OrderBy    []OrderItem
 	Limit      int
+	OutputPath string // File named by SELECT ... INTO 'file', empty for stdout
+	// CreateTable is set by CREATE TABLE 'file' AS SELECT ...: the result is
+	// written to OutputPath and indexed
+	CreateTable bool
+	// NoHeader marks an input whose first row is data rather than column
+	// names. Columns are named by HeaderNames, then c1, c2, ... by position
+	NoHeader    bool
+	HeaderNames []string
+	// HasHeader declares the first row holds column names. With neither it
+	// nor NoHeader set, the engine sniffs which it is
+	HasHeader bool
+	// Dialect is how the input separates and quotes fields; fields left
+	// zero are sniffed from the start of the file
+	Dialect csvio.Dialect
+	// Numbers is how the input writes numbers; see WithNumberFormat
+	Numbers datatype.NumberFormat
+	// Encoding is the input's character encoding (see csvio.ParseEncoding);
+	// empty for UTF-8
+	Encoding string
+	// Sample draws a random subset of the rows matching WHERE; nil reads them all
+	Sample *Sample
+	// Top keeps the first rows of each partition, from QUALIFY; nil keeps all
+	Top *TopN
+	// OnError is what to do with a row whose field count differs from the
+	// header's (one of the OnError constants). Empty keeps it, reading
+	// missing fields as empty. Dropped rows are written to RejectPath if set
+	OnError    string
+	RejectPath string
+	// IndexMode is whether the query reads the input's .sidx (one of the
+	// IndexMode constants). Empty uses it when it's present and valid
+	IndexMode string
+	// Resources bounds the workers and buffers the query uses; the zero
+	// value uses the defaults
+	Resources Resources
+	// Output is how aggregate results are rendered; see OutputFormat
+	Output OutputFormat
+	// StableOrder writes rows in input order whichever path runs the
+	// query, where it would otherwise choose another (QUALIFY ranks them)
+	StableOrder bool
+	// Unordered lets a parallel scan write each batch of rows as soon as
+	// a worker finishes it, interleaving parts of the file. StableOrder and
+	// SAMPLE, which draws by position, override it
+	Unordered bool
+}
+
+// OrderItem is one ORDER BY key of an aggregate query
+type OrderItem struct {
+	// Expr is computed from the output columns, named as in HAVING. A
+	// number instead picks the output column at Position, from 1
+	Expr     Scalar
+	Position int
+	Desc     bool
+}
+
+// Resources caps what a query takes from the machine, for shared servers
+// and small containers. Zero fields use the defaults.
+type Resources struct {
+	Threads         int   // Parallel scan workers; 0 uses GOMAXPROCS, 1 scans sequentially
+	ParallelMinSize int64 // Inputs smaller than this are scanned sequentially; 0 uses 10MB
+	IOBufferSize    int   // Read and write buffer size; 0 uses 1MB reads and 256KB writes
+	// MaxMemory bounds the rows a parallel scan holds for the writer, by
+	// sending smaller batches; 0 leaves batches at their row count. It is
+	// no limit on the process: aggregates keep every group regardless
+	MaxMemory int64
+}
+
+// Policies for malformed rows, set by --on-error
+const (
+	OnErrorStrict = "strict" // Fail the query at the first malformed row
+	OnErrorSkip   = "skip"   // Drop malformed rows and count them
+	OnErrorLog    = "log"    // Drop malformed rows and report each one
+)
+
+// Index policies, set by --no-index and --require-index
+const (
+	IndexModeOff     = "off"     // Never read the index, even a valid one
+	IndexModeRequire = "require" // Fail the query when there is no usable index
+)
+
+// Sample is a SAMPLE clause: SAMPLE 1% keeps each row with probability
+// Percent/100 (Bernoulli), SAMPLE 10000 ROWS keeps a uniform sample of Rows
+// rows (reservoir)
+type Sample struct {
+	Percent   float64
+	Rows      int
+	Reservoir bool
+	// Seed makes the sample repeatable, set by REPEATABLE (seed)
+	Seed       int64
+	Repeatable bool
+}
+
+// TopN is QUALIFY ROW_NUMBER() OVER (PARTITION BY ... ORDER BY ...) <= N:
+// the first N rows matching WHERE in each partition, ranked by OrderBy.
+// Values that both read as numbers rank numerically, others as strings;
+// rows without a value rank last and ties keep input order.
+type TopN struct {
+	PartitionBy []string // Empty for one partition holding every row
+	OrderBy     Scalar
+	Desc        bool
+	N           int
 }
 
 // Expression represents a boolean expression in the WHERE clause
@@ -42,392 +162,1469 @@ func (UnaryExpr) isExpression() {}
 // Comparison represents a single column comparison
 type Comparison struct {
 	Column       string
-	Operator     string // "=", "!=", ">", ">=", "<", "<="
+	Operator     string // "=", "!=", ">", ">=", "<", "<=", "ILIKE"
 	Value        string
 	NumericValue float64
 	IsNumeric    bool
+	TimeValue    int64 // Unix nanos, set when Value is a date or date-time
+	IsTimestamp  bool
+	// DecimalValue is set for columns declared decimal, which compare
+	// exactly; values that aren't plain decimals never match
+	DecimalValue datatype.Decimal
+	IsDecimal    bool
+	// BoolValue is set for boolean comparisons (WHERE active, or a column
+	// declared boolean); values that aren't true/false, yes/no, or 1/0
+	// never match
+	BoolValue bool
+	IsBool    bool
+	// Numbers reads candidate values (and the literal) for numeric and
+	// decimal comparisons; see WithNumberFormat
+	Numbers datatype.NumberFormat
+	// CaseInsensitive compares string values with Unicode case folding
+	// (set by =~, ILIKE, or FoldCase)
+	CaseInsensitive bool
+	// Type is the column type declared by CAST or WithSchema; empty when the
+	// comparison kind is inferred from Value
+	Type string
 }
 
 func (Comparison) isExpression() {}
 
-// Predicate is kept for backward compatibility (deprecated)
-type Predicate = Comparison
-
-var (
-	queryRe = regexp.MustCompile(`(?i)^\s*select\s+(.+?)\s+from\s+((?:'[^']+'|"[^"]+"|\S+))(?:\s+where\s+(.+?))?(?:\s+group\s+by\s+(.+?))?(?:\s+limit\s+(\d+))?\s*$`)
+// ColumnComparison compares two columns of the same row, as in
+// WHERE discount_minor > price_minor
+type ColumnComparison struct {
+	Left     string
+	Operator string // "=", "!=", ">", ">=", "<", "<="
+	Right    string
+	// Numbers reads both values for numeric and decimal comparisons; see
+	// WithNumberFormat
+	Numbers datatype.NumberFormat
+	// CaseInsensitive compares string values with Unicode case folding
+	// (set by =~ or FoldCase)
+	CaseInsensitive bool
+	// NullSafe makes an empty value equal another empty one and differ
+	// from anything else, whatever Type (set by <=>)
+	NullSafe bool
+	// Type is the type both columns compare as, declared by CAST or
+	// WithSchema; empty when each row's values decide (see Compare)
+	Type string
+}
 
-	predicateRe = regexp.MustCompile(`(?i)^\s*([a-zA-Z0-9_]+)\s*(=|!=|>=|<=|>|<)\s*(.+?)\s*$`)
-)
+func (ColumnComparison) isExpression() {}
 
-// isWordBoundary returns true if the character is a word boundary (whitespace or paren)
-func isWordBoundary(c byte) bool {
-	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(' || c == ')'
-}
+// Predicate is kept for backward compatibility (deprecated)
+type Predicate = Comparison
 
 // Parse turns a limited SQL string into a Query structure.
 func Parse(input string) (Query, error) {
-	matches := queryRe.FindStringSubmatch(input)
-	if len(matches) == 0 {
-		return Query{}, fmt.Errorf("unsupported query; expected SELECT ... FROM file [WHERE ...] [LIMIT ...]")
+	p := &parser{input: input}
+	p.advance()
+	switch {
+	case p.isKeyword("CREATE"):
+		return p.createTable()
+	case p.isKeyword("SELECT"):
+		return p.selectStatement()
 	}
+	return Query{}, p.errorf("unsupported query; expected SELECT ... [INTO file] FROM file [SAMPLE ...] [WHERE ...] [GROUP BY ...] [HAVING ...] [ORDER BY ...] [LIMIT ...]")
+}
+
+// parser is a recursive-descent parser over the tokens of one statement.
+// File paths and bare comparison values aren't tokens: they are read from
+// the input where the current token starts.
+type parser struct {
+	input string
+	tok   token // Current token, not yet consumed
+	// outputs is set in HAVING and ORDER BY, whose aggregate calls name
+	// the output columns holding their results
+	outputs bool
+}
 
-	columnsPart := strings.TrimSpace(matches[1])
-	filePart := trimQuotes(strings.TrimSpace(matches[2]))
-	wherePart := strings.TrimSpace(matches[3])
-	groupByPart := strings.TrimSpace(matches[4])
-	limitPart := strings.TrimSpace(matches[5])
+func (p *parser) advance() {
+	p.tok = lex(p.input, p.tok.end)
+}
 
-	q := Query{FilePath: filePart, Limit: -1}
+// peek returns the token after the current one
+func (p *parser) peek() token {
+	return lex(p.input, p.tok.end)
+}
 
-	if q.FilePath == "" {
-		return Query{}, fmt.Errorf("missing file path in FROM clause")
-	}
+// isKeyword reports whether the current token is the keyword kw
+func (p *parser) isKeyword(kw string) bool {
+	return p.tok.kind == tokWord && strings.EqualFold(p.tok.text, kw)
+}
 
-	if columnsPart == "*" {
-		q.AllColumns = true
-	} else {
-		cols := strings.Split(columnsPart, ",")
-		for _, col := range cols {
-			cleaned := strings.TrimSpace(col)
-			if cleaned == "" {
-				return Query{}, fmt.Errorf("empty column name in SELECT clause")
-			}
-			q.Columns = append(q.Columns, cleaned)
-		}
+// acceptKeyword consumes the current token if it is the keyword kw
+func (p *parser) acceptKeyword(kw string) bool {
+	if !p.isKeyword(kw) {
+		return false
 	}
+	p.advance()
+	return true
+}
 
-	if wherePart != "" {
-		expr, err := parseExpression(wherePart)
-		if err != nil {
-			return Query{}, err
-		}
-		q.Where = expr
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.unexpected(kw)
 	}
+	return nil
+}
 
-	if groupByPart != "" {
-		cols := strings.Split(groupByPart, ",")
-		for _, col := range cols {
-			cleaned := strings.TrimSpace(col)
-			if cleaned == "" {
-				return Query{}, fmt.Errorf("empty column name in GROUP BY clause")
-			}
-			q.GroupBy = append(q.GroupBy, cleaned)
-		}
+func (p *parser) isSymbol(s string) bool {
+	return p.tok.kind == tokSymbol && p.tok.text == s
+}
+
+// acceptSymbol consumes the current token if it is the symbol s
+func (p *parser) acceptSymbol(s string) bool {
+	if !p.isSymbol(s) {
+		return false
 	}
+	p.advance()
+	return true
+}
 
-	if limitPart != "" {
-		limit, err := strconv.Atoi(limitPart)
-		if err != nil || limit < 0 {
-			return Query{}, fmt.Errorf("invalid LIMIT value: %s", limitPart)
-		}
-		q.Limit = limit
+func (p *parser) expectSymbol(s string) error {
+	if !p.acceptSymbol(s) {
+		return p.unexpected(fmt.Sprintf("%q", s))
 	}
+	return nil
+}
 
-	return q, nil
+// errorf returns a SyntaxError at the current token
+func (p *parser) errorf(format string, args ...any) error {
+	return p.errorAt(p.tok, format, args...)
+}
+
+// errorAt returns a SyntaxError at tok
+func (p *parser) errorAt(tok token, format string, args ...any) error {
+	return newSyntaxError(p.input, tok, fmt.Sprintf(format, args...))
 }
 
-// parseExpression parses OR expressions (lowest precedence)
-func parseExpression(input string) (Expression, error) {
-	return parseOrExpr(input)
+// unexpected reports that the current token isn't the wanted one
+func (p *parser) unexpected(want string) error {
+	return p.unexpectedAt(p.tok, want)
 }
 
-// parseOrExpr handles OR operations
-func parseOrExpr(input string) (Expression, error) {
-	// Split on OR (case insensitive, outside parentheses)
-	parts := splitOnOperator(input, "OR")
-	if len(parts) == 1 {
-		return parseAndExpr(parts[0])
+// unexpectedAt reports that tok isn't the wanted token, suggesting a
+// keyword if tok looks like a misspelled one
+func (p *parser) unexpectedAt(tok token, want string) error {
+	switch tok.kind {
+	case tokError:
+		return p.errorAt(tok, "%s", tok.text)
+	case tokEOF:
+		return p.errorAt(tok, "expected %s, found end of query", want)
+	}
+	err := newSyntaxError(p.input, tok, fmt.Sprintf("expected %s, found %q", want, p.input[tok.pos:tok.end]))
+	if tok.kind == tokWord {
+		if kw := suggestKeyword(tok.text); kw != "" {
+			err.Hint = fmt.Sprintf("did you mean %s?", kw)
+		}
 	}
+	return err
+}
 
-	left, err := parseAndExpr(parts[0])
+// createTable parses CREATE TABLE file AS SELECT ...
+func (p *parser) createTable() (Query, error) {
+	p.advance()
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return Query{}, err
+	}
+	path, err := p.path("CREATE TABLE")
 	if err != nil {
-		return nil, err
+		return Query{}, err
 	}
+	if err := p.expectKeyword("AS"); err != nil {
+		return Query{}, err
+	}
+	if !p.isKeyword("SELECT") {
+		return Query{}, p.unexpected("SELECT")
+	}
+	q, err := p.selectStatement()
+	if err != nil {
+		return Query{}, err
+	}
+	if q.OutputPath != "" {
+		return Query{}, fmt.Errorf("CREATE TABLE ... AS SELECT cannot also use INTO")
+	}
+	q.OutputPath = path
+	q.CreateTable = true
+	return q, nil
+}
+
+// selectStatement parses SELECT ... [INTO file] FROM file [SAMPLE ...]
+// [WHERE ...] [GROUP BY ...] [HAVING ...] [ORDER BY ...] [LIMIT n], up to
+// the end of the input
+func (p *parser) selectStatement() (Query, error) {
+	p.advance()
+	q := Query{Limit: -1}
 
-	for i := 1; i < len(parts); i++ {
-		right, err := parseAndExpr(parts[i])
+	items, err := p.list("SELECT", "INTO", "FROM")
+	if err != nil {
+		return Query{}, err
+	}
+	for i, item := range items {
+		sel, err := p.selectItem(item)
 		if err != nil {
-			return nil, err
+			return Query{}, err
 		}
-		left = BinaryExpr{Left: left, Operator: "OR", Right: right}
+		q.Columns = append(q.Columns, sel.text)
+		if sel.expr != nil {
+			if q.Exprs == nil {
+				q.Exprs = make([]Scalar, len(items))
+			}
+			q.Exprs[i] = sel.expr
+		}
+		if sel.alias != "" {
+			if q.Aliases == nil {
+				q.Aliases = make([]string, len(items))
+			}
+			q.Aliases[i] = sel.alias
+		}
+		if sel.filter != nil {
+			if q.Filters == nil {
+				q.Filters = make([]Expression, len(items))
+			}
+			q.Filters[i] = sel.filter
+		}
+	}
+	if len(q.Columns) == 1 && q.Columns[0] == "*" && q.Aliases == nil {
+		q.AllColumns = true
+		q.Columns = nil
 	}
 
-	return left, nil
-}
+	if p.acceptKeyword("INTO") {
+		if q.OutputPath, err = p.path("INTO"); err != nil {
+			return Query{}, err
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return Query{}, err
+	}
+	if q.FilePath, err = p.path("FROM"); err != nil {
+		return Query{}, err
+	}
 
-// parseAndExpr handles AND operations
-func parseAndExpr(input string) (Expression, error) {
-	parts := splitOnOperator(input, "AND")
-	if len(parts) == 1 {
-		return parseNotExpr(parts[0])
+	if p.isKeyword("SAMPLE") || p.isKeyword("TABLESAMPLE") {
+		if q.Sample, err = p.sample(); err != nil {
+			return Query{}, err
+		}
 	}
 
-	left, err := parseNotExpr(parts[0])
-	if err != nil {
-		return nil, err
+	if p.acceptKeyword("WHERE") {
+		if q.Where, err = p.orExpr(); err != nil {
+			return Query{}, err
+		}
 	}
 
-	for i := 1; i < len(parts); i++ {
-		right, err := parseNotExpr(parts[i])
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return Query{}, err
+		}
+		items, err := p.list("GROUP BY", "HAVING", "QUALIFY", "ORDER", "LIMIT")
 		if err != nil {
-			return nil, err
+			return Query{}, err
+		}
+		for _, item := range items {
+			q.GroupBy = append(q.GroupBy, p.itemText(item))
 		}
-		left = BinaryExpr{Left: left, Operator: "AND", Right: right}
 	}
 
-	return left, nil
-}
+	if p.acceptKeyword("HAVING") {
+		p.outputs = true
+		if q.Having, err = p.orExpr(); err != nil {
+			return Query{}, err
+		}
+		p.outputs = false
+	}
 
-// parseNotExpr handles NOT operations
-func parseNotExpr(input string) (Expression, error) {
-	input = strings.TrimSpace(input)
+	if p.isKeyword("QUALIFY") {
+		if len(q.GroupBy) > 0 {
+			return Query{}, p.errorf("QUALIFY can't be combined with GROUP BY")
+		}
+		p.advance()
+		if q.Top, err = p.qualify(); err != nil {
+			return Query{}, err
+		}
+	}
 
-	// Check for NOT prefix (with space or paren after)
-	inputUpper := strings.ToUpper(input)
-	if strings.HasPrefix(inputUpper, "NOT ") || strings.HasPrefix(inputUpper, "NOT(") {
-		var inner string
-		if strings.HasPrefix(inputUpper, "NOT ") {
-			inner = strings.TrimSpace(input[4:])
-		} else {
-			inner = strings.TrimSpace(input[3:])
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return Query{}, err
+		}
+		if q.OrderBy, err = p.orderBy(len(q.Columns)); err != nil {
+			return Query{}, err
 		}
-		expr, err := parseNotExpr(inner)
+	}
+
+	if p.isKeyword("LIMIT") {
+		p.advance()
+		start := p.tok
+		value, err := p.value()
 		if err != nil {
-			return nil, err
+			return Query{}, err
+		}
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return Query{}, p.errorAt(start, "invalid LIMIT value: %s", value)
 		}
-		return UnaryExpr{Operator: "NOT", Expr: expr}, nil
+		q.Limit = limit
 	}
 
-	return parsePrimary(input)
+	if p.isSymbol(";") {
+		err := newSyntaxError(p.input, p.tok, `expected end of query, found ";"`)
+		err.Hint = "run several statements as a script with -f"
+		return Query{}, err
+	}
+	if p.tok.kind != tokEOF {
+		return Query{}, p.unexpected("end of query")
+	}
+	return q, nil
 }
 
-// parsePrimary handles parentheses and comparisons
-func parsePrimary(input string) (Expression, error) {
-	input = strings.TrimSpace(input)
-
-	// Handle parentheses - verify outer parens match
-	if strings.HasPrefix(input, "(") && strings.HasSuffix(input, ")") {
-		// Verify the opening ( at position 0 matches the final )
-		depth := 0
-		matchesOuter := true
-		for i := 0; i < len(input)-1; i++ {
-			if input[i] == '(' {
-				depth++
-			} else if input[i] == ')' {
-				depth--
-				if depth == 0 {
-					// Closed before the end, not outer parens
-					matchesOuter = false
-					break
-				}
+// orderBy parses the keys after ORDER BY, each an expression over the
+// output columns or the position of one of the columns, optionally
+// followed by ASC or DESC
+func (p *parser) orderBy(columns int) ([]OrderItem, error) {
+	p.outputs = true
+	defer func() { p.outputs = false }()
+	var items []OrderItem
+	for {
+		var item OrderItem
+		start := p.tok
+		expr, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := expr.(Literal); ok {
+			n, err := strconv.Atoi(lit.Value)
+			if !lit.IsNumber || err != nil || n < 1 || n > columns {
+				return nil, p.errorAt(start, "ORDER BY position must be between 1 and %d", columns)
 			}
+			item.Position = n
+		} else {
+			item.Expr = expr
+		}
+		if p.acceptKeyword("DESC") {
+			item.Desc = true
+		} else {
+			p.acceptKeyword("ASC")
 		}
-		if matchesOuter && depth == 1 {
-			// Valid outer parentheses, strip them
-			inner := input[1 : len(input)-1]
-			return parseExpression(inner)
+		items = append(items, item)
+		if !p.acceptSymbol(",") {
+			return items, nil
 		}
 	}
-
-	// Parse as comparison
-	return parseComparison(input)
 }
 
-// parseComparison parses a single column comparison
-func parseComparison(input string) (Comparison, error) {
-	matches := predicateRe.FindStringSubmatch(input)
-	if len(matches) == 0 {
-		return Comparison{}, fmt.Errorf("unsupported WHERE clause; expected column OP value")
+// qualify parses the QUALIFY condition after the keyword. Only a row
+// number limit is supported:
+//
+//	ROW_NUMBER() OVER ([PARTITION BY col, ...] ORDER BY expr [ASC|DESC]) <= n
+//
+// with < n and = 1 also accepted.
+func (p *parser) qualify() (*TopN, error) {
+	if !p.isKeyword("ROW_NUMBER") {
+		return nil, p.errorf("QUALIFY supports only ROW_NUMBER() OVER (...) <= n")
+	}
+	p.advance()
+	for _, sym := range []string{"(", ")"} {
+		if err := p.expectSymbol(sym); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("OVER"); err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
 	}
 
-	column := matches[1]
-	operator := matches[2]
-	value := strings.TrimSpace(matches[3])
-	value = trimQuotes(value)
-
-	comp := Comparison{Column: column, Operator: operator, Value: value}
-
-	if numeric, err := strconv.ParseFloat(value, 64); err == nil {
-		comp.IsNumeric = true
-		comp.NumericValue = numeric
+	top := &TopN{}
+	if p.acceptKeyword("PARTITION") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			col, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			top.PartitionBy = append(top.PartitionBy, col)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectKeyword("ORDER"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("BY"); err != nil {
+		return nil, err
+	}
+	var err error
+	if top.OrderBy, err = p.additive(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("DESC") {
+		top.Desc = true
+	} else {
+		p.acceptKeyword("ASC")
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
 	}
 
-	return comp, nil
+	operator := p.tok
+	if !p.acceptSymbol("<=") && !p.acceptSymbol("<") && !p.acceptSymbol("=") {
+		return nil, p.unexpected("<=, <, or =")
+	}
+	start := p.tok
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return nil, p.errorAt(start, "invalid row number: %s", value)
+	}
+	switch operator.text {
+	case "<":
+		n--
+	case "=":
+		if n != 1 {
+			return nil, p.errorAt(operator, "ROW_NUMBER() = n is only supported for n = 1; use <= n")
+		}
+	}
+	top.N = n
+	return top, nil
 }
 
-// splitOnOperator splits input on operator (AND/OR) respecting parentheses
-func splitOnOperator(input string, op string) []string {
-	input = strings.TrimSpace(input)
-	opUpper := strings.ToUpper(op)
-	opLen := len(op)
-
-	var parts []string
-	var current strings.Builder
-	parenDepth := 0
+// span is where a list item is in the input: input[pos:end]
+type span struct {
+	pos, end int
+}
 
-	i := 0
-	for i < len(input) {
-		// Track parentheses depth
-		if input[i] == '(' {
-			parenDepth++
-			current.WriteByte(input[i])
-			i++
-			continue
+// list reads the comma-separated items of a SELECT or GROUP BY clause up to
+// one of the stop keywords (outside parentheses) or the end of the input,
+// and returns where each one is
+func (p *parser) list(clause string, stops ...string) ([]span, error) {
+	var items []span
+	start, prevEnd, depth := 0, -1, 0 // prevEnd is -1 before an item's first token
+	// A word after the start of an item that looks like a misspelled stop
+	// keyword (SELECT a FORM t) is reported if no stop keyword follows
+	var suspect token
+	suspectStop := ""
+	for {
+		atEnd := p.tok.kind == tokEOF
+		for _, stop := range stops {
+			atEnd = atEnd || depth == 0 && p.isKeyword(stop)
 		}
-		if input[i] == ')' {
-			parenDepth--
-			current.WriteByte(input[i])
-			i++
+		if atEnd && p.tok.kind == tokEOF && suspectStop != "" {
+			return nil, p.unexpectedAt(suspect, suspectStop)
+		}
+		if atEnd || depth == 0 && p.isSymbol(",") {
+			if prevEnd < 0 {
+				return nil, p.errorf("empty column name in %s clause", clause)
+			}
+			items = append(items, span{start, prevEnd})
+			if atEnd {
+				return items, nil
+			}
+			p.advance()
+			prevEnd = -1
 			continue
 		}
 
-		// Check if we're at the operator (outside parentheses)
-		if parenDepth == 0 && i+opLen <= len(input) {
-			substr := strings.ToUpper(input[i : i+opLen])
-			// Ensure it's a word boundary (whitespace, paren, or start/end)
-			beforeOk := i == 0 || isWordBoundary(input[i-1])
-			afterOk := i+opLen >= len(input) || isWordBoundary(input[i+opLen])
-
-			if substr == opUpper && beforeOk && afterOk {
-				// Found operator, save current part
-				parts = append(parts, current.String())
-				current.Reset()
-				i += opLen
-				// Skip trailing spaces
-				for i < len(input) && input[i] == ' ' {
-					i++
+		if p.tok.kind == tokWord && depth == 0 && prevEnd >= 0 && suspectStop == "" {
+			for _, stop := range stops {
+				if suggestKeyword(p.tok.text) == stop {
+					suspect, suspectStop = p.tok, stop
 				}
-				continue
 			}
 		}
 
-		current.WriteByte(input[i])
-		i++
-	}
+		switch {
+		case p.tok.kind == tokError:
+			return nil, p.unexpected("column name")
+		case p.isSymbol("("):
+			depth++
+		case p.isSymbol(")"):
+			if depth == 0 {
+				return nil, p.unexpected("column name")
+			}
+			depth--
+		}
 
-	// Add final part
-	if current.Len() > 0 {
-		parts = append(parts, current.String())
+		if prevEnd < 0 {
+			start = p.tok.pos
+		}
+		prevEnd = p.tok.end
+		p.advance()
 	}
+}
 
-	if len(parts) == 0 {
-		return []string{input}
+// itemText returns a list item as written, except that quoted identifiers
+// lose their quotes and comments become spaces, so COUNT(*) stays one item
+// and "Order Date" becomes Order Date
+func (p *parser) itemText(item span) string {
+	var b strings.Builder
+	for tok := lex(p.input, item.pos); tok.kind != tokEOF && tok.pos < item.end; {
+		if isQuotedName(p.input, tok) {
+			b.WriteString(tok.text)
+		} else {
+			b.WriteString(p.input[tok.pos:tok.end])
+		}
+		next := lex(p.input, tok.end)
+		if next.pos < item.end {
+			gap := p.input[tok.end:next.pos]
+			if strings.TrimLeft(gap, " \t\r\n") != "" {
+				gap = " " // A comment
+			}
+			b.WriteString(gap)
+		}
+		tok = next
 	}
+	return b.String()
+}
 
-	return parts
+// sub returns a parser for a list item alone
+func (p *parser) sub(item span) *parser {
+	sub := &parser{input: p.input[:item.end]}
+	sub.tok = lex(sub.input, item.pos)
+	return sub
 }
 
-func trimQuotes(input string) string {
-	if len(input) >= 2 {
-		if (input[0] == '\'' && input[len(input)-1] == '\'') || (input[0] == '"' && input[len(input)-1] == '"') {
-			return input[1 : len(input)-1]
-		}
-	}
-	return input
+// selection is a parsed SELECT item
+type selection struct {
+	text   string
+	expr   Scalar
+	alias  string
+	filter Expression
 }
 
-// Evaluate evaluates an expression tree against a row (map of column -> value)
-func Evaluate(expr Expression, row map[string]string) bool {
-	switch e := expr.(type) {
-	case BinaryExpr:
-		switch e.Operator {
-		case "AND":
-			// Short-circuit: if left is false, return false without evaluating right
-			if !Evaluate(e.Left, row) {
-				return false
+// selectItem reads a SELECT item: an expression or an aggregate, optionally
+// followed by FILTER (WHERE ...) for an aggregate and AS name. A plain
+// column comes back as its name with a nil expression; so do aggregates,
+// left for the engine, and items that don't parse as expressions but have
+// no operators either, such as unquoted names with dots or non-ASCII
+// letters. An aggregate with FILTER and no AS is named by its full text.
+func (p *parser) selectItem(item span) (selection, error) {
+	// FILTER (WHERE ...) and AS name at the end of the item are cut from it
+	var sel selection
+	var filterTok token
+	full := item
+	sub := p.sub(item)
+	for depth, prevEnd := 0, item.pos; sub.tok.kind != tokEOF; {
+		switch {
+		case sub.isSymbol("("):
+			depth++
+		case sub.isSymbol(")"):
+			depth--
+		case depth == 0 && sub.isKeyword("AS") && sub.tok.pos > item.pos:
+			full.end = min(full.end, prevEnd)
+			sub.advance()
+			var err error
+			if sel.alias, err = sub.column(); err != nil {
+				return selection{}, err
+			}
+			if sub.tok.kind != tokEOF {
+				return selection{}, sub.unexpected("\",\" or FROM")
+			}
+			item.end = min(item.end, prevEnd)
+			continue
+		case depth == 0 && sub.isKeyword("FILTER") && sub.peek().text == "(" && sub.tok.pos > item.pos && sel.filter == nil:
+			filterTok = sub.tok
+			sub.advance()
+			sub.advance()
+			if err := sub.expectKeyword("WHERE"); err != nil {
+				return selection{}, err
 			}
-			return Evaluate(e.Right, row)
-		case "OR":
-			// Short-circuit: if left is true, return true without evaluating right
-			if Evaluate(e.Left, row) {
-				return true
+			var err error
+			if sel.filter, err = sub.orExpr(); err != nil {
+				return selection{}, err
 			}
-			return Evaluate(e.Right, row)
+			if err := sub.expectSymbol(")"); err != nil {
+				return selection{}, err
+			}
+			item.end, prevEnd = prevEnd, sub.tok.pos
+			continue
 		}
-		return false
+		prevEnd = sub.tok.end
+		sub.advance()
+	}
 
-	case UnaryExpr:
-		if e.Operator == "NOT" {
-			return !Evaluate(e.Expr, row)
+	sel.text = p.itemText(item)
+	sub = p.sub(item)
+	aggregate := sub.tok.kind == tokWord && isAggregate(sub.tok.text) && sub.peek().text == "("
+	if sel.filter != nil {
+		if !aggregate {
+			return selection{}, p.errorAt(filterTok, "FILTER applies only to aggregates")
 		}
-		return false
-
-	case Comparison:
-		value, exists := row[e.Column]
-		if !exists {
-			return false
+		if sel.alias == "" {
+			sel.alias = strings.Join(strings.Fields(p.itemText(full)), " ")
 		}
-		return e.Compare(value)
+	}
+	if sel.text == "*" {
+		return sel, nil
+	}
 
+	// An aggregate keeps its text; expr is its argument, if computed
+	arg := sel.text
+	if aggregate {
+		sub.advance()
+		sub.advance()
+		if sub.isSymbol("*") {
+			return sel, nil
+		}
+		_, arg, _ = strings.Cut(sel.text, "(")
+		arg = strings.TrimSuffix(arg, ")")
+	}
+	expr, err := sub.additive()
+	if err == nil && aggregate {
+		err = sub.expectSymbol(")")
+	}
+	if err == nil && sub.tok.kind != tokEOF {
+		err = sub.unexpected("\",\" or FROM")
+	}
+	switch {
+	case err != nil && strings.ContainsAny(arg, "+-*/%()"):
+		return selection{}, err
+	case err != nil:
+		return sel, nil
+	}
+	col, plain := expr.(ColumnRef)
+	switch {
+	case plain && aggregate:
+	case plain:
+		sel.text = col.Name
 	default:
-		return false
+		sel.text, sel.expr = strings.Join(strings.Fields(sel.text), " "), expr
 	}
+	return sel, nil
 }
 
-// EvaluateNormalized evaluates expression with normalized (lowercase) column names
-func EvaluateNormalized(expr Expression, row map[string]string) bool {
-	switch e := expr.(type) {
-	case *BinaryExpr:
-		switch e.Operator {
-		case "AND":
-			if !EvaluateNormalized(e.Left, row) {
-				return false
-			}
-			return EvaluateNormalized(e.Right, row)
-		case "OR":
-			if EvaluateNormalized(e.Left, row) {
-				return true
-			}
-			return EvaluateNormalized(e.Right, row)
-		}
-		return false
+// isAggregate reports whether name is an aggregate function
+func isAggregate(name string) bool {
+	switch strings.ToUpper(name) {
+	case "COUNT", "SUM", "AVG", "MIN", "MAX":
+		return true
+	}
+	return false
+}
 
-	case BinaryExpr:
-		switch e.Operator {
-		case "AND":
-			if !EvaluateNormalized(e.Left, row) {
-				return false
+// isIdentifier reports whether the current token is a quoted identifier:
+// "Order Date", `Order Date`, or [Order Date]. In value position a
+// double-quoted token is a string literal instead.
+func (p *parser) isIdentifier() bool {
+	return isQuotedName(p.input, p.tok)
+}
+
+// isQuotedName reports whether tok is a quoted identifier
+func isQuotedName(input string, tok token) bool {
+	return tok.kind == tokIdent || tok.kind == tokString && input[tok.pos] == '"'
+}
+
+// column reads a column name, bare or quoted
+func (p *parser) column() (string, error) {
+	if p.tok.kind != tokWord && !p.isIdentifier() {
+		return "", p.unexpected("column name")
+	}
+	name := p.tok.text
+	p.advance()
+	return name, nil
+}
+
+// path reads the file path starting at the current token (see readPath)
+// and expands it
+func (p *parser) path(clause string) (string, error) {
+	if p.tok.kind == tokEOF {
+		return "", p.errorf("missing file path in %s clause", clause)
+	}
+	path, rest, err := readPath(p.input[p.tok.pos:])
+	if err != nil {
+		return "", p.errorf("%v in %s clause", err, clause)
+	}
+	p.tok = lex(p.input, len(p.input)-len(rest))
+	return ExpandPath(path), nil
+}
+
+// value reads a comparison literal: a quoted string, or a bare value such
+// as 42, -1.5, or 2024-01-31, which runs to the next space, parenthesis,
+// semicolon, or comment
+func (p *parser) value() (string, error) {
+	switch {
+	case p.tok.kind == tokString:
+		value := p.tok.text
+		p.advance()
+		return value, nil
+	case p.tok.kind == tokEOF || p.tok.kind == tokError || p.isSymbol("(") || p.isSymbol(")"):
+		return "", p.unexpected("value")
+	}
+	end := p.bareEnd(p.tok.pos)
+	value := p.input[p.tok.pos:end]
+	p.tok = lex(p.input, end)
+	return value, nil
+}
+
+// bareEnd returns where a bare value starting at pos ends
+func (p *parser) bareEnd(pos int) int {
+	end := pos
+	for end < len(p.input) && !isSpace(p.input[end]) && !strings.ContainsRune("();", rune(p.input[end])) &&
+		!strings.HasPrefix(p.input[end:], "--") && !strings.HasPrefix(p.input[end:], "/*") {
+		end++
+	}
+	return end
+}
+
+// sample parses SAMPLE p%, SAMPLE n ROWS, or TABLESAMPLE BERNOULLI (p),
+// each optionally followed by REPEATABLE (seed)
+func (p *parser) sample() (*Sample, error) {
+	sample := &Sample{}
+	if p.acceptKeyword("TABLESAMPLE") {
+		if err := p.expectKeyword("BERNOULLI"); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		if !isNumber(p.tok) {
+			return nil, p.unexpected("sample percentage")
+		}
+		percent, err := p.samplePercent(p.tok)
+		if err != nil {
+			return nil, err
+		}
+		p.advance()
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		sample.Percent = percent
+	} else {
+		p.advance()
+		size := p.tok
+		if !isNumber(size) {
+			return nil, p.unexpected("sample size")
+		}
+		p.advance()
+		switch {
+		case p.acceptKeyword("ROWS"):
+			rows, err := strconv.Atoi(size.text)
+			if err != nil {
+				return nil, p.errorAt(size, "invalid sample size: %s", size.text)
 			}
-			return EvaluateNormalized(e.Right, row)
-		case "OR":
-			if EvaluateNormalized(e.Left, row) {
-				return true
+			sample.Rows = rows
+			sample.Reservoir = true
+		case p.acceptSymbol("%") || p.acceptKeyword("PERCENT"):
+			percent, err := p.samplePercent(size)
+			if err != nil {
+				return nil, err
 			}
-			return EvaluateNormalized(e.Right, row)
+			sample.Percent = percent
+		default:
+			return nil, p.unexpected("%, PERCENT, or ROWS")
 		}
-		return false
+	}
 
-	case *UnaryExpr:
-		if e.Operator == "NOT" {
-			return !EvaluateNormalized(e.Expr, row)
+	if p.acceptKeyword("REPEATABLE") {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
 		}
-		return false
-
-	case UnaryExpr:
-		if e.Operator == "NOT" {
-			return !EvaluateNormalized(e.Expr, row)
+		seed, err := strconv.ParseInt(p.tok.text, 10, 64)
+		if err != nil || p.tok.kind != tokWord {
+			return nil, p.unexpected("REPEATABLE seed")
 		}
-		return false
-
-	case Comparison:
-		// Normalize column name for lookup
-		normalized := strings.ToLower(strings.TrimSpace(e.Column))
-		value, exists := row[normalized]
-		if !exists {
-			return false
+		p.advance()
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
 		}
-		return e.Compare(value)
+		sample.Seed = seed
+		sample.Repeatable = true
+	}
+	return sample, nil
+}
 
-	default:
-		return false
+// samplePercent reads the percentage of a sample from a number token
+func (p *parser) samplePercent(tok token) (float64, error) {
+	percent, err := strconv.ParseFloat(tok.text, 64)
+	if err != nil || percent > 100 {
+		return 0, p.errorAt(tok, "invalid sample percentage: %s (want 0 to 100)", tok.text)
 	}
+	return percent, nil
+}
+
+// isNumber reports whether tok is an unsigned number
+func isNumber(tok token) bool {
+	return tok.kind == tokWord && tok.text[0] >= '0' && tok.text[0] <= '9'
+}
+
+// orExpr parses OR expressions (lowest precedence)
+func (p *parser) orExpr() (Expression, error) {
+	left, err := p.andExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.andExpr()
+		if err != nil {
+			return nil, err
+		}
+		left = BinaryExpr{Left: left, Operator: "OR", Right: right}
+	}
+	return left, nil
+}
+
+// andExpr parses AND operations
+func (p *parser) andExpr() (Expression, error) {
+	left, err := p.notExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		left = BinaryExpr{Left: left, Operator: "AND", Right: right}
+	}
+	return left, nil
+}
+
+// notExpr parses NOT operations
+func (p *parser) notExpr() (Expression, error) {
+	if !p.acceptKeyword("NOT") {
+		return p.primary()
+	}
+	expr, err := p.notExpr()
+	if err != nil {
+		return nil, err
+	}
+	return UnaryExpr{Operator: "NOT", Expr: expr}, nil
+}
+
+// primary parses a parenthesized expression or a predicate. Parentheses
+// followed by an operator, as in (price - discount) * qty > 100, group
+// arithmetic in a predicate instead.
+func (p *parser) primary() (Expression, error) {
+	if !p.isSymbol("(") || p.groupsOperand() {
+		return p.predicate()
+	}
+	p.advance()
+	expr, err := p.orExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// groupsOperand reports whether the parenthesized group at the current
+// token is followed by an arithmetic or comparison operator
+func (p *parser) groupsOperand() bool {
+	depth := 0
+	for tok := p.tok; tok.kind != tokEOF && tok.kind != tokError; tok = lex(p.input, tok.end) {
+		if tok.kind != tokSymbol {
+			continue
+		}
+		switch tok.text {
+		case "(":
+			depth++
+		case ")":
+			if depth--; depth == 0 {
+				next := lex(p.input, tok.end)
+				if next.kind == tokWord {
+					return strings.EqualFold(next.text, "BETWEEN") || strings.EqualFold(next.text, "NOT") && strings.EqualFold(lex(p.input, next.end).text, "BETWEEN")
+				}
+				if next.kind != tokSymbol {
+					return false
+				}
+				switch next.text {
+				case "+", "-", "*", "/", "%", "<=>", "=~", "!=", ">=", "<=", "=", ">", "<":
+					return true
+				}
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// predicate parses a comparison, BETWEEN range, IN list, or boolean column
+// test.
+// The left side is a column, CAST(column AS type), or an expression; the
+// right side a literal, a column, or an expression.
+func (p *parser) predicate() (Expression, error) {
+	start := p.tok
+	var castType, column string
+	var left Scalar // Set instead of column when the left side is computed
+	if p.isKeyword("EXISTS") && p.peek().text == "(" {
+		return nil, p.errorf("EXISTS subqueries aren't supported; use [NOT] IN (SELECT ...)")
+	}
+	if p.isKeyword("CAST") && p.peek().text == "(" {
+		p.advance()
+		p.advance()
+		var err error
+		if column, err = p.column(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AS"); err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokWord {
+			return nil, p.unexpected("type name")
+		}
+		castType = p.tok.text
+		p.advance()
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	} else {
+		expr, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		if col, ok := expr.(ColumnRef); ok {
+			column = col.Name
+		} else {
+			left = expr
+		}
+	}
+	// Type and literal errors point at the start of the predicate
+	located := func(err error) error {
+		return p.errorAt(start, "%v", err)
+	}
+
+	// col BETWEEN a AND b is col >= a AND col <= b
+	not := p.isKeyword("NOT") && (strings.EqualFold(p.peek().text, "BETWEEN") || strings.EqualFold(p.peek().text, "IN"))
+	if not {
+		p.advance()
+	}
+	if p.isKeyword("IN") {
+		if castType != "" || left != nil {
+			return nil, p.errorAt(start, "IN needs a column on the left")
+		}
+		p.advance()
+		in, err := p.in(column)
+		if err != nil {
+			return nil, err
+		}
+		if not {
+			return UnaryExpr{Operator: "NOT", Expr: in}, nil
+		}
+		return in, nil
+	}
+	if p.acceptKeyword("BETWEEN") {
+		low, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		lowCmp, err := p.comparison(column, castType, left, ">=", low, nil)
+		if err != nil {
+			return nil, located(err)
+		}
+		highCmp, err := p.comparison(column, castType, left, "<=", high, nil)
+		if err != nil {
+			return nil, located(err)
+		}
+		var expr Expression = BinaryExpr{Left: lowCmp, Operator: "AND", Right: highCmp}
+		if not {
+			expr = UnaryExpr{Operator: "NOT", Expr: expr}
+		}
+		return expr, nil
+	}
+
+	var operator string
+	switch {
+	case p.isKeyword("ILIKE"):
+		operator = "ILIKE"
+	case p.tok.kind == tokSymbol:
+		switch p.tok.text {
+		case "<=>", "=~", "!=", ">=", "<=", "=", ">", "<":
+			operator = p.tok.text
+		}
+	}
+	if operator == "" {
+		// A bare column name tests a boolean column: WHERE active
+		if castType == "" && left == nil && p.atPredicateEnd() {
+			return Comparison{Column: column, Operator: "=", Value: "true", Type: TypeBoolean, BoolValue: true, IsBool: true}, nil
+		}
+		return nil, p.unexpected("comparison operator")
+	}
+	p.advance()
+
+	// Quoted strings, TRUE/FALSE, and bare values starting with a digit or
+	// sign (42, -1.5, 2024-01-31) are literals, which a number may follow
+	// with arithmetic (2 * price); anything else is a column or an
+	// expression. ILIKE patterns are always literals.
+	var value string
+	var right Scalar
+	var err error
+	if operator == "ILIKE" || !p.atOperand() {
+		if value, err = p.value(); err != nil {
+			return nil, err
+		}
+		if lit := newLiteral(value); operator != "ILIKE" && lit.IsNumber && p.atArithmetic() {
+			right, err = p.additiveFrom(lit)
+		}
+	} else {
+		right, err = p.additive()
+	}
+	if err != nil {
+		return nil, err
+	}
+	comp, err := p.comparison(column, castType, left, operator, value, right)
+	if err != nil {
+		return nil, located(err)
+	}
+	return comp, nil
+}
+
+// in parses the list after IN: literal values, or a subquery selecting
+// one column
+func (p *parser) in(column string) (Expression, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("SELECT") {
+		return p.subquery(column)
+	}
+	in := InExpr{Column: column}
+	for {
+		value, err := p.listValue()
+		if err != nil {
+			return nil, err
+		}
+		in.Values = append(in.Values, value)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// subquery parses the SELECT at the current token up to its closing
+// parenthesis, which it consumes
+func (p *parser) subquery(column string) (Expression, error) {
+	start := p.tok
+	depth := 0
+	end := p.tok
+	for ; ; end = lex(p.input, end.end) {
+		if end.kind == tokEOF || end.kind == tokError {
+			return nil, p.unexpectedAt(end, `")"`)
+		}
+		if end.kind != tokSymbol {
+			continue
+		}
+		if end.text == "(" {
+			depth++
+		} else if end.text == ")" {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+	}
+
+	if p.outputs {
+		return nil, p.errorAt(start, "IN (SELECT ...) isn't supported in HAVING")
+	}
+	sub := &parser{input: p.input[:end.pos], tok: start}
+	q, err := sub.selectStatement()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case q.AllColumns || len(q.Columns) != 1:
+		return nil, p.errorAt(start, "IN (SELECT ...) must select exactly one column")
+	case q.OutputPath != "":
+		return nil, p.errorAt(start, "IN (SELECT ...) can't use INTO")
+	}
+	p.tok = lex(p.input, end.end)
+	return InExpr{Column: column, Subquery: &q}, nil
+}
+
+// listValue reads a value in an IN list, where a comma also ends a bare value
+func (p *parser) listValue() (string, error) {
+	if p.tok.kind == tokString || p.tok.kind == tokEOF || p.tok.kind == tokError {
+		return p.value()
+	}
+	end := p.bareEnd(p.tok.pos)
+	if comma := strings.IndexByte(p.input[p.tok.pos:end], ','); comma >= 0 {
+		end = p.tok.pos + comma
+	}
+	if end == p.tok.pos {
+		return "", p.unexpected("value")
+	}
+	value := p.input[p.tok.pos:end]
+	p.tok = lex(p.input, end)
+	return value, nil
+}
+
+// comparison builds a predicate from its parsed sides: column (cast to
+// castType, if given) or a computed left side, and a literal value or a
+// right side expression
+func (p *parser) comparison(column, castType string, left Scalar, operator, value string, right Scalar) (Expression, error) {
+	col, columns := right.(ColumnRef)
+	columns = columns && left == nil
+	if operator == "<=>" && !columns {
+		return nil, fmt.Errorf("<=> compares two columns; use = '' to find empty values")
+	}
+	if left == nil && right == nil {
+		return newComparison(column, castType, operator, value)
+	}
+	if columns {
+		return newColumnComparison(column, castType, operator, col.Name)
+	}
+	switch {
+	case castType != "":
+		return nil, fmt.Errorf("CAST only compares with a literal or a column")
+	case operator == "ILIKE":
+		return nil, fmt.Errorf("ILIKE needs a column on the left")
+	}
+	if left == nil {
+		left = ColumnRef{Name: column}
+	}
+	if right == nil {
+		right = newLiteral(value)
+	}
+	comp := ExprComparison{Left: left, Operator: operator, Right: right}
+	if operator == "=~" {
+		comp.Operator = "="
+		comp.CaseInsensitive = true
+	}
+	return comp, nil
+}
+
+// atOperand reports whether the current token starts a column or an
+// expression in value position (see predicate)
+func (p *parser) atOperand() bool {
+	switch p.tok.kind {
+	case tokIdent:
+		return true
+	case tokWord:
+		c := p.tok.text[0]
+		return !(c >= '0' && c <= '9') && !p.isKeyword("TRUE") && !p.isKeyword("FALSE")
+	}
+	return p.isSymbol("(")
+}
+
+// atArithmetic reports whether the current token is an arithmetic operator
+func (p *parser) atArithmetic() bool {
+	return p.isSymbol("+") || p.isSymbol("-") || p.isSymbol("*") || p.isSymbol("/") || p.isSymbol("%")
+}
+
+// additive parses + and -, the lowest arithmetic precedence
+func (p *parser) additive() (Scalar, error) {
+	return p.additiveFrom(nil)
+}
+
+// additiveFrom is additive with the first operand already read, unless
+// first is nil
+func (p *parser) additiveFrom(first Scalar) (Scalar, error) {
+	left, err := p.multiplicative(first)
+	if err != nil {
+		return nil, err
+	}
+	for p.isSymbol("+") || p.isSymbol("-") {
+		operator := p.tok.text
+		p.advance()
+		right, err := p.multiplicative(nil)
+		if err != nil {
+			return nil, err
+		}
+		left = Arithmetic{Left: left, Operator: operator, Right: right}
+	}
+	return left, nil
+}
+
+// multiplicative parses *, /, and %, starting from first unless it is nil
+func (p *parser) multiplicative(first Scalar) (Scalar, error) {
+	left := first
+	if left == nil {
+		var err error
+		if left, err = p.unary(); err != nil {
+			return nil, err
+		}
+	}
+	for p.isSymbol("*") || p.isSymbol("/") || p.isSymbol("%") {
+		operator := p.tok.text
+		p.advance()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = Arithmetic{Left: left, Operator: operator, Right: right}
+	}
+	return left, nil
+}
+
+// unary parses a signed operand
+func (p *parser) unary() (Scalar, error) {
+	switch {
+	case p.acceptSymbol("-"):
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := operand.(Literal); ok && lit.IsNumber {
+			return Literal{Value: "-" + lit.Value, Number: -lit.Number, IsNumber: true}, nil
+		}
+		return Arithmetic{Left: Literal{Value: "0", IsNumber: true}, Operator: "-", Right: operand}, nil
+	case p.acceptSymbol("+"):
+		return p.unary()
+	}
+	return p.operand()
+}
+
+// operand parses a number, a 'string', a column, a function call, or a
+// parenthesized expression
+func (p *parser) operand() (Scalar, error) {
+	switch {
+	case p.acceptSymbol("("):
+		expr, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case p.tok.kind == tokString && !p.isIdentifier():
+		lit := Literal{Value: p.tok.text}
+		p.advance()
+		return lit, nil
+	case p.tok.kind == tokWord && p.tok.text[0] >= '0' && p.tok.text[0] <= '9':
+		lit := newLiteral(p.tok.text)
+		if !lit.IsNumber {
+			return nil, p.errorf("invalid number %q", p.tok.text)
+		}
+		p.advance()
+		return lit, nil
+	case p.outputs && p.tok.kind == tokWord && isAggregate(p.tok.text) && p.peek().text == "(":
+		return p.aggregateRef()
+	case p.tok.kind == tokWord && p.peek().kind == tokSymbol && p.peek().text == "(":
+		return p.call()
+	}
+	name, err := p.column()
+	if err != nil {
+		return nil, err
+	}
+	return ColumnRef{Name: name}, nil
+}
+
+// aggregateRef reads an aggregate call in HAVING or ORDER BY as the
+// output column holding its result, named FUNC(argument) with the
+// argument's spacing normalized
+func (p *parser) aggregateRef() (Scalar, error) {
+	name := strings.ToUpper(p.tok.text)
+	p.advance()
+	p.advance()
+	start, end, depth := p.tok.pos, p.tok.pos, 0
+	for depth > 0 || !p.isSymbol(")") {
+		switch {
+		case p.tok.kind == tokEOF || p.tok.kind == tokError:
+			return nil, p.unexpected(`")"`)
+		case p.isSymbol("("):
+			depth++
+		case p.isSymbol(")"):
+			depth--
+		}
+		end = p.tok.end
+		p.advance()
+	}
+	if end == start {
+		return nil, p.errorf("%s() needs an argument", name)
+	}
+	p.advance()
+	arg := strings.Join(strings.Fields(p.itemText(span{start, end})), " ")
+	return ColumnRef{Name: name + "(" + arg + ")"}, nil
+}
+
+// call parses a function call: a name from Functions and its arguments
+func (p *parser) call() (Scalar, error) {
+	nameTok := p.tok
+	name := strings.ToUpper(p.tok.text)
+	arity, ok := Functions[name]
+	if !ok {
+		return nil, p.errorf("unknown function %s", nameTok.text)
+	}
+	p.advance()
+	p.advance()
+	var args []Scalar
+	for !p.isSymbol(")") {
+		arg, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	if len(args) < arity[0] || len(args) > arity[1] {
+		want := fmt.Sprintf("%d argument", arity[0])
+		switch {
+		case arity[0] != arity[1]:
+			want = fmt.Sprintf("%d or %d arguments", arity[0], arity[1])
+		case arity[0] > 1:
+			want += "s"
+		}
+		return nil, p.errorAt(nameTok, "%s takes %s, got %d", name, want, len(args))
+	}
+	return FuncCall{Name: name, Args: args}, nil
+}
+
+// atPredicateEnd reports whether the current token can follow a predicate
+func (p *parser) atPredicateEnd() bool {
+	return p.tok.kind == tokEOF || p.isSymbol(")") ||
+		p.isKeyword("AND") || p.isKeyword("OR") || p.isKeyword("GROUP") || p.isKeyword("HAVING") ||
+		p.isKeyword("QUALIFY") || p.isKeyword("ORDER") || p.isKeyword("LIMIT")
+}
+
+// newColumnComparison builds a comparison of two columns, both cast to
+// castType if given
+func newColumnComparison(left, castType, operator, right string) (ColumnComparison, error) {
+	comp := ColumnComparison{Left: left, Operator: operator, Right: right}
+	switch operator {
+	case "=~":
+		comp.Operator = "="
+		comp.CaseInsensitive = true
+	case "<=>":
+		// Null-safe equality
+		comp.Operator = "="
+		comp.NullSafe = true
+	}
+	if castType == "" {
+		return comp, nil
+	}
+	t, err := ParseType(castType)
+	if err != nil {
+		return ColumnComparison{}, err
+	}
+	return comp.withType(t)
+}
+
+// newComparison builds a comparison of column (cast to castType, if given)
+// against a literal value
+func newComparison(column, castType, operator, value string) (Comparison, error) {
+	operator = strings.ToUpper(operator)
+	comp := Comparison{Column: column, Operator: operator, Value: value}
+
+	switch operator {
+	case "=~":
+		// Case-insensitive equality
+		comp.Operator = "="
+		comp.CaseInsensitive = true
+	case "ILIKE":
+		// Patterns always match as strings
+		comp.CaseInsensitive = true
+	}
+
+	if castType != "" {
+		t, err := ParseType(castType)
+		if err != nil {
+			return Comparison{}, err
+		}
+		return comp.withType(t)
+	}
+	if comp.Operator != "ILIKE" {
+		comp.inferType()
+	}
+	return comp, nil
+}
+
+// Evaluate evaluates an expression tree against a row (map of column -> value)
+func Evaluate(expr Expression, row map[string]string) bool {
+	if expr == nil {
+		return false
+	}
+	return VisitExpression[bool](expr, rowEvaluator{row: row})
+}
+
+// EvaluateNormalized evaluates expression with normalized (lowercase) column names
+func EvaluateNormalized(expr Expression, row map[string]string) bool {
+	if expr == nil {
+		return false
+	}
+	return VisitExpression[bool](expr, rowEvaluator{row: row, normalized: true})
+}
+
+// rowEvaluator evaluates an expression against a row map. A column missing
+// from the row never matches.
+type rowEvaluator struct {
+	row        map[string]string
+	normalized bool // Columns are looked up lower-cased and trimmed
+}
+
+func (r rowEvaluator) value(column string) (string, bool) {
+	if r.normalized {
+		column = strings.ToLower(strings.TrimSpace(column))
+	}
+	value, ok := r.row[column]
+	return value, ok
+}
+
+func (r rowEvaluator) eval(expr Expression) bool {
+	return VisitExpression[bool](expr, r)
+}
+
+func (r rowEvaluator) Binary(e BinaryExpr) bool {
+	switch e.Operator {
+	case "AND":
+		// Short-circuit: if left is false, return false without evaluating right
+		return r.eval(e.Left) && r.eval(e.Right)
+	case "OR":
+		// Short-circuit: if left is true, return true without evaluating right
+		return r.eval(e.Left) || r.eval(e.Right)
+	}
+	return false
+}
+
+func (r rowEvaluator) Unary(e UnaryExpr) bool {
+	return e.Operator == "NOT" && !r.eval(e.Expr)
+}
+
+func (r rowEvaluator) Comparison(e Comparison) bool {
+	value, ok := r.value(e.Column)
+	return ok && e.Compare(value)
+}
+
+func (r rowEvaluator) ColumnComparison(e ColumnComparison) bool {
+	left, ok := r.value(e.Left)
+	if !ok {
+		return false
+	}
+	right, ok := r.value(e.Right)
+	return ok && e.Compare(left, right)
+}
+
+func (r rowEvaluator) ExprComparison(e ExprComparison) bool {
+	return e.Compare(EvalScalar(e.Left, r.value, e.Numbers), EvalScalar(e.Right, r.value, e.Numbers))
+}
+
+func (r rowEvaluator) In(e InExpr) bool {
+	value, ok := r.value(e.Column)
+	return ok && e.Contains(value)
 }
 
 // Compare evaluates a comparison against the provided value.
 func (c Comparison) Compare(candidate string) bool {
 	if c.IsNumeric {
-		candidateNum, err := strconv.ParseFloat(candidate, 64)
-		if err != nil {
+		candidateNum, ok := c.Numbers.ParseFloat(candidate)
+		if !ok {
 			return false
 		}
 		switch c.Operator {
@@ -447,8 +1644,145 @@ func (c Comparison) Compare(candidate string) bool {
 		return false
 	}
 
-	cmp := strings.Compare(candidate, c.Value)
-	switch c.Operator {
+	if c.IsBool {
+		b, ok := datatype.ParseBool(candidate)
+		if !ok {
+			return false
+		}
+		if c.Operator == "!=" {
+			return b != c.BoolValue
+		}
+		return c.Operator == "=" && b == c.BoolValue
+	}
+
+	if c.IsTimestamp {
+		candidateTime, ok := datatype.ParseTimestamp(candidate)
+		if !ok {
+			return false
+		}
+		switch c.Operator {
+		case "=":
+			return candidateTime == c.TimeValue
+		case "!=":
+			return candidateTime != c.TimeValue
+		case ">":
+			return candidateTime > c.TimeValue
+		case ">=":
+			return candidateTime >= c.TimeValue
+		case "<":
+			return candidateTime < c.TimeValue
+		case "<=":
+			return candidateTime <= c.TimeValue
+		}
+		return false
+	}
+
+	if c.Operator == "ILIKE" {
+		return matchLikeFold(candidate, c.Value)
+	}
+
+	var cmp int
+	if c.IsDecimal {
+		n, ok := c.Numbers.Normalize(candidate)
+		if !ok {
+			return false
+		}
+		d, ok := datatype.ParseDecimal(n)
+		if !ok {
+			return false
+		}
+		cmp = d.Cmp(c.DecimalValue)
+	} else if c.CaseInsensitive {
+		cmp = compareFold(candidate, c.Value)
+	} else {
+		cmp = strings.Compare(candidate, c.Value)
+	}
+	return orderMatches(c.Operator, cmp)
+}
+
+// Compare evaluates the comparison on a row's two values. Without a
+// declared type, values that both read as numbers compare numerically and
+// values that both read as dates or date-times compare chronologically;
+// anything else compares as strings. With one, values that don't read as
+// that type never match. A null-safe comparison matches two empty values
+// and never one.
+func (c ColumnComparison) Compare(left, right string) bool {
+	if c.NullSafe && (left == "" || right == "") {
+		return left == right
+	}
+	switch c.Type {
+	case "":
+		if l, ok := c.Numbers.ParseFloat(left); ok {
+			if r, ok := c.Numbers.ParseFloat(right); ok {
+				return compareOrdered(c.Operator, l, r)
+			}
+		}
+		if l, ok := datatype.ParseTimestamp(left); ok {
+			if r, ok := datatype.ParseTimestamp(right); ok {
+				return compareOrdered(c.Operator, l, r)
+			}
+		}
+	case TypeInt, TypeFloat:
+		l, lok := c.Numbers.ParseFloat(left)
+		r, rok := c.Numbers.ParseFloat(right)
+		return lok && rok && compareOrdered(c.Operator, l, r)
+	case TypeDecimal:
+		l, lok := c.decimal(left)
+		r, rok := c.decimal(right)
+		return lok && rok && orderMatches(c.Operator, l.Cmp(r))
+	case TypeTimestamp:
+		l, lok := datatype.ParseTimestamp(left)
+		r, rok := datatype.ParseTimestamp(right)
+		return lok && rok && compareOrdered(c.Operator, l, r)
+	case TypeBoolean:
+		l, lok := datatype.ParseBool(left)
+		r, rok := datatype.ParseBool(right)
+		if !lok || !rok {
+			return false
+		}
+		if c.Operator == "!=" {
+			return l != r
+		}
+		return c.Operator == "=" && l == r
+	}
+	if c.CaseInsensitive {
+		return orderMatches(c.Operator, compareFold(left, right))
+	}
+	return orderMatches(c.Operator, strings.Compare(left, right))
+}
+
+// decimal reads s as a plain decimal written in c.Numbers
+func (c ColumnComparison) decimal(s string) (datatype.Decimal, bool) {
+	n, ok := c.Numbers.Normalize(s)
+	if !ok {
+		return datatype.Decimal{}, false
+	}
+	return datatype.ParseDecimal(n)
+}
+
+// compareOrdered applies a comparison operator to a and b
+func compareOrdered[T float64 | int64](op string, a, b T) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return false
+}
+
+// orderMatches applies a comparison operator to an ordering result (-1, 0,
+// or 1)
+func orderMatches(op string, cmp int) bool {
+	switch op {
 	case "=":
 		return cmp == 0
 	case "!=":
@@ -461,7 +1795,106 @@ func (c Comparison) Compare(candidate string) bool {
 		return cmp < 0
 	case "<=":
 		return cmp <= 0
+	}
+	return false
+}
+
+// FoldCase returns a copy of expr in which every comparison is case-insensitive
+// (the --case-insensitive mode)
+func FoldCase(expr Expression) Expression {
+	if expr == nil {
+		return nil
+	}
+	return VisitExpression[Expression](expr, caseFolder{})
+}
+
+// caseFolder sets CaseInsensitive on every comparison of an expression
+type caseFolder struct{}
+
+func (caseFolder) Binary(e BinaryExpr) Expression {
+	return BinaryExpr{Left: FoldCase(e.Left), Operator: e.Operator, Right: FoldCase(e.Right)}
+}
+
+func (caseFolder) Unary(e UnaryExpr) Expression {
+	return UnaryExpr{Operator: e.Operator, Expr: FoldCase(e.Expr)}
+}
+
+func (caseFolder) Comparison(e Comparison) Expression {
+	e.CaseInsensitive = true
+	return e
+}
+
+func (caseFolder) ColumnComparison(e ColumnComparison) Expression {
+	e.CaseInsensitive = true
+	return e
+}
+
+func (caseFolder) ExprComparison(e ExprComparison) Expression {
+	e.CaseInsensitive = true
+	return e
+}
+
+func (caseFolder) In(e InExpr) Expression {
+	e.CaseInsensitive = true
+	return e
+}
+
+// compareFold orders two strings rune by rune after lowercasing each rune,
+// without allocating
+func compareFold(a, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		ra, rb = unicode.ToLower(ra), unicode.ToLower(rb)
+		if ra != rb {
+			if ra < rb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[na:], b[nb:]
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
 	default:
-		return false
+		return 1
+	}
+}
+
+// matchLikeFold reports whether s matches a LIKE pattern case-insensitively:
+// % matches any run of characters and _ matches exactly one
+func matchLikeFold(s, pattern string) bool {
+	// Backtrack to the most recent % on mismatch
+	starPattern, starS := -1, 0
+	si, pi := 0, 0
+	for si < len(s) {
+		if pi < len(pattern) {
+			pr, pn := utf8.DecodeRuneInString(pattern[pi:])
+			sr, sn := utf8.DecodeRuneInString(s[si:])
+			switch {
+			case pr == '%':
+				starPattern, starS = pi+pn, si
+				pi += pn
+				continue
+			case pr == '_' || unicode.ToLower(pr) == unicode.ToLower(sr):
+				si += sn
+				pi += pn
+				continue
+			}
+		}
+		if starPattern < 0 {
+			return false
+		}
+		// Let the last % absorb one more character and retry
+		_, sn := utf8.DecodeRuneInString(s[starS:])
+		starS += sn
+		si, pi = starS, starPattern
+	}
+	for pi < len(pattern) && pattern[pi] == '%' {
+		pi++
 	}
+	return pi == len(pattern)
 }
@@ -74,8 +74,8 @@ func TestWhitespaceInOperators(t *testing.T) {
 		{
 			name:        "and_with_newline",
 			input:       "SELECT * FROM data.csv WHERE col1 = 'A'\nAND col2 = 'B'",
-			shouldParse: false, // Known limitation: main query regex doesn't handle newlines
-			description: "AND operator with newline (not supported - known limitation)",
+			shouldParse: true,
+			description: "AND operator with newline",
 		},
 		{
 			name:        "and_no_space_before_paren",
@@ -86,8 +86,8 @@ func TestWhitespaceInOperators(t *testing.T) {
 		{
 			name:        "or_with_mixed_whitespace",
 			input:       "SELECT * FROM data.csv WHERE col1 = 'A'  \t\n  OR   col2 = 'B'",
-			shouldParse: false, // Known limitation: main query regex doesn't handle newlines
-			description: "OR with mixed whitespace including newline (not supported)",
+			shouldParse: true,
+			description: "OR with mixed whitespace including newline",
 		},
 		{
 			name:        "not_with_paren",
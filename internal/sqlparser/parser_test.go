@@ -1,6 +1,13 @@
 package sqlparser
 
-import "testing"
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestParseBasicQuery(t *testing.T) {
 	q, err := Parse("SELECT col1, col2 FROM data.csv WHERE col1 = '42' LIMIT 10")
@@ -41,6 +48,121 @@ func TestParseQuotedFilePath(t *testing.T) {
 	}
 }
 
+func TestParseFilePathTokens(t *testing.T) {
+	tests := []struct {
+		query, path, into string
+	}{
+		{`SELECT * FROM 'q1 from sales.csv' WHERE a = 1`, "q1 from sales.csv", ""},
+		{`SELECT * FROM "it's.csv" LIMIT 1`, "it's.csv", ""},
+		{`SELECT * FROM 'it''s here.csv'`, "it's here.csv", ""},
+		{`SELECT * FROM "say ""hi"".csv"`, `say "hi".csv`, ""},
+		{`SELECT * INTO "out where.csv" FROM 'in limit.csv' LIMIT 2`, "in limit.csv", "out where.csv"},
+		{"SELECT *\nFROM data.csv\nLIMIT 3", "data.csv", ""},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if q.FilePath != tt.path || q.OutputPath != tt.into {
+			t.Errorf("%s: path %q into %q, want %q into %q", tt.query, q.FilePath, q.OutputPath, tt.path, tt.into)
+		}
+	}
+
+	for _, query := range []string{
+		`SELECT * FROM 'unterminated.csv WHERE a = 1`,
+		`SELECT * INTO 'out.csv FROM data.csv`,
+		`CREATE TABLE 'out.csv AS SELECT * FROM data.csv`,
+	} {
+		if _, err := Parse(query); err == nil || !strings.Contains(err.Error(), "unterminated") {
+			t.Errorf("%s: expected unterminated quote error, got %v", query, err)
+		}
+	}
+}
+
+func TestParseExpandsPaths(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	t.Setenv("SIESWI_DATA", "/srv/data")
+
+	q, err := Parse(`SELECT * INTO '$SIESWI_DATA/out file.csv' FROM ~/data.csv`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(home, "data.csv"); q.FilePath != want {
+		t.Errorf("FilePath = %q, want %q", q.FilePath, want)
+	}
+	if q.OutputPath != "/srv/data/out file.csv" {
+		t.Errorf("OutputPath = %q", q.OutputPath)
+	}
+
+	q, err = Parse(`CREATE TABLE "${SIESWI_DATA}/t.csv" AS SELECT * FROM data.csv`)
+	if err != nil || q.OutputPath != "/srv/data/t.csv" {
+		t.Errorf("CREATE TABLE path = %q (%v)", q.OutputPath, err)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("SIESWI_DIR", "/tmp/x")
+	os.Unsetenv("SIESWI_UNSET")
+	tests := map[string]string{
+		"data.csv":            "data.csv",
+		"$SIESWI_DIR/a.csv":   "/tmp/x/a.csv",
+		"${SIESWI_DIR}_b.csv": "/tmp/x_b.csv",
+		"$SIESWI_UNSET/a.csv": "$SIESWI_UNSET/a.csv",
+		"${SIESWI_UNSET}.csv": "${SIESWI_UNSET}.csv",
+		"price$.csv":          "price$.csv",
+		"~user/a.csv":         "~user/a.csv",
+		"reports/~/a.csv":     "reports/~/a.csv",
+		"-":                   "-",
+	}
+	for in, want := range tests {
+		if got := ExpandPath(in); got != want {
+			t.Errorf("ExpandPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	q, err := Parse(`SELECT id, name INTO 'out dir/result.csv.gz' FROM data.csv WHERE id > 1`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.OutputPath != "out dir/result.csv.gz" {
+		t.Fatalf("unexpected output path: %q", q.OutputPath)
+	}
+	if len(q.Columns) != 2 || q.Columns[1] != "name" || q.FilePath != "data.csv" || q.Where == nil {
+		t.Fatalf("unexpected query: %#v", q)
+	}
+
+	q, err = Parse("SELECT * FROM data.csv")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.OutputPath != "" {
+		t.Fatalf("expected no output path, got %q", q.OutputPath)
+	}
+}
+
+func TestParseCreateTableAs(t *testing.T) {
+	q, err := Parse("create table 'uk orders.csv' as SELECT id FROM orders.csv WHERE country = 'UK' LIMIT 5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !q.CreateTable || q.OutputPath != "uk orders.csv" {
+		t.Fatalf("unexpected target: create=%v path=%q", q.CreateTable, q.OutputPath)
+	}
+	if q.FilePath != "orders.csv" || q.Limit != 5 || q.Where == nil {
+		t.Fatalf("unexpected query: %#v", q)
+	}
+
+	if _, err := Parse("CREATE TABLE a.csv AS SELECT * INTO b.csv FROM c.csv"); err == nil {
+		t.Fatal("expected error for CREATE TABLE with INTO")
+	}
+}
+
 func TestParseHandlesWhitespace(t *testing.T) {
 	q, err := Parse("  SELECT   col1  ,  col2    FROM   ./data.csv   LIMIT   5  ")
 	if err != nil {
@@ -56,6 +178,43 @@ func TestParseHandlesWhitespace(t *testing.T) {
 	}
 }
 
+func TestParseTrailingSemicolon(t *testing.T) {
+	tests := []struct {
+		query, path, value string
+	}{
+		{"SELECT * FROM e.csv WHERE id = 1;", "e.csv", "1"},
+		{"SELECT * FROM e.csv;", "e.csv", ""},
+		{"SELECT * FROM e.csv WHERE id = 1 ; -- done\n", "e.csv", "1"},
+		{"SELECT * FROM 'e.csv' WHERE id = 2;\n/* end */", "e.csv", "2"},
+		{"SELECT * FROM e.csv WHERE name = 'a;'", "e.csv", "a;"},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if q.FilePath != tt.path {
+			t.Errorf("%q: path %q, want %q", tt.query, q.FilePath, tt.path)
+		}
+		if c, ok := q.Where.(Comparison); tt.value != "" && (!ok || c.Value != tt.value) {
+			t.Errorf("%q: WHERE %#v, want value %q", tt.query, q.Where, tt.value)
+		}
+	}
+
+	// A second statement is an error, not part of a value or path
+	for _, query := range []string{
+		"SELECT * FROM e.csv; SELECT * FROM f.csv",
+		"SELECT * FROM e.csv WHERE id = 1;2",
+		"SELECT * FROM e.csv;;",
+	} {
+		_, err := Parse(query)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) || syntax.Pos != strings.Index(query, ";") {
+			t.Errorf("%q: got %v, want a syntax error at the semicolon", query, err)
+		}
+	}
+}
+
 func TestParseRejectsInvalidQueries(t *testing.T) {
 	if _, err := Parse("DELETE FROM data.csv"); err == nil {
 		t.Fatalf("expected error for unsupported verb")
@@ -80,6 +239,125 @@ func TestPredicateCompare(t *testing.T) {
 	}
 }
 
+func TestTimestampCompare(t *testing.T) {
+	query, err := Parse("SELECT * FROM data.csv WHERE created_at >= '2024-01-31'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	comp, ok := query.Where.(Comparison)
+	if !ok || !comp.IsTimestamp || comp.IsNumeric {
+		t.Fatalf("expected timestamp comparison, got %#v", query.Where)
+	}
+
+	tests := []struct {
+		candidate string
+		want      bool
+	}{
+		{"2024-01-31", true},
+		{"2024-01-31T00:00:00Z", true},
+		{"2024-01-30T23:59:59Z", false},
+		{"2024-01-31T01:00:00+02:00", false}, // 2024-01-30T23:00:00Z
+		{"2024-02-01 08:00:00", true},
+		{"not a date", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := comp.Compare(tt.candidate); got != tt.want {
+			t.Errorf("Compare(%q) = %v, want %v", tt.candidate, got, tt.want)
+		}
+	}
+}
+
+func TestCaseInsensitiveCompare(t *testing.T) {
+	tests := []struct {
+		where     string
+		candidate string
+		want      bool
+	}{
+		{"status =~ 'Completed'", "completed", true},
+		{"status =~ 'Completed'", "COMPLETED", true},
+		{"status =~ 'Completed'", "complete", false},
+		{"status = 'Completed'", "completed", false},
+		{"status ILIKE 'comp%'", "Completed", true},
+		{"status ILIKE 'comp%'", "incomplete", false},
+		{"status ilike '%LET_D'", "completed", true},
+		{"status ILIKE '%e%e%'", "Pending", false},
+		{"status ILIKE '%'", "", true},
+		{"code ILIKE '10'", "10", true},
+		{"code ILIKE '10'", "10.0", false},
+		{"name =~ 'ÉCOLE'", "école", true},
+	}
+	for _, tt := range tests {
+		query, err := Parse("SELECT * FROM data.csv WHERE " + tt.where)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.where, err)
+		}
+		if got := Evaluate(query.Where, map[string]string{"status": tt.candidate, "code": tt.candidate, "name": tt.candidate}); got != tt.want {
+			t.Errorf("%s against %q = %v, want %v", tt.where, tt.candidate, got, tt.want)
+		}
+	}
+}
+
+func TestNullSafeCompare(t *testing.T) {
+	tests := []struct {
+		where       string
+		left, right string
+		want        bool
+	}{
+		{"a <=> b", "", "", true},
+		{"a <=> b", "", "5", false},
+		{"a <=> b", "5", "", false},
+		{"a <=> b", "5", "5.0", true},
+		{"a <=> b", "5", "6", false},
+		{"NOT a <=> b", "", "5", true},
+		{"NOT a <=> b", "", "", false},
+		{"CAST(a AS INT) <=> b", "", "", true},
+		{"CAST(a AS INT) <=> b", "", "5", false},
+		{"CAST(a AS INT) = b", "", "", false},
+		{"a <=> b", "Done", "DONE", false},
+	}
+	for _, tt := range tests {
+		query, err := Parse("SELECT * FROM data.csv WHERE " + tt.where)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.where, err)
+		}
+		if got := Evaluate(query.Where, map[string]string{"a": tt.left, "b": tt.right}); got != tt.want {
+			t.Errorf("%s with a=%q, b=%q = %v, want %v", tt.where, tt.left, tt.right, got, tt.want)
+		}
+	}
+
+	query, err := Parse("SELECT * FROM data.csv WHERE a <=> b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Evaluate(FoldCase(query.Where), map[string]string{"a": "Done", "b": "DONE"}) {
+		t.Error("FoldCase lost the case-insensitive match of a <=> b")
+	}
+	for _, where := range []string{"a <=> ''", "a <=> 5", "a + 1 <=> b"} {
+		if _, err := Parse("SELECT * FROM data.csv WHERE " + where); err == nil {
+			t.Errorf("Parse(%q) succeeded; <=> only compares two columns", where)
+		}
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	query, err := Parse("SELECT * FROM data.csv WHERE NOT (status = 'Done' OR name < 'm')")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expr := FoldCase(query.Where)
+
+	if Evaluate(expr, map[string]string{"status": "DONE", "name": "zed"}) {
+		t.Errorf("expected DONE to match 'Done' case-insensitively")
+	}
+	if !Evaluate(expr, map[string]string{"status": "open", "name": "Zed"}) {
+		t.Errorf("expected Zed to sort after 'm' case-insensitively")
+	}
+	if Evaluate(query.Where, map[string]string{"status": "open", "name": "Zed"}) {
+		t.Errorf("FoldCase must not modify the original expression")
+	}
+}
+
 func TestParseGroupBy(t *testing.T) {
 	q, err := Parse("SELECT country, COUNT(*) FROM data.csv GROUP BY country")
 	if err != nil {
@@ -120,3 +398,536 @@ func TestParseGroupByWithWhere(t *testing.T) {
 		t.Fatalf("expected WHERE expression")
 	}
 }
+
+func TestParseSample(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Sample
+	}{
+		{"SELECT * FROM data.csv SAMPLE 1%", Sample{Percent: 1}},
+		{"SELECT * FROM 'big file.csv' sample 0.5 PERCENT WHERE id > 3 LIMIT 10", Sample{Percent: 0.5}},
+		{"SELECT id FROM data.csv SAMPLE 10000 ROWS", Sample{Rows: 10000, Reservoir: true}},
+		{"SELECT id FROM data.csv TABLESAMPLE BERNOULLI (25) REPEATABLE (42)", Sample{Percent: 25, Seed: 42, Repeatable: true}},
+		{"SELECT country, COUNT(*) FROM data.csv SAMPLE 5 rows repeatable(1) GROUP BY country", Sample{Rows: 5, Reservoir: true, Seed: 1, Repeatable: true}},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.query, err)
+		}
+		if q.Sample == nil || *q.Sample != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.query, q.Sample, tt.want)
+		}
+	}
+
+	q, err := Parse("SELECT * FROM data.csv WHERE name = 'sample 10%'")
+	if err != nil || q.Sample != nil {
+		t.Errorf("SAMPLE inside a WHERE literal: %+v, %v", q.Sample, err)
+	}
+
+	for _, query := range []string{
+		"SELECT * FROM data.csv SAMPLE 101%",
+		"SELECT * FROM data.csv SAMPLE 10",
+		"SELECT * FROM data.csv SAMPLE 1.5 ROWS",
+		"SELECT * FROM data.csv SAMPLE 10% REPEATABLE",
+	} {
+		if _, err := Parse(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func TestParseBetween(t *testing.T) {
+	q, err := Parse("SELECT * FROM data.csv WHERE country = 'DE' AND amount BETWEEN 10 AND 20 AND id NOT BETWEEN 3 AND 5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows := []struct {
+		row  map[string]string
+		want bool
+	}{
+		{map[string]string{"country": "DE", "amount": "10", "id": "1"}, true},
+		{map[string]string{"country": "DE", "amount": "20", "id": "6"}, true},
+		{map[string]string{"country": "DE", "amount": "21", "id": "1"}, false},
+		{map[string]string{"country": "DE", "amount": "9.5", "id": "1"}, false},
+		{map[string]string{"country": "DE", "amount": "15", "id": "4"}, false},
+		{map[string]string{"country": "FR", "amount": "15", "id": "1"}, false},
+	}
+	for _, tt := range rows {
+		if got := Evaluate(q.Where, tt.row); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.row, got, tt.want)
+		}
+	}
+
+	q, err = Parse("SELECT * FROM data.csv WHERE day between '2024-01-01' and '2024-01-31'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	and := q.Where.(BinaryExpr)
+	if low := and.Left.(Comparison); low.Operator != ">=" || !low.IsTimestamp {
+		t.Errorf("unexpected lower bound: %+v", low)
+	}
+	if high := and.Right.(Comparison); high.Operator != "<=" || high.Value != "2024-01-31" {
+		t.Errorf("unexpected upper bound: %+v", high)
+	}
+}
+
+func TestParseMultilineQuery(t *testing.T) {
+	q, err := Parse("SELECT country,\n       COUNT(*)\nFROM data.csv\nWHERE status = 'paid'\n  AND amount > 10\nGROUP BY country\nLIMIT 5\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(q.Columns) != 2 || q.Columns[1] != "COUNT(*)" || len(q.GroupBy) != 1 || q.Limit != 5 {
+		t.Fatalf("unexpected query: %#v", q)
+	}
+	if and, ok := q.Where.(BinaryExpr); !ok || and.Operator != "AND" {
+		t.Fatalf("expected AND, got %#v", q.Where)
+	}
+}
+
+func TestParseKeywordsInLiterals(t *testing.T) {
+	q, err := Parse("SELECT * FROM data.csv WHERE note = 'paid WHERE x OR y LIMIT 3' AND tag = \"a AND b\" LIMIT 2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	and, ok := q.Where.(BinaryExpr)
+	if !ok || and.Operator != "AND" || q.Limit != 2 {
+		t.Fatalf("unexpected query: %#v", q)
+	}
+	if note := and.Left.(Comparison); note.Value != "paid WHERE x OR y LIMIT 3" {
+		t.Errorf("note = %q", note.Value)
+	}
+	if tag := and.Right.(Comparison); tag.Value != "a AND b" {
+		t.Errorf("tag = %q", tag.Value)
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{"SELECT * FROM data.csv WHERE", 28, "expected column name, found end of query"},
+		{"SELECT * FROM data.csv WHERE x >", 32, "expected value"},
+		{"SELECT * FROM data.csv WHERE (x = 1", 35, `expected ")"`},
+		{"SELECT * FROM data.csv WHERE x = 'abc", 33, "unterminated string"},
+		{"SELECT * FROM data.csv WHERE x 5", 31, "expected comparison operator"},
+		{"SELECT * FROM data.csv LIMIT ten", 29, "invalid LIMIT value: ten"},
+		{"SELECT * FROM data.csv\nWHRE x = 1", 23, `expected end of query, found "WHRE"`},
+		{"SELECT * FROM data.csv WHERE CAST(x AS money2) = 1", 29, "unknown type"},
+		{"SELECT * FROM data.csv SAMPLE 5", 31, "expected %, PERCENT, or ROWS"},
+		{"UPDATE data.csv", 0, "unsupported query"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("%q: expected a SyntaxError, got %v", tt.query, err)
+			continue
+		}
+		if serr.Pos != tt.pos || !strings.Contains(serr.Msg, tt.msg) {
+			t.Errorf("%q: error %q at %d, want %q at %d", tt.query, serr.Msg, serr.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestParseEscapedQuotes(t *testing.T) {
+	tests := []struct {
+		where string
+		want  string
+	}{
+		{`name = 'O''Brien'`, "O'Brien"},
+		{`name = 'O\'Brien'`, "O'Brien"},
+		{`name = "say ""hi"""`, `say "hi"`},
+		{`name = "say \"hi\""`, `say "hi"`},
+		{`name = 'it''s ''quoted'' AND more'`, "it's 'quoted' AND more"},
+		{`name = ''''`, "'"},
+		{`name = ''`, ""},
+		{`path = 'C:\data\file.csv'`, `C:\data\file.csv`},
+		{`path = 'ends with \\'`, `ends with \`},
+		{`name ILIKE 'o''b%'`, "o'b%"},
+	}
+	for _, tt := range tests {
+		q, err := Parse("SELECT * FROM data.csv WHERE " + tt.where + " LIMIT 1")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.where, err)
+		}
+		if comp := q.Where.(Comparison); comp.Value != tt.want {
+			t.Errorf("%s: value %q, want %q", tt.where, comp.Value, tt.want)
+		}
+	}
+
+	q, err := Parse(`SELECT * FROM data.csv WHERE name BETWEEN 'D''Arcy' AND 'O\'Neil'`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !Evaluate(q.Where, map[string]string{"name": "O'Brien"}) || Evaluate(q.Where, map[string]string{"name": "O'Reilly"}) {
+		t.Errorf("BETWEEN with escaped quotes: %#v", q.Where)
+	}
+
+	if _, err := Parse(`SELECT * FROM data.csv WHERE name = 'O\'`); err == nil || !strings.Contains(err.Error(), "unterminated string") {
+		t.Errorf("expected unterminated string, got %v", err)
+	}
+}
+
+func TestParseQuotedIdentifiers(t *testing.T) {
+	q, err := Parse("SELECT \"Order Date\", `user.id`, [Net ]]Amount], SUM(\"Net Amount\") FROM data.csv " +
+		"WHERE \"Order Date\" >= '2024-01-01' AND `user.id` = \"42\" AND CAST([Qty (units)] AS INT) > 1 AND \"is active\" " +
+		"GROUP BY \"Order Date\", `user.id`")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantColumns := []string{"Order Date", "user.id", "Net ]Amount", "SUM(Net Amount)"}
+	if strings.Join(q.Columns, "|") != strings.Join(wantColumns, "|") {
+		t.Errorf("columns = %q, want %q", q.Columns, wantColumns)
+	}
+	if strings.Join(q.GroupBy, "|") != "Order Date|user.id" {
+		t.Errorf("group by = %q", q.GroupBy)
+	}
+
+	var comps []Comparison
+	var walk func(Expression)
+	walk = func(e Expression) {
+		switch e := e.(type) {
+		case BinaryExpr:
+			walk(e.Left)
+			walk(e.Right)
+		case Comparison:
+			comps = append(comps, e)
+		}
+	}
+	walk(q.Where)
+	want := []struct{ column, value string }{
+		{"Order Date", "2024-01-01"}, {"user.id", "42"}, {"Qty (units)", "1"}, {"is active", "true"},
+	}
+	if len(comps) != len(want) {
+		t.Fatalf("comparisons = %+v", comps)
+	}
+	for i, w := range want {
+		if comps[i].Column != w.column || comps[i].Value != w.value {
+			t.Errorf("comparison %d on %q = %q, want %q = %q", i, comps[i].Column, comps[i].Value, w.column, w.value)
+		}
+	}
+	if !comps[2].IsNumeric || !comps[3].IsBool {
+		t.Errorf("unexpected comparison types: %+v", comps)
+	}
+
+	if _, err := Parse("SELECT * FROM data.csv WHERE `user.id = 1"); err == nil || !strings.Contains(err.Error(), "unterminated identifier") {
+		t.Errorf("expected unterminated identifier, got %v", err)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	q, err := Parse(`-- Paid orders by country
+/* saved from report.sql; don't edit */
+SELECT country, -- the grouping column
+       COUNT(*) /* rows */, SUM(amount)--total
+FROM 'orders.csv' -- the file
+WHERE status = 'paid -- not a comment' /* inline */ AND amount > 10--ten
+GROUP BY country /* end */
+LIMIT 5 -- five`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(q.Columns, "|") != "country|COUNT(*)|SUM(amount)" {
+		t.Errorf("columns = %q", q.Columns)
+	}
+	if q.FilePath != "orders.csv" || q.Limit != 5 || strings.Join(q.GroupBy, "|") != "country" {
+		t.Errorf("unexpected query: %#v", q)
+	}
+	and := q.Where.(BinaryExpr)
+	if status := and.Left.(Comparison); status.Value != "paid -- not a comment" {
+		t.Errorf("status = %q", status.Value)
+	}
+	if amount := and.Right.(Comparison); amount.Value != "10" || !amount.IsNumeric {
+		t.Errorf("amount = %+v", amount)
+	}
+
+	if _, err := Parse("SELECT * FROM data.csv /* never closed"); err == nil || !strings.Contains(err.Error(), "unterminated comment") {
+		t.Errorf("expected unterminated comment, got %v", err)
+	}
+}
+
+func TestParseColumnComparison(t *testing.T) {
+	q, err := Parse("SELECT * FROM data.csv WHERE discount_minor > price_minor AND `Ship Date` >= [Order Date] AND active = true AND code = abc-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	and := q.Where.(BinaryExpr)
+	inner := and.Left.(BinaryExpr).Left.(BinaryExpr)
+	if c := inner.Left.(ColumnComparison); c.Left != "discount_minor" || c.Operator != ">" || c.Right != "price_minor" {
+		t.Errorf("discount_minor > price_minor = %+v", c)
+	}
+	if c := inner.Right.(ColumnComparison); c.Left != "Ship Date" || c.Right != "Order Date" {
+		t.Errorf("quoted columns = %+v", c)
+	}
+	// TRUE/FALSE and numbers stay literals; abc-1 is arithmetic on column abc
+	if c := and.Left.(BinaryExpr).Right.(Comparison); c.Value != "true" {
+		t.Errorf("active = true = %+v", c)
+	}
+	if c := and.Right.(ExprComparison); c.Right != (Arithmetic{Left: ColumnRef{Name: "abc"}, Operator: "-", Right: newLiteral("1")}) {
+		t.Errorf("code = abc-1 = %+v", c)
+	}
+
+	for _, tt := range []struct {
+		left, op, right string
+		want            bool
+	}{
+		{"10", ">", "9", true}, // Numbers compare numerically
+		{"abc", ">", "9", true},
+		{"2024-02-01", ">", "2024-01-31T23:00:00Z", true},
+		{"x", "=", "x", true},
+		{"NaN", "!=", "NaN", true},
+		{"", "=", "", true},
+		{"b", "<=", "a", false},
+	} {
+		c := ColumnComparison{Left: "l", Operator: tt.op, Right: "r"}
+		if got := c.Compare(tt.left, tt.right); got != tt.want {
+			t.Errorf("%q %s %q = %v, want %v", tt.left, tt.op, tt.right, got, tt.want)
+		}
+	}
+
+	q, err = Parse("SELECT * FROM data.csv WHERE name =~ nickname OR CAST(a AS STRING) < b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	or := q.Where.(BinaryExpr)
+	if !EvaluateNormalized(or.Left, map[string]string{"name": "Ada", "nickname": "ADA"}) {
+		t.Errorf("=~ should compare the columns case-insensitively")
+	}
+	if c := or.Right.(ColumnComparison); c.Type != TypeString || !c.Compare("10", "9") {
+		t.Errorf("CAST to string should compare 10 < 9 as text: %+v", c)
+	}
+	if EvaluateNormalized(or.Right, map[string]string{"a": "10"}) {
+		t.Errorf("missing column should not match")
+	}
+
+	if _, err := Parse("SELECT * FROM data.csv WHERE CAST(a AS BOOLEAN) > b"); err == nil {
+		t.Errorf("expected error for > on boolean columns")
+	}
+}
+
+func TestParseScalarExpressions(t *testing.T) {
+	q, err := Parse("SELECT id, ROUND(total_minor/100.0, 2) AS total_gbp, price * -qty, `Unit Price` AS up FROM data.csv WHERE (price - discount) * qty > 100 AND 2 * qty <= id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.Columns[0] != "id" || q.Exprs[0] != nil || q.Aliases[0] != "" {
+		t.Errorf("plain column = %q %v %q", q.Columns[0], q.Exprs[0], q.Aliases[0])
+	}
+	round := FuncCall{Name: "ROUND", Args: []Scalar{
+		Arithmetic{Left: ColumnRef{Name: "total_minor"}, Operator: "/", Right: newLiteral("100.0")},
+		newLiteral("2"),
+	}}
+	if got := q.Exprs[1]; !reflect.DeepEqual(got, round) || q.Aliases[1] != "total_gbp" || q.Columns[1] != "ROUND(total_minor/100.0, 2)" {
+		t.Errorf("ROUND item = %q %+v %q", q.Columns[1], got, q.Aliases[1])
+	}
+	negated := Arithmetic{Left: ColumnRef{Name: "price"}, Operator: "*", Right: Arithmetic{Left: Literal{Value: "0", IsNumber: true}, Operator: "-", Right: ColumnRef{Name: "qty"}}}
+	if got := q.Exprs[2]; !reflect.DeepEqual(got, negated) {
+		t.Errorf("price * -qty = %+v", got)
+	}
+	if q.Columns[3] != "Unit Price" || q.Exprs[3] != nil || q.Aliases[3] != "up" {
+		t.Errorf("aliased column = %q %v %q", q.Columns[3], q.Exprs[3], q.Aliases[3])
+	}
+
+	and := q.Where.(BinaryExpr)
+	left := and.Left.(ExprComparison)
+	if sub, ok := left.Left.(Arithmetic); !ok || sub.Operator != "*" || sub.Left.(Arithmetic).Operator != "-" || left.Right != newLiteral("100") {
+		t.Errorf("(price - discount) * qty > 100 = %+v", left)
+	}
+	right := and.Right.(ExprComparison)
+	if right.Left != (Arithmetic{Left: newLiteral("2"), Operator: "*", Right: ColumnRef{Name: "qty"}}) || right.Right != (ColumnRef{Name: "id"}) {
+		t.Errorf("2 * qty <= id = %+v", right)
+	}
+	if !Evaluate(q.Where, map[string]string{"price": "30", "discount": "5", "qty": "5", "id": "10"}) {
+		t.Error("expected row to match")
+	}
+
+	for query, want := range map[string]string{
+		"SELECT ROUND(a, 1, 2) FROM t.csv":                 "ROUND takes 1 or 2 arguments, got 3",
+		"SELECT ABS() FROM t.csv":                          "ABS takes 1 argument, got 0",
+		"SELECT NOPE(a) FROM t.csv":                        "unknown function NOPE",
+		"SELECT a + FROM t.csv":                            "expected column name",
+		"SELECT * FROM t.csv WHERE a * 2 ILIKE 'x'":        "ILIKE needs a column",
+		"SELECT * FROM t.csv WHERE CAST(a AS INT) > b + 1": "CAST only compares",
+	} {
+		if _, err := Parse(query); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", query, err, want)
+		}
+	}
+}
+
+func TestParseAggregateOverExpression(t *testing.T) {
+	q, err := Parse("SELECT country, SUM(price_minor * quantity) AS revenue, AVG([Net Amount]), COUNT(*) FROM data.csv GROUP BY country")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Arithmetic{Left: ColumnRef{Name: "price_minor"}, Operator: "*", Right: ColumnRef{Name: "quantity"}}
+	if q.Columns[1] != "SUM(price_minor * quantity)" || q.Exprs[1] != want || q.Aliases[1] != "revenue" {
+		t.Errorf("SUM item = %q %+v %q", q.Columns[1], q.Exprs[1], q.Aliases[1])
+	}
+	// Aggregates over a column or * keep only their text
+	if q.Columns[2] != "AVG(Net Amount)" || q.Exprs[2] != nil || q.Exprs[3] != nil {
+		t.Errorf("plain aggregates = %q %v %v", q.Columns[2], q.Exprs[2], q.Exprs[3])
+	}
+	if _, err := Parse("SELECT SUM(price *) FROM data.csv"); err == nil {
+		t.Error("expected error for incomplete expression")
+	}
+}
+
+func TestParseAggregateFilter(t *testing.T) {
+	q, err := Parse("SELECT country, COUNT(*) FILTER (WHERE status = 'refunded'), SUM(amount) FILTER ( WHERE amount > 5 OR paid ) AS big FROM data.csv GROUP BY country")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.Filters[0] != nil || q.Columns[1] != "COUNT(*)" || q.Columns[2] != "SUM(amount)" {
+		t.Errorf("columns = %q, filters = %v", q.Columns, q.Filters)
+	}
+	if c := q.Filters[1].(Comparison); c.Column != "status" || c.Value != "refunded" {
+		t.Errorf("COUNT filter = %+v", c)
+	}
+	// Without AS the item is named by its full text
+	if q.Aliases[1] != "COUNT(*) FILTER (WHERE status = 'refunded')" || q.Aliases[2] != "big" {
+		t.Errorf("aliases = %q", q.Aliases)
+	}
+	if _, ok := q.Filters[2].(BinaryExpr); !ok {
+		t.Errorf("SUM filter = %+v", q.Filters[2])
+	}
+
+	for query, want := range map[string]string{
+		"SELECT country FILTER (WHERE a = 1) FROM t.csv": "FILTER applies only to aggregates",
+		"SELECT COUNT(*) FILTER (a = 1) FROM t.csv":      "expected WHERE",
+		"SELECT COUNT(*) FILTER (WHERE a = 1 FROM t.csv": "expected \")\"",
+	} {
+		if _, err := Parse(query); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", query, err, want)
+		}
+	}
+}
+
+func TestParseQualify(t *testing.T) {
+	q, err := Parse("SELECT * FROM orders.csv WHERE status = 'paid' QUALIFY ROW_NUMBER() OVER (PARTITION BY country, [Sales Rep] ORDER BY total_minor / 100 DESC) <= 3 LIMIT 50")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &TopN{
+		PartitionBy: []string{"country", "Sales Rep"},
+		OrderBy:     Arithmetic{Left: ColumnRef{Name: "total_minor"}, Operator: "/", Right: newLiteral("100")},
+		Desc:        true,
+		N:           3,
+	}
+	if !reflect.DeepEqual(q.Top, want) || q.Limit != 50 || q.Where == nil {
+		t.Errorf("Top = %+v, Limit = %d", q.Top, q.Limit)
+	}
+
+	for query, n := range map[string]int{
+		"SELECT * FROM t.csv QUALIFY ROW_NUMBER() OVER (ORDER BY a) < 3":     2,
+		"SELECT * FROM t.csv QUALIFY ROW_NUMBER() OVER (ORDER BY a ASC) = 1": 1,
+	} {
+		q, err := Parse(query)
+		if err != nil || q.Top.N != n || q.Top.Desc || q.Top.PartitionBy != nil {
+			t.Errorf("%s: got %+v, %v", query, q.Top, err)
+		}
+	}
+
+	for query, want := range map[string]string{
+		"SELECT * FROM t.csv QUALIFY RANK() OVER (ORDER BY a) <= 3":                  "supports only ROW_NUMBER()",
+		"SELECT * FROM t.csv QUALIFY ROW_NUMBER() OVER (PARTITION BY a) <= 3":        "expected ORDER",
+		"SELECT * FROM t.csv QUALIFY ROW_NUMBER() OVER (ORDER BY a) = 2":             "only supported for n = 1",
+		"SELECT * FROM t.csv QUALIFY ROW_NUMBER() OVER (ORDER BY a) <= 0":            "invalid row number",
+		"SELECT a FROM t.csv GROUP BY a QUALIFY ROW_NUMBER() OVER (ORDER BY a) <= 1": "can't be combined with GROUP BY",
+	} {
+		if _, err := Parse(query); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", query, err, want)
+		}
+	}
+}
+
+func TestParseHavingOrderBy(t *testing.T) {
+	q, err := Parse(`SELECT country, SUM(refund_minor) AS refunded FROM orders.csv WHERE status = 'refunded'
+		GROUP BY country HAVING sum( "refund_minor" ) > 1000000 AND COUNT(*) >= 2
+		ORDER BY refunded DESC, 1 LIMIT 5`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	comparison := func(column, operator, value string) Comparison {
+		c, err := newComparison(column, "", operator, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	having := BinaryExpr{
+		Left:     comparison("SUM(refund_minor)", ">", "1000000"),
+		Operator: "AND",
+		Right:    comparison("COUNT(*)", ">=", "2"),
+	}
+	order := []OrderItem{{Expr: ColumnRef{Name: "refunded"}, Desc: true}, {Position: 1}}
+	if !reflect.DeepEqual(q.Having, having) || !reflect.DeepEqual(q.OrderBy, order) || q.Limit != 5 {
+		t.Errorf("Having = %+v, OrderBy = %+v, Limit = %d", q.Having, q.OrderBy, q.Limit)
+	}
+
+	q, err = Parse("SELECT a, AVG(x) FROM t.csv GROUP BY a ORDER BY MAX(x) - MIN(x), a ASC")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spread := Arithmetic{Left: ColumnRef{Name: "MAX(x)"}, Operator: "-", Right: ColumnRef{Name: "MIN(x)"}}
+	if want := []OrderItem{{Expr: spread}, {Expr: ColumnRef{Name: "a"}}}; !reflect.DeepEqual(q.OrderBy, want) {
+		t.Errorf("OrderBy = %+v, want %+v", q.OrderBy, want)
+	}
+
+	for query, want := range map[string]string{
+		"SELECT a, COUNT(*) FROM t.csv GROUP BY a ORDER BY 3":                        "between 1 and 2",
+		"SELECT a, COUNT(*) FROM t.csv GROUP BY a HAVING COUNT() > 1":                "needs an argument",
+		"SELECT a, COUNT(*) FROM t.csv GROUP BY a HAVING a IN (SELECT a FROM u.csv)": "isn't supported in HAVING",
+		"SELECT a, COUNT(*) FROM t.csv GROUP BY a HAVING SUM(x > 1":                  `expected ")"`,
+		"SELECT * FROM t.csv WHERE SUM(x) > 1":                                       "unknown function SUM",
+	} {
+		if _, err := Parse(query); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", query, err, want)
+		}
+	}
+}
+
+func TestParseIn(t *testing.T) {
+	q, err := Parse("SELECT * FROM orders.csv WHERE country IN ('UK', 'US',CA) AND id NOT IN (1,2.5)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := BinaryExpr{
+		Left:     InExpr{Column: "country", Values: []string{"UK", "US", "CA"}},
+		Operator: "AND",
+		Right:    UnaryExpr{Operator: "NOT", Expr: InExpr{Column: "id", Values: []string{"1", "2.5"}}},
+	}
+	if !reflect.DeepEqual(q.Where, want) {
+		t.Errorf("Where = %+v", q.Where)
+	}
+
+	q, err = Parse("SELECT * FROM orders.csv WHERE user_id IN (SELECT user_id FROM vip.csv WHERE tier IN ('gold')) LIMIT 5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	in, ok := q.Where.(InExpr)
+	if !ok || in.Subquery == nil || in.Subquery.FilePath != "vip.csv" || in.Subquery.Columns[0] != "user_id" || q.Limit != 5 {
+		t.Fatalf("Where = %+v, Limit = %d", q.Where, q.Limit)
+	}
+	if subs := Subqueries(q.Where); len(subs) != 1 || subs[0] != in.Subquery {
+		t.Errorf("Subqueries = %v", subs)
+	}
+
+	for query, want := range map[string]string{
+		"SELECT * FROM t.csv WHERE a IN ()":                           `expected value`,
+		"SELECT * FROM t.csv WHERE a IN (1, 2":                        `expected ")"`,
+		"SELECT * FROM t.csv WHERE a IN (SELECT a, b FROM u.csv)":     "exactly one column",
+		"SELECT * FROM t.csv WHERE a IN (SELECT * FROM u.csv)":        "exactly one column",
+		"SELECT * FROM t.csv WHERE a IN (SELECT a FROM u.csv":         `expected ")"`,
+		"SELECT * FROM t.csv WHERE a + 1 IN (1)":                      "needs a column on the left",
+		"SELECT * FROM t.csv WHERE NOT EXISTS (SELECT a FROM u.csv)":  "EXISTS subqueries aren't supported",
+		"SELECT * FROM t.csv WHERE a IN (SELECT a INTO x.csv FROM u)": "can't use INTO",
+	} {
+		if _, err := Parse(query); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", query, err, want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package sqlparser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readPath reads the file path at the start of input: a quoted path ('...'
+// or "...", where a doubled quote stands for one quote character) or a bare
+// path running to the next whitespace or semicolon. It returns the path and the input
+// after it.
+func readPath(input string) (path, rest string, err error) {
+	input = strings.TrimLeft(input, " \t\r\n")
+	if input == "" {
+		return "", "", fmt.Errorf("missing file path")
+	}
+
+	quote := input[0]
+	if quote != '\'' && quote != '"' {
+		end := strings.IndexAny(input, " \t\r\n;")
+		if end < 0 {
+			end = len(input)
+		}
+		return input[:end], input[end:], nil
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(input); i++ {
+		if input[i] != quote {
+			b.WriteByte(input[i])
+			continue
+		}
+		if i+1 < len(input) && input[i+1] == quote {
+			b.WriteByte(quote)
+			i++
+			continue
+		}
+		if b.Len() == 0 {
+			return "", "", fmt.Errorf("missing file path")
+		}
+		return b.String(), input[i+1:], nil
+	}
+	return "", "", fmt.Errorf("unterminated quoted path")
+}
+
+// ExpandPath expands a leading ~ to the home directory and $VAR or ${VAR}
+// to the variable's value. Unset variables (and ~ when the home directory is
+// unknown) are left as written, so a literal '$' in a file name survives.
+func ExpandPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if !strings.Contains(path, "$") {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '$' {
+			b.WriteByte(path[i])
+			continue
+		}
+		name, size := envName(path[i+1:])
+		if value, ok := os.LookupEnv(name); ok && name != "" {
+			b.WriteString(value)
+			i += size
+			continue
+		}
+		b.WriteByte('$')
+	}
+	return b.String()
+}
+
+// envName reads the variable name after a '$', either {NAME} or a run of
+// letters, digits, and underscores. It returns the name and how many bytes
+// it took up, braces included.
+func envName(s string) (string, int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+	n := 0
+	for n < len(s) && (s[n] == '_' || s[n] >= 'a' && s[n] <= 'z' || s[n] >= 'A' && s[n] <= 'Z' || n > 0 && s[n] >= '0' && s[n] <= '9') {
+		n++
+	}
+	return s[:n], n
+}
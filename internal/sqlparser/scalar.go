@@ -0,0 +1,348 @@
+package sqlparser
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+// Scalar is a value computed for each row, in SELECT or in a WHERE
+// comparison: a column, a literal, arithmetic, or a math function
+type Scalar interface {
+	isScalar()
+}
+
+// ColumnRef is the value of a column
+type ColumnRef struct {
+	Name string
+}
+
+// Literal is a constant; Number is set when the text is a plain number
+type Literal struct {
+	Value    string
+	Number   float64
+	IsNumber bool
+}
+
+// Arithmetic is Left Operator Right, with Operator one of + - * / %.
+// Division is always exact (10 / 4 is 2.5).
+type Arithmetic struct {
+	Left     Scalar
+	Operator string
+	Right    Scalar
+}
+
+// FuncCall applies a math function (see Functions) to its arguments
+type FuncCall struct {
+	Name string // Upper case
+	Args []Scalar
+}
+
+func (ColumnRef) isScalar()  {}
+func (Literal) isScalar()    {}
+func (Arithmetic) isScalar() {}
+func (FuncCall) isScalar()   {}
+
+// newLiteral returns the literal for text, reading it as a number if it is one
+func newLiteral(text string) Literal {
+	n, err := strconv.ParseFloat(text, 64)
+	return Literal{Value: text, Number: n, IsNumber: err == nil}
+}
+
+// Functions maps the scalar functions to their minimum and maximum number
+// of arguments
+var Functions = map[string][2]int{
+	"ABS":     {1, 1},
+	"CEIL":    {1, 1},
+	"CEILING": {1, 1},
+	"FLOOR":   {1, 1},
+	"ROUND":   {1, 2}, // ROUND(x, digits), half away from zero
+	"TRUNC":   {1, 2}, // TRUNC(x, digits), towards zero
+	"SIGN":    {1, 1},
+	"SQRT":    {1, 1},
+	"POWER":   {2, 2},
+	"POW":     {2, 2},
+	"MOD":     {2, 2},
+	"EXP":     {1, 1},
+	"LN":      {1, 1},
+	"LOG10":   {1, 1},
+}
+
+// Value is a Scalar's result for one row. Column values and string literals
+// are text, which arithmetic reads as numbers; arithmetic and functions
+// produce numbers. Null is the result of arithmetic on a value that isn't a
+// number, division by zero, or a function outside its domain (SQRT(-1)).
+type Value struct {
+	Text     string
+	Number   float64
+	IsNumber bool
+	Null     bool
+}
+
+// NumberValue returns n as a Value, or Null if n is infinite or NaN
+func NumberValue(n float64) Value {
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return Value{Null: true}
+	}
+	return Value{Number: n, IsNumber: true}
+}
+
+// Float reads v as a number written in format f
+func (v Value) Float(f datatype.NumberFormat) (float64, bool) {
+	switch {
+	case v.Null:
+		return 0, false
+	case v.IsNumber:
+		return v.Number, true
+	}
+	return f.ParseFloat(v.Text)
+}
+
+// String renders v for output: numbers in the shortest form that reads back
+// exactly, without an exponent, and Null as an empty field
+func (v Value) String() string {
+	switch {
+	case v.Null:
+		return ""
+	case v.IsNumber:
+		return strconv.FormatFloat(v.Number, 'f', -1, 64)
+	}
+	return v.Text
+}
+
+// EvalScalar computes s for a row whose columns are read by column, which
+// reports false for a missing column (whose value is Null). Numbers in the
+// row are read in format f.
+func EvalScalar(s Scalar, column func(name string) (string, bool), f datatype.NumberFormat) Value {
+	switch e := s.(type) {
+	case ColumnRef:
+		text, ok := column(e.Name)
+		if !ok {
+			return Value{Null: true}
+		}
+		return Value{Text: text}
+	case Literal:
+		return LiteralValue(e)
+	case Arithmetic:
+		return ApplyArithmetic(e.Operator, EvalScalar(e.Left, column, f), EvalScalar(e.Right, column, f), f)
+	case FuncCall:
+		var buf [2]Value
+		args := buf[:0]
+		for _, arg := range e.Args {
+			args = append(args, EvalScalar(arg, column, f))
+		}
+		return ApplyFunction(e.Name, args, f)
+	}
+	return Value{Null: true}
+}
+
+// LiteralValue is the Value of a literal
+func LiteralValue(l Literal) Value {
+	if l.IsNumber {
+		return Value{Text: l.Value, Number: l.Number, IsNumber: true}
+	}
+	return Value{Text: l.Value}
+}
+
+// ApplyArithmetic computes l op r
+func ApplyArithmetic(op string, l, r Value, f datatype.NumberFormat) Value {
+	a, aok := l.Float(f)
+	b, bok := r.Float(f)
+	if !aok || !bok {
+		return Value{Null: true}
+	}
+	switch op {
+	case "+":
+		return NumberValue(a + b)
+	case "-":
+		return NumberValue(a - b)
+	case "*":
+		return NumberValue(a * b)
+	case "/":
+		return NumberValue(a / b)
+	case "%":
+		return NumberValue(math.Mod(a, b))
+	}
+	return Value{Null: true}
+}
+
+// ApplyFunction computes the scalar function name on args, whose count the
+// parser has checked against Functions
+func ApplyFunction(name string, args []Value, f datatype.NumberFormat) Value {
+	var x [2]float64
+	for i, arg := range args {
+		n, ok := arg.Float(f)
+		if !ok {
+			return Value{Null: true}
+		}
+		x[i] = n
+	}
+	digits := 0
+	if len(args) == 2 {
+		digits = int(x[1])
+	}
+	switch name {
+	case "ABS":
+		return NumberValue(math.Abs(x[0]))
+	case "CEIL", "CEILING":
+		return NumberValue(math.Ceil(x[0]))
+	case "FLOOR":
+		return NumberValue(math.Floor(x[0]))
+	case "ROUND":
+		return NumberValue(roundDigits(x[0], digits, true))
+	case "TRUNC":
+		return NumberValue(roundDigits(x[0], digits, false))
+	case "SIGN":
+		switch {
+		case x[0] > 0:
+			return NumberValue(1)
+		case x[0] < 0:
+			return NumberValue(-1)
+		}
+		return NumberValue(0)
+	case "SQRT":
+		return NumberValue(math.Sqrt(x[0]))
+	case "POWER", "POW":
+		return NumberValue(math.Pow(x[0], x[1]))
+	case "MOD":
+		return NumberValue(math.Mod(x[0], x[1]))
+	case "EXP":
+		return NumberValue(math.Exp(x[0]))
+	case "LN":
+		return NumberValue(math.Log(x[0]))
+	case "LOG10":
+		return NumberValue(math.Log10(x[0]))
+	}
+	return Value{Null: true}
+}
+
+// roundDigits rounds x to the given number of decimal places (tens,
+// hundreds, ... when negative), half away from zero or, without half,
+// towards zero. It works on x's shortest decimal form, so ROUND(2.675, 2)
+// is 2.68 as written rather than 2.67 from its binary value.
+func roundDigits(x float64, digits int, half bool) float64 {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return x
+	}
+	text := strconv.FormatFloat(math.Abs(x), 'f', -1, 64)
+	whole, frac, _ := strings.Cut(text, ".")
+	all := whole + frac
+	cut := len(whole) + digits // Leading digits of all to keep
+	switch {
+	case cut >= len(all):
+		return x
+	case cut < 0:
+		return 0
+	}
+
+	// The last kept digit is worth 10^-digits
+	kept := []byte(all[:cut])
+	if half && all[cut] >= '5' {
+		kept = incrementDigits(kept)
+	}
+	value, _ := strconv.ParseFloat(string(kept)+"e"+strconv.Itoa(-digits), 64)
+	if value == 0 {
+		return 0
+	}
+	return math.Copysign(value, x)
+}
+
+// incrementDigits adds one to a string of decimal digits, growing it on a
+// carry out of the first digit
+func incrementDigits(digits []byte) []byte {
+	for i := len(digits) - 1; i >= 0; i-- {
+		if digits[i] < '9' {
+			digits[i]++
+			return digits
+		}
+		digits[i] = '0'
+	}
+	return append([]byte{'1'}, digits...)
+}
+
+// ScalarColumns returns the columns s reads, in order of appearance
+func ScalarColumns(s Scalar) []string {
+	var names []string
+	var walk func(Scalar)
+	walk = func(s Scalar) {
+		switch e := s.(type) {
+		case ColumnRef:
+			names = append(names, e.Name)
+		case Arithmetic:
+			walk(e.Left)
+			walk(e.Right)
+		case FuncCall:
+			for _, arg := range e.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(s)
+	return names
+}
+
+// ExpressionColumns returns the columns e reads, in order of appearance
+func ExpressionColumns(e Expression) []string {
+	if e == nil {
+		return nil
+	}
+	return VisitExpression[[]string](e, columnLister{})
+}
+
+// columnLister lists the columns an expression reads
+type columnLister struct{}
+
+func (columnLister) Binary(e BinaryExpr) []string {
+	return append(ExpressionColumns(e.Left), ExpressionColumns(e.Right)...)
+}
+
+func (columnLister) Unary(e UnaryExpr) []string { return ExpressionColumns(e.Expr) }
+
+func (columnLister) Comparison(e Comparison) []string { return []string{e.Column} }
+
+func (columnLister) ColumnComparison(e ColumnComparison) []string {
+	return []string{e.Left, e.Right}
+}
+
+func (columnLister) ExprComparison(e ExprComparison) []string {
+	return append(ScalarColumns(e.Left), ScalarColumns(e.Right)...)
+}
+
+func (columnLister) In(e InExpr) []string { return []string{e.Column} }
+
+// ExprComparison compares computed values, as in WHERE price_minor *
+// quantity > 10000 or ROUND(score) = 3. Values that both read as numbers
+// compare numerically; others compare like a ColumnComparison without a
+// declared type. A Null side never matches.
+type ExprComparison struct {
+	Left     Scalar
+	Operator string // "=", "!=", ">", ">=", "<", "<="
+	Right    Scalar
+	// Numbers reads numbers in the row and in string literals; see
+	// WithNumberFormat
+	Numbers datatype.NumberFormat
+	// CaseInsensitive compares string values with Unicode case folding
+	// (set by =~ or FoldCase)
+	CaseInsensitive bool
+}
+
+func (ExprComparison) isExpression() {}
+
+// Compare evaluates the comparison on the two computed values
+func (c ExprComparison) Compare(left, right Value) bool {
+	if left.Null || right.Null {
+		return false
+	}
+	if !left.IsNumber && !right.IsNumber {
+		text := ColumnComparison{Operator: c.Operator, Numbers: c.Numbers, CaseInsensitive: c.CaseInsensitive}
+		return text.Compare(left.Text, right.Text)
+	}
+	l, lok := left.Float(c.Numbers)
+	r, rok := right.Float(c.Numbers)
+	if lok && rok {
+		return compareOrdered(c.Operator, l, r)
+	}
+	return orderMatches(c.Operator, strings.Compare(left.String(), right.String()))
+}
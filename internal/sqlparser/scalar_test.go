@@ -0,0 +1,57 @@
+package sqlparser
+
+import (
+	"testing"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+func TestApplyFunction(t *testing.T) {
+	num := func(n float64) Value { return NumberValue(n) }
+	for _, tt := range []struct {
+		name string
+		args []Value
+		want string
+	}{
+		{"ROUND", []Value{num(2.5)}, "3"},
+		{"ROUND", []Value{num(-2.5)}, "-3"},
+		{"ROUND", []Value{num(2.675), num(2)}, "2.68"}, // As written, not as stored
+		{"ROUND", []Value{num(1.005), num(2)}, "1.01"},
+		{"ROUND", []Value{num(12345), num(-2)}, "12300"},
+		{"ROUND", []Value{num(99.96), num(1)}, "100"},
+		{"ROUND", []Value{num(-0.4)}, "0"},
+		{"ROUND", []Value{{Text: "123.456"}, num(1)}, "123.5"},
+		{"TRUNC", []Value{num(-7.89), num(1)}, "-7.8"},
+		{"CEIL", []Value{num(-1.5)}, "-1"},
+		{"FLOOR", []Value{num(-1.5)}, "-2"},
+		{"ABS", []Value{{Text: "-4"}}, "4"},
+		{"SIGN", []Value{num(-0.1)}, "-1"},
+		{"POWER", []Value{num(2), num(10)}, "1024"},
+		{"MOD", []Value{num(7), num(3)}, "1"},
+		{"SQRT", []Value{num(-1)}, ""}, // Outside the domain
+		{"LN", []Value{num(0)}, ""},
+		{"ABS", []Value{{Text: "n/a"}}, ""},
+	} {
+		if got := ApplyFunction(tt.name, tt.args, datatype.NumberFormat{}).String(); got != tt.want {
+			t.Errorf("%s(%v) = %q, want %q", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestApplyArithmetic(t *testing.T) {
+	f := datatype.NumberFormat{}
+	if got := ApplyArithmetic("/", Value{Text: "10"}, NumberValue(4), f).String(); got != "2.5" {
+		t.Errorf("10 / 4 = %q", got)
+	}
+	if got := ApplyArithmetic("/", NumberValue(1), NumberValue(0), f); !got.Null {
+		t.Errorf("1 / 0 = %+v, want null", got)
+	}
+	if got := ApplyArithmetic("+", Value{Text: "x"}, NumberValue(1), f); !got.Null {
+		t.Errorf("x + 1 = %+v, want null", got)
+	}
+	// Numbers in the row follow the input's format
+	comma := datatype.NumberFormat{Thousands: '.', Decimal: ','}
+	if got := ApplyArithmetic("*", Value{Text: "1.234,5"}, NumberValue(2), comma).String(); got != "2469" {
+		t.Errorf("1.234,5 * 2 = %q", got)
+	}
+}
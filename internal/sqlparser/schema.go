@@ -0,0 +1,280 @@
+package sqlparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+// Declared column types, as written by CAST(col AS type) or --schema
+const (
+	TypeString    = "string"
+	TypeInt       = "int"
+	TypeFloat     = "float"
+	TypeDecimal   = "decimal"
+	TypeTimestamp = "timestamp"
+	TypeBoolean   = "boolean"
+)
+
+// ParseType normalizes a type name from CAST or a schema entry. Common SQL
+// spellings are accepted as aliases.
+func ParseType(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "string", "text", "varchar", "char":
+		return TypeString, nil
+	case "int", "integer", "bigint":
+		return TypeInt, nil
+	case "float", "double", "real":
+		return TypeFloat, nil
+	case "decimal", "numeric", "money":
+		return TypeDecimal, nil
+	case "timestamp", "datetime", "date":
+		return TypeTimestamp, nil
+	case "boolean", "bool":
+		return TypeBoolean, nil
+	}
+	return "", fmt.Errorf("unknown type %q (want string, int, float, decimal, timestamp, or boolean)", name)
+}
+
+// ParseSchema parses a comma-separated list of column:type pairs, such as
+// "country:string,total_minor:int". Column names are matched case-insensitively.
+func ParseSchema(spec string) (map[string]string, error) {
+	schema := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		column, typeName, ok := strings.Cut(entry, ":")
+		column = strings.TrimSpace(column)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("schema entry %q: expected column:type", entry)
+		}
+		t, err := ParseType(typeName)
+		if err != nil {
+			return nil, fmt.Errorf("schema entry %q: %w", entry, err)
+		}
+		schema[strings.ToLower(column)] = t
+	}
+	return schema, nil
+}
+
+// WithSchema returns a copy of expr in which comparisons on the schema's
+// columns use the declared types. Comparisons with an explicit CAST keep it.
+func WithSchema(expr Expression, schema map[string]string) (Expression, error) {
+	return rewrite(expr, schemaTyper(schema))
+}
+
+// schemaTyper gives the comparisons of an expression their columns' declared
+// types, keyed by lowercased column name
+type schemaTyper map[string]string
+
+func (s schemaTyper) Binary(e BinaryExpr) rewritten { return rewriteBinary(e, s) }
+func (s schemaTyper) Unary(e UnaryExpr) rewritten   { return rewriteUnary(e, s) }
+
+func (s schemaTyper) Comparison(e Comparison) rewritten {
+	t, ok := s[strings.ToLower(strings.TrimSpace(e.Column))]
+	if !ok || e.Type != "" {
+		return rewritten{expr: e}
+	}
+	typed, err := e.withType(t)
+	if err != nil {
+		return rewritten{err: err}
+	}
+	return rewritten{expr: typed}
+}
+
+func (s schemaTyper) ColumnComparison(e ColumnComparison) rewritten {
+	if e.Type != "" {
+		return rewritten{expr: e}
+	}
+	left, lok := s[strings.ToLower(strings.TrimSpace(e.Left))]
+	right, rok := s[strings.ToLower(strings.TrimSpace(e.Right))]
+	t := left
+	switch {
+	case lok && rok:
+		var ok bool
+		if t, ok = commonType(left, right); !ok {
+			return rewritten{err: fmt.Errorf("%s is %s but %s is %s; compare them with CAST", e.Left, left, e.Right, right)}
+		}
+	case rok:
+		t = right
+	case !lok:
+		return rewritten{expr: e}
+	}
+	typed, err := e.withType(t)
+	if err != nil {
+		return rewritten{err: err}
+	}
+	return rewritten{expr: typed}
+}
+
+// ExprComparison and In don't take declared types: computed values and IN
+// lists compare by what their values read as
+func (schemaTyper) ExprComparison(e ExprComparison) rewritten { return rewritten{expr: e} }
+func (schemaTyper) In(e InExpr) rewritten                     { return rewritten{expr: e} }
+
+// commonType is the type two declared columns compare as: their own when
+// they agree, and decimal or float for a mix of numeric types
+func commonType(a, b string) (string, bool) {
+	numeric := func(t string) bool { return t == TypeInt || t == TypeFloat || t == TypeDecimal }
+	switch {
+	case a == b:
+		return a, true
+	case !numeric(a) || !numeric(b):
+		return "", false
+	case a == TypeDecimal || b == TypeDecimal:
+		return TypeDecimal, true
+	}
+	return TypeFloat, true
+}
+
+// withType returns c comparing both columns as the declared type t
+func (c ColumnComparison) withType(t string) (ColumnComparison, error) {
+	if t == TypeBoolean && c.Operator != "=" && c.Operator != "!=" {
+		return ColumnComparison{}, fmt.Errorf("%s is %s; only = and != compare booleans", c.Left, t)
+	}
+	c.Type = t
+	return c, nil
+}
+
+// withType returns c comparing as the declared type t. The literal must be
+// readable as that type.
+func (c Comparison) withType(t string) (Comparison, error) {
+	c.Type = t
+	c.IsNumeric, c.NumericValue = false, 0
+	c.IsTimestamp, c.TimeValue = false, 0
+	c.IsDecimal, c.DecimalValue = false, datatype.Decimal{}
+	c.IsBool, c.BoolValue = false, false
+	if c.Operator == "ILIKE" && t != TypeString {
+		return Comparison{}, fmt.Errorf("ILIKE on %s needs a string column, not %s", c.Column, t)
+	}
+
+	switch t {
+	case TypeInt, TypeFloat:
+		normalized, ok := c.literalNumber()
+		if !ok {
+			return Comparison{}, fmt.Errorf("%s is %s but %q is not a number", c.Column, t, c.Value)
+		}
+		c.Value = normalized
+		c.IsNumeric = true
+		c.NumericValue, _ = strconv.ParseFloat(normalized, 64)
+	case TypeDecimal:
+		normalized, ok := c.literalNumber()
+		d, dok := datatype.ParseDecimal(normalized)
+		if !ok || !dok {
+			return Comparison{}, fmt.Errorf("%s is %s but %q is not a plain decimal", c.Column, t, c.Value)
+		}
+		c.Value = normalized
+		c.IsDecimal = true
+		c.DecimalValue = d
+	case TypeTimestamp:
+		ts, ok := datatype.ParseTimestamp(c.Value)
+		if !ok {
+			return Comparison{}, fmt.Errorf("%s is %s but %q is not a date or date-time", c.Column, t, c.Value)
+		}
+		c.IsTimestamp = true
+		c.TimeValue = ts
+	case TypeBoolean:
+		b, ok := datatype.ParseBool(c.Value)
+		if !ok {
+			return Comparison{}, fmt.Errorf("%s is %s but %q is not true/false, yes/no, or 1/0", c.Column, t, c.Value)
+		}
+		if c.Operator != "=" && c.Operator != "!=" {
+			return Comparison{}, fmt.Errorf("%s is %s; only = and != compare booleans", c.Column, t)
+		}
+		c.IsBool = true
+		c.BoolValue = b
+	}
+	return c, nil
+}
+
+// inferType sets how c compares from its literal alone: numbers numerically,
+// dates and date-times chronologically, anything else as a string
+func (c *Comparison) inferType() {
+	if normalized, ok := c.literalNumber(); ok {
+		c.Value = normalized
+		c.IsNumeric = true
+		c.NumericValue, _ = strconv.ParseFloat(normalized, 64)
+	} else if ts, ok := datatype.ParseTimestamp(c.Value); ok {
+		c.IsTimestamp = true
+		c.TimeValue = ts
+	}
+}
+
+// literalNumber reads the literal as a number written in c.Numbers or, failing
+// that, in plain SQL syntax. The text is returned normalized, which is how the
+// index reads it.
+func (c Comparison) literalNumber() (string, bool) {
+	if normalized, ok := c.Numbers.Normalize(c.Value); ok {
+		if _, err := strconv.ParseFloat(normalized, 64); err == nil {
+			return normalized, true
+		}
+	}
+	if _, err := strconv.ParseFloat(c.Value, 64); err == nil {
+		return c.Value, true
+	}
+	return "", false
+}
+
+// Retyped reports whether a declared type makes c compare differently than
+// its literal alone would, e.g. user_id = 007 declared as a string. Decimal
+// comparisons always are: float bounds can't decide exact ones. So are
+// boolean ones, which only boolean column stats can decide.
+func (c Comparison) Retyped() bool {
+	if c.Type == "" || c.Operator == "ILIKE" {
+		return false
+	}
+	inferred := Comparison{Value: c.Value}
+	inferred.inferType()
+	return inferred.IsNumeric != c.IsNumeric || inferred.IsTimestamp != c.IsTimestamp || c.IsDecimal || c.IsBool
+}
+
+// WithNumberFormat returns a copy of expr whose comparisons read numbers in
+// format f, in the data and in literals: with a ',' thousands separator,
+// amount > '1,000' compares numerically and matches "1,234.56".
+func WithNumberFormat(expr Expression, f datatype.NumberFormat) (Expression, error) {
+	return rewrite(expr, numberFormatter{f})
+}
+
+// numberFormatter sets the number format of every comparison of an expression
+type numberFormatter struct {
+	format datatype.NumberFormat
+}
+
+func (n numberFormatter) Binary(e BinaryExpr) rewritten { return rewriteBinary(e, n) }
+func (n numberFormatter) Unary(e UnaryExpr) rewritten   { return rewriteUnary(e, n) }
+
+func (n numberFormatter) Comparison(e Comparison) rewritten {
+	e.Numbers = n.format
+	if e.Type != "" {
+		typed, err := e.withType(e.Type)
+		if err != nil {
+			return rewritten{err: err}
+		}
+		return rewritten{expr: typed}
+	}
+	if e.Operator != "ILIKE" {
+		e.IsNumeric, e.NumericValue = false, 0
+		e.IsTimestamp, e.TimeValue = false, 0
+		e.inferType()
+	}
+	return rewritten{expr: e}
+}
+
+func (n numberFormatter) ColumnComparison(e ColumnComparison) rewritten {
+	e.Numbers = n.format
+	return rewritten{expr: e}
+}
+
+func (n numberFormatter) ExprComparison(e ExprComparison) rewritten {
+	e.Numbers = n.format
+	return rewritten{expr: e}
+}
+
+func (n numberFormatter) In(e InExpr) rewritten {
+	e.Numbers = n.format
+	return rewritten{expr: e}
+}
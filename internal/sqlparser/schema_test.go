@@ -0,0 +1,240 @@
+package sqlparser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+func TestParseSchema(t *testing.T) {
+	schema, err := ParseSchema("Country:string, total_minor:INT,created_at:timestamp,price:decimal")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"country":     TypeString,
+		"total_minor": TypeInt,
+		"created_at":  TypeTimestamp,
+		"price":       TypeDecimal,
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Errorf("got %v, want %v", schema, want)
+	}
+
+	for _, spec := range []string{"country", ":int", "country:blob"} {
+		if _, err := ParseSchema(spec); err == nil {
+			t.Errorf("ParseSchema(%q): expected error", spec)
+		}
+	}
+}
+
+func TestParseCast(t *testing.T) {
+	q, err := Parse("SELECT * FROM data.csv WHERE CAST(user_id AS VARCHAR) = 007 AND cast( total as int ) > '10'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	and := q.Where.(BinaryExpr)
+	id := and.Left.(Comparison)
+	if id.Column != "user_id" || id.Type != TypeString || id.IsNumeric || id.Value != "007" {
+		t.Errorf("unexpected string cast: %+v", id)
+	}
+	if !id.Compare("007") || id.Compare("7") {
+		t.Errorf("string cast should compare 007 as text")
+	}
+	total := and.Right.(Comparison)
+	if total.Type != TypeInt || !total.IsNumeric || total.NumericValue != 10 {
+		t.Errorf("unexpected int cast: %+v", total)
+	}
+
+	for _, where := range []string{
+		"CAST(total AS INT) = 'abc'",
+		"CAST(created AS TIMESTAMP) > 'soon'",
+		"CAST(name AS INT) ILIKE 'a%'",
+		"CAST(name AS BLOB) = 1",
+	} {
+		if _, err := Parse("SELECT * FROM data.csv WHERE " + where); err == nil {
+			t.Errorf("%s: expected error", where)
+		}
+	}
+}
+
+func TestWithSchema(t *testing.T) {
+	q, err := Parse("SELECT * FROM data.csv WHERE user_id = 007 OR NOT (CAST(total AS FLOAT) = 5 AND created_at >= 20240101)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	schema := map[string]string{"user_id": TypeString, "total": TypeString, "created_at": TypeTimestamp}
+	if _, err := WithSchema(q.Where, schema); err == nil {
+		t.Fatalf("expected error for non-date literal on a timestamp column")
+	}
+
+	delete(schema, "created_at")
+	expr, err := WithSchema(q.Where, schema)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	or := expr.(BinaryExpr)
+	id := or.Left.(Comparison)
+	if id.Type != TypeString || id.IsNumeric || !id.Retyped() {
+		t.Errorf("schema should make user_id a string: %+v", id)
+	}
+	and := or.Right.(UnaryExpr).Expr.(BinaryExpr)
+	if total := and.Left.(Comparison); total.Type != TypeFloat || !total.IsNumeric || total.Retyped() {
+		t.Errorf("CAST should win over the schema: %+v", total)
+	}
+	if created := and.Right.(Comparison); created.Type != "" || !created.IsNumeric {
+		t.Errorf("undeclared column should keep the inferred type: %+v", created)
+	}
+
+	row := map[string]string{"user_id": "7", "total": "1", "created_at": "1"}
+	if !EvaluateNormalized(q.Where, row) {
+		t.Errorf("inferred types: 7 should equal 007")
+	}
+	if EvaluateNormalized(expr, map[string]string{"user_id": "7", "total": "5", "created_at": "20240102"}) {
+		t.Errorf("declared string: 7 should not equal 007")
+	}
+	if !EvaluateNormalized(expr, map[string]string{"user_id": "007", "total": "5", "created_at": "20240102"}) {
+		t.Errorf("declared string: 007 should equal 007")
+	}
+}
+
+func TestDecimalComparison(t *testing.T) {
+	q, err := Parse("SELECT * FROM data.csv WHERE CAST(total AS DECIMAL) >= 9007199254740993")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := q.Where.(Comparison)
+	if !c.IsDecimal || c.IsNumeric || !c.Retyped() {
+		t.Fatalf("unexpected comparison: %+v", c)
+	}
+	// As floats both sides round to 9007199254740992
+	if c.Compare("9007199254740992") {
+		t.Errorf("9007199254740992 should be below the literal")
+	}
+	if !c.Compare("9007199254740993.00") || c.Compare("1e20") {
+		t.Errorf("unexpected decimal comparison results")
+	}
+
+	if _, err := Parse("SELECT * FROM data.csv WHERE CAST(total AS NUMERIC) = 1e3"); err == nil {
+		t.Errorf("expected error for an exponent literal on a decimal column")
+	}
+}
+
+func TestWithNumberFormat(t *testing.T) {
+	q, err := Parse("SELECT * FROM data.csv WHERE amount > '1.234,5' AND CAST(code AS STRING) = '1.000' AND CAST(total AS DECIMAL) = 0.10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eu := datatype.NumberFormat{Thousands: '.', Decimal: ','}
+	expr, err := WithNumberFormat(q.Where, eu)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	outer := expr.(BinaryExpr)
+	inner := outer.Left.(BinaryExpr)
+
+	amount := inner.Left.(Comparison)
+	if !amount.IsNumeric || amount.NumericValue != 1234.5 || amount.Value != "1234.5" || amount.Retyped() {
+		t.Errorf("unexpected amount comparison: %+v", amount)
+	}
+	if !amount.Compare("2.000") || amount.Compare("999,99") || amount.Compare("2,000.00") {
+		t.Errorf("amount should compare EU-formatted values")
+	}
+	if code := inner.Right.(Comparison); code.IsNumeric || code.Value != "1.000" {
+		t.Errorf("string comparison should keep its literal: %+v", code)
+	}
+	total := outer.Right.(Comparison)
+	if !total.IsDecimal || !total.Compare("0,1") || total.Compare("0.1") {
+		t.Errorf("unexpected decimal comparison: %+v", total)
+	}
+
+	// Literals are read in plain SQL syntax until a format is applied
+	if _, err := Parse("SELECT * FROM data.csv WHERE CAST(total AS DECIMAL) = '0,10'"); err == nil {
+		t.Errorf("expected error: '0,10' is not a plain decimal")
+	}
+}
+
+func TestBooleanPredicates(t *testing.T) {
+	q, err := Parse("SELECT * FROM data.csv WHERE active AND NOT (deleted) AND CAST(verified AS BOOL) != 'no'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	and := q.Where.(BinaryExpr)
+	inner := and.Left.(BinaryExpr)
+	active := inner.Left.(Comparison)
+	if active.Column != "active" || !active.IsBool || !active.BoolValue || active.Type != TypeBoolean {
+		t.Errorf("unexpected bare boolean: %+v", active)
+	}
+	if deleted := inner.Right.(UnaryExpr).Expr.(Comparison); deleted.Column != "deleted" || !deleted.IsBool {
+		t.Errorf("unexpected negated boolean: %+v", deleted)
+	}
+	if verified := and.Right.(Comparison); !verified.IsBool || verified.BoolValue || verified.Operator != "!=" {
+		t.Errorf("unexpected boolean cast: %+v", verified)
+	}
+
+	rows := []struct {
+		row  map[string]string
+		want bool
+	}{
+		{map[string]string{"active": "true", "deleted": "false", "verified": "yes"}, true},
+		{map[string]string{"active": "YES", "deleted": "0", "verified": "1"}, true},
+		{map[string]string{"active": "1", "deleted": "", "verified": "True"}, true},
+		{map[string]string{"active": "false", "deleted": "no", "verified": "yes"}, false},
+		{map[string]string{"active": "maybe", "deleted": "no", "verified": "yes"}, false},
+		{map[string]string{"active": "true", "deleted": "true", "verified": "yes"}, false},
+		{map[string]string{"active": "true", "deleted": "no", "verified": "No"}, false},
+		{map[string]string{"active": "true", "deleted": "no", "verified": ""}, false},
+	}
+	for _, tt := range rows {
+		if got := EvaluateNormalized(q.Where, tt.row); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.row, got, tt.want)
+		}
+	}
+
+	for _, where := range []string{
+		"CAST(active AS BOOLEAN) = 'maybe'",
+		"CAST(active AS BOOLEAN) > 0",
+		"active total",
+	} {
+		if _, err := Parse("SELECT * FROM data.csv WHERE " + where); err == nil {
+			t.Errorf("%s: expected error", where)
+		}
+	}
+}
+
+func TestWithSchemaColumnComparison(t *testing.T) {
+	q, err := Parse("SELECT * FROM data.csv WHERE a < b AND c = d AND e > f AND x != y")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	schema := map[string]string{"a": TypeString, "c": TypeInt, "d": TypeDecimal, "f": TypeTimestamp}
+	expr, err := WithSchema(q.Where, schema)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	var walk func(Expression)
+	walk = func(e Expression) {
+		switch e := e.(type) {
+		case BinaryExpr:
+			walk(e.Left)
+			walk(e.Right)
+		case ColumnComparison:
+			got = append(got, e.Type)
+		}
+	}
+	walk(expr)
+	if strings.Join(got, ",") != "string,decimal,timestamp," {
+		t.Errorf("types = %q", got)
+	}
+	if a := expr.(BinaryExpr).Left.(BinaryExpr).Left.(BinaryExpr).Left.(ColumnComparison); a.Compare("9", "10") {
+		t.Errorf("declared string: 9 should sort after 10")
+	}
+
+	schema["b"] = TypeInt
+	if _, err := WithSchema(q.Where, schema); err == nil {
+		t.Errorf("expected error comparing a string column with an int column")
+	}
+}
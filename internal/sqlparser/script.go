@@ -0,0 +1,51 @@
+package sqlparser
+
+import "strings"
+
+// SplitStatements splits a script into semicolon-separated statements.
+// Semicolons inside quoted strings, identifiers, and file names are kept
+// (quotes escaped by doubling or with a backslash don't end them), as are
+// semicolons and quotes inside -- and /* */ comments. Statements that are
+// empty or only comments are dropped.
+func SplitStatements(script string) []string {
+	var statements []string
+	var quote byte
+	start := 0
+	content := false // Whether the current statement has more than comments
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && quote != ']' && i+1 < len(script) && isEscapable(script[i+1]) {
+				i++ // Escaped character, as in 'O\'Brien'
+			} else if c == quote {
+				quote = 0
+			}
+		case strings.HasPrefix(script[i:], "--") || strings.HasPrefix(script[i:], "/*"):
+			next, ok := skipSpace(script, i)
+			if !ok {
+				next = len(script) // Unterminated; the parser reports it
+				content = true
+			}
+			i = next - 1
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			content = true
+		case c == '[':
+			quote = ']'
+			content = true
+		case c == ';':
+			if stmt := strings.TrimSpace(script[start:i]); content {
+				statements = append(statements, stmt)
+			}
+			start = i + 1
+			content = false
+		case !isSpace(c):
+			content = true
+		}
+	}
+	if stmt := strings.TrimSpace(script[start:]); content {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
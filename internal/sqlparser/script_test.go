@@ -0,0 +1,44 @@
+package sqlparser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `
+SELECT * FROM a.csv WHERE name = 'x;y';
+SELECT * FROM a.csv WHERE name = 'O''Brien;' OR name = 'O\'Neil;';
+SELECT id FROM "b;c.csv"
+;;
+  SELECT COUNT(*) FROM d.csv  `
+	want := []string{
+		"SELECT * FROM a.csv WHERE name = 'x;y'",
+		`SELECT * FROM a.csv WHERE name = 'O''Brien;' OR name = 'O\'Neil;'`,
+		`SELECT id FROM "b;c.csv"`,
+		"SELECT COUNT(*) FROM d.csv",
+	}
+	if got := SplitStatements(script); !reflect.DeepEqual(got, want) {
+		t.Fatalf("SplitStatements() = %q, want %q", got, want)
+	}
+	if got := SplitStatements(" ; \n"); len(got) != 0 {
+		t.Fatalf("expected no statements, got %q", got)
+	}
+
+	script = `-- setup; nothing to run
+SELECT * FROM a.csv; -- don't split here; or here
+/* it's; a block */ SELECT * FROM b.csv WHERE [it's; odd] = 1;
+/* trailing */ -- comment only`
+	want = []string{
+		"-- setup; nothing to run\nSELECT * FROM a.csv",
+		"-- don't split here; or here\n/* it's; a block */ SELECT * FROM b.csv WHERE [it's; odd] = 1",
+	}
+	if got := SplitStatements(script); !reflect.DeepEqual(got, want) {
+		t.Fatalf("SplitStatements() = %q, want %q", got, want)
+	}
+	for _, stmt := range want {
+		if _, err := Parse(stmt); err != nil {
+			t.Errorf("%q: %v", stmt, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package sqlparser
+
+import "fmt"
+
+// ExpressionVisitor has one method per kind of Expression node, and
+// VisitExpression calls the one for a node's kind. A new kind of node adds
+// a method here, so every visitor stops compiling until it handles it
+// rather than silently skipping it.
+type ExpressionVisitor[T any] interface {
+	Binary(BinaryExpr) T
+	Unary(UnaryExpr) T
+	Comparison(Comparison) T
+	ColumnComparison(ColumnComparison) T
+	ExprComparison(ExprComparison) T
+	In(InExpr) T
+}
+
+// VisitExpression calls v's method for expr. A pointer to a node, as in
+// &BinaryExpr{...}, is visited as the node. It panics on nil and on a type
+// ExpressionVisitor has no method for.
+func VisitExpression[T any](expr Expression, v ExpressionVisitor[T]) T {
+	switch e := expr.(type) {
+	case BinaryExpr:
+		return v.Binary(e)
+	case *BinaryExpr:
+		return v.Binary(*e)
+	case UnaryExpr:
+		return v.Unary(e)
+	case *UnaryExpr:
+		return v.Unary(*e)
+	case Comparison:
+		return v.Comparison(e)
+	case *Comparison:
+		return v.Comparison(*e)
+	case ColumnComparison:
+		return v.ColumnComparison(e)
+	case *ColumnComparison:
+		return v.ColumnComparison(*e)
+	case ExprComparison:
+		return v.ExprComparison(e)
+	case *ExprComparison:
+		return v.ExprComparison(*e)
+	case InExpr:
+		return v.In(e)
+	case *InExpr:
+		return v.In(*e)
+	}
+	panic(fmt.Sprintf("sqlparser: no visitor method for expression %T", expr))
+}
+
+// Normalize returns expr with every pointer to a node replaced by the node,
+// the one form Parse builds and the engine reads. nil stays nil.
+func Normalize(expr Expression) Expression {
+	if expr == nil {
+		return nil
+	}
+	return VisitExpression[Expression](expr, normalizer{})
+}
+
+// normalizer rebuilds an expression from its nodes' values
+type normalizer struct{}
+
+func (normalizer) Binary(e BinaryExpr) Expression {
+	return BinaryExpr{Left: Normalize(e.Left), Operator: e.Operator, Right: Normalize(e.Right)}
+}
+
+func (normalizer) Unary(e UnaryExpr) Expression {
+	return UnaryExpr{Operator: e.Operator, Expr: Normalize(e.Expr)}
+}
+
+func (normalizer) Comparison(e Comparison) Expression             { return e }
+func (normalizer) ColumnComparison(e ColumnComparison) Expression { return e }
+func (normalizer) ExprComparison(e ExprComparison) Expression     { return e }
+func (normalizer) In(e InExpr) Expression                         { return e }
+
+// rewritten is an expression rebuilt by a rewriting visitor, or why it
+// couldn't be
+type rewritten struct {
+	expr Expression
+	err  error
+}
+
+// rewrite runs a rewriting visitor over expr. nil stays nil.
+func rewrite(expr Expression, v ExpressionVisitor[rewritten]) (Expression, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	r := VisitExpression(expr, v)
+	return r.expr, r.err
+}
+
+// rewriteBinary rebuilds e from its operands as rewritten by v
+func rewriteBinary(e BinaryExpr, v ExpressionVisitor[rewritten]) rewritten {
+	left, err := rewrite(e.Left, v)
+	if err != nil {
+		return rewritten{err: err}
+	}
+	right, err := rewrite(e.Right, v)
+	if err != nil {
+		return rewritten{err: err}
+	}
+	return rewritten{expr: BinaryExpr{Left: left, Operator: e.Operator, Right: right}}
+}
+
+// rewriteUnary rebuilds e from its operand as rewritten by v
+func rewriteUnary(e UnaryExpr, v ExpressionVisitor[rewritten]) rewritten {
+	inner, err := rewrite(e.Expr, v)
+	if err != nil {
+		return rewritten{err: err}
+	}
+	return rewritten{expr: UnaryExpr{Operator: e.Operator, Expr: inner}}
+}
@@ -0,0 +1,152 @@
+package sqlparser
+
+import (
+	"go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/melihbirim/sieswi/internal/datatype"
+)
+
+// namingVisitor names the method VisitExpression called
+type namingVisitor struct{}
+
+func (namingVisitor) Binary(BinaryExpr) string                 { return "BinaryExpr" }
+func (namingVisitor) Unary(UnaryExpr) string                   { return "UnaryExpr" }
+func (namingVisitor) Comparison(Comparison) string             { return "Comparison" }
+func (namingVisitor) ColumnComparison(ColumnComparison) string { return "ColumnComparison" }
+func (namingVisitor) ExprComparison(ExprComparison) string     { return "ExprComparison" }
+func (namingVisitor) In(InExpr) string                         { return "InExpr" }
+
+// TestVisitExpressionIsExhaustive checks that every type in the package
+// implementing Expression has its visitor method, as a value and a pointer
+func TestVisitExpressionIsExhaustive(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var declared []string
+	fset := gotoken.NewFileSet()
+	for _, name := range files {
+		f, err := goparser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || fn.Name.Name != "isExpression" {
+				continue
+			}
+			if ident, ok := fn.Recv.List[0].Type.(*ast.Ident); ok {
+				declared = append(declared, ident.Name)
+			}
+		}
+	}
+	sort.Strings(declared)
+
+	nodes := []Expression{BinaryExpr{}, UnaryExpr{}, Comparison{}, ColumnComparison{}, ExprComparison{}, InExpr{}}
+	var visited []string
+	for _, node := range nodes {
+		name := VisitExpression[string](node, namingVisitor{})
+		ptr := reflect.New(reflect.TypeOf(node))
+		ptr.Elem().Set(reflect.ValueOf(node))
+		if got := VisitExpression[string](ptr.Interface().(Expression), namingVisitor{}); got != name {
+			t.Errorf("pointer to %s visited as %s", name, got)
+		}
+		visited = append(visited, name)
+	}
+	sort.Strings(visited)
+	if !reflect.DeepEqual(declared, visited) {
+		t.Errorf("Expression types %v, visited %v: add the missing ones to ExpressionVisitor", declared, visited)
+	}
+}
+
+func TestNormalizeAndEvaluatePointers(t *testing.T) {
+	q, err := Parse("SELECT * FROM 't.csv' WHERE NOT (a = 1 OR b IN ('x', 'y')) AND c > 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	or := q.Where.(BinaryExpr).Left.(UnaryExpr).Expr.(BinaryExpr)
+	cmp := q.Where.(BinaryExpr).Right.(Comparison)
+	pointers := &BinaryExpr{
+		Left:     &UnaryExpr{Operator: "NOT", Expr: &or},
+		Operator: "AND",
+		Right:    &cmp,
+	}
+	if got := Normalize(pointers); !reflect.DeepEqual(got, q.Where) {
+		t.Errorf("Normalize = %#v, want %#v", got, q.Where)
+	}
+	if Normalize(nil) != nil {
+		t.Error("Normalize(nil) != nil")
+	}
+
+	rows := []map[string]string{
+		{"a": "2", "b": "z", "c": "3"},
+		{"a": "1", "b": "z", "c": "3"},
+		{"a": "2", "b": "y", "c": "3"},
+		{"a": "2", "b": "z", "c": "1"},
+		{"a": "2", "c": "3"},
+	}
+	for _, row := range rows {
+		want := Evaluate(q.Where, row)
+		if got := Evaluate(pointers, row); got != want {
+			t.Errorf("Evaluate(pointers, %v) = %v, want %v", row, got, want)
+		}
+		if got := EvaluateNormalized(pointers, row); got != want {
+			t.Errorf("EvaluateNormalized(pointers, %v) = %v, want %v", row, got, want)
+		}
+	}
+}
+
+func TestRewritersReadPointers(t *testing.T) {
+	q, err := Parse("SELECT * FROM 't.csv' WHERE NOT (a = 1 OR b IN (SELECT b FROM 'u.csv' WHERE c > 2)) AND a < b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	or := q.Where.(BinaryExpr).Left.(UnaryExpr).Expr.(BinaryExpr)
+	in := or.Right.(InExpr)
+	cols := q.Where.(BinaryExpr).Right.(ColumnComparison)
+	pointers := &BinaryExpr{
+		Left:     &UnaryExpr{Operator: "NOT", Expr: &BinaryExpr{Left: or.Left, Operator: "OR", Right: &in}},
+		Operator: "AND",
+		Right:    &cols,
+	}
+
+	if got, want := FoldCase(pointers), FoldCase(q.Where); !reflect.DeepEqual(got, want) {
+		t.Errorf("FoldCase = %#v, want %#v", got, want)
+	}
+	if got, want := ExpressionColumns(pointers), []string{"a", "b", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpressionColumns = %v, want %v", got, want)
+	}
+	if got := Subqueries(pointers); len(got) != 1 || got[0] != in.Subquery {
+		t.Errorf("Subqueries = %v, want [%p]", got, in.Subquery)
+	}
+	schema := map[string]string{"a": TypeInt, "b": TypeInt}
+	got, err := WithSchema(pointers, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := WithSchema(q.Where, schema); !reflect.DeepEqual(got, want) {
+		t.Errorf("WithSchema = %#v, want %#v", got, want)
+	}
+	f := datatype.NumberFormat{Thousands: ','}
+	got, err = WithNumberFormat(pointers, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := WithNumberFormat(q.Where, f); !reflect.DeepEqual(got, want) {
+		t.Errorf("WithNumberFormat = %#v, want %#v", got, want)
+	}
+	for _, rewritten := range []Expression{FoldCase(nil), Normalize(nil)} {
+		if rewritten != nil {
+			t.Errorf("rewriting nil = %#v", rewritten)
+		}
+	}
+	if ExpressionColumns(nil) != nil || Subqueries(nil) != nil {
+		t.Error("walking nil found something")
+	}
+}